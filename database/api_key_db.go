@@ -0,0 +1,275 @@
+package database
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// API key format: rak_<prefix>_<secret>. The prefix identifies the key (shown in the list),
+// only the sha256 of the whole key is stored
+const (
+	apiKeyScheme       = "rak"
+	apiKeyPrefixBytes  = 4
+	apiKeySecretBytes  = 32
+	apiKeyLastUsedStep = time.Minute // lastUsedAt is written at most once a minute per key
+)
+
+// apiKeyForbiddenPermissions are the administration permissions a key cannot grant:
+// users, roles, company and the keys themselves are managed by a human
+var apiKeyForbiddenPermissions = map[string]bool{
+	PermissionUserManage:    true,
+	PermissionRoleManage:    true,
+	PermissionCompanyManage: true,
+	PermissionStoreManage:   true,
+	PermissionAPIKeyManage:  true,
+}
+
+// APIKey lets an integration (accounting bridge, e-commerce sync...) call the API without a user login.
+// It acts as the user who created it, limited to its stores and permissions
+type APIKey struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	CompanyID   primitive.ObjectID   `bson:"companyId" json:"companyId"`
+	Name        string               `bson:"name" json:"name"`
+	Prefix      string               `bson:"prefix" json:"prefix"` // rak_xxxxxxxx, pour reconnaître la clé
+	KeyHash     string               `bson:"keyHash" json:"-"`     // sha256 de la clé complète
+	StoreIDs    []primitive.ObjectID `bson:"storeIds" json:"storeIds"`
+	Permissions []string             `bson:"permissions" json:"permissions"`
+	CreatedBy   primitive.ObjectID   `bson:"createdBy" json:"createdBy"`
+	ExpiresAt   *time.Time           `bson:"expiresAt,omitempty" json:"expiresAt,omitempty"`
+	LastUsedAt  *time.Time           `bson:"lastUsedAt,omitempty" json:"lastUsedAt,omitempty"`
+	RevokedAt   *time.Time           `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt   time.Time            `bson:"createdAt" json:"createdAt"`
+}
+
+// IsActive tells whether the key can still be used
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || k.ExpiresAt.After(now))
+}
+
+// generateAPIKey returns a new key and its prefix
+func generateAPIKey() (string, string, error) {
+	prefixBytes := make([]byte, apiKeyPrefixBytes)
+	secretBytes := make([]byte, apiKeySecretBytes)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", err
+	}
+	prefix := apiKeyScheme + "_" + hex.EncodeToString(prefixBytes)
+	return prefix + "_" + base64.RawURLEncoding.EncodeToString(secretBytes), prefix, nil
+}
+
+// apiKeyPrefix returns the prefix of a key, "" when the key is malformed
+func apiKeyPrefix(rawKey string) string {
+	parts := strings.SplitN(rawKey, "_", 3) // The secret may contain "_"
+	if len(parts) != 3 || parts[0] != apiKeyScheme || len(parts[1]) != apiKeyPrefixBytes*2 || parts[2] == "" {
+		return ""
+	}
+	return parts[0] + "_" + parts[1]
+}
+
+// hashAPIKey hashes a key: keys are random, a fast hash is enough
+func hashAPIKey(rawKey string) string {
+	sum := sha256.Sum256([]byte(rawKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeAPIKeyPermissions checks the permissions of a key against the catalogue
+// and refuses the administration permissions
+func normalizeAPIKeyPermissions(permissions []string) ([]string, error) {
+	normalized, err := normalizePermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+	for _, permission := range normalized {
+		if apiKeyForbiddenPermissions[permission] {
+			return nil, utils.ValidationErrorf("Permission %s cannot be granted to an API key", permission)
+		}
+	}
+	if len(normalized) == 0 {
+		return nil, utils.ValidationErrorf("An API key needs at least one permission")
+	}
+	return normalized, nil
+}
+
+// apiKeyStoreIDs returns the stores of a key the owner still has access to
+func apiKeyStoreIDs(keyStoreIDs []primitive.ObjectID, owner *User) []string {
+	ownerStores := make(map[primitive.ObjectID]bool)
+	if owner.Role == RoleAdmin {
+		for _, storeID := range owner.StoreIDs {
+			ownerStores[storeID] = true
+		}
+	} else if owner.AssignedStoreID != nil {
+		ownerStores[*owner.AssignedStoreID] = true
+	}
+
+	storeIDs := make([]string, 0, len(keyStoreIDs))
+	for _, storeID := range keyStoreIDs {
+		if ownerStores[storeID] {
+			storeIDs = append(storeIDs, storeID.Hex())
+		}
+	}
+	return storeIDs
+}
+
+// CreateAPIKey creates a key and returns it with the key itself, which is not stored and shown only once
+func (db *DB) CreateAPIKey(companyID, createdBy primitive.ObjectID, name string, storeIDs []primitive.ObjectID, permissions []string, expiresAt *time.Time) (*APIKey, string, error) {
+	normalized, err := normalizeAPIKeyPermissions(permissions)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(storeIDs) == 0 {
+		return nil, "", utils.ValidationErrorf("An API key needs at least one store")
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", utils.ValidationErrorf("Expiry date must be in the future")
+	}
+
+	rawKey, prefix, err := generateAPIKey()
+	if err != nil {
+		return nil, "", utils.DatabaseErrorf("generate_api_key", "Error generating API key: %v", err)
+	}
+
+	apiKey := APIKey{
+		ID:          primitive.NewObjectID(),
+		CompanyID:   companyID,
+		Name:        strings.TrimSpace(name),
+		Prefix:      prefix,
+		KeyHash:     hashAPIKey(rawKey),
+		StoreIDs:    storeIDs,
+		Permissions: normalized,
+		CreatedBy:   createdBy,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   time.Now(),
+	}
+
+	apiKeyCollection := colHelper(db, "api_keys")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err = apiKeyCollection.InsertOne(ctx, apiKey)
+	if err != nil {
+		return nil, "", utils.DatabaseErrorf("create_api_key", "Error creating API key: %v", err)
+	}
+
+	return &apiKey, rawKey, nil
+}
+
+// FindAPIKeysByCompanyID returns the keys of the company, most recent first (revoked ones included)
+func (db *DB) FindAPIKeysByCompanyID(companyID primitive.ObjectID) ([]*APIKey, error) {
+	apiKeyCollection := colHelper(db, "api_keys")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := apiKeyCollection.Find(ctx, bson.M{"companyId": companyID}, opts)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_api_keys", "Error finding API keys: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var apiKeys []*APIKey
+	if err = cursor.All(ctx, &apiKeys); err != nil {
+		return nil, utils.DatabaseErrorf("find_api_keys", "Error decoding API keys: %v", err)
+	}
+
+	return apiKeys, nil
+}
+
+// RevokeAPIKey revokes a key of the company; the next requests with it are refused
+func (db *DB) RevokeAPIKey(id string, companyID primitive.ObjectID) (*APIKey, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, utils.ValidationErrorf("Invalid API key ID")
+	}
+
+	apiKeyCollection := colHelper(db, "api_keys")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var apiKey APIKey
+	err = apiKeyCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": objectID, "companyId": companyID},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&apiKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("API key not found")
+		}
+		return nil, utils.DatabaseErrorf("revoke_api_key", "Error revoking API key: %v", err)
+	}
+
+	return &apiKey, nil
+}
+
+// AuthenticateAPIKey checks a key of the X-API-Key header and returns the claims of the request:
+// the user who created the key, limited to the stores and permissions of the key
+func (db *DB) AuthenticateAPIKey(rawKey string) (*utils.JwtCustomClaim, error) {
+	prefix := apiKeyPrefix(rawKey)
+	if prefix == "" {
+		return nil, utils.ValidationErrorf("Invalid API key")
+	}
+
+	apiKeyCollection := colHelper(db, "api_keys")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var apiKey APIKey
+	err := apiKeyCollection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&apiKey)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.ValidationErrorf("Invalid API key")
+		}
+		return nil, utils.DatabaseErrorf("find_api_key", "Error finding API key: %v", err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashAPIKey(rawKey)), []byte(apiKey.KeyHash)) != 1 {
+		return nil, utils.ValidationErrorf("Invalid API key")
+	}
+	now := time.Now()
+	if !apiKey.IsActive(now) {
+		return nil, utils.ValidationErrorf("API key has been revoked or has expired")
+	}
+
+	// The owner acts with their membership in the company of the key
+	user, err := db.FindUserInCompany(apiKey.CreatedBy.Hex(), apiKey.CompanyID)
+	if err != nil {
+		return nil, utils.ValidationErrorf("API key owner not found")
+	}
+	if user.IsBlocked {
+		return nil, utils.ValidationErrorf("API key owner is blocked")
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedStep {
+		_, err = apiKeyCollection.UpdateOne(ctx, bson.M{"_id": apiKey.ID}, bson.M{"$set": bson.M{"lastUsedAt": now}})
+		if err != nil {
+			utils.LogError(err, "Failed to update API key last use")
+		}
+	}
+
+	// The stores the owner lost access to since the creation are dropped
+	storeIDs := apiKeyStoreIDs(apiKey.StoreIDs, user)
+
+	return &utils.JwtCustomClaim{
+		ID:          user.ID.Hex(),
+		CompanyID:   apiKey.CompanyID.Hex(),
+		Role:        user.Role,
+		StoreIDs:    storeIDs,
+		APIKeyID:    apiKey.ID.Hex(),
+		Permissions: apiKey.Permissions,
+	}, nil
+}
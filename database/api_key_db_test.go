@@ -0,0 +1,55 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestGenerateAPIKey vérifie le format des clés et la lecture de leur préfixe
+func TestGenerateAPIKey(t *testing.T) {
+	rawKey, prefix, err := generateAPIKey()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rawKey, prefix+"_"))
+	assert.Equal(t, prefix, apiKeyPrefix(rawKey))
+	assert.Len(t, hashAPIKey(rawKey), 64)
+
+	otherKey, _, err := generateAPIKey()
+	assert.NoError(t, err)
+	assert.NotEqual(t, rawKey, otherKey)
+
+	assert.Equal(t, "rak_12345678", apiKeyPrefix("rak_12345678_se_cr-et"))
+	assert.Empty(t, apiKeyPrefix("Bearer abc"))
+	assert.Empty(t, apiKeyPrefix("rak_1234_"))
+	assert.Empty(t, apiKeyPrefix("xyz_12345678_secret"))
+}
+
+// TestNormalizeAPIKeyPermissions vérifie qu'une clé ne reçoit pas de permission d'administration
+func TestNormalizeAPIKeyPermissions(t *testing.T) {
+	permissions, err := normalizeAPIKeyPermissions([]string{PermissionSaleCreate, PermissionReportView, PermissionSaleCreate})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{PermissionReportView, PermissionSaleCreate}, permissions)
+
+	_, err = normalizeAPIKeyPermissions([]string{PermissionSaleCreate, PermissionUserManage})
+	assert.Error(t, err)
+	_, err = normalizeAPIKeyPermissions([]string{PermissionAPIKeyManage})
+	assert.Error(t, err)
+	_, err = normalizeAPIKeyPermissions([]string{})
+	assert.Error(t, err)
+}
+
+// TestAPIKeyStoreIDs vérifie qu'une clé perd les boutiques auxquelles son créateur n'a plus accès
+func TestAPIKeyStoreIDs(t *testing.T) {
+	storeA, storeB, storeC := primitive.NewObjectID(), primitive.NewObjectID(), primitive.NewObjectID()
+	keyStores := []primitive.ObjectID{storeA, storeB}
+
+	admin := &User{Role: RoleAdmin, StoreIDs: []primitive.ObjectID{storeA, storeC}}
+	assert.Equal(t, []string{storeA.Hex()}, apiKeyStoreIDs(keyStores, admin))
+
+	user := &User{Role: RoleUser, AssignedStoreID: &storeB}
+	assert.Equal(t, []string{storeB.Hex()}, apiKeyStoreIDs(keyStores, user))
+
+	assert.Empty(t, apiKeyStoreIDs(keyStores, &User{Role: RoleUser}))
+}
@@ -0,0 +1,376 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Audit actions recorded outside of the mutations (the mutations use their GraphQL name)
+const (
+	AuditActionLoginLockout = "login.lockout" // Trop d'échecs de connexion pour un numéro ou une IP
+)
+
+// auditGlobalChain is the chain of the entries without company (login, register...)
+const auditGlobalChain = "global"
+
+// auditAppendRetries bounds the retries of an append racing with another one on the same chain
+const auditAppendRetries = 5
+
+// auditQueueClaim is how long FlushAuditQueue keeps an entry of the queue for itself
+const auditQueueClaim = time.Minute
+
+// AuditEntry is an entry of the append-only audit log. The entries of a company form a hash chain:
+// each hash covers the entry and the hash of the previous one, so editing or removing an entry breaks the chain
+type AuditEntry struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	Chain     string              `bson:"chain" json:"chain"`       // companyId, ou "global"
+	Sequence  int64               `bson:"sequence" json:"sequence"` // Position dans la chaîne, à partir de 1
+	PrevHash  string              `bson:"prevHash" json:"prevHash"`
+	Hash      string              `bson:"hash" json:"hash"`
+	CompanyID *primitive.ObjectID `bson:"companyId,omitempty" json:"companyId,omitempty"`
+	StoreID   *primitive.ObjectID `bson:"storeId,omitempty" json:"storeId,omitempty"`
+	ActorID   *primitive.ObjectID `bson:"actorId,omitempty" json:"actorId,omitempty"` // null pour une action anonyme (login)
+	Action    string              `bson:"action" json:"action"`
+	TargetID  string              `bson:"targetId,omitempty" json:"targetId,omitempty"`
+	Details   string              `bson:"details,omitempty" json:"details,omitempty"` // JSON: arguments de la mutation (secrets masqués) ou détails de l'événement
+	Before    string              `bson:"before,omitempty" json:"before,omitempty"`   // JSON: document avant une modification ou une suppression
+	After     string              `bson:"after,omitempty" json:"after,omitempty"`     // JSON: document après une modification
+	Error     string              `bson:"error,omitempty" json:"error,omitempty"`     // Message si la mutation a échoué
+	IPAddress string              `bson:"ipAddress,omitempty" json:"ipAddress,omitempty"`
+	CreatedAt time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+// AuditLogFilter filters auditLog; Limit defaults to 100 (500 max)
+type AuditLogFilter struct {
+	StoreID   *primitive.ObjectID
+	ActorID   *primitive.ObjectID
+	Action    string
+	TargetID  string
+	StartDate *time.Time
+	EndDate   *time.Time
+	Limit     int
+}
+
+// AuditChainVerification is the result of VerifyAuditChain
+type AuditChainVerification struct {
+	Valid            bool
+	Checked          int
+	BrokenAtSequence *int64
+	Reason           string
+}
+
+// AuditJSON encodes the details of an audit entry
+func AuditJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		utils.LogError(err, "Failed to encode audit details")
+		return ""
+	}
+	return string(data)
+}
+
+func auditChain(companyID *primitive.ObjectID) string {
+	if companyID == nil || *companyID == primitive.NilObjectID {
+		return auditGlobalChain
+	}
+	return companyID.Hex()
+}
+
+func optionalObjectIDHex(id *primitive.ObjectID) string {
+	if id == nil {
+		return ""
+	}
+	return id.Hex()
+}
+
+// auditEntryHash hashes the previous hash and the fields of the entry, in a fixed order
+func auditEntryHash(entry *AuditEntry) string {
+	payload, _ := json.Marshal([]interface{}{
+		entry.Chain,
+		entry.Sequence,
+		entry.PrevHash,
+		optionalObjectIDHex(entry.CompanyID),
+		optionalObjectIDHex(entry.StoreID),
+		optionalObjectIDHex(entry.ActorID),
+		entry.Action,
+		entry.TargetID,
+		entry.Details,
+		entry.Before,
+		entry.After,
+		entry.Error,
+		entry.IPAddress,
+		entry.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+// checkAuditLink checks an entry against the previous one of its chain (nil for the first).
+// It returns the reason of the break, or "" when the entry is intact
+func checkAuditLink(prev, entry *AuditEntry) string {
+	expectedSequence, expectedPrevHash := int64(1), ""
+	if prev != nil {
+		expectedSequence, expectedPrevHash = prev.Sequence+1, prev.Hash
+	}
+	if entry.Sequence != expectedSequence {
+		return "missing entries before this sequence"
+	}
+	if entry.PrevHash != expectedPrevHash {
+		return "previous hash does not match"
+	}
+	if entry.Hash != auditEntryHash(entry) {
+		return "entry was modified"
+	}
+	return ""
+}
+
+// CreateAuditEntry appends an entry to the chain of its company. When the chain stays busy or the
+// append fails, the entry is queued in audit_queue and appended later by FlushAuditQueue: only an
+// error writing the queue is returned
+func (db *DB) CreateAuditEntry(entry AuditEntry) error {
+	entry.ID = primitive.NewObjectID()
+	entry.Chain = auditChain(entry.CompanyID)
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	// MongoDB stores milliseconds: hash what will be read back
+	entry.CreatedAt = entry.CreatedAt.UTC().Truncate(time.Millisecond)
+
+	err := db.appendAuditEntry(entry)
+	if err == nil {
+		return nil
+	}
+	utils.LogError(err, "Failed to append audit entry, queuing it")
+
+	ctx, cancel := GetDBContext()
+	defer cancel()
+	if _, err := colHelper(db, "audit_queue").InsertOne(ctx, entry); err != nil {
+		return utils.DatabaseErrorf("queue_audit_entry", "Error queuing audit entry: %v", err)
+	}
+	return nil
+}
+
+// appendAuditEntry appends the entry (with its ID) after the last entry of its chain
+func (db *DB) appendAuditEntry(entry AuditEntry) error {
+	auditCollection := colHelper(db, "audit_log")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	for attempt := 0; attempt < auditAppendRetries; attempt++ {
+		var last AuditEntry
+		err := auditCollection.FindOne(ctx,
+			bson.M{"chain": entry.Chain},
+			options.FindOne().SetSort(bson.M{"sequence": -1}),
+		).Decode(&last)
+		switch {
+		case err == mongo.ErrNoDocuments:
+			entry.Sequence, entry.PrevHash = 1, ""
+		case err != nil:
+			return utils.DatabaseErrorf("create_audit_entry", "Error finding last audit entry: %v", err)
+		default:
+			entry.Sequence, entry.PrevHash = last.Sequence+1, last.Hash
+		}
+
+		entry.Hash = auditEntryHash(&entry)
+
+		// Unique index chain + sequence: of two concurrent appends, the second one retries
+		_, err = auditCollection.InsertOne(ctx, entry)
+		if err == nil {
+			return nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return utils.DatabaseErrorf("create_audit_entry", "Error creating audit entry: %v", err)
+		}
+	}
+
+	return utils.DatabaseErrorf("create_audit_entry", "Error creating audit entry: chain %s is busy", entry.Chain)
+}
+
+// FlushAuditQueue appends the queued entries to their chain, oldest first. An entry stays queued
+// until it is appended (the next run retries it); it returns the number of entries appended
+func (db *DB) FlushAuditQueue() (int, error) {
+	queueCollection := colHelper(db, "audit_queue")
+	count := 0
+
+	for {
+		ctx, cancel := GetDBContext()
+		now := time.Now()
+		// Claim the oldest entry: another instance flushing at the same time takes the next one
+		var entry AuditEntry
+		err := queueCollection.FindOneAndUpdate(ctx,
+			bson.M{"$or": bson.A{
+				bson.M{"claimedUntil": bson.M{"$exists": false}},
+				bson.M{"claimedUntil": bson.M{"$lte": now}},
+			}},
+			bson.M{"$set": bson.M{"claimedUntil": now.Add(auditQueueClaim)}},
+			options.FindOneAndUpdate().SetSort(bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}}),
+		).Decode(&entry)
+		cancel()
+		if err == mongo.ErrNoDocuments {
+			return count, nil
+		}
+		if err != nil {
+			return count, utils.DatabaseErrorf("flush_audit_queue", "Error reading audit queue: %v", err)
+		}
+
+		// An entry appended by a run that stopped before removing it from the queue is not appended twice
+		ctx, cancel = GetDBContext()
+		appended, err := colHelper(db, "audit_log").CountDocuments(ctx, bson.M{"_id": entry.ID})
+		cancel()
+		if err != nil {
+			return count, utils.DatabaseErrorf("flush_audit_queue", "Error finding audit entry: %v", err)
+		}
+		if appended == 0 {
+			if err := db.appendAuditEntry(entry); err != nil {
+				return count, err
+			}
+			count++
+		}
+
+		ctx, cancel = GetDBContext()
+		_, err = queueCollection.DeleteOne(ctx, bson.M{"_id": entry.ID})
+		cancel()
+		if err != nil {
+			return count, utils.DatabaseErrorf("flush_audit_queue", "Error removing queued audit entry: %v", err)
+		}
+	}
+}
+
+// FindAuditEntries returns the entries of a company, most recent first
+func (db *DB) FindAuditEntries(companyID primitive.ObjectID, filter AuditLogFilter) ([]*AuditEntry, error) {
+	auditCollection := colHelper(db, "audit_log")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	query := bson.M{"chain": companyID.Hex()}
+	if filter.StoreID != nil {
+		query["storeId"] = *filter.StoreID
+	}
+	if filter.ActorID != nil {
+		query["actorId"] = *filter.ActorID
+	}
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+	if filter.TargetID != "" {
+		query["targetId"] = filter.TargetID
+	}
+	if filter.StartDate != nil || filter.EndDate != nil {
+		dateQuery := bson.M{}
+		if filter.StartDate != nil {
+			dateQuery["$gte"] = *filter.StartDate
+		}
+		if filter.EndDate != nil {
+			dateQuery["$lte"] = *filter.EndDate
+		}
+		query["createdAt"] = dateQuery
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	if limit > 500 {
+		limit = 500
+	}
+
+	cursor, err := auditCollection.Find(ctx, query, options.Find().SetSort(bson.M{"sequence": -1}).SetLimit(int64(limit)))
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_audit_entries", "Error finding audit entries: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var entries []*AuditEntry
+	if err = cursor.All(ctx, &entries); err != nil {
+		return nil, utils.DatabaseErrorf("find_audit_entries", "Error decoding audit entries: %v", err)
+	}
+
+	return entries, nil
+}
+
+// VerifyAuditChain walks the chain of a company and reports the first broken entry
+func (db *DB) VerifyAuditChain(companyID primitive.ObjectID) (*AuditChainVerification, error) {
+	auditCollection := colHelper(db, "audit_log")
+	// Whole chain: not bound by the default query timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	cursor, err := auditCollection.Find(ctx, bson.M{"chain": companyID.Hex()}, options.Find().SetSort(bson.M{"sequence": 1}))
+	if err != nil {
+		return nil, utils.DatabaseErrorf("verify_audit_chain", "Error reading audit chain: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	result := &AuditChainVerification{Valid: true}
+	var prev *AuditEntry
+	for cursor.Next(ctx) {
+		var entry AuditEntry
+		if err := cursor.Decode(&entry); err != nil {
+			return nil, utils.DatabaseErrorf("verify_audit_chain", "Error decoding audit entry: %v", err)
+		}
+		if reason := checkAuditLink(prev, &entry); reason != "" {
+			sequence := entry.Sequence
+			result.Valid = false
+			result.BrokenAtSequence = &sequence
+			result.Reason = reason
+			return result, nil
+		}
+		result.Checked++
+		prev = &entry
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, utils.DatabaseErrorf("verify_audit_chain", "Error reading audit chain: %v", err)
+	}
+
+	return result, nil
+}
+
+// auditSnapshotSecrets are the fields left out of the snapshots (password, TOTP secret, API key and invitation code hashes)
+var auditSnapshotSecrets = map[string]bool{
+	"password":  true,
+	"twoFactor": true,
+	"keyHash":   true,
+	"codeHash":  true,
+}
+
+// FindAuditSnapshot returns a document as relaxed extended JSON for the before/after
+// snapshots of an audit entry ("" when it does not exist). Secrets are left out
+func (db *DB) FindAuditSnapshot(collection string, id primitive.ObjectID) (string, error) {
+	snapshotCollection := colHelper(db, collection)
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var document bson.D
+	err := snapshotCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&document)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return "", nil
+		}
+		return "", utils.DatabaseErrorf("find_audit_snapshot", "Error reading %s snapshot: %v", collection, err)
+	}
+
+	filtered := make(bson.D, 0, len(document))
+	for _, element := range document {
+		if auditSnapshotSecrets[element.Key] {
+			continue
+		}
+		filtered = append(filtered, element)
+	}
+
+	data, err := bson.MarshalExtJSON(filtered, false, false)
+	if err != nil {
+		return "", utils.DatabaseErrorf("find_audit_snapshot", "Error encoding %s snapshot: %v", collection, err)
+	}
+
+	return string(data), nil
+}
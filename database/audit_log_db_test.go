@@ -0,0 +1,52 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func chainedAuditEntry(prev *AuditEntry, action string) *AuditEntry {
+	companyID := primitive.NewObjectID()
+	entry := &AuditEntry{
+		ID:        primitive.NewObjectID(),
+		Chain:     companyID.Hex(),
+		Sequence:  1,
+		CompanyID: &companyID,
+		Action:    action,
+		Details:   `{"id":"42"}`,
+		CreatedAt: time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC),
+	}
+	if prev != nil {
+		entry.Chain, entry.CompanyID = prev.Chain, prev.CompanyID
+		entry.Sequence, entry.PrevHash = prev.Sequence+1, prev.Hash
+	}
+	entry.Hash = auditEntryHash(entry)
+	return entry
+}
+
+// TestCheckAuditLink vérifie qu'une modification, une suppression ou une réécriture d'entrée casse la chaîne
+func TestCheckAuditLink(t *testing.T) {
+	first := chainedAuditEntry(nil, "deleteCaisseTransaction")
+	second := chainedAuditEntry(first, "updateClientCreditLimit")
+	third := chainedAuditEntry(second, "updateFacture")
+
+	assert.Empty(t, checkAuditLink(nil, first))
+	assert.Empty(t, checkAuditLink(first, second))
+	assert.Empty(t, checkAuditLink(second, third))
+
+	// Modified field
+	tampered := *second
+	tampered.Details = `{"id":"43"}`
+	assert.Equal(t, "entry was modified", checkAuditLink(first, &tampered))
+
+	// Deleted entry
+	assert.Equal(t, "missing entries before this sequence", checkAuditLink(first, third))
+
+	// Rewritten entry with a recomputed hash: the next one no longer links
+	tampered.Hash = auditEntryHash(&tampered)
+	assert.Empty(t, checkAuditLink(first, &tampered))
+	assert.Equal(t, "previous hash does not match", checkAuditLink(&tampered, third))
+}
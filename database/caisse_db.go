@@ -1,8 +1,11 @@
 package database
 
 import (
+	"context"
 	"time"
 
+	"rangoapp/events"
+
 	"github.com/vektah/gqlparser/v2/gqlerror"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -16,11 +19,16 @@ type Trans struct {
 	Operation   string             `bson:"operation" json:"operation"` // "Entree" or "Sortie"
 	Description string             `bson:"description" json:"description"`
 	Currency    string             `bson:"currency" json:"currency"` // "USD" or "CDF"
-	OperatorID  primitive.ObjectID `bson:"operatorId" json:"operatorId"`
-	StoreID     primitive.ObjectID `bson:"storeId" json:"storeId"`
-	Date        time.Time          `bson:"date" json:"date"`
-	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
-	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+	// Mode de paiement (voir PaymentMethodLabels), vide pour les espèces; référence mobile money ou carte
+	PaymentMethod string              `bson:"paymentMethod,omitempty" json:"paymentMethod,omitempty"`
+	Reference     string              `bson:"reference,omitempty" json:"reference,omitempty"`
+	SaleID        *primitive.ObjectID `bson:"saleId,omitempty" json:"saleId,omitempty"`
+	Purchase      bool                `bson:"purchase,omitempty" json:"purchase,omitempty"` // Paiement de marchandises à un fournisseur (coût d'achat)
+	OperatorID    primitive.ObjectID  `bson:"operatorId" json:"operatorId"`
+	StoreID       primitive.ObjectID  `bson:"storeId" json:"storeId"`
+	Date          time.Time           `bson:"date" json:"date"`
+	CreatedAt     time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time           `bson:"updatedAt" json:"updatedAt"`
 }
 
 type Caisse struct {
@@ -41,36 +49,95 @@ type CaisseResumeJour struct {
 	NombreTransactions int       `bson:"nombreTransactions" json:"nombreTransactions"`
 }
 
+// CaissePaymentMethodTotal is the part of the caisse of a payment method (cash drawer, M-Pesa account...)
+type CaissePaymentMethodTotal struct {
+	Method             string  `bson:"method" json:"method"`
+	Entrees            float64 `bson:"entrees" json:"entrees"`
+	Sorties            float64 `bson:"sorties" json:"sorties"`
+	Solde              float64 `bson:"solde" json:"solde"`
+	NombreTransactions int     `bson:"nombreTransactions" json:"nombreTransactions"`
+}
+
 type CaisseRapport struct {
-	StoreID            *primitive.ObjectID `bson:"storeId,omitempty" json:"storeId,omitempty"`
-	Currency           string              `bson:"currency" json:"currency"`
-	Period             string              `bson:"period" json:"period"`
-	StartDate          time.Time           `bson:"startDate" json:"startDate"`
-	EndDate            time.Time           `bson:"endDate" json:"endDate"`
-	TotalEntrees       float64             `bson:"totalEntrees" json:"totalEntrees"`
-	TotalSorties       float64             `bson:"totalSorties" json:"totalSorties"`
-	TotalBenefice      float64             `bson:"totalBenefice" json:"totalBenefice"` // Total profit from sales
-	SoldeInitial       float64             `bson:"soldeInitial" json:"soldeInitial"`
-	SoldeFinal         float64             `bson:"soldeFinal" json:"soldeFinal"`
-	NombreTransactions int                 `bson:"nombreTransactions" json:"nombreTransactions"`
-	Transactions       []*Trans            `bson:"transactions" json:"transactions"`
-	ResumeParJour      []*CaisseResumeJour `bson:"resumeParJour" json:"resumeParJour"`
+	StoreID            *primitive.ObjectID         `bson:"storeId,omitempty" json:"storeId,omitempty"`
+	Currency           string                      `bson:"currency" json:"currency"`
+	Period             string                      `bson:"period" json:"period"`
+	StartDate          time.Time                   `bson:"startDate" json:"startDate"`
+	EndDate            time.Time                   `bson:"endDate" json:"endDate"`
+	TotalEntrees       float64                     `bson:"totalEntrees" json:"totalEntrees"`
+	TotalSorties       float64                     `bson:"totalSorties" json:"totalSorties"`
+	TotalBenefice      float64                     `bson:"totalBenefice" json:"totalBenefice"` // Total profit from sales
+	SoldeInitial       float64                     `bson:"soldeInitial" json:"soldeInitial"`
+	SoldeFinal         float64                     `bson:"soldeFinal" json:"soldeFinal"`
+	NombreTransactions int                         `bson:"nombreTransactions" json:"nombreTransactions"`
+	Transactions       []*Trans                    `bson:"transactions" json:"transactions"`
+	ResumeParJour      []*CaisseResumeJour         `bson:"resumeParJour" json:"resumeParJour"`
+	ParMethode         []*CaissePaymentMethodTotal `bson:"parMethode" json:"parMethode"` // Transactions de la période par mode de paiement
 }
 
 // CreateTrans creates a new cash register transaction
 func (db *DB) CreateTrans(operation string, amount float64, description string, currency string, operatorID, storeID primitive.ObjectID, date *time.Time) (*Trans, error) {
-	transCollection := colHelper(db, "trans")
+	return db.createPaymentTrans(operation, amount, description, currency, "", "", nil, operatorID, storeID, date)
+}
+
+// createPaymentTrans creates a cash register transaction received or paid with a payment method
+// (mobile money, card...) and its reference, linked to its sale if any
+func (db *DB) createPaymentTrans(operation string, amount float64, description, currency, paymentMethod, reference string, saleID *primitive.ObjectID, operatorID, storeID primitive.ObjectID, date *time.Time) (*Trans, error) {
+	return db.insertTrans(Trans{
+		Amount:        amount,
+		Operation:     operation,
+		Description:   description,
+		Currency:      currency,
+		PaymentMethod: paymentMethod,
+		Reference:     reference,
+		SaleID:        saleID,
+		OperatorID:    operatorID,
+		StoreID:       storeID,
+	}, date)
+}
+
+// CreatePurchaseTrans creates the "Sortie" paying goods to a provider (supply, provider debt payment):
+// its amount is a purchase cost, hidden without the price.viewCost permission
+func (db *DB) CreatePurchaseTrans(amount float64, description string, currency string, operatorID, storeID primitive.ObjectID, date *time.Time) (*Trans, error) {
+	return db.insertTrans(Trans{
+		Amount:      amount,
+		Operation:   "Sortie",
+		Description: description,
+		Currency:    currency,
+		Purchase:    true,
+		OperatorID:  operatorID,
+		StoreID:     storeID,
+	}, date)
+}
+
+// insertTrans validates and records a cash register transaction, dated now without date
+func (db *DB) insertTrans(trans Trans, date *time.Time) (*Trans, error) {
 	ctx, cancel := GetDBContext()
 	defer cancel()
 
+	if date != nil {
+		trans.Date = *date
+	}
+	if err := db.writeTrans(ctx, &trans); err != nil {
+		return nil, err
+	}
+
+	db.publish(events.Event{Type: events.CaisseUpdated, StoreID: trans.StoreID.Hex(), Currency: trans.Currency, Payload: &trans})
+
+	return &trans, nil
+}
+
+// writeTrans validates and inserts a cash register transaction with ctx (a session context within a MongoDB
+// transaction), dated now without date. The caller publishes it once it is committed
+func (db *DB) writeTrans(ctx context.Context, trans *Trans) error {
 	// Validate operation
-	if operation != "Entree" && operation != "Sortie" {
-		return nil, gqlerror.Errorf("Operation must be 'Entree' or 'Sortie'")
+	if trans.Operation != "Entree" && trans.Operation != "Sortie" {
+		return gqlerror.Errorf("Operation must be 'Entree' or 'Sortie'")
 	}
 
 	// Validate amount
-	if amount <= 0 {
-		return nil, gqlerror.Errorf("Amount must be greater than 0")
+	if trans.Amount <= 0 {
+		return gqlerror.Errorf("Amount must be greater than 0")
 	}
 
 	// Validate currency (support all currencies from validators)
@@ -79,34 +146,26 @@ func (db *DB) CreateTrans(operation string, amount float64, description string,
 		"EUR": true,
 		"CDF": true,
 	}
-	if !validCurrencies[currency] {
-		return nil, gqlerror.Errorf("Invalid currency code. Supported: USD, EUR, CDF")
+	if !validCurrencies[trans.Currency] {
+		return gqlerror.Errorf("Invalid currency code. Supported: USD, EUR, CDF")
 	}
 
-	transactionDate := time.Now()
-	if date != nil {
-		transactionDate = *date
+	now := time.Now()
+	if trans.ID.IsZero() {
+		trans.ID = primitive.NewObjectID()
 	}
-
-	trans := Trans{
-		ID:          primitive.NewObjectID(),
-		Amount:      amount,
-		Operation:   operation,
-		Description: description,
-		Currency:    currency,
-		OperatorID:  operatorID,
-		StoreID:     storeID,
-		Date:        transactionDate,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
+	if trans.Date.IsZero() {
+		trans.Date = now
 	}
+	trans.CreatedAt = now
+	trans.UpdatedAt = now
 
-	_, err := transCollection.InsertOne(ctx, trans)
+	_, err := colHelper(db, "trans").InsertOne(ctx, trans)
 	if err != nil {
-		return nil, gqlerror.Errorf("Error creating transaction: %v", err)
+		return gqlerror.Errorf("Error creating transaction: %v", err)
 	}
 
-	return &trans, nil
+	return nil
 }
 
 // FindTransByStoreIDs finds all transactions for the given stores with optional filters
@@ -211,8 +270,8 @@ func (db *DB) calculateBeneficeFromSales(storeIDs []primitive.ObjectID, currency
 	ctx, cancel := GetDBContext()
 	defer cancel()
 
-	// Build match filter
-	matchFilter := bson.M{"storeId": bson.M{"$in": storeIDs}}
+	// Build match filter (cancelled sales don't generate profit)
+	matchFilter := bson.M{"storeId": bson.M{"$in": storeIDs}, "cancelledAt": nil}
 
 	// Add currency filter
 	if currency != nil {
@@ -236,32 +295,37 @@ func (db *DB) calculateBeneficeFromSales(storeIDs []primitive.ObjectID, currency
 	}
 
 	// Aggregation pipeline to calculate total benefice
-	// Benefice = (sale price - purchase price) * quantity for each item
+	// Benefice = (sale price - purchase price) * quantity - promotion discount for each item
 	pipeline := []bson.M{
 		{"$match": matchFilter},
 		// Unwind basket to process each item separately
 		{"$unwind": "$basket"},
-		// Lookup product information
+		// Lookup the product in stock of each basket line (it holds the purchase price)
 		{
 			"$lookup": bson.M{
-				"from":         "products",
-				"localField":   "basket.productId",
+				"from":         "products_in_stock",
+				"localField":   "basket.productInStockId",
 				"foreignField": "_id",
 				"as":           "productInfo",
 			},
 		},
 		// Unwind productInfo (should be single element)
 		{"$unwind": bson.M{"path": "$productInfo", "preserveNullAndEmptyArrays": true}},
-		// Calculate benefice for each item: (price - priceAchat) * quantity
+		// Calculate benefice for each item: (price - priceAchat) * quantity - discount
 		{
 			"$project": bson.M{
 				"itemBenefice": bson.M{
 					"$cond": bson.M{
 						"if": bson.M{"$ne": []interface{}{"$productInfo", nil}},
 						"then": bson.M{
-							"$multiply": []interface{}{
-								bson.M{"$subtract": []interface{}{"$basket.price", "$productInfo.priceAchat"}},
-								"$basket.quantity",
+							"$subtract": []interface{}{
+								bson.M{
+									"$multiply": []interface{}{
+										bson.M{"$subtract": []interface{}{"$basket.price", "$productInfo.priceAchat"}},
+										"$basket.quantity",
+									},
+								},
+								bson.M{"$ifNull": []interface{}{"$basket.discount", 0}},
 							},
 						},
 						"else": 0,
@@ -290,16 +354,29 @@ func (db *DB) calculateBeneficeFromSales(storeIDs []primitive.ObjectID, currency
 	}
 
 	// Extract total benefice from results
+	var totalBenefice float64
 	if len(results) > 0 {
-		if totalBenefice, ok := results[0]["totalBenefice"].(float64); ok {
-			return totalBenefice, nil
-		}
-		if totalBenefice, ok := results[0]["totalBenefice"].(int64); ok {
-			return float64(totalBenefice), nil
+		if benefice, ok := results[0]["totalBenefice"].(float64); ok {
+			totalBenefice = benefice
+		} else if benefice, ok := results[0]["totalBenefice"].(int64); ok {
+			totalBenefice = float64(benefice)
 		}
 	}
 
-	return 0, nil
+	// Returns give back the margin they carried
+	var start, end time.Time
+	if startDate != nil {
+		start = *startDate
+	}
+	if endDate != nil {
+		end = *endDate
+	}
+	returnsBenefice, err := db.sumReturnsBenefice(storeIDs, currency, start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	return totalBenefice - returnsBenefice, nil
 }
 
 // FindCaisse calculates the cash register balance for a store with optional filters
@@ -465,11 +542,17 @@ func (db *DB) DeleteTrans(id string) error {
 	ctx, cancel := GetDBContext()
 	defer cancel()
 
-	_, err = transCollection.DeleteOne(ctx, bson.M{"_id": objectID})
+	var trans Trans
+	err = transCollection.FindOneAndDelete(ctx, bson.M{"_id": objectID}).Decode(&trans)
+	if err == mongo.ErrNoDocuments {
+		return nil
+	}
 	if err != nil {
 		return gqlerror.Errorf("Error deleting transaction: %v", err)
 	}
 
+	db.publish(events.Event{Type: events.CaisseUpdated, StoreID: trans.StoreID.Hex(), Currency: trans.Currency, Payload: &trans})
+
 	return nil
 }
 
@@ -715,5 +798,6 @@ func (db *DB) FindCaisseRapport(storeID *string, currency *string, period *strin
 		NombreTransactions: len(allTransactions),
 		Transactions:       allTransactions,
 		ResumeParJour:      resumeParJour,
+		ParMethode:         transPaymentMethodTotals(allTransactions),
 	}, nil
 }
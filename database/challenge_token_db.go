@@ -0,0 +1,52 @@
+package database
+
+import (
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// UsedChallengeToken is a two-factor challenge token that already opened a session: it cannot be used again
+type UsedChallengeToken struct {
+	TokenID   string             `bson:"_id" json:"tokenId"` // jti du JWT
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	UsedAt    time.Time          `bson:"usedAt" json:"usedAt"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"` // Index TTL: le token est expiré après
+}
+
+// UseChallengeToken marks the challenge token as used. It returns false when it was already used
+func (db *DB) UseChallengeToken(tokenID string, userID primitive.ObjectID, expiresAt time.Time) (bool, error) {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := colHelper(db, "used_challenge_tokens").InsertOne(ctx, UsedChallengeToken{
+		TokenID:   tokenID,
+		UserID:    userID,
+		UsedAt:    time.Now(),
+		ExpiresAt: expiresAt,
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, utils.DatabaseErrorf("use_challenge_token", "Error using challenge token: %v", err)
+	}
+	return true, nil
+}
+
+// IsChallengeTokenUsed tells whether the challenge token already opened a session
+func (db *DB) IsChallengeTokenUsed(tokenID string) (bool, error) {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	count, err := colHelper(db, "used_challenge_tokens").CountDocuments(ctx, bson.M{"_id": tokenID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, utils.DatabaseErrorf("check_challenge_token", "Error checking challenge token: %v", err)
+	}
+	return count > 0, nil
+}
@@ -23,6 +23,7 @@ type Company struct {
 	IDCommerce    *string            `bson:"idCommerce,omitempty" json:"idCommerce,omitempty"`
 	LicenseID     *string            `bson:"licenseId,omitempty" json:"licenseId,omitempty"` // ID de licence pour l'exploitation annuelle
 	ExchangeRates []ExchangeRate     `bson:"exchangeRates" json:"exchangeRates"` // Taux de change configurés
+	RequireAdminTwoFactor bool       `bson:"requireAdminTwoFactor" json:"requireAdminTwoFactor"` // Les Admins doivent activer la 2FA pour se connecter
 	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
 	UpdatedAt     time.Time          `bson:"updatedAt" json:"updatedAt"`
 }
@@ -141,6 +142,23 @@ func (db *DB) UpdateCompany(id string, name, address, phone, description, compan
 	return db.FindCompanyByID(id)
 }
 
+// SetCompanyAdminTwoFactor sets whether the Admins of the company must log in with two-factor authentication
+func (db *DB) SetCompanyAdminTwoFactor(companyID primitive.ObjectID, required bool) (*Company, error) {
+	companyCollection := colHelper(db, "companies")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := companyCollection.UpdateOne(ctx, bson.M{"_id": companyID}, bson.M{"$set": bson.M{
+		"requireAdminTwoFactor": required,
+		"updatedAt":             time.Now(),
+	}})
+	if err != nil {
+		return nil, gqlerror.Errorf("Error updating company: %v", err)
+	}
+
+	return db.FindCompanyByID(companyID.Hex())
+}
+
 func (db *DB) DeleteCompany(id string) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -158,10 +176,12 @@ func (db *DB) DeleteCompany(id string) error {
 		return gqlerror.Errorf("Cannot delete company: it contains stores. Please delete all stores first.")
 	}
 
-	// Check if company has any users
-	userCollection := colHelper(db, "users")
-	userCount, _ := userCollection.CountDocuments(ctx, bson.M{"companyId": objectID})
-	if userCount > 1 {
+	// Check if company has any members (memberships, whatever their active company)
+	members, err := db.FindUsersByCompanyID(id)
+	if err != nil {
+		return err
+	}
+	if len(members) > 1 {
 		// More than 1 because we need to check if there are other users besides the one deleting
 		return gqlerror.Errorf("Cannot delete company: it contains users. Please remove all users first.")
 	}
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"rangoapp/events"
 	"rangoapp/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -139,6 +140,7 @@ func connectWithRetry(client *mongo.Client, ctx context.Context, maxRetries int,
 type DB struct {
 	client   *mongo.Client
 	database *mongo.Database
+	events   *events.Bus // Bus des GraphQL subscriptions
 	// cacheHelper *CacheHelper // Cache helper (disabled - Redis not configured)
 }
 
@@ -146,6 +148,30 @@ func (db *DB) Client() *mongo.Client {
 	return db.client
 }
 
+// Events returns the bus the database publishes its changes to (sales, caisse, stock)
+func (db *DB) Events() *events.Bus {
+	return db.events
+}
+
+// publish notifies the subscriptions of a change, once it is committed
+func (db *DB) publish(event events.Event) {
+	if db.events == nil {
+		return
+	}
+	db.events.Publish(event)
+}
+
+// publishCommitted notifies the subscriptions of the caisse transactions and stock movements of a committed
+// MongoDB transaction
+func (db *DB) publishCommitted(transactions []*Trans, movements []*StockMovement) {
+	for _, trans := range transactions {
+		db.publish(events.Event{Type: events.CaisseUpdated, StoreID: trans.StoreID.Hex(), Currency: trans.Currency, Payload: trans})
+	}
+	for _, movement := range movements {
+		db.publish(events.Event{Type: events.StockLevelChanged, StoreID: movement.StoreID.Hex(), Payload: movement})
+	}
+}
+
 func ConnectDB() *DB {
 	// Use sync.Once to ensure thread-safe initialization
 	once.Do(func() {
@@ -209,6 +235,7 @@ func ConnectDB() *DB {
 		dbInstance = &DB{
 			client:   client,
 			database: client.Database(dbName),
+			events:   events.NewBus(),
 			// cacheHelper: cacheHelper, // Disabled
 		}
 
@@ -292,6 +319,27 @@ func createIndexes(db *DB) {
 				{Key: "deletedAt", Value: 1},
 			},
 		},
+		{
+			// Barcode unique per store (only for products that have one)
+			Keys: bson.D{
+				{Key: "storeId", Value: 1},
+				{Key: "barcode", Value: 1},
+			},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"barcode": bson.M{"$type": "string"}}),
+		},
+		{
+			// SKU unique per store (only for products that have one)
+			Keys: bson.D{
+				{Key: "storeId", Value: 1},
+				{Key: "sku", Value: 1},
+			},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"sku": bson.M{"$type": "string"}}),
+		},
+		{
+			// Products waiting for the low stock job
+			Keys:    map[string]interface{}{"stockCheckPending": 1},
+			Options: options.Index().SetPartialFilterExpression(bson.M{"stockCheckPending": true}),
+		},
 	}
 	_, err = productCollection.Indexes().CreateMany(ctx, productIndexes)
 	if err != nil {
@@ -471,6 +519,397 @@ func createIndexes(db *DB) {
 		utils.LogError(err, "Failed to create sales indexes")
 	}
 
+	// Sale returns indexes
+	saleReturnCollection := colHelper(db, "sale_returns")
+	saleReturnIndexes := []mongo.IndexModel{
+		{
+			Keys: map[string]interface{}{"saleId": 1},
+		},
+		{
+			// Compound index for storeId + currency + createdAt (benefice net of returns)
+			Keys: bson.D{
+				{Key: "storeId", Value: 1},
+				{Key: "currency", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+	}
+	_, err = saleReturnCollection.Indexes().CreateMany(ctx, saleReturnIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create sale returns indexes")
+	}
+
+	// Stock transfers indexes (listed from both ends)
+	stockTransferCollection := colHelper(db, "stock_transfers")
+	stockTransferIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "fromStoreId", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "toStoreId", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+		{
+			Keys: map[string]interface{}{"status": 1},
+		},
+	}
+	_, err = stockTransferCollection.Indexes().CreateMany(ctx, stockTransferIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create stock transfers indexes")
+	}
+
+	// Purchase orders indexes
+	purchaseOrderCollection := colHelper(db, "purchase_orders")
+	purchaseOrderIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "storeId", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+		{
+			Keys: map[string]interface{}{"providerId": 1},
+		},
+		{
+			Keys: map[string]interface{}{"status": 1},
+		},
+	}
+	_, err = purchaseOrderCollection.Indexes().CreateMany(ctx, purchaseOrderIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create purchase orders indexes")
+	}
+
+	// Products in stock indexes (expiry tracking)
+	productInStockCollection := colHelper(db, "products_in_stock")
+	productInStockIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "storeId", Value: 1},
+				{Key: "expiryDate", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "productId", Value: 1},
+				{Key: "storeId", Value: 1},
+			},
+		},
+	}
+	_, err = productInStockCollection.Indexes().CreateMany(ctx, productInStockIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create products in stock indexes")
+	}
+
+	// Stock alerts indexes
+	stockAlertCollection := colHelper(db, "stock_alerts")
+	stockAlertIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "productId", Value: 1},
+				{Key: "status", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "storeId", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+	}
+	_, err = stockAlertCollection.Indexes().CreateMany(ctx, stockAlertIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create stock alerts indexes")
+	}
+
+	// Refresh tokens indexes
+	refreshTokenCollection := colHelper(db, "refresh_tokens")
+	refreshTokenIndexes := []mongo.IndexModel{
+		{
+			Keys:    map[string]interface{}{"tokenId": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "userId", Value: 1},
+				{Key: "expiresAt", Value: 1},
+			},
+		},
+		{
+			Keys: map[string]interface{}{"familyId": 1},
+		},
+		{
+			// TTL: expired refresh tokens are deleted by MongoDB
+			Keys:    map[string]interface{}{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = refreshTokenCollection.Indexes().CreateMany(ctx, refreshTokenIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create refresh tokens indexes")
+	}
+
+	// Token revocations indexes (revocation list checked on every request)
+	revocationCollection := colHelper(db, "token_revocations")
+	revocationIndexes := []mongo.IndexModel{
+		{
+			Keys:    map[string]interface{}{"familyId": 1},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			// TTL: once expired, no token of the family can be valid anymore
+			Keys:    map[string]interface{}{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = revocationCollection.Indexes().CreateMany(ctx, revocationIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create token revocations indexes")
+	}
+
+	// Used challenge tokens indexes (a two-factor challenge opens a single session)
+	usedChallengeCollection := colHelper(db, "used_challenge_tokens")
+	_, err = usedChallengeCollection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		// TTL: once expired, the challenge token is refused anyway
+		Keys:    map[string]interface{}{"expiresAt": 1},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		utils.LogError(err, "Failed to create used challenge tokens indexes")
+	}
+
+	// Sessions indexes
+	sessionCollection := colHelper(db, "sessions")
+	sessionIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "userId", Value: 1},
+				{Key: "lastSeenAt", Value: -1},
+			},
+		},
+		{
+			// TTL: sessions are kept 30 days after their expiration
+			Keys:    map[string]interface{}{"expiresAt": 1},
+			Options: options.Index().SetExpireAfterSeconds(30 * 24 * 3600),
+		},
+	}
+	_, err = sessionCollection.Indexes().CreateMany(ctx, sessionIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create sessions indexes")
+	}
+
+	// Roles indexes
+	roleCollection := colHelper(db, "roles")
+	roleIndexes := []mongo.IndexModel{
+		{
+			// Role names are unique per company, case insensitive
+			Keys: bson.D{
+				{Key: "companyId", Value: 1},
+				{Key: "name", Value: 1},
+			},
+			Options: options.Index().SetUnique(true).SetCollation(&options.Collation{Locale: "fr", Strength: 2}),
+		},
+	}
+	_, err = roleCollection.Indexes().CreateMany(ctx, roleIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create roles indexes")
+	}
+
+	// OTP codes indexes
+	otpCollection := colHelper(db, "otp_codes")
+	otpIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "phone", Value: 1},
+				{Key: "purpose", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+		{
+			// Codes are removed one day after expiry
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(24 * 3600),
+		},
+	}
+	_, err = otpCollection.Indexes().CreateMany(ctx, otpIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create otp_codes indexes")
+	}
+
+	// Login attempts indexes
+	loginAttemptCollection := colHelper(db, "login_attempts")
+	loginAttemptIndexes := []mongo.IndexModel{
+		{
+			// Counters are removed one day after the last failure
+			Keys:    bson.D{{Key: "lastFailureAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(24 * 3600),
+		},
+	}
+	_, err = loginAttemptCollection.Indexes().CreateMany(ctx, loginAttemptIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create login_attempts indexes")
+	}
+
+	// Audit log indexes
+	auditCollection := colHelper(db, "audit_log")
+	auditIndexes := []mongo.IndexModel{
+		{
+			// One entry per position of a chain: concurrent appends cannot fork it
+			Keys: bson.D{
+				{Key: "chain", Value: 1},
+				{Key: "sequence", Value: -1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "chain", Value: 1},
+				{Key: "action", Value: 1},
+				{Key: "sequence", Value: -1},
+			},
+		},
+		{
+			Keys: bson.D{
+				{Key: "chain", Value: 1},
+				{Key: "targetId", Value: 1},
+				{Key: "sequence", Value: -1},
+			},
+		},
+	}
+	_, err = auditCollection.Indexes().CreateMany(ctx, auditIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create audit_log indexes")
+	}
+
+	// API keys indexes
+	apiKeyCollection := colHelper(db, "api_keys")
+	apiKeyIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "prefix", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "companyId", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+	}
+	_, err = apiKeyCollection.Indexes().CreateMany(ctx, apiKeyIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create api_keys indexes")
+	}
+
+	// Memberships indexes
+	membershipCollection := colHelper(db, "memberships")
+	membershipIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "userId", Value: 1},
+				{Key: "companyId", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "companyId", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "roleId", Value: 1}},
+		},
+	}
+	_, err = membershipCollection.Indexes().CreateMany(ctx, membershipIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create memberships indexes")
+	}
+
+	// Invitations indexes
+	invitationCollection := colHelper(db, "invitations")
+	invitationIndexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "codeHash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{
+				{Key: "companyId", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+	}
+	_, err = invitationCollection.Indexes().CreateMany(ctx, invitationIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create invitations indexes")
+	}
+
+	// Promotions indexes
+	promotionCollection := colHelper(db, "promotions")
+	promotionIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "companyId", Value: 1},
+				{Key: "active", Value: 1},
+				{Key: "createdAt", Value: 1},
+			},
+		},
+		{
+			Keys: bson.D{{Key: "storeIds", Value: 1}},
+		},
+	}
+	_, err = promotionCollection.Indexes().CreateMany(ctx, promotionIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create promotions indexes")
+	}
+
+	// Payment intents indexes
+	paymentIntentCollection := colHelper(db, "payment_intents")
+	paymentIntentIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "provider", Value: 1},
+				{Key: "providerRef", Value: 1},
+			},
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"providerRef": bson.M{"$type": "string"}}),
+		},
+		{
+			Keys: bson.D{
+				{Key: "storeId", Value: 1},
+				{Key: "createdAt", Value: -1},
+			},
+		},
+		{
+			Keys: bson.D{{Key: "debtId", Value: 1}},
+		},
+	}
+	_, err = paymentIntentCollection.Indexes().CreateMany(ctx, paymentIntentIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create payment intents indexes")
+	}
+
+	// Idempotency keys indexes (results expire after IDEMPOTENCY_TTL_HOURS)
+	idempotencyCollection := colHelper(db, "idempotency_keys")
+	idempotencyIndexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{
+				{Key: "companyId", Value: 1},
+				{Key: "operation", Value: 1},
+				{Key: "key", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+	_, err = idempotencyCollection.Indexes().CreateMany(ctx, idempotencyIndexes)
+	if err != nil {
+		utils.LogError(err, "Failed to create idempotency keys indexes")
+	}
+
 	// Subscriptions indexes
 	subscriptionCollection := colHelper(db, "subscriptions")
 	subscriptionIndexes := []mongo.IndexModel{
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -22,11 +23,12 @@ type Debt struct {
 	AmountPaid   float64             `bson:"amountPaid" json:"amountPaid"`      // Montant déjà payé
 	AmountDue    float64             `bson:"amountDue" json:"amountDue"`        // Montant restant à payer
 	Currency     string              `bson:"currency" json:"currency"`
-	Status       string              `bson:"status" json:"status"` // "paid", "partial", "unpaid"
+	Status       string              `bson:"status" json:"status"` // "paid", "partial", "unpaid", "cancelled"
 	PaymentType  string              `bson:"paymentType" json:"paymentType"`   // "cash", "debt", "advance"
 	CreatedAt    time.Time           `bson:"createdAt" json:"createdAt"`
 	UpdatedAt    time.Time           `bson:"updatedAt" json:"updatedAt"`
 	PaidAt       *time.Time          `bson:"paidAt,omitempty" json:"paidAt,omitempty"` // Date de paiement complet
+	CancelledAt  *time.Time          `bson:"cancelledAt,omitempty" json:"cancelledAt,omitempty"` // Date d'annulation (vente annulée)
 }
 
 // DebtPayment represents a payment made towards a debt
@@ -39,6 +41,10 @@ type DebtPayment struct {
 	StoreID     primitive.ObjectID `bson:"storeId" json:"storeId"`
 	Description string             `bson:"description" json:"description"`
 	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	VoidedAt    *time.Time         `bson:"voidedAt,omitempty" json:"voidedAt,omitempty"` // Set when the debt is cancelled
+
+	PaymentMethod string `bson:"paymentMethod,omitempty" json:"paymentMethod,omitempty"` // Vide: espèces (voir PaymentIntent)
+	Reference     string `bson:"reference,omitempty" json:"reference,omitempty"`         // Référence de la transaction mobile money
 }
 
 // CreateDebt creates a new debt from a sale
@@ -97,38 +103,90 @@ func (db *DB) CreateDebt(saleID, clientID, storeID primitive.ObjectID, totalAmou
 
 // PayDebt records a payment towards a debt
 func (db *DB) PayDebt(debtID string, amount float64, operatorID, storeID primitive.ObjectID, description string) (*Debt, *DebtPayment, error) {
+	return db.payDebt(debtID, amount, operatorID, storeID, description, "", "")
+}
+
+// payDebt records a payment towards a debt received with a payment method (empty: cash) and posts it to the caisse.
+// The amount due and the debt status of the sale follow the debt.
+// All database operations are performed within a MongoDB transaction to ensure atomicity
+func (db *DB) payDebt(debtID string, amount float64, operatorID, storeID primitive.ObjectID, description, paymentMethod, reference string) (*Debt, *DebtPayment, error) {
+	// Start MongoDB transaction
+	session, err := db.client.StartSession()
+	if err != nil {
+		return nil, nil, utils.DatabaseErrorf("start_session", "Error starting transaction session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	txCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var debt *Debt
+	var payment *DebtPayment
+	var trans *Trans
+	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
+		// Start transaction
+		if err := session.StartTransaction(); err != nil {
+			return utils.DatabaseErrorf("start_transaction", "Error starting transaction: %v", err)
+		}
+
+		var err error
+		debt, payment, trans, err = db.payDebtInTx(sc, debtID, amount, operatorID, storeID, description, paymentMethod, reference)
+		if err != nil {
+			return err
+		}
+
+		// Commit transaction
+		if err := session.CommitTransaction(sc); err != nil {
+			return utils.DatabaseErrorf("commit_transaction", "Error committing transaction: %v", err)
+		}
+
+		return nil
+	})
+
+	// Handle transaction errors
+	// MongoDB automatically aborts the transaction if an error occurs in WithSession
+	if err != nil {
+		return nil, nil, err
+	}
+
+	db.publishCommitted([]*Trans{trans}, nil)
+
+	return debt, payment, nil
+}
+
+// payDebtInTx records the payment of payDebt within the transaction sc: the debt payment, the debt and its sale,
+// and the caisse transaction, returned for the caller to publish once committed
+func (db *DB) payDebtInTx(sc mongo.SessionContext, debtID string, amount float64, operatorID, storeID primitive.ObjectID, description, paymentMethod, reference string) (*Debt, *DebtPayment, *Trans, error) {
 	objectID, err := primitive.ObjectIDFromHex(debtID)
 	if err != nil {
-		return nil, nil, utils.ValidationErrorf("Invalid debt ID")
+		return nil, nil, nil, utils.ValidationErrorf("Invalid debt ID")
 	}
 
 	debtCollection := colHelper(db, "debts")
 	paymentCollection := colHelper(db, "debtPayments")
-	ctx, cancel := GetDBContext()
-	defer cancel()
 
 	// Get current debt
 	var debt Debt
-	err = debtCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&debt)
+	err = debtCollection.FindOne(sc, bson.M{"_id": objectID}).Decode(&debt)
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
-			return nil, nil, utils.NotFoundErrorf("Debt not found")
+			return nil, nil, nil, utils.NotFoundErrorf("Debt not found")
 		}
-		return nil, nil, utils.DatabaseErrorf("find_debt", "Error finding debt: %v", err)
+		return nil, nil, nil, utils.DatabaseErrorf("find_debt", "Error finding debt: %v", err)
 	}
 
 	// Validate amount
 	if amount <= 0 {
-		return nil, nil, utils.ValidationErrorf("Payment amount must be greater than 0")
+		return nil, nil, nil, utils.ValidationErrorf("Payment amount must be greater than 0")
 	}
 
 	if amount > debt.AmountDue {
-		return nil, nil, utils.ValidationErrorf("Payment amount (%.2f) exceeds remaining debt (%.2f)", amount, debt.AmountDue)
+		return nil, nil, nil, utils.ValidationErrorf("Payment amount (%.2f) exceeds remaining debt (%.2f)", amount, debt.AmountDue)
 	}
 
 	// Verify store access
 	if debt.StoreID != storeID {
-		return nil, nil, utils.ValidationErrorf("Debt does not belong to the specified store")
+		return nil, nil, nil, utils.ValidationErrorf("Debt does not belong to the specified store")
 	}
 
 	// Calculate new amounts
@@ -158,14 +216,17 @@ func (db *DB) PayDebt(debtID string, amount float64, operatorID, storeID primiti
 		StoreID:     storeID,
 		Description: description,
 		CreatedAt:   now,
+
+		PaymentMethod: paymentMethod,
+		Reference:     reference,
 	}
 
-	_, err = paymentCollection.InsertOne(ctx, payment)
+	_, err = paymentCollection.InsertOne(sc, payment)
 	if err != nil {
-		return nil, nil, utils.DatabaseErrorf("create_payment", "Error creating payment record: %v", err)
+		return nil, nil, nil, utils.DatabaseErrorf("create_payment", "Error creating payment record: %v", err)
 	}
 
-	// Update debt
+	// Update debt (the amountDue guard protects against two concurrent payments)
 	update := bson.M{
 		"amountPaid": newAmountPaid,
 		"amountDue":  newAmountDue,
@@ -176,36 +237,48 @@ func (db *DB) PayDebt(debtID string, amount float64, operatorID, storeID primiti
 		update["paidAt"] = paidAt
 	}
 
-	_, err = debtCollection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": update})
+	result, err := debtCollection.UpdateOne(sc, bson.M{"_id": objectID, "amountDue": debt.AmountDue}, bson.M{"$set": update})
 	if err != nil {
-		return nil, nil, utils.DatabaseErrorf("update_debt", "Error updating debt: %v", err)
+		return nil, nil, nil, utils.DatabaseErrorf("update_debt", "Error updating debt: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, nil, nil, utils.ValidationErrorf("Debt was modified by another operation, please retry")
 	}
 
 	// Reload debt
-	err = debtCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&debt)
+	err = debtCollection.FindOne(sc, bson.M{"_id": objectID}).Decode(&debt)
 	if err != nil {
-		return nil, nil, utils.DatabaseErrorf("reload_debt", "Error reloading debt: %v", err)
+		return nil, nil, nil, utils.DatabaseErrorf("reload_debt", "Error reloading debt: %v", err)
 	}
 
-	// Create caisse transaction for the payment
-	// Note: If this fails, we log it but don't fail the payment since it's already recorded
-	// In a production system, you might want to retry this or use a queue
-	_, err = db.CreateTrans(
-		"Entree",
-		amount,
-		fmt.Sprintf("Paiement dette - %s", description),
-		debt.Currency,
-		operatorID,
-		storeID,
-		&now,
-	)
+	// Keep the sale in line with its debt
+	_, err = colHelper(db, "sales").UpdateOne(sc, bson.M{"_id": debt.SaleID}, bson.M{"$set": bson.M{
+		"amountDue":  debt.AmountDue,
+		"debtStatus": debt.Status,
+		"updatedAt":  now,
+	}})
 	if err != nil {
-		// Log error but don't fail the payment - the payment is already recorded in the database
-		// This is a non-critical operation that can be retried later if needed
-		utils.LogError(err, fmt.Sprintf("Failed to create caisse transaction for debt payment %s (debt: %s)", payment.ID.Hex(), debtID))
+		return nil, nil, nil, utils.DatabaseErrorf("update_sale", "Error updating sale %s after debt payment: %v", debt.SaleID.Hex(), err)
 	}
 
-	return &debt, &payment, nil
+	// Create caisse transaction for the payment
+	trans := &Trans{
+		Amount:        amount,
+		Operation:     "Entree",
+		Description:   fmt.Sprintf("Paiement dette - %s", description),
+		Currency:      debt.Currency,
+		PaymentMethod: paymentMethod,
+		Reference:     reference,
+		SaleID:        &debt.SaleID,
+		OperatorID:    operatorID,
+		StoreID:       storeID,
+		Date:          now,
+	}
+	if err := db.writeTrans(sc, trans); err != nil {
+		return nil, nil, nil, utils.DatabaseErrorf("create_caisse_transaction", "Error creating caisse transaction for debt payment %s: %v", payment.ID.Hex(), err)
+	}
+
+	return &debt, &payment, trans, nil
 }
 
 // GetDebtByID retrieves a debt by ID
@@ -0,0 +1,224 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	IdempotencyInProgress = "in_progress"
+	IdempotencyCompleted  = "completed"
+	IdempotencyFailed     = "failed" // Failed after it may have written: it is not run again with this key
+
+	defaultIdempotencyTTL = 24 * time.Hour
+	idempotencyLock       = 2 * time.Minute        // A request still in progress after this is considered dead (crash)
+	IdempotencyHeartbeat  = 30 * time.Second       // The request in flight extends its lock at this interval
+	idempotencyWait       = 5 * time.Second        // How long a duplicate waits for the request in flight
+	idempotencyPoll       = 200 * time.Millisecond // Polling interval while waiting
+)
+
+// IdempotencyRecord is a mutation run with an idempotency key (Idempotency-Key header or idempotencyKey
+// argument): the retries of the same request get the stored result instead of running it again
+type IdempotencyRecord struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CompanyID   primitive.ObjectID `bson:"companyId" json:"companyId"`
+	UserID      primitive.ObjectID `bson:"userId" json:"userId"`
+	Operation   string             `bson:"operation" json:"operation"` // createSale, payDebt...
+	Key         string             `bson:"key" json:"key"`
+	RequestHash string             `bson:"requestHash" json:"requestHash"`         // Empreinte des arguments: une clé ne sert qu'à une requête
+	Status      string             `bson:"status" json:"status"`                   // "in_progress", "completed", "failed"
+	Response    []byte             `bson:"response,omitempty" json:"-"`            // Résultat JSON de la mutation
+	Error       string             `bson:"error,omitempty" json:"error,omitempty"` // Erreur d'une requête en échec
+	LockedUntil time.Time          `bson:"lockedUntil" json:"lockedUntil"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	CompletedAt *time.Time         `bson:"completedAt,omitempty" json:"completedAt,omitempty"`
+	ExpiresAt   time.Time          `bson:"expiresAt" json:"expiresAt"` // Index TTL
+}
+
+// getIdempotencyTTL returns how long the results are kept for the retries
+// Can be configured via IDEMPOTENCY_TTL_HOURS environment variable
+func getIdempotencyTTL() time.Duration {
+	ttlStr := os.Getenv("IDEMPOTENCY_TTL_HOURS")
+	if ttlStr == "" {
+		return defaultIdempotencyTTL
+	}
+
+	hours, err := strconv.Atoi(ttlStr)
+	if err != nil || hours <= 0 {
+		utils.Warning("Invalid IDEMPOTENCY_TTL_HOURS value '%s', using default: %v", ttlStr, defaultIdempotencyTTL)
+		return defaultIdempotencyTTL
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Actions on an existing record for a new request with the same key
+const (
+	idempotencyReplay   = "replay"    // Return the stored result
+	idempotencyWaitFor  = "wait"      // The same request is in flight
+	idempotencyTakeOver = "take_over" // Expired result or dead request: run again
+	idempotencyConflict = "conflict"  // The key was used for another request
+	idempotencyFailed   = "failed"    // The request failed and may be partly applied
+)
+
+// idempotencyAction returns what to do with a request whose key is already recorded
+func idempotencyAction(record *IdempotencyRecord, requestHash string, now time.Time) string {
+	if !record.ExpiresAt.After(now) {
+		return idempotencyTakeOver
+	}
+	if record.RequestHash != requestHash {
+		return idempotencyConflict
+	}
+	if record.Status == IdempotencyCompleted {
+		return idempotencyReplay
+	}
+	if record.Status == IdempotencyFailed {
+		return idempotencyFailed
+	}
+	if !record.LockedUntil.After(now) {
+		return idempotencyTakeOver
+	}
+	return idempotencyWaitFor
+}
+
+// BeginIdempotentRequest records the request of the key before running it. It returns the stored record
+// with its Response when the request already completed (replay), waits for the same request in flight,
+// and rejects a key reused for another request, still in flight after the wait or whose request failed
+// after it may have written (see FailIdempotentRequest)
+func (db *DB) BeginIdempotentRequest(companyID, userID primitive.ObjectID, operation, key, requestHash string) (*IdempotencyRecord, error) {
+	collection := colHelper(db, "idempotency_keys")
+	deadline := time.Now().Add(idempotencyWait)
+
+	for {
+		now := time.Now()
+		record := &IdempotencyRecord{
+			ID:          primitive.NewObjectID(),
+			CompanyID:   companyID,
+			UserID:      userID,
+			Operation:   operation,
+			Key:         key,
+			RequestHash: requestHash,
+			Status:      IdempotencyInProgress,
+			LockedUntil: now.Add(idempotencyLock),
+			CreatedAt:   now,
+			ExpiresAt:   now.Add(getIdempotencyTTL()),
+		}
+
+		ctx, cancel := GetDBContext()
+		_, err := collection.InsertOne(ctx, record)
+		cancel()
+		if err == nil {
+			return record, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return nil, utils.DatabaseErrorf("create_idempotency_key", "Error recording idempotency key: %v", err)
+		}
+
+		// The key is already recorded
+		filter := bson.M{"companyId": companyID, "operation": operation, "key": key}
+		var existing IdempotencyRecord
+		ctx, cancel = GetDBContext()
+		err = collection.FindOne(ctx, filter).Decode(&existing)
+		cancel()
+		if err == mongo.ErrNoDocuments {
+			continue // Released in the meantime
+		}
+		if err != nil {
+			return nil, utils.DatabaseErrorf("find_idempotency_key", "Error finding idempotency key: %v", err)
+		}
+
+		switch idempotencyAction(&existing, requestHash, now) {
+		case idempotencyReplay:
+			return &existing, nil
+		case idempotencyConflict:
+			return nil, utils.NewConflictError("Idempotency key already used for a different request")
+		case idempotencyFailed:
+			return nil, utils.NewConflictError(fmt.Sprintf("The request with this idempotency key failed and may be partly applied (%s): check the result, then retry with a new key", existing.Error))
+		case idempotencyTakeOver:
+			// Replace the record unless another request took it over first
+			ctx, cancel = GetDBContext()
+			_, err = collection.DeleteOne(ctx, bson.M{"_id": existing.ID, "lockedUntil": existing.LockedUntil, "status": existing.Status})
+			cancel()
+			if err != nil {
+				return nil, utils.DatabaseErrorf("delete_idempotency_key", "Error replacing idempotency key: %v", err)
+			}
+		case idempotencyWaitFor:
+			if now.After(deadline) {
+				return nil, utils.NewConflictError("A request with this idempotency key is already in progress, retry later")
+			}
+			time.Sleep(idempotencyPoll)
+		}
+	}
+}
+
+// CompleteIdempotentRequest stores the result of the request for its retries
+func (db *DB) CompleteIdempotentRequest(record *IdempotencyRecord, response []byte) error {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	_, err := colHelper(db, "idempotency_keys").UpdateOne(ctx, bson.M{"_id": record.ID, "status": IdempotencyInProgress}, bson.M{"$set": bson.M{
+		"status":      IdempotencyCompleted,
+		"response":    response,
+		"completedAt": now,
+		"expiresAt":   now.Add(getIdempotencyTTL()),
+	}})
+	if err != nil {
+		return utils.DatabaseErrorf("complete_idempotency_key", "Error storing idempotent result: %v", err)
+	}
+	return nil
+}
+
+// ExtendIdempotentRequest extends the lock of the request in flight, so that it is not taken over while it runs
+func (db *DB) ExtendIdempotentRequest(record *IdempotencyRecord) error {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := colHelper(db, "idempotency_keys").UpdateOne(ctx,
+		bson.M{"_id": record.ID, "status": IdempotencyInProgress},
+		bson.M{"$set": bson.M{"lockedUntil": time.Now().Add(idempotencyLock)}},
+	)
+	if err != nil {
+		return utils.DatabaseErrorf("extend_idempotency_key", "Error extending idempotency key: %v", err)
+	}
+	return nil
+}
+
+// FailIdempotentRequest keeps a request that failed after it may have written (mutation without rollback):
+// its retries with the key are refused instead of applying it twice
+func (db *DB) FailIdempotentRequest(record *IdempotencyRecord, cause error) error {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	_, err := colHelper(db, "idempotency_keys").UpdateOne(ctx, bson.M{"_id": record.ID, "status": IdempotencyInProgress}, bson.M{"$set": bson.M{
+		"status":      IdempotencyFailed,
+		"error":       cause.Error(),
+		"completedAt": now,
+		"expiresAt":   now.Add(getIdempotencyTTL()),
+	}})
+	if err != nil {
+		return utils.DatabaseErrorf("fail_idempotency_key", "Error storing idempotent failure: %v", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotentRequest forgets a request that failed without writing (rolled back or refused before
+// its first write): a retry runs it again
+func (db *DB) ReleaseIdempotentRequest(record *IdempotencyRecord) error {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := colHelper(db, "idempotency_keys").DeleteOne(ctx, bson.M{"_id": record.ID, "status": IdempotencyInProgress})
+	if err != nil {
+		return utils.DatabaseErrorf("release_idempotency_key", "Error releasing idempotency key: %v", err)
+	}
+	return nil
+}
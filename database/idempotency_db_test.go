@@ -0,0 +1,55 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIdempotencyAction vérifie le traitement d'une requête dont la clé d'idempotence est déjà enregistrée
+func TestIdempotencyAction(t *testing.T) {
+	now := time.Now()
+	record := func(status string) *IdempotencyRecord {
+		return &IdempotencyRecord{
+			RequestHash: "hash",
+			Status:      status,
+			LockedUntil: now.Add(time.Minute),
+			ExpiresAt:   now.Add(time.Hour),
+		}
+	}
+
+	assert.Equal(t, idempotencyReplay, idempotencyAction(record(IdempotencyCompleted), "hash", now))
+	assert.Equal(t, idempotencyWaitFor, idempotencyAction(record(IdempotencyInProgress), "hash", now))
+	assert.Equal(t, idempotencyConflict, idempotencyAction(record(IdempotencyCompleted), "other", now))
+	assert.Equal(t, idempotencyConflict, idempotencyAction(record(IdempotencyInProgress), "other", now))
+
+	// Requête en échec, peut-être en partie appliquée : jamais exécutée à nouveau, même après le verrou
+	failed := record(IdempotencyFailed)
+	failed.LockedUntil = now.Add(-time.Second)
+	assert.Equal(t, idempotencyFailed, idempotencyAction(failed, "hash", now))
+	assert.Equal(t, idempotencyConflict, idempotencyAction(failed, "other", now))
+
+	// Requête interrompue (crash) : reprise après le verrou
+	dead := record(IdempotencyInProgress)
+	dead.LockedUntil = now.Add(-time.Second)
+	assert.Equal(t, idempotencyTakeOver, idempotencyAction(dead, "hash", now))
+
+	// Résultat expiré : la clé peut resservir, même pour une autre requête
+	expired := record(IdempotencyCompleted)
+	expired.ExpiresAt = now
+	assert.Equal(t, idempotencyTakeOver, idempotencyAction(expired, "hash", now))
+	assert.Equal(t, idempotencyTakeOver, idempotencyAction(expired, "other", now))
+}
+
+// TestGetIdempotencyTTL vérifie la durée de conservation des résultats
+func TestGetIdempotencyTTL(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TTL_HOURS", "")
+	assert.Equal(t, 24*time.Hour, getIdempotencyTTL())
+
+	t.Setenv("IDEMPOTENCY_TTL_HOURS", "48")
+	assert.Equal(t, 48*time.Hour, getIdempotencyTTL())
+
+	t.Setenv("IDEMPOTENCY_TTL_HOURS", "abc")
+	assert.Equal(t, 24*time.Hour, getIdempotencyTTL())
+}
@@ -333,6 +333,17 @@ func (db *DB) CompleteInventory(inventoryID string, adjustStock bool) (*Inventor
 		return nil, gqlerror.Errorf("Error completing inventory: %v", err)
 	}
 
+	// Flag the counted products for the low stock job
+	if adjustStock {
+		countedProductIDs := make([]primitive.ObjectID, 0, len(inventory.Items))
+		for _, item := range inventory.Items {
+			countedProductIDs = append(countedProductIDs, item.ProductID)
+		}
+		if err := db.MarkProductsForStockCheck(countedProductIDs); err != nil {
+			utils.LogError(err, fmt.Sprintf("Failed to flag products of inventory %s for stock check", inventory.ID.Hex()))
+		}
+	}
+
 	return &inventory, nil
 }
 
@@ -0,0 +1,308 @@
+package database
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Invitation codes: short enough to be typed from an SMS (xxxx-xxxx, same alphabet as the recovery codes)
+const (
+	InvitationValidity   = 7 * 24 * time.Hour
+	invitationCodeLength = 8
+)
+
+// Invitation status
+const (
+	InvitationStatusPending  = "pending"
+	InvitationStatusAccepted = "accepted"
+	InvitationStatusRevoked  = "revoked"
+	InvitationStatusExpired  = "expired"
+)
+
+// Invitation lets a person join a company with a preset role and store, with register
+// (new account) or acceptInvitation (existing account)
+type Invitation struct {
+	ID         primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	CompanyID  primitive.ObjectID  `bson:"companyId" json:"companyId"`
+	CodeHash   string              `bson:"codeHash" json:"-"`                      // sha256 du code normalisé
+	Phone      string              `bson:"phone,omitempty" json:"phone,omitempty"` // Seul ce numéro peut utiliser le code
+	Role       string              `bson:"role" json:"role"`                       // "Admin" or "User"
+	RoleID     *primitive.ObjectID `bson:"roleId,omitempty" json:"roleId,omitempty"`
+	StoreID    *primitive.ObjectID `bson:"storeId,omitempty" json:"storeId,omitempty"`
+	InvitedBy  primitive.ObjectID  `bson:"invitedBy" json:"invitedBy"`
+	SMSSent    bool                `bson:"smsSent" json:"smsSent"` // Code envoyé par SMS au numéro: l'utiliser prouve la possession du numéro
+	ExpiresAt  time.Time           `bson:"expiresAt" json:"expiresAt"`
+	AcceptedAt *time.Time          `bson:"acceptedAt,omitempty" json:"acceptedAt,omitempty"`
+	AcceptedBy *primitive.ObjectID `bson:"acceptedBy,omitempty" json:"acceptedBy,omitempty"`
+	RevokedAt  *time.Time          `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	CreatedAt  time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+// Status returns the status of the invitation at the given time
+func (i *Invitation) Status(now time.Time) string {
+	switch {
+	case i.AcceptedAt != nil:
+		return InvitationStatusAccepted
+	case i.RevokedAt != nil:
+		return InvitationStatusRevoked
+	case !i.ExpiresAt.After(now):
+		return InvitationStatusExpired
+	default:
+		return InvitationStatusPending
+	}
+}
+
+// generateInvitationCode returns a new invitation code
+func generateInvitationCode() (string, error) {
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	var code strings.Builder
+	for i := 0; i < invitationCodeLength; i++ {
+		if i == invitationCodeLength/2 {
+			code.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code.WriteByte(recoveryCodeAlphabet[n.Int64()])
+	}
+	return code.String(), nil
+}
+
+// hashInvitationCode hashes a typed code (case, spaces and dashes ignored)
+func hashInvitationCode(code string) string {
+	return hashAPIKey(normalizeRecoveryCode(code))
+}
+
+// CreateInvitation creates an invitation to the company and returns it with its code,
+// which is not stored and shown only once
+func (db *DB) CreateInvitation(companyID, invitedBy primitive.ObjectID, phone, role string, roleID, storeID *primitive.ObjectID) (*Invitation, string, error) {
+	if role != RoleAdmin && role != RoleUser {
+		return nil, "", utils.ValidationErrorf("Role must be Admin or User")
+	}
+	if role == RoleAdmin && (roleID != nil || storeID != nil) {
+		return nil, "", utils.ValidationErrorf("Admins have every permission and every store: no role or store to preset")
+	}
+	if roleID != nil {
+		if _, err := db.FindRoleByID(roleID.Hex(), companyID); err != nil {
+			return nil, "", err
+		}
+	}
+	if storeID != nil {
+		store, err := db.FindStoreByID(storeID.Hex())
+		if err != nil {
+			return nil, "", err
+		}
+		if store.CompanyID != companyID {
+			return nil, "", utils.ValidationErrorf("Store does not belong to your company")
+		}
+	}
+
+	phone = strings.TrimSpace(phone)
+	if phone != "" {
+		existing, err := db.FindUserByPhone(phone)
+		if err != nil {
+			return nil, "", utils.DatabaseErrorf("find_user", "Error finding user: %v", err)
+		}
+		if existing != nil {
+			if _, err := db.FindUserInCompany(existing.ID.Hex(), companyID); err == nil {
+				return nil, "", utils.ValidationErrorf("This user is already a member of the company")
+			}
+		}
+	}
+
+	code, err := generateInvitationCode()
+	if err != nil {
+		return nil, "", utils.DatabaseErrorf("generate_invitation_code", "Error generating invitation code: %v", err)
+	}
+
+	now := time.Now()
+	invitation := Invitation{
+		ID:        primitive.NewObjectID(),
+		CompanyID: companyID,
+		CodeHash:  hashInvitationCode(code),
+		Phone:     phone,
+		Role:      role,
+		RoleID:    roleID,
+		StoreID:   storeID,
+		InvitedBy: invitedBy,
+		ExpiresAt: now.Add(InvitationValidity),
+		CreatedAt: now,
+	}
+
+	invitationCollection := colHelper(db, "invitations")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err = invitationCollection.InsertOne(ctx, invitation)
+	if err != nil {
+		return nil, "", utils.DatabaseErrorf("create_invitation", "Error creating invitation: %v", err)
+	}
+
+	return &invitation, code, nil
+}
+
+// MarkInvitationSent records that the code was sent by SMS to the phone of the invitation
+func (db *DB) MarkInvitationSent(id primitive.ObjectID) error {
+	invitationCollection := colHelper(db, "invitations")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := invitationCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"smsSent": true}})
+	if err != nil {
+		return utils.DatabaseErrorf("mark_invitation_sent", "Error updating invitation: %v", err)
+	}
+
+	return nil
+}
+
+// FindInvitationsByCompanyID returns the invitations of the company, most recent first
+func (db *DB) FindInvitationsByCompanyID(companyID primitive.ObjectID) ([]*Invitation, error) {
+	invitationCollection := colHelper(db, "invitations")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	cursor, err := invitationCollection.Find(ctx, bson.M{"companyId": companyID}, opts)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_invitations", "Error finding invitations: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var invitations []*Invitation
+	if err = cursor.All(ctx, &invitations); err != nil {
+		return nil, utils.DatabaseErrorf("find_invitations", "Error decoding invitations: %v", err)
+	}
+
+	return invitations, nil
+}
+
+// RevokeInvitation revokes a pending invitation of the company
+func (db *DB) RevokeInvitation(id string, companyID primitive.ObjectID) (*Invitation, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, utils.ValidationErrorf("Invalid invitation ID")
+	}
+
+	invitationCollection := colHelper(db, "invitations")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var invitation Invitation
+	err = invitationCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": objectID, "companyId": companyID, "acceptedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&invitation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("Invitation not found or already accepted")
+		}
+		return nil, utils.DatabaseErrorf("revoke_invitation", "Error revoking invitation: %v", err)
+	}
+
+	return &invitation, nil
+}
+
+// FindPendingInvitation returns the pending invitation of a code, checked against the phone of the
+// person using it when the invitation was sent to a phone number
+func (db *DB) FindPendingInvitation(code, phone string) (*Invitation, error) {
+	invitationCollection := colHelper(db, "invitations")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var invitation Invitation
+	err := invitationCollection.FindOne(ctx, bson.M{"codeHash": hashInvitationCode(code)}).Decode(&invitation)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.ValidationErrorf("Invalid or expired invitation code")
+		}
+		return nil, utils.DatabaseErrorf("find_invitation", "Error finding invitation: %v", err)
+	}
+
+	if invitation.Status(time.Now()) != InvitationStatusPending {
+		return nil, utils.ValidationErrorf("Invalid or expired invitation code")
+	}
+	if invitation.Phone != "" && invitation.Phone != strings.TrimSpace(phone) {
+		return nil, utils.ValidationErrorf("This invitation was sent to another phone number")
+	}
+
+	return &invitation, nil
+}
+
+// AcceptInvitation adds the user to the company of the invitation with its role and store.
+// A user without company yet (just registered) makes it their active company
+func (db *DB) AcceptInvitation(code string, user *User) (*Membership, error) {
+	invitation, err := db.FindPendingInvitation(code, user.Phone)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.FindUserInCompany(user.ID.Hex(), invitation.CompanyID); err == nil {
+		return nil, utils.ValidationErrorf("You are already a member of this company")
+	}
+
+	membership := &Membership{
+		UserID:    user.ID,
+		CompanyID: invitation.CompanyID,
+		Role:      invitation.Role,
+		RoleID:    invitation.RoleID,
+		InvitedBy: &invitation.InvitedBy,
+	}
+	if invitation.Role == RoleAdmin {
+		// Like the other admins: every store of the company
+		stores, err := db.FindStoresByCompanyID(invitation.CompanyID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		for _, store := range stores {
+			membership.StoreIDs = append(membership.StoreIDs, store.ID)
+		}
+	} else if invitation.StoreID != nil {
+		membership.StoreIDs = []primitive.ObjectID{*invitation.StoreID}
+		membership.AssignedStoreID = invitation.StoreID
+	}
+
+	invitationCollection := colHelper(db, "invitations")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	// A code is used once, even by two concurrent requests
+	now := time.Now()
+	result, err := invitationCollection.UpdateOne(ctx,
+		bson.M{
+			"_id":        invitation.ID,
+			"acceptedAt": bson.M{"$exists": false},
+			"revokedAt":  bson.M{"$exists": false},
+			"expiresAt":  bson.M{"$gt": now},
+		},
+		bson.M{"$set": bson.M{"acceptedAt": now, "acceptedBy": user.ID}},
+	)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("accept_invitation", "Error accepting invitation: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, utils.ValidationErrorf("Invalid or expired invitation code")
+	}
+
+	if err := db.AddMembership(membership); err != nil {
+		return nil, err
+	}
+
+	if user.CompanyID.IsZero() {
+		if _, err := db.SwitchActiveMembership(user.ID, invitation.CompanyID); err != nil {
+			return nil, err
+		}
+	}
+
+	return membership, nil
+}
@@ -0,0 +1,35 @@
+package database
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGenerateInvitationCode vérifie le format des codes et leur saisie sans tiret ni majuscules
+func TestGenerateInvitationCode(t *testing.T) {
+	code, err := generateInvitationCode()
+	assert.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^[a-z2-9]{4}-[a-z2-9]{4}$`), code)
+
+	typed := strings.ToUpper(strings.ReplaceAll(code, "-", " "))
+	assert.Equal(t, hashInvitationCode(code), hashInvitationCode(typed))
+
+	other, err := generateInvitationCode()
+	assert.NoError(t, err)
+	assert.NotEqual(t, hashInvitationCode(code), hashInvitationCode(other))
+}
+
+// TestInvitationStatus vérifie le statut d'une invitation: acceptée, révoquée, expirée ou en attente
+func TestInvitationStatus(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+
+	assert.Equal(t, InvitationStatusPending, (&Invitation{ExpiresAt: now.Add(time.Hour)}).Status(now))
+	assert.Equal(t, InvitationStatusExpired, (&Invitation{ExpiresAt: past}).Status(now))
+	assert.Equal(t, InvitationStatusRevoked, (&Invitation{ExpiresAt: now.Add(time.Hour), RevokedAt: &past}).Status(now))
+	assert.Equal(t, InvitationStatusAccepted, (&Invitation{ExpiresAt: past, AcceptedAt: &past}).Status(now))
+}
@@ -0,0 +1,292 @@
+package database
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"rangoapp/utils"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Kinds of login attempt counters: failures are tracked per phone number, per phone number and
+// IP address, and per IP address. Only the last two lock: nobody can lock a user out from
+// their own address, the phone counter only slows the attempts down
+const (
+	LoginAttemptPhone   = "phone"
+	LoginAttemptPhoneIP = "phone_ip"
+	LoginAttemptIP      = "ip"
+)
+
+const (
+	LoginFailureWindow       = 15 * time.Minute // Les échecs plus anciens ne comptent plus
+	LoginDelayAfterFailures  = 3                // Délai progressif à partir du 3e échec
+	LoginMaxRetryDelay       = 30 * time.Second
+	LoginMaxFailuresPerPhone = 5 // Par numéro depuis une même IP
+	LoginMaxFailuresPerIP    = 20
+	LoginLockoutDuration     = 15 * time.Minute // Levé plus tôt par unblockUser
+)
+
+// LoginAttempt counts the recent login failures of a phone number, a phone number from an IP address
+// or an IP address
+type LoginAttempt struct {
+	Key           string     `bson:"_id" json:"key"` // "phone:+243...", "phone_ip:+243...|1.2.3.4" ou "ip:1.2.3.4"
+	Kind          string     `bson:"kind" json:"kind"`
+	Value         string     `bson:"value" json:"value"`
+	Phone         string     `bson:"phone,omitempty" json:"phone,omitempty"` // Numéro des compteurs phone et phone_ip
+	Failures      int        `bson:"failures" json:"failures"`
+	LastFailureAt time.Time  `bson:"lastFailureAt" json:"lastFailureAt"` // Index TTL
+	LockedUntil   *time.Time `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"`
+}
+
+// LoginLockout is returned by RecordLoginFailure when a failure locks a phone number from an IP address
+// or an IP address
+type LoginLockout struct {
+	Kind        string
+	Value       string
+	Failures    int
+	LockedUntil time.Time
+}
+
+func loginAttemptKey(kind, value string) string {
+	return kind + ":" + value
+}
+
+// loginCounter is a counter of the failures of a login attempt, locked after maxFailures (0: never)
+type loginCounter struct {
+	kind        string
+	value       string
+	phone       string
+	maxFailures int
+}
+
+func (c loginCounter) key() string {
+	return loginAttemptKey(c.kind, c.value)
+}
+
+// loginCounters returns the counters of a login attempt: the phone number, the phone number
+// from the IP address and the IP address (without IP, outside HTTP requests, the first two)
+func loginCounters(phone, ip string) []loginCounter {
+	counters := []loginCounter{
+		{kind: LoginAttemptPhone, value: phone, phone: phone},
+		{kind: LoginAttemptPhoneIP, value: phone + "|" + ip, phone: phone, maxFailures: LoginMaxFailuresPerPhone},
+	}
+	if ip != "" {
+		counters = append(counters, loginCounter{kind: LoginAttemptIP, value: ip, maxFailures: LoginMaxFailuresPerIP})
+	}
+	return counters
+}
+
+// loginRetryDelay is the minimum delay before the next attempt after the given number of failures:
+// none for the first ones, then 1s, 2s, 4s... up to LoginMaxRetryDelay
+func loginRetryDelay(failures int) time.Duration {
+	if failures < LoginDelayAfterFailures {
+		return 0
+	}
+	exponent := failures - LoginDelayAfterFailures
+	if exponent > 10 {
+		return LoginMaxRetryDelay
+	}
+	delay := time.Duration(math.Pow(2, float64(exponent))) * time.Second
+	if delay > LoginMaxRetryDelay {
+		return LoginMaxRetryDelay
+	}
+	return delay
+}
+
+// loginWaitTime returns how long the counter forbids a new attempt at now (0: allowed)
+func loginWaitTime(attempt *LoginAttempt, now time.Time) time.Duration {
+	if attempt.LockedUntil != nil && attempt.LockedUntil.After(now) {
+		return attempt.LockedUntil.Sub(now)
+	}
+	if now.Sub(attempt.LastFailureAt) > LoginFailureWindow {
+		return 0
+	}
+	if wait := attempt.LastFailureAt.Add(loginRetryDelay(attempt.Failures)).Sub(now); wait > 0 {
+		return wait
+	}
+	return 0
+}
+
+func tooManyLoginAttempts(wait time.Duration, locked bool) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	message := fmt.Sprintf("Too many failed attempts, please retry in %d seconds", seconds)
+	if locked {
+		message = fmt.Sprintf("Too many failed attempts, login is locked for %d minutes", int(math.Ceil(wait.Minutes())))
+	}
+	return &gqlerror.Error{
+		Message:    message,
+		Extensions: map[string]interface{}{"code": "TOO_MANY_ATTEMPTS", "retryAfter": seconds},
+	}
+}
+
+// ReserveLoginAttempt refuses a login attempt while the phone number from the IP address or the IP
+// address is locked, or a progressive delay is not elapsed. Otherwise the attempt is counted as a
+// failure of the phone number before the credentials are checked (a successful login resets it):
+// the counter is updated only if it did not change since it was read, so concurrent attempts
+// cannot all pass the same check
+func (db *DB) ReserveLoginAttempt(phone, ip string) error {
+	loginAttemptCollection := colHelper(db, "login_attempts")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	counters := loginCounters(phone, ip)
+	var keys []string
+	for _, counter := range counters {
+		keys = append(keys, counter.key())
+	}
+
+	cursor, err := loginAttemptCollection.Find(ctx, bson.M{"_id": bson.M{"$in": keys}})
+	if err != nil {
+		return utils.DatabaseErrorf("reserve_login_attempt", "Error finding login attempts: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var attempts []LoginAttempt
+	if err = cursor.All(ctx, &attempts); err != nil {
+		return utils.DatabaseErrorf("reserve_login_attempt", "Error decoding login attempts: %v", err)
+	}
+
+	now := time.Now()
+	var phoneAttempt *LoginAttempt
+	for i := range attempts {
+		if wait := loginWaitTime(&attempts[i], now); wait > 0 {
+			locked := attempts[i].LockedUntil != nil && attempts[i].LockedUntil.After(now)
+			return tooManyLoginAttempts(wait, locked)
+		}
+		if attempts[i].Key == counters[0].key() {
+			phoneAttempt = &attempts[i]
+		}
+	}
+
+	// Conditional upsert on the phone counter as read: a concurrent attempt changed it (or created it)
+	// when nothing matches, and the insert then fails on the duplicate key
+	filter := bson.M{"_id": counters[0].key(), "failures": bson.M{"$exists": false}}
+	failures := 1
+	if phoneAttempt != nil {
+		filter = bson.M{"_id": phoneAttempt.Key, "failures": phoneAttempt.Failures, "lastFailureAt": phoneAttempt.LastFailureAt}
+		if now.Sub(phoneAttempt.LastFailureAt) <= LoginFailureWindow {
+			failures = phoneAttempt.Failures + 1
+		}
+	}
+	_, err = loginAttemptCollection.UpdateOne(ctx, filter,
+		bson.M{"$set": bson.M{
+			"kind":          counters[0].kind,
+			"value":         counters[0].value,
+			"phone":         counters[0].phone,
+			"failures":      failures,
+			"lastFailureAt": now,
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return tooManyLoginAttempts(time.Second, false)
+	}
+	if err != nil {
+		return utils.DatabaseErrorf("reserve_login_attempt", "Error reserving login attempt: %v", err)
+	}
+
+	return nil
+}
+
+// RecordLoginFailure counts a failed login for the phone number from the IP address and the IP address
+// (ReserveLoginAttempt already counted it for the phone number). It returns the lockouts started by
+// this failure (at most one per counter)
+func (db *DB) RecordLoginFailure(phone, ip string) ([]LoginLockout, error) {
+	var lockouts []LoginLockout
+	for _, counter := range loginCounters(phone, ip)[1:] {
+		lockout, err := db.recordLoginFailure(counter)
+		if err != nil {
+			return lockouts, err
+		}
+		if lockout != nil {
+			lockouts = append(lockouts, *lockout)
+		}
+	}
+
+	return lockouts, nil
+}
+
+func (db *DB) recordLoginFailure(counter loginCounter) (*LoginLockout, error) {
+	loginAttemptCollection := colHelper(db, "login_attempts")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	key := counter.key()
+
+	// Pipeline update: the counter restarts when the previous failure is out of the window
+	var attempt LoginAttempt
+	err := loginAttemptCollection.FindOneAndUpdate(ctx,
+		bson.M{"_id": key},
+		mongo.Pipeline{
+			{{Key: "$set", Value: bson.M{
+				"kind":  counter.kind,
+				"value": counter.value,
+				"phone": counter.phone,
+				"failures": bson.M{"$cond": bson.A{
+					bson.M{"$gt": bson.A{"$lastFailureAt", now.Add(-LoginFailureWindow)}},
+					bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}},
+					1,
+				}},
+				"lastFailureAt": now,
+			}}},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&attempt)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("record_login_failure", "Error recording login failure: %v", err)
+	}
+
+	if counter.maxFailures == 0 || attempt.Failures < counter.maxFailures {
+		return nil, nil
+	}
+
+	// Conditional update: concurrent failures start a single lockout
+	lockedUntil := now.Add(LoginLockoutDuration)
+	result, err := loginAttemptCollection.UpdateOne(ctx,
+		bson.M{"_id": key, "$or": bson.A{
+			bson.M{"lockedUntil": bson.M{"$exists": false}},
+			bson.M{"lockedUntil": bson.M{"$lte": now}},
+		}},
+		bson.M{"$set": bson.M{"lockedUntil": lockedUntil}},
+	)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("record_login_failure", "Error locking login: %v", err)
+	}
+	if result.ModifiedCount == 0 {
+		return nil, nil
+	}
+
+	if counter.kind == LoginAttemptPhoneIP {
+		userCollection := colHelper(db, "users")
+		_, err = userCollection.UpdateOne(ctx, bson.M{"phone": counter.phone}, bson.M{"$set": bson.M{"lockedUntil": lockedUntil}})
+		if err != nil {
+			utils.LogError(err, "Failed to record lockout on user")
+		}
+	}
+
+	return &LoginLockout{Kind: counter.kind, Value: counter.value, Failures: attempt.Failures, LockedUntil: lockedUntil}, nil
+}
+
+// ResetLoginFailures clears the failures and the lockouts of a phone number, from every IP address
+// (successful login, unblockUser)
+func (db *DB) ResetLoginFailures(phone string) error {
+	loginAttemptCollection := colHelper(db, "login_attempts")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := loginAttemptCollection.DeleteMany(ctx, bson.M{"$or": bson.A{
+		bson.M{"_id": loginAttemptKey(LoginAttemptPhone, phone)},
+		bson.M{"phone": phone},
+	}})
+	if err != nil {
+		return utils.DatabaseErrorf("reset_login_failures", "Error resetting login failures: %v", err)
+	}
+
+	return nil
+}
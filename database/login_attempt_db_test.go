@@ -0,0 +1,104 @@
+package database
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// TestLoginRetryDelay vérifie le délai progressif entre deux tentatives
+func TestLoginRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), loginRetryDelay(1))
+	assert.Equal(t, time.Duration(0), loginRetryDelay(LoginDelayAfterFailures-1))
+	assert.Equal(t, time.Second, loginRetryDelay(LoginDelayAfterFailures))
+	assert.Equal(t, 2*time.Second, loginRetryDelay(LoginDelayAfterFailures+1))
+	assert.Equal(t, 4*time.Second, loginRetryDelay(LoginDelayAfterFailures+2))
+	assert.Equal(t, LoginMaxRetryDelay, loginRetryDelay(LoginDelayAfterFailures+10))
+	assert.Equal(t, LoginMaxRetryDelay, loginRetryDelay(1000))
+}
+
+// TestLoginWaitTime vérifie le verrouillage, le délai progressif et la fenêtre des échecs
+func TestLoginWaitTime(t *testing.T) {
+	now := time.Now()
+
+	// Verrouillé
+	lockedUntil := now.Add(10 * time.Minute)
+	locked := &LoginAttempt{Failures: LoginMaxFailuresPerPhone, LastFailureAt: now, LockedUntil: &lockedUntil}
+	assert.Equal(t, 10*time.Minute, loginWaitTime(locked, now))
+
+	// Verrouillage terminé, échecs hors de la fenêtre
+	expired := now.Add(-time.Minute)
+	old := &LoginAttempt{Failures: LoginMaxFailuresPerPhone, LastFailureAt: now.Add(-LoginFailureWindow - time.Minute), LockedUntil: &expired}
+	assert.Equal(t, time.Duration(0), loginWaitTime(old, now))
+
+	// Délai progressif en cours puis écoulé
+	recent := &LoginAttempt{Failures: LoginDelayAfterFailures + 1, LastFailureAt: now.Add(-time.Second)}
+	assert.Equal(t, time.Second, loginWaitTime(recent, now))
+	assert.Equal(t, time.Duration(0), loginWaitTime(recent, now.Add(2*time.Second)))
+
+	// Premiers échecs: pas de délai
+	first := &LoginAttempt{Failures: 1, LastFailureAt: now}
+	assert.Equal(t, time.Duration(0), loginWaitTime(first, now))
+}
+
+// TestLoginCounters vérifie que seuls les compteurs numéro + IP et IP verrouillent la connexion
+func TestLoginCounters(t *testing.T) {
+	counters := loginCounters("+243810000001", "198.51.100.1")
+	assert.Equal(t, []loginCounter{
+		{kind: LoginAttemptPhone, value: "+243810000001", phone: "+243810000001"},
+		{kind: LoginAttemptPhoneIP, value: "+243810000001|198.51.100.1", phone: "+243810000001", maxFailures: LoginMaxFailuresPerPhone},
+		{kind: LoginAttemptIP, value: "198.51.100.1", maxFailures: LoginMaxFailuresPerIP},
+	}, counters)
+	assert.Equal(t, "phone_ip:+243810000001|198.51.100.1", counters[1].key())
+
+	assert.Len(t, loginCounters("+243810000001", ""), 2, "Without IP only the phone counters apply")
+}
+
+// TestReserveLoginAttemptConcurrent vérifie que des tentatives simultanées ne passent pas toutes le délai progressif
+func TestReserveLoginAttemptConcurrent(t *testing.T) {
+	db := setupTestDB(t)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := db.database.Collection("login_attempts").Drop(ctx); err != nil {
+			t.Logf("Warning: Failed to drop test collection: %v", err)
+		}
+		if err := db.client.Disconnect(ctx); err != nil {
+			t.Logf("Warning: Failed to disconnect test client: %v", err)
+		}
+	})
+	phone := "+243810000099"
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	reserved := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := db.ReserveLoginAttempt(phone, "198.51.100.9"); err == nil {
+				mu.Lock()
+				reserved++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Chaque tentative acceptée est comptée, et le délai progressif limite celles qui passent
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var attempt LoginAttempt
+	require.NoError(t, db.database.Collection("login_attempts").FindOne(ctx, bson.M{"_id": loginAttemptKey(LoginAttemptPhone, phone)}).Decode(&attempt))
+	assert.Equal(t, reserved, attempt.Failures)
+	assert.LessOrEqual(t, reserved, LoginDelayAfterFailures)
+
+	// Une connexion réussie remet le compteur à zéro
+	require.NoError(t, db.ResetLoginFailures(phone))
+	assert.NoError(t, db.ReserveLoginAttempt(phone, "198.51.100.9"))
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"sort"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// lotAllocation is the quantity taken from one lot (ProductInStock) of a product
+type lotAllocation struct {
+	lot      *ProductInStock
+	quantity float64
+}
+
+// sortLotsForAllocation orders lots FEFO (earliest expiry first, lots without expiry last), then FIFO (oldest first)
+func sortLotsForAllocation(lots []*ProductInStock) {
+	sort.SliceStable(lots, func(i, j int) bool {
+		a, b := lots[i], lots[j]
+		if a.ExpiryDate != nil && b.ExpiryDate != nil && !a.ExpiryDate.Equal(*b.ExpiryDate) {
+			return a.ExpiryDate.Before(*b.ExpiryDate)
+		}
+		if (a.ExpiryDate == nil) != (b.ExpiryDate == nil) {
+			return a.ExpiryDate != nil
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+}
+
+// lotsInCurrency keeps the lots priced in the currency of the sale: the sale price and the margin
+// of each line are in that currency
+func lotsInCurrency(lots []*ProductInStock, currency string) []*ProductInStock {
+	kept := make([]*ProductInStock, 0, len(lots))
+	for _, lot := range lots {
+		if lot.Currency == currency {
+			kept = append(kept, lot)
+		}
+	}
+	return kept
+}
+
+// allocateLots takes the quantity from the lots in order, skipping what is already reserved on each lot
+// It returns the allocations and the quantity that could not be allocated
+func allocateLots(lots []*ProductInStock, quantity float64, reserved map[primitive.ObjectID]float64) ([]lotAllocation, float64) {
+	var allocations []lotAllocation
+	remaining := quantity
+	for _, lot := range lots {
+		if remaining <= 0 {
+			break
+		}
+		available := lot.Stock - reserved[lot.ID]
+		if available <= 0 {
+			continue
+		}
+		take := available
+		if remaining < take {
+			take = remaining
+		}
+		allocations = append(allocations, lotAllocation{lot: lot, quantity: take})
+		remaining -= take
+	}
+	return allocations, remaining
+}
+
+// AllocateProductLots splits the quantity of a product over its lots in the store (FEFO, then FIFO)
+// and returns one basket line per lot consumed, all sold at the given price.
+// Only the lots in the currency of the sale are used.
+// Quantities the basket already takes from a lot are not allocated twice.
+func (db *DB) AllocateProductLots(storeID, productID primitive.ObjectID, quantity, price float64, currency string, basket []ProductInBasket) ([]ProductInBasket, error) {
+	product, err := db.FindProductByID(productID.Hex())
+	if err != nil {
+		return nil, utils.NotFoundErrorf("Product not found: %s", productID.Hex())
+	}
+	if product.StoreID != storeID {
+		return nil, utils.ValidationErrorf("Product %s does not belong to the specified store", productID.Hex())
+	}
+
+	lots, err := db.FindSellableProductsInStock(productID, storeID)
+	if err != nil {
+		return nil, err
+	}
+	lots = lotsInCurrency(lots, currency)
+	sortLotsForAllocation(lots)
+
+	reserved := make(map[primitive.ObjectID]float64)
+	for _, item := range basket {
+		reserved[item.ProductInStockID] += item.Quantity
+	}
+
+	allocations, remaining := allocateLots(lots, quantity, reserved)
+	if remaining > 0 {
+		return nil, utils.ValidationErrorf("Insufficient stock for product %s in %s: %.2f missing", product.Name, currency, remaining)
+	}
+
+	lines := make([]ProductInBasket, 0, len(allocations))
+	for _, allocation := range allocations {
+		lines = append(lines, ProductInBasket{
+			ProductInStockID: allocation.lot.ID,
+			Quantity:         allocation.quantity,
+			Price:            price,
+			ProductID:        &productID,
+		})
+	}
+	return lines, nil
+}
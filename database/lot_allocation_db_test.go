@@ -0,0 +1,67 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestSortLotsForAllocation vérifie l'ordre FEFO puis FIFO des lots
+func TestSortLotsForAllocation(t *testing.T) {
+	now := time.Now()
+	soon := now.AddDate(0, 1, 0)
+	later := now.AddDate(0, 6, 0)
+
+	oldNoExpiry := &ProductInStock{ID: primitive.NewObjectID(), CreatedAt: now.AddDate(0, -2, 0)}
+	newNoExpiry := &ProductInStock{ID: primitive.NewObjectID(), CreatedAt: now}
+	expiresLater := &ProductInStock{ID: primitive.NewObjectID(), CreatedAt: now.AddDate(0, -3, 0), ExpiryDate: &later}
+	expiresSoon := &ProductInStock{ID: primitive.NewObjectID(), CreatedAt: now, ExpiryDate: &soon}
+
+	lots := []*ProductInStock{newNoExpiry, expiresLater, oldNoExpiry, expiresSoon}
+	sortLotsForAllocation(lots)
+
+	assert.Equal(t, []*ProductInStock{expiresSoon, expiresLater, oldNoExpiry, newNoExpiry}, lots)
+}
+
+// TestLotsInCurrency vérifie que seuls les lots dans la devise de la vente sont alloués
+func TestLotsInCurrency(t *testing.T) {
+	usd := &ProductInStock{ID: primitive.NewObjectID(), Currency: "USD"}
+	cdf := &ProductInStock{ID: primitive.NewObjectID(), Currency: "CDF"}
+	usdNewer := &ProductInStock{ID: primitive.NewObjectID(), Currency: "USD"}
+
+	assert.Equal(t, []*ProductInStock{usd, usdNewer}, lotsInCurrency([]*ProductInStock{usd, cdf, usdNewer}, "USD"))
+	assert.Equal(t, []*ProductInStock{cdf}, lotsInCurrency([]*ProductInStock{usd, cdf, usdNewer}, "CDF"))
+	assert.Empty(t, lotsInCurrency([]*ProductInStock{usd, cdf}, "EUR"))
+}
+
+// TestAllocateLots vérifie la répartition d'une quantité sur plusieurs lots
+func TestAllocateLots(t *testing.T) {
+	lotA := &ProductInStock{ID: primitive.NewObjectID(), Stock: 3, PriceAchat: 10}
+	lotB := &ProductInStock{ID: primitive.NewObjectID(), Stock: 5, PriceAchat: 12}
+	lots := []*ProductInStock{lotA, lotB}
+
+	t.Run("Spans two lots", func(t *testing.T) {
+		allocations, remaining := allocateLots(lots, 4, nil)
+		assert.Equal(t, 0.0, remaining)
+		assert.Len(t, allocations, 2)
+		assert.Equal(t, lotA, allocations[0].lot)
+		assert.Equal(t, 3.0, allocations[0].quantity)
+		assert.Equal(t, lotB, allocations[1].lot)
+		assert.Equal(t, 1.0, allocations[1].quantity)
+	})
+
+	t.Run("Skips quantities already in the basket", func(t *testing.T) {
+		reserved := map[primitive.ObjectID]float64{lotA.ID: 3}
+		allocations, remaining := allocateLots(lots, 2, reserved)
+		assert.Equal(t, 0.0, remaining)
+		assert.Len(t, allocations, 1)
+		assert.Equal(t, lotB, allocations[0].lot)
+	})
+
+	t.Run("Insufficient stock", func(t *testing.T) {
+		_, remaining := allocateLots(lots, 10, nil)
+		assert.Equal(t, 2.0, remaining)
+	})
+}
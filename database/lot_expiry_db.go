@@ -0,0 +1,196 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExpiredLotWriteOffReason is the movement reason recorded when an expired lot is written off
+const ExpiredLotWriteOffReason = "expired"
+
+// FindExpiringProductsInStock finds the lots with stock that expire before the given date
+// (already expired lots included), soonest expiry first
+func (db *DB) FindExpiringProductsInStock(storeIDs []primitive.ObjectID, before time.Time) ([]*ProductInStock, error) {
+	productInStockCollection := colHelper(db, "products_in_stock")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := bson.M{
+		"storeId":    bson.M{"$in": storeIDs},
+		"stock":      bson.M{"$gt": 0},
+		"expiryDate": bson.M{"$ne": nil, "$lte": before},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "expiryDate", Value: 1}})
+
+	cursor, err := productInStockCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_expiring_stock", "Error finding expiring products in stock: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var productsInStock []*ProductInStock
+	if err = cursor.All(ctx, &productsInStock); err != nil {
+		return nil, utils.DatabaseErrorf("find_expiring_stock", "Error decoding products in stock: %v", err)
+	}
+
+	return productsInStock, nil
+}
+
+// SetLotExpiryWriteOff opts a lot in (or out) of the automatic write-off done by WriteOffExpiredLots
+func (db *DB) SetLotExpiryWriteOff(productInStockID string, enabled bool, adminID primitive.ObjectID) (*ProductInStock, error) {
+	productInStock, err := db.FindProductInStockByID(productInStockID)
+	if err != nil {
+		return nil, err
+	}
+	if enabled && productInStock.ExpiryDate == nil {
+		return nil, utils.ValidationErrorf("This lot has no expiry date")
+	}
+
+	productInStockCollection := colHelper(db, "products_in_stock")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	update := bson.M{"$set": bson.M{"writeOffExpired": true, "writeOffBy": adminID, "updatedAt": time.Now()}}
+	if !enabled {
+		update = bson.M{
+			"$set":   bson.M{"updatedAt": time.Now()},
+			"$unset": bson.M{"writeOffExpired": "", "writeOffBy": ""},
+		}
+	}
+
+	_, err = productInStockCollection.UpdateOne(ctx, bson.M{"_id": productInStock.ID}, update)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("update_product_in_stock", "Error updating product in stock: %v", err)
+	}
+
+	return db.FindProductInStockByID(productInStockID)
+}
+
+// WriteOffExpiredLots empties the expired lots that were opted in for write-off and records
+// an AJUSTEMENT movement (reason "expired") for each one. It returns the number of lots written off.
+func (db *DB) WriteOffExpiredLots() (int, error) {
+	productInStockCollection := colHelper(db, "products_in_stock")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	cursor, err := productInStockCollection.Find(ctx, bson.M{
+		"writeOffExpired": true,
+		"stock":           bson.M{"$gt": 0},
+		"expiryDate":      bson.M{"$lte": now},
+	})
+	if err != nil {
+		return 0, utils.DatabaseErrorf("find_expired_lots", "Error finding expired lots: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var lots []*ProductInStock
+	if err = cursor.All(ctx, &lots); err != nil {
+		return 0, utils.DatabaseErrorf("find_expired_lots", "Error decoding expired lots: %v", err)
+	}
+
+	count := 0
+	for _, lot := range lots {
+		if err := db.writeOffLot(lot, now); err != nil {
+			utils.LogError(err, fmt.Sprintf("Failed to write off expired lot %s", lot.ID.Hex()))
+			continue
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// writeOffLot sets the stock of the lot to zero and records the matching AJUSTEMENT movement
+func (db *DB) writeOffLot(lot *ProductInStock, now time.Time) error {
+	operatorID := primitive.NilObjectID
+	if lot.WriteOffBy != nil {
+		operatorID = *lot.WriteOffBy
+	}
+
+	// Start MongoDB transaction
+	session, err := db.client.StartSession()
+	if err != nil {
+		return utils.DatabaseErrorf("start_session", "Error starting transaction session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	txCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	movement := StockMovement{
+		ID:            primitive.NewObjectID(),
+		ProductID:     lot.ProductID,
+		StoreID:       lot.StoreID,
+		Type:          StockMovementTypeAjustement,
+		Quantity:      lot.Stock,
+		UnitPrice:     lot.PriceAchat,
+		TotalValue:    lot.Stock * lot.PriceAchat,
+		Currency:      lot.Currency,
+		Reason:        ExpiredLotWriteOffReason,
+		Reference:     fmt.Sprintf("expiry-%s", lot.ID.Hex()),
+		ReferenceType: "ADJUSTMENT",
+		ReferenceID:   &lot.ID,
+		OperatorID:    operatorID,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+
+	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
+		// Start transaction
+		if err := session.StartTransaction(); err != nil {
+			return utils.DatabaseErrorf("start_transaction", "Error starting transaction: %v", err)
+		}
+
+		// Collections
+		productInStockCollection := colHelper(db, "products_in_stock")
+		stockMovementCollection := colHelper(db, "stock_movements")
+
+		// The stock guard skips the lot if it was sold or adjusted since it was read
+		result, err := productInStockCollection.UpdateOne(
+			sc,
+			bson.M{"_id": lot.ID, "stock": lot.Stock},
+			bson.M{"$set": bson.M{"stock": 0.0, "writtenOffAt": now, "updatedAt": now}},
+		)
+		if err != nil {
+			return utils.DatabaseErrorf("update_product_stock", "Error writing off product in stock %s: %v", lot.ID.Hex(), err)
+		}
+		if result.MatchedCount == 0 {
+			return utils.ValidationErrorf("Stock of lot %s changed during write-off", lot.ID.Hex())
+		}
+
+		if _, err := stockMovementCollection.InsertOne(sc, movement); err != nil {
+			return utils.DatabaseErrorf("create_stock_movement", "Error creating stock movement for lot %s: %v", lot.ID.Hex(), err)
+		}
+
+		// Commit transaction
+		if err := session.CommitTransaction(sc); err != nil {
+			return utils.DatabaseErrorf("commit_transaction", "Error committing transaction: %v", err)
+		}
+
+		return nil
+	})
+
+	// Handle transaction errors
+	// MongoDB automatically aborts the transaction if an error occurs in WithSession
+	if err != nil {
+		return err
+	}
+
+	// Flag the product for the low stock job (best effort, the write-off is already recorded)
+	if err := db.MarkProductsForStockCheck([]primitive.ObjectID{lot.ProductID}); err != nil {
+		utils.LogError(err, fmt.Sprintf("Failed to flag product of lot %s for stock check", lot.ID.Hex()))
+	}
+
+	// Notify the subscriptions (dashboards) now that the write-off is committed
+	db.publishCommitted(nil, []*StockMovement{&movement})
+	return nil
+}
@@ -0,0 +1,32 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestProductInStockIsExpired vérifie la détection des lots périmés
+func TestProductInStockIsExpired(t *testing.T) {
+	now := time.Now()
+	yesterday := now.AddDate(0, 0, -1)
+	tomorrow := now.AddDate(0, 0, 1)
+
+	assert.False(t, (&ProductInStock{}).IsExpired(now), "A lot without expiry date never expires")
+	assert.True(t, (&ProductInStock{ExpiryDate: &yesterday}).IsExpired(now))
+	assert.True(t, (&ProductInStock{ExpiryDate: &now}).IsExpired(now), "A lot expires on its expiry date")
+	assert.False(t, (&ProductInStock{ExpiryDate: &tomorrow}).IsExpired(now))
+}
+
+// TestLotFilter vérifie que les lots sans numéro ni péremption correspondent aux champs absents
+func TestLotFilter(t *testing.T) {
+	filter := lotFilter("", nil)
+	assert.Nil(t, filter["batchNumber"])
+	assert.Nil(t, filter["expiryDate"])
+
+	expiry := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	filter = lotFilter("LOT-42", &expiry)
+	assert.Equal(t, "LOT-42", filter["batchNumber"])
+	assert.Equal(t, expiry, filter["expiryDate"])
+}
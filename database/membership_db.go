@@ -0,0 +1,359 @@
+package database
+
+import (
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Membership is the place of a user in a company: built-in role, company role and stores.
+// A user can belong to several companies (an accountant serving several shops); the company
+// fields of the User document mirror the active membership, the one of the last login or switchCompany
+type Membership struct {
+	ID              primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	UserID          primitive.ObjectID   `bson:"userId" json:"userId"`
+	CompanyID       primitive.ObjectID   `bson:"companyId" json:"companyId"`
+	Role            string               `bson:"role" json:"role"` // "Admin" or "User"
+	RoleID          *primitive.ObjectID  `bson:"roleId,omitempty" json:"roleId,omitempty"`
+	StoreIDs        []primitive.ObjectID `bson:"storeIds" json:"storeIds"`
+	AssignedStoreID *primitive.ObjectID  `bson:"assignedStoreId,omitempty" json:"assignedStoreId,omitempty"`
+	InvitedBy       *primitive.ObjectID  `bson:"invitedBy,omitempty" json:"invitedBy,omitempty"` // Absent pour le créateur de l'entreprise
+	CreatedAt       time.Time            `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+// membershipFromUser returns the membership mirrored by the company fields of the user
+func membershipFromUser(user *User) *Membership {
+	storeIDs := user.StoreIDs
+	if storeIDs == nil {
+		storeIDs = []primitive.ObjectID{}
+	}
+	return &Membership{
+		UserID:          user.ID,
+		CompanyID:       user.CompanyID,
+		Role:            user.Role,
+		RoleID:          user.RoleID,
+		StoreIDs:        storeIDs,
+		AssignedStoreID: user.AssignedStoreID,
+		CreatedAt:       user.CreatedAt,
+		UpdatedAt:       user.UpdatedAt,
+	}
+}
+
+// applyMembership replaces the company fields of the user by those of the membership
+func (u *User) applyMembership(m *Membership) {
+	u.CompanyID = m.CompanyID
+	u.Role = m.Role
+	u.RoleID = m.RoleID
+	u.StoreIDs = m.StoreIDs
+	u.AssignedStoreID = m.AssignedStoreID
+}
+
+// membershipUpdate returns the update writing the company fields of the membership,
+// the same in the memberships and users collections
+func membershipUpdate(m *Membership, now time.Time) bson.M {
+	storeIDs := m.StoreIDs
+	if storeIDs == nil {
+		storeIDs = []primitive.ObjectID{}
+	}
+	set := bson.M{
+		"companyId": m.CompanyID,
+		"role":      m.Role,
+		"storeIds":  storeIDs,
+		"updatedAt": now,
+	}
+	unset := bson.M{}
+	if m.RoleID != nil {
+		set["roleId"] = *m.RoleID
+	} else {
+		unset["roleId"] = ""
+	}
+	if m.AssignedStoreID != nil {
+		set["assignedStoreId"] = *m.AssignedStoreID
+	} else {
+		unset["assignedStoreId"] = ""
+	}
+
+	update := bson.M{"$set": set}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	return update
+}
+
+// AddMembership adds the user to a company
+func (db *DB) AddMembership(m *Membership) error {
+	now := time.Now()
+	m.ID = primitive.NewObjectID()
+	m.CreatedAt = now
+	m.UpdatedAt = now
+	if m.StoreIDs == nil {
+		m.StoreIDs = []primitive.ObjectID{}
+	}
+
+	membershipCollection := colHelper(db, "memberships")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := membershipCollection.InsertOne(ctx, m)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return utils.ValidationErrorf("User is already a member of this company")
+		}
+		return utils.DatabaseErrorf("add_membership", "Error adding membership: %v", err)
+	}
+
+	return nil
+}
+
+// ensureMembership creates the membership of the active company of a user who has none yet
+// (users created before memberships); an existing membership is left unchanged
+func (db *DB) ensureMembership(user *User) error {
+	if user.CompanyID.IsZero() {
+		return nil
+	}
+	m := membershipFromUser(user)
+
+	membershipCollection := colHelper(db, "memberships")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := membershipCollection.UpdateOne(ctx,
+		bson.M{"userId": m.UserID, "companyId": m.CompanyID},
+		bson.M{"$setOnInsert": m},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return utils.DatabaseErrorf("ensure_membership", "Error creating membership: %v", err)
+	}
+
+	return nil
+}
+
+// FindMembership returns the membership of the user in the company, nil when they are not a member
+func (db *DB) FindMembership(userID, companyID primitive.ObjectID) (*Membership, error) {
+	membershipCollection := colHelper(db, "memberships")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var m Membership
+	err := membershipCollection.FindOne(ctx, bson.M{"userId": userID, "companyId": companyID}).Decode(&m)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, utils.DatabaseErrorf("find_membership", "Error finding membership: %v", err)
+	}
+
+	return &m, nil
+}
+
+// FindUserMemberships returns the companies of the user, oldest membership first
+func (db *DB) FindUserMemberships(user *User) ([]*Membership, error) {
+	if err := db.ensureMembership(user); err != nil {
+		return nil, err
+	}
+
+	membershipCollection := colHelper(db, "memberships")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := membershipCollection.Find(ctx, bson.M{"userId": user.ID}, opts)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_memberships", "Error finding memberships: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var memberships []*Membership
+	if err = cursor.All(ctx, &memberships); err != nil {
+		return nil, utils.DatabaseErrorf("find_memberships", "Error decoding memberships: %v", err)
+	}
+
+	return memberships, nil
+}
+
+// findCompanyMemberships returns the memberships of a company by user
+func (db *DB) findCompanyMemberships(companyID primitive.ObjectID) (map[primitive.ObjectID]*Membership, error) {
+	membershipCollection := colHelper(db, "memberships")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	cursor, err := membershipCollection.Find(ctx, bson.M{"companyId": companyID})
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_memberships", "Error finding memberships: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var memberships []*Membership
+	if err = cursor.All(ctx, &memberships); err != nil {
+		return nil, utils.DatabaseErrorf("find_memberships", "Error decoding memberships: %v", err)
+	}
+
+	byUser := make(map[primitive.ObjectID]*Membership, len(memberships))
+	for _, m := range memberships {
+		byUser[m.UserID] = m
+	}
+	return byUser, nil
+}
+
+// FindUserInCompany returns the user with the role and stores of their membership in the company
+func (db *DB) FindUserInCompany(userID string, companyID primitive.ObjectID) (*User, error) {
+	if companyID.IsZero() {
+		return nil, utils.NotFoundErrorf("User not found")
+	}
+	user, err := db.FindUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := db.FindMembership(user.ID, companyID)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		if user.CompanyID != companyID {
+			return nil, utils.NotFoundErrorf("User not found")
+		}
+		// User created before memberships: their membership is the user document
+		if err := db.ensureMembership(user); err != nil {
+			utils.LogError(err, "Failed to create membership")
+		}
+		return user, nil
+	}
+
+	user.applyMembership(m)
+	return user, nil
+}
+
+// FindUserForToken returns the user of a token with their membership in the company of the token.
+// A token without company (issued before createCompany) gets the active membership
+func (db *DB) FindUserForToken(userID, companyID string) (*User, error) {
+	companyObjectID, err := primitive.ObjectIDFromHex(companyID)
+	if err != nil || companyObjectID.IsZero() {
+		return db.FindUserByID(userID)
+	}
+	return db.FindUserInCompany(userID, companyObjectID)
+}
+
+// updateMembership writes the company fields of a member: the membership, and the user document
+// when the company is the active one
+func (db *DB) updateMembership(m *Membership) error {
+	now := time.Now()
+	update := membershipUpdate(m, now)
+
+	membershipCollection := colHelper(db, "memberships")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := membershipCollection.UpdateOne(ctx, bson.M{"userId": m.UserID, "companyId": m.CompanyID}, update)
+	if err != nil {
+		return utils.DatabaseErrorf("update_membership", "Error updating membership: %v", err)
+	}
+
+	userCollection := colHelper(db, "users")
+	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": m.UserID, "companyId": m.CompanyID}, update)
+	if err != nil {
+		return utils.DatabaseErrorf("update_membership", "Error updating user: %v", err)
+	}
+
+	return nil
+}
+
+// SwitchActiveMembership makes the company the active one of the user (next logins)
+func (db *DB) SwitchActiveMembership(userID, companyID primitive.ObjectID) (*User, error) {
+	m, err := db.FindMembership(userID, companyID)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil {
+		return nil, utils.NotFoundErrorf("You are not a member of this company")
+	}
+
+	userCollection := colHelper(db, "users")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": userID}, membershipUpdate(m, time.Now()))
+	if err != nil {
+		return nil, utils.DatabaseErrorf("switch_membership", "Error switching company: %v", err)
+	}
+
+	return db.FindUserByID(userID.Hex())
+}
+
+// RemoveMembership removes the user from the company. When it was their active company,
+// the oldest remaining membership becomes active, or the user goes back to having no company
+func (db *DB) RemoveMembership(userID, companyID primitive.ObjectID) error {
+	membershipCollection := colHelper(db, "memberships")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := membershipCollection.DeleteOne(ctx, bson.M{"userId": userID, "companyId": companyID})
+	if err != nil {
+		return utils.DatabaseErrorf("remove_membership", "Error removing membership: %v", err)
+	}
+
+	var next Membership
+	opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	err = membershipCollection.FindOne(ctx, bson.M{"userId": userID}, opts).Decode(&next)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return utils.DatabaseErrorf("remove_membership", "Error finding memberships: %v", err)
+	}
+	if err == mongo.ErrNoDocuments {
+		// Like after register: Admin of the company they will create
+		next = Membership{Role: RoleAdmin}
+	}
+
+	userCollection := colHelper(db, "users")
+	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": userID, "companyId": companyID}, membershipUpdate(&next, time.Now()))
+	if err != nil {
+		return utils.DatabaseErrorf("remove_membership", "Error updating user: %v", err)
+	}
+
+	return nil
+}
+
+// RemoveUserFromCompany removes a user from the company; the account is deleted when they
+// belonged to no other company. It returns true when the account was deleted
+func (db *DB) RemoveUserFromCompany(userID string, companyID primitive.ObjectID) (bool, error) {
+	user, err := db.FindUserInCompany(userID, companyID)
+	if err != nil {
+		return false, err
+	}
+
+	membershipCollection := colHelper(db, "memberships")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	others, err := membershipCollection.CountDocuments(ctx, bson.M{"userId": user.ID, "companyId": bson.M{"$ne": companyID}})
+	if err != nil {
+		return false, utils.DatabaseErrorf("count_memberships", "Error counting memberships: %v", err)
+	}
+	if others == 0 {
+		return true, db.DeleteUser(userID)
+	}
+
+	return false, db.RemoveMembership(user.ID, companyID)
+}
+
+// RemoveCompanyMembers removes every member of a deleted company
+func (db *DB) RemoveCompanyMembers(companyID primitive.ObjectID) error {
+	users, err := db.FindUsersByCompanyID(companyID.Hex())
+	if err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		if err := db.RemoveMembership(user.ID, companyID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
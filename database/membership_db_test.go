@@ -0,0 +1,57 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestApplyMembership vérifie que le rôle et les stores de l'entreprise remplacent ceux du document utilisateur
+func TestApplyMembership(t *testing.T) {
+	companyA, companyB := primitive.NewObjectID(), primitive.NewObjectID()
+	storeA, storeB := primitive.NewObjectID(), primitive.NewObjectID()
+	roleID := primitive.NewObjectID()
+
+	user := &User{ID: primitive.NewObjectID(), Role: RoleAdmin, CompanyID: companyA, StoreIDs: []primitive.ObjectID{storeA}}
+	membership := membershipFromUser(user)
+	assert.Equal(t, user.ID, membership.UserID)
+	assert.Equal(t, companyA, membership.CompanyID)
+	assert.Equal(t, RoleAdmin, membership.Role)
+
+	user.applyMembership(&Membership{
+		CompanyID:       companyB,
+		Role:            RoleUser,
+		RoleID:          &roleID,
+		StoreIDs:        []primitive.ObjectID{storeB},
+		AssignedStoreID: &storeB,
+	})
+	assert.Equal(t, companyB, user.CompanyID)
+	assert.Equal(t, RoleUser, user.Role)
+	assert.Equal(t, &roleID, user.RoleID)
+	assert.Equal(t, []primitive.ObjectID{storeB}, user.StoreIDs)
+	assert.Equal(t, &storeB, user.AssignedStoreID)
+
+	assert.Equal(t, []primitive.ObjectID{}, membershipFromUser(&User{}).StoreIDs)
+}
+
+// TestMembershipUpdate vérifie que le rôle de l'entreprise et le store assigné absents sont effacés
+func TestMembershipUpdate(t *testing.T) {
+	now := time.Now()
+	storeID, roleID := primitive.NewObjectID(), primitive.NewObjectID()
+
+	update := membershipUpdate(&Membership{Role: RoleAdmin}, now)
+	assert.Equal(t, bson.M{"roleId": "", "assignedStoreId": ""}, update["$unset"])
+	set := update["$set"].(bson.M)
+	assert.Equal(t, RoleAdmin, set["role"])
+	assert.Equal(t, []primitive.ObjectID{}, set["storeIds"])
+	assert.Equal(t, now, set["updatedAt"])
+
+	update = membershipUpdate(&Membership{Role: RoleUser, RoleID: &roleID, AssignedStoreID: &storeID}, now)
+	assert.NotContains(t, update, "$unset")
+	set = update["$set"].(bson.M)
+	assert.Equal(t, roleID, set["roleId"])
+	assert.Equal(t, storeID, set["assignedStoreId"])
+}
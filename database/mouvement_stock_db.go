@@ -4,6 +4,8 @@ import (
 	"context"
 	"time"
 
+	"rangoapp/events"
+
 	"github.com/vektah/gqlparser/v2/gqlerror"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -30,7 +32,7 @@ type StockMovement struct {
 	Currency      string              `bson:"currency" json:"currency"`
 	Reason        string              `bson:"reason,omitempty" json:"reason,omitempty"`
 	Reference     string              `bson:"reference,omitempty" json:"reference,omitempty"`         // Référence externe (ID de vente, achat, etc.)
-	ReferenceType string              `bson:"referenceType,omitempty" json:"referenceType,omitempty"` // "SALE", "PURCHASE", "INVENTORY", "ADJUSTMENT", "TRANSFER"
+	ReferenceType string              `bson:"referenceType,omitempty" json:"referenceType,omitempty"` // "SALE", "SALE_CANCEL", "SALE_RETURN", "PURCHASE", "INVENTORY", "ADJUSTMENT", "TRANSFER"
 	ReferenceID   *primitive.ObjectID `bson:"referenceId,omitempty" json:"referenceId,omitempty"`
 	OperatorID    primitive.ObjectID  `bson:"operatorId" json:"operatorId"`
 	CreatedAt     time.Time           `bson:"createdAt" json:"createdAt"`
@@ -191,7 +193,6 @@ func (db *DB) CreateStockMovement(
 	reason, reference, referenceType string,
 	referenceID *primitive.ObjectID,
 ) (*StockMovement, error) {
-	stockMovementCollection := colHelper(db, "stock_movements")
 	ctx, cancel := GetDBContext()
 	defer cancel()
 
@@ -239,9 +240,7 @@ func (db *DB) CreateStockMovement(
 		}
 	}
 
-	now := time.Now()
 	movement := StockMovement{
-		ID:            primitive.NewObjectID(),
 		ProductID:     productObjectID,
 		StoreID:       storeObjectID,
 		Type:          movementType,
@@ -254,16 +253,32 @@ func (db *DB) CreateStockMovement(
 		ReferenceType: referenceType,
 		ReferenceID:   referenceID,
 		OperatorID:    operatorID,
-		CreatedAt:     now,
-		UpdatedAt:     now,
+	}
+	if err := db.writeStockMovement(ctx, &movement); err != nil {
+		return nil, err
 	}
 
-	_, err = stockMovementCollection.InsertOne(ctx, movement)
+	db.publish(events.Event{Type: events.StockLevelChanged, StoreID: storeID, Payload: &movement})
+
+	return &movement, nil
+}
+
+// writeStockMovement inserts a stock movement with ctx (a session context within a MongoDB transaction).
+// The caller publishes it once it is committed
+func (db *DB) writeStockMovement(ctx context.Context, movement *StockMovement) error {
+	now := time.Now()
+	if movement.ID.IsZero() {
+		movement.ID = primitive.NewObjectID()
+	}
+	movement.CreatedAt = now
+	movement.UpdatedAt = now
+
+	_, err := colHelper(db, "stock_movements").InsertOne(ctx, movement)
 	if err != nil {
-		return nil, gqlerror.Errorf("Error creating stock movement: %v", err)
+		return gqlerror.Errorf("Error creating stock movement: %v", err)
 	}
 
-	return &movement, nil
+	return nil
 }
 
 // FindStockMovements finds stock movements with filters
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// OTP purposes: a code is only accepted for the purpose it was sent for
+const (
+	OTPPurposePasswordReset     = "password_reset"
+	OTPPurposePhoneVerification = "phone_verification"
+)
+
+const (
+	OTPLength         = 6
+	OTPValidity       = 10 * time.Minute
+	OTPMaxAttempts    = 5           // Au-delà, un nouveau code doit être demandé
+	OTPResendInterval = time.Minute // Délai minimum entre deux envois au même numéro
+)
+
+// ErrOTPResendTooSoon is returned by CreateOTP less than OTPResendInterval after the previous code
+var ErrOTPResendTooSoon = utils.ValidationErrorf("A code was just sent, please wait before requesting a new one")
+
+// OTPCode is a one-time code sent by SMS. Only its bcrypt hash is stored
+type OTPCode struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Phone      string             `bson:"phone" json:"phone"`
+	Purpose    string             `bson:"purpose" json:"purpose"`
+	CodeHash   string             `bson:"codeHash" json:"-"`
+	Attempts   int                `bson:"attempts" json:"attempts"`
+	ExpiresAt  time.Time          `bson:"expiresAt" json:"expiresAt"`
+	ConsumedAt *time.Time         `bson:"consumedAt,omitempty" json:"consumedAt,omitempty"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// CreateOTP stores a new code for the phone and purpose, replacing the pending ones.
+// It refuses to issue a code less than OTPResendInterval after the previous one
+func (db *DB) CreateOTP(phone, purpose, code string) (*OTPCode, error) {
+	otpCollection := colHelper(db, "otp_codes")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	var last OTPCode
+	err := otpCollection.FindOne(ctx,
+		bson.M{"phone": phone, "purpose": purpose},
+		options.FindOne().SetSort(bson.M{"createdAt": -1}),
+	).Decode(&last)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, utils.DatabaseErrorf("find_otp", "Error finding OTP: %v", err)
+	}
+	if err == nil && now.Sub(last.CreatedAt) < OTPResendInterval {
+		return nil, ErrOTPResendTooSoon
+	}
+
+	codeHash, err := utils.HashPassword(code)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("create_otp", "Error hashing OTP: %v", err)
+	}
+
+	// Only the last code sent is valid
+	_, err = otpCollection.DeleteMany(ctx, bson.M{
+		"phone":      phone,
+		"purpose":    purpose,
+		"consumedAt": bson.M{"$exists": false},
+	})
+	if err != nil {
+		return nil, utils.DatabaseErrorf("create_otp", "Error replacing pending OTPs: %v", err)
+	}
+
+	otp := OTPCode{
+		ID:        primitive.NewObjectID(),
+		Phone:     phone,
+		Purpose:   purpose,
+		CodeHash:  codeHash,
+		ExpiresAt: now.Add(OTPValidity),
+		CreatedAt: now,
+	}
+
+	_, err = otpCollection.InsertOne(ctx, otp)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("create_otp", "Error creating OTP: %v", err)
+	}
+
+	return &otp, nil
+}
+
+// VerifyOTP checks a code and consumes it. Each check uses one attempt, taken atomically
+// before the comparison so that concurrent guesses cannot exceed OTPMaxAttempts
+func (db *DB) VerifyOTP(phone, purpose, code string) error {
+	otpCollection := colHelper(db, "otp_codes")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	var otp OTPCode
+	err := otpCollection.FindOneAndUpdate(ctx,
+		bson.M{
+			"phone":      phone,
+			"purpose":    purpose,
+			"consumedAt": bson.M{"$exists": false},
+			"expiresAt":  bson.M{"$gt": now},
+			"attempts":   bson.M{"$lt": OTPMaxAttempts},
+		},
+		bson.M{"$inc": bson.M{"attempts": 1}},
+		options.FindOneAndUpdate().SetSort(bson.M{"createdAt": -1}).SetReturnDocument(options.After),
+	).Decode(&otp)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return utils.ValidationErrorf("Invalid or expired code, please request a new one")
+		}
+		return utils.DatabaseErrorf("verify_otp", "Error verifying OTP: %v", err)
+	}
+
+	if !utils.ComparePassword(code, otp.CodeHash) {
+		if remaining := OTPMaxAttempts - otp.Attempts; remaining > 0 {
+			return utils.ValidationErrorf("Invalid code, %d attempt(s) left", remaining)
+		}
+		return utils.ValidationErrorf("Invalid code, please request a new one")
+	}
+
+	// Conditional update: a code is consumed only once
+	result, err := otpCollection.UpdateOne(ctx,
+		bson.M{"_id": otp.ID, "consumedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"consumedAt": now}},
+	)
+	if err != nil {
+		return utils.DatabaseErrorf("verify_otp", "Error consuming OTP: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return utils.ValidationErrorf("Invalid or expired code, please request a new one")
+	}
+
+	return nil
+}
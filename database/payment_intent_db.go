@@ -0,0 +1,448 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PaymentIntent is a mobile money payment pushed to the phone of the customer (see utils.PaymentProvider).
+// Once confirmed by the operator, it pays the amount due of its sale or debt and posts the caisse entry
+type PaymentIntent struct {
+	ID            primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	CompanyID     primitive.ObjectID  `bson:"companyId" json:"companyId"`
+	StoreID       primitive.ObjectID  `bson:"storeId" json:"storeId"`
+	OperatorID    primitive.ObjectID  `bson:"operatorId" json:"operatorId"` // Utilisateur qui a initié le paiement
+	Provider      string              `bson:"provider" json:"provider"`
+	Method        string              `bson:"method" json:"method"` // "mpesa", "airtel_money", "orange_money"
+	Phone         string              `bson:"phone" json:"phone"`
+	Amount        float64             `bson:"amount" json:"amount"`
+	Currency      string              `bson:"currency" json:"currency"` // Devise de la dette
+	SaleID        *primitive.ObjectID `bson:"saleId,omitempty" json:"saleId,omitempty"`
+	DebtID        primitive.ObjectID  `bson:"debtId" json:"debtId"` // Dette réglée (celle de la vente si saleId)
+	Status        string              `bson:"status" json:"status"` // "pending", "succeeded", "failed"
+	ProviderRef   string              `bson:"providerRef,omitempty" json:"providerRef,omitempty"`
+	TransactionID string              `bson:"transactionId,omitempty" json:"transactionId,omitempty"` // Reçu de l'opérateur
+	FailureReason string              `bson:"failureReason,omitempty" json:"failureReason,omitempty"`
+	CreatedAt     time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt     time.Time           `bson:"updatedAt" json:"updatedAt"`
+	ConfirmedAt   *time.Time          `bson:"confirmedAt,omitempty" json:"confirmedAt,omitempty"`
+
+	// Settlement of the debt once the payment succeeded
+	DebtPaymentID   *primitive.ObjectID `bson:"debtPaymentId,omitempty" json:"debtPaymentId,omitempty"`
+	SettlementError string              `bson:"settlementError,omitempty" json:"settlementError,omitempty"` // Paiement reçu mais non imputé: à régulariser
+}
+
+// IsMobileMoneyMethod reports whether the payment method is collected by a push request to the phone
+func IsMobileMoneyMethod(method string) bool {
+	return method == PaymentMethodMpesa || method == PaymentMethodAirtelMoney || method == PaymentMethodOrangeMoney
+}
+
+// paymentIntentUpdate returns the update of a pending payment intent for the result of the operator,
+// nil while the payment is pending
+func paymentIntentUpdate(result *utils.PaymentResult, now time.Time) bson.M {
+	switch result.Status {
+	case utils.PaymentStatusSucceeded:
+		return bson.M{
+			"status":        utils.PaymentStatusSucceeded,
+			"transactionId": result.TransactionID,
+			"confirmedAt":   now,
+			"updatedAt":     now,
+		}
+	case utils.PaymentStatusFailed:
+		reason := result.Message
+		if reason == "" {
+			reason = "Payment refused by the operator"
+		}
+		return bson.M{
+			"status":        utils.PaymentStatusFailed,
+			"failureReason": reason,
+			"updatedAt":     now,
+		}
+	}
+	return nil
+}
+
+// paymentIntentDescription returns the description of the debt payment of a confirmed payment intent
+func paymentIntentDescription(intent *PaymentIntent) string {
+	description := fmt.Sprintf("%s %s", PaymentMethodLabels[intent.Method], intent.Phone)
+	if intent.TransactionID != "" {
+		description += fmt.Sprintf(" (réf. %s)", intent.TransactionID)
+	}
+	return description
+}
+
+// CreatePaymentIntent records a pending payment of the amount due of a sale or of a debt of the store.
+// The amount due of a sale is its debt (credit sale): a sale without a debt is paid at checkout, its mobile
+// money tenders are recorded by createSale. The amount cannot exceed the amount due
+func (db *DB) CreatePaymentIntent(storeID, operatorID primitive.ObjectID, provider, method, phone string, amount float64, saleID, debtID *primitive.ObjectID) (*PaymentIntent, error) {
+	if !IsMobileMoneyMethod(method) {
+		return nil, utils.ValidationErrorf("Invalid mobile money method: %s", method)
+	}
+	if amount <= 0 {
+		return nil, utils.ValidationErrorf("Payment amount must be greater than 0")
+	}
+	if (saleID == nil) == (debtID == nil) {
+		return nil, utils.ValidationErrorf("A payment intent settles either a sale or a debt")
+	}
+
+	if saleID != nil {
+		sale, err := db.FindSaleByID(saleID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		if sale.StoreID != storeID {
+			return nil, utils.ValidationErrorf("Sale does not belong to the specified store")
+		}
+		if sale.CancelledAt != nil {
+			return nil, utils.ValidationErrorf("Sale is cancelled")
+		}
+		if sale.DebtID == nil || sale.DebtID.IsZero() {
+			return nil, utils.ValidationErrorf("Sale has no amount due: only the debt of a credit sale can be paid by mobile money")
+		}
+		debtID = sale.DebtID
+	}
+
+	debt, err := db.GetDebtByID(debtID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if debt.StoreID != storeID {
+		return nil, utils.ValidationErrorf("Debt does not belong to the specified store")
+	}
+	if debt.Status == "cancelled" || debt.AmountDue <= 0 {
+		return nil, utils.ValidationErrorf("Debt has no amount due")
+	}
+	if amount > debt.AmountDue {
+		return nil, utils.ValidationErrorf("Payment amount (%.2f) exceeds remaining debt (%.2f)", amount, debt.AmountDue)
+	}
+
+	store, err := db.FindStoreByID(storeID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	intent := &PaymentIntent{
+		ID:         primitive.NewObjectID(),
+		CompanyID:  store.CompanyID,
+		StoreID:    storeID,
+		OperatorID: operatorID,
+		Provider:   provider,
+		Method:     method,
+		Phone:      phone,
+		Amount:     amount,
+		Currency:   debt.Currency,
+		SaleID:     saleID,
+		DebtID:     debt.ID,
+		Status:     utils.PaymentStatusPending,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	ctx, cancel := GetDBContext()
+	defer cancel()
+	if _, err := colHelper(db, "payment_intents").InsertOne(ctx, intent); err != nil {
+		return nil, utils.DatabaseErrorf("create_payment_intent", "Error creating payment intent: %v", err)
+	}
+
+	return intent, nil
+}
+
+// SetPaymentIntentProviderRef saves the reference of the push request at the operator
+func (db *DB) SetPaymentIntentProviderRef(intent *PaymentIntent, providerRef string) error {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	_, err := colHelper(db, "payment_intents").UpdateOne(ctx, bson.M{"_id": intent.ID}, bson.M{"$set": bson.M{
+		"providerRef": providerRef,
+		"updatedAt":   now,
+	}})
+	if err != nil {
+		return utils.DatabaseErrorf("update_payment_intent", "Error updating payment intent: %v", err)
+	}
+
+	intent.ProviderRef = providerRef
+	intent.UpdatedAt = now
+	return nil
+}
+
+// FindPaymentIntentByID returns a payment intent of the company
+func (db *DB) FindPaymentIntentByID(id string, companyID primitive.ObjectID) (*PaymentIntent, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, utils.ValidationErrorf("Invalid payment intent ID")
+	}
+	return db.findPaymentIntent(bson.M{"_id": objectID, "companyId": companyID})
+}
+
+// FindPaymentIntentByProviderRef returns the payment intent of a push request (operator callbacks)
+func (db *DB) FindPaymentIntentByProviderRef(provider, providerRef string) (*PaymentIntent, error) {
+	return db.findPaymentIntent(bson.M{"provider": provider, "providerRef": providerRef})
+}
+
+func (db *DB) findPaymentIntent(filter bson.M) (*PaymentIntent, error) {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var intent PaymentIntent
+	err := colHelper(db, "payment_intents").FindOne(ctx, filter).Decode(&intent)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("Payment intent not found")
+		}
+		return nil, utils.DatabaseErrorf("find_payment_intent", "Error finding payment intent: %v", err)
+	}
+
+	return &intent, nil
+}
+
+// FindPaymentIntents returns the payment intents of the stores, optionally with a status, newest first
+func (db *DB) FindPaymentIntents(storeIDs []primitive.ObjectID, status *string, limit int) ([]*PaymentIntent, error) {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := bson.M{"storeId": bson.M{"$in": storeIDs}}
+	if status != nil && *status != "" {
+		filter["status"] = *status
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+	if limit > 0 {
+		opts.SetLimit(int64(limit))
+	}
+
+	cursor, err := colHelper(db, "payment_intents").Find(ctx, filter, opts)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_payment_intents", "Error finding payment intents: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	intents := []*PaymentIntent{}
+	if err = cursor.All(ctx, &intents); err != nil {
+		return nil, utils.DatabaseErrorf("decode_payment_intents", "Error decoding payment intents: %v", err)
+	}
+
+	return intents, nil
+}
+
+// ApplyPaymentResult records the result of the operator on a pending payment intent. A confirmed payment
+// pays the debt (the amount due of the sale) with the mobile money method, which posts the caisse entry,
+// in the same MongoDB transaction as the status. When the debt cannot take the payment (paid or cancelled
+// meanwhile) the intent is recorded as succeeded with its settlementError, retried by SettlePaymentIntents.
+// Only the first result is applied: repeated callbacks and status checks return the intent unchanged
+func (db *DB) ApplyPaymentResult(intent *PaymentIntent, result *utils.PaymentResult) (*PaymentIntent, error) {
+	if intent.Status != utils.PaymentStatusPending {
+		return intent, nil
+	}
+	update := paymentIntentUpdate(result, time.Now())
+	if update == nil {
+		return intent, nil
+	}
+
+	updated, trans, err := db.applyPaymentResult(intent, update)
+	if isSettlementFailure(err) {
+		// The money is received but the debt refused it: keep the result with the reason
+		utils.LogError(err, fmt.Sprintf("Failed to settle payment intent %s", intent.ID.Hex()))
+		update["settlementError"] = err.Error()
+		updated, trans, err = db.applyPaymentResult(intent, update)
+	}
+	if err == errPaymentIntentApplied {
+		// Already applied by a concurrent callback
+		return db.findPaymentIntent(bson.M{"_id": intent.ID})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if trans != nil {
+		db.publishCommitted([]*Trans{trans}, nil)
+	}
+
+	return updated, nil
+}
+
+// errPaymentIntentApplied is returned by applyPaymentResult when the intent is no longer pending
+var errPaymentIntentApplied = errors.New("payment intent already applied")
+
+// isSettlementFailure reports whether the debt refused the payment of an intent (rather than the database failing)
+func isSettlementFailure(err error) bool {
+	var appErr *utils.AppError
+	return errors.As(err, &appErr) && (appErr.Type == utils.ErrorTypeValidation || appErr.Type == utils.ErrorTypeNotFound)
+}
+
+// applyPaymentResult sets the status of a pending intent and, when it succeeded without a settlementError,
+// pays its debt within the same transaction. It returns the intent and the caisse transaction to publish
+func (db *DB) applyPaymentResult(intent *PaymentIntent, update bson.M) (*PaymentIntent, *Trans, error) {
+	session, err := db.client.StartSession()
+	if err != nil {
+		return nil, nil, utils.DatabaseErrorf("start_session", "Error starting transaction session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	txCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var updated PaymentIntent
+	var trans *Trans
+	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
+		// Start transaction
+		if err := session.StartTransaction(); err != nil {
+			return utils.DatabaseErrorf("start_transaction", "Error starting transaction: %v", err)
+		}
+
+		err := colHelper(db, "payment_intents").FindOneAndUpdate(
+			sc,
+			bson.M{"_id": intent.ID, "status": utils.PaymentStatusPending},
+			bson.M{"$set": update},
+			options.FindOneAndUpdate().SetReturnDocument(options.After),
+		).Decode(&updated)
+		if err != nil {
+			if err == mongo.ErrNoDocuments {
+				return errPaymentIntentApplied
+			}
+			return utils.DatabaseErrorf("update_payment_intent", "Error updating payment intent: %v", err)
+		}
+
+		if updated.Status == utils.PaymentStatusSucceeded && updated.SettlementError == "" {
+			trans, err = db.settlePaymentIntent(sc, &updated)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Commit transaction
+		if err := session.CommitTransaction(sc); err != nil {
+			return utils.DatabaseErrorf("commit_transaction", "Error committing transaction: %v", err)
+		}
+
+		return nil
+	})
+
+	// Handle transaction errors
+	// MongoDB automatically aborts the transaction if an error occurs in WithSession
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &updated, trans, nil
+}
+
+// settlePaymentIntent pays the debt of a succeeded payment intent with the mobile money method within the
+// transaction sc and links the debt payment to the intent. It returns the caisse transaction to publish
+func (db *DB) settlePaymentIntent(sc mongo.SessionContext, intent *PaymentIntent) (*Trans, error) {
+	_, payment, trans, err := db.payDebtInTx(sc, intent.DebtID.Hex(), intent.Amount, intent.OperatorID, intent.StoreID,
+		paymentIntentDescription(intent), intent.Method, intent.TransactionID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The debtPaymentId guard protects against a concurrent settlement
+	result, err := colHelper(db, "payment_intents").UpdateOne(sc,
+		bson.M{"_id": intent.ID, "debtPaymentId": nil},
+		bson.M{
+			"$set":   bson.M{"debtPaymentId": payment.ID, "updatedAt": time.Now()},
+			"$unset": bson.M{"settlementError": ""},
+		},
+	)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("update_payment_intent", "Error updating payment intent settlement: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, utils.ValidationErrorf("Payment intent is already settled")
+	}
+
+	intent.DebtPaymentID = &payment.ID
+	intent.SettlementError = ""
+	return trans, nil
+}
+
+// SettlePaymentIntents retries the settlement of the succeeded payment intents that did not pay their debt
+// (settlementError). It returns the number of intents settled and of intents still unsettled
+func (db *DB) SettlePaymentIntents() (int, int, error) {
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	cursor, err := colHelper(db, "payment_intents").Find(ctx, bson.M{
+		"status":        utils.PaymentStatusSucceeded,
+		"debtPaymentId": nil,
+	})
+	if err != nil {
+		return 0, 0, utils.DatabaseErrorf("find_payment_intents", "Error finding unsettled payment intents: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var intents []*PaymentIntent
+	if err = cursor.All(ctx, &intents); err != nil {
+		return 0, 0, utils.DatabaseErrorf("decode_payment_intents", "Error decoding payment intents: %v", err)
+	}
+
+	settled := 0
+	for _, intent := range intents {
+		trans, err := db.retrySettlement(intent)
+		if err != nil {
+			utils.LogError(err, fmt.Sprintf("Failed to settle payment intent %s", intent.ID.Hex()))
+			if isSettlementFailure(err) && err.Error() != intent.SettlementError {
+				if _, err := colHelper(db, "payment_intents").UpdateOne(ctx, bson.M{"_id": intent.ID, "debtPaymentId": nil},
+					bson.M{"$set": bson.M{"settlementError": err.Error(), "updatedAt": time.Now()}}); err != nil {
+					utils.LogError(err, fmt.Sprintf("Failed to record settlement error of payment intent %s", intent.ID.Hex()))
+				}
+			}
+			continue
+		}
+		db.publishCommitted([]*Trans{trans}, nil)
+		settled++
+	}
+
+	return settled, len(intents) - settled, nil
+}
+
+// retrySettlement settles a succeeded payment intent in its own transaction
+func (db *DB) retrySettlement(intent *PaymentIntent) (*Trans, error) {
+	session, err := db.client.StartSession()
+	if err != nil {
+		return nil, utils.DatabaseErrorf("start_session", "Error starting transaction session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	txCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var trans *Trans
+	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
+		// Start transaction
+		if err := session.StartTransaction(); err != nil {
+			return utils.DatabaseErrorf("start_transaction", "Error starting transaction: %v", err)
+		}
+
+		var err error
+		trans, err = db.settlePaymentIntent(sc, intent)
+		if err != nil {
+			return err
+		}
+
+		// Commit transaction
+		if err := session.CommitTransaction(sc); err != nil {
+			return utils.DatabaseErrorf("commit_transaction", "Error committing transaction: %v", err)
+		}
+
+		return nil
+	})
+
+	// Handle transaction errors
+	// MongoDB automatically aborts the transaction if an error occurs in WithSession
+	if err != nil {
+		return nil, err
+	}
+
+	return trans, nil
+}
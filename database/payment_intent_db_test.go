@@ -0,0 +1,58 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"rangoapp/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPaymentIntentUpdate vérifie la mise à jour d'un paiement en attente selon la réponse de l'opérateur
+func TestPaymentIntentUpdate(t *testing.T) {
+	now := time.Now()
+
+	assert.Nil(t, paymentIntentUpdate(&utils.PaymentResult{Status: utils.PaymentStatusPending}, now))
+
+	update := paymentIntentUpdate(&utils.PaymentResult{Status: utils.PaymentStatusSucceeded, TransactionID: "MP123"}, now)
+	assert.Equal(t, utils.PaymentStatusSucceeded, update["status"])
+	assert.Equal(t, "MP123", update["transactionId"])
+	assert.Equal(t, now, update["confirmedAt"])
+
+	update = paymentIntentUpdate(&utils.PaymentResult{Status: utils.PaymentStatusFailed, Message: "Solde insuffisant"}, now)
+	assert.Equal(t, utils.PaymentStatusFailed, update["status"])
+	assert.Equal(t, "Solde insuffisant", update["failureReason"])
+	assert.NotContains(t, update, "confirmedAt")
+
+	update = paymentIntentUpdate(&utils.PaymentResult{Status: utils.PaymentStatusFailed}, now)
+	assert.NotEmpty(t, update["failureReason"])
+}
+
+// TestPaymentIntentDescription vérifie la description du paiement de la dette
+func TestPaymentIntentDescription(t *testing.T) {
+	intent := &PaymentIntent{Method: PaymentMethodMpesa, Phone: "+243810000001"}
+	assert.Equal(t, "M-Pesa +243810000001", paymentIntentDescription(intent))
+
+	intent.TransactionID = "MP123"
+	assert.Equal(t, "M-Pesa +243810000001 (réf. MP123)", paymentIntentDescription(intent))
+}
+
+// TestIsMobileMoneyMethod vérifie les moyens de paiement poussés sur le téléphone
+func TestIsMobileMoneyMethod(t *testing.T) {
+	assert.True(t, IsMobileMoneyMethod(PaymentMethodMpesa))
+	assert.True(t, IsMobileMoneyMethod(PaymentMethodAirtelMoney))
+	assert.True(t, IsMobileMoneyMethod(PaymentMethodOrangeMoney))
+	assert.False(t, IsMobileMoneyMethod(PaymentMethodCash))
+	assert.False(t, IsMobileMoneyMethod(PaymentMethodCard))
+}
+
+// TestIsSettlementFailure vérifie que seul un refus de la dette est gardé dans settlementError (une erreur
+// de base de données laisse le paiement en attente)
+func TestIsSettlementFailure(t *testing.T) {
+	assert.True(t, isSettlementFailure(utils.ValidationErrorf("Payment amount (%.2f) exceeds remaining debt (%.2f)", 10.0, 5.0)))
+	assert.True(t, isSettlementFailure(utils.NotFoundErrorf("Debt not found")))
+	assert.False(t, isSettlementFailure(utils.DatabaseErrorf("update_debt", "Error updating debt")))
+	assert.False(t, isSettlementFailure(errPaymentIntentApplied))
+	assert.False(t, isSettlementFailure(nil))
+}
@@ -4,22 +4,33 @@ import (
 	"context"
 	"time"
 
+	"rangoapp/utils"
+
 	"github.com/vektah/gqlparser/v2/gqlerror"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type Product struct {
 	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
 	Name      string              `bson:"name" json:"name"`
 	Mark      string              `bson:"mark" json:"mark"`
+	Barcode   string              `bson:"barcode,omitempty" json:"barcode,omitempty"` // EAN-13 / UPC-A, unique par boutique
+	SKU       string              `bson:"sku,omitempty" json:"sku,omitempty"`         // Référence interne, unique par boutique
 	StoreID   primitive.ObjectID  `bson:"storeId" json:"storeId"`
 	DeletedAt *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
 	CreatedAt time.Time           `bson:"createdAt" json:"createdAt"`
 	UpdatedAt time.Time           `bson:"updatedAt" json:"updatedAt"`
+
+	// Reorder threshold (see stock_alert_db.go): 0 disables the low stock alerts
+	ReorderPoint      float64 `bson:"reorderPoint,omitempty" json:"reorderPoint,omitempty"`
+	ReorderQuantity   float64 `bson:"reorderQuantity,omitempty" json:"reorderQuantity,omitempty"`
+	StockCheckPending bool    `bson:"stockCheckPending,omitempty" json:"-"` // Stock modifié depuis la dernière vérification
+	StockCheckVersion int64   `bson:"stockCheckVersion,omitempty" json:"-"` // Incrémenté à chaque signalement (voir MarkProductsForStockCheck)
 }
 
-func (db *DB) CreateProduct(name, mark string, storeID primitive.ObjectID) (*Product, error) {
+func (db *DB) CreateProduct(name, mark, barcode, sku string, storeID primitive.ObjectID) (*Product, error) {
 	productCollection := colHelper(db, "products")
 	ctx, cancel := GetDBContext()
 	defer cancel()
@@ -30,10 +41,18 @@ func (db *DB) CreateProduct(name, mark string, storeID primitive.ObjectID) (*Pro
 		return nil, gqlerror.Errorf("Store not found")
 	}
 
+	// Barcode and SKU must be unique per store (a UPC-A barcode is stored in its EAN-13 form)
+	barcode = utils.NormalizeBarcode(barcode)
+	if err := db.checkProductCodesAvailable(storeID, primitive.NilObjectID, barcode, sku); err != nil {
+		return nil, err
+	}
+
 	product := Product{
 		ID:        primitive.NewObjectID(),
 		Name:      name,
 		Mark:      mark,
+		Barcode:   barcode,
+		SKU:       sku,
 		StoreID:   storeID,
 		CreatedAt: time.Now(),
 		UpdatedAt: time.Now(),
@@ -41,6 +60,9 @@ func (db *DB) CreateProduct(name, mark string, storeID primitive.ObjectID) (*Pro
 
 	_, err = productCollection.InsertOne(ctx, product)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, utils.ValidationErrorf("A product with this barcode or SKU already exists in this store")
+		}
 		return nil, gqlerror.Errorf("Error creating product: %v", err)
 	}
 
@@ -116,7 +138,8 @@ func (db *DB) FindProductsByProviderID(providerID string, storeIDs []primitive.O
 	return products, nil
 }
 
-func (db *DB) UpdateProduct(id string, name, mark *string) (*Product, error) {
+// UpdateProduct updates a product; an empty barcode or sku removes the code
+func (db *DB) UpdateProduct(id string, name, mark, barcode, sku *string) (*Product, error) {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return nil, gqlerror.Errorf("Invalid product ID")
@@ -141,8 +164,47 @@ func (db *DB) UpdateProduct(id string, name, mark *string) (*Product, error) {
 		update["mark"] = *mark
 	}
 
-	_, err = productCollection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": update})
+	if barcode != nil {
+		normalized := utils.NormalizeBarcode(*barcode)
+		barcode = &normalized
+	}
+	newBarcode, newSKU := "", ""
+	if barcode != nil && *barcode != currentProduct.Barcode {
+		newBarcode = *barcode
+	}
+	if sku != nil && *sku != currentProduct.SKU {
+		newSKU = *sku
+	}
+	if err := db.checkProductCodesAvailable(currentProduct.StoreID, objectID, newBarcode, newSKU); err != nil {
+		return nil, err
+	}
+
+	unset := bson.M{}
+	if barcode != nil {
+		if *barcode == "" {
+			unset["barcode"] = ""
+		} else {
+			update["barcode"] = *barcode
+		}
+	}
+	if sku != nil {
+		if *sku == "" {
+			unset["sku"] = ""
+		} else {
+			update["sku"] = *sku
+		}
+	}
+
+	updateDoc := bson.M{"$set": update}
+	if len(unset) > 0 {
+		updateDoc["$unset"] = unset
+	}
+
+	_, err = productCollection.UpdateOne(ctx, bson.M{"_id": objectID}, updateDoc)
 	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, utils.ValidationErrorf("A product with this barcode or SKU already exists in this store")
+		}
 		return nil, gqlerror.Errorf("Error updating product: %v", err)
 	}
 
@@ -170,6 +232,79 @@ func (db *DB) UpdateProductStock(id string, quantity float64) error {
 	return nil
 }
 
+// barcodeForms returns the stored forms of a normalized barcode: its EAN-13 form and, for a UPC-A code,
+// the 12 digits form saved before barcodes were normalized
+func barcodeForms(barcode string) []string {
+	barcode = utils.NormalizeBarcode(barcode)
+	if len(barcode) == 13 && barcode[0] == '0' {
+		return []string{barcode, barcode[1:]}
+	}
+	return []string{barcode}
+}
+
+// FindProductByCode finds a product of the store by barcode or SKU
+// A UPC-A code and its EAN-13 form (leading zero) are the same barcode
+func (db *DB) FindProductByCode(storeID primitive.ObjectID, code string) (*Product, error) {
+	productCollection := colHelper(db, "products")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := bson.M{
+		"storeId":   storeID,
+		"deletedAt": nil,
+		"$or": []bson.M{
+			{"barcode": bson.M{"$in": barcodeForms(code)}},
+			{"sku": code},
+		},
+	}
+
+	var product Product
+	err := productCollection.FindOne(ctx, filter).Decode(&product)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("No product found for code %s", code)
+		}
+		return nil, utils.DatabaseErrorf("FindProductByCode", "Error finding product by code: %v", err)
+	}
+
+	return &product, nil
+}
+
+// checkProductCodesAvailable returns an error if another product of the store already uses the barcode or SKU
+// Empty codes are ignored; excludeID is the product being updated (NilObjectID on creation)
+func (db *DB) checkProductCodesAvailable(storeID, excludeID primitive.ObjectID, barcode, sku string) error {
+	productCollection := colHelper(db, "products")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	check := func(field, value, label string) error {
+		if value == "" {
+			return nil
+		}
+		var match interface{} = value
+		if field == "barcode" {
+			match = bson.M{"$in": barcodeForms(value)}
+		}
+		count, err := productCollection.CountDocuments(ctx, bson.M{
+			"storeId": storeID,
+			field:     match,
+			"_id":     bson.M{"$ne": excludeID},
+		})
+		if err != nil {
+			return utils.DatabaseErrorf("checkProductCodesAvailable", "Error checking product %s: %v", label, err)
+		}
+		if count > 0 {
+			return utils.ValidationErrorf("A product with %s %s already exists in this store", label, value)
+		}
+		return nil
+	}
+
+	if err := check("barcode", barcode, "barcode"); err != nil {
+		return err
+	}
+	return check("sku", sku, "SKU")
+}
+
 // SoftDeleteProduct marks a product as deleted (soft delete)
 func (db *DB) SoftDeleteProduct(id string) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
@@ -188,13 +323,17 @@ func (db *DB) SoftDeleteProduct(id string) error {
 		return gqlerror.Errorf("Product not found or already deleted")
 	}
 
-	// Soft delete: set deletedAt
+	// Soft delete: set deletedAt and release the barcode/SKU so they can be reused
 	now := time.Now()
 	_, err = productCollection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{
 		"$set": bson.M{
 			"deletedAt": now,
 			"updatedAt": now,
 		},
+		"$unset": bson.M{
+			"barcode": "",
+			"sku":     "",
+		},
 	})
 	if err != nil {
 		return gqlerror.Errorf("Error soft deleting product: %v", err)
@@ -7,6 +7,8 @@ import (
 	"github.com/vektah/gqlparser/v2/gqlerror"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type ProductInStock struct {
@@ -18,8 +20,32 @@ type ProductInStock struct {
 	Stock      float64             `bson:"stock" json:"stock"`
 	StoreID    primitive.ObjectID  `bson:"storeId" json:"storeId"`
 	ProviderID primitive.ObjectID  `bson:"providerId" json:"providerId"`
+	ExpiryDate *time.Time          `bson:"expiryDate,omitempty" json:"expiryDate,omitempty"` // Péremption du lot (optionnelle, utilisée pour l'allocation FEFO)
 	CreatedAt  time.Time           `bson:"createdAt" json:"createdAt"`
 	UpdatedAt  time.Time           `bson:"updatedAt" json:"updatedAt"`
+
+	// Batch tracking (see ExpiringProductsInStock and WriteOffExpiredLots)
+	BatchNumber     string              `bson:"batchNumber,omitempty" json:"batchNumber,omitempty"`
+	WriteOffExpired bool                `bson:"writeOffExpired,omitempty" json:"writeOffExpired,omitempty"` // Mise au rebut automatique à la péremption
+	WriteOffBy      *primitive.ObjectID `bson:"writeOffBy,omitempty" json:"writeOffBy,omitempty"`           // Admin ayant activé la mise au rebut
+	WrittenOffAt    *time.Time          `bson:"writtenOffAt,omitempty" json:"writtenOffAt,omitempty"`
+}
+
+// IsExpired reports whether the lot has an expiry date in the past
+func (p *ProductInStock) IsExpired(now time.Time) bool {
+	return p.ExpiryDate != nil && !p.ExpiryDate.After(now)
+}
+
+// lotFilter matches the rows of the same batch; rows without batch or expiry match missing fields
+func lotFilter(batchNumber string, expiryDate *time.Time) bson.M {
+	filter := bson.M{"batchNumber": nil, "expiryDate": nil}
+	if batchNumber != "" {
+		filter["batchNumber"] = batchNumber
+	}
+	if expiryDate != nil {
+		filter["expiryDate"] = *expiryDate
+	}
+	return filter
 }
 
 // CreateProductInStock creates a new product in stock
@@ -28,8 +54,9 @@ func (db *DB) CreateProductInStock(
 	priceVente, priceAchat, stock float64,
 	currency string,
 	storeID, providerID primitive.ObjectID,
+	batchNumber string,
+	expiryDate *time.Time,
 ) (*ProductInStock, error) {
-	productInStockCollection := colHelper(db, "products_in_stock")
 	ctx, cancel := GetDBContext()
 	defer cancel()
 
@@ -80,49 +107,60 @@ func (db *DB) CreateProductInStock(
 		return nil, gqlerror.Errorf("Provider does not belong to the same store")
 	}
 
-	// Check if ProductInStock already exists for this product and provider
-	var existing ProductInStock
-	err = productInStockCollection.FindOne(ctx, bson.M{
-		"productId":  productID,
-		"storeId":    storeID,
-		"providerId": providerID,
-	}).Decode(&existing)
+	return db.supplyProductInStock(ctx, productID, priceVente, priceAchat, stock, currency, storeID, providerID, batchNumber, expiryDate)
+}
 
-	if err == nil {
-		// ProductInStock exists, update it
-		update := bson.M{
+// supplyProductInStock adds the supplied stock to the ProductInStock of the product, provider and batch
+// with ctx (a session context within a MongoDB transaction), or creates it. The prices are replaced
+// by the ones of the supply
+func (db *DB) supplyProductInStock(
+	ctx context.Context,
+	productID primitive.ObjectID,
+	priceVente, priceAchat, stock float64,
+	currency string,
+	storeID, providerID primitive.ObjectID,
+	batchNumber string,
+	expiryDate *time.Time,
+) (*ProductInStock, error) {
+	productInStockCollection := colHelper(db, "products_in_stock")
+
+	// Check if ProductInStock already exists for this product, provider and batch
+	filter := lotFilter(batchNumber, expiryDate)
+	filter["productId"] = productID
+	filter["storeId"] = storeID
+	filter["providerId"] = providerID
+
+	var existing ProductInStock
+	err := productInStockCollection.FindOneAndUpdate(ctx, filter, bson.M{
+		"$inc": bson.M{"stock": stock}, // Add to existing stock
+		"$set": bson.M{
 			"priceVente": priceVente,
 			"priceAchat": priceAchat,
 			"currency":   currency,
-			"stock":      existing.Stock + stock, // Add to existing stock
 			"updatedAt":  time.Now(),
-		}
-
-		_, err = productInStockCollection.UpdateOne(ctx, bson.M{"_id": existing.ID}, bson.M{"$set": update})
-		if err != nil {
-			return nil, gqlerror.Errorf("Error updating product in stock: %v", err)
-		}
-
-		// Reload
-		err = productInStockCollection.FindOne(ctx, bson.M{"_id": existing.ID}).Decode(&existing)
-		if err != nil {
-			return nil, gqlerror.Errorf("Error reloading product in stock: %v", err)
-		}
+		},
+	}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&existing)
+	if err == nil {
 		return &existing, nil
 	}
+	if err != mongo.ErrNoDocuments {
+		return nil, gqlerror.Errorf("Error updating product in stock: %v", err)
+	}
 
 	// Create new ProductInStock
 	productInStock := ProductInStock{
-		ID:         primitive.NewObjectID(),
-		ProductID:  productID,
-		PriceVente: priceVente,
-		PriceAchat: priceAchat,
-		Currency:   currency,
-		Stock:      stock,
-		StoreID:    storeID,
-		ProviderID: providerID,
-		CreatedAt:  time.Now(),
-		UpdatedAt:  time.Now(),
+		ID:          primitive.NewObjectID(),
+		ProductID:   productID,
+		PriceVente:  priceVente,
+		PriceAchat:  priceAchat,
+		Currency:    currency,
+		Stock:       stock,
+		StoreID:     storeID,
+		ProviderID:  providerID,
+		ExpiryDate:  expiryDate,
+		BatchNumber: batchNumber,
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
 	}
 
 	_, err = productInStockCollection.InsertOne(ctx, productInStock)
@@ -203,6 +241,37 @@ func (db *DB) FindProductsInStockByProductID(productID string, storeIDs []primit
 	return productsInStock, nil
 }
 
+// FindSellableProductsInStock finds the unexpired lots of a product that still have stock in a store, oldest first
+func (db *DB) FindSellableProductsInStock(productID, storeID primitive.ObjectID) ([]*ProductInStock, error) {
+	productInStockCollection := colHelper(db, "products_in_stock")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{
+		"productId": productID,
+		"storeId":   storeID,
+		"stock":     bson.M{"$gt": 0},
+		"$or": []bson.M{
+			{"expiryDate": nil},
+			{"expiryDate": bson.M{"$gt": time.Now()}},
+		},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+
+	cursor, err := productInStockCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, gqlerror.Errorf("Error finding products in stock: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var productsInStock []*ProductInStock
+	if err = cursor.All(ctx, &productsInStock); err != nil {
+		return nil, gqlerror.Errorf("Error decoding products in stock: %v", err)
+	}
+
+	return productsInStock, nil
+}
+
 // FindProductsInStockByProviderID finds products in stock by provider ID
 func (db *DB) FindProductsInStockByProviderID(providerID string, storeIDs []primitive.ObjectID) ([]*ProductInStock, error) {
 	providerObjectID, err := primitive.ObjectIDFromHex(providerID)
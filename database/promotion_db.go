@@ -0,0 +1,515 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Promotion types. Line promotions target products, basket promotions the whole sale
+const (
+	PromotionTypePercentage       = "percentage"        // Value % off the targeted products
+	PromotionTypeFixed            = "fixed"             // Value off each unit of the targeted products
+	PromotionTypeBuyXGetY         = "buy_x_get_y"       // BuyQuantity bought, GetQuantity free (the cheapest units)
+	PromotionTypeBasketPercentage = "basket_percentage" // Value % off the basket
+	PromotionTypeBasketFixed      = "basket_fixed"      // Value off the basket
+)
+
+// PromotionTimeLayout is the layout of the time window of a promotion (happy hour)
+const PromotionTimeLayout = "15:04"
+
+// Promotion is a discount of the company applied by CreateSale to the sales of its stores.
+// Each product gets the best of its line promotions, then the sale gets the best basket promotion
+type Promotion struct {
+	ID              primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	CompanyID       primitive.ObjectID   `bson:"companyId" json:"companyId"`
+	Name            string               `bson:"name" json:"name"`
+	Description     string               `bson:"description,omitempty" json:"description,omitempty"`
+	Type            string               `bson:"type" json:"type"`
+	Value           float64              `bson:"value" json:"value"`                               // Pourcentage ou montant selon le type
+	Currency        string               `bson:"currency,omitempty" json:"currency,omitempty"`     // Ventes dans cette devise seulement (requis pour les montants)
+	ProductIDs      []primitive.ObjectID `bson:"productIds,omitempty" json:"productIds,omitempty"` // Produits ciblés (promotions de ligne)
+	BuyQuantity     float64              `bson:"buyQuantity,omitempty" json:"buyQuantity,omitempty"`
+	GetQuantity     float64              `bson:"getQuantity,omitempty" json:"getQuantity,omitempty"`
+	MinBasketAmount float64              `bson:"minBasketAmount,omitempty" json:"minBasketAmount,omitempty"` // Promotions de panier
+	StoreIDs        []primitive.ObjectID `bson:"storeIds" json:"storeIds"`                                   // Vide: toutes les boutiques
+	StartDate       *time.Time           `bson:"startDate,omitempty" json:"startDate,omitempty"`
+	EndDate         *time.Time           `bson:"endDate,omitempty" json:"endDate,omitempty"`       // Exclue
+	DaysOfWeek      []int                `bson:"daysOfWeek,omitempty" json:"daysOfWeek,omitempty"` // 0 = dimanche, vide: tous les jours
+	StartTime       string               `bson:"startTime,omitempty" json:"startTime,omitempty"`   // HH:MM, heure locale du serveur
+	EndTime         string               `bson:"endTime,omitempty" json:"endTime,omitempty"`       // HH:MM, exclue (peut passer minuit)
+	Active          bool                 `bson:"active" json:"active"`
+	CreatedBy       primitive.ObjectID   `bson:"createdBy" json:"createdBy"`
+	CreatedAt       time.Time            `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time            `bson:"updatedAt" json:"updatedAt"`
+}
+
+// AppliedPromotion is the discount a promotion gave to a sale
+type AppliedPromotion struct {
+	PromotionID primitive.ObjectID `bson:"promotionId" json:"promotionId"`
+	Name        string             `bson:"name" json:"name"`
+	Type        string             `bson:"type" json:"type"`
+	Amount      float64            `bson:"amount" json:"amount"`
+}
+
+// IsBasketPromotion reports whether the promotion applies to the whole sale
+func (p *Promotion) IsBasketPromotion() bool {
+	return p.Type == PromotionTypeBasketPercentage || p.Type == PromotionTypeBasketFixed
+}
+
+// AppliesAt reports whether the promotion applies to a sale of the store in the currency at the given time
+func (p *Promotion) AppliesAt(storeID primitive.ObjectID, currency string, now time.Time) bool {
+	if !p.Active {
+		return false
+	}
+	if p.Currency != "" && p.Currency != currency {
+		return false
+	}
+	if len(p.StoreIDs) > 0 && !containsObjectID(p.StoreIDs, storeID) {
+		return false
+	}
+	if p.StartDate != nil && now.Before(*p.StartDate) {
+		return false
+	}
+	if p.EndDate != nil && !now.Before(*p.EndDate) {
+		return false
+	}
+	if len(p.DaysOfWeek) > 0 {
+		today := int(now.Weekday())
+		found := false
+		for _, day := range p.DaysOfWeek {
+			if day == today {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if p.StartTime != "" && p.EndTime != "" {
+		clock := now.Format(PromotionTimeLayout)
+		if p.StartTime <= p.EndTime {
+			return clock >= p.StartTime && clock < p.EndTime
+		}
+		// Window over midnight (ex: 22:00 - 02:00)
+		return clock >= p.StartTime || clock < p.EndTime
+	}
+	return true
+}
+
+func containsObjectID(ids []primitive.ObjectID, id primitive.ObjectID) bool {
+	for _, candidate := range ids {
+		if candidate == id {
+			return true
+		}
+	}
+	return false
+}
+
+// roundAmount rounds a discount to the cent
+func roundAmount(amount float64) float64 {
+	return math.Round(amount*100) / 100
+}
+
+// lineDiscounts returns the discount of a line promotion on each line of a product
+func (p *Promotion) lineDiscounts(lines []ProductInBasket) []float64 {
+	discounts := make([]float64, len(lines))
+	switch p.Type {
+	case PromotionTypePercentage:
+		for i, line := range lines {
+			discounts[i] = roundAmount(line.Quantity * line.Price * p.Value / 100)
+		}
+	case PromotionTypeFixed:
+		for i, line := range lines {
+			discounts[i] = roundAmount(line.Quantity * math.Min(p.Value, line.Price))
+		}
+	case PromotionTypeBuyXGetY:
+		if p.BuyQuantity <= 0 || p.GetQuantity <= 0 {
+			return discounts
+		}
+		var quantity float64
+		for _, line := range lines {
+			quantity += line.Quantity
+		}
+		free := math.Floor(quantity/(p.BuyQuantity+p.GetQuantity)) * p.GetQuantity
+
+		// The free units are the cheapest ones
+		order := make([]int, len(lines))
+		for i := range order {
+			order[i] = i
+		}
+		sort.SliceStable(order, func(a, b int) bool { return lines[order[a]].Price < lines[order[b]].Price })
+		for _, i := range order {
+			if free <= 0 {
+				break
+			}
+			take := math.Min(free, lines[i].Quantity)
+			discounts[i] = roundAmount(take * lines[i].Price)
+			free -= take
+		}
+	}
+	return discounts
+}
+
+// basketDiscount returns the discount of a basket promotion on a basket of the given net amount
+func (p *Promotion) basketDiscount(net float64) float64 {
+	if net <= 0 || net < p.MinBasketAmount {
+		return 0
+	}
+	switch p.Type {
+	case PromotionTypeBasketPercentage:
+		return roundAmount(net * p.Value / 100)
+	case PromotionTypeBasketFixed:
+		return roundAmount(math.Min(p.Value, net))
+	}
+	return 0
+}
+
+// applyPromotions sets the discount and the promotions of each basket line and returns the promotions applied.
+// productIDs[i] is the product of basket[i]. Each product gets its best line promotion, then the basket
+// gets its best basket promotion, shared between the lines in proportion of their amount after discount
+func applyPromotions(basket []ProductInBasket, productIDs []primitive.ObjectID, promotions []*Promotion, storeID primitive.ObjectID, currency string, now time.Time) []AppliedPromotion {
+	var applied []AppliedPromotion
+	record := func(promotion *Promotion, amount float64) {
+		for i := range applied {
+			if applied[i].PromotionID == promotion.ID {
+				applied[i].Amount = roundAmount(applied[i].Amount + amount)
+				return
+			}
+		}
+		applied = append(applied, AppliedPromotion{PromotionID: promotion.ID, Name: promotion.Name, Type: promotion.Type, Amount: amount})
+	}
+
+	var linePromotions, basketPromotions []*Promotion
+	for _, promotion := range promotions {
+		if !promotion.AppliesAt(storeID, currency, now) {
+			continue
+		}
+		if promotion.IsBasketPromotion() {
+			basketPromotions = append(basketPromotions, promotion)
+		} else {
+			linePromotions = append(linePromotions, promotion)
+		}
+	}
+
+	// Line promotions, by product (a product can be sold from several lots)
+	var products []primitive.ObjectID
+	productLines := make(map[primitive.ObjectID][]int)
+	for i, productID := range productIDs {
+		if _, ok := productLines[productID]; !ok {
+			products = append(products, productID)
+		}
+		productLines[productID] = append(productLines[productID], i)
+	}
+	for _, productID := range products {
+		indexes := productLines[productID]
+		lines := make([]ProductInBasket, len(indexes))
+		for i, index := range indexes {
+			lines[i] = basket[index]
+		}
+
+		var best *Promotion
+		var bestDiscounts []float64
+		var bestTotal float64
+		for _, promotion := range linePromotions {
+			if !containsObjectID(promotion.ProductIDs, productID) {
+				continue
+			}
+			discounts := promotion.lineDiscounts(lines)
+			var total float64
+			for _, discount := range discounts {
+				total += discount
+			}
+			if total > bestTotal {
+				best, bestDiscounts, bestTotal = promotion, discounts, total
+			}
+		}
+		if best == nil {
+			continue
+		}
+		for i, index := range indexes {
+			if bestDiscounts[i] > 0 {
+				basket[index].Discount = roundAmount(basket[index].Discount + bestDiscounts[i])
+				basket[index].PromotionIDs = append(basket[index].PromotionIDs, best.ID)
+			}
+		}
+		record(best, roundAmount(bestTotal))
+	}
+
+	// Basket promotion, on the amount left after the line promotions
+	var net float64
+	lastLine := -1
+	for i, line := range basket {
+		if lineNet := line.Quantity*line.Price - line.Discount; lineNet > 0 {
+			net += lineNet
+			lastLine = i
+		}
+	}
+	var best *Promotion
+	var bestAmount float64
+	for _, promotion := range basketPromotions {
+		if amount := promotion.basketDiscount(net); amount > bestAmount {
+			best, bestAmount = promotion, amount
+		}
+	}
+	if best != nil {
+		remaining := bestAmount
+		for i, line := range basket {
+			lineNet := line.Quantity*line.Price - line.Discount
+			if lineNet <= 0 {
+				continue
+			}
+			share := roundAmount(bestAmount * lineNet / net)
+			if i == lastLine || share > remaining {
+				share = remaining
+			}
+			basket[i].Discount = roundAmount(basket[i].Discount + share)
+			basket[i].PromotionIDs = append(basket[i].PromotionIDs, best.ID)
+			remaining = roundAmount(remaining - share)
+		}
+		record(best, bestAmount)
+	}
+
+	return applied
+}
+
+// totalDiscount returns the sum of the discounts of the promotions applied
+func totalDiscount(applied []AppliedPromotion) float64 {
+	var total float64
+	for _, promotion := range applied {
+		total += promotion.Amount
+	}
+	return roundAmount(total)
+}
+
+// validatePromotion checks the fields of a promotion required by its type
+func validatePromotion(promotion *Promotion) error {
+	switch promotion.Type {
+	case PromotionTypePercentage, PromotionTypeBasketPercentage:
+		if promotion.Value <= 0 || promotion.Value > 100 {
+			return utils.ValidationErrorf("Percentage must be between 0 and 100")
+		}
+	case PromotionTypeFixed, PromotionTypeBasketFixed:
+		if promotion.Value <= 0 {
+			return utils.ValidationErrorf("Discount amount must be greater than 0")
+		}
+		if promotion.Currency == "" {
+			return utils.ValidationErrorf("Currency is required for a fixed discount")
+		}
+	case PromotionTypeBuyXGetY:
+		if promotion.BuyQuantity <= 0 || promotion.GetQuantity <= 0 {
+			return utils.ValidationErrorf("Buy and get quantities must be greater than 0")
+		}
+	default:
+		return utils.ValidationErrorf("Invalid promotion type: %s", promotion.Type)
+	}
+
+	if promotion.IsBasketPromotion() {
+		if len(promotion.ProductIDs) > 0 {
+			return utils.ValidationErrorf("A basket promotion does not target products")
+		}
+		if promotion.MinBasketAmount > 0 && promotion.Currency == "" {
+			return utils.ValidationErrorf("Currency is required for a minimum basket amount")
+		}
+	} else if len(promotion.ProductIDs) == 0 {
+		return utils.ValidationErrorf("At least one product is required")
+	}
+
+	if promotion.StartDate != nil && promotion.EndDate != nil && !promotion.EndDate.After(*promotion.StartDate) {
+		return utils.ValidationErrorf("End date must be after start date")
+	}
+	if (promotion.StartTime == "") != (promotion.EndTime == "") {
+		return utils.ValidationErrorf("Start time and end time go together")
+	}
+	return nil
+}
+
+// checkPromotionScope checks that the products and stores of the promotion belong to the company
+func (db *DB) checkPromotionScope(promotion *Promotion) error {
+	for _, storeID := range promotion.StoreIDs {
+		store, err := db.FindStoreByID(storeID.Hex())
+		if err != nil {
+			return err
+		}
+		if store.CompanyID != promotion.CompanyID {
+			return utils.ValidationErrorf("Store does not belong to your company")
+		}
+	}
+	for _, productID := range promotion.ProductIDs {
+		product, err := db.FindProductByID(productID.Hex())
+		if err != nil {
+			return utils.NotFoundErrorf("Product not found: %s", productID.Hex())
+		}
+		store, err := db.FindStoreByID(product.StoreID.Hex())
+		if err != nil {
+			return err
+		}
+		if store.CompanyID != promotion.CompanyID {
+			return utils.ValidationErrorf("Product %s does not belong to your company", productID.Hex())
+		}
+	}
+	return nil
+}
+
+// CreatePromotion creates a promotion of the company
+func (db *DB) CreatePromotion(promotion *Promotion) (*Promotion, error) {
+	promotion.Name = strings.TrimSpace(promotion.Name)
+	if promotion.StoreIDs == nil {
+		promotion.StoreIDs = []primitive.ObjectID{}
+	}
+	if err := validatePromotion(promotion); err != nil {
+		return nil, err
+	}
+	if err := db.checkPromotionScope(promotion); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	promotion.ID = primitive.NewObjectID()
+	promotion.CreatedAt = now
+	promotion.UpdatedAt = now
+
+	promotionCollection := colHelper(db, "promotions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	if _, err := promotionCollection.InsertOne(ctx, promotion); err != nil {
+		return nil, utils.DatabaseErrorf("create_promotion", "Error creating promotion: %v", err)
+	}
+
+	return promotion, nil
+}
+
+// FindPromotionByID finds a promotion of the company
+func (db *DB) FindPromotionByID(id string, companyID primitive.ObjectID) (*Promotion, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, utils.ValidationErrorf("Invalid promotion ID")
+	}
+
+	promotionCollection := colHelper(db, "promotions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var promotion Promotion
+	err = promotionCollection.FindOne(ctx, bson.M{"_id": objectID, "companyId": companyID}).Decode(&promotion)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("Promotion not found")
+		}
+		return nil, utils.DatabaseErrorf("find_promotion", "Error finding promotion: %v", err)
+	}
+
+	return &promotion, nil
+}
+
+// FindPromotions returns the promotions of the company, optionally those of a store (store-specific or
+// company-wide) and only the active ones, oldest first
+func (db *DB) FindPromotions(companyID primitive.ObjectID, storeID *primitive.ObjectID, activeOnly bool) ([]*Promotion, error) {
+	promotionCollection := colHelper(db, "promotions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := bson.M{"companyId": companyID}
+	if storeID != nil {
+		filter["$or"] = []bson.M{
+			{"storeIds": *storeID},
+			{"storeIds": bson.M{"$size": 0}},
+		}
+	}
+	if activeOnly {
+		filter["active"] = true
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := promotionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_promotions", "Error finding promotions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var promotions []*Promotion
+	if err = cursor.All(ctx, &promotions); err != nil {
+		return nil, utils.DatabaseErrorf("find_promotions", "Error decoding promotions: %v", err)
+	}
+
+	return promotions, nil
+}
+
+// UpdatePromotion replaces the settings of a promotion of the company
+func (db *DB) UpdatePromotion(id string, update *Promotion) (*Promotion, error) {
+	existing, err := db.FindPromotionByID(id, update.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+
+	update.ID = existing.ID
+	update.Name = strings.TrimSpace(update.Name)
+	update.CreatedBy = existing.CreatedBy
+	update.CreatedAt = existing.CreatedAt
+	update.UpdatedAt = time.Now()
+	if update.StoreIDs == nil {
+		update.StoreIDs = []primitive.ObjectID{}
+	}
+	if err := validatePromotion(update); err != nil {
+		return nil, err
+	}
+	if err := db.checkPromotionScope(update); err != nil {
+		return nil, err
+	}
+
+	promotionCollection := colHelper(db, "promotions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err = promotionCollection.ReplaceOne(ctx, bson.M{"_id": existing.ID, "companyId": existing.CompanyID}, update)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("update_promotion", "Error updating promotion: %v", err)
+	}
+
+	return update, nil
+}
+
+// DeletePromotion deletes a promotion of the company. The sales keep its name and discount
+func (db *DB) DeletePromotion(id string, companyID primitive.ObjectID) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return utils.ValidationErrorf("Invalid promotion ID")
+	}
+
+	promotionCollection := colHelper(db, "promotions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	result, err := promotionCollection.DeleteOne(ctx, bson.M{"_id": objectID, "companyId": companyID})
+	if err != nil {
+		return utils.DatabaseErrorf("delete_promotion", "Error deleting promotion: %v", err)
+	}
+	if result.DeletedCount == 0 {
+		return utils.NotFoundErrorf("Promotion not found")
+	}
+
+	return nil
+}
+
+// applyStorePromotions applies the active promotions of the store to the basket (see applyPromotions)
+func (db *DB) applyStorePromotions(basket []ProductInBasket, productIDs []primitive.ObjectID, storeID primitive.ObjectID, currency string) ([]AppliedPromotion, error) {
+	store, err := db.FindStoreByID(storeID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	promotions, err := db.FindPromotions(store.CompanyID, &storeID, true)
+	if err != nil {
+		return nil, err
+	}
+	return applyPromotions(basket, productIDs, promotions, storeID, currency, time.Now()), nil
+}
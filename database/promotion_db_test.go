@@ -0,0 +1,124 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestPromotionAppliesAt vérifie la boutique, la devise, la période, les jours et la plage horaire d'une promotion
+func TestPromotionAppliesAt(t *testing.T) {
+	storeID := primitive.NewObjectID()
+	now := time.Date(2026, 3, 6, 18, 30, 0, 0, time.Local) // vendredi
+	yesterday, tomorrow := now.AddDate(0, 0, -1), now.AddDate(0, 0, 1)
+
+	assert.True(t, (&Promotion{Active: true}).AppliesAt(storeID, "USD", now))
+	assert.False(t, (&Promotion{}).AppliesAt(storeID, "USD", now))
+	assert.False(t, (&Promotion{Active: true, Currency: "CDF"}).AppliesAt(storeID, "USD", now))
+	assert.False(t, (&Promotion{Active: true, StoreIDs: []primitive.ObjectID{primitive.NewObjectID()}}).AppliesAt(storeID, "USD", now))
+	assert.True(t, (&Promotion{Active: true, StoreIDs: []primitive.ObjectID{storeID}}).AppliesAt(storeID, "USD", now))
+
+	assert.True(t, (&Promotion{Active: true, StartDate: &yesterday, EndDate: &tomorrow}).AppliesAt(storeID, "USD", now))
+	assert.False(t, (&Promotion{Active: true, StartDate: &tomorrow}).AppliesAt(storeID, "USD", now))
+	assert.False(t, (&Promotion{Active: true, EndDate: &now}).AppliesAt(storeID, "USD", now))
+
+	assert.True(t, (&Promotion{Active: true, DaysOfWeek: []int{5, 6}}).AppliesAt(storeID, "USD", now))
+	assert.False(t, (&Promotion{Active: true, DaysOfWeek: []int{0}}).AppliesAt(storeID, "USD", now))
+
+	assert.True(t, (&Promotion{Active: true, StartTime: "17:00", EndTime: "19:00"}).AppliesAt(storeID, "USD", now))
+	assert.False(t, (&Promotion{Active: true, StartTime: "17:00", EndTime: "18:30"}).AppliesAt(storeID, "USD", now))
+	assert.True(t, (&Promotion{Active: true, StartTime: "18:00", EndTime: "02:00"}).AppliesAt(storeID, "USD", now))
+	assert.False(t, (&Promotion{Active: true, StartTime: "22:00", EndTime: "02:00"}).AppliesAt(storeID, "USD", now))
+}
+
+// TestApplyPromotionsLine vérifie qu'un produit reçoit la meilleure de ses promotions de ligne
+func TestApplyPromotionsLine(t *testing.T) {
+	storeID := primitive.NewObjectID()
+	productA, productB := primitive.NewObjectID(), primitive.NewObjectID()
+	percentage := &Promotion{ID: primitive.NewObjectID(), Name: "-10%", Type: PromotionTypePercentage, Value: 10, ProductIDs: []primitive.ObjectID{productA, productB}, Active: true}
+	fixed := &Promotion{ID: primitive.NewObjectID(), Name: "-3$", Type: PromotionTypeFixed, Value: 3, Currency: "USD", ProductIDs: []primitive.ObjectID{productB}, Active: true}
+
+	basket := []ProductInBasket{
+		{ProductInStockID: primitive.NewObjectID(), Quantity: 2, Price: 50},
+		{ProductInStockID: primitive.NewObjectID(), Quantity: 1, Price: 20},
+	}
+	applied := applyPromotions(basket, []primitive.ObjectID{productA, productB}, []*Promotion{percentage, fixed}, storeID, "USD", time.Now())
+
+	assert.Equal(t, 10.0, basket[0].Discount)
+	assert.Equal(t, []primitive.ObjectID{percentage.ID}, basket[0].PromotionIDs)
+	assert.Equal(t, 3.0, basket[1].Discount)
+	assert.Equal(t, []primitive.ObjectID{fixed.ID}, basket[1].PromotionIDs)
+	assert.Len(t, applied, 2)
+	assert.Equal(t, 13.0, totalDiscount(applied))
+
+	// In another currency the fixed discount does not apply
+	basket[0].Discount, basket[0].PromotionIDs = 0, nil
+	basket[1].Discount, basket[1].PromotionIDs = 0, nil
+	applied = applyPromotions(basket, []primitive.ObjectID{productA, productB}, []*Promotion{percentage, fixed}, storeID, "CDF", time.Now())
+	assert.Equal(t, 2.0, basket[1].Discount)
+	assert.Equal(t, 12.0, totalDiscount(applied))
+}
+
+// TestApplyPromotionsBuyXGetY vérifie que les unités offertes sont les moins chères, sur tous les lots du produit
+func TestApplyPromotionsBuyXGetY(t *testing.T) {
+	productID := primitive.NewObjectID()
+	promotion := &Promotion{ID: primitive.NewObjectID(), Type: PromotionTypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, ProductIDs: []primitive.ObjectID{productID}, Active: true}
+
+	basket := []ProductInBasket{
+		{ProductInStockID: primitive.NewObjectID(), Quantity: 4, Price: 10},
+		{ProductInStockID: primitive.NewObjectID(), Quantity: 3, Price: 8},
+	}
+	applied := applyPromotions(basket, []primitive.ObjectID{productID, productID}, []*Promotion{promotion}, primitive.NewObjectID(), "USD", time.Now())
+
+	// 7 units: 2 sets of 3, 2 free units from the cheapest lot
+	assert.Equal(t, 0.0, basket[0].Discount)
+	assert.Equal(t, 16.0, basket[1].Discount)
+	assert.Equal(t, 16.0, totalDiscount(applied))
+}
+
+// TestApplyPromotionsBasket vérifie que la remise de panier s'applique après les remises de ligne
+// et se répartit entre les lignes sans écart d'arrondi
+func TestApplyPromotionsBasket(t *testing.T) {
+	productA, productB := primitive.NewObjectID(), primitive.NewObjectID()
+	line := &Promotion{ID: primitive.NewObjectID(), Type: PromotionTypePercentage, Value: 50, ProductIDs: []primitive.ObjectID{productA}, Active: true}
+	small := &Promotion{ID: primitive.NewObjectID(), Type: PromotionTypeBasketFixed, Value: 5, Currency: "USD", Active: true}
+	large := &Promotion{ID: primitive.NewObjectID(), Type: PromotionTypeBasketPercentage, Value: 10, MinBasketAmount: 200, Currency: "USD", Active: true}
+
+	basket := []ProductInBasket{
+		{ProductInStockID: primitive.NewObjectID(), Quantity: 1, Price: 100},
+		{ProductInStockID: primitive.NewObjectID(), Quantity: 3, Price: 33.33},
+	}
+	applied := applyPromotions(basket, []primitive.ObjectID{productA, productB}, []*Promotion{line, small, large}, primitive.NewObjectID(), "USD", time.Now())
+
+	// Net after the line promotion: 50 + 99.99, below the minimum of the 10% promotion
+	assert.Len(t, applied, 2)
+	assert.Equal(t, small.ID, applied[1].PromotionID)
+	assert.Equal(t, 5.0, applied[1].Amount)
+	assert.Equal(t, 55.0, totalDiscount(applied))
+	assert.InDelta(t, 55.0, basket[0].Discount+basket[1].Discount, 1e-9)
+	assert.Equal(t, []primitive.ObjectID{line.ID, small.ID}, basket[0].PromotionIDs)
+	assert.Equal(t, []primitive.ObjectID{small.ID}, basket[1].PromotionIDs)
+}
+
+// TestValidatePromotion vérifie les champs requis selon le type de promotion
+func TestValidatePromotion(t *testing.T) {
+	productIDs := []primitive.ObjectID{primitive.NewObjectID()}
+	start := time.Now()
+	end := start.Add(-time.Hour)
+
+	assert.NoError(t, validatePromotion(&Promotion{Type: PromotionTypePercentage, Value: 10, ProductIDs: productIDs}))
+	assert.NoError(t, validatePromotion(&Promotion{Type: PromotionTypeBasketPercentage, Value: 5}))
+	assert.NoError(t, validatePromotion(&Promotion{Type: PromotionTypeBuyXGetY, BuyQuantity: 2, GetQuantity: 1, ProductIDs: productIDs}))
+
+	assert.Error(t, validatePromotion(&Promotion{Type: "bogo"}))
+	assert.Error(t, validatePromotion(&Promotion{Type: PromotionTypePercentage, Value: 120, ProductIDs: productIDs}))
+	assert.Error(t, validatePromotion(&Promotion{Type: PromotionTypePercentage, Value: 10}))
+	assert.Error(t, validatePromotion(&Promotion{Type: PromotionTypeFixed, Value: 2, ProductIDs: productIDs}))
+	assert.Error(t, validatePromotion(&Promotion{Type: PromotionTypeBasketFixed, Value: 2, Currency: "USD", ProductIDs: productIDs}))
+	assert.Error(t, validatePromotion(&Promotion{Type: PromotionTypeBasketPercentage, Value: 5, MinBasketAmount: 50}))
+	assert.Error(t, validatePromotion(&Promotion{Type: PromotionTypeBuyXGetY, BuyQuantity: 2, ProductIDs: productIDs}))
+	assert.Error(t, validatePromotion(&Promotion{Type: PromotionTypeBasketPercentage, Value: 5, StartDate: &start, EndDate: &end}))
+	assert.Error(t, validatePromotion(&Promotion{Type: PromotionTypeBasketPercentage, Value: 5, StartTime: "17:00"}))
+}
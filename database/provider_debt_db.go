@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -44,10 +45,21 @@ func (db *DB) CreateProviderDebt(
 	totalAmount, amountPaid, amountDue float64,
 	currency string,
 ) (*ProviderDebt, error) {
-	debtCollection := colHelper(db, "provider_debts")
 	ctx, cancel := GetDBContext()
 	defer cancel()
 
+	return db.createProviderDebt(ctx, supplyID, providerID, storeID, totalAmount, amountPaid, amountDue, currency)
+}
+
+// createProviderDebt creates a provider debt with ctx (a session context within a MongoDB transaction)
+func (db *DB) createProviderDebt(
+	ctx context.Context,
+	supplyID, providerID, storeID primitive.ObjectID,
+	totalAmount, amountPaid, amountDue float64,
+	currency string,
+) (*ProviderDebt, error) {
+	debtCollection := colHelper(db, "provider_debts")
+
 	// Determine status
 	status := "unpaid"
 	if amountDue <= 0 {
@@ -186,8 +198,7 @@ func (db *DB) PayProviderDebt(
 	// Create caisse transaction for the payment
 	// Note: If this fails, we log it but don't fail the payment since it's already recorded
 	// In a production system, you might want to retry this or use a queue
-	_, err = db.CreateTrans(
-		"Sortie",
+	_, err = db.CreatePurchaseTrans(
 		amount,
 		fmt.Sprintf("Paiement dette fournisseur - %s", description),
 		debt.Currency,
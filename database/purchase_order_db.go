@@ -0,0 +1,535 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"rangoapp/events"
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// PurchaseOrderStatus represents the status of a purchase order
+const (
+	PurchaseOrderStatusDraft             = "draft"              // En préparation
+	PurchaseOrderStatusSent              = "sent"               // Envoyé au fournisseur
+	PurchaseOrderStatusPartiallyReceived = "partially_received" // Livraison partielle, reliquat en attente
+	PurchaseOrderStatusReceived          = "received"           // Entièrement reçu
+	PurchaseOrderStatusCancelled         = "cancelled"          // Annulé (le reliquat éventuel est abandonné)
+)
+
+// PurchaseOrder represents an order placed with a provider before the goods arrive
+type PurchaseOrder struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	StoreID      primitive.ObjectID  `bson:"storeId" json:"storeId"`
+	ProviderID   primitive.ObjectID  `bson:"providerId" json:"providerId"`
+	Status       string              `bson:"status" json:"status"` // "draft", "sent", "partially_received", "received", "cancelled"
+	Lines        []PurchaseOrderLine `bson:"lines" json:"lines"`
+	Currency     string              `bson:"currency" json:"currency"`
+	TotalAmount  float64             `bson:"totalAmount" json:"totalAmount"` // Montant commandé: sum of priceAchat * quantityOrdered
+	Note         string              `bson:"note,omitempty" json:"note,omitempty"`
+	ExpectedDate *time.Time          `bson:"expectedDate,omitempty" json:"expectedDate,omitempty"` // Date de livraison prévue
+	CreatedBy    primitive.ObjectID  `bson:"createdBy" json:"createdBy"`
+	SentAt       *time.Time          `bson:"sentAt,omitempty" json:"sentAt,omitempty"`
+	ReceivedAt   *time.Time          `bson:"receivedAt,omitempty" json:"receivedAt,omitempty"` // Date de la réception complète
+	CancelledAt  *time.Time          `bson:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+	CreatedAt    time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time           `bson:"updatedAt" json:"updatedAt"`
+}
+
+// PurchaseOrderLine represents one ordered product template
+type PurchaseOrderLine struct {
+	ProductID        primitive.ObjectID   `bson:"productId" json:"productId"`
+	QuantityOrdered  float64              `bson:"quantityOrdered" json:"quantityOrdered"`
+	QuantityReceived float64              `bson:"quantityReceived" json:"quantityReceived"`
+	PriceAchat       float64              `bson:"priceAchat" json:"priceAchat"`
+	PriceVente       float64              `bson:"priceVente" json:"priceVente"`
+	SupplyIDs        []primitive.ObjectID `bson:"supplyIds" json:"supplyIds"` // Approvisionnements créés à chaque réception
+}
+
+// Backorder returns the quantity still expected from the provider
+func (l PurchaseOrderLine) Backorder() float64 {
+	remaining := l.QuantityOrdered - l.QuantityReceived
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// PurchaseOrderReceipt is the quantity delivered for one product of the order
+// A product delivered in several batches is received as one receipt per batch
+type PurchaseOrderReceipt struct {
+	ProductID   primitive.ObjectID
+	Quantity    float64
+	BatchNumber string
+	ExpiryDate  *time.Time
+}
+
+// purchaseOrderStatusAfterReceipt returns "received" once every line is complete, "partially_received" otherwise
+func purchaseOrderStatusAfterReceipt(lines []PurchaseOrderLine) string {
+	for _, line := range lines {
+		if line.Backorder() > 0 {
+			return PurchaseOrderStatusPartiallyReceived
+		}
+	}
+	return PurchaseOrderStatusReceived
+}
+
+// CreatePurchaseOrder creates a draft purchase order for a provider of the store
+func (db *DB) CreatePurchaseOrder(storeID, providerID primitive.ObjectID, lines []PurchaseOrderLine, currency, note string, expectedDate *time.Time, operatorID primitive.ObjectID) (*PurchaseOrder, error) {
+	if len(lines) == 0 {
+		return nil, utils.ValidationErrorf("A purchase order must contain at least one line")
+	}
+
+	provider, err := db.FindProviderByID(providerID.Hex())
+	if err != nil {
+		return nil, utils.NotFoundErrorf("Provider not found")
+	}
+	if provider.StoreID != storeID {
+		return nil, utils.ValidationErrorf("Provider does not belong to the same store")
+	}
+
+	// Determine currency
+	if currency == "" {
+		currency, err = db.GetStoreDefaultCurrency(storeID.Hex())
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		isValid, err := db.ValidateStoreCurrency(storeID.Hex(), currency)
+		if err != nil {
+			return nil, err
+		}
+		if !isValid {
+			return nil, utils.ValidationErrorf("Currency %s is not supported by this store", currency)
+		}
+	}
+
+	seen := make(map[primitive.ObjectID]bool, len(lines))
+	orderLines := make([]PurchaseOrderLine, 0, len(lines))
+	var totalAmount float64
+	for _, line := range lines {
+		if seen[line.ProductID] {
+			return nil, utils.ValidationErrorf("Product %s appears more than once", line.ProductID.Hex())
+		}
+		seen[line.ProductID] = true
+
+		if line.QuantityOrdered <= 0 {
+			return nil, utils.ValidationErrorf("Quantity must be greater than 0")
+		}
+		if line.PriceAchat <= 0 {
+			return nil, utils.ValidationErrorf("Price d'achat must be greater than 0")
+		}
+		if line.PriceVente < line.PriceAchat {
+			return nil, utils.ValidationErrorf("Price de vente must be >= price d'achat")
+		}
+		product, err := db.FindProductByID(line.ProductID.Hex())
+		if err != nil {
+			return nil, utils.NotFoundErrorf("Product not found: %s", line.ProductID.Hex())
+		}
+		if product.StoreID != storeID {
+			return nil, utils.ValidationErrorf("Product %s does not belong to the store", line.ProductID.Hex())
+		}
+
+		orderLines = append(orderLines, PurchaseOrderLine{
+			ProductID:       line.ProductID,
+			QuantityOrdered: line.QuantityOrdered,
+			PriceAchat:      line.PriceAchat,
+			PriceVente:      line.PriceVente,
+			SupplyIDs:       []primitive.ObjectID{},
+		})
+		totalAmount += line.PriceAchat * line.QuantityOrdered
+	}
+
+	orderCollection := colHelper(db, "purchase_orders")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	order := PurchaseOrder{
+		ID:           primitive.NewObjectID(),
+		StoreID:      storeID,
+		ProviderID:   providerID,
+		Status:       PurchaseOrderStatusDraft,
+		Lines:        orderLines,
+		Currency:     currency,
+		TotalAmount:  totalAmount,
+		Note:         note,
+		ExpectedDate: expectedDate,
+		CreatedBy:    operatorID,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+
+	_, err = orderCollection.InsertOne(ctx, order)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("create_purchase_order", "Error creating purchase order: %v", err)
+	}
+
+	return &order, nil
+}
+
+// SendPurchaseOrder marks a draft order as sent to the provider
+func (db *DB) SendPurchaseOrder(orderID string) (*PurchaseOrder, error) {
+	order, err := db.GetPurchaseOrderByID(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != PurchaseOrderStatusDraft {
+		return nil, utils.ValidationErrorf("Only a draft purchase order can be sent (current status: %s)", order.Status)
+	}
+
+	now := time.Now()
+	if err := db.updatePurchaseOrder(order, bson.M{
+		"status":    PurchaseOrderStatusSent,
+		"sentAt":    now,
+		"updatedAt": now,
+	}); err != nil {
+		return nil, err
+	}
+
+	return db.GetPurchaseOrderByID(orderID)
+}
+
+// ReceivePurchaseOrder records a delivery against a sent order. Each received line goes through the
+// same path as supplyStock: ProductInStock, StockSupply, provider debt (paymentType "debt") or caisse
+// exit ("cash") and ENTREE movement. Short deliveries leave the order partially received (back-order).
+// All database operations are performed within a MongoDB transaction to ensure atomicity
+func (db *DB) ReceivePurchaseOrder(orderID string, receipts []PurchaseOrderReceipt, paymentType string, date time.Time, operatorID primitive.ObjectID) (*PurchaseOrder, error) {
+	if paymentType != "cash" && paymentType != "debt" {
+		return nil, utils.ValidationErrorf("Payment type must be 'cash' or 'debt'")
+	}
+	if len(receipts) == 0 {
+		return nil, utils.ValidationErrorf("A receipt must contain at least one line")
+	}
+
+	order, err := db.GetPurchaseOrderByID(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != PurchaseOrderStatusSent && order.Status != PurchaseOrderStatusPartiallyReceived {
+		return nil, utils.ValidationErrorf("Only a sent purchase order can be received (current status: %s)", order.Status)
+	}
+
+	// Apply the delivered quantities to the lines
+	lines := make([]PurchaseOrderLine, len(order.Lines))
+	copy(lines, order.Lines)
+	lineIndex := make(map[primitive.ObjectID]int, len(lines))
+	for i, line := range lines {
+		lineIndex[line.ProductID] = i
+	}
+	received := make(map[int]float64, len(receipts))
+	for _, receipt := range receipts {
+		i, ok := lineIndex[receipt.ProductID]
+		if !ok {
+			return nil, utils.ValidationErrorf("Product %s is not part of this purchase order", receipt.ProductID.Hex())
+		}
+		if receipt.Quantity <= 0 {
+			return nil, utils.ValidationErrorf("Received quantity must be greater than 0")
+		}
+		received[i] += receipt.Quantity
+		if received[i] > lines[i].Backorder() {
+			return nil, utils.ValidationErrorf(
+				"Received quantity (%.2f) exceeds the quantity still expected (%.2f) for product %s",
+				received[i],
+				lines[i].Backorder(),
+				receipt.ProductID.Hex(),
+			)
+		}
+	}
+	for i := range lines {
+		lines[i].QuantityReceived += received[i]
+	}
+	status := purchaseOrderStatusAfterReceipt(lines)
+
+	// Checks of supplyStock (read-only operations, before the transaction)
+	isValid, err := db.ValidateStoreCurrency(order.StoreID.Hex(), order.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if !isValid {
+		return nil, utils.ValidationErrorf("Currency %s is not supported by this store", order.Currency)
+	}
+	if order.Currency != "USD" && order.Currency != "EUR" && order.Currency != "CDF" {
+		return nil, utils.ValidationErrorf("Invalid currency code. Supported: USD, EUR, CDF")
+	}
+	provider, err := db.FindProviderByID(order.ProviderID.Hex())
+	if err != nil {
+		return nil, utils.NotFoundErrorf("Provider not found")
+	}
+	if provider.StoreID != order.StoreID {
+		return nil, utils.ValidationErrorf("Provider does not belong to the same store")
+	}
+
+	// Start MongoDB transaction
+	session, err := db.client.StartSession()
+	if err != nil {
+		return nil, utils.DatabaseErrorf("start_session", "Error starting transaction session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	// Transaction context with longer timeout for multiple operations
+	txCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var caisseTransactions []*Trans
+	var movements []*StockMovement
+	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
+		// Start transaction
+		if err := session.StartTransaction(); err != nil {
+			return utils.DatabaseErrorf("start_transaction", "Error starting transaction: %v", err)
+		}
+		caisseTransactions, movements = nil, nil
+
+		// 1. Turn each receipt into a stock supply, like supplyStock
+		for _, receipt := range receipts {
+			i := lineIndex[receipt.ProductID]
+			supply, trans, movement, err := db.supplyPurchaseOrderLine(sc, order, lines[i], receipt, paymentType, date, operatorID)
+			if err != nil {
+				return err
+			}
+			lines[i].SupplyIDs = append(lines[i].SupplyIDs, supply.ID)
+			movements = append(movements, movement)
+			if trans != nil {
+				caisseTransactions = append(caisseTransactions, trans)
+			}
+		}
+
+		// 2. Record the received quantities (the updatedAt guard protects against two concurrent receipts)
+		update := bson.M{
+			"status":    status,
+			"lines":     lines,
+			"updatedAt": now,
+		}
+		if status == PurchaseOrderStatusReceived {
+			update["receivedAt"] = now
+		}
+		result, err := colHelper(db, "purchase_orders").UpdateOne(
+			sc,
+			bson.M{"_id": order.ID, "status": order.Status, "updatedAt": order.UpdatedAt},
+			bson.M{"$set": update},
+		)
+		if err != nil {
+			return utils.DatabaseErrorf("update_purchase_order", "Error updating purchase order: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return utils.ValidationErrorf("Purchase order was modified by another operation, please retry")
+		}
+
+		// Commit transaction
+		if err := session.CommitTransaction(sc); err != nil {
+			return utils.DatabaseErrorf("commit_transaction", "Error committing transaction: %v", err)
+		}
+
+		return nil
+	})
+
+	// Handle transaction errors
+	// MongoDB automatically aborts the transaction if an error occurs in WithSession
+	if err != nil {
+		return nil, err
+	}
+
+	// Flag the received products for the low stock job (best effort, the receipt is already recorded)
+	productIDs := make([]primitive.ObjectID, 0, len(receipts))
+	for _, receipt := range receipts {
+		productIDs = append(productIDs, receipt.ProductID)
+	}
+	if err := db.MarkProductsForStockCheck(productIDs); err != nil {
+		utils.LogError(err, fmt.Sprintf("Failed to flag products of purchase order %s for stock check", order.ID.Hex()))
+	}
+
+	// Notify the subscriptions now that the receipt is committed
+	for _, trans := range caisseTransactions {
+		db.publish(events.Event{Type: events.CaisseUpdated, StoreID: order.StoreID.Hex(), Currency: trans.Currency, Payload: trans})
+	}
+	for _, movement := range movements {
+		db.publish(events.Event{Type: events.StockLevelChanged, StoreID: order.StoreID.Hex(), Payload: movement})
+	}
+
+	return db.GetPurchaseOrderByID(orderID)
+}
+
+// supplyPurchaseOrderLine records the delivered quantity of a line like supplyStock does, within the
+// transaction of the receipt: ProductInStock, StockSupply, ProviderDebt (debt) or caisse Sortie (cash),
+// and the ENTREE movement. It returns the supply, the caisse transaction (cash) and the movement
+func (db *DB) supplyPurchaseOrderLine(sc mongo.SessionContext, order *PurchaseOrder, line PurchaseOrderLine, receipt PurchaseOrderReceipt, paymentType string, date time.Time, operatorID primitive.ObjectID) (*StockSupply, *Trans, *StockMovement, error) {
+	quantity := receipt.Quantity
+	if line.PriceVente < line.PriceAchat {
+		return nil, nil, nil, utils.ValidationErrorf("Price de vente must be >= price d'achat")
+	}
+
+	// Create or update the ProductInStock of the product, provider and batch
+	productInStock, err := db.supplyProductInStock(sc, line.ProductID, line.PriceVente, line.PriceAchat, quantity, order.Currency, order.StoreID, order.ProviderID, receipt.BatchNumber, receipt.ExpiryDate)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	totalAmount := line.PriceAchat * quantity
+	supply := StockSupply{
+		ID:               primitive.NewObjectID(),
+		ProductID:        line.ProductID,
+		ProductInStockID: productInStock.ID,
+		Quantity:         quantity,
+		PriceAchat:       line.PriceAchat,
+		PriceVente:       line.PriceVente,
+		Currency:         order.Currency,
+		ProviderID:       order.ProviderID,
+		StoreID:          order.StoreID,
+		OperatorID:       operatorID,
+		PaymentType:      paymentType,
+		BatchNumber:      receipt.BatchNumber,
+		ExpiryDate:       receipt.ExpiryDate,
+		Date:             date,
+	}
+
+	if paymentType == "debt" {
+		providerDebt, err := db.createProviderDebt(sc, supply.ID, order.ProviderID, order.StoreID, totalAmount, 0, totalAmount, order.Currency)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		supply.ProviderDebtID = &providerDebt.ID
+	}
+
+	if err := db.writeStockSupply(sc, &supply); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Stock movement (ENTREE)
+	movement := StockMovement{
+		ProductID:     line.ProductID,
+		StoreID:       order.StoreID,
+		Type:          StockMovementTypeEntree,
+		Quantity:      quantity,
+		UnitPrice:     line.PriceAchat,
+		TotalValue:    totalAmount,
+		Currency:      order.Currency,
+		Reason:        fmt.Sprintf("Réception commande #%s - Fournisseur: %s", order.ID.Hex(), order.ProviderID.Hex()),
+		Reference:     fmt.Sprintf("supply-%s", supply.ID.Hex()),
+		ReferenceType: "PURCHASE",
+		ReferenceID:   &order.ID,
+		OperatorID:    operatorID,
+	}
+	if err := db.writeStockMovement(sc, &movement); err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Caisse transaction (SORTIE) if paid cash
+	var trans *Trans
+	if paymentType == "cash" && totalAmount > 0 {
+		trans = &Trans{
+			Amount:      totalAmount,
+			Operation:   "Sortie",
+			Description: fmt.Sprintf("Achat stock - Commande #%s, Produit: %s", order.ID.Hex(), line.ProductID.Hex()),
+			Currency:    order.Currency,
+			Purchase:    true,
+			OperatorID:  operatorID,
+			StoreID:     order.StoreID,
+			Date:        date,
+		}
+		if err := db.writeTrans(sc, trans); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	return &supply, trans, &movement, nil
+}
+
+// CancelPurchaseOrder cancels an order that is not fully received; any back-order is dropped
+func (db *DB) CancelPurchaseOrder(orderID string) (*PurchaseOrder, error) {
+	order, err := db.GetPurchaseOrderByID(orderID)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status == PurchaseOrderStatusReceived || order.Status == PurchaseOrderStatusCancelled {
+		return nil, utils.ValidationErrorf("Cannot cancel a purchase order with status %s", order.Status)
+	}
+
+	now := time.Now()
+	if err := db.updatePurchaseOrder(order, bson.M{
+		"status":      PurchaseOrderStatusCancelled,
+		"cancelledAt": now,
+		"updatedAt":   now,
+	}); err != nil {
+		return nil, err
+	}
+
+	return db.GetPurchaseOrderByID(orderID)
+}
+
+// updatePurchaseOrder applies an update only if the order has not changed since it was loaded
+func (db *DB) updatePurchaseOrder(order *PurchaseOrder, set bson.M) error {
+	orderCollection := colHelper(db, "purchase_orders")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	result, err := orderCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": order.ID, "status": order.Status, "updatedAt": order.UpdatedAt},
+		bson.M{"$set": set},
+	)
+	if err != nil {
+		return utils.DatabaseErrorf("update_purchase_order", "Error updating purchase order: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return utils.ValidationErrorf("Purchase order was modified by another operation, please retry")
+	}
+	return nil
+}
+
+// GetPurchaseOrderByID retrieves a purchase order by ID
+func (db *DB) GetPurchaseOrderByID(orderID string) (*PurchaseOrder, error) {
+	objectID, err := primitive.ObjectIDFromHex(orderID)
+	if err != nil {
+		return nil, utils.ValidationErrorf("Invalid purchase order ID")
+	}
+
+	orderCollection := colHelper(db, "purchase_orders")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var order PurchaseOrder
+	err = orderCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&order)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("Purchase order not found")
+		}
+		return nil, utils.DatabaseErrorf("find_purchase_order", "Error finding purchase order: %v", err)
+	}
+
+	return &order, nil
+}
+
+// GetPurchaseOrdersByStoreIDs returns the purchase orders of the given stores with optional filters
+func (db *DB) GetPurchaseOrdersByStoreIDs(storeIDs []primitive.ObjectID, providerID *primitive.ObjectID, status *string) ([]*PurchaseOrder, error) {
+	orderCollection := colHelper(db, "purchase_orders")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := bson.M{"storeId": bson.M{"$in": storeIDs}}
+	if providerID != nil {
+		filter["providerId"] = *providerID
+	}
+	if status != nil {
+		filter["status"] = *status
+	}
+
+	cursor, err := orderCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_purchase_orders", "Error finding purchase orders: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var orders []*PurchaseOrder
+	if err = cursor.All(ctx, &orders); err != nil {
+		return nil, utils.DatabaseErrorf("decode_purchase_orders", "Error decoding purchase orders: %v", err)
+	}
+
+	return orders, nil
+}
@@ -0,0 +1,29 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestPurchaseOrderLineBackorder vérifie le calcul du reliquat d'une ligne de commande
+func TestPurchaseOrderLineBackorder(t *testing.T) {
+	assert.Equal(t, 6.0, PurchaseOrderLine{QuantityOrdered: 10, QuantityReceived: 4}.Backorder())
+	assert.Equal(t, 0.0, PurchaseOrderLine{QuantityOrdered: 10, QuantityReceived: 10}.Backorder())
+	assert.Equal(t, 0.0, PurchaseOrderLine{QuantityOrdered: 10, QuantityReceived: 12}.Backorder(), "Over-delivery should not produce a negative backorder")
+}
+
+// TestPurchaseOrderStatusAfterReceipt vérifie le statut d'une commande après réception
+func TestPurchaseOrderStatusAfterReceipt(t *testing.T) {
+	partial := []PurchaseOrderLine{
+		{QuantityOrdered: 10, QuantityReceived: 10},
+		{QuantityOrdered: 5, QuantityReceived: 2},
+	}
+	assert.Equal(t, PurchaseOrderStatusPartiallyReceived, purchaseOrderStatusAfterReceipt(partial))
+
+	complete := []PurchaseOrderLine{
+		{QuantityOrdered: 10, QuantityReceived: 10},
+		{QuantityOrdered: 5, QuantityReceived: 5},
+	}
+	assert.Equal(t, PurchaseOrderStatusReceived, purchaseOrderStatusAfterReceipt(complete))
+}
@@ -0,0 +1,196 @@
+package database
+
+import (
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Reasons a token family is revoked
+const (
+	TokenRevokedLogout     = "logout"
+	TokenRevokedLogoutAll  = "logout_all"
+	TokenRevokedReuse      = "reuse"          // Un refresh token déjà utilisé a été présenté (vol probable)
+	TokenRevokedBlocked    = "blocked"        // Utilisateur bloqué
+	TokenRevokedSession    = "session"        // Session fermée par revokeSession
+	TokenRevokedPassword   = "password_reset" // Mot de passe réinitialisé par SMS
+	TokenRevokedSwitch     = "switch_company" // Session remplacée par switchCompany
+	TokenRevokedMembership = "membership"     // Utilisateur retiré de l'entreprise de la session
+)
+
+// RefreshToken is an issued refresh token. Every login starts a token family (its Session);
+// each refreshToken call uses the current token once and issues the next one of the family
+type RefreshToken struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	TokenID    string             `bson:"tokenId" json:"tokenId"` // jti du JWT
+	FamilyID   string             `bson:"familyId" json:"familyId"`
+	UserID     primitive.ObjectID `bson:"userId" json:"userId"`
+	ExpiresAt  time.Time          `bson:"expiresAt" json:"expiresAt"` // Index TTL: supprimé à l'expiration
+	UsedAt     *time.Time         `bson:"usedAt,omitempty" json:"usedAt,omitempty"`
+	ReplacedBy string             `bson:"replacedBy,omitempty" json:"replacedBy,omitempty"` // jti du token suivant
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// TokenRevocation is an entry of the revocation list: every token (access or refresh) of the family is rejected
+type TokenRevocation struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	FamilyID  string             `bson:"familyId" json:"familyId"`
+	UserID    primitive.ObjectID `bson:"userId" json:"userId"`
+	Reason    string             `bson:"reason" json:"reason"`
+	RevokedAt time.Time          `bson:"revokedAt" json:"revokedAt"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"` // Plus aucun token de la famille n'est valide après
+}
+
+// CreateRefreshToken persists an issued refresh token
+func (db *DB) CreateRefreshToken(tokenID, familyID string, userID primitive.ObjectID, expiresAt time.Time) (*RefreshToken, error) {
+	refreshTokenCollection := colHelper(db, "refresh_tokens")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	refreshToken := RefreshToken{
+		ID:        primitive.NewObjectID(),
+		TokenID:   tokenID,
+		FamilyID:  familyID,
+		UserID:    userID,
+		ExpiresAt: expiresAt,
+		CreatedAt: time.Now(),
+	}
+
+	_, err := refreshTokenCollection.InsertOne(ctx, refreshToken)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("create_refresh_token", "Error creating refresh token: %v", err)
+	}
+
+	return &refreshToken, nil
+}
+
+// FindRefreshToken finds a refresh token by its jti
+func (db *DB) FindRefreshToken(tokenID string) (*RefreshToken, error) {
+	refreshTokenCollection := colHelper(db, "refresh_tokens")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var refreshToken RefreshToken
+	err := refreshTokenCollection.FindOne(ctx, bson.M{"tokenId": tokenID}).Decode(&refreshToken)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("Refresh token not found")
+		}
+		return nil, utils.DatabaseErrorf("find_refresh_token", "Error finding refresh token: %v", err)
+	}
+
+	return &refreshToken, nil
+}
+
+// UseRefreshToken marks a refresh token as used by the rotation, replaced by the token replacedBy.
+// It returns false when the token was already used: the caller must treat it as a reuse
+func (db *DB) UseRefreshToken(tokenID, replacedBy string) (bool, error) {
+	refreshTokenCollection := colHelper(db, "refresh_tokens")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	// Conditional update: of two concurrent refreshes with the same token, only one wins
+	result, err := refreshTokenCollection.UpdateOne(ctx, bson.M{
+		"tokenId": tokenID,
+		"usedAt":  bson.M{"$exists": false},
+	}, bson.M{
+		"$set": bson.M{
+			"usedAt":     time.Now(),
+			"replacedBy": replacedBy,
+		},
+	})
+	if err != nil {
+		return false, utils.DatabaseErrorf("use_refresh_token", "Error rotating refresh token: %v", err)
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// RevokeTokenFamily adds the token family to the revocation list
+func (db *DB) RevokeTokenFamily(familyID string, userID primitive.ObjectID, reason string) error {
+	revocationCollection := colHelper(db, "token_revocations")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	// Upsert: revoking twice keeps the first reason
+	_, err := revocationCollection.UpdateOne(ctx,
+		bson.M{"familyId": familyID},
+		bson.M{"$setOnInsert": bson.M{
+			"_id":       primitive.NewObjectID(),
+			"familyId":  familyID,
+			"userId":    userID,
+			"reason":    reason,
+			"revokedAt": now,
+			"expiresAt": now.Add(utils.JWTRefreshTokenExpiration),
+		}},
+		options.Update().SetUpsert(true),
+	)
+	if err != nil {
+		return utils.DatabaseErrorf("revoke_token_family", "Error revoking token family: %v", err)
+	}
+
+	// The session of the family is ended too
+	return db.markSessionRevoked(familyID, reason)
+}
+
+// RevokeUserTokens revokes every token family of the user that still has a valid refresh token
+// and returns the number of families revoked
+func (db *DB) RevokeUserTokens(userID primitive.ObjectID, reason string) (int, error) {
+	refreshTokenCollection := colHelper(db, "refresh_tokens")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	familyIDs, err := refreshTokenCollection.Distinct(ctx, "familyId", bson.M{
+		"userId":    userID,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	})
+	if err != nil {
+		return 0, utils.DatabaseErrorf("find_token_families", "Error finding token families: %v", err)
+	}
+
+	revoked := 0
+	for _, value := range familyIDs {
+		familyID, ok := value.(string)
+		if !ok || familyID == "" {
+			continue
+		}
+		if err := db.RevokeTokenFamily(familyID, userID, reason); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+
+	if revoked > 0 {
+		utils.Info("Revoked %d token families of user %s (%s)", revoked, userID.Hex(), reason)
+	}
+	return revoked, nil
+}
+
+// IsTokenFamilyRevoked tells whether the token family is on the revocation list
+func (db *DB) IsTokenFamilyRevoked(familyID string) (bool, error) {
+	revocationCollection := colHelper(db, "token_revocations")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	count, err := revocationCollection.CountDocuments(ctx, bson.M{"familyId": familyID}, options.Count().SetLimit(1))
+	if err != nil {
+		return false, utils.DatabaseErrorf("check_token_revocation", "Error checking token revocation: %v", err)
+	}
+
+	return count > 0, nil
+}
+
+// IsTokenRevoked tells whether a JWT was revoked (logout, reuse of a refresh token, blocked user).
+// Tokens issued before the token families existed have no family and stay valid until they expire
+func (db *DB) IsTokenRevoked(claims *utils.JwtCustomClaim) (bool, error) {
+	if claims == nil || claims.FamilyID == "" {
+		return false, nil
+	}
+	return db.IsTokenFamilyRevoked(claims.FamilyID)
+}
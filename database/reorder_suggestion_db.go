@@ -0,0 +1,271 @@
+package database
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultReorderLookbackDays is the sales history used to compute the average daily consumption
+const DefaultReorderLookbackDays = 30
+
+// ReorderSuggestion is the quantity of a product worth reordering to cover the horizon
+type ReorderSuggestion struct {
+	Product                 *Product
+	Stock                   float64  // Stock vendable actuel
+	AverageDailyConsumption float64  // Ventes nettes (retours et annulations déduits) par jour
+	DaysOfCover             *float64 // nil si le produit ne se vend pas
+	SuggestedQuantity       float64
+	PriceAchat              float64 // Prix d'achat du dernier approvisionnement
+	Currency                string
+}
+
+// ReorderSuggestionGroup gathers the suggestions of the provider last used for each product
+type ReorderSuggestionGroup struct {
+	ProviderID  *primitive.ObjectID // nil pour les produits jamais approvisionnés
+	Suggestions []*ReorderSuggestion
+	TotalAmount float64 // Dans Currency (devise de la boutique), prix d'achat convertis aux taux de l'entreprise
+	Currency    string
+}
+
+// lastSupply is the provider and prices of the most recent supply of a product
+type lastSupply struct {
+	ProductID  primitive.ObjectID `bson:"_id"`
+	ProviderID primitive.ObjectID `bson:"providerId"`
+	PriceAchat float64            `bson:"priceAchat"`
+	Currency   string             `bson:"currency"`
+}
+
+// suggestReorderQuantity returns the quantity needed to cover horizonDays of consumption on top of the
+// reorder point (safety stock), rounded up, and at least the reorder quantity when something is needed
+func suggestReorderQuantity(stock, averageDailyConsumption float64, horizonDays int, reorderPoint, reorderQuantity float64) float64 {
+	target := averageDailyConsumption*float64(horizonDays) + reorderPoint
+	needed := math.Ceil(target - stock)
+	if needed <= 0 {
+		return 0
+	}
+	if needed < reorderQuantity {
+		return reorderQuantity
+	}
+	return needed
+}
+
+// daysOfCover returns how many days the stock lasts at the current consumption (nil without consumption)
+func daysOfCover(stock, averageDailyConsumption float64) *float64 {
+	if averageDailyConsumption <= 0 {
+		return nil
+	}
+	days := math.Max(stock, 0) / averageDailyConsumption
+	return &days
+}
+
+// GetReorderSuggestions computes, for each product of the store, the average daily consumption over the
+// last lookbackDays, the days of cover left and the quantity to reorder to cover horizonDays.
+// Products that need nothing are left out; the rest is grouped by the provider of their last supply.
+func (db *DB) GetReorderSuggestions(storeID primitive.ObjectID, horizonDays, lookbackDays int) ([]*ReorderSuggestionGroup, error) {
+	if horizonDays <= 0 || lookbackDays <= 0 {
+		return nil, utils.ValidationErrorf("Horizon and lookback must be at least one day")
+	}
+
+	products, err := db.FindProductsByStoreIDs([]primitive.ObjectID{storeID})
+	if err != nil {
+		return nil, err
+	}
+	if len(products) == 0 {
+		return []*ReorderSuggestionGroup{}, nil
+	}
+	productIDs := make([]primitive.ObjectID, 0, len(products))
+	for _, product := range products {
+		productIDs = append(productIDs, product.ID)
+	}
+
+	since := time.Now().AddDate(0, 0, -lookbackDays)
+	consumption, err := db.netSalesByProduct(storeID, since)
+	if err != nil {
+		return nil, err
+	}
+	levels, err := db.sellableStockByProduct(productIDs)
+	if err != nil {
+		return nil, err
+	}
+	supplies, err := db.lastSupplyByProduct(storeID, productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	var suggestions []*ReorderSuggestion
+	for _, product := range products {
+		averageDaily := math.Max(consumption[product.ID], 0) / float64(lookbackDays)
+		stock := levels[product.ID]
+		quantity := suggestReorderQuantity(stock, averageDaily, horizonDays, product.ReorderPoint, product.ReorderQuantity)
+		if quantity <= 0 {
+			continue
+		}
+		suggestion := &ReorderSuggestion{
+			Product:                 product,
+			Stock:                   stock,
+			AverageDailyConsumption: averageDaily,
+			DaysOfCover:             daysOfCover(stock, averageDaily),
+			SuggestedQuantity:       quantity,
+		}
+		if supply, ok := supplies[product.ID]; ok {
+			suggestion.PriceAchat = supply.PriceAchat
+			suggestion.Currency = supply.Currency
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+
+	// Most urgent first: fewest days of cover, products without consumption last
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		a, b := suggestions[i].DaysOfCover, suggestions[j].DaysOfCover
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return *a < *b
+	})
+
+	// The totals are in the currency of the store: the lots of a provider may be bought in several currencies
+	store, err := db.FindStoreByID(storeID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	currency := store.DefaultCurrency
+	if currency == "" {
+		currency = "USD" // Fallback to USD
+	}
+	return groupReorderSuggestions(suggestions, supplies, currency, func(amount float64, from string) (float64, error) {
+		return db.ConvertCurrency(store.CompanyID.Hex(), amount, from, currency)
+	})
+}
+
+// groupReorderSuggestions groups the suggestions by the provider of their last supply, in order, and totals
+// each group in currency; convert converts an amount of another currency
+func groupReorderSuggestions(suggestions []*ReorderSuggestion, supplies map[primitive.ObjectID]lastSupply, currency string, convert func(amount float64, from string) (float64, error)) ([]*ReorderSuggestionGroup, error) {
+	groups := []*ReorderSuggestionGroup{}
+	groupByProvider := make(map[primitive.ObjectID]*ReorderSuggestionGroup)
+	for _, suggestion := range suggestions {
+		var providerID *primitive.ObjectID
+		key := primitive.NilObjectID
+		if supply, ok := supplies[suggestion.Product.ID]; ok {
+			id := supply.ProviderID
+			providerID = &id
+			key = id
+		}
+		group, ok := groupByProvider[key]
+		if !ok {
+			group = &ReorderSuggestionGroup{ProviderID: providerID, Currency: currency}
+			groupByProvider[key] = group
+			groups = append(groups, group)
+		}
+		group.Suggestions = append(group.Suggestions, suggestion)
+
+		amount := suggestion.SuggestedQuantity * suggestion.PriceAchat
+		if amount > 0 && suggestion.Currency != "" && suggestion.Currency != currency {
+			converted, err := convert(amount, suggestion.Currency)
+			if err != nil {
+				return nil, err
+			}
+			amount = converted
+		}
+		group.TotalAmount = roundAmount(group.TotalAmount + amount)
+	}
+	return groups, nil
+}
+
+// netSalesByProduct sums the quantities sold per product since the given date, minus cancellations and returns
+func (db *DB) netSalesByProduct(storeID primitive.ObjectID, since time.Time) (map[primitive.ObjectID]float64, error) {
+	stockMovementCollection := colHelper(db, "stock_movements")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"storeId":       storeID,
+				"createdAt":     bson.M{"$gte": since},
+				"referenceType": bson.M{"$in": []string{"SALE", "SALE_CANCEL", "SALE_RETURN"}},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id": "$productId",
+				"quantity": bson.M{
+					"$sum": bson.M{
+						"$cond": []interface{}{
+							bson.M{"$eq": []interface{}{"$type", StockMovementTypeSortie}},
+							"$quantity",
+							bson.M{"$multiply": []interface{}{"$quantity", -1}},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	cursor, err := stockMovementCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("net_sales_by_product", "Error calculating sales per product: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		ProductID primitive.ObjectID `bson:"_id"`
+		Quantity  float64            `bson:"quantity"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, utils.DatabaseErrorf("net_sales_by_product", "Error decoding sales per product: %v", err)
+	}
+
+	sales := make(map[primitive.ObjectID]float64, len(results))
+	for _, result := range results {
+		sales[result.ProductID] = result.Quantity
+	}
+	return sales, nil
+}
+
+// lastSupplyByProduct returns the most recent stock supply of each product in the store
+func (db *DB) lastSupplyByProduct(storeID primitive.ObjectID, productIDs []primitive.ObjectID) (map[primitive.ObjectID]lastSupply, error) {
+	supplyCollection := colHelper(db, "stock_supplies")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"storeId":   storeID,
+				"productId": bson.M{"$in": productIDs},
+			},
+		},
+		{"$sort": bson.D{{Key: "date", Value: -1}, {Key: "createdAt", Value: -1}}},
+		{
+			"$group": bson.M{
+				"_id":        "$productId",
+				"providerId": bson.M{"$first": "$providerId"},
+				"priceAchat": bson.M{"$first": "$priceAchat"},
+				"currency":   bson.M{"$first": "$currency"},
+			},
+		},
+	}
+
+	cursor, err := supplyCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("last_supply_by_product", "Error finding last supplies: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []lastSupply
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, utils.DatabaseErrorf("last_supply_by_product", "Error decoding last supplies: %v", err)
+	}
+
+	supplies := make(map[primitive.ObjectID]lastSupply, len(results))
+	for _, result := range results {
+		supplies[result.ProductID] = result
+	}
+	return supplies, nil
+}
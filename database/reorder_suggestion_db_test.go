@@ -0,0 +1,63 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestSuggestReorderQuantity vérifie la quantité suggérée pour couvrir l'horizon
+func TestSuggestReorderQuantity(t *testing.T) {
+	assert.Equal(t, 50.0, suggestReorderQuantity(10, 2, 30, 0, 0), "Covers 30 days at 2 per day minus the stock")
+	assert.Equal(t, 55.0, suggestReorderQuantity(10, 2, 30, 5, 0), "The reorder point is kept as safety stock")
+	assert.Equal(t, 8.0, suggestReorderQuantity(0, 0.25, 30, 0, 0), "Quantities are rounded up")
+	assert.Equal(t, 100.0, suggestReorderQuantity(10, 2, 30, 0, 100), "The reorder quantity is the minimum order")
+	assert.Equal(t, 0.0, suggestReorderQuantity(100, 2, 30, 0, 100), "Enough stock needs no order")
+	assert.Equal(t, 0.0, suggestReorderQuantity(0, 0, 30, 0, 20), "Unsold products without reorder point need no order")
+	assert.Equal(t, 20.0, suggestReorderQuantity(3, 0, 30, 5, 20), "Stock under the reorder point is reordered even without sales")
+}
+
+// TestDaysOfCover vérifie le nombre de jours de stock restants
+func TestDaysOfCover(t *testing.T) {
+	assert.Nil(t, daysOfCover(10, 0), "No consumption means no cover estimate")
+	if days := daysOfCover(10, 4); assert.NotNil(t, days) {
+		assert.Equal(t, 2.5, *days)
+	}
+	if days := daysOfCover(-3, 4); assert.NotNil(t, days) {
+		assert.Equal(t, 0.0, *days, "Negative stock has no cover left")
+	}
+}
+
+// TestGroupReorderSuggestions vérifie le regroupement par fournisseur et le total dans la devise de la boutique
+func TestGroupReorderSuggestions(t *testing.T) {
+	providerID := primitive.NewObjectID()
+	usdLot := &ReorderSuggestion{Product: &Product{ID: primitive.NewObjectID()}, SuggestedQuantity: 10, PriceAchat: 2, Currency: "USD"}
+	cdfLot := &ReorderSuggestion{Product: &Product{ID: primitive.NewObjectID()}, SuggestedQuantity: 5, PriceAchat: 2200, Currency: "CDF"}
+	neverSupplied := &ReorderSuggestion{Product: &Product{ID: primitive.NewObjectID()}, SuggestedQuantity: 3}
+	supplies := map[primitive.ObjectID]lastSupply{
+		usdLot.Product.ID: {ProviderID: providerID, PriceAchat: 2, Currency: "USD"},
+		cdfLot.Product.ID: {ProviderID: providerID, PriceAchat: 2200, Currency: "CDF"},
+	}
+	toUSD := func(amount float64, from string) (float64, error) {
+		require.Equal(t, "CDF", from)
+		return amount / 2200, nil
+	}
+
+	groups, err := groupReorderSuggestions([]*ReorderSuggestion{usdLot, neverSupplied, cdfLot}, supplies, "USD", toUSD)
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Equal(t, providerID, *groups[0].ProviderID)
+	assert.Equal(t, []*ReorderSuggestion{usdLot, cdfLot}, groups[0].Suggestions)
+	assert.Equal(t, 25.0, groups[0].TotalAmount, "20 USD + 11000 CDF converted to 5 USD")
+	assert.Equal(t, "USD", groups[0].Currency)
+	assert.Nil(t, groups[1].ProviderID)
+	assert.Equal(t, 0.0, groups[1].TotalAmount)
+
+	_, err = groupReorderSuggestions([]*ReorderSuggestion{cdfLot}, supplies, "USD", func(float64, string) (float64, error) {
+		return 0, errors.New("no rate")
+	})
+	assert.Error(t, err, "A missing exchange rate is reported")
+}
@@ -0,0 +1,429 @@
+package database
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Permissions checked by the @auth(permission: ...) directive and the resolvers
+const (
+	PermissionSaleCreate      = "sale.create"
+	PermissionSaleCancel      = "sale.cancel"
+	PermissionSaleReturn      = "sale.return"
+	PermissionCaisseView      = "caisse.view"
+	PermissionCaisseManage    = "caisse.manage"
+	PermissionStockSupply     = "stock.supply"
+	PermissionStockAdjust     = "stock.adjust"
+	PermissionStockTransfer   = "stock.transfer"
+	PermissionStockExpiry     = "stock.expiry"
+	PermissionPriceViewCost   = "price.viewCost"
+	PermissionProductManage   = "product.manage"
+	PermissionClientManage    = "client.manage"
+	PermissionClientCredit    = "client.credit"
+	PermissionProviderManage  = "provider.manage"
+	PermissionReportView      = "report.view"
+	PermissionUserManage      = "user.manage"
+	PermissionRoleManage      = "role.manage"
+	PermissionStoreManage     = "store.manage"
+	PermissionCompanyManage   = "company.manage"
+	PermissionAuditView       = "audit.view"
+	PermissionAPIKeyManage    = "apikey.manage"
+	PermissionPromotionManage = "promotion.manage"
+)
+
+// PermissionInfo describes a permission of the catalogue
+type PermissionInfo struct {
+	Key         string
+	Description string
+}
+
+// Permissions is the catalogue of the permissions a role can grant
+var Permissions = []PermissionInfo{
+	{PermissionSaleCreate, "Enregistrer des ventes"},
+	{PermissionSaleCancel, "Annuler des ventes"},
+	{PermissionSaleReturn, "Enregistrer des retours clients"},
+	{PermissionCaisseView, "Consulter la caisse et ses rapports"},
+	{PermissionCaisseManage, "Créer et supprimer des transactions de caisse"},
+	{PermissionStockSupply, "Approvisionner le stock et gérer les bons de commande"},
+	{PermissionStockAdjust, "Inventaires, mouvements manuels et seuils de réapprovisionnement"},
+	{PermissionStockTransfer, "Transférer du stock entre boutiques"},
+	{PermissionStockExpiry, "Configurer la mise au rebut des lots périmés"},
+	{PermissionPriceViewCost, "Voir les prix d'achat et les bénéfices"},
+	{PermissionProductManage, "Créer, modifier et supprimer des produits"},
+	{PermissionClientManage, "Créer, modifier et supprimer des clients"},
+	{PermissionClientCredit, "Modifier les limites de crédit des clients"},
+	{PermissionProviderManage, "Créer, modifier et supprimer des fournisseurs"},
+	{PermissionReportView, "Consulter les rapports et statistiques"},
+	{PermissionUserManage, "Gérer les utilisateurs et leurs sessions"},
+	{PermissionRoleManage, "Gérer les rôles et les attribuer"},
+	{PermissionStoreManage, "Créer, modifier et supprimer des boutiques"},
+	{PermissionCompanyManage, "Modifier l'entreprise et ses taux de change"},
+	{PermissionAuditView, "Consulter le journal d'audit"},
+	{PermissionAPIKeyManage, "Créer et révoquer les clés d'API des intégrations"},
+	{PermissionPromotionManage, "Créer, modifier et supprimer les promotions"},
+}
+
+// Built-in roles: the two historical roles of User.Role
+const (
+	RoleAdmin = "Admin"
+	RoleUser  = "User"
+)
+
+// userPermissions are the permissions of the built-in "User" role: everything but the administration
+// and the purchase prices and margins (price.viewCost, granted through a company role)
+var userPermissions = []string{
+	PermissionSaleCreate,
+	PermissionSaleCancel,
+	PermissionSaleReturn,
+	PermissionCaisseView,
+	PermissionCaisseManage,
+	PermissionStockSupply,
+	PermissionStockAdjust,
+	PermissionStockTransfer,
+	PermissionProductManage,
+	PermissionClientManage,
+	PermissionProviderManage,
+	PermissionReportView,
+}
+
+// Role is a named set of permissions defined by a company for its "User" users
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	CompanyID   primitive.ObjectID `bson:"companyId" json:"companyId"`
+	Name        string             `bson:"name" json:"name"`
+	Description string             `bson:"description,omitempty" json:"description,omitempty"`
+	Permissions []string           `bson:"permissions" json:"permissions"`
+	BuiltIn     bool               `bson:"-" json:"builtIn"` // Admin et User, définis dans le code
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// DefaultRoleTemplates are the roles createDefaultRoles adds to a company
+var DefaultRoleTemplates = []Role{
+	{
+		Name:        "Manager",
+		Description: "Gère la boutique: ventes, stock, caisse et rapports",
+		Permissions: []string{
+			PermissionSaleCreate, PermissionSaleCancel, PermissionSaleReturn,
+			PermissionCaisseView, PermissionCaisseManage,
+			PermissionStockSupply, PermissionStockAdjust, PermissionStockTransfer, PermissionStockExpiry,
+			PermissionPriceViewCost, PermissionProductManage, PermissionClientManage, PermissionClientCredit,
+			PermissionProviderManage, PermissionReportView, PermissionPromotionManage,
+		},
+	},
+	{
+		Name:        "Cashier",
+		Description: "Caissier: ventes, retours et caisse",
+		Permissions: []string{
+			PermissionSaleCreate, PermissionSaleReturn, PermissionCaisseView, PermissionClientManage,
+		},
+	},
+	{
+		Name:        "Stock keeper",
+		Description: "Magasinier: approvisionnements, inventaires et transferts",
+		Permissions: []string{
+			PermissionStockSupply, PermissionStockAdjust, PermissionStockTransfer,
+			PermissionProductManage, PermissionProviderManage,
+		},
+	},
+	{
+		Name:        "Accountant",
+		Description: "Comptable: caisse, coûts, rapports et journal d'audit",
+		Permissions: []string{
+			PermissionCaisseView, PermissionPriceViewCost, PermissionReportView, PermissionAuditView,
+		},
+	},
+}
+
+// allPermissionKeys returns the keys of the catalogue
+func allPermissionKeys() []string {
+	keys := make([]string, 0, len(Permissions))
+	for _, permission := range Permissions {
+		keys = append(keys, permission.Key)
+	}
+	return keys
+}
+
+// BuiltInRoles returns the Admin (every permission) and User roles
+func BuiltInRoles() []*Role {
+	return []*Role{
+		{Name: RoleAdmin, Description: "Administrateur: toutes les permissions", Permissions: allPermissionKeys(), BuiltIn: true},
+		{Name: RoleUser, Description: "Utilisateur d'une boutique", Permissions: append([]string{}, userPermissions...), BuiltIn: true},
+	}
+}
+
+// normalizePermissions checks the permissions against the catalogue and removes duplicates
+func normalizePermissions(permissions []string) ([]string, error) {
+	known := make(map[string]bool, len(Permissions))
+	for _, permission := range Permissions {
+		known[permission.Key] = true
+	}
+
+	seen := make(map[string]bool, len(permissions))
+	normalized := make([]string, 0, len(permissions))
+	for _, permission := range permissions {
+		permission = strings.TrimSpace(permission)
+		if !known[permission] {
+			return nil, utils.ValidationErrorf("Unknown permission: %s", permission)
+		}
+		if seen[permission] {
+			continue
+		}
+		seen[permission] = true
+		normalized = append(normalized, permission)
+	}
+	sort.Strings(normalized)
+	return normalized, nil
+}
+
+// isBuiltInRoleName tells whether a name is reserved by a built-in role
+func isBuiltInRoleName(name string) bool {
+	return strings.EqualFold(name, RoleAdmin) || strings.EqualFold(name, RoleUser)
+}
+
+// CreateRole creates a role of the company
+func (db *DB) CreateRole(companyID primitive.ObjectID, name, description string, permissions []string) (*Role, error) {
+	if isBuiltInRoleName(name) {
+		return nil, utils.ValidationErrorf("Role name %s is reserved", name)
+	}
+	normalized, err := normalizePermissions(permissions)
+	if err != nil {
+		return nil, err
+	}
+
+	roleCollection := colHelper(db, "roles")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	role := Role{
+		ID:          primitive.NewObjectID(),
+		CompanyID:   companyID,
+		Name:        name,
+		Description: description,
+		Permissions: normalized,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	_, err = roleCollection.InsertOne(ctx, role)
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, utils.ValidationErrorf("A role named %s already exists", name)
+		}
+		return nil, utils.DatabaseErrorf("create_role", "Error creating role: %v", err)
+	}
+
+	return &role, nil
+}
+
+// CreateDefaultRoles adds the default roles (Manager, Cashier, Stock keeper, Accountant) the company does not have yet
+func (db *DB) CreateDefaultRoles(companyID primitive.ObjectID) ([]*Role, error) {
+	existing, err := db.FindRolesByCompanyID(companyID)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(existing))
+	for _, role := range existing {
+		names[strings.ToLower(role.Name)] = true
+	}
+
+	created := []*Role{}
+	for _, template := range DefaultRoleTemplates {
+		if names[strings.ToLower(template.Name)] {
+			continue
+		}
+		role, err := db.CreateRole(companyID, template.Name, template.Description, template.Permissions)
+		if err != nil {
+			return nil, err
+		}
+		created = append(created, role)
+	}
+
+	return created, nil
+}
+
+// FindRoleByID finds a role of the company
+func (db *DB) FindRoleByID(id string, companyID primitive.ObjectID) (*Role, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, utils.ValidationErrorf("Invalid role ID")
+	}
+
+	roleCollection := colHelper(db, "roles")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var role Role
+	err = roleCollection.FindOne(ctx, bson.M{"_id": objectID, "companyId": companyID}).Decode(&role)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("Role not found")
+		}
+		return nil, utils.DatabaseErrorf("find_role", "Error finding role: %v", err)
+	}
+
+	return &role, nil
+}
+
+// FindRolesByCompanyID returns the roles defined by the company, by name
+func (db *DB) FindRolesByCompanyID(companyID primitive.ObjectID) ([]*Role, error) {
+	roleCollection := colHelper(db, "roles")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}})
+	cursor, err := roleCollection.Find(ctx, bson.M{"companyId": companyID}, opts)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_roles", "Error finding roles: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var roles []*Role
+	if err = cursor.All(ctx, &roles); err != nil {
+		return nil, utils.DatabaseErrorf("find_roles", "Error decoding roles: %v", err)
+	}
+	if roles == nil {
+		roles = []*Role{}
+	}
+
+	return roles, nil
+}
+
+// UpdateRole updates the name, description or permissions of a role of the company
+func (db *DB) UpdateRole(id string, companyID primitive.ObjectID, name, description *string, permissions []string) (*Role, error) {
+	role, err := db.FindRoleByID(id, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	update := bson.M{"updatedAt": time.Now()}
+	if name != nil {
+		if isBuiltInRoleName(*name) {
+			return nil, utils.ValidationErrorf("Role name %s is reserved", *name)
+		}
+		update["name"] = *name
+	}
+	if description != nil {
+		update["description"] = *description
+	}
+	if permissions != nil {
+		normalized, err := normalizePermissions(permissions)
+		if err != nil {
+			return nil, err
+		}
+		update["permissions"] = normalized
+	}
+
+	roleCollection := colHelper(db, "roles")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err = roleCollection.UpdateOne(ctx, bson.M{"_id": role.ID}, bson.M{"$set": update})
+	if err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, utils.ValidationErrorf("A role named %s already exists", *name)
+		}
+		return nil, utils.DatabaseErrorf("update_role", "Error updating role: %v", err)
+	}
+
+	return db.FindRoleByID(id, companyID)
+}
+
+// DeleteRole deletes a role of the company that is not assigned to any user
+func (db *DB) DeleteRole(id string, companyID primitive.ObjectID) error {
+	role, err := db.FindRoleByID(id, companyID)
+	if err != nil {
+		return err
+	}
+
+	membershipCollection := colHelper(db, "memberships")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	count, err := membershipCollection.CountDocuments(ctx, bson.M{"roleId": role.ID})
+	if err != nil {
+		return utils.DatabaseErrorf("count_role_users", "Error counting role users: %v", err)
+	}
+	if count == 0 {
+		// Users created before memberships
+		userCollection := colHelper(db, "users")
+		count, err = userCollection.CountDocuments(ctx, bson.M{"roleId": role.ID})
+		if err != nil {
+			return utils.DatabaseErrorf("count_role_users", "Error counting role users: %v", err)
+		}
+	}
+	if count > 0 {
+		return utils.ValidationErrorf("Role is assigned to %d user(s)", count)
+	}
+
+	roleCollection := colHelper(db, "roles")
+	_, err = roleCollection.DeleteOne(ctx, bson.M{"_id": role.ID})
+	if err != nil {
+		return utils.DatabaseErrorf("delete_role", "Error deleting role: %v", err)
+	}
+
+	return nil
+}
+
+// AssignUserRole gives a company role to a "User" member, or brings them back to the built-in User role (roleID nil)
+func (db *DB) AssignUserRole(userID string, companyID primitive.ObjectID, roleID *primitive.ObjectID) (*User, error) {
+	user, err := db.FindUserInCompany(userID, companyID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == RoleAdmin {
+		return nil, utils.ValidationErrorf("Admins have every permission, roles only apply to users")
+	}
+
+	if roleID != nil {
+		if _, err := db.FindRoleByID(roleID.Hex(), companyID); err != nil {
+			return nil, err
+		}
+	}
+
+	m := membershipFromUser(user)
+	m.RoleID = roleID
+	if err := db.updateMembership(m); err != nil {
+		return nil, err
+	}
+
+	return db.FindUserInCompany(userID, companyID)
+}
+
+// GetUserPermissions returns the permissions of the user: every permission for an Admin,
+// the permissions of their company role when they have one, the built-in User role otherwise
+func (db *DB) GetUserPermissions(user *User) ([]string, error) {
+	if user.IsBlocked {
+		return []string{}, nil
+	}
+	if user.Role == RoleAdmin {
+		return allPermissionKeys(), nil
+	}
+	if user.RoleID != nil {
+		role, err := db.FindRoleByID(user.RoleID.Hex(), user.CompanyID)
+		if err != nil {
+			return nil, err
+		}
+		return role.Permissions, nil
+	}
+	return append([]string{}, userPermissions...), nil
+}
+
+// GetMemberPermissions loads the user of a token with their membership in the company
+// of the token and returns their permissions
+func (db *DB) GetMemberPermissions(userID, companyID string) ([]string, error) {
+	user, err := db.FindUserForToken(userID, companyID)
+	if err != nil {
+		return nil, err
+	}
+	return db.GetUserPermissions(user)
+}
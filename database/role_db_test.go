@@ -0,0 +1,33 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestNormalizePermissions vérifie que les permissions sont dédupliquées, triées et connues du catalogue
+func TestNormalizePermissions(t *testing.T) {
+	normalized, err := normalizePermissions([]string{PermissionSaleCreate, " caisse.view ", PermissionSaleCreate})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{PermissionCaisseView, PermissionSaleCreate}, normalized)
+
+	_, err = normalizePermissions([]string{PermissionSaleCreate, "sale.delete"})
+	assert.Error(t, err, "Unknown permission")
+}
+
+// TestBuiltInRoles vérifie la migration des rôles historiques: Admin a tout, User n'a pas l'administration
+func TestBuiltInRoles(t *testing.T) {
+	roles := BuiltInRoles()
+	assert.Len(t, roles, 2)
+	assert.Len(t, roles[0].Permissions, len(Permissions))
+	assert.Contains(t, roles[1].Permissions, PermissionSaleCreate)
+	assert.NotContains(t, roles[1].Permissions, PermissionUserManage)
+	assert.NotContains(t, roles[1].Permissions, PermissionRoleManage)
+	assert.NotContains(t, roles[1].Permissions, PermissionPriceViewCost, "Costs are hidden from users by default")
+
+	for _, template := range DefaultRoleTemplates {
+		_, err := normalizePermissions(template.Permissions)
+		assert.NoError(t, err, template.Name)
+	}
+}
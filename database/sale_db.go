@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"rangoapp/events"
 	"rangoapp/utils"
 
 	"go.mongodb.org/mongo-driver/bson"
@@ -17,6 +18,11 @@ type ProductInBasket struct {
 	ProductInStockID primitive.ObjectID `bson:"productInStockId" json:"productInStockId"`
 	Quantity         float64            `bson:"quantity" json:"quantity"`
 	Price            float64            `bson:"price" json:"price"`
+	// Produit demandé quand le lot a été choisi par le serveur (voir AllocateProductLots)
+	ProductID *primitive.ObjectID `bson:"productId,omitempty" json:"productId,omitempty"`
+	// Remise des promotions sur la ligne (montant total, pas unitaire), calculée par CreateSale
+	Discount     float64              `bson:"discount,omitempty" json:"discount,omitempty"`
+	PromotionIDs []primitive.ObjectID `bson:"promotionIds,omitempty" json:"promotionIds,omitempty"`
 }
 
 type Sale struct {
@@ -36,11 +42,38 @@ type Sale struct {
 	Date        time.Time           `bson:"date" json:"date"`
 	CreatedAt   time.Time           `bson:"createdAt" json:"createdAt"`
 	UpdatedAt   time.Time           `bson:"updatedAt" json:"updatedAt"`
+
+	// Cancellation (see CancelSale): stock, caisse and debt are reversed, the sale is kept for history
+	CancelledAt  *time.Time          `bson:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+	CancelledBy  *primitive.ObjectID `bson:"cancelledBy,omitempty" json:"cancelledBy,omitempty"`
+	CancelReason string              `bson:"cancelReason,omitempty" json:"cancelReason,omitempty"`
+
+	// Returns (see CreateSaleReturn): running totals of the sale_returns recorded against this sale
+	ReturnedAmount   float64 `bson:"returnedAmount,omitempty" json:"returnedAmount,omitempty"`
+	ReturnedBenefice float64 `bson:"returnedBenefice,omitempty" json:"returnedBenefice,omitempty"`
+
+	// Promotions (see applyPromotions): total discount, already deducted from priceToPay
+	Discount   float64            `bson:"discount,omitempty" json:"discount,omitempty"`
+	Promotions []AppliedPromotion `bson:"promotions,omitempty" json:"promotions,omitempty"`
+
+	// Tenders (see normalizeSalePayments): pricePayed is their total in the currency of the sale
+	Payments []SalePayment `bson:"payments,omitempty" json:"payments,omitempty"`
+
+	// Multi-currency tenders: exchange rates at the time of the sale and change given in ChangeCurrency
+	ExchangeRates  []SaleExchangeRate `bson:"exchangeRates,omitempty" json:"exchangeRates,omitempty"`
+	ChangeCurrency string             `bson:"changeCurrency,omitempty" json:"changeCurrency,omitempty"`
+	ChangeAmount   float64            `bson:"changeAmount,omitempty" json:"changeAmount,omitempty"`
 }
 
-// CreateSale creates a new sale entry and automatically creates a caisse transaction
+// CreateSale creates a new sale entry and automatically creates a caisse transaction.
+// The active promotions of the store are applied to the basket (see applyPromotions) and
+// priceToPay is lowered to the discounted total when it is higher.
+// With split tenders (payments), pricePayed is their total converted with the exchange rates of the
+// sale, the remainder becomes a debt of the client and the change is given in changeCurrency
+// (default: the currency of the sale). Each non-cash tender gets its caisse transaction and the cash
+// gets one transaction per currency, net of the change given in that currency.
 // All database operations are performed within a MongoDB transaction to ensure atomicity
-func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float64, currency, paymentType string, clientID *primitive.ObjectID, operatorID, storeID primitive.ObjectID, saleDate *time.Time) (*Sale, error) {
+func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float64, payments []SalePayment, changeCurrency, currency, paymentType string, clientID *primitive.ObjectID, operatorID, storeID primitive.ObjectID, saleDate *time.Time) (*Sale, error) {
 	// Pre-transaction validations (read-only operations)
 	// These don't need to be in the transaction but must pass before starting it
 
@@ -53,26 +86,6 @@ func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float6
 		if client.StoreID != storeID {
 			return nil, utils.ValidationErrorf("Client does not belong to the specified store")
 		}
-
-		// Vérifier le crédit disponible si c'est une vente à crédit
-		if paymentType == "debt" || paymentType == "advance" {
-			// Calculer le montant qui sera à crédit
-			amountOnCredit := priceToPay - pricePayed
-			if amountOnCredit > 0 {
-				// Vérifier si le client a assez de crédit disponible
-				hasEnough, availableCredit, err := db.CheckClientCredit(clientID.Hex(), amountOnCredit)
-				if err != nil {
-					return nil, err
-				}
-				if !hasEnough {
-					return nil, utils.ValidationErrorf(
-						"Crédit insuffisant. Crédit disponible: %.2f, Montant requis: %.2f",
-						availableCredit,
-						amountOnCredit,
-					)
-				}
-			}
-		}
 	} else if paymentType == "debt" || paymentType == "advance" {
 		// Si c'est une vente à crédit, un client doit être spécifié
 		return nil, utils.ValidationErrorf("Un client doit être spécifié pour les ventes à crédit")
@@ -100,12 +113,107 @@ func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float6
 			return nil, utils.ValidationErrorf("Insufficient stock for product in stock %s", item.ProductInStockID.Hex())
 		}
 
+		// Expired lots cannot be sold
+		if productInStock.IsExpired(time.Now()) {
+			return nil, utils.ValidationErrorf("Product in stock %s expired on %s", item.ProductInStockID.Hex(), productInStock.ExpiryDate.Format("2006-01-02"))
+		}
+
 		productInfos = append(productInfos, productInfo{
 			productInStock: productInStock,
 			quantity:       item.Quantity,
 		})
 	}
 
+	// Promotions are evaluated here, never taken from the client: the discounts are saved on the lines
+	basket = append([]ProductInBasket(nil), basket...)
+	productIDs := make([]primitive.ObjectID, len(basket))
+	var subtotal float64
+	for i := range basket {
+		basket[i].Discount = 0
+		basket[i].PromotionIDs = nil
+		productIDs[i] = productInfos[i].productInStock.ProductID
+		subtotal += basket[i].Quantity * basket[i].Price
+	}
+	appliedPromotions, err := db.applyStorePromotions(basket, productIDs, storeID, currency)
+	if err != nil {
+		return nil, err
+	}
+	discount := totalDiscount(appliedPromotions)
+	if discount > 0 && priceToPay > subtotal-discount {
+		priceToPay = roundAmount(subtotal - discount)
+	}
+
+	// Split tenders: the tenders, converted at today's rates, make pricePayed and the remainder becomes a debt
+	splitTenders := len(payments) > 0
+	var exchangeRates []SaleExchangeRate
+	var changeAmount float64
+	if splitTenders {
+		if changeCurrency == "" {
+			changeCurrency = currency
+		}
+		store, err := db.FindStoreByID(storeID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		exchangeRates, err = db.saleExchangeRates(store, currency, payments, changeCurrency)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		changeCurrency = ""
+	}
+	payments, err = normalizeSalePayments(payments, pricePayed, priceToPay, currency, exchangeRates)
+	if err != nil {
+		return nil, err
+	}
+	if splitTenders {
+		pricePayed = salePaymentsTotal(payments)
+		paymentType = splitTenderPaymentType(priceToPay, pricePayed)
+		if paymentType != "cash" && clientID == nil {
+			return nil, utils.ValidationErrorf("Un client doit être spécifié pour les ventes à crédit")
+		}
+		changeAmount = saleChange(pricePayed, priceToPay, currency, changeCurrency, exchangeRates)
+	}
+
+	// Caisse transactions of the tenders: the cash of each currency shares one transaction
+	cashTransactions := cashByCurrency(payments, currency, changeCurrency, changeAmount, exchangeRates)
+	for i := range cashTransactions {
+		transID := primitive.NewObjectID()
+		cashTransactions[i].TransID = &transID
+	}
+	for i := range payments {
+		if payments[i].Method != PaymentMethodCash {
+			transID := primitive.NewObjectID()
+			payments[i].TransID = &transID
+			continue
+		}
+		for _, cash := range cashTransactions {
+			if cash.Currency == payments[i].Currency {
+				payments[i].TransID = cash.TransID
+			}
+		}
+	}
+
+	// Vérifier le crédit disponible si c'est une vente à crédit
+	if clientID != nil && (paymentType == "debt" || paymentType == "advance") {
+		// Calculer le montant qui sera à crédit
+		amountOnCredit := priceToPay - pricePayed
+		if amountOnCredit > 0 {
+			// Vérifier si le client a assez de crédit disponible
+			hasEnough, availableCredit, err := db.CheckClientCredit(clientID.Hex(), amountOnCredit)
+			if err != nil {
+				return nil, err
+			}
+			if !hasEnough {
+				return nil, utils.ValidationErrorf(
+					"Crédit insuffisant. Crédit disponible: %.2f, Montant requis: %.2f",
+					availableCredit,
+					amountOnCredit,
+				)
+			}
+		}
+	}
+
 	// Validate payment type
 	if paymentType == "" {
 		paymentType = "cash" // Default to cash
@@ -154,6 +262,8 @@ func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float6
 	defer cancel()
 
 	var sale *Sale
+	var caisseTransactions []*Trans
+	var movements []*StockMovement
 	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
 		// Start transaction
 		if err := session.StartTransaction(); err != nil {
@@ -168,10 +278,11 @@ func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float6
 		stockMovementCollection := colHelper(db, "stock_movements")
 
 		// 1. Update product in stock quantities (within transaction)
+		// The stock guard rejects the sale if a concurrent sale consumed the lot in the meantime
 		for _, info := range productInfos {
-			_, err := productInStockCollection.UpdateOne(
+			result, err := productInStockCollection.UpdateOne(
 				sc,
-				bson.M{"_id": info.productInStock.ID},
+				bson.M{"_id": info.productInStock.ID, "stock": bson.M{"$gte": info.quantity}},
 				bson.M{
 					"$inc": bson.M{"stock": -info.quantity},
 					"$set": bson.M{"updatedAt": time.Now()},
@@ -180,6 +291,9 @@ func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float6
 			if err != nil {
 				return utils.DatabaseErrorf("update_product_stock", "Error updating product in stock %s: %v", info.productInStock.ID.Hex(), err)
 			}
+			if result.MatchedCount == 0 {
+				return utils.ValidationErrorf("Insufficient stock for product in stock %s", info.productInStock.ID.Hex())
+			}
 		}
 
 		// 2. Create sale (within transaction)
@@ -198,6 +312,13 @@ func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float6
 			Date:        date,
 			CreatedAt:   time.Now(),
 			UpdatedAt:   time.Now(),
+			Discount:    discount,
+			Promotions:  appliedPromotions,
+			Payments:    payments,
+
+			ExchangeRates:  exchangeRates,
+			ChangeCurrency: changeCurrency,
+			ChangeAmount:   changeAmount,
 		}
 
 		_, err = saleCollection.InsertOne(sc, sale)
@@ -255,20 +376,47 @@ func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float6
 			sale.DebtID = &debt.ID
 		}
 
-		// 4. Create caisse transaction if money was received (within transaction)
-		if pricePayed > 0 {
-			trans := Trans{
-				ID:          primitive.NewObjectID(),
-				Amount:      pricePayed,
-				Operation:   "Entree",
-				Description: fmt.Sprintf("Vente - Montant reçu: %.2f %s", pricePayed, currency),
-				Currency:    currency,
-				OperatorID:  operatorID,
-				StoreID:     storeID,
-				Date:        date,
-				CreatedAt:   time.Now(),
-				UpdatedAt:   time.Now(),
+		// 4. Create the caisse transactions of the tenders, tagged with their payment method (within transaction):
+		// one per non-cash tender and one per cash currency (a Sortie when the change exceeds the cash received)
+		for _, payment := range payments {
+			if payment.Method == PaymentMethodCash {
+				continue
+			}
+			caisseTransactions = append(caisseTransactions, &Trans{
+				ID:            *payment.TransID,
+				Amount:        payment.Amount,
+				Operation:     "Entree",
+				Description:   tenderDescription("Vente", payment),
+				Currency:      payment.Currency,
+				PaymentMethod: payment.Method,
+				Reference:     payment.Reference,
+			})
+		}
+		for _, cash := range cashTransactions {
+			trans := &Trans{
+				ID:            *cash.TransID,
+				Amount:        cash.Amount,
+				Operation:     "Entree",
+				Description:   tenderDescription("Vente", cash),
+				Currency:      cash.Currency,
+				PaymentMethod: PaymentMethodCash,
+			}
+			if cash.Amount < 0 {
+				trans.Amount = -cash.Amount
+				trans.Operation = "Sortie"
+				trans.Description = fmt.Sprintf("Vente - Monnaie rendue: %.2f %s", -cash.Amount, cash.Currency)
+			} else if cash.Amount == 0 {
+				continue
 			}
+			caisseTransactions = append(caisseTransactions, trans)
+		}
+		for _, trans := range caisseTransactions {
+			trans.SaleID = &sale.ID
+			trans.OperatorID = operatorID
+			trans.StoreID = storeID
+			trans.Date = date
+			trans.CreatedAt = time.Now()
+			trans.UpdatedAt = time.Now()
 
 			_, err = transCollection.InsertOne(sc, trans)
 			if err != nil {
@@ -315,6 +463,7 @@ func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float6
 			if err != nil {
 				return utils.DatabaseErrorf("create_stock_movement", "Error creating stock movement for product %s: %v", productInfo.ProductID.Hex(), err)
 			}
+			movements = append(movements, &movement)
 		}
 
 		// Commit transaction
@@ -331,9 +480,268 @@ func (db *DB) CreateSale(basket []ProductInBasket, priceToPay, pricePayed float6
 		return nil, err
 	}
 
+	// Flag the sold products for the low stock job (best effort, the sale is already recorded)
+	soldProductIDs := make([]primitive.ObjectID, 0, len(productInfos))
+	for _, info := range productInfos {
+		soldProductIDs = append(soldProductIDs, info.productInStock.ProductID)
+	}
+	if err := db.MarkProductsForStockCheck(soldProductIDs); err != nil {
+		utils.LogError(err, fmt.Sprintf("Failed to flag products of sale %s for stock check", sale.ID.Hex()))
+	}
+
+	// Notify the subscriptions (dashboards) now that the sale is committed
+	db.publish(events.Event{Type: events.SaleCreated, StoreID: storeID.Hex(), Payload: sale})
+	for _, trans := range caisseTransactions {
+		db.publish(events.Event{Type: events.CaisseUpdated, StoreID: storeID.Hex(), Currency: trans.Currency, Payload: trans})
+	}
+	for _, movement := range movements {
+		db.publish(events.Event{Type: events.StockLevelChanged, StoreID: storeID.Hex(), Payload: movement})
+	}
+
 	return sale, nil
 }
 
+// CancelSale cancels a sale and reverses everything CreateSale did: the stock is put back,
+// compensating ENTREE movements are written, the money received is taken out of the caisse
+// and the linked debt (with its payments) is voided.
+// All database operations are performed within a MongoDB transaction to ensure atomicity
+func (db *DB) CancelSale(saleID string, reason string, operatorID primitive.ObjectID) (*Sale, error) {
+	sale, err := db.FindSaleByID(saleID)
+	if err != nil {
+		return nil, err
+	}
+	if sale.CancelledAt != nil {
+		return nil, utils.ValidationErrorf("Sale is already cancelled")
+	}
+
+	// Load the products in stock of the basket (needed for the product template of each movement)
+	productsInStock := make(map[primitive.ObjectID]*ProductInStock, len(sale.Basket))
+	for _, item := range sale.Basket {
+		if _, ok := productsInStock[item.ProductInStockID]; ok {
+			continue
+		}
+		productInStock, err := db.FindProductInStockByID(item.ProductInStockID.Hex())
+		if err != nil {
+			return nil, utils.NotFoundErrorf("Product in stock not found: %s", item.ProductInStockID.Hex())
+		}
+		productsInStock[item.ProductInStockID] = productInStock
+	}
+
+	var debt *Debt
+	var debtPayments []*DebtPayment
+	if sale.DebtID != nil && !sale.DebtID.IsZero() {
+		debt, err = db.GetDebtByID(sale.DebtID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		debtPayments, err = db.GetDebtPayments(debt.ID.Hex())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Products already returned (see CreateSaleReturn) are back in stock and were refunded
+	// separately: only the remaining quantities and money are reversed here
+	previousReturns, err := db.FindSaleReturnsBySaleID(sale.ID)
+	if err != nil {
+		return nil, err
+	}
+	alreadyReturned := returnedQuantities(previousReturns)
+	refundAmount := cancelRefundAmount(sale, debtPayments, previousReturns)
+
+	// Start MongoDB transaction
+	session, err := db.client.StartSession()
+	if err != nil {
+		return nil, utils.DatabaseErrorf("start_session", "Error starting transaction session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	// Transaction context with longer timeout for multiple operations
+	txCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var refunds []*Trans
+	var movements []*StockMovement
+	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
+		// Start transaction
+		if err := session.StartTransaction(); err != nil {
+			return utils.DatabaseErrorf("start_transaction", "Error starting transaction: %v", err)
+		}
+
+		// Collections
+		saleCollection := colHelper(db, "sales")
+		productInStockCollection := colHelper(db, "products_in_stock")
+		debtCollection := colHelper(db, "debts")
+		paymentCollection := colHelper(db, "debtPayments")
+		stockMovementCollection := colHelper(db, "stock_movements")
+
+		// 1. Mark the sale as cancelled (the cancelledAt guard protects against concurrent cancellations)
+		saleUpdate := bson.M{
+			"cancelledAt":  now,
+			"cancelledBy":  operatorID,
+			"cancelReason": reason,
+			"updatedAt":    now,
+		}
+		if debt != nil {
+			saleUpdate["amountDue"] = 0
+			saleUpdate["debtStatus"] = "cancelled"
+		}
+		result, err := saleCollection.UpdateOne(
+			sc,
+			bson.M{"_id": sale.ID, "deletedAt": nil, "cancelledAt": nil},
+			bson.M{"$set": saleUpdate},
+		)
+		if err != nil {
+			return utils.DatabaseErrorf("cancel_sale", "Error cancelling sale: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return utils.ValidationErrorf("Sale is already cancelled")
+		}
+
+		// 2. Put the sold quantities back in stock and write compensating movements
+		for _, item := range sale.Basket {
+			productInStock := productsInStock[item.ProductInStockID]
+
+			quantity := item.Quantity
+			if returned := alreadyReturned[item.ProductInStockID]; returned > 0 {
+				if returned >= quantity {
+					alreadyReturned[item.ProductInStockID] -= quantity
+					continue
+				}
+				quantity -= returned
+				alreadyReturned[item.ProductInStockID] = 0
+			}
+
+			_, err := productInStockCollection.UpdateOne(
+				sc,
+				bson.M{"_id": productInStock.ID},
+				bson.M{
+					"$inc": bson.M{"stock": quantity},
+					"$set": bson.M{"updatedAt": now},
+				},
+			)
+			if err != nil {
+				return utils.DatabaseErrorf("restore_product_stock", "Error restoring product in stock %s: %v", productInStock.ID.Hex(), err)
+			}
+
+			movement := StockMovement{
+				ID:            primitive.NewObjectID(),
+				ProductID:     productInStock.ProductID,
+				StoreID:       sale.StoreID,
+				Type:          StockMovementTypeEntree,
+				Quantity:      quantity,
+				UnitPrice:     item.Price,
+				TotalValue:    quantity * item.Price,
+				Currency:      sale.Currency,
+				Reason:        fmt.Sprintf("Annulation vente #%s: %s", sale.ID.Hex(), reason),
+				Reference:     fmt.Sprintf("sale-cancel-%s", sale.ID.Hex()),
+				ReferenceType: "SALE_CANCEL",
+				ReferenceID:   &sale.ID,
+				OperatorID:    operatorID,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}
+
+			_, err = stockMovementCollection.InsertOne(sc, movement)
+			if err != nil {
+				return utils.DatabaseErrorf("create_stock_movement", "Error creating stock movement for product %s: %v", productInStock.ProductID.Hex(), err)
+			}
+			movements = append(movements, &movement)
+		}
+
+		// 3. Take the money received back out of the caisse, by the payment methods of the tenders
+		for _, refund := range refundsByMethod(sale, refundAmount) {
+			description := fmt.Sprintf("Annulation vente #%s - Montant remboursé: %.2f %s", sale.ID.Hex(), refund.Amount, refund.Currency)
+			if refund.Method != PaymentMethodCash {
+				description = fmt.Sprintf("Annulation vente #%s - Remboursé par %s: %.2f %s", sale.ID.Hex(), PaymentMethodLabels[refund.Method], refund.Amount, refund.Currency)
+			}
+			trans := &Trans{
+				Amount:        refund.Amount,
+				Operation:     "Sortie",
+				Description:   description,
+				Currency:      refund.Currency,
+				PaymentMethod: refund.Method,
+				Reference:     refund.Reference,
+				SaleID:        &sale.ID,
+				OperatorID:    operatorID,
+				StoreID:       sale.StoreID,
+				Date:          now,
+			}
+
+			if err := db.writeTrans(sc, trans); err != nil {
+				return utils.DatabaseErrorf("create_caisse_transaction", "Error creating caisse transaction: %v", err)
+			}
+			refunds = append(refunds, trans)
+		}
+
+		// 4. Void the debt and its payments
+		if debt != nil {
+			_, err = debtCollection.UpdateOne(
+				sc,
+				bson.M{"_id": debt.ID},
+				bson.M{"$set": bson.M{
+					"status":      "cancelled",
+					"amountDue":   0,
+					"cancelledAt": now,
+					"updatedAt":   now,
+				}},
+			)
+			if err != nil {
+				return utils.DatabaseErrorf("cancel_debt", "Error cancelling debt: %v", err)
+			}
+
+			_, err = paymentCollection.UpdateMany(
+				sc,
+				bson.M{"debtId": debt.ID, "voidedAt": nil},
+				bson.M{"$set": bson.M{"voidedAt": now}},
+			)
+			if err != nil {
+				return utils.DatabaseErrorf("void_debt_payments", "Error voiding debt payments: %v", err)
+			}
+		}
+
+		// Commit transaction
+		if err := session.CommitTransaction(sc); err != nil {
+			return utils.DatabaseErrorf("commit_transaction", "Error committing transaction: %v", err)
+		}
+
+		return nil
+	})
+
+	// Handle transaction errors
+	// MongoDB automatically aborts the transaction if an error occurs in WithSession
+	if err != nil {
+		return nil, err
+	}
+
+	// Flag the restocked products for the low stock job (best effort, the cancellation is already recorded)
+	if err := db.MarkProductsForStockCheck(productIDsOfLots(productsInStock)); err != nil {
+		utils.LogError(err, fmt.Sprintf("Failed to flag products of cancelled sale %s for stock check", sale.ID.Hex()))
+	}
+
+	// Notify the subscriptions (dashboards) now that the cancellation is committed
+	db.publishCommitted(refunds, movements)
+
+	return db.FindSaleByID(saleID)
+}
+
+// cancelRefundAmount is the money received for a sale that its cancellation takes back out of the caisse:
+// the amount paid at checkout (less the change given with split tenders) plus every debt payment since,
+// less the cash already refunded by its returns
+func cancelRefundAmount(sale *Sale, debtPayments []*DebtPayment, previousReturns []*SaleReturn) float64 {
+	refundAmount := sale.PricePayed
+	if sale.ChangeAmount > 0 && sale.PricePayed > sale.PriceToPay {
+		refundAmount = sale.PriceToPay
+	}
+	for _, payment := range debtPayments {
+		if payment.VoidedAt == nil {
+			refundAmount += payment.Amount
+		}
+	}
+	return refundAmount - cashRefunded(previousReturns)
+}
+
 // getPeriodDateRange calculates start and end dates based on period string
 func getPeriodDateRange(period *string, startDateStr, endDateStr *string) (start time.Time, end time.Time, err error) {
 	now := time.Now()
@@ -530,15 +938,18 @@ func (db *DB) FindSalesListByStoreIDsWithFilters(
 
 	// Build options with projection - only retrieve fields needed for list view
 	projection := bson.M{
-		"_id":        1,
-		"date":       1,
-		"createdAt":  1,
-		"priceToPay": 1,
-		"pricePayed": 1,
-		"currency":   1,
-		"clientId":   1,
-		"storeId":    1,
-		"basket":     1, // Need basket to calculate basketCount and totalItems
+		"_id":            1,
+		"date":           1,
+		"createdAt":      1,
+		"priceToPay":     1,
+		"pricePayed":     1,
+		"currency":       1,
+		"clientId":       1,
+		"storeId":        1,
+		"basket":         1, // Need basket to calculate basketCount and totalItems
+		"cancelledAt":    1,
+		"returnedAmount": 1,
+		"discount":       1,
 		// Exclude: operatorId, updatedAt (not needed for list)
 	}
 
@@ -634,6 +1045,7 @@ type SaleStats struct {
 	TotalItems    float64 `bson:"totalItems" json:"totalItems"`
 	AverageSale   float64 `bson:"averageSale" json:"averageSale"`
 	TotalBenefice float64 `bson:"totalBenefice" json:"totalBenefice"`
+	TotalDiscount float64 `bson:"totalDiscount" json:"totalDiscount"` // Remises des promotions
 }
 
 // GetSalesStatsByStoreIDs calculates sales statistics using aggregation pipeline
@@ -652,8 +1064,8 @@ func (db *DB) GetSalesStatsByStoreIDs(
 	ctx, cancel := GetDBContext()
 	defer cancel()
 
-	// Build match filter (exclude deleted sales)
-	matchFilter := bson.M{"storeId": bson.M{"$in": storeIDs}, "deletedAt": nil}
+	// Build match filter (exclude deleted and cancelled sales)
+	matchFilter := bson.M{"storeId": bson.M{"$in": storeIDs}, "deletedAt": nil, "cancelledAt": nil}
 
 	// Add currency filter
 	if currency != nil {
@@ -685,9 +1097,10 @@ func (db *DB) GetSalesStatsByStoreIDs(
 		{"$match": matchFilter},
 		{
 			"$group": bson.M{
-				"_id":          nil,
-				"totalSales":   bson.M{"$sum": 1},
-				"totalRevenue": bson.M{"$sum": "$pricePayed"},
+				"_id":           nil,
+				"totalSales":    bson.M{"$sum": 1},
+				"totalRevenue":  bson.M{"$sum": "$pricePayed"},
+				"totalDiscount": bson.M{"$sum": bson.M{"$ifNull": []interface{}{"$discount", 0}}},
 				"totalItems": bson.M{
 					"$sum": bson.M{
 						"$reduce": bson.M{
@@ -701,11 +1114,12 @@ func (db *DB) GetSalesStatsByStoreIDs(
 		},
 		{
 			"$project": bson.M{
-				"_id":          0,
-				"totalSales":   1,
-				"totalRevenue": 1,
-				"totalItems":   1,
-				"averageSale":  bson.M{"$divide": []interface{}{"$totalRevenue", "$totalSales"}},
+				"_id":           0,
+				"totalSales":    1,
+				"totalRevenue":  1,
+				"totalItems":    1,
+				"totalDiscount": 1,
+				"averageSale":   bson.M{"$divide": []interface{}{"$totalRevenue", "$totalSales"}},
 			},
 		},
 	}
@@ -738,6 +1152,11 @@ func (db *DB) GetSalesStatsByStoreIDs(
 		if averageSale, ok := result["averageSale"].(float64); ok {
 			stats.AverageSale = averageSale
 		}
+		if totalDiscount, ok := result["totalDiscount"].(float64); ok {
+			stats.TotalDiscount = totalDiscount
+		} else if totalDiscount, ok := result["totalDiscount"].(int32); ok {
+			stats.TotalDiscount = float64(totalDiscount)
+		}
 	}
 
 	// Calculate benefice separately (requires product data)
@@ -765,8 +1184,8 @@ func (db *DB) CalculateTotalBeneficeByStoreIDs(
 	ctx, cancel := GetDBContext()
 	defer cancel()
 
-	// Build match filter (exclude deleted sales)
-	matchFilter := bson.M{"storeId": bson.M{"$in": storeIDs}, "deletedAt": nil}
+	// Build match filter (exclude deleted and cancelled sales)
+	matchFilter := bson.M{"storeId": bson.M{"$in": storeIDs}, "deletedAt": nil, "cancelledAt": nil}
 
 	// Add currency filter
 	if currency != nil {
@@ -794,32 +1213,37 @@ func (db *DB) CalculateTotalBeneficeByStoreIDs(
 	}
 
 	// Aggregation pipeline to calculate total benefice
-	// Benefice = (sale price - purchase price) * quantity for each item
+	// Benefice = (sale price - purchase price) * quantity - promotion discount for each item
 	pipeline := []bson.M{
 		{"$match": matchFilter},
 		// Unwind basket to process each item separately
 		{"$unwind": "$basket"},
-		// Lookup product information
+		// Lookup the product in stock of each basket line (it holds the purchase price)
 		{
 			"$lookup": bson.M{
-				"from":         "products",
-				"localField":   "basket.productId",
+				"from":         "products_in_stock",
+				"localField":   "basket.productInStockId",
 				"foreignField": "_id",
 				"as":           "productInfo",
 			},
 		},
 		// Unwind productInfo (should be single element)
 		{"$unwind": bson.M{"path": "$productInfo", "preserveNullAndEmptyArrays": true}},
-		// Calculate benefice for each item: (price - priceAchat) * quantity
+		// Calculate benefice for each item: (price - priceAchat) * quantity - discount
 		{
 			"$project": bson.M{
 				"itemBenefice": bson.M{
 					"$cond": bson.M{
 						"if": bson.M{"$ne": []interface{}{"$productInfo", nil}},
 						"then": bson.M{
-							"$multiply": []interface{}{
-								bson.M{"$subtract": []interface{}{"$basket.price", "$productInfo.priceAchat"}},
-								"$basket.quantity",
+							"$subtract": []interface{}{
+								bson.M{
+									"$multiply": []interface{}{
+										bson.M{"$subtract": []interface{}{"$basket.price", "$productInfo.priceAchat"}},
+										"$basket.quantity",
+									},
+								},
+								bson.M{"$ifNull": []interface{}{"$basket.discount", 0}},
 							},
 						},
 						"else": 0,
@@ -848,16 +1272,22 @@ func (db *DB) CalculateTotalBeneficeByStoreIDs(
 	}
 
 	// Extract total benefice from results
+	var totalBenefice float64
 	if len(results) > 0 {
-		if totalBenefice, ok := results[0]["totalBenefice"].(float64); ok {
-			return totalBenefice, nil
-		}
-		if totalBenefice, ok := results[0]["totalBenefice"].(int64); ok {
-			return float64(totalBenefice), nil
+		if benefice, ok := results[0]["totalBenefice"].(float64); ok {
+			totalBenefice = benefice
+		} else if benefice, ok := results[0]["totalBenefice"].(int64); ok {
+			totalBenefice = float64(benefice)
 		}
 	}
 
-	return 0, nil
+	// Returns give back the margin they carried
+	returnsBenefice, err := db.sumReturnsBenefice(storeIDs, currency, start, end)
+	if err != nil {
+		return 0, err
+	}
+
+	return totalBenefice - returnsBenefice, nil
 }
 
 // FindSaleByID finds a sale by ID
@@ -903,7 +1333,8 @@ func (db *DB) FindSalesByClientID(clientID primitive.ObjectID) ([]*Sale, error)
 	return sales, nil
 }
 
-// SoftDeleteSale marks a sale as deleted (soft delete)
+// SoftDeleteSale marks a sale as deleted (soft delete). Only a cancelled sale can be deleted:
+// CancelSale reverses its stock, caisse and debt, deleting it directly would leave them behind
 func (db *DB) SoftDeleteSale(id string) error {
 	objectID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -920,10 +1351,13 @@ func (db *DB) SoftDeleteSale(id string) error {
 	if err != nil {
 		return utils.NotFoundErrorf("Sale not found or already deleted")
 	}
+	if sale.CancelledAt == nil {
+		return utils.ValidationErrorf("Sale must be cancelled (cancelSale) before it can be deleted")
+	}
 
 	// Soft delete: set deletedAt
 	now := time.Now()
-	_, err = saleCollection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{
+	_, err = saleCollection.UpdateOne(ctx, bson.M{"_id": objectID, "cancelledAt": bson.M{"$ne": nil}}, bson.M{
 		"$set": bson.M{
 			"deletedAt": now,
 			"updatedAt": now,
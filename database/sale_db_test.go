@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestCancelRefundAmount vérifie le montant repris de la caisse à l'annulation d'une vente
+func TestCancelRefundAmount(t *testing.T) {
+	now := time.Now()
+
+	assert.Equal(t, 100.0, cancelRefundAmount(&Sale{PriceToPay: 100, PricePayed: 100}, nil, nil))
+	// Monnaie rendue sur des paiements fractionnés: seul le prix reste en caisse
+	assert.Equal(t, 100.0, cancelRefundAmount(&Sale{PriceToPay: 100, PricePayed: 120, ChangeAmount: 20}, nil, nil))
+
+	// Vente à crédit: l'acompte et les paiements de la dette non annulés
+	payments := []*DebtPayment{{Amount: 20}, {Amount: 15, VoidedAt: &now}, {Amount: 10}}
+	assert.Equal(t, 60.0, cancelRefundAmount(&Sale{PriceToPay: 100, PricePayed: 30}, payments, nil))
+
+	// Les retours déjà remboursés en espèces ne sont pas repris une seconde fois
+	returns := []*SaleReturn{{RefundMethod: "cash", TotalAmount: 25}, {RefundMethod: "debt", TotalAmount: 10}}
+	assert.Equal(t, 75.0, cancelRefundAmount(&Sale{PriceToPay: 100, PricePayed: 100}, nil, returns))
+}
+
+// Les annulations utilisent des transactions: TEST_MONGO_URI doit pointer vers un replica set
+
+// setupSaleTestDB connects to the test database and drops the collections used by the cancellations at the end
+func setupSaleTestDB(t *testing.T) *DB {
+	db := setupTestDB(t)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, name := range []string{"stores", "products", "products_in_stock", "stock_movements", "sales", "sale_returns", "trans"} {
+			if err := db.database.Collection(name).Drop(ctx); err != nil {
+				t.Logf("Warning: Failed to drop test collection %s: %v", name, err)
+			}
+		}
+		if err := db.client.Disconnect(ctx); err != nil {
+			t.Logf("Warning: Failed to disconnect test client: %v", err)
+		}
+	})
+	return db
+}
+
+func TestCancelSaleThenDelete(t *testing.T) {
+	db := setupSaleTestDB(t)
+	operatorID := primitive.NewObjectID()
+	store := createTransferTestStore(t, db, primitive.NewObjectID(), "Gombe")
+	_, productInStock := createTransferTestProduct(t, db, store.ID, "6001234567890", 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	sale := &Sale{
+		ID:          primitive.NewObjectID(),
+		Basket:      []ProductInBasket{{ProductInStockID: productInStock.ID, Quantity: 2, Price: 3}},
+		PriceToPay:  6,
+		PricePayed:  6,
+		Currency:    "USD",
+		OperatorID:  operatorID,
+		StoreID:     store.ID,
+		PaymentType: "cash",
+		DebtStatus:  "none",
+		Payments:    []SalePayment{{Method: PaymentMethodCash, Amount: 6, Currency: "USD", Rate: 1, ConvertedAmount: 6}},
+		Date:        time.Now(),
+		CreatedAt:   time.Now(),
+		UpdatedAt:   time.Now(),
+	}
+	_, err := db.database.Collection("sales").InsertOne(ctx, sale)
+	require.NoError(t, err, "Failed to insert test sale")
+
+	// Une vente non annulée ne peut pas être supprimée
+	err = db.DeleteSale(sale.ID.Hex())
+	assert.Error(t, err, "A sale must be cancelled before it is deleted")
+
+	cancelled, err := db.CancelSale(sale.ID.Hex(), "erreur de caisse", operatorID)
+	require.NoError(t, err)
+	require.NotNil(t, cancelled.CancelledAt)
+	assert.Equal(t, "erreur de caisse", cancelled.CancelReason)
+
+	// Le stock est remis et l'argent repris de la caisse, avec la vente et le mode de paiement
+	restocked, err := db.FindProductInStockByID(productInStock.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, 12.0, restocked.Stock)
+
+	var refund Trans
+	require.NoError(t, db.database.Collection("trans").FindOne(ctx, bson.M{"saleId": sale.ID}).Decode(&refund))
+	assert.Equal(t, "Sortie", refund.Operation)
+	assert.Equal(t, 6.0, refund.Amount)
+	assert.Equal(t, PaymentMethodCash, refund.PaymentMethod)
+
+	_, err = db.CancelSale(sale.ID.Hex(), "erreur de caisse", operatorID)
+	assert.Error(t, err, "A sale cannot be cancelled twice")
+
+	require.NoError(t, db.DeleteSale(sale.ID.Hex()))
+	_, err = db.FindSaleByID(sale.ID.Hex())
+	assert.Error(t, err, "A deleted sale should not be found")
+}
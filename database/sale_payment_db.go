@@ -0,0 +1,397 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Payment methods of a sale tender and of a caisse transaction (empty: cash, before split tenders)
+const (
+	PaymentMethodCash         = "cash"
+	PaymentMethodMpesa        = "mpesa"
+	PaymentMethodAirtelMoney  = "airtel_money"
+	PaymentMethodOrangeMoney  = "orange_money"
+	PaymentMethodCard         = "card"
+	PaymentMethodBankTransfer = "bank_transfer"
+)
+
+// PaymentMethodLabels are the names of the payment methods in the caisse descriptions
+var PaymentMethodLabels = map[string]string{
+	PaymentMethodCash:         "Espèces",
+	PaymentMethodMpesa:        "M-Pesa",
+	PaymentMethodAirtelMoney:  "Airtel Money",
+	PaymentMethodOrangeMoney:  "Orange Money",
+	PaymentMethodCard:         "Carte",
+	PaymentMethodBankTransfer: "Virement",
+}
+
+// IsValidPaymentMethod reports whether the payment method is supported
+func IsValidPaymentMethod(method string) bool {
+	_, ok := PaymentMethodLabels[method]
+	return ok
+}
+
+// SalePayment is one tender of a sale (split tenders: part cash, part mobile money...).
+// Amount is in the currency of the tender, ConvertedAmount in the currency of the sale
+type SalePayment struct {
+	Method          string              `bson:"method" json:"method"`
+	Amount          float64             `bson:"amount" json:"amount"`
+	Currency        string              `bson:"currency" json:"currency"`
+	Rate            float64             `bson:"rate,omitempty" json:"rate,omitempty"`                       // 1 Currency = Rate devise de la vente
+	ConvertedAmount float64             `bson:"convertedAmount,omitempty" json:"convertedAmount,omitempty"` // Montant dans la devise de la vente
+	Reference       string              `bson:"reference,omitempty" json:"reference,omitempty"`             // Référence de la transaction mobile money ou carte
+	TransID         *primitive.ObjectID `bson:"transId,omitempty" json:"transId,omitempty"`                 // Transaction de caisse du paiement
+}
+
+// saleAmount returns the amount of the tender in the currency of the sale
+// (tenders recorded before multi-currency tenders are in the currency of the sale)
+func (p SalePayment) saleAmount() float64 {
+	if p.Rate > 0 {
+		return p.ConvertedAmount
+	}
+	return p.Amount
+}
+
+// SaleExchangeRate is the exchange rate of a tender currency saved on the sale: 1 Currency = Rate sale currency
+type SaleExchangeRate struct {
+	Currency string  `bson:"currency" json:"currency"`
+	Rate     float64 `bson:"rate" json:"rate"`
+}
+
+// PaymentMethodTotal is the amount received with a payment method
+type PaymentMethodTotal struct {
+	Method   string  `bson:"method" json:"method"`
+	Currency string  `bson:"currency" json:"currency"`
+	Amount   float64 `bson:"amount" json:"amount"`
+	Count    int64   `bson:"count" json:"count"`
+}
+
+// exchangeRateOf returns the rate of a currency to the currency of the sale
+func exchangeRateOf(rates []SaleExchangeRate, currency, saleCurrency string) (float64, bool) {
+	if currency == saleCurrency {
+		return 1, true
+	}
+	for _, rate := range rates {
+		if rate.Currency == currency {
+			return rate.Rate, true
+		}
+	}
+	return 0, false
+}
+
+// saleExchangeRates takes the snapshot of the exchange rates of the tender and change currencies
+// that differ from the currency of the sale. These currencies must be supported by the store
+func (db *DB) saleExchangeRates(store *Store, currency string, payments []SalePayment, changeCurrency string) ([]SaleExchangeRate, error) {
+	currencies := make([]string, 0, len(payments)+1)
+	for _, payment := range payments {
+		currencies = append(currencies, payment.Currency)
+	}
+	currencies = append(currencies, changeCurrency)
+
+	var rates []SaleExchangeRate
+	for _, c := range currencies {
+		if c == "" {
+			continue
+		}
+		if _, ok := exchangeRateOf(rates, c, currency); ok {
+			continue
+		}
+		supported := false
+		for _, supportedCurrency := range store.SupportedCurrencies {
+			if supportedCurrency == c {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return nil, utils.ValidationErrorf("Currency %s is not supported by this store. Supported currencies: %v", c, store.SupportedCurrencies)
+		}
+		rate, err := db.GetExchangeRate(store.CompanyID.Hex(), c, currency)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, SaleExchangeRate{Currency: c, Rate: rate})
+	}
+	return rates, nil
+}
+
+// normalizeSalePayments checks the tenders of a sale and returns them with their currency set and
+// converted to the currency of the sale with the rates of the sale.
+// Without tenders, pricePayed is a single cash tender (the sale before split tenders).
+// Change is only given on cash: the other tenders cannot exceed the price to pay
+func normalizeSalePayments(payments []SalePayment, pricePayed, priceToPay float64, currency string, rates []SaleExchangeRate) ([]SalePayment, error) {
+	if len(payments) == 0 {
+		if pricePayed <= 0 {
+			return nil, nil
+		}
+		return []SalePayment{{Method: PaymentMethodCash, Amount: pricePayed, Currency: currency, Rate: 1, ConvertedAmount: pricePayed}}, nil
+	}
+
+	normalized := make([]SalePayment, 0, len(payments))
+	var nonCash float64
+	for _, payment := range payments {
+		payment.Method = strings.TrimSpace(payment.Method)
+		payment.Reference = strings.TrimSpace(payment.Reference)
+		if !IsValidPaymentMethod(payment.Method) {
+			return nil, utils.ValidationErrorf("Invalid payment method: %s", payment.Method)
+		}
+		if payment.Amount <= 0 {
+			return nil, utils.ValidationErrorf("Payment amount must be greater than 0")
+		}
+		if payment.Currency == "" {
+			payment.Currency = currency
+		}
+		rate, ok := exchangeRateOf(rates, payment.Currency, currency)
+		if !ok {
+			return nil, utils.ValidationErrorf("No exchange rate from %s to the sale currency %s", payment.Currency, currency)
+		}
+		payment.Rate = rate
+		payment.ConvertedAmount = roundAmount(payment.Amount * rate)
+		if payment.Method != PaymentMethodCash {
+			nonCash += payment.ConvertedAmount
+		}
+		payment.TransID = nil
+		normalized = append(normalized, payment)
+	}
+	if nonCash > priceToPay {
+		return nil, utils.ValidationErrorf("Non-cash payments (%.2f) exceed the price to pay (%.2f): change is only given in cash", nonCash, priceToPay)
+	}
+	return normalized, nil
+}
+
+// salePaymentsTotal returns the amount received by the tenders in the currency of the sale
+func salePaymentsTotal(payments []SalePayment) float64 {
+	var total float64
+	for _, payment := range payments {
+		total += payment.saleAmount()
+	}
+	return roundAmount(total)
+}
+
+// saleChange returns the change to give in changeCurrency when the tenders exceed the price to pay
+func saleChange(pricePayed, priceToPay float64, currency, changeCurrency string, rates []SaleExchangeRate) float64 {
+	change := pricePayed - priceToPay
+	if change <= 0 {
+		return 0
+	}
+	rate, ok := exchangeRateOf(rates, changeCurrency, currency)
+	if !ok || rate <= 0 {
+		return 0
+	}
+	return roundAmount(change / rate)
+}
+
+// cashByCurrency returns the cash kept in each currency drawer: the cash tenders of the currency minus
+// the change given in it (negative when the change exceeds the cash received in the currency)
+func cashByCurrency(payments []SalePayment, currency, changeCurrency string, changeAmount float64, rates []SaleExchangeRate) []SalePayment {
+	var cash []SalePayment
+	add := func(c string, amount, rate float64) {
+		for i := range cash {
+			if cash[i].Currency == c {
+				cash[i].Amount = roundAmount(cash[i].Amount + amount)
+				return
+			}
+		}
+		cash = append(cash, SalePayment{Method: PaymentMethodCash, Amount: amount, Currency: c, Rate: rate})
+	}
+	for _, payment := range payments {
+		if payment.Method == PaymentMethodCash {
+			rate := payment.Rate
+			if rate <= 0 {
+				rate = 1
+			}
+			add(payment.Currency, payment.Amount, rate)
+		}
+	}
+	if changeAmount > 0 {
+		rate, _ := exchangeRateOf(rates, changeCurrency, currency)
+		add(changeCurrency, -changeAmount, rate)
+	}
+	for i := range cash {
+		cash[i].ConvertedAmount = roundAmount(cash[i].Amount * cash[i].Rate)
+	}
+	return cash
+}
+
+// splitTenderPaymentType returns the payment type of a sale paid by tenders:
+// paid in full is "cash", the remainder becomes a debt ("advance" if something was paid, "debt" otherwise)
+func splitTenderPaymentType(priceToPay, pricePayed float64) string {
+	switch {
+	case pricePayed >= priceToPay:
+		return "cash"
+	case pricePayed > 0:
+		return "advance"
+	default:
+		return "debt"
+	}
+}
+
+// refundsByMethod splits the refund of a cancelled sale (in the currency of the sale) over what its
+// tenders left in the caisse: the non-cash tenders are refunded by their method first, then the cash
+// kept in each currency, the rest (debt payments) in cash in the currency of the sale
+func refundsByMethod(sale *Sale, refundAmount float64) []SalePayment {
+	var receipts []SalePayment
+	for _, payment := range sale.Payments {
+		if payment.Method != PaymentMethodCash {
+			receipts = append(receipts, payment)
+		}
+	}
+	receipts = append(receipts, cashByCurrency(sale.Payments, sale.Currency, sale.ChangeCurrency, sale.ChangeAmount, sale.ExchangeRates)...)
+
+	var refunds []SalePayment
+	remaining := refundAmount
+	for _, receipt := range receipts {
+		received := receipt.saleAmount()
+		if remaining <= 0 || received <= 0 {
+			continue
+		}
+		refund := SalePayment{Method: receipt.Method, Amount: receipt.Amount, Currency: receipt.Currency, Rate: receipt.Rate, ConvertedAmount: received, Reference: receipt.Reference}
+		if received > remaining {
+			refund.ConvertedAmount = remaining
+			refund.Amount = remaining
+			if receipt.Rate > 0 {
+				refund.Amount = roundAmount(remaining / receipt.Rate)
+			}
+		}
+		refunds = append(refunds, refund)
+		remaining = roundAmount(remaining - refund.ConvertedAmount)
+	}
+	if remaining > 0 {
+		refunds = append(refunds, SalePayment{Method: PaymentMethodCash, Amount: remaining, Currency: sale.Currency, Rate: 1, ConvertedAmount: remaining})
+	}
+	return refunds
+}
+
+// tenderDescription returns the caisse description of a tender
+func tenderDescription(prefix string, payment SalePayment) string {
+	if payment.Method == PaymentMethodCash {
+		return fmt.Sprintf("%s - Montant reçu: %.2f %s", prefix, payment.Amount, payment.Currency)
+	}
+	description := fmt.Sprintf("%s - %s: %.2f %s", prefix, PaymentMethodLabels[payment.Method], payment.Amount, payment.Currency)
+	if payment.Reference != "" {
+		description += fmt.Sprintf(" (réf. %s)", payment.Reference)
+	}
+	return description
+}
+
+// GetPaymentMethodStatsByStoreIDs returns the amounts kept at checkout per payment method and currency:
+// the change given is deducted from the cash of its currency (as in cashByCurrency) and is not counted
+// as a tender. Sales recorded before split tenders count as a single cash tender of pricePayed
+func (db *DB) GetPaymentMethodStatsByStoreIDs(
+	storeIDs []primitive.ObjectID,
+	period *string,
+	startDate *string,
+	endDate *string,
+	currency *string,
+) ([]*PaymentMethodTotal, error) {
+	if len(storeIDs) == 0 {
+		return []*PaymentMethodTotal{}, nil
+	}
+
+	saleCollection := colHelper(db, "sales")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	// Build match filter (exclude deleted and cancelled sales)
+	matchFilter := bson.M{"storeId": bson.M{"$in": storeIDs}, "deletedAt": nil, "cancelledAt": nil}
+	if currency != nil && isValidCurrency(*currency) {
+		matchFilter["currency"] = *currency
+	}
+
+	start, end, err := getPeriodDateRange(period, startDate, endDate)
+	if err != nil {
+		return nil, err
+	}
+	if !start.IsZero() && !end.IsZero() {
+		matchFilter["createdAt"] = bson.M{"$gte": start, "$lte": end}
+	} else if !start.IsZero() {
+		matchFilter["createdAt"] = bson.M{"$gte": start}
+	} else if !end.IsZero() {
+		matchFilter["createdAt"] = bson.M{"$lte": end}
+	}
+
+	pipeline := []bson.M{
+		{"$match": matchFilter},
+		{
+			"$project": bson.M{
+				"payments": bson.M{"$concatArrays": []interface{}{
+					bson.M{"$cond": bson.M{
+						"if":   bson.M{"$gt": []interface{}{bson.M{"$size": bson.M{"$ifNull": []interface{}{"$payments", []interface{}{}}}}, 0}},
+						"then": "$payments",
+						"else": []interface{}{bson.M{"method": PaymentMethodCash, "amount": "$pricePayed", "currency": "$currency"}},
+					}},
+					// Change given: cash leaving the drawer of its currency
+					bson.M{"$cond": bson.M{
+						"if":   bson.M{"$gt": []interface{}{"$changeAmount", 0}},
+						"then": []interface{}{bson.M{"method": PaymentMethodCash, "amount": bson.M{"$multiply": []interface{}{"$changeAmount", -1}}, "currency": "$changeCurrency", "change": true}},
+						"else": []interface{}{},
+					}},
+				}},
+			},
+		},
+		{"$unwind": "$payments"},
+		{"$match": bson.M{"$or": []bson.M{{"payments.amount": bson.M{"$gt": 0}}, {"payments.change": true}}}},
+		{
+			"$group": bson.M{
+				"_id":    bson.M{"method": "$payments.method", "currency": "$payments.currency"},
+				"amount": bson.M{"$sum": "$payments.amount"},
+				"count":  bson.M{"$sum": bson.M{"$cond": []interface{}{"$payments.change", 0, 1}}},
+			},
+		},
+		{
+			"$project": bson.M{
+				"_id":      0,
+				"method":   "$_id.method",
+				"currency": "$_id.currency",
+				"amount":   1,
+				"count":    1,
+			},
+		},
+		{"$sort": bson.D{{Key: "currency", Value: 1}, {Key: "amount", Value: -1}}},
+	}
+
+	cursor, err := saleCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("aggregate_payment_methods", "Error aggregating payment methods: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	totals := []*PaymentMethodTotal{}
+	if err = cursor.All(ctx, &totals); err != nil {
+		return nil, utils.DatabaseErrorf("decode_payment_methods", "Error decoding payment methods: %v", err)
+	}
+
+	return totals, nil
+}
+
+// transPaymentMethodTotals returns the caisse entries and exits of the transactions per payment method
+// (transactions without method are cash)
+func transPaymentMethodTotals(transactions []*Trans) []*CaissePaymentMethodTotal {
+	var totals []*CaissePaymentMethodTotal
+	byMethod := make(map[string]*CaissePaymentMethodTotal)
+	for _, t := range transactions {
+		method := t.PaymentMethod
+		if method == "" {
+			method = PaymentMethodCash
+		}
+		total, ok := byMethod[method]
+		if !ok {
+			total = &CaissePaymentMethodTotal{Method: method}
+			byMethod[method] = total
+			totals = append(totals, total)
+		}
+		if t.Operation == "Entree" {
+			total.Entrees += t.Amount
+		} else if t.Operation == "Sortie" {
+			total.Sorties += t.Amount
+		}
+		total.Solde = total.Entrees - total.Sorties
+		total.NombreTransactions++
+	}
+	return totals
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestNormalizeSalePayments vérifie les paiements d'une vente: espèces par défaut, devises et monnaie rendue
+func TestNormalizeSalePayments(t *testing.T) {
+	payments, err := normalizeSalePayments(nil, 120, 100, "USD", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []SalePayment{{Method: PaymentMethodCash, Amount: 120, Currency: "USD", Rate: 1, ConvertedAmount: 120}}, payments)
+
+	payments, err = normalizeSalePayments(nil, 0, 100, "USD", nil)
+	require.NoError(t, err)
+	assert.Empty(t, payments)
+
+	payments, err = normalizeSalePayments([]SalePayment{
+		{Method: PaymentMethodCash, Amount: 30},
+		{Method: " mpesa ", Amount: 80, Reference: " MP123 "},
+	}, 0, 100, "USD", nil)
+	require.NoError(t, err)
+	assert.Equal(t, []SalePayment{
+		{Method: PaymentMethodCash, Amount: 30, Currency: "USD", Rate: 1, ConvertedAmount: 30},
+		{Method: PaymentMethodMpesa, Amount: 80, Currency: "USD", Rate: 1, ConvertedAmount: 80, Reference: "MP123"},
+	}, payments)
+	assert.Equal(t, 110.0, salePaymentsTotal(payments))
+
+	_, err = normalizeSalePayments([]SalePayment{{Method: "cheque", Amount: 10}}, 0, 100, "USD", nil)
+	assert.Error(t, err)
+	_, err = normalizeSalePayments([]SalePayment{{Method: PaymentMethodCash, Amount: 0}}, 0, 100, "USD", nil)
+	assert.Error(t, err)
+	// La monnaie n'est rendue qu'en espèces
+	_, err = normalizeSalePayments([]SalePayment{{Method: PaymentMethodCard, Amount: 60}, {Method: PaymentMethodAirtelMoney, Amount: 50}}, 0, 100, "USD", nil)
+	assert.Error(t, err)
+}
+
+// TestNormalizeSalePaymentsMultiCurrency vérifie la conversion des paiements au taux enregistré sur la vente
+func TestNormalizeSalePaymentsMultiCurrency(t *testing.T) {
+	rates := []SaleExchangeRate{{Currency: "CDF", Rate: 1.0 / 2500}}
+
+	payments, err := normalizeSalePayments([]SalePayment{
+		{Method: PaymentMethodCash, Amount: 20, Currency: "USD"},
+		{Method: PaymentMethodCash, Amount: 50000, Currency: "CDF"},
+	}, 0, 40, "USD", rates)
+	require.NoError(t, err)
+	assert.Equal(t, 20.0, payments[1].ConvertedAmount)
+	assert.Equal(t, 40.0, salePaymentsTotal(payments))
+
+	// Devise sans taux
+	_, err = normalizeSalePayments([]SalePayment{{Method: PaymentMethodCash, Amount: 20, Currency: "EUR"}}, 0, 40, "USD", rates)
+	assert.Error(t, err)
+	// Les paiements hors espèces convertis ne dépassent pas le prix
+	_, err = normalizeSalePayments([]SalePayment{{Method: PaymentMethodOrangeMoney, Amount: 125000, Currency: "CDF"}}, 0, 40, "USD", rates)
+	assert.Error(t, err)
+}
+
+// TestSaleChangeAndCashByCurrency vérifie la monnaie rendue dans une autre devise et le solde de chaque caisse
+func TestSaleChangeAndCashByCurrency(t *testing.T) {
+	rates := []SaleExchangeRate{{Currency: "CDF", Rate: 1.0 / 2500}}
+	payments := []SalePayment{
+		{Method: PaymentMethodCash, Amount: 50, Currency: "USD", Rate: 1, ConvertedAmount: 50},
+		{Method: PaymentMethodCash, Amount: 25000, Currency: "CDF", Rate: 1.0 / 2500, ConvertedAmount: 10},
+		{Method: PaymentMethodMpesa, Amount: 5, Currency: "USD", Rate: 1, ConvertedAmount: 5},
+	}
+
+	// 65 USD reçus pour 52 USD: 13 USD rendus en francs
+	change := saleChange(65, 52, "USD", "CDF", rates)
+	assert.Equal(t, 32500.0, change)
+	assert.Equal(t, 0.0, saleChange(50, 52, "USD", "CDF", rates))
+
+	cash := cashByCurrency(payments, "USD", "CDF", change, rates)
+	require.Len(t, cash, 2)
+	assert.Equal(t, "USD", cash[0].Currency)
+	assert.Equal(t, 50.0, cash[0].Amount)
+	assert.Equal(t, "CDF", cash[1].Currency)
+	assert.Equal(t, -7500.0, cash[1].Amount)
+	assert.Equal(t, -3.0, cash[1].ConvertedAmount)
+
+	// Monnaie rendue dans une devise sans paiement en espèces
+	cash = cashByCurrency(payments[:1], "USD", "CDF", 5000, rates)
+	require.Len(t, cash, 2)
+	assert.Equal(t, -5000.0, cash[1].Amount)
+}
+
+// TestSplitTenderPaymentType vérifie que le reste d'une vente payée par plusieurs moyens devient une dette
+func TestSplitTenderPaymentType(t *testing.T) {
+	assert.Equal(t, "cash", splitTenderPaymentType(100, 100))
+	assert.Equal(t, "cash", splitTenderPaymentType(100, 120))
+	assert.Equal(t, "advance", splitTenderPaymentType(100, 40))
+	assert.Equal(t, "debt", splitTenderPaymentType(100, 0))
+}
+
+// TestRefundsByMethod vérifie que les paiements hors espèces sont remboursés par leur moyen en premier,
+// puis les espèces gardées dans chaque devise
+func TestRefundsByMethod(t *testing.T) {
+	sale := &Sale{
+		Currency: "USD",
+		Payments: []SalePayment{
+			{Method: PaymentMethodCash, Amount: 30, Currency: "USD", Rate: 1, ConvertedAmount: 30},
+			{Method: PaymentMethodMpesa, Amount: 50, Currency: "USD", Rate: 1, ConvertedAmount: 50, Reference: "MP123"},
+		},
+	}
+
+	assert.Equal(t, []SalePayment{
+		{Method: PaymentMethodMpesa, Amount: 50, Currency: "USD", Rate: 1, ConvertedAmount: 50, Reference: "MP123"},
+		{Method: PaymentMethodCash, Amount: 30, Currency: "USD", Rate: 1, ConvertedAmount: 30},
+	}, refundsByMethod(sale, 80))
+
+	assert.Equal(t, []SalePayment{
+		{Method: PaymentMethodMpesa, Amount: 20, Currency: "USD", Rate: 1, ConvertedAmount: 20, Reference: "MP123"},
+	}, refundsByMethod(sale, 20))
+	assert.Empty(t, refundsByMethod(sale, 0))
+
+	// Vente sans paiements enregistrés: remboursement en espèces
+	assert.Equal(t, []SalePayment{{Method: PaymentMethodCash, Amount: 80, Currency: "CDF", Rate: 1, ConvertedAmount: 80}}, refundsByMethod(&Sale{Currency: "CDF"}, 80))
+
+	// Espèces en francs pour une vente en dollars
+	sale = &Sale{
+		Currency:      "USD",
+		ExchangeRates: []SaleExchangeRate{{Currency: "CDF", Rate: 1.0 / 2500}},
+		Payments:      []SalePayment{{Method: PaymentMethodCash, Amount: 50000, Currency: "CDF", Rate: 1.0 / 2500, ConvertedAmount: 20}},
+	}
+	refunds := refundsByMethod(sale, 10)
+	require.Len(t, refunds, 1)
+	assert.Equal(t, "CDF", refunds[0].Currency)
+	assert.Equal(t, 25000.0, refunds[0].Amount)
+}
+
+// TestTenderDescription vérifie les descriptions des transactions de caisse d'une vente
+func TestTenderDescription(t *testing.T) {
+	assert.Equal(t, "Vente - Montant reçu: 30.00 USD", tenderDescription("Vente", SalePayment{Method: PaymentMethodCash, Amount: 30, Currency: "USD"}))
+	assert.Equal(t, "Vente - M-Pesa: 50.00 USD (réf. MP123)", tenderDescription("Vente", SalePayment{Method: PaymentMethodMpesa, Amount: 50, Currency: "USD", Reference: "MP123"}))
+	assert.Equal(t, "Vente - Carte: 20.00 CDF", tenderDescription("Vente", SalePayment{Method: PaymentMethodCard, Amount: 20, Currency: "CDF"}))
+}
+
+// TestTransPaymentMethodTotals vérifie les totaux de caisse par moyen de paiement
+func TestTransPaymentMethodTotals(t *testing.T) {
+	totals := transPaymentMethodTotals([]*Trans{
+		{Amount: 100, Operation: "Entree"},
+		{Amount: 50, Operation: "Entree", PaymentMethod: PaymentMethodMpesa},
+		{Amount: 20, Operation: "Sortie", PaymentMethod: PaymentMethodCash},
+		{Amount: 10, Operation: "Sortie", PaymentMethod: PaymentMethodMpesa},
+	})
+
+	require.Len(t, totals, 2)
+	assert.Equal(t, &CaissePaymentMethodTotal{Method: PaymentMethodCash, Entrees: 100, Sorties: 20, Solde: 80, NombreTransactions: 2}, totals[0])
+	assert.Equal(t, &CaissePaymentMethodTotal{Method: PaymentMethodMpesa, Entrees: 50, Sorties: 10, Solde: 40, NombreTransactions: 2}, totals[1])
+}
+
+// TestGetPaymentMethodStatsDeductsChange vérifie que la monnaie rendue n'est pas comptée comme encaissée
+func TestGetPaymentMethodStatsDeductsChange(t *testing.T) {
+	db := setupTestDB(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := db.database.Collection("sales").Drop(ctx); err != nil {
+			t.Logf("Warning: Failed to drop test collection sales: %v", err)
+		}
+	})
+
+	storeID := primitive.NewObjectID()
+	now := time.Now()
+	sales := []interface{}{
+		// 100 USD payés avec 120 USD en espèces: 20 USD rendus
+		Sale{ID: primitive.NewObjectID(), StoreID: storeID, Currency: "USD", PriceToPay: 100, PricePayed: 120, CreatedAt: now,
+			Payments: []SalePayment{{Method: PaymentMethodCash, Amount: 120, Currency: "USD"}}, ChangeCurrency: "USD", ChangeAmount: 20},
+		// 50 USD payés par M-Pesa (60) avec 10 USD rendus en espèces
+		Sale{ID: primitive.NewObjectID(), StoreID: storeID, Currency: "USD", PriceToPay: 50, PricePayed: 60, CreatedAt: now,
+			Payments: []SalePayment{{Method: PaymentMethodMpesa, Amount: 60, Currency: "USD"}}, ChangeCurrency: "USD", ChangeAmount: 10},
+	}
+	_, err := db.database.Collection("sales").InsertMany(ctx, sales)
+	require.NoError(t, err)
+
+	totals, err := db.GetPaymentMethodStatsByStoreIDs([]primitive.ObjectID{storeID}, nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	byMethod := make(map[string]*PaymentMethodTotal)
+	for _, total := range totals {
+		byMethod[total.Method] = total
+	}
+	require.Len(t, byMethod, 2)
+	assert.Equal(t, 90.0, byMethod[PaymentMethodCash].Amount)
+	assert.Equal(t, int64(1), byMethod[PaymentMethodCash].Count, "The change is not a tender")
+	assert.Equal(t, 60.0, byMethod[PaymentMethodMpesa].Amount)
+	assert.Equal(t, 150.0, byMethod[PaymentMethodCash].Amount+byMethod[PaymentMethodMpesa].Amount, "Total of the sales")
+}
@@ -0,0 +1,505 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// SaleReturnItem is one returned line of the original sale basket
+type SaleReturnItem struct {
+	ProductInStockID primitive.ObjectID `bson:"productInStockId" json:"productInStockId"`
+	Quantity         float64            `bson:"quantity" json:"quantity"`
+	Price            float64            `bson:"price" json:"price"`           // Prix de vente unitaire repris de la vente
+	PriceAchat       float64            `bson:"priceAchat" json:"priceAchat"` // Prix d'achat au moment du retour (pour le bénéfice)
+}
+
+// SaleReturn represents a (partial) customer return against an existing sale
+type SaleReturn struct {
+	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	SaleID       primitive.ObjectID  `bson:"saleId" json:"saleId"`
+	StoreID      primitive.ObjectID  `bson:"storeId" json:"storeId"`
+	ClientID     *primitive.ObjectID `bson:"clientId,omitempty" json:"clientId,omitempty"`
+	DebtID       *primitive.ObjectID `bson:"debtId,omitempty" json:"debtId,omitempty"` // Dette réduite si refundMethod = "debt"
+	Items        []SaleReturnItem    `bson:"items" json:"items"`
+	TotalAmount  float64             `bson:"totalAmount" json:"totalAmount"`
+	Benefice     float64             `bson:"benefice" json:"benefice"` // Marge annulée: sum of (price - priceAchat) * quantity
+	Currency     string              `bson:"currency" json:"currency"`
+	RefundMethod string              `bson:"refundMethod" json:"refundMethod"` // "cash", "debt"
+	Reason       string              `bson:"reason,omitempty" json:"reason,omitempty"`
+	OperatorID   primitive.ObjectID  `bson:"operatorId" json:"operatorId"`
+	CreatedAt    time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+// returnedQuantities sums the quantities already returned per product in stock
+func returnedQuantities(returns []*SaleReturn) map[primitive.ObjectID]float64 {
+	quantities := make(map[primitive.ObjectID]float64)
+	for _, r := range returns {
+		for _, item := range r.Items {
+			quantities[item.ProductInStockID] += item.Quantity
+		}
+	}
+	return quantities
+}
+
+// cashRefunded sums the amounts already paid back from the caisse
+func cashRefunded(returns []*SaleReturn) float64 {
+	var total float64
+	for _, r := range returns {
+		if r.RefundMethod == "cash" {
+			total += r.TotalAmount
+		}
+	}
+	return total
+}
+
+// CreateSaleReturn records a customer return against a sale: the returned quantities go back
+// to the originating ProductInStock and the customer is refunded either from the caisse ("cash")
+// or by reducing the debt of the sale ("debt").
+// All database operations are performed within a MongoDB transaction to ensure atomicity
+func (db *DB) CreateSaleReturn(saleID string, items []SaleReturnItem, refundMethod, reason string, operatorID primitive.ObjectID) (*SaleReturn, error) {
+	if refundMethod != "cash" && refundMethod != "debt" {
+		return nil, utils.ValidationErrorf("Invalid refund method: %s. Valid methods: cash, debt", refundMethod)
+	}
+
+	sale, err := db.FindSaleByID(saleID)
+	if err != nil {
+		return nil, err
+	}
+	if sale.CancelledAt != nil {
+		return nil, utils.ValidationErrorf("Cannot return products of a cancelled sale")
+	}
+
+	previousReturns, err := db.FindSaleReturnsBySaleID(sale.ID)
+	if err != nil {
+		return nil, err
+	}
+	alreadyReturned := returnedQuantities(previousReturns)
+
+	// Quantity and value sold per product in stock (a product may appear on several basket lines),
+	// net of the promotion discounts
+	soldQuantity := make(map[primitive.ObjectID]float64)
+	soldValue := make(map[primitive.ObjectID]float64)
+	for _, line := range sale.Basket {
+		soldQuantity[line.ProductInStockID] += line.Quantity
+		soldValue[line.ProductInStockID] += line.Quantity*line.Price - line.Discount
+	}
+
+	// Merge duplicated items and check them against the basket
+	requested := make(map[primitive.ObjectID]float64)
+	order := make([]primitive.ObjectID, 0, len(items))
+	for _, item := range items {
+		if item.Quantity <= 0 {
+			return nil, utils.ValidationErrorf("Returned quantity must be greater than 0")
+		}
+		if _, ok := requested[item.ProductInStockID]; !ok {
+			order = append(order, item.ProductInStockID)
+		}
+		requested[item.ProductInStockID] += item.Quantity
+	}
+
+	productsInStock := make(map[primitive.ObjectID]*ProductInStock, len(order))
+	returnItems := make([]SaleReturnItem, 0, len(order))
+	var totalAmount, benefice float64
+	for _, productInStockID := range order {
+		quantity := requested[productInStockID]
+		sold, ok := soldQuantity[productInStockID]
+		if !ok {
+			return nil, utils.ValidationErrorf("Product in stock %s is not part of this sale", productInStockID.Hex())
+		}
+		if quantity > sold-alreadyReturned[productInStockID] {
+			return nil, utils.ValidationErrorf(
+				"Cannot return %.2f of product in stock %s: %.2f sold, %.2f already returned",
+				quantity,
+				productInStockID.Hex(),
+				sold,
+				alreadyReturned[productInStockID],
+			)
+		}
+
+		productInStock, err := db.FindProductInStockByID(productInStockID.Hex())
+		if err != nil {
+			return nil, utils.NotFoundErrorf("Product in stock not found: %s", productInStockID.Hex())
+		}
+		productsInStock[productInStockID] = productInStock
+
+		price := soldValue[productInStockID] / sold
+		returnItems = append(returnItems, SaleReturnItem{
+			ProductInStockID: productInStockID,
+			Quantity:         quantity,
+			Price:            price,
+			PriceAchat:       productInStock.PriceAchat,
+		})
+		totalAmount += quantity * price
+		benefice += (price - productInStock.PriceAchat) * quantity
+	}
+
+	// Check the refund can be covered by the chosen method
+	var debt *Debt
+	if refundMethod == "debt" {
+		if sale.DebtID == nil || sale.DebtID.IsZero() {
+			return nil, utils.ValidationErrorf("This sale has no debt to reduce, use the cash refund method")
+		}
+		debt, err = db.GetDebtByID(sale.DebtID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		if debt.Status == "cancelled" {
+			return nil, utils.ValidationErrorf("The debt of this sale is cancelled")
+		}
+		if totalAmount > debt.AmountDue {
+			return nil, utils.ValidationErrorf(
+				"Refund amount (%.2f) exceeds the amount due on the debt (%.2f)",
+				totalAmount,
+				debt.AmountDue,
+			)
+		}
+	} else {
+		// Money actually received for this sale (change excluded), including debt payments
+		received := sale.PricePayed
+		if received > sale.PriceToPay {
+			received = sale.PriceToPay
+		}
+		if sale.DebtID != nil && !sale.DebtID.IsZero() {
+			saleDebt, err := db.GetDebtByID(sale.DebtID.Hex())
+			if err != nil {
+				return nil, err
+			}
+			received = saleDebt.AmountPaid
+		}
+		available := received - cashRefunded(previousReturns)
+		if totalAmount > available {
+			return nil, utils.ValidationErrorf(
+				"Refund amount (%.2f) exceeds the money received for this sale (%.2f available)",
+				totalAmount,
+				available,
+			)
+		}
+	}
+
+	// Start MongoDB transaction
+	session, err := db.client.StartSession()
+	if err != nil {
+		return nil, utils.DatabaseErrorf("start_session", "Error starting transaction session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	// Transaction context with longer timeout for multiple operations
+	txCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	saleReturn := &SaleReturn{
+		ID:           primitive.NewObjectID(),
+		SaleID:       sale.ID,
+		StoreID:      sale.StoreID,
+		ClientID:     sale.ClientID,
+		Items:        returnItems,
+		TotalAmount:  totalAmount,
+		Benefice:     benefice,
+		Currency:     sale.Currency,
+		RefundMethod: refundMethod,
+		Reason:       reason,
+		OperatorID:   operatorID,
+		CreatedAt:    now,
+	}
+	if debt != nil {
+		saleReturn.DebtID = &debt.ID
+	}
+
+	var refund *Trans
+	var movements []*StockMovement
+	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
+		// Start transaction
+		if err := session.StartTransaction(); err != nil {
+			return utils.DatabaseErrorf("start_transaction", "Error starting transaction: %v", err)
+		}
+
+		// Collections
+		saleCollection := colHelper(db, "sales")
+		saleReturnCollection := colHelper(db, "sale_returns")
+		productInStockCollection := colHelper(db, "products_in_stock")
+		debtCollection := colHelper(db, "debts")
+		stockMovementCollection := colHelper(db, "stock_movements")
+
+		// 1. Update the running totals of the sale. Matching on the previous returnedAmount
+		// protects against two concurrent returns both passing the quantity checks above
+		var previousAmount interface{} = sale.ReturnedAmount
+		if sale.ReturnedAmount == 0 {
+			previousAmount = bson.M{"$in": []interface{}{0, nil}}
+		}
+		saleUpdate := bson.M{"updatedAt": now}
+		var newAmountDue float64
+		var newStatus string
+		if debt != nil {
+			newAmountDue = debt.AmountDue - totalAmount
+			newStatus = debt.Status
+			if newAmountDue <= 0 {
+				newAmountDue = 0
+				newStatus = "paid"
+			}
+			saleUpdate["amountDue"] = newAmountDue
+			saleUpdate["debtStatus"] = newStatus
+		}
+		result, err := saleCollection.UpdateOne(
+			sc,
+			bson.M{"_id": sale.ID, "deletedAt": nil, "cancelledAt": nil, "returnedAmount": previousAmount},
+			bson.M{
+				"$inc": bson.M{"returnedAmount": totalAmount, "returnedBenefice": benefice},
+				"$set": saleUpdate,
+			},
+		)
+		if err != nil {
+			return utils.DatabaseErrorf("update_sale_returns", "Error updating sale returns: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return utils.ValidationErrorf("Sale was modified by another operation, please retry")
+		}
+
+		// 2. Record the return
+		_, err = saleReturnCollection.InsertOne(sc, saleReturn)
+		if err != nil {
+			return utils.DatabaseErrorf("create_sale_return", "Error creating sale return: %v", err)
+		}
+
+		// 3. Put the returned quantities back in stock and write ENTREE movements
+		for _, item := range returnItems {
+			productInStock := productsInStock[item.ProductInStockID]
+
+			_, err := productInStockCollection.UpdateOne(
+				sc,
+				bson.M{"_id": productInStock.ID},
+				bson.M{
+					"$inc": bson.M{"stock": item.Quantity},
+					"$set": bson.M{"updatedAt": now},
+				},
+			)
+			if err != nil {
+				return utils.DatabaseErrorf("restore_product_stock", "Error restoring product in stock %s: %v", productInStock.ID.Hex(), err)
+			}
+
+			movement := StockMovement{
+				ID:            primitive.NewObjectID(),
+				ProductID:     productInStock.ProductID,
+				StoreID:       sale.StoreID,
+				Type:          StockMovementTypeEntree,
+				Quantity:      item.Quantity,
+				UnitPrice:     item.Price,
+				TotalValue:    item.Quantity * item.Price,
+				Currency:      sale.Currency,
+				Reason:        fmt.Sprintf("Retour client vente #%s", sale.ID.Hex()),
+				Reference:     fmt.Sprintf("sale-return-%s", saleReturn.ID.Hex()),
+				ReferenceType: "SALE_RETURN",
+				ReferenceID:   &saleReturn.ID,
+				OperatorID:    operatorID,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}
+
+			_, err = stockMovementCollection.InsertOne(sc, movement)
+			if err != nil {
+				return utils.DatabaseErrorf("create_stock_movement", "Error creating stock movement for product %s: %v", productInStock.ProductID.Hex(), err)
+			}
+			movements = append(movements, &movement)
+		}
+
+		// 4. Refund the customer
+		if debt != nil {
+			debtUpdate := bson.M{
+				"amountDue": newAmountDue,
+				"status":    newStatus,
+				"updatedAt": now,
+			}
+			if newStatus == "paid" && debt.PaidAt == nil {
+				debtUpdate["paidAt"] = now
+			}
+			result, err := debtCollection.UpdateOne(
+				sc,
+				bson.M{"_id": debt.ID, "amountDue": debt.AmountDue},
+				bson.M{
+					"$inc": bson.M{"totalAmount": -totalAmount},
+					"$set": debtUpdate,
+				},
+			)
+			if err != nil {
+				return utils.DatabaseErrorf("reduce_debt", "Error reducing debt: %v", err)
+			}
+			if result.MatchedCount == 0 {
+				return utils.ValidationErrorf("Debt was modified by another operation, please retry")
+			}
+		} else if totalAmount > 0 {
+			// Returns are refunded in cash, whatever the tenders of the sale
+			refund = &Trans{
+				Amount:        totalAmount,
+				Operation:     "Sortie",
+				Description:   fmt.Sprintf("Retour client vente #%s - Montant remboursé: %.2f %s", sale.ID.Hex(), totalAmount, sale.Currency),
+				Currency:      sale.Currency,
+				PaymentMethod: PaymentMethodCash,
+				SaleID:        &sale.ID,
+				OperatorID:    operatorID,
+				StoreID:       sale.StoreID,
+				Date:          now,
+			}
+
+			if err := db.writeTrans(sc, refund); err != nil {
+				return utils.DatabaseErrorf("create_caisse_transaction", "Error creating caisse transaction: %v", err)
+			}
+		}
+
+		// Commit transaction
+		if err := session.CommitTransaction(sc); err != nil {
+			return utils.DatabaseErrorf("commit_transaction", "Error committing transaction: %v", err)
+		}
+
+		return nil
+	})
+
+	// Handle transaction errors
+	// MongoDB automatically aborts the transaction if an error occurs in WithSession
+	if err != nil {
+		return nil, err
+	}
+
+	// Flag the returned products for the low stock job (best effort, the return is already recorded)
+	if err := db.MarkProductsForStockCheck(productIDsOfLots(productsInStock)); err != nil {
+		utils.LogError(err, fmt.Sprintf("Failed to flag products of sale return %s for stock check", saleReturn.ID.Hex()))
+	}
+
+	// Notify the subscriptions (dashboards) now that the return is committed
+	var refunds []*Trans
+	if refund != nil {
+		refunds = append(refunds, refund)
+	}
+	db.publishCommitted(refunds, movements)
+
+	return saleReturn, nil
+}
+
+// FindSaleReturnsBySaleID returns all returns recorded against a sale, oldest first
+func (db *DB) FindSaleReturnsBySaleID(saleID primitive.ObjectID) ([]*SaleReturn, error) {
+	saleReturnCollection := colHelper(db, "sale_returns")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	cursor, err := saleReturnCollection.Find(ctx, bson.M{"saleId": saleID}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_sale_returns", "Error finding sale returns: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var returns []*SaleReturn
+	if err = cursor.All(ctx, &returns); err != nil {
+		return nil, utils.DatabaseErrorf("decode_sale_returns", "Error decoding sale returns: %v", err)
+	}
+
+	return returns, nil
+}
+
+// FindSaleReturnsByStoreIDs returns the returns of the given stores, optionally for a single sale
+func (db *DB) FindSaleReturnsByStoreIDs(storeIDs []primitive.ObjectID, saleID *primitive.ObjectID) ([]*SaleReturn, error) {
+	saleReturnCollection := colHelper(db, "sale_returns")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := bson.M{"storeId": bson.M{"$in": storeIDs}}
+	if saleID != nil {
+		filter["saleId"] = *saleID
+	}
+
+	cursor, err := saleReturnCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_sale_returns", "Error finding sale returns: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var returns []*SaleReturn
+	if err = cursor.All(ctx, &returns); err != nil {
+		return nil, utils.DatabaseErrorf("decode_sale_returns", "Error decoding sale returns: %v", err)
+	}
+
+	return returns, nil
+}
+
+// sumReturnsBenefice sums the margin given back by returns for the given stores, currency and period.
+// Returns are attributed to the period in which they were recorded (createdAt).
+func (db *DB) sumReturnsBenefice(storeIDs []primitive.ObjectID, currency *string, startDate, endDate time.Time) (float64, error) {
+	if len(storeIDs) == 0 {
+		return 0, nil
+	}
+
+	saleReturnCollection := colHelper(db, "sale_returns")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	matchFilter := bson.M{"storeId": bson.M{"$in": storeIDs}}
+
+	// Add currency filter (same rule as the sales pipelines)
+	if currency != nil {
+		validCurrencies := map[string]bool{
+			"USD": true,
+			"EUR": true,
+			"CDF": true,
+		}
+		if validCurrencies[*currency] {
+			matchFilter["currency"] = *currency
+		}
+	}
+
+	// Add date filter
+	if !startDate.IsZero() && !endDate.IsZero() {
+		matchFilter["createdAt"] = bson.M{"$gte": startDate, "$lte": endDate}
+	} else if !startDate.IsZero() {
+		matchFilter["createdAt"] = bson.M{"$gte": startDate}
+	} else if !endDate.IsZero() {
+		matchFilter["createdAt"] = bson.M{"$lte": endDate}
+	}
+
+	pipeline := []bson.M{
+		{"$match": matchFilter},
+		// A cancelled sale already gives back its whole margin: its returns are not subtracted again
+		{
+			"$lookup": bson.M{
+				"from":         "sales",
+				"localField":   "saleId",
+				"foreignField": "_id",
+				"as":           "sale",
+			},
+		},
+		{"$match": bson.M{"sale.cancelledAt": nil}},
+		{
+			"$group": bson.M{
+				"_id":           nil,
+				"totalBenefice": bson.M{"$sum": "$benefice"},
+			},
+		},
+	}
+
+	cursor, err := saleReturnCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return 0, utils.DatabaseErrorf("aggregate_returns_benefice", "Error aggregating returns benefice: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []bson.M
+	if err = cursor.All(ctx, &results); err != nil {
+		return 0, utils.DatabaseErrorf("decode_returns_benefice", "Error decoding returns benefice: %v", err)
+	}
+
+	if len(results) > 0 {
+		if totalBenefice, ok := results[0]["totalBenefice"].(float64); ok {
+			return totalBenefice, nil
+		}
+		if totalBenefice, ok := results[0]["totalBenefice"].(int64); ok {
+			return float64(totalBenefice), nil
+		}
+	}
+
+	return 0, nil
+}
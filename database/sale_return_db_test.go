@@ -0,0 +1,36 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestReturnedQuantities vérifie le cumul des quantités retournées par produit en stock
+func TestReturnedQuantities(t *testing.T) {
+	productA := primitive.NewObjectID()
+	productB := primitive.NewObjectID()
+
+	returns := []*SaleReturn{
+		{Items: []SaleReturnItem{{ProductInStockID: productA, Quantity: 1}, {ProductInStockID: productB, Quantity: 2}}},
+		{Items: []SaleReturnItem{{ProductInStockID: productA, Quantity: 0.5}}},
+	}
+
+	quantities := returnedQuantities(returns)
+	assert.Equal(t, 1.5, quantities[productA])
+	assert.Equal(t, 2.0, quantities[productB])
+	assert.Equal(t, 0.0, quantities[primitive.NewObjectID()], "Unknown product should have nothing returned")
+}
+
+// TestCashRefunded vérifie que seuls les remboursements en caisse sont comptés
+func TestCashRefunded(t *testing.T) {
+	returns := []*SaleReturn{
+		{RefundMethod: "cash", TotalAmount: 10},
+		{RefundMethod: "debt", TotalAmount: 25},
+		{RefundMethod: "cash", TotalAmount: 5},
+	}
+
+	assert.Equal(t, 15.0, cashRefunded(returns))
+	assert.Equal(t, 0.0, cashRefunded(nil))
+}
@@ -0,0 +1,179 @@
+package database
+
+import (
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Maximum lengths stored for the client provided device information
+const (
+	maxDeviceNameLength = 100
+	maxUserAgentLength  = 512
+)
+
+// DeviceInfo describes the device a user logs in from
+type DeviceInfo struct {
+	DeviceName string
+	IPAddress  string
+	UserAgent  string
+}
+
+// Session is a login of a user on a device. Its ID is the family of the tokens issued
+// for this login (see RefreshToken): revoking the session revokes its tokens
+type Session struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID        primitive.ObjectID `bson:"userId" json:"userId"`
+	CompanyID     primitive.ObjectID `bson:"companyId" json:"companyId"`
+	DeviceName    string             `bson:"deviceName,omitempty" json:"deviceName,omitempty"`
+	IPAddress     string             `bson:"ipAddress,omitempty" json:"ipAddress,omitempty"`
+	UserAgent     string             `bson:"userAgent,omitempty" json:"userAgent,omitempty"`
+	LastSeenAt    time.Time          `bson:"lastSeenAt" json:"lastSeenAt"` // Dernier refresh des tokens
+	ExpiresAt     time.Time          `bson:"expiresAt" json:"expiresAt"`   // Expiration du dernier refresh token
+	RevokedAt     *time.Time         `bson:"revokedAt,omitempty" json:"revokedAt,omitempty"`
+	RevokedReason string             `bson:"revokedReason,omitempty" json:"revokedReason,omitempty"`
+	CreatedAt     time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// IsActive tells whether the tokens of the session can still be used
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && s.ExpiresAt.After(now)
+}
+
+// truncate cuts a client provided value to the stored length
+func truncate(value string, maxLength int) string {
+	runes := []rune(value)
+	if len(runes) <= maxLength {
+		return value
+	}
+	return string(runes[:maxLength])
+}
+
+// CreateSession records a new login of the user
+func (db *DB) CreateSession(userID, companyID primitive.ObjectID, device DeviceInfo, expiresAt time.Time) (*Session, error) {
+	sessionCollection := colHelper(db, "sessions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	session := Session{
+		ID:         primitive.NewObjectID(),
+		UserID:     userID,
+		CompanyID:  companyID,
+		DeviceName: truncate(device.DeviceName, maxDeviceNameLength),
+		IPAddress:  device.IPAddress,
+		UserAgent:  truncate(device.UserAgent, maxUserAgentLength),
+		LastSeenAt: now,
+		ExpiresAt:  expiresAt,
+		CreatedAt:  now,
+	}
+
+	_, err := sessionCollection.InsertOne(ctx, session)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("create_session", "Error creating session: %v", err)
+	}
+
+	return &session, nil
+}
+
+// FindSessionByID finds a session by ID
+func (db *DB) FindSessionByID(id string) (*Session, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, utils.ValidationErrorf("Invalid session ID")
+	}
+
+	sessionCollection := colHelper(db, "sessions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var session Session
+	err = sessionCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&session)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("Session not found")
+		}
+		return nil, utils.DatabaseErrorf("find_session", "Error finding session: %v", err)
+	}
+
+	return &session, nil
+}
+
+// FindActiveSessionsByUserID returns the sessions of the user that are neither revoked nor expired, most recent first
+func (db *DB) FindActiveSessionsByUserID(userID primitive.ObjectID) ([]*Session, error) {
+	sessionCollection := colHelper(db, "sessions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := bson.M{
+		"userId":    userID,
+		"revokedAt": bson.M{"$exists": false},
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "lastSeenAt", Value: -1}})
+
+	cursor, err := sessionCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_sessions", "Error finding sessions: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var sessions []*Session
+	if err = cursor.All(ctx, &sessions); err != nil {
+		return nil, utils.DatabaseErrorf("find_sessions", "Error decoding sessions: %v", err)
+	}
+	if sessions == nil {
+		sessions = []*Session{}
+	}
+
+	return sessions, nil
+}
+
+// TouchSession records the use of the session (token refresh) and extends its expiration
+func (db *DB) TouchSession(id string, expiresAt time.Time) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil // Famille de tokens sans session
+	}
+
+	sessionCollection := colHelper(db, "sessions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err = sessionCollection.UpdateOne(ctx,
+		bson.M{"_id": objectID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"lastSeenAt": time.Now(), "expiresAt": expiresAt}},
+	)
+	if err != nil {
+		return utils.DatabaseErrorf("touch_session", "Error updating session: %v", err)
+	}
+
+	return nil
+}
+
+// markSessionRevoked records the revocation on the session of a token family
+func (db *DB) markSessionRevoked(familyID, reason string) error {
+	objectID, err := primitive.ObjectIDFromHex(familyID)
+	if err != nil {
+		return nil // Famille de tokens sans session
+	}
+
+	sessionCollection := colHelper(db, "sessions")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err = sessionCollection.UpdateOne(ctx,
+		bson.M{"_id": objectID, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now(), "revokedReason": reason}},
+	)
+	if err != nil {
+		return utils.DatabaseErrorf("revoke_session", "Error revoking session: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,26 @@
+package database
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestSessionIsActive vérifie qu'une session révoquée ou expirée n'est plus active
+func TestSessionIsActive(t *testing.T) {
+	now := time.Now()
+	revokedAt := now.Add(-time.Minute)
+
+	assert.True(t, (&Session{ExpiresAt: now.Add(time.Hour)}).IsActive(now))
+	assert.False(t, (&Session{ExpiresAt: now.Add(-time.Hour)}).IsActive(now), "Expired session")
+	assert.False(t, (&Session{ExpiresAt: now.Add(time.Hour), RevokedAt: &revokedAt}).IsActive(now), "Revoked session")
+}
+
+// TestTruncate vérifie la troncature des informations envoyées par l'appareil
+func TestTruncate(t *testing.T) {
+	assert.Equal(t, "Tablette caisse", truncate("Tablette caisse", maxDeviceNameLength))
+	assert.Len(t, truncate(strings.Repeat("a", 600), maxUserAgentLength), maxUserAgentLength)
+	assert.Equal(t, "Télé", truncate("Téléphone", 4), "Truncation keeps whole characters")
+}
@@ -0,0 +1,324 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Stock alert statuses
+const (
+	StockAlertStatusOpen     = "open"
+	StockAlertStatusResolved = "resolved"
+)
+
+// StockAlert is the notification recorded when the stock of a product falls below its reorder point
+type StockAlert struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	ProductID       primitive.ObjectID `bson:"productId" json:"productId"`
+	StoreID         primitive.ObjectID `bson:"storeId" json:"storeId"`
+	Stock           float64            `bson:"stock" json:"stock"` // Stock vendable au moment de l'alerte
+	ReorderPoint    float64            `bson:"reorderPoint" json:"reorderPoint"`
+	ReorderQuantity float64            `bson:"reorderQuantity" json:"reorderQuantity"`
+	Status          string             `bson:"status" json:"status"` // "open", "resolved"
+	ResolvedAt      *time.Time         `bson:"resolvedAt,omitempty" json:"resolvedAt,omitempty"`
+	CreatedAt       time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt       time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// LowStockProduct is a product whose sellable stock is at or below its reorder point
+type LowStockProduct struct {
+	Product *Product
+	Stock   float64
+}
+
+// stockAlertTransition tells what to do with the alert of a product after a stock change:
+// "open" when the stock is at or below the reorder point without an open alert,
+// "resolve" when the stock went back above it, "" otherwise
+func stockAlertTransition(stock, reorderPoint float64, hasOpenAlert bool) string {
+	if reorderPoint <= 0 {
+		if hasOpenAlert {
+			return "resolve"
+		}
+		return ""
+	}
+	if stock <= reorderPoint && !hasOpenAlert {
+		return "open"
+	}
+	if stock > reorderPoint && hasOpenAlert {
+		return "resolve"
+	}
+	return ""
+}
+
+// SetProductReorderPoint sets the reorder point and quantity of a product (0 disables the alerts)
+func (db *DB) SetProductReorderPoint(productID string, reorderPoint, reorderQuantity float64) (*Product, error) {
+	if reorderPoint < 0 || reorderQuantity < 0 {
+		return nil, utils.ValidationErrorf("Reorder point and quantity cannot be negative")
+	}
+
+	product, err := db.FindProductByID(productID)
+	if err != nil {
+		return nil, err
+	}
+
+	productCollection := colHelper(db, "products")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	// The new threshold is checked by the next run of the low stock job
+	_, err = productCollection.UpdateOne(ctx, bson.M{"_id": product.ID}, bson.M{
+		"$set": bson.M{
+			"reorderPoint":      reorderPoint,
+			"reorderQuantity":   reorderQuantity,
+			"stockCheckPending": true,
+			"updatedAt":         time.Now(),
+		},
+		"$inc": bson.M{"stockCheckVersion": 1},
+	})
+	if err != nil {
+		return nil, utils.DatabaseErrorf("update_product", "Error updating reorder point: %v", err)
+	}
+
+	return db.FindProductByID(productID)
+}
+
+// MarkProductsForStockCheck flags products whose stock changed so the low stock job re-checks them.
+// Each flag increments stockCheckVersion: a product flagged again while the job runs stays flagged
+func (db *DB) MarkProductsForStockCheck(productIDs []primitive.ObjectID) error {
+	if len(productIDs) == 0 {
+		return nil
+	}
+
+	productCollection := colHelper(db, "products")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := productCollection.UpdateMany(ctx,
+		bson.M{"_id": bson.M{"$in": productIDs}, "reorderPoint": bson.M{"$gt": 0}},
+		bson.M{"$set": bson.M{"stockCheckPending": true}, "$inc": bson.M{"stockCheckVersion": 1}},
+	)
+	if err != nil {
+		return utils.DatabaseErrorf("mark_stock_check", "Error flagging products for stock check: %v", err)
+	}
+	return nil
+}
+
+// productIDsOfLots returns the products of the lots, to flag them for the low stock job
+func productIDsOfLots(productsInStock map[primitive.ObjectID]*ProductInStock) []primitive.ObjectID {
+	productIDs := make([]primitive.ObjectID, 0, len(productsInStock))
+	for _, productInStock := range productsInStock {
+		productIDs = append(productIDs, productInStock.ProductID)
+	}
+	return productIDs
+}
+
+// sellableStockByProduct sums the unexpired stock of each product (products are scoped to one store)
+func (db *DB) sellableStockByProduct(productIDs []primitive.ObjectID) (map[primitive.ObjectID]float64, error) {
+	productInStockCollection := colHelper(db, "products_in_stock")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	pipeline := []bson.M{
+		{
+			"$match": bson.M{
+				"productId": bson.M{"$in": productIDs},
+				"$or": []bson.M{
+					{"expiryDate": nil},
+					{"expiryDate": bson.M{"$gt": time.Now()}},
+				},
+			},
+		},
+		{
+			"$group": bson.M{
+				"_id":   "$productId",
+				"stock": bson.M{"$sum": "$stock"},
+			},
+		},
+	}
+
+	cursor, err := productInStockCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("sellable_stock", "Error calculating stock levels: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		ProductID primitive.ObjectID `bson:"_id"`
+		Stock     float64            `bson:"stock"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, utils.DatabaseErrorf("sellable_stock", "Error decoding stock levels: %v", err)
+	}
+
+	levels := make(map[primitive.ObjectID]float64, len(results))
+	for _, result := range results {
+		levels[result.ProductID] = result.Stock
+	}
+	return levels, nil
+}
+
+// FindLowStockProducts returns the products of the stores with a reorder point whose sellable stock is at or below it
+func (db *DB) FindLowStockProducts(storeIDs []primitive.ObjectID) ([]*LowStockProduct, error) {
+	productCollection := colHelper(db, "products")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	cursor, err := productCollection.Find(ctx, bson.M{
+		"storeId":      bson.M{"$in": storeIDs},
+		"reorderPoint": bson.M{"$gt": 0},
+		"deletedAt":    nil,
+	})
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_low_stock_products", "Error finding products: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var products []*Product
+	if err = cursor.All(ctx, &products); err != nil {
+		return nil, utils.DatabaseErrorf("find_low_stock_products", "Error decoding products: %v", err)
+	}
+	if len(products) == 0 {
+		return []*LowStockProduct{}, nil
+	}
+
+	productIDs := make([]primitive.ObjectID, 0, len(products))
+	for _, product := range products {
+		productIDs = append(productIDs, product.ID)
+	}
+	levels, err := db.sellableStockByProduct(productIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	lowStock := []*LowStockProduct{}
+	for _, product := range products {
+		if levels[product.ID] <= product.ReorderPoint {
+			lowStock = append(lowStock, &LowStockProduct{Product: product, Stock: levels[product.ID]})
+		}
+	}
+	return lowStock, nil
+}
+
+// stockCheckClearFilter matches the product only if it was not flagged again since it was loaded
+// (products flagged before stockCheckVersion existed have no version)
+func stockCheckClearFilter(product *Product) bson.M {
+	if product.StockCheckVersion == 0 {
+		return bson.M{"_id": product.ID, "stockCheckVersion": nil}
+	}
+	return bson.M{"_id": product.ID, "stockCheckVersion": product.StockCheckVersion}
+}
+
+// ProcessPendingStockChecks re-checks the products flagged by MarkProductsForStockCheck and records
+// an open stock alert when a product crosses below its reorder point (resolved once restocked).
+// It returns the number of alerts opened.
+func (db *DB) ProcessPendingStockChecks() (int, error) {
+	productCollection := colHelper(db, "products")
+	alertCollection := colHelper(db, "stock_alerts")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	cursor, err := productCollection.Find(ctx, bson.M{"stockCheckPending": true})
+	if err != nil {
+		return 0, utils.DatabaseErrorf("find_pending_stock_checks", "Error finding products to check: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var products []*Product
+	if err = cursor.All(ctx, &products); err != nil {
+		return 0, utils.DatabaseErrorf("find_pending_stock_checks", "Error decoding products: %v", err)
+	}
+	if len(products) == 0 {
+		return 0, nil
+	}
+
+	productIDs := make([]primitive.ObjectID, 0, len(products))
+	for _, product := range products {
+		productIDs = append(productIDs, product.ID)
+	}
+	levels, err := db.sellableStockByProduct(productIDs)
+	if err != nil {
+		return 0, err
+	}
+
+	opened := 0
+	for _, product := range products {
+		stock := levels[product.ID]
+		now := time.Now()
+
+		var openAlert StockAlert
+		err := alertCollection.FindOne(ctx, bson.M{"productId": product.ID, "status": StockAlertStatusOpen}).Decode(&openAlert)
+		if err != nil && err != mongo.ErrNoDocuments {
+			utils.LogError(err, fmt.Sprintf("Failed to load stock alert for product %s", product.ID.Hex()))
+			continue
+		}
+		hasOpenAlert := err == nil
+
+		switch stockAlertTransition(stock, product.ReorderPoint, hasOpenAlert) {
+		case "open":
+			alert := StockAlert{
+				ID:              primitive.NewObjectID(),
+				ProductID:       product.ID,
+				StoreID:         product.StoreID,
+				Stock:           stock,
+				ReorderPoint:    product.ReorderPoint,
+				ReorderQuantity: product.ReorderQuantity,
+				Status:          StockAlertStatusOpen,
+				CreatedAt:       now,
+				UpdatedAt:       now,
+			}
+			if _, err := alertCollection.InsertOne(ctx, alert); err != nil {
+				utils.LogError(err, fmt.Sprintf("Failed to record stock alert for product %s", product.ID.Hex()))
+				continue
+			}
+			opened++
+		case "resolve":
+			_, err := alertCollection.UpdateOne(ctx, bson.M{"_id": openAlert.ID}, bson.M{
+				"$set": bson.M{"status": StockAlertStatusResolved, "resolvedAt": now, "updatedAt": now},
+			})
+			if err != nil {
+				utils.LogError(err, fmt.Sprintf("Failed to resolve stock alert for product %s", product.ID.Hex()))
+				continue
+			}
+		}
+
+		_, err = productCollection.UpdateOne(ctx, stockCheckClearFilter(product), bson.M{"$unset": bson.M{"stockCheckPending": ""}})
+		if err != nil {
+			utils.LogError(err, fmt.Sprintf("Failed to clear stock check flag for product %s", product.ID.Hex()))
+		}
+	}
+
+	return opened, nil
+}
+
+// GetStockAlertsByStoreIDs lists the stock alerts of the stores, most recent first
+func (db *DB) GetStockAlertsByStoreIDs(storeIDs []primitive.ObjectID, status *string) ([]*StockAlert, error) {
+	alertCollection := colHelper(db, "stock_alerts")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := bson.M{"storeId": bson.M{"$in": storeIDs}}
+	if status != nil && *status != "" {
+		filter["status"] = *status
+	}
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}})
+
+	cursor, err := alertCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_stock_alerts", "Error finding stock alerts: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var alerts []*StockAlert
+	if err = cursor.All(ctx, &alerts); err != nil {
+		return nil, utils.DatabaseErrorf("find_stock_alerts", "Error decoding stock alerts: %v", err)
+	}
+
+	return alerts, nil
+}
@@ -0,0 +1,27 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TestStockAlertTransition vérifie l'ouverture et la résolution des alertes de stock faible
+func TestStockAlertTransition(t *testing.T) {
+	assert.Equal(t, "open", stockAlertTransition(4, 5, false), "Stock below the reorder point opens an alert")
+	assert.Equal(t, "open", stockAlertTransition(5, 5, false), "Stock at the reorder point opens an alert")
+	assert.Equal(t, "", stockAlertTransition(3, 5, true), "An open alert is not duplicated")
+	assert.Equal(t, "resolve", stockAlertTransition(12, 5, true), "Restocking resolves the alert")
+	assert.Equal(t, "", stockAlertTransition(12, 5, false))
+	assert.Equal(t, "resolve", stockAlertTransition(0, 0, true), "Disabling the reorder point resolves the alert")
+	assert.Equal(t, "", stockAlertTransition(0, 0, false))
+}
+
+// TestStockCheckClearFilter vérifie qu'un produit signalé pendant la vérification reste signalé
+func TestStockCheckClearFilter(t *testing.T) {
+	id := primitive.NewObjectID()
+	assert.Equal(t, bson.M{"_id": id, "stockCheckVersion": int64(3)}, stockCheckClearFilter(&Product{ID: id, StockCheckVersion: 3}))
+	assert.Equal(t, bson.M{"_id": id, "stockCheckVersion": nil}, stockCheckClearFilter(&Product{ID: id}), "Products flagged before the version have none")
+}
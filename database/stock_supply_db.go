@@ -22,6 +22,8 @@ type StockSupply struct {
 	OperatorID      primitive.ObjectID  `bson:"operatorId" json:"operatorId"`
 	PaymentType     string              `bson:"paymentType" json:"paymentType"` // "cash" or "debt"
 	ProviderDebtID  *primitive.ObjectID `bson:"providerDebtId,omitempty" json:"providerDebtId,omitempty"`
+	BatchNumber     string              `bson:"batchNumber,omitempty" json:"batchNumber,omitempty"`
+	ExpiryDate      *time.Time          `bson:"expiryDate,omitempty" json:"expiryDate,omitempty"`
 	Date            time.Time           `bson:"date" json:"date"`
 	CreatedAt       time.Time           `bson:"createdAt" json:"createdAt"`
 	UpdatedAt       time.Time           `bson:"updatedAt" json:"updatedAt"`
@@ -36,28 +38,13 @@ func (db *DB) CreateStockSupply(
 	paymentType string,
 	providerDebtID *primitive.ObjectID,
 	date time.Time,
+	batchNumber string,
+	expiryDate *time.Time,
 ) (*StockSupply, error) {
-	supplyCollection := colHelper(db, "stock_supplies")
 	ctx, cancel := GetDBContext()
 	defer cancel()
 
-	// Validate payment type
-	if paymentType != "cash" && paymentType != "debt" {
-		return nil, gqlerror.Errorf("Invalid payment type: %s. Valid types: cash, debt", paymentType)
-	}
-
-	// Validate prices
-	if priceVente < priceAchat {
-		return nil, gqlerror.Errorf("Price de vente must be >= price d'achat")
-	}
-
-	// Validate quantity
-	if quantity <= 0 {
-		return nil, gqlerror.Errorf("Quantity must be greater than 0")
-	}
-
 	stockSupply := StockSupply{
-		ID:               primitive.NewObjectID(),
 		ProductID:        productID,
 		ProductInStockID: productInStockID,
 		Quantity:         quantity,
@@ -69,17 +56,46 @@ func (db *DB) CreateStockSupply(
 		OperatorID:       operatorID,
 		PaymentType:      paymentType,
 		ProviderDebtID:   providerDebtID,
+		BatchNumber:      batchNumber,
+		ExpiryDate:       expiryDate,
 		Date:             date,
-		CreatedAt:        time.Now(),
-		UpdatedAt:        time.Now(),
+	}
+	if err := db.writeStockSupply(ctx, &stockSupply); err != nil {
+		return nil, err
+	}
+
+	return &stockSupply, nil
+}
+
+// writeStockSupply validates and inserts a stock supply with ctx (a session context within a MongoDB transaction)
+func (db *DB) writeStockSupply(ctx context.Context, supply *StockSupply) error {
+	// Validate payment type
+	if supply.PaymentType != "cash" && supply.PaymentType != "debt" {
+		return gqlerror.Errorf("Invalid payment type: %s. Valid types: cash, debt", supply.PaymentType)
+	}
+
+	// Validate prices
+	if supply.PriceVente < supply.PriceAchat {
+		return gqlerror.Errorf("Price de vente must be >= price d'achat")
 	}
 
-	_, err := supplyCollection.InsertOne(ctx, stockSupply)
+	// Validate quantity
+	if supply.Quantity <= 0 {
+		return gqlerror.Errorf("Quantity must be greater than 0")
+	}
+
+	if supply.ID.IsZero() {
+		supply.ID = primitive.NewObjectID()
+	}
+	supply.CreatedAt = time.Now()
+	supply.UpdatedAt = time.Now()
+
+	_, err := colHelper(db, "stock_supplies").InsertOne(ctx, supply)
 	if err != nil {
-		return nil, gqlerror.Errorf("Error creating stock supply: %v", err)
+		return gqlerror.Errorf("Error creating stock supply: %v", err)
 	}
 
-	return &stockSupply, nil
+	return nil
 }
 
 // FindStockSupplyByID finds a stock supply by ID
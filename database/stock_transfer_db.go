@@ -0,0 +1,739 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// StockTransferStatus represents the status of a stock transfer
+const (
+	StockTransferStatusDraft     = "draft"     // Préparé, le stock n'a pas encore bougé
+	StockTransferStatusShipped   = "shipped"   // Expédié: stock sorti de la boutique source
+	StockTransferStatusReceived  = "received"  // Reçu: stock entré dans la boutique destination
+	StockTransferStatusCancelled = "cancelled" // Annulé avant expédition
+)
+
+// StockTransfer represents goods moved from one store to another of the same company
+type StockTransfer struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id"`
+	CompanyID   primitive.ObjectID  `bson:"companyId" json:"companyId"`
+	FromStoreID primitive.ObjectID  `bson:"fromStoreId" json:"fromStoreId"`
+	ToStoreID   primitive.ObjectID  `bson:"toStoreId" json:"toStoreId"`
+	Status      string              `bson:"status" json:"status"` // "draft", "shipped", "received", "cancelled"
+	Items       []StockTransferItem `bson:"items" json:"items"`
+	Note        string              `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedBy   primitive.ObjectID  `bson:"createdBy" json:"createdBy"`
+	ShippedBy   *primitive.ObjectID `bson:"shippedBy,omitempty" json:"shippedBy,omitempty"`
+	ShippedAt   *time.Time          `bson:"shippedAt,omitempty" json:"shippedAt,omitempty"`
+	ReceivedBy  *primitive.ObjectID `bson:"receivedBy,omitempty" json:"receivedBy,omitempty"`
+	ReceivedAt  *time.Time          `bson:"receivedAt,omitempty" json:"receivedAt,omitempty"`
+	CancelledAt *time.Time          `bson:"cancelledAt,omitempty" json:"cancelledAt,omitempty"`
+	CreatedAt   time.Time           `bson:"createdAt" json:"createdAt"`
+	UpdatedAt   time.Time           `bson:"updatedAt" json:"updatedAt"`
+}
+
+// StockTransferItem represents one product moved by a transfer
+type StockTransferItem struct {
+	SourceProductInStockID      primitive.ObjectID  `bson:"sourceProductInStockId" json:"sourceProductInStockId"`
+	DestinationProductInStockID *primitive.ObjectID `bson:"destinationProductInStockId,omitempty" json:"destinationProductInStockId,omitempty"` // Renseigné à la réception
+	DestinationProductID        *primitive.ObjectID `bson:"destinationProductId,omitempty" json:"destinationProductId,omitempty"`               // Produit de la boutique destination
+	ProductID                   primitive.ObjectID  `bson:"productId" json:"productId"`
+	Quantity                    float64             `bson:"quantity" json:"quantity"`                                       // Quantité expédiée
+	ReceivedQuantity            float64             `bson:"receivedQuantity" json:"receivedQuantity"`                       // Quantité reçue
+	Discrepancy                 float64             `bson:"discrepancy" json:"discrepancy"`                                 // Écart (receivedQuantity - quantity)
+	DiscrepancyReason           string              `bson:"discrepancyReason,omitempty" json:"discrepancyReason,omitempty"` // Raison de l'écart (casse, perte, etc.)
+	PriceAchat                  float64             `bson:"priceAchat" json:"priceAchat"`                                   // Prix d'achat de la boutique source
+	PriceVente                  float64             `bson:"priceVente" json:"priceVente"`                                   // Prix de vente de la boutique source
+	Currency                    string              `bson:"currency" json:"currency"`                                       // Devise de la boutique source
+}
+
+// StockTransferReceipt is the quantity actually received for one transfer item
+type StockTransferReceipt struct {
+	SourceProductInStockID primitive.ObjectID
+	ReceivedQuantity       float64
+	Reason                 string
+}
+
+// CreateStockTransfer creates a draft transfer between two stores of the same company.
+// Stock is only checked here; it moves when the transfer is shipped.
+func (db *DB) CreateStockTransfer(fromStoreID, toStoreID primitive.ObjectID, items []StockTransferItem, note string, operatorID primitive.ObjectID) (*StockTransfer, error) {
+	if fromStoreID == toStoreID {
+		return nil, utils.ValidationErrorf("Source and destination stores must be different")
+	}
+	if len(items) == 0 {
+		return nil, utils.ValidationErrorf("A transfer must contain at least one product")
+	}
+
+	fromStore, err := db.FindStoreByID(fromStoreID.Hex())
+	if err != nil {
+		return nil, utils.NotFoundErrorf("Source store not found")
+	}
+	toStore, err := db.FindStoreByID(toStoreID.Hex())
+	if err != nil {
+		return nil, utils.NotFoundErrorf("Destination store not found")
+	}
+	if fromStore.CompanyID != toStore.CompanyID {
+		return nil, utils.ValidationErrorf("Stores must belong to the same company")
+	}
+
+	seen := make(map[primitive.ObjectID]bool, len(items))
+	transferItems := make([]StockTransferItem, 0, len(items))
+	for _, item := range items {
+		if seen[item.SourceProductInStockID] {
+			return nil, utils.ValidationErrorf("Product in stock %s appears more than once", item.SourceProductInStockID.Hex())
+		}
+		seen[item.SourceProductInStockID] = true
+
+		if item.Quantity <= 0 {
+			return nil, utils.ValidationErrorf("Quantity must be greater than 0")
+		}
+		productInStock, err := db.FindProductInStockByID(item.SourceProductInStockID.Hex())
+		if err != nil {
+			return nil, utils.NotFoundErrorf("Product in stock not found: %s", item.SourceProductInStockID.Hex())
+		}
+		if productInStock.StoreID != fromStoreID {
+			return nil, utils.ValidationErrorf("Product in stock %s does not belong to the source store", item.SourceProductInStockID.Hex())
+		}
+		if productInStock.Stock < item.Quantity {
+			return nil, utils.ValidationErrorf("Insufficient stock for product in stock %s", item.SourceProductInStockID.Hex())
+		}
+
+		transferItems = append(transferItems, StockTransferItem{
+			SourceProductInStockID: productInStock.ID,
+			ProductID:              productInStock.ProductID,
+			Quantity:               item.Quantity,
+			PriceAchat:             productInStock.PriceAchat,
+			PriceVente:             productInStock.PriceVente,
+			Currency:               productInStock.Currency,
+		})
+	}
+
+	transferCollection := colHelper(db, "stock_transfers")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	transfer := StockTransfer{
+		ID:          primitive.NewObjectID(),
+		CompanyID:   fromStore.CompanyID,
+		FromStoreID: fromStoreID,
+		ToStoreID:   toStoreID,
+		Status:      StockTransferStatusDraft,
+		Items:       transferItems,
+		Note:        note,
+		CreatedBy:   operatorID,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+
+	_, err = transferCollection.InsertOne(ctx, transfer)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("create_stock_transfer", "Error creating stock transfer: %v", err)
+	}
+
+	return &transfer, nil
+}
+
+// ShipStockTransfer takes the goods out of the source store and writes the SORTIE movements.
+// All database operations are performed within a MongoDB transaction to ensure atomicity
+func (db *DB) ShipStockTransfer(transferID string, operatorID primitive.ObjectID) (*StockTransfer, error) {
+	transfer, err := db.GetStockTransferByID(transferID)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.Status != StockTransferStatusDraft {
+		return nil, utils.ValidationErrorf("Only a draft transfer can be shipped (current status: %s)", transfer.Status)
+	}
+
+	// Start MongoDB transaction
+	session, err := db.client.StartSession()
+	if err != nil {
+		return nil, utils.DatabaseErrorf("start_session", "Error starting transaction session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	// Transaction context with longer timeout for multiple operations
+	txCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var movements []*StockMovement
+	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
+		// Start transaction
+		if err := session.StartTransaction(); err != nil {
+			return utils.DatabaseErrorf("start_transaction", "Error starting transaction: %v", err)
+		}
+
+		// Collections
+		transferCollection := colHelper(db, "stock_transfers")
+		productInStockCollection := colHelper(db, "products_in_stock")
+		stockMovementCollection := colHelper(db, "stock_movements")
+
+		// 1. Move the transfer to shipped (the status guard protects against a double shipment)
+		result, err := transferCollection.UpdateOne(
+			sc,
+			bson.M{"_id": transfer.ID, "status": StockTransferStatusDraft},
+			bson.M{"$set": bson.M{
+				"status":    StockTransferStatusShipped,
+				"shippedBy": operatorID,
+				"shippedAt": now,
+				"updatedAt": now,
+			}},
+		)
+		if err != nil {
+			return utils.DatabaseErrorf("ship_stock_transfer", "Error shipping stock transfer: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return utils.ValidationErrorf("Transfer has already been shipped or cancelled")
+		}
+
+		// 2. Take the goods out of the source store
+		for _, item := range transfer.Items {
+			result, err := productInStockCollection.UpdateOne(
+				sc,
+				bson.M{"_id": item.SourceProductInStockID, "stock": bson.M{"$gte": item.Quantity}},
+				bson.M{
+					"$inc": bson.M{"stock": -item.Quantity},
+					"$set": bson.M{"updatedAt": now},
+				},
+			)
+			if err != nil {
+				return utils.DatabaseErrorf("update_product_stock", "Error updating product in stock %s: %v", item.SourceProductInStockID.Hex(), err)
+			}
+			if result.MatchedCount == 0 {
+				return utils.ValidationErrorf("Insufficient stock for product in stock %s", item.SourceProductInStockID.Hex())
+			}
+
+			movement := StockMovement{
+				ID:            primitive.NewObjectID(),
+				ProductID:     item.ProductID,
+				StoreID:       transfer.FromStoreID,
+				Type:          StockMovementTypeSortie,
+				Quantity:      item.Quantity,
+				UnitPrice:     item.PriceAchat,
+				TotalValue:    item.Quantity * item.PriceAchat,
+				Currency:      item.Currency,
+				Reason:        fmt.Sprintf("Transfert #%s vers boutique %s", transfer.ID.Hex(), transfer.ToStoreID.Hex()),
+				Reference:     fmt.Sprintf("transfer-%s", transfer.ID.Hex()),
+				ReferenceType: "TRANSFER",
+				ReferenceID:   &transfer.ID,
+				OperatorID:    operatorID,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}
+
+			_, err = stockMovementCollection.InsertOne(sc, movement)
+			if err != nil {
+				return utils.DatabaseErrorf("create_stock_movement", "Error creating stock movement for product %s: %v", item.ProductID.Hex(), err)
+			}
+			movements = append(movements, &movement)
+		}
+
+		// Commit transaction
+		if err := session.CommitTransaction(sc); err != nil {
+			return utils.DatabaseErrorf("commit_transaction", "Error committing transaction: %v", err)
+		}
+
+		return nil
+	})
+
+	// Handle transaction errors
+	// MongoDB automatically aborts the transaction if an error occurs in WithSession
+	if err != nil {
+		return nil, err
+	}
+
+	// Flag the shipped products for the low stock job (best effort, the shipment is already recorded)
+	shippedProductIDs := make([]primitive.ObjectID, 0, len(transfer.Items))
+	for _, item := range transfer.Items {
+		shippedProductIDs = append(shippedProductIDs, item.ProductID)
+	}
+	if err := db.MarkProductsForStockCheck(shippedProductIDs); err != nil {
+		utils.LogError(err, fmt.Sprintf("Failed to flag products of transfer %s for stock check", transfer.ID.Hex()))
+	}
+
+	// Notify the subscriptions (dashboards) now that the shipment is committed
+	db.publishCommitted(nil, movements)
+
+	return db.GetStockTransferByID(transferID)
+}
+
+// ReceiveStockTransfer puts the received goods in the destination store and records the
+// discrepancies with the shipped quantities. Items without a receipt are considered fully received.
+// The product is matched in the destination store (barcode, SKU, then name and mark) or created there.
+// The destination ProductInStock (same product and currency) is incremented, or created with the
+// source prices converted to the destination store currency. A missing quantity is recorded as an
+// AJUSTEMENT movement of the destination store.
+// All database operations are performed within a MongoDB transaction to ensure atomicity
+func (db *DB) ReceiveStockTransfer(transferID string, receipts []StockTransferReceipt, operatorID primitive.ObjectID) (*StockTransfer, error) {
+	transfer, err := db.GetStockTransferByID(transferID)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.Status != StockTransferStatusShipped {
+		return nil, utils.ValidationErrorf("Only a shipped transfer can be received (current status: %s)", transfer.Status)
+	}
+
+	receiptByItem := make(map[primitive.ObjectID]StockTransferReceipt, len(receipts))
+	for _, receipt := range receipts {
+		if _, ok := receiptByItem[receipt.SourceProductInStockID]; ok {
+			return nil, utils.ValidationErrorf("Duplicate receipt for product in stock %s", receipt.SourceProductInStockID.Hex())
+		}
+		receiptByItem[receipt.SourceProductInStockID] = receipt
+	}
+
+	toStore, err := db.FindStoreByID(transfer.ToStoreID.Hex())
+	if err != nil {
+		return nil, utils.NotFoundErrorf("Destination store not found")
+	}
+	destinationCurrency := toStore.DefaultCurrency
+	if destinationCurrency == "" {
+		destinationCurrency = "USD" // Fallback to USD
+	}
+
+	// Prepare the received items (read-only operations, before the transaction)
+	type destination struct {
+		product     *Product
+		existing    *ProductInStock
+		providerID  primitive.ObjectID
+		priceAchat  float64
+		priceVente  float64
+		batchNumber string
+		expiryDate  *time.Time
+	}
+	destinations := make([]destination, len(transfer.Items))
+	items := make([]StockTransferItem, len(transfer.Items))
+	products := make(map[primitive.ObjectID]*Product) // Produit source -> produit de la boutique destination
+	newProducts := make(map[primitive.ObjectID]bool)  // Produits à créer dans la boutique destination
+	for i, item := range transfer.Items {
+		received := item.Quantity
+		reason := ""
+		if receipt, ok := receiptByItem[item.SourceProductInStockID]; ok {
+			received = receipt.ReceivedQuantity
+			reason = receipt.Reason
+			delete(receiptByItem, item.SourceProductInStockID)
+		}
+		if received < 0 {
+			return nil, utils.ValidationErrorf("Received quantity cannot be negative")
+		}
+		if received > item.Quantity {
+			return nil, utils.ValidationErrorf(
+				"Received quantity (%.2f) exceeds the shipped quantity (%.2f) for product in stock %s",
+				received,
+				item.Quantity,
+				item.SourceProductInStockID.Hex(),
+			)
+		}
+		item.ReceivedQuantity = received
+		item.Discrepancy = received - item.Quantity
+		item.DiscrepancyReason = reason
+		items[i] = item
+
+		// Prices are copied from the source store, converted when currencies differ
+		priceAchat, priceVente := item.PriceAchat, item.PriceVente
+		if item.Currency != destinationCurrency {
+			priceAchat, err = db.ConvertCurrency(transfer.CompanyID.Hex(), item.PriceAchat, item.Currency, destinationCurrency)
+			if err != nil {
+				return nil, err
+			}
+			priceVente, err = db.ConvertCurrency(transfer.CompanyID.Hex(), item.PriceVente, item.Currency, destinationCurrency)
+			if err != nil {
+				return nil, err
+			}
+		}
+		source, err := db.FindProductInStockByID(item.SourceProductInStockID.Hex())
+		if err != nil {
+			return nil, utils.NotFoundErrorf("Product in stock not found: %s", item.SourceProductInStockID.Hex())
+		}
+		product, ok := products[item.ProductID]
+		if !ok {
+			product, err = db.findTransferDestinationProduct(item.ProductID, transfer.ToStoreID)
+			if err != nil {
+				return nil, err
+			}
+			if product.ID.IsZero() {
+				product.ID = primitive.NewObjectID()
+				newProducts[product.ID] = true
+			}
+			products[item.ProductID] = product
+		}
+		var existing *ProductInStock
+		if !newProducts[product.ID] {
+			existing, err = db.findProductInStockForTransfer(product.ID, transfer.ToStoreID, destinationCurrency, source.BatchNumber, source.ExpiryDate)
+			if err != nil {
+				return nil, err
+			}
+		}
+		destinations[i] = destination{
+			product:     product,
+			existing:    existing,
+			providerID:  source.ProviderID,
+			priceAchat:  priceAchat,
+			priceVente:  priceVente,
+			batchNumber: source.BatchNumber,
+			expiryDate:  source.ExpiryDate,
+		}
+	}
+	for productInStockID := range receiptByItem {
+		return nil, utils.ValidationErrorf("Product in stock %s is not part of this transfer", productInStockID.Hex())
+	}
+
+	// Start MongoDB transaction
+	session, err := db.client.StartSession()
+	if err != nil {
+		return nil, utils.DatabaseErrorf("start_session", "Error starting transaction session: %v", err)
+	}
+	defer session.EndSession(context.Background())
+
+	// Transaction context with longer timeout for multiple operations
+	txCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var movements []*StockMovement
+	err = mongo.WithSession(txCtx, session, func(sc mongo.SessionContext) error {
+		// Start transaction
+		if err := session.StartTransaction(); err != nil {
+			return utils.DatabaseErrorf("start_transaction", "Error starting transaction: %v", err)
+		}
+
+		// Collections
+		transferCollection := colHelper(db, "stock_transfers")
+		productCollection := colHelper(db, "products")
+		productInStockCollection := colHelper(db, "products_in_stock")
+		stockMovementCollection := colHelper(db, "stock_movements")
+
+		// 1. Create the products missing in the destination store
+		for _, product := range products {
+			if !newProducts[product.ID] {
+				continue
+			}
+			product.CreatedAt = now
+			product.UpdatedAt = now
+			if _, err := productCollection.InsertOne(sc, product); err != nil {
+				return utils.DatabaseErrorf("create_product", "Error creating product %s in destination store: %v", product.Name, err)
+			}
+		}
+
+		// 2. Put the received goods in the destination store
+		// (rows created here are reused when several source rows carry the same product and batch)
+		created := make(map[string]primitive.ObjectID)
+		for i := range items {
+			item := &items[i]
+			dest := destinations[i]
+			item.DestinationProductID = &dest.product.ID
+			lotKey := dest.product.ID.Hex() + "|" + dest.batchNumber
+			if dest.expiryDate != nil {
+				lotKey += "|" + dest.expiryDate.Format(time.RFC3339)
+			}
+
+			var destinationID primitive.ObjectID
+			if dest.existing != nil || created[lotKey] != primitive.NilObjectID {
+				if dest.existing != nil {
+					destinationID = dest.existing.ID
+				} else {
+					destinationID = created[lotKey]
+				}
+				_, err := productInStockCollection.UpdateOne(
+					sc,
+					bson.M{"_id": destinationID},
+					bson.M{
+						"$inc": bson.M{"stock": item.ReceivedQuantity},
+						"$set": bson.M{"updatedAt": now},
+					},
+				)
+				if err != nil {
+					return utils.DatabaseErrorf("update_product_stock", "Error updating product in stock %s: %v", destinationID.Hex(), err)
+				}
+			} else {
+				productInStock := ProductInStock{
+					ID:          primitive.NewObjectID(),
+					ProductID:   dest.product.ID,
+					PriceVente:  dest.priceVente,
+					PriceAchat:  dest.priceAchat,
+					Currency:    destinationCurrency,
+					Stock:       item.ReceivedQuantity,
+					StoreID:     transfer.ToStoreID,
+					ProviderID:  dest.providerID, // Garde la trace du fournisseur d'origine
+					ExpiryDate:  dest.expiryDate,
+					BatchNumber: dest.batchNumber,
+					CreatedAt:   now,
+					UpdatedAt:   now,
+				}
+				_, err := productInStockCollection.InsertOne(sc, productInStock)
+				if err != nil {
+					return utils.DatabaseErrorf("create_product_in_stock", "Error creating product in stock: %v", err)
+				}
+				destinationID = productInStock.ID
+				created[lotKey] = productInStock.ID
+			}
+			item.DestinationProductInStockID = &destinationID
+
+			// Missing quantity (breakage, loss...): AJUSTEMENT of the destination store
+			if item.Discrepancy < 0 {
+				reason := fmt.Sprintf("Écart transfert #%s", transfer.ID.Hex())
+				if item.DiscrepancyReason != "" {
+					reason += ": " + item.DiscrepancyReason
+				}
+				adjustment := StockMovement{
+					ID:            primitive.NewObjectID(),
+					ProductID:     dest.product.ID,
+					StoreID:       transfer.ToStoreID,
+					Type:          StockMovementTypeAjustement,
+					Quantity:      -item.Discrepancy,
+					UnitPrice:     dest.priceAchat,
+					TotalValue:    -item.Discrepancy * dest.priceAchat,
+					Currency:      destinationCurrency,
+					Reason:        reason,
+					Reference:     fmt.Sprintf("transfer-%s", transfer.ID.Hex()),
+					ReferenceType: "TRANSFER",
+					ReferenceID:   &transfer.ID,
+					OperatorID:    operatorID,
+					CreatedAt:     now,
+					UpdatedAt:     now,
+				}
+				if _, err := stockMovementCollection.InsertOne(sc, adjustment); err != nil {
+					return utils.DatabaseErrorf("create_stock_movement", "Error creating adjustment movement for product %s: %v", dest.product.ID.Hex(), err)
+				}
+				movements = append(movements, &adjustment)
+			}
+
+			if item.ReceivedQuantity == 0 {
+				continue
+			}
+
+			movement := StockMovement{
+				ID:            primitive.NewObjectID(),
+				ProductID:     dest.product.ID,
+				StoreID:       transfer.ToStoreID,
+				Type:          StockMovementTypeEntree,
+				Quantity:      item.ReceivedQuantity,
+				UnitPrice:     dest.priceAchat,
+				TotalValue:    item.ReceivedQuantity * dest.priceAchat,
+				Currency:      destinationCurrency,
+				Reason:        fmt.Sprintf("Transfert #%s depuis boutique %s", transfer.ID.Hex(), transfer.FromStoreID.Hex()),
+				Reference:     fmt.Sprintf("transfer-%s", transfer.ID.Hex()),
+				ReferenceType: "TRANSFER",
+				ReferenceID:   &transfer.ID,
+				OperatorID:    operatorID,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			}
+
+			_, err = stockMovementCollection.InsertOne(sc, movement)
+			if err != nil {
+				return utils.DatabaseErrorf("create_stock_movement", "Error creating stock movement for product %s: %v", item.ProductID.Hex(), err)
+			}
+			movements = append(movements, &movement)
+		}
+
+		// 3. Close the transfer with the received quantities (the status guard protects against a double receipt)
+		result, err := transferCollection.UpdateOne(
+			sc,
+			bson.M{"_id": transfer.ID, "status": StockTransferStatusShipped},
+			bson.M{"$set": bson.M{
+				"status":     StockTransferStatusReceived,
+				"items":      items,
+				"receivedBy": operatorID,
+				"receivedAt": now,
+				"updatedAt":  now,
+			}},
+		)
+		if err != nil {
+			return utils.DatabaseErrorf("receive_stock_transfer", "Error receiving stock transfer: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return utils.ValidationErrorf("Transfer has already been received")
+		}
+
+		// Commit transaction
+		if err := session.CommitTransaction(sc); err != nil {
+			return utils.DatabaseErrorf("commit_transaction", "Error committing transaction: %v", err)
+		}
+
+		return nil
+	})
+
+	// Handle transaction errors
+	// MongoDB automatically aborts the transaction if an error occurs in WithSession
+	if err != nil {
+		return nil, err
+	}
+
+	// Flag the received products for the low stock job (best effort, the receipt is already recorded)
+	receivedProductIDs := make([]primitive.ObjectID, 0, len(destinations))
+	for _, dest := range destinations {
+		receivedProductIDs = append(receivedProductIDs, dest.product.ID)
+	}
+	if err := db.MarkProductsForStockCheck(receivedProductIDs); err != nil {
+		utils.LogError(err, fmt.Sprintf("Failed to flag products of transfer %s for stock check", transfer.ID.Hex()))
+	}
+
+	// Notify the subscriptions (dashboards) now that the receipt is committed
+	db.publishCommitted(nil, movements)
+
+	return db.GetStockTransferByID(transferID)
+}
+
+// CancelStockTransfer cancels a transfer that has not been shipped yet
+func (db *DB) CancelStockTransfer(transferID string) (*StockTransfer, error) {
+	transfer, err := db.GetStockTransferByID(transferID)
+	if err != nil {
+		return nil, err
+	}
+	if transfer.Status != StockTransferStatusDraft {
+		return nil, utils.ValidationErrorf("Only a draft transfer can be cancelled (current status: %s)", transfer.Status)
+	}
+
+	transferCollection := colHelper(db, "stock_transfers")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	result, err := transferCollection.UpdateOne(
+		ctx,
+		bson.M{"_id": transfer.ID, "status": StockTransferStatusDraft},
+		bson.M{"$set": bson.M{
+			"status":      StockTransferStatusCancelled,
+			"cancelledAt": now,
+			"updatedAt":   now,
+		}},
+	)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("cancel_stock_transfer", "Error cancelling stock transfer: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, utils.ValidationErrorf("Transfer has already been shipped or cancelled")
+	}
+
+	return db.GetStockTransferByID(transferID)
+}
+
+// GetStockTransferByID retrieves a stock transfer by ID
+func (db *DB) GetStockTransferByID(transferID string) (*StockTransfer, error) {
+	objectID, err := primitive.ObjectIDFromHex(transferID)
+	if err != nil {
+		return nil, utils.ValidationErrorf("Invalid stock transfer ID")
+	}
+
+	transferCollection := colHelper(db, "stock_transfers")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var transfer StockTransfer
+	err = transferCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&transfer)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, utils.NotFoundErrorf("Stock transfer not found")
+		}
+		return nil, utils.DatabaseErrorf("find_stock_transfer", "Error finding stock transfer: %v", err)
+	}
+
+	return &transfer, nil
+}
+
+// GetStockTransfersByStoreIDs returns the transfers leaving or entering the given stores
+func (db *DB) GetStockTransfersByStoreIDs(storeIDs []primitive.ObjectID, status *string) ([]*StockTransfer, error) {
+	transferCollection := colHelper(db, "stock_transfers")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := bson.M{"$or": []bson.M{
+		{"fromStoreId": bson.M{"$in": storeIDs}},
+		{"toStoreId": bson.M{"$in": storeIDs}},
+	}}
+	if status != nil {
+		filter["status"] = *status
+	}
+
+	cursor, err := transferCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": -1}))
+	if err != nil {
+		return nil, utils.DatabaseErrorf("find_stock_transfers", "Error finding stock transfers: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	var transfers []*StockTransfer
+	if err = cursor.All(ctx, &transfers); err != nil {
+		return nil, utils.DatabaseErrorf("decode_stock_transfers", "Error decoding stock transfers: %v", err)
+	}
+
+	return transfers, nil
+}
+
+// findProductInStockForTransfer finds the destination row (same batch and expiry) receiving a transferred product
+func (db *DB) findProductInStockForTransfer(productID, storeID primitive.ObjectID, currency, batchNumber string, expiryDate *time.Time) (*ProductInStock, error) {
+	productInStockCollection := colHelper(db, "products_in_stock")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	filter := lotFilter(batchNumber, expiryDate)
+	filter["productId"] = productID
+	filter["storeId"] = storeID
+	filter["currency"] = currency
+
+	var productInStock ProductInStock
+	err := productInStockCollection.FindOne(ctx, filter).Decode(&productInStock)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		return nil, utils.DatabaseErrorf("find_product_in_stock", "Error finding product in stock: %v", err)
+	}
+
+	return &productInStock, nil
+}
+
+// findTransferDestinationProduct finds the product of the destination store matching a transferred product:
+// same barcode, same SKU, then same name and mark. When none matches, it returns a copy of the source
+// product for the destination store, with a zero ID (to create)
+func (db *DB) findTransferDestinationProduct(sourceProductID, storeID primitive.ObjectID) (*Product, error) {
+	source, err := db.FindProductByID(sourceProductID.Hex())
+	if err != nil {
+		return nil, utils.NotFoundErrorf("Product not found: %s", sourceProductID.Hex())
+	}
+
+	productCollection := colHelper(db, "products")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	var filters []bson.M
+	if source.Barcode != "" {
+		filters = append(filters, bson.M{"barcode": source.Barcode})
+	}
+	if source.SKU != "" {
+		filters = append(filters, bson.M{"sku": source.SKU})
+	}
+	filters = append(filters, bson.M{"name": source.Name, "mark": source.Mark})
+
+	for _, filter := range filters {
+		filter["storeId"] = storeID
+		filter["deletedAt"] = nil
+
+		var product Product
+		err := productCollection.FindOne(ctx, filter).Decode(&product)
+		if err == nil {
+			return &product, nil
+		}
+		if err != mongo.ErrNoDocuments {
+			return nil, utils.DatabaseErrorf("find_product", "Error finding product in destination store: %v", err)
+		}
+	}
+
+	return &Product{
+		Name:            source.Name,
+		Mark:            source.Mark,
+		Barcode:         source.Barcode,
+		SKU:             source.SKU,
+		StoreID:         storeID,
+		ReorderPoint:    source.ReorderPoint,
+		ReorderQuantity: source.ReorderQuantity,
+	}, nil
+}
@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Les transferts utilisent des transactions: TEST_MONGO_URI doit pointer vers un replica set
+
+// setupTransferTestDB connects to the test database and drops the collections used by the transfers at the end
+func setupTransferTestDB(t *testing.T) *DB {
+	db := setupTestDB(t)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		for _, name := range []string{"stores", "products", "products_in_stock", "stock_movements", "stock_transfers"} {
+			if err := db.database.Collection(name).Drop(ctx); err != nil {
+				t.Logf("Warning: Failed to drop test collection %s: %v", name, err)
+			}
+		}
+		if err := db.client.Disconnect(ctx); err != nil {
+			t.Logf("Warning: Failed to disconnect test client: %v", err)
+		}
+	})
+	return db
+}
+
+// createTransferTestStore inserts a USD store of the company
+func createTransferTestStore(t *testing.T, db *DB, companyID primitive.ObjectID, name string) *Store {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	store := &Store{
+		ID:                  primitive.NewObjectID(),
+		Name:                name,
+		CompanyID:           companyID,
+		DefaultCurrency:     "USD",
+		SupportedCurrencies: []string{"USD"},
+		CreatedAt:           time.Now(),
+		UpdatedAt:           time.Now(),
+	}
+	_, err := db.database.Collection("stores").InsertOne(ctx, store)
+	require.NoError(t, err, "Failed to insert test store")
+	return store
+}
+
+// createTransferTestProduct inserts a product of the store, with stock when quantity > 0
+func createTransferTestProduct(t *testing.T, db *DB, storeID primitive.ObjectID, barcode string, quantity float64) (*Product, *ProductInStock) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	product := &Product{
+		ID:        primitive.NewObjectID(),
+		Name:      "Savon",
+		Mark:      "Omo",
+		Barcode:   barcode,
+		StoreID:   storeID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	_, err := db.database.Collection("products").InsertOne(ctx, product)
+	require.NoError(t, err, "Failed to insert test product")
+
+	if quantity == 0 {
+		return product, nil
+	}
+	productInStock := &ProductInStock{
+		ID:         primitive.NewObjectID(),
+		ProductID:  product.ID,
+		PriceAchat: 2,
+		PriceVente: 3,
+		Currency:   "USD",
+		Stock:      quantity,
+		StoreID:    storeID,
+		CreatedAt:  time.Now(),
+		UpdatedAt:  time.Now(),
+	}
+	_, err = db.database.Collection("products_in_stock").InsertOne(ctx, productInStock)
+	require.NoError(t, err, "Failed to insert test product in stock")
+	return product, productInStock
+}
+
+// findTransferTestMovements returns the movements of the transfer in a store
+func findTransferTestMovements(t *testing.T, db *DB, transferID, storeID primitive.ObjectID) map[string]float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := db.database.Collection("stock_movements").Find(ctx, bson.M{"referenceId": transferID, "storeId": storeID})
+	require.NoError(t, err)
+	var movements []StockMovement
+	require.NoError(t, cursor.All(ctx, &movements))
+
+	quantities := make(map[string]float64)
+	for _, movement := range movements {
+		quantities[movement.Type] += movement.Quantity
+	}
+	return quantities
+}
+
+func TestShipAndReceiveStockTransfer(t *testing.T) {
+	db := setupTransferTestDB(t)
+	companyID := primitive.NewObjectID()
+	operatorID := primitive.NewObjectID()
+	from := createTransferTestStore(t, db, companyID, "Gombe")
+	to := createTransferTestStore(t, db, companyID, "Limete")
+	product, source := createTransferTestProduct(t, db, from.ID, "6001234567890", 10)
+
+	transfer, err := db.CreateStockTransfer(from.ID, to.ID, []StockTransferItem{{SourceProductInStockID: source.ID, Quantity: 4}}, "", operatorID)
+	require.NoError(t, err)
+
+	// Expédition: le stock sort de la boutique source
+	shipped, err := db.ShipStockTransfer(transfer.ID.Hex(), operatorID)
+	require.NoError(t, err)
+	assert.Equal(t, StockTransferStatusShipped, shipped.Status)
+	updatedSource, err := db.FindProductInStockByID(source.ID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, 6.0, updatedSource.Stock)
+	assert.Equal(t, 4.0, findTransferTestMovements(t, db, transfer.ID, from.ID)[StockMovementTypeSortie])
+
+	_, err = db.ShipStockTransfer(transfer.ID.Hex(), operatorID)
+	assert.Error(t, err, "A transfer cannot be shipped twice")
+
+	// Réception partielle: le produit est créé dans la boutique destination
+	received, err := db.ReceiveStockTransfer(transfer.ID.Hex(), []StockTransferReceipt{
+		{SourceProductInStockID: source.ID, ReceivedQuantity: 3, Reason: "casse"},
+	}, operatorID)
+	require.NoError(t, err)
+	assert.Equal(t, StockTransferStatusReceived, received.Status)
+
+	item := received.Items[0]
+	assert.Equal(t, -1.0, item.Discrepancy)
+	require.NotNil(t, item.DestinationProductID)
+	require.NotNil(t, item.DestinationProductInStockID)
+	assert.NotEqual(t, product.ID, *item.DestinationProductID)
+
+	destinationProduct, err := db.FindProductByCode(to.ID, product.Barcode)
+	require.NoError(t, err)
+	assert.Equal(t, *item.DestinationProductID, destinationProduct.ID)
+
+	destination, err := db.FindProductInStockByID(item.DestinationProductInStockID.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, destinationProduct.ID, destination.ProductID)
+	assert.Equal(t, to.ID, destination.StoreID)
+	assert.Equal(t, 3.0, destination.Stock)
+
+	movements := findTransferTestMovements(t, db, transfer.ID, to.ID)
+	assert.Equal(t, 3.0, movements[StockMovementTypeEntree])
+	assert.Equal(t, 1.0, movements[StockMovementTypeAjustement])
+
+	_, err = db.ReceiveStockTransfer(transfer.ID.Hex(), nil, operatorID)
+	assert.Error(t, err, "A transfer cannot be received twice")
+}
+
+func TestReceiveStockTransferMatchesDestinationProduct(t *testing.T) {
+	db := setupTransferTestDB(t)
+	companyID := primitive.NewObjectID()
+	operatorID := primitive.NewObjectID()
+	from := createTransferTestStore(t, db, companyID, "Gombe")
+	to := createTransferTestStore(t, db, companyID, "Limete")
+	_, source := createTransferTestProduct(t, db, from.ID, "6001234567890", 10)
+	existing, _ := createTransferTestProduct(t, db, to.ID, "6001234567890", 0)
+
+	transfer, err := db.CreateStockTransfer(from.ID, to.ID, []StockTransferItem{{SourceProductInStockID: source.ID, Quantity: 5}}, "", operatorID)
+	require.NoError(t, err)
+	_, err = db.ShipStockTransfer(transfer.ID.Hex(), operatorID)
+	require.NoError(t, err)
+
+	_, err = db.ReceiveStockTransfer(transfer.ID.Hex(), []StockTransferReceipt{
+		{SourceProductInStockID: source.ID, ReceivedQuantity: 5},
+		{SourceProductInStockID: source.ID, ReceivedQuantity: 2},
+	}, operatorID)
+	assert.Error(t, err, "Duplicate receipts are refused")
+
+	received, err := db.ReceiveStockTransfer(transfer.ID.Hex(), nil, operatorID)
+	require.NoError(t, err)
+
+	item := received.Items[0]
+	require.NotNil(t, item.DestinationProductID)
+	assert.Equal(t, existing.ID, *item.DestinationProductID)
+	assert.Equal(t, 0.0, item.Discrepancy)
+	assert.Zero(t, findTransferTestMovements(t, db, transfer.ID, to.ID)[StockMovementTypeAjustement])
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	count, err := db.database.Collection("products").CountDocuments(ctx, bson.M{"storeId": to.ID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count, "The product of the destination store is reused")
+}
@@ -60,6 +60,8 @@ func createTestProduct(t *testing.T, db *DB, storeID primitive.ObjectID, name, m
 	product, err := db.CreateProduct(
 		name,
 		mark,
+		"",
+		"",
 		storeID,
 	)
 	require.NoError(t, err, "Should create test product")
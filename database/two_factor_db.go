@@ -0,0 +1,280 @@
+package database
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+	"time"
+
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Recovery codes: single-use codes replacing the authenticator app when it is lost
+const (
+	RecoveryCodeCount    = 10
+	recoveryCodeLength   = 10 // Affiché en deux groupes: xxxxx-xxxxx
+	recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+)
+
+// TwoFactor is the TOTP (RFC 6238) configuration of a user
+type TwoFactor struct {
+	Enabled       bool       `bson:"enabled" json:"enabled"`
+	Secret        string     `bson:"secret,omitempty" json:"-"`        // Secret base32 actif, chiffré (TOTP_ENCRYPTION_KEY)
+	PendingSecret string     `bson:"pendingSecret,omitempty" json:"-"` // Secret en attente du premier code, chiffré
+	RecoveryCodes []string   `bson:"recoveryCodes,omitempty" json:"-"` // Hash bcrypt des codes de secours restants
+	LastStep      int64      `bson:"lastStep" json:"-"`                // Dernier pas de temps accepté: un code ne sert qu'une fois
+	EnabledAt     *time.Time `bson:"enabledAt,omitempty" json:"enabledAt,omitempty"`
+}
+
+// TwoFactorEnabled tells whether the user logs in with a TOTP code
+func (u *User) TwoFactorEnabled() bool {
+	return u.TwoFactor != nil && u.TwoFactor.Enabled
+}
+
+// generateRecoveryCodes returns new recovery codes and their hashes
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	for i := range codes {
+		var code strings.Builder
+		for j := 0; j < recoveryCodeLength; j++ {
+			if j == recoveryCodeLength/2 {
+				code.WriteByte('-')
+			}
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			code.WriteByte(recoveryCodeAlphabet[n.Int64()])
+		}
+		hash, err := utils.HashPassword(normalizeRecoveryCode(code.String()))
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i], hashes[i] = code.String(), hash
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode ignores case, spaces and dashes of a typed recovery code
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// isTOTPCode tells whether a code is a TOTP code (digits) rather than a recovery code
+func isTOTPCode(code string) bool {
+	code = strings.TrimSpace(code)
+	if len(code) != utils.TOTPDigits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// storedTOTPSecret returns the secret to store: the stored value when it is already encrypted,
+// the encrypted secret otherwise (and the stored value if encryption fails, it is retried next time)
+func storedTOTPSecret(stored, secret string) string {
+	if utils.IsEncryptedTOTPSecret(stored) {
+		return stored
+	}
+	encrypted, err := utils.EncryptTOTPSecret(secret)
+	if err != nil {
+		utils.LogError(err, "Failed to encrypt TOTP secret")
+		return stored
+	}
+	return encrypted
+}
+
+// StartTwoFactorSetup generates the secret of a new enrolment; it is only enabled by ConfirmTwoFactorSetup
+func (db *DB) StartTwoFactorSetup(user *User) (string, error) {
+	if user.TwoFactorEnabled() {
+		return "", utils.ValidationErrorf("Two-factor authentication is already enabled")
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return "", utils.DatabaseErrorf("generate_totp_secret", "Error generating secret: %v", err)
+	}
+	encrypted, err := utils.EncryptTOTPSecret(secret)
+	if err != nil {
+		return "", utils.DatabaseErrorf("encrypt_totp_secret", "Error encrypting secret: %v", err)
+	}
+
+	userCollection := colHelper(db, "users")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+		"twoFactor": TwoFactor{PendingSecret: encrypted},
+		"updatedAt": time.Now(),
+	}})
+	if err != nil {
+		return "", utils.DatabaseErrorf("start_two_factor_setup", "Error starting two-factor setup: %v", err)
+	}
+
+	return secret, nil
+}
+
+// ConfirmTwoFactorSetup enables two-factor authentication with a first code of the app
+// and returns the recovery codes (shown only once)
+func (db *DB) ConfirmTwoFactorSetup(user *User, code string) ([]string, error) {
+	if user.TwoFactorEnabled() {
+		return nil, utils.ValidationErrorf("Two-factor authentication is already enabled")
+	}
+	if user.TwoFactor == nil || user.TwoFactor.PendingSecret == "" {
+		return nil, utils.ValidationErrorf("No two-factor setup in progress")
+	}
+
+	pendingSecret := user.TwoFactor.PendingSecret
+	secret, err := utils.DecryptTOTPSecret(pendingSecret)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("decrypt_totp_secret", "Error reading secret: %v", err)
+	}
+	step, ok := utils.ValidateTOTP(secret, code, time.Now())
+	if !ok {
+		return nil, utils.ValidationErrorf("Invalid two-factor code")
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, utils.DatabaseErrorf("generate_recovery_codes", "Error generating recovery codes: %v", err)
+	}
+
+	userCollection := colHelper(db, "users")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	now := time.Now()
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": user.ID, "twoFactor.pendingSecret": pendingSecret},
+		bson.M{"$set": bson.M{
+			"twoFactor": TwoFactor{
+				Enabled:       true,
+				Secret:        storedTOTPSecret(pendingSecret, secret),
+				RecoveryCodes: hashes,
+				LastStep:      step,
+				EnabledAt:     &now,
+			},
+			"updatedAt": now,
+		}},
+	)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("confirm_two_factor_setup", "Error enabling two-factor authentication: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, utils.ValidationErrorf("No two-factor setup in progress")
+	}
+
+	return codes, nil
+}
+
+// VerifyTwoFactorCode checks a TOTP code (used once) or consumes a recovery code
+func (db *DB) VerifyTwoFactorCode(user *User, code string) error {
+	if !user.TwoFactorEnabled() {
+		return utils.ValidationErrorf("Two-factor authentication is not enabled")
+	}
+
+	userCollection := colHelper(db, "users")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	if isTOTPCode(code) {
+		secret, err := utils.DecryptTOTPSecret(user.TwoFactor.Secret)
+		if err != nil {
+			return utils.DatabaseErrorf("decrypt_totp_secret", "Error reading secret: %v", err)
+		}
+		step, ok := utils.ValidateTOTP(secret, code, time.Now())
+		if !ok {
+			return utils.ValidationErrorf("Invalid two-factor code")
+		}
+		// Atomic: of two requests with the same code, only one moves lastStep forward.
+		// A secret stored in clear before encryption is encrypted on the way
+		update := bson.M{"twoFactor.lastStep": step}
+		if stored := storedTOTPSecret(user.TwoFactor.Secret, secret); stored != user.TwoFactor.Secret {
+			update["twoFactor.secret"] = stored
+		}
+		result, err := userCollection.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "twoFactor.lastStep": bson.M{"$lt": step}},
+			bson.M{"$set": update},
+		)
+		if err != nil {
+			return utils.DatabaseErrorf("verify_two_factor_code", "Error verifying two-factor code: %v", err)
+		}
+		if result.MatchedCount == 0 {
+			return utils.ValidationErrorf("Two-factor code already used")
+		}
+		return nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	for _, hash := range user.TwoFactor.RecoveryCodes {
+		if !utils.ComparePassword(normalized, hash) {
+			continue
+		}
+		result, err := userCollection.UpdateOne(ctx,
+			bson.M{"_id": user.ID, "twoFactor.recoveryCodes": hash},
+			bson.M{"$pull": bson.M{"twoFactor.recoveryCodes": hash}},
+		)
+		if err != nil {
+			return utils.DatabaseErrorf("use_recovery_code", "Error using recovery code: %v", err)
+		}
+		if result.ModifiedCount == 0 {
+			return utils.ValidationErrorf("Recovery code already used")
+		}
+		utils.Info("Recovery code used by user %s (%d left)", user.ID.Hex(), len(user.TwoFactor.RecoveryCodes)-1)
+		return nil
+	}
+
+	return utils.ValidationErrorf("Invalid two-factor code")
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of the user
+func (db *DB) RegenerateRecoveryCodes(userID primitive.ObjectID) ([]string, error) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, utils.DatabaseErrorf("generate_recovery_codes", "Error generating recovery codes: %v", err)
+	}
+
+	userCollection := colHelper(db, "users")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	result, err := userCollection.UpdateOne(ctx,
+		bson.M{"_id": userID, "twoFactor.enabled": true},
+		bson.M{"$set": bson.M{"twoFactor.recoveryCodes": hashes, "updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return nil, utils.DatabaseErrorf("regenerate_recovery_codes", "Error saving recovery codes: %v", err)
+	}
+	if result.MatchedCount == 0 {
+		return nil, utils.ValidationErrorf("Two-factor authentication is not enabled")
+	}
+
+	return codes, nil
+}
+
+// DisableTwoFactor removes the TOTP configuration of the user
+func (db *DB) DisableTwoFactor(userID primitive.ObjectID) error {
+	userCollection := colHelper(db, "users")
+	ctx, cancel := GetDBContext()
+	defer cancel()
+
+	_, err := userCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{
+		"$unset": bson.M{"twoFactor": ""},
+		"$set":   bson.M{"updatedAt": time.Now()},
+	})
+	if err != nil {
+		return utils.DatabaseErrorf("disable_two_factor", "Error disabling two-factor authentication: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package database
+
+import (
+	"strings"
+	"testing"
+
+	"rangoapp/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestGenerateRecoveryCodes vérifie le format des codes de secours et leur hash
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	assert.NoError(t, err)
+	assert.Len(t, codes, RecoveryCodeCount)
+	assert.Len(t, hashes, RecoveryCodeCount)
+
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		assert.Len(t, code, recoveryCodeLength+1)
+		assert.Equal(t, byte('-'), code[recoveryCodeLength/2])
+		assert.False(t, seen[code], "Recovery codes must be unique")
+		seen[code] = true
+
+		// Saisi en majuscules et sans tiret, le code reste valide
+		typed := strings.ToUpper(strings.Replace(code, "-", "", 1))
+		assert.True(t, utils.ComparePassword(normalizeRecoveryCode(typed), hashes[i]))
+	}
+}
+
+func TestIsTOTPCode(t *testing.T) {
+	assert.True(t, isTOTPCode("123456"))
+	assert.True(t, isTOTPCode(" 123456"))
+	assert.False(t, isTOTPCode("12345"))
+	assert.False(t, isTOTPCode("abcde-23456"))
+	assert.False(t, isTOTPCode("12345a"))
+}
+
+func TestUserTwoFactorEnabled(t *testing.T) {
+	assert.False(t, (&User{}).TwoFactorEnabled())
+	assert.False(t, (&User{TwoFactor: &TwoFactor{PendingSecret: "ABC"}}).TwoFactorEnabled())
+	assert.True(t, (&User{TwoFactor: &TwoFactor{Enabled: true}}).TwoFactorEnabled())
+}
@@ -18,13 +18,17 @@ type User struct {
 	UID             string               `bson:"uid" json:"uid"`
 	Name            string               `bson:"name" json:"name"`
 	Phone           string               `bson:"phone" json:"phone"`
+	PhoneVerified   bool                 `bson:"phoneVerified" json:"phoneVerified"` // Vérifié par code SMS à l'inscription
 	Email           *string              `bson:"email,omitempty" json:"email,omitempty"`
 	Password        string               `bson:"password" json:"-"`
 	Role            string               `bson:"role" json:"role"` // "Admin" or "User"
 	IsBlocked       bool                 `bson:"isBlocked" json:"isBlocked"`
+	LockedUntil     *time.Time           `bson:"lockedUntil,omitempty" json:"lockedUntil,omitempty"` // Connexion verrouillée après trop d'échecs (levé par unblockUser)
 	CompanyID       primitive.ObjectID   `bson:"companyId" json:"companyId"`
 	StoreIDs        []primitive.ObjectID `bson:"storeIds" json:"storeIds"`
 	AssignedStoreID *primitive.ObjectID  `bson:"assignedStoreId,omitempty" json:"assignedStoreId,omitempty"`
+	RoleID          *primitive.ObjectID  `bson:"roleId,omitempty" json:"roleId,omitempty"` // Rôle de l'entreprise (User uniquement), sinon rôle intégré
+	TwoFactor       *TwoFactor           `bson:"twoFactor,omitempty" json:"-"`             // Authentification à deux facteurs (TOTP)
 	CreatedAt       time.Time            `bson:"createdAt" json:"createdAt"`
 	UpdatedAt       time.Time            `bson:"updatedAt" json:"updatedAt"`
 }
@@ -70,6 +74,12 @@ func (db *DB) CreateUser(name, phone, email, password, role string, companyID pr
 		return nil, gqlerror.Errorf("Error creating user: %v", err)
 	}
 
+	if !companyID.IsZero() {
+		if err := db.AddMembership(membershipFromUser(&user)); err != nil {
+			return nil, err
+		}
+	}
+
 	return &user, nil
 }
 
@@ -112,17 +122,32 @@ func (db *DB) FindUserByPhone(phone string) (*User, error) {
 	return &user, nil
 }
 
+// FindUsersByCompanyID returns the members of the company with the role and stores of their membership
 func (db *DB) FindUsersByCompanyID(companyID string) ([]*User, error) {
 	objectID, err := primitive.ObjectIDFromHex(companyID)
 	if err != nil {
 		return nil, gqlerror.Errorf("Invalid company ID")
 	}
 
+	memberships, err := db.findCompanyMemberships(objectID)
+	if err != nil {
+		return nil, err
+	}
+	memberIDs := make([]primitive.ObjectID, 0, len(memberships))
+	for userID := range memberships {
+		memberIDs = append(memberIDs, userID)
+	}
+
 	userCollection := colHelper(db, "users")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	cursor, err := userCollection.Find(ctx, bson.M{"companyId": objectID})
+	// Users created before memberships only have the companyId of the user document
+	filter := bson.M{"$or": []bson.M{
+		{"_id": bson.M{"$in": memberIDs}},
+		{"companyId": objectID},
+	}}
+	cursor, err := userCollection.Find(ctx, filter)
 	if err != nil {
 		return nil, gqlerror.Errorf("Error finding users: %v", err)
 	}
@@ -133,13 +158,20 @@ func (db *DB) FindUsersByCompanyID(companyID string) ([]*User, error) {
 		return nil, gqlerror.Errorf("Error decoding users: %v", err)
 	}
 
+	for _, user := range users {
+		if m, ok := memberships[user.ID]; ok {
+			user.applyMembership(m)
+		}
+	}
+
 	return users, nil
 }
 
-func (db *DB) UpdateUser(id string, name, phone, email, role *string, assignedStoreID *primitive.ObjectID) (*User, error) {
-	objectID, err := primitive.ObjectIDFromHex(id)
+// UpdateUser updates the profile of a member and their role and store in the company
+func (db *DB) UpdateUser(id string, companyID primitive.ObjectID, name, phone, email, role *string, assignedStoreID *primitive.ObjectID) (*User, error) {
+	user, err := db.FindUserInCompany(id, companyID)
 	if err != nil {
-		return nil, gqlerror.Errorf("Invalid user ID")
+		return nil, err
 	}
 
 	userCollection := colHelper(db, "users")
@@ -156,23 +188,32 @@ func (db *DB) UpdateUser(id string, name, phone, email, role *string, assignedSt
 	if email != nil {
 		update["email"] = *email
 	}
-	if role != nil {
-		update["role"] = *role
-	}
-	if assignedStoreID != nil {
-		update["assignedStoreId"] = *assignedStoreID
-	}
 
-	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": update})
+	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": update})
 	if err != nil {
 		return nil, gqlerror.Errorf("Error updating user: %v", err)
 	}
 
-	return db.FindUserByID(id)
+	// Role and store belong to the membership in this company
+	if role != nil || assignedStoreID != nil {
+		m := membershipFromUser(user)
+		if role != nil {
+			m.Role = *role
+		}
+		if assignedStoreID != nil {
+			m.AssignedStoreID = assignedStoreID
+		}
+		if err := db.updateMembership(m); err != nil {
+			return nil, err
+		}
+	}
+
+	return db.FindUserInCompany(id, companyID)
 }
 
-func (db *DB) AssignUserToStore(userID string, storeID primitive.ObjectID) (*User, error) {
-	user, err := db.FindUserByID(userID)
+// AssignUserToStore assigns a "User" member of the company to one of its stores
+func (db *DB) AssignUserToStore(userID string, companyID, storeID primitive.ObjectID) (*User, error) {
+	user, err := db.FindUserInCompany(userID, companyID)
 	if err != nil {
 		return nil, err
 	}
@@ -196,44 +237,30 @@ func (db *DB) AssignUserToStore(userID string, storeID primitive.ObjectID) (*Use
 	user.StoreIDs = []primitive.ObjectID{storeID}
 	user.UpdatedAt = time.Now()
 
-	userCollection := colHelper(db, "users")
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
-		"assignedStoreId": storeID,
-		"storeIds":        []primitive.ObjectID{storeID},
-		"updatedAt":       time.Now(),
-	}})
-	if err != nil {
-		return nil, gqlerror.Errorf("Error assigning user to store: %v", err)
+	if err := db.updateMembership(membershipFromUser(user)); err != nil {
+		return nil, err
 	}
 
 	return user, nil
 }
 
-func (db *DB) UpdateUserStoreIDs(userID string, storeIDs []primitive.ObjectID) error {
-	objectID, err := primitive.ObjectIDFromHex(userID)
+// UpdateUserStoreIDs replaces the stores of a member of the company
+func (db *DB) UpdateUserStoreIDs(userID string, companyID primitive.ObjectID, storeIDs []primitive.ObjectID) error {
+	user, err := db.FindUserInCompany(userID, companyID)
 	if err != nil {
-		return gqlerror.Errorf("Invalid user ID")
+		return err
 	}
 
-	userCollection := colHelper(db, "users")
-	ctx, cancel := GetDBContext()
-	defer cancel()
-
-	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{"$set": bson.M{
-		"storeIds":  storeIDs,
-		"updatedAt": time.Now(),
-	}})
-	if err != nil {
-		return gqlerror.Errorf("Error updating user store IDs: %v", err)
+	user.StoreIDs = storeIDs
+	if err := db.updateMembership(membershipFromUser(user)); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-// UpdateUserCompanyID updates the company ID for a user
+// UpdateUserCompanyID makes the company the active one of the user and adds them to it with their
+// current role (createCompany: the creator becomes Admin of the company)
 func (db *DB) UpdateUserCompanyID(userID string, companyID primitive.ObjectID) error {
 	objectID, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
@@ -252,7 +279,11 @@ func (db *DB) UpdateUserCompanyID(userID string, companyID primitive.ObjectID) e
 		return gqlerror.Errorf("Error updating user company ID: %v", err)
 	}
 
-	return nil
+	user, err := db.FindUserByID(userID)
+	if err != nil {
+		return err
+	}
+	return db.ensureMembership(user)
 }
 
 func (db *DB) BlockUser(id string) (*User, error) {
@@ -287,15 +318,23 @@ func (db *DB) UnblockUser(id string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
-		"isBlocked": false,
-		"updatedAt": time.Now(),
-	}})
+	// Also lifts the lockout started by failed logins
+	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{
+		"$set": bson.M{
+			"isBlocked": false,
+			"updatedAt": time.Now(),
+		},
+		"$unset": bson.M{"lockedUntil": ""},
+	})
 	if err != nil {
 		return nil, gqlerror.Errorf("Error unblocking user: %v", err)
 	}
+	if err := db.ResetLoginFailures(user.Phone); err != nil {
+		return nil, err
+	}
 
 	user.IsBlocked = false
+	user.LockedUntil = nil
 	return user, nil
 }
 
@@ -314,6 +353,12 @@ func (db *DB) DeleteUser(id string) error {
 		return gqlerror.Errorf("Error deleting user: %v", err)
 	}
 
+	membershipCollection := colHelper(db, "memberships")
+	_, err = membershipCollection.DeleteMany(ctx, bson.M{"userId": objectID})
+	if err != nil {
+		return gqlerror.Errorf("Error deleting user memberships: %v", err)
+	}
+
 	return nil
 }
 
@@ -351,6 +396,11 @@ func (db *DB) ChangePassword(userID string, currentPassword, newPassword string)
 		return gqlerror.Errorf("Current password is incorrect")
 	}
 
+	return db.SetPassword(user.ID, newPassword)
+}
+
+// SetPassword replaces the password of a user (changePassword, resetPassword)
+func (db *DB) SetPassword(userID primitive.ObjectID, newPassword string) error {
 	// Hash new password
 	hashedPassword, err := utils.HashPassword(newPassword)
 	if err != nil {
@@ -362,7 +412,7 @@ func (db *DB) ChangePassword(userID string, currentPassword, newPassword string)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{
+	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{
 		"password":  hashedPassword,
 		"updatedAt": time.Now(),
 	}})
@@ -372,3 +422,20 @@ func (db *DB) ChangePassword(userID string, currentPassword, newPassword string)
 
 	return nil
 }
+
+// MarkPhoneVerified records that the user proved owning its phone number with an SMS code
+func (db *DB) MarkPhoneVerified(userID primitive.ObjectID) error {
+	userCollection := colHelper(db, "users")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := userCollection.UpdateOne(ctx, bson.M{"_id": userID}, bson.M{"$set": bson.M{
+		"phoneVerified": true,
+		"updatedAt":     time.Now(),
+	}})
+	if err != nil {
+		return gqlerror.Errorf("Error updating user: %v", err)
+	}
+
+	return nil
+}
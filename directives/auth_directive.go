@@ -3,19 +3,58 @@ package directives
 import (
 	"context"
 
+	"rangoapp/database"
 	"rangoapp/middlewares"
+	"rangoapp/utils"
 
 	"github.com/99designs/gqlgen/graphql"
 	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
-func Auth(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error) {
-	tokenData := middlewares.CtxValue(ctx)
-	if tokenData == nil {
-		return nil, &gqlerror.Error{
-			Message: "Access Denied",
+// AuthDirective is the @auth(permission: String) directive
+type AuthDirective func(ctx context.Context, obj interface{}, next graphql.Resolver, permission *string) (interface{}, error)
+
+// NewAuth returns the @auth directive: the request must be authenticated and, when a permission
+// is given, the role of the user must grant it (see database.GetUserPermissions).
+// An API key only runs the mutations guarded by a permission it was given
+func NewAuth(db *database.DB) AuthDirective {
+	return func(ctx context.Context, obj interface{}, next graphql.Resolver, permission *string) (interface{}, error) {
+		tokenData := middlewares.CtxValue(ctx)
+		if tokenData == nil {
+			return nil, &gqlerror.Error{
+				Message: "Access Denied",
+			}
+		}
+
+		if tokenData.APIKeyID != "" && (permission == nil || *permission == "") && isMutation(ctx) {
+			return nil, &gqlerror.Error{
+				Message:    "Access Denied: not available to API keys",
+				Extensions: map[string]interface{}{"code": "FORBIDDEN"},
+			}
 		}
+
+		if permission != nil && *permission != "" {
+			permissions, err := middlewares.UserPermissions(ctx, db.GetMemberPermissions)
+			if err != nil {
+				utils.LogError(err, "Failed to load user permissions")
+				return nil, &gqlerror.Error{
+					Message: "Access Denied",
+				}
+			}
+			if !permissions[*permission] {
+				return nil, &gqlerror.Error{
+					Message:    "Access Denied: missing permission " + *permission,
+					Extensions: map[string]interface{}{"code": "FORBIDDEN", "permission": *permission},
+				}
+			}
+		}
+
+		return next(ctx)
 	}
+}
 
-	return next(ctx)
+// isMutation reports whether the directive guards a field of the Mutation type
+func isMutation(ctx context.Context) bool {
+	fc := graphql.GetFieldContext(ctx)
+	return fc != nil && fc.Object == "Mutation"
 }
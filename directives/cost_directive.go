@@ -0,0 +1,54 @@
+package directives
+
+import (
+	"context"
+
+	"rangoapp/database"
+	"rangoapp/graph/model"
+	"rangoapp/middlewares"
+	"rangoapp/utils"
+
+	"github.com/99designs/gqlgen/graphql"
+)
+
+// CostDirective is the @cost directive of the purchase price and margin fields
+type CostDirective func(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error)
+
+// saleValuedMovements are the stock movements valued at the sale price (see database.StockMovement)
+var saleValuedMovements = map[string]bool{"SALE": true, "SALE_CANCEL": true, "SALE_RETURN": true, "INVENTORY": true}
+
+// isCost reports whether the @cost field of obj holds a cost: the amounts of the caisse transactions
+// and the values of the stock movements only do for the purchases
+func isCost(obj interface{}) bool {
+	switch o := obj.(type) {
+	case *model.CaisseTransaction:
+		return o.Purchase
+	case *model.StockMovement:
+		return o.ReferenceType == nil || !saleValuedMovements[*o.ReferenceType]
+	}
+	return true
+}
+
+// NewCost returns the @cost directive: the field is null unless the role of the user grants
+// price.viewCost (Admin, or a company role such as Manager or Accountant)
+func NewCost(db *database.DB) CostDirective {
+	return func(ctx context.Context, obj interface{}, next graphql.Resolver) (interface{}, error) {
+		if !isCost(obj) {
+			return next(ctx)
+		}
+		if middlewares.CtxValue(ctx) == nil {
+			return nil, nil
+		}
+
+		permissions, err := middlewares.UserPermissions(ctx, db.GetMemberPermissions)
+		if err != nil {
+			utils.LogError(err, "Failed to load user permissions")
+			return nil, nil
+		}
+		if !permissions[database.PermissionPriceViewCost] {
+			return nil, nil
+		}
+
+		return next(ctx)
+	}
+}
@@ -0,0 +1,53 @@
+package directives
+
+import (
+	"context"
+	"testing"
+
+	"rangoapp/graph/model"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestIsCost vérifie les champs @cost masqués: prix d'achat et marges, sauf les montants valorisés au prix de vente
+func TestIsCost(t *testing.T) {
+	referenceType := func(value string) *string { return &value }
+
+	tests := []struct {
+		name string
+		obj  interface{}
+		cost bool
+	}{
+		{"Product in stock", &model.ProductInStock{}, true},
+		{"Sales stats", &model.SalesStats{}, true},
+		{"Provider debt", &model.ProviderDebt{}, true},
+		{"Caisse purchase", &model.CaisseTransaction{Purchase: true}, true},
+		{"Caisse sale", &model.CaisseTransaction{}, false},
+		{"Movement without reference", &model.StockMovement{}, true},
+		{"Supply movement", &model.StockMovement{ReferenceType: referenceType("SUPPLY")}, true},
+		{"Transfer movement", &model.StockMovement{ReferenceType: referenceType("TRANSFER")}, true},
+		{"Sale movement", &model.StockMovement{ReferenceType: referenceType("SALE")}, false},
+		{"Sale cancel movement", &model.StockMovement{ReferenceType: referenceType("SALE_CANCEL")}, false},
+		{"Sale return movement", &model.StockMovement{ReferenceType: referenceType("SALE_RETURN")}, false},
+		{"Inventory movement", &model.StockMovement{ReferenceType: referenceType("INVENTORY")}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.cost, isCost(tt.obj))
+		})
+	}
+}
+
+// TestNewCost vérifie qu'un coût est masqué sans utilisateur et qu'un montant de vente reste visible
+func TestNewCost(t *testing.T) {
+	cost := NewCost(nil)
+	next := func(ctx context.Context) (interface{}, error) { return 12.5, nil }
+
+	value, err := cost(context.Background(), &model.CaisseTransaction{Purchase: true}, next)
+	assert.NoError(t, err)
+	assert.Nil(t, value, "A purchase is hidden without an authenticated user")
+
+	value, err = cost(context.Background(), &model.CaisseTransaction{}, next)
+	assert.NoError(t, err)
+	assert.Equal(t, 12.5, value)
+}
@@ -0,0 +1,92 @@
+package events
+
+import (
+	"sync"
+)
+
+// Event types published by the database layer
+const (
+	SaleCreated       = "sale.created"        // Payload: *database.Sale
+	CaisseUpdated     = "caisse.updated"      // Payload: *database.Trans
+	StockLevelChanged = "stock.level_changed" // Payload: *database.StockMovement
+)
+
+// subscriberBuffer is the number of events a slow subscriber can lag behind before missing events
+const subscriberBuffer = 32
+
+// Event is a change notified to the GraphQL subscriptions
+type Event struct {
+	Type     string
+	StoreID  string
+	Currency string // Devise de la caisse (événements caisse uniquement)
+	Payload  interface{}
+}
+
+// Bus is an in-process publish/subscribe bus.
+// Events are only delivered inside this instance: with several replicas, a client only
+// receives the changes made through the replica it is connected to.
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
+
+type subscriber struct {
+	eventType string
+	storeID   string
+	ch        chan Event
+}
+
+// NewBus creates an empty event bus
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*subscriber]struct{})}
+}
+
+// Publish delivers the event to the subscribers of its type and store.
+// It never blocks the caller: a subscriber whose buffer is full misses the event.
+func (b *Bus) Publish(event Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers {
+		if sub.eventType != event.Type || sub.storeID != event.StoreID {
+			continue
+		}
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
+
+// Subscribe returns the events of the given type for the store and a function to unsubscribe.
+// The channel is closed once unsubscribed.
+func (b *Bus) Subscribe(eventType, storeID string) (<-chan Event, func()) {
+	sub := &subscriber{
+		eventType: eventType,
+		storeID:   storeID,
+		ch:        make(chan Event, subscriberBuffer),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, sub)
+			b.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+
+	return sub.ch, unsubscribe
+}
+
+// SubscriberCount returns the number of active subscriptions
+func (b *Bus) SubscriberCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
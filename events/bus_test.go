@@ -0,0 +1,56 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBus_DeliversToMatchingSubscribers(t *testing.T) {
+	bus := NewBus()
+	sales, unsubscribeSales := bus.Subscribe(SaleCreated, "store1")
+	defer unsubscribeSales()
+	otherStore, unsubscribeOther := bus.Subscribe(SaleCreated, "store2")
+	defer unsubscribeOther()
+	caisse, unsubscribeCaisse := bus.Subscribe(CaisseUpdated, "store1")
+	defer unsubscribeCaisse()
+
+	bus.Publish(Event{Type: SaleCreated, StoreID: "store1", Payload: "sale"})
+
+	select {
+	case event := <-sales:
+		assert.Equal(t, "sale", event.Payload)
+	default:
+		t.Fatal("Subscriber of the store should receive the event")
+	}
+	assert.Len(t, otherStore, 0, "Other stores should not receive the event")
+	assert.Len(t, caisse, 0, "Other event types should not be received")
+}
+
+func TestBus_PublishNeverBlocks(t *testing.T) {
+	bus := NewBus()
+	ch, unsubscribe := bus.Subscribe(StockLevelChanged, "store1")
+	defer unsubscribe()
+
+	for i := 0; i < subscriberBuffer+10; i++ {
+		bus.Publish(Event{Type: StockLevelChanged, StoreID: "store1"})
+	}
+
+	assert.Len(t, ch, subscriberBuffer, "Events beyond the buffer are dropped")
+}
+
+func TestBus_Unsubscribe(t *testing.T) {
+	bus := NewBus()
+	ch, unsubscribe := bus.Subscribe(SaleCreated, "store1")
+	assert.Equal(t, 1, bus.SubscriberCount())
+
+	unsubscribe()
+	unsubscribe() // Idempotent
+
+	assert.Equal(t, 0, bus.SubscriberCount())
+	_, open := <-ch
+	assert.False(t, open, "Channel should be closed after unsubscribe")
+
+	// Publishing after unsubscribe must not panic
+	bus.Publish(Event{Type: SaleCreated, StoreID: "store1"})
+}
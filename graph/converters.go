@@ -3,6 +3,7 @@ package graph
 import (
 	"rangoapp/database"
 	"rangoapp/graph/model"
+	"rangoapp/services"
 	"rangoapp/utils"
 	"time"
 
@@ -11,6 +12,22 @@ import (
 
 // Convert database types to GraphQL model types
 
+// convertAuthResponseToGraphQL converts the result of a login step: the tokens, or the two-factor challenge
+func convertAuthResponseToGraphQL(response *services.AuthResponse) *model.AuthResponse {
+	if response == nil {
+		return nil
+	}
+
+	return &model.AuthResponse{
+		AccessToken:            optionalString(response.AccessToken),
+		RefreshToken:           optionalString(response.RefreshToken),
+		User:                   convertUserToGraphQL(response.User),
+		TwoFactorRequired:      response.TwoFactorRequired,
+		TwoFactorSetupRequired: response.TwoFactorSetupRequired,
+		ChallengeToken:         optionalString(response.ChallengeToken),
+	}
+}
+
 func convertUserToGraphQL(dbUser *database.User) *model.User {
 	if dbUser == nil {
 		return nil
@@ -25,18 +42,31 @@ func convertUserToGraphQL(dbUser *database.User) *model.User {
 		id := dbUser.AssignedStoreID.Hex()
 		assignedStoreID = &id
 	}
+	var lockedUntil *string
+	if dbUser.LockedUntil != nil && dbUser.LockedUntil.After(time.Now()) {
+		lockedUntil = optionalTime(dbUser.LockedUntil)
+	}
+	var roleID *string
+	if dbUser.RoleID != nil {
+		id := dbUser.RoleID.Hex()
+		roleID = &id
+	}
 	return &model.User{
-		ID:              dbUser.ID.Hex(),
-		UID:             dbUser.UID,
-		Name:            dbUser.Name,
-		Phone:           dbUser.Phone,
-		Role:            dbUser.Role,
-		IsBlocked:       dbUser.IsBlocked,
-		CompanyID:       companyID,
-		StoreIds:        storeIDs,
-		AssignedStoreID: assignedStoreID,
-		CreatedAt:       dbUser.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:       dbUser.UpdatedAt.Format(time.RFC3339),
+		ID:               dbUser.ID.Hex(),
+		UID:              dbUser.UID,
+		Name:             dbUser.Name,
+		Phone:            dbUser.Phone,
+		PhoneVerified:    dbUser.PhoneVerified,
+		TwoFactorEnabled: dbUser.TwoFactorEnabled(),
+		Role:             dbUser.Role,
+		IsBlocked:        dbUser.IsBlocked,
+		LockedUntil:      lockedUntil,
+		CompanyID:        companyID,
+		StoreIds:         storeIDs,
+		AssignedStoreID:  assignedStoreID,
+		RoleID:           roleID,
+		CreatedAt:        dbUser.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:        dbUser.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
@@ -82,23 +112,24 @@ func convertCompanyToGraphQL(dbCompany *database.Company, db *database.DB, loadS
 	}
 
 	return &model.Company{
-		ID:            dbCompany.ID.Hex(),
-		Name:          dbCompany.Name,
-		Address:       dbCompany.Address,
-		Phone:         dbCompany.Phone,
-		Email:         dbCompany.Email,
-		Description:   dbCompany.Description,
-		Type:          dbCompany.Type,
-		Logo:          dbCompany.Logo,
-		Rccm:          dbCompany.Rccm,
-		IDNat:         dbCompany.IDNat,
-		IDCommerce:    dbCompany.IDCommerce,
-		LicenseID:     dbCompany.LicenseID,
-		Stores:        storeModels,
-		Subscription:  subscriptionModel,
-		ExchangeRates: exchangeRateModels,
-		CreatedAt:     dbCompany.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:     dbCompany.UpdatedAt.Format(time.RFC3339),
+		ID:                    dbCompany.ID.Hex(),
+		Name:                  dbCompany.Name,
+		Address:               dbCompany.Address,
+		Phone:                 dbCompany.Phone,
+		Email:                 dbCompany.Email,
+		Description:           dbCompany.Description,
+		Type:                  dbCompany.Type,
+		Logo:                  dbCompany.Logo,
+		Rccm:                  dbCompany.Rccm,
+		IDNat:                 dbCompany.IDNat,
+		IDCommerce:            dbCompany.IDCommerce,
+		LicenseID:             dbCompany.LicenseID,
+		Stores:                storeModels,
+		Subscription:          subscriptionModel,
+		ExchangeRates:         exchangeRateModels,
+		RequireAdminTwoFactor: dbCompany.RequireAdminTwoFactor,
+		CreatedAt:             dbCompany.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:             dbCompany.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
@@ -112,7 +143,7 @@ func convertSubscriptionToGraphQL(dbSubscription *database.Subscription) *model.
 	now := time.Now()
 	endDate := dbSubscription.TrialEndDate
 	isTrialExpired := now.After(endDate)
-	
+
 	var daysRemaining int
 	if !isTrialExpired && now.Before(endDate) {
 		diff := endDate.Sub(now)
@@ -221,14 +252,26 @@ func convertProductToGraphQL(dbProduct *database.Product, db *database.DB) *mode
 		store = nil // Continue with nil, GraphQL will handle it
 	}
 
+	var barcode, sku *string
+	if dbProduct.Barcode != "" {
+		barcode = &dbProduct.Barcode
+	}
+	if dbProduct.SKU != "" {
+		sku = &dbProduct.SKU
+	}
+
 	return &model.Product{
-		ID:        dbProduct.ID.Hex(),
-		Name:      dbProduct.Name,
-		Mark:      dbProduct.Mark,
-		StoreID:   dbProduct.StoreID.Hex(),
-		Store:     convertStoreToGraphQL(store, db, true),
-		CreatedAt: dbProduct.CreatedAt.Format(time.RFC3339),
-		UpdatedAt: dbProduct.UpdatedAt.Format(time.RFC3339),
+		ID:              dbProduct.ID.Hex(),
+		Name:            dbProduct.Name,
+		Mark:            dbProduct.Mark,
+		Barcode:         barcode,
+		Sku:             sku,
+		ReorderPoint:    dbProduct.ReorderPoint,
+		ReorderQuantity: dbProduct.ReorderQuantity,
+		StoreID:         dbProduct.StoreID.Hex(),
+		Store:           convertStoreToGraphQL(store, db, true),
+		CreatedAt:       dbProduct.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       dbProduct.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
@@ -259,19 +302,23 @@ func convertProductInStockToGraphQL(dbProductInStock *database.ProductInStock, d
 	}
 
 	return &model.ProductInStock{
-		ID:         dbProductInStock.ID.Hex(),
-		ProductID:  dbProductInStock.ProductID.Hex(),
-		Product:    convertProductToGraphQL(product, db),
-		PriceVente: dbProductInStock.PriceVente,
-		PriceAchat: dbProductInStock.PriceAchat,
-		Currency:   dbProductInStock.Currency,
-		Stock:      dbProductInStock.Stock,
-		StoreID:    dbProductInStock.StoreID.Hex(),
-		Store:      convertStoreToGraphQL(store, db, true),
-		ProviderID: dbProductInStock.ProviderID.Hex(),
-		Provider:   convertProviderToGraphQL(provider, db),
-		CreatedAt:  dbProductInStock.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:  dbProductInStock.UpdatedAt.Format(time.RFC3339),
+		ID:              dbProductInStock.ID.Hex(),
+		ProductID:       dbProductInStock.ProductID.Hex(),
+		Product:         convertProductToGraphQL(product, db),
+		PriceVente:      dbProductInStock.PriceVente,
+		PriceAchat:      costValue(dbProductInStock.PriceAchat),
+		Currency:        dbProductInStock.Currency,
+		Stock:           dbProductInStock.Stock,
+		StoreID:         dbProductInStock.StoreID.Hex(),
+		Store:           convertStoreToGraphQL(store, db, true),
+		ProviderID:      dbProductInStock.ProviderID.Hex(),
+		Provider:        convertProviderToGraphQL(provider, db),
+		BatchNumber:     optionalString(dbProductInStock.BatchNumber),
+		ExpiryDate:      optionalTime(dbProductInStock.ExpiryDate),
+		WriteOffExpired: dbProductInStock.WriteOffExpired,
+		WrittenOffAt:    optionalTime(dbProductInStock.WrittenOffAt),
+		CreatedAt:       dbProductInStock.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       dbProductInStock.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
@@ -437,17 +484,27 @@ func convertCaisseTransactionToGraphQL(dbTrans *database.Trans, db *database.DB)
 
 	storeGraphQL := convertStoreToGraphQL(store, db, false)
 
+	// Transactions recorded before payment methods are cash
+	paymentMethod := dbTrans.PaymentMethod
+	if paymentMethod == "" {
+		paymentMethod = database.PaymentMethodCash
+	}
+
 	return &model.CaisseTransaction{
-		ID:          dbTrans.ID.Hex(),
-		Amount:      dbTrans.Amount,
-		Operation:   dbTrans.Operation,
-		Description: dbTrans.Description,
-		Currency:    dbTrans.Currency,
-		StoreID:     dbTrans.StoreID.Hex(),
-		Store:       storeGraphQL,
-		Date:        dbTrans.Date.Format(time.RFC3339),
-		CreatedAt:   dbTrans.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:   dbTrans.UpdatedAt.Format(time.RFC3339),
+		ID:            dbTrans.ID.Hex(),
+		Amount:        costValue(dbTrans.Amount),
+		Operation:     dbTrans.Operation,
+		Purchase:      dbTrans.Purchase,
+		Description:   dbTrans.Description,
+		Currency:      dbTrans.Currency,
+		PaymentMethod: paymentMethod,
+		Reference:     optionalString(dbTrans.Reference),
+		SaleID:        optionalObjectIDHex(dbTrans.SaleID),
+		StoreID:       dbTrans.StoreID.Hex(),
+		Store:         storeGraphQL,
+		Date:          dbTrans.Date.Format(time.RFC3339),
+		CreatedAt:     dbTrans.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:     dbTrans.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
@@ -460,7 +517,7 @@ func convertCaisseResumeJourToGraphQL(dbResume *database.CaisseResumeJour) *mode
 		Date:               dbResume.Date.Format(time.RFC3339),
 		Entrees:            dbResume.Entrees,
 		Sorties:            dbResume.Sorties,
-		Benefice:           dbResume.Benefice,
+		Benefice:           costValue(dbResume.Benefice),
 		Solde:              dbResume.Solde,
 		NombreTransactions: dbResume.NombreTransactions,
 	}
@@ -510,12 +567,13 @@ func convertCaisseRapportToGraphQL(dbRapport *database.CaisseRapport, db *databa
 		EndDate:            dbRapport.EndDate.Format(time.RFC3339),
 		TotalEntrees:       dbRapport.TotalEntrees,
 		TotalSorties:       dbRapport.TotalSorties,
-		TotalBenefice:      dbRapport.TotalBenefice,
+		TotalBenefice:      costValue(dbRapport.TotalBenefice),
 		SoldeInitial:       dbRapport.SoldeInitial,
 		SoldeFinal:         dbRapport.SoldeFinal,
 		NombreTransactions: dbRapport.NombreTransactions,
 		Transactions:       transactions,
 		ResumeParJour:      resumeParJour,
+		ParMethode:         convertCaissePaymentMethodTotalsToGraphQL(dbRapport.ParMethode),
 	}
 }
 
@@ -539,7 +597,7 @@ func convertCaisseToGraphQL(dbCaisse *database.Caisse, db *database.DB) *model.C
 		CurrentBalance: dbCaisse.CurrentBalance,
 		In:             dbCaisse.In,
 		Out:            dbCaisse.Out,
-		TotalBenefice:  dbCaisse.TotalBenefice,
+		TotalBenefice:  costValue(dbCaisse.TotalBenefice),
 		Currency:       dbCaisse.Currency,
 		StoreID:        storeID,
 		Store:          storeGraphQL,
@@ -560,11 +618,19 @@ func convertSaleToGraphQL(dbSale *database.Sale, db *database.DB) *model.Sale {
 			utils.LogError(err, "Failed to load product in stock for sale")
 			continue
 		}
+		var productID *string
+		if item.ProductID != nil {
+			id := item.ProductID.Hex()
+			productID = &id
+		}
 		saleProducts = append(saleProducts, &model.SaleProduct{
 			ProductInStockID: item.ProductInStockID.Hex(),
 			ProductInStock:   convertProductInStockToGraphQL(productInStock, db),
 			Quantity:         item.Quantity,
 			Price:            item.Price,
+			ProductID:        productID,
+			Discount:         item.Discount,
+			PromotionIds:     objectIDsHex(item.PromotionIDs),
 		})
 	}
 
@@ -596,15 +662,17 @@ func convertSaleToGraphQL(dbSale *database.Sale, db *database.DB) *model.Sale {
 	// Calculate change
 	change := dbSale.PricePayed - dbSale.PriceToPay
 
-	// Calculate benefice: sum of (price - priceAchat) * quantity for each product
+	// Calculate benefice: sum of (price - priceAchat) * quantity - discount for each product
 	var benefice float64
 	for _, item := range dbSale.Basket {
 		productInStock, err := db.FindProductInStockByID(item.ProductInStockID.Hex())
 		if err == nil {
-			// Benefice = (prix de vente - prix d'achat) * quantité
-			benefice += (item.Price - productInStock.PriceAchat) * item.Quantity
+			// Benefice = (prix de vente - prix d'achat) * quantité - remise des promotions
+			benefice += (item.Price-productInStock.PriceAchat)*item.Quantity - item.Discount
 		}
 	}
+	// Returned products give their margin back
+	benefice -= dbSale.ReturnedBenefice
 
 	var clientModel *model.Client
 	if client != nil {
@@ -636,26 +704,43 @@ func convertSaleToGraphQL(dbSale *database.Sale, db *database.DB) *model.Sale {
 		debtStatus = "none"
 	}
 
+	var cancelledAt, cancelReason *string
+	if dbSale.CancelledAt != nil {
+		cancelledAtStr := dbSale.CancelledAt.Format(time.RFC3339)
+		cancelledAt = &cancelledAtStr
+		reason := dbSale.CancelReason
+		cancelReason = &reason
+	}
+
 	return &model.Sale{
-		ID:          dbSale.ID.Hex(),
-		Basket:      saleProducts,
-		PriceToPay:  dbSale.PriceToPay,
-		PricePayed:  dbSale.PricePayed,
-		Change:      change,
-		Benefice:    benefice,
-		Currency:    dbSale.Currency,
-		Client:      clientModel, // Can be nil for walk-in sales
-		Operator:    convertUserToGraphQL(operator),
-		StoreID:     dbSale.StoreID.Hex(),
-		Store:       convertStoreToGraphQL(store, db, true),
-		PaymentType: paymentType,
-		AmountDue:   dbSale.AmountDue,
-		DebtStatus:  debtStatus,
-		DebtID:      debtID,
-		Debt:        debtModel,
-		Date:        dbSale.Date.Format(time.RFC3339),
-		CreatedAt:   dbSale.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:   dbSale.UpdatedAt.Format(time.RFC3339),
+		ID:             dbSale.ID.Hex(),
+		Basket:         saleProducts,
+		PriceToPay:     dbSale.PriceToPay,
+		PricePayed:     dbSale.PricePayed,
+		Change:         change,
+		Benefice:       costValue(benefice),
+		Currency:       dbSale.Currency,
+		Client:         clientModel, // Can be nil for walk-in sales
+		Operator:       convertUserToGraphQL(operator),
+		StoreID:        dbSale.StoreID.Hex(),
+		Store:          convertStoreToGraphQL(store, db, true),
+		PaymentType:    paymentType,
+		AmountDue:      dbSale.AmountDue,
+		DebtStatus:     debtStatus,
+		DebtID:         debtID,
+		Debt:           debtModel,
+		CancelledAt:    cancelledAt,
+		CancelReason:   cancelReason,
+		ReturnedAmount: dbSale.ReturnedAmount,
+		Discount:       dbSale.Discount,
+		Promotions:     convertAppliedPromotionsToGraphQL(dbSale.Promotions),
+		Payments:       convertSalePaymentsToGraphQL(dbSale.Payments),
+		ExchangeRates:  convertSaleExchangeRatesToGraphQL(dbSale.ExchangeRates),
+		ChangeCurrency: optionalString(dbSale.ChangeCurrency),
+		ChangeAmount:   dbSale.ChangeAmount,
+		Date:           dbSale.Date.Format(time.RFC3339),
+		CreatedAt:      dbSale.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:      dbSale.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
@@ -706,21 +791,96 @@ func convertSaleListToGraphQL(dbSale *database.Sale, db *database.DB) *model.Sal
 		debtStatus = "none"
 	}
 
+	var cancelledAt *string
+	if dbSale.CancelledAt != nil {
+		cancelledAtStr := dbSale.CancelledAt.Format(time.RFC3339)
+		cancelledAt = &cancelledAtStr
+	}
+
 	return &model.SaleList{
-		ID:          dbSale.ID.Hex(),
-		Date:        dbSale.Date.Format(time.RFC3339),
-		CreatedAt:   dbSale.CreatedAt.Format(time.RFC3339),
-		PriceToPay:  dbSale.PriceToPay,
-		PricePayed:  dbSale.PricePayed,
-		Change:      change,
-		Currency:    dbSale.Currency,
-		Client:      clientModel, // Can be nil for walk-in sales
-		BasketCount: basketCount,
-		TotalItems:  totalItems,
-		StoreID:     dbSale.StoreID.Hex(),
-		PaymentType: paymentType,
-		AmountDue:   dbSale.AmountDue,
-		DebtStatus:  debtStatus,
+		ID:             dbSale.ID.Hex(),
+		Date:           dbSale.Date.Format(time.RFC3339),
+		CreatedAt:      dbSale.CreatedAt.Format(time.RFC3339),
+		PriceToPay:     dbSale.PriceToPay,
+		PricePayed:     dbSale.PricePayed,
+		Change:         change,
+		Currency:       dbSale.Currency,
+		Client:         clientModel, // Can be nil for walk-in sales
+		BasketCount:    basketCount,
+		TotalItems:     totalItems,
+		StoreID:        dbSale.StoreID.Hex(),
+		PaymentType:    paymentType,
+		AmountDue:      dbSale.AmountDue,
+		DebtStatus:     debtStatus,
+		CancelledAt:    cancelledAt,
+		ReturnedAmount: dbSale.ReturnedAmount,
+		Discount:       dbSale.Discount,
+	}
+}
+
+// convertSaleReturnToGraphQL converts a database SaleReturn to a GraphQL SaleReturn
+func convertSaleReturnToGraphQL(dbReturn *database.SaleReturn, db *database.DB) *model.SaleReturn {
+	if dbReturn == nil {
+		return nil
+	}
+
+	// Convert returned items
+	var items []*model.SaleReturnItem
+	for _, item := range dbReturn.Items {
+		productInStock, err := db.FindProductInStockByID(item.ProductInStockID.Hex())
+		if err != nil {
+			utils.LogError(err, "Failed to load product in stock for sale return")
+			continue
+		}
+		items = append(items, &model.SaleReturnItem{
+			ProductInStockID: item.ProductInStockID.Hex(),
+			ProductInStock:   convertProductInStockToGraphQL(productInStock, db),
+			Quantity:         item.Quantity,
+			Price:            item.Price,
+		})
+	}
+
+	// Load original sale
+	sale, err := db.FindSaleByID(dbReturn.SaleID.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load sale for sale return")
+		sale = nil
+	}
+
+	// Load operator (user)
+	operator, err := db.FindUserByID(dbReturn.OperatorID.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load operator for sale return")
+		operator = nil
+	}
+
+	// Load store
+	store, err := db.FindStoreByID(dbReturn.StoreID.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load store for sale return")
+		store = nil
+	}
+
+	var reason *string
+	if dbReturn.Reason != "" {
+		reason = &dbReturn.Reason
+	}
+
+	return &model.SaleReturn{
+		ID:           dbReturn.ID.Hex(),
+		SaleID:       dbReturn.SaleID.Hex(),
+		Sale:         convertSaleToGraphQL(sale, db),
+		Items:        items,
+		TotalAmount:  dbReturn.TotalAmount,
+		Benefice:     costValue(dbReturn.Benefice),
+		Currency:     dbReturn.Currency,
+		RefundMethod: dbReturn.RefundMethod,
+		Reason:       reason,
+		OperatorID:   dbReturn.OperatorID.Hex(),
+		Operator:     convertUserToGraphQL(operator),
+		StoreID:      dbReturn.StoreID.Hex(),
+		Store:        convertStoreToGraphQL(store, db, true),
+		CreatedAt:    dbReturn.CreatedAt.Format(time.RFC3339),
 	}
 }
 
@@ -817,18 +977,33 @@ func convertDebtPaymentToGraphQL(dbPayment *database.DebtPayment, db *database.D
 		store = nil
 	}
 
+	var voidedAt *string
+	if dbPayment.VoidedAt != nil {
+		voidedAtStr := dbPayment.VoidedAt.Format(time.RFC3339)
+		voidedAt = &voidedAtStr
+	}
+
+	// Payments recorded before mobile money payments are cash
+	paymentMethod := dbPayment.PaymentMethod
+	if paymentMethod == "" {
+		paymentMethod = database.PaymentMethodCash
+	}
+
 	return &model.DebtPayment{
-		ID:          dbPayment.ID.Hex(),
-		DebtID:      dbPayment.DebtID.Hex(),
-		Debt:        convertDebtToGraphQL(debt, db),
-		Amount:      dbPayment.Amount,
-		Currency:    dbPayment.Currency,
-		OperatorID:  dbPayment.OperatorID.Hex(),
-		Operator:    convertUserToGraphQL(operator),
-		StoreID:     dbPayment.StoreID.Hex(),
-		Store:       convertStoreToGraphQL(store, db, true),
-		Description: dbPayment.Description,
-		CreatedAt:   dbPayment.CreatedAt.Format(time.RFC3339),
+		ID:            dbPayment.ID.Hex(),
+		DebtID:        dbPayment.DebtID.Hex(),
+		Debt:          convertDebtToGraphQL(debt, db),
+		Amount:        dbPayment.Amount,
+		Currency:      dbPayment.Currency,
+		OperatorID:    dbPayment.OperatorID.Hex(),
+		Operator:      convertUserToGraphQL(operator),
+		StoreID:       dbPayment.StoreID.Hex(),
+		Store:         convertStoreToGraphQL(store, db, true),
+		Description:   dbPayment.Description,
+		PaymentMethod: paymentMethod,
+		Reference:     optionalString(dbPayment.Reference),
+		CreatedAt:     dbPayment.CreatedAt.Format(time.RFC3339),
+		VoidedAt:      voidedAt,
 	}
 }
 
@@ -995,8 +1170,8 @@ func convertStockMovementToGraphQL(dbMovement *database.StockMovement, db *datab
 		Store:         convertStoreToGraphQL(store, db, false),
 		Type:          model.StockMovementType(dbMovement.Type),
 		Quantity:      dbMovement.Quantity,
-		UnitPrice:     dbMovement.UnitPrice,
-		TotalValue:    dbMovement.TotalValue,
+		UnitPrice:     costValue(dbMovement.UnitPrice),
+		TotalValue:    costValue(dbMovement.TotalValue),
 		Currency:      dbMovement.Currency,
 		Reason:        stringPtr(dbMovement.Reason),
 		Reference:     stringPtr(dbMovement.Reference),
@@ -1040,8 +1215,8 @@ func convertStockReportToGraphQL(dbReport *database.StockReportData, db *databas
 			SoldeInitial:        prodData.SoldeInitial,
 			SoldeFinal:          prodData.SoldeFinal,
 			NombreMouvements:    prodData.NombreMouvements,
-			ValeurTotaleEntrees: prodData.ValeurTotaleEntrees,
-			ValeurTotaleSorties: prodData.ValeurTotaleSorties,
+			ValeurTotaleEntrees: costValue(prodData.ValeurTotaleEntrees),
+			ValeurTotaleSorties: costValue(prodData.ValeurTotaleSorties),
 		}
 	}
 
@@ -1055,8 +1230,8 @@ func convertStockReportToGraphQL(dbReport *database.StockReportData, db *databas
 			Ajustements:         dayData.Ajustements,
 			Solde:               dayData.Solde,
 			NombreMouvements:    dayData.NombreMouvements,
-			ValeurTotaleEntrees: dayData.ValeurTotaleEntrees,
-			ValeurTotaleSorties: dayData.ValeurTotaleSorties,
+			ValeurTotaleEntrees: costValue(dayData.ValeurTotaleEntrees),
+			ValeurTotaleSorties: costValue(dayData.ValeurTotaleSorties),
 		}
 	}
 
@@ -1183,7 +1358,7 @@ func convertStockSupplyToGraphQL(dbSupply *database.StockSupply, db *database.DB
 		ProductInStockID: dbSupply.ProductInStockID.Hex(),
 		ProductInStock:   convertProductInStockToGraphQL(productInStock, db),
 		Quantity:         dbSupply.Quantity,
-		PriceAchat:       dbSupply.PriceAchat,
+		PriceAchat:       costValue(dbSupply.PriceAchat),
 		PriceVente:       dbSupply.PriceVente,
 		Currency:         dbSupply.Currency,
 		ProviderID:       dbSupply.ProviderID.Hex(),
@@ -1195,6 +1370,8 @@ func convertStockSupplyToGraphQL(dbSupply *database.StockSupply, db *database.DB
 		PaymentType:      dbSupply.PaymentType,
 		ProviderDebtID:   providerDebtID,
 		ProviderDebt:     providerDebtModel,
+		BatchNumber:      optionalString(dbSupply.BatchNumber),
+		ExpiryDate:       optionalTime(dbSupply.ExpiryDate),
 		Date:             dbSupply.Date.Format(time.RFC3339),
 		CreatedAt:        dbSupply.CreatedAt.Format(time.RFC3339),
 		UpdatedAt:        dbSupply.UpdatedAt.Format(time.RFC3339),
@@ -1245,7 +1422,7 @@ func convertProviderDebtToGraphQL(dbDebt *database.ProviderDebt, db *database.DB
 			ID:             payment.ID.Hex(),
 			ProviderDebtID: payment.ProviderDebtID.Hex(),
 			ProviderDebt:   convertProviderDebtToGraphQL(dbDebt, db), // This will cause recursion, but GraphQL handles it
-			Amount:         payment.Amount,
+			Amount:         costValue(payment.Amount),
 			Currency:       payment.Currency,
 			OperatorID:     payment.OperatorID.Hex(),
 			Operator:       convertUserToGraphQL(operator),
@@ -1269,9 +1446,9 @@ func convertProviderDebtToGraphQL(dbDebt *database.ProviderDebt, db *database.DB
 		Provider:    convertProviderToGraphQL(provider, db),
 		StoreID:     dbDebt.StoreID.Hex(),
 		Store:       convertStoreToGraphQL(store, db, true),
-		TotalAmount: dbDebt.TotalAmount,
-		AmountPaid:  dbDebt.AmountPaid,
-		AmountDue:   dbDebt.AmountDue,
+		TotalAmount: costValue(dbDebt.TotalAmount),
+		AmountPaid:  costValue(dbDebt.AmountPaid),
+		AmountDue:   costValue(dbDebt.AmountDue),
 		Currency:    dbDebt.Currency,
 		Status:      dbDebt.Status,
 		Payments:    paymentModels,
@@ -1280,3 +1457,614 @@ func convertProviderDebtToGraphQL(dbDebt *database.ProviderDebt, db *database.DB
 		PaidAt:      paidAt,
 	}
 }
+
+// convertStockTransferToGraphQL converts a database StockTransfer to a GraphQL StockTransfer
+func convertStockTransferToGraphQL(dbTransfer *database.StockTransfer, db *database.DB) *model.StockTransfer {
+	if dbTransfer == nil {
+		return nil
+	}
+
+	// Load stores
+	fromStore, err := db.FindStoreByID(dbTransfer.FromStoreID.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load source store for stock transfer")
+		fromStore = nil
+	}
+	toStore, err := db.FindStoreByID(dbTransfer.ToStoreID.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load destination store for stock transfer")
+		toStore = nil
+	}
+
+	// Load creator
+	createdBy, err := db.FindUserByID(dbTransfer.CreatedBy.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load creator for stock transfer")
+		createdBy = nil
+	}
+
+	// Convert items
+	items := make([]*model.StockTransferItem, 0, len(dbTransfer.Items))
+	for _, item := range dbTransfer.Items {
+		productInStock, err := db.FindProductInStockByID(item.SourceProductInStockID.Hex())
+		if err != nil {
+			utils.LogError(err, "Failed to load product in stock for stock transfer")
+			productInStock = nil
+		}
+		product, err := db.FindProductByID(item.ProductID.Hex())
+		if err != nil {
+			utils.LogError(err, "Failed to load product template for stock transfer")
+			product = nil
+		}
+
+		var destinationID *string
+		if item.DestinationProductInStockID != nil {
+			id := item.DestinationProductInStockID.Hex()
+			destinationID = &id
+		}
+		var destinationProductID *string
+		if item.DestinationProductID != nil {
+			id := item.DestinationProductID.Hex()
+			destinationProductID = &id
+		}
+		var discrepancyReason *string
+		if item.DiscrepancyReason != "" {
+			reason := item.DiscrepancyReason
+			discrepancyReason = &reason
+		}
+
+		items = append(items, &model.StockTransferItem{
+			SourceProductInStockID:      item.SourceProductInStockID.Hex(),
+			SourceProductInStock:        convertProductInStockToGraphQL(productInStock, db),
+			DestinationProductInStockID: destinationID,
+			DestinationProductID:        destinationProductID,
+			ProductID:                   item.ProductID.Hex(),
+			Product:                     convertProductToGraphQL(product, db),
+			Quantity:                    item.Quantity,
+			ReceivedQuantity:            item.ReceivedQuantity,
+			Discrepancy:                 item.Discrepancy,
+			DiscrepancyReason:           discrepancyReason,
+			PriceAchat:                  costValue(item.PriceAchat),
+			Currency:                    item.Currency,
+		})
+	}
+
+	var note *string
+	if dbTransfer.Note != "" {
+		note = &dbTransfer.Note
+	}
+	formatDate := func(t *time.Time) *string {
+		if t == nil {
+			return nil
+		}
+		formatted := t.Format(time.RFC3339)
+		return &formatted
+	}
+
+	return &model.StockTransfer{
+		ID:          dbTransfer.ID.Hex(),
+		FromStoreID: dbTransfer.FromStoreID.Hex(),
+		FromStore:   convertStoreToGraphQL(fromStore, db, false),
+		ToStoreID:   dbTransfer.ToStoreID.Hex(),
+		ToStore:     convertStoreToGraphQL(toStore, db, false),
+		Status:      dbTransfer.Status,
+		Items:       items,
+		Note:        note,
+		CreatedBy:   convertUserToGraphQL(createdBy),
+		ShippedAt:   formatDate(dbTransfer.ShippedAt),
+		ReceivedAt:  formatDate(dbTransfer.ReceivedAt),
+		CancelledAt: formatDate(dbTransfer.CancelledAt),
+		CreatedAt:   dbTransfer.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   dbTransfer.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// convertPurchaseOrderToGraphQL converts a database PurchaseOrder to a GraphQL PurchaseOrder
+func convertPurchaseOrderToGraphQL(dbOrder *database.PurchaseOrder, db *database.DB) *model.PurchaseOrder {
+	if dbOrder == nil {
+		return nil
+	}
+
+	// Load store
+	store, err := db.FindStoreByID(dbOrder.StoreID.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load store for purchase order")
+		store = nil
+	}
+
+	// Load provider
+	provider, err := db.FindProviderByID(dbOrder.ProviderID.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load provider for purchase order")
+		provider = nil
+	}
+
+	// Load creator
+	createdBy, err := db.FindUserByID(dbOrder.CreatedBy.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load creator for purchase order")
+		createdBy = nil
+	}
+
+	// Convert lines
+	lines := make([]*model.PurchaseOrderLine, 0, len(dbOrder.Lines))
+	for _, line := range dbOrder.Lines {
+		product, err := db.FindProductByID(line.ProductID.Hex())
+		if err != nil {
+			utils.LogError(err, "Failed to load product template for purchase order")
+			product = nil
+		}
+
+		supplies := make([]*model.StockSupply, 0, len(line.SupplyIDs))
+		for _, supplyID := range line.SupplyIDs {
+			supply, err := db.FindStockSupplyByID(supplyID.Hex())
+			if err != nil {
+				utils.LogError(err, "Failed to load stock supply for purchase order")
+				continue
+			}
+			supplies = append(supplies, convertStockSupplyToGraphQL(supply, db))
+		}
+
+		lines = append(lines, &model.PurchaseOrderLine{
+			ProductID:         line.ProductID.Hex(),
+			Product:           convertProductToGraphQL(product, db),
+			QuantityOrdered:   line.QuantityOrdered,
+			QuantityReceived:  line.QuantityReceived,
+			BackorderQuantity: line.Backorder(),
+			PriceAchat:        costValue(line.PriceAchat),
+			PriceVente:        line.PriceVente,
+			Supplies:          supplies,
+		})
+	}
+
+	var note *string
+	if dbOrder.Note != "" {
+		note = &dbOrder.Note
+	}
+	formatDate := func(t *time.Time) *string {
+		if t == nil {
+			return nil
+		}
+		formatted := t.Format(time.RFC3339)
+		return &formatted
+	}
+
+	return &model.PurchaseOrder{
+		ID:           dbOrder.ID.Hex(),
+		StoreID:      dbOrder.StoreID.Hex(),
+		Store:        convertStoreToGraphQL(store, db, false),
+		ProviderID:   dbOrder.ProviderID.Hex(),
+		Provider:     convertProviderToGraphQL(provider, db),
+		Status:       dbOrder.Status,
+		Lines:        lines,
+		Currency:     dbOrder.Currency,
+		TotalAmount:  costValue(dbOrder.TotalAmount),
+		Note:         note,
+		ExpectedDate: formatDate(dbOrder.ExpectedDate),
+		CreatedBy:    convertUserToGraphQL(createdBy),
+		SentAt:       formatDate(dbOrder.SentAt),
+		ReceivedAt:   formatDate(dbOrder.ReceivedAt),
+		CancelledAt:  formatDate(dbOrder.CancelledAt),
+		CreatedAt:    dbOrder.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:    dbOrder.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// convertLowStockProductToGraphQL converts a database LowStockProduct to a GraphQL LowStockProduct
+func convertLowStockProductToGraphQL(dbLowStock *database.LowStockProduct, db *database.DB) *model.LowStockProduct {
+	if dbLowStock == nil || dbLowStock.Product == nil {
+		return nil
+	}
+
+	return &model.LowStockProduct{
+		ProductID:       dbLowStock.Product.ID.Hex(),
+		Product:         convertProductToGraphQL(dbLowStock.Product, db),
+		StoreID:         dbLowStock.Product.StoreID.Hex(),
+		Stock:           dbLowStock.Stock,
+		ReorderPoint:    dbLowStock.Product.ReorderPoint,
+		ReorderQuantity: dbLowStock.Product.ReorderQuantity,
+	}
+}
+
+// convertSessionToGraphQL converts a database Session to a GraphQL Session
+func convertSessionToGraphQL(dbSession *database.Session, currentSessionID string) *model.Session {
+	if dbSession == nil {
+		return nil
+	}
+
+	return &model.Session{
+		ID:         dbSession.ID.Hex(),
+		UserID:     dbSession.UserID.Hex(),
+		DeviceName: optionalString(dbSession.DeviceName),
+		IPAddress:  optionalString(dbSession.IPAddress),
+		UserAgent:  optionalString(dbSession.UserAgent),
+		Current:    dbSession.ID.Hex() == currentSessionID,
+		LastSeenAt: dbSession.LastSeenAt.Format(time.RFC3339),
+		ExpiresAt:  dbSession.ExpiresAt.Format(time.RFC3339),
+		CreatedAt:  dbSession.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// convertAPIKeyToGraphQL converts a database APIKey to a GraphQL APIKey (never the key itself)
+func convertAPIKeyToGraphQL(dbKey *database.APIKey) *model.APIKey {
+	if dbKey == nil {
+		return nil
+	}
+
+	storeIDs := make([]string, len(dbKey.StoreIDs))
+	for i, storeID := range dbKey.StoreIDs {
+		storeIDs[i] = storeID.Hex()
+	}
+
+	return &model.APIKey{
+		ID:          dbKey.ID.Hex(),
+		Name:        dbKey.Name,
+		Prefix:      dbKey.Prefix,
+		StoreIds:    storeIDs,
+		Permissions: dbKey.Permissions,
+		CreatedBy:   dbKey.CreatedBy.Hex(),
+		ExpiresAt:   optionalTime(dbKey.ExpiresAt),
+		LastUsedAt:  optionalTime(dbKey.LastUsedAt),
+		RevokedAt:   optionalTime(dbKey.RevokedAt),
+		Active:      dbKey.IsActive(time.Now()),
+		CreatedAt:   dbKey.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// convertMembershipToGraphQL converts a database Membership to a GraphQL Membership;
+// current tells whether it is the company of the request
+func convertMembershipToGraphQL(dbMembership *database.Membership, current bool, db *database.DB) *model.Membership {
+	if dbMembership == nil {
+		return nil
+	}
+
+	companyName := ""
+	company, err := db.FindCompanyByID(dbMembership.CompanyID.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load company for membership")
+	} else {
+		companyName = company.Name
+	}
+
+	storeIDs := make([]string, len(dbMembership.StoreIDs))
+	for i, storeID := range dbMembership.StoreIDs {
+		storeIDs[i] = storeID.Hex()
+	}
+
+	return &model.Membership{
+		CompanyID:       dbMembership.CompanyID.Hex(),
+		CompanyName:     companyName,
+		Role:            dbMembership.Role,
+		RoleID:          optionalObjectIDHex(dbMembership.RoleID),
+		StoreIds:        storeIDs,
+		AssignedStoreID: optionalObjectIDHex(dbMembership.AssignedStoreID),
+		Current:         current,
+		CreatedAt:       dbMembership.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// convertInvitationToGraphQL converts a database Invitation to a GraphQL Invitation
+func convertInvitationToGraphQL(dbInvitation *database.Invitation) *model.Invitation {
+	if dbInvitation == nil {
+		return nil
+	}
+
+	return &model.Invitation{
+		ID:         dbInvitation.ID.Hex(),
+		Phone:      optionalString(dbInvitation.Phone),
+		Role:       dbInvitation.Role,
+		RoleID:     optionalObjectIDHex(dbInvitation.RoleID),
+		StoreID:    optionalObjectIDHex(dbInvitation.StoreID),
+		InvitedBy:  dbInvitation.InvitedBy.Hex(),
+		Status:     dbInvitation.Status(time.Now()),
+		SmsSent:    dbInvitation.SMSSent,
+		ExpiresAt:  dbInvitation.ExpiresAt.Format(time.RFC3339),
+		AcceptedAt: optionalTime(dbInvitation.AcceptedAt),
+		AcceptedBy: optionalObjectIDHex(dbInvitation.AcceptedBy),
+		RevokedAt:  optionalTime(dbInvitation.RevokedAt),
+		CreatedAt:  dbInvitation.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// convertAuditEntryToGraphQL converts a database AuditEntry to a GraphQL AuditLogEntry
+func convertAuditEntryToGraphQL(dbEntry *database.AuditEntry) *model.AuditLogEntry {
+	if dbEntry == nil {
+		return nil
+	}
+
+	return &model.AuditLogEntry{
+		ID:        dbEntry.ID.Hex(),
+		Sequence:  int(dbEntry.Sequence),
+		Action:    dbEntry.Action,
+		ActorID:   optionalObjectIDHex(dbEntry.ActorID),
+		CompanyID: optionalObjectIDHex(dbEntry.CompanyID),
+		StoreID:   optionalObjectIDHex(dbEntry.StoreID),
+		TargetID:  optionalString(dbEntry.TargetID),
+		Details:   optionalString(dbEntry.Details),
+		Before:    optionalString(dbEntry.Before),
+		After:     optionalString(dbEntry.After),
+		Error:     optionalString(dbEntry.Error),
+		IPAddress: optionalString(dbEntry.IPAddress),
+		CreatedAt: dbEntry.CreatedAt.Format(time.RFC3339),
+		PrevHash:  dbEntry.PrevHash,
+		Hash:      dbEntry.Hash,
+	}
+}
+
+// convertReorderSuggestionGroupToGraphQL converts a database ReorderSuggestionGroup to a GraphQL ReorderSuggestionGroup
+func convertReorderSuggestionGroupToGraphQL(dbGroup *database.ReorderSuggestionGroup, storeID primitive.ObjectID, db *database.DB) *model.ReorderSuggestionGroup {
+	if dbGroup == nil {
+		return nil
+	}
+
+	var providerID *string
+	var provider *model.Provider
+	if dbGroup.ProviderID != nil {
+		id := dbGroup.ProviderID.Hex()
+		providerID = &id
+
+		dbProvider, err := db.FindProviderByID(id)
+		if err != nil {
+			utils.LogError(err, "Failed to load provider for reorder suggestion")
+		} else {
+			provider = convertProviderToGraphQL(dbProvider, db)
+		}
+	}
+
+	suggestions := make([]*model.ReorderSuggestion, 0, len(dbGroup.Suggestions))
+	for _, suggestion := range dbGroup.Suggestions {
+		suggestions = append(suggestions, &model.ReorderSuggestion{
+			ProductID:               suggestion.Product.ID.Hex(),
+			Product:                 convertProductToGraphQL(suggestion.Product, db),
+			Stock:                   suggestion.Stock,
+			AverageDailyConsumption: suggestion.AverageDailyConsumption,
+			DaysOfCover:             suggestion.DaysOfCover,
+			SuggestedQuantity:       suggestion.SuggestedQuantity,
+			PriceAchat:              costValue(suggestion.PriceAchat),
+			Currency:                optionalString(suggestion.Currency),
+		})
+	}
+
+	return &model.ReorderSuggestionGroup{
+		ProviderID:  providerID,
+		Provider:    provider,
+		StoreID:     storeID.Hex(),
+		Suggestions: suggestions,
+		TotalAmount: costValue(dbGroup.TotalAmount),
+		Currency:    dbGroup.Currency,
+	}
+}
+
+// convertStockAlertToGraphQL converts a database StockAlert to a GraphQL StockAlert
+func convertStockAlertToGraphQL(dbAlert *database.StockAlert, db *database.DB) *model.StockAlert {
+	if dbAlert == nil {
+		return nil
+	}
+
+	// Load product
+	product, err := db.FindProductByID(dbAlert.ProductID.Hex())
+	if err != nil {
+		utils.LogError(err, "Failed to load product for stock alert")
+		product = nil
+	}
+
+	return &model.StockAlert{
+		ID:              dbAlert.ID.Hex(),
+		ProductID:       dbAlert.ProductID.Hex(),
+		Product:         convertProductToGraphQL(product, db),
+		StoreID:         dbAlert.StoreID.Hex(),
+		Stock:           dbAlert.Stock,
+		ReorderPoint:    dbAlert.ReorderPoint,
+		ReorderQuantity: dbAlert.ReorderQuantity,
+		Status:          dbAlert.Status,
+		ResolvedAt:      optionalTime(dbAlert.ResolvedAt),
+		CreatedAt:       dbAlert.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// optionalString returns nil for an empty string
+func optionalString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+// costValue returns a cost or margin field, nulled by the @cost directive without price.viewCost
+func costValue(value float64) *float64 {
+	return &value
+}
+
+// optionalObjectIDHex returns the hex of an optional ObjectID
+func optionalObjectIDHex(id *primitive.ObjectID) *string {
+	if id == nil {
+		return nil
+	}
+	hex := id.Hex()
+	return &hex
+}
+
+// optionalPositive returns nil for a value not set (zero)
+func optionalPositive(value float64) *float64 {
+	if value == 0 {
+		return nil
+	}
+	return &value
+}
+
+// objectIDsHex returns the hex of the ObjectIDs, an empty list for nil
+func objectIDsHex(ids []primitive.ObjectID) []string {
+	hexes := make([]string, len(ids))
+	for i, id := range ids {
+		hexes[i] = id.Hex()
+	}
+	return hexes
+}
+
+// optionalTime formats an optional date as RFC3339
+func optionalTime(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	formatted := t.Format(time.RFC3339)
+	return &formatted
+}
+
+// convertRoleToGraphQL converts a role; built-in roles have their name as id and no dates
+func convertRoleToGraphQL(dbRole *database.Role) *model.Role {
+	if dbRole == nil {
+		return nil
+	}
+
+	role := &model.Role{
+		ID:          dbRole.ID.Hex(),
+		Name:        dbRole.Name,
+		Description: optionalString(dbRole.Description),
+		Permissions: dbRole.Permissions,
+		BuiltIn:     dbRole.BuiltIn,
+	}
+	if dbRole.BuiltIn {
+		role.ID = dbRole.Name
+		return role
+	}
+	createdAt := dbRole.CreatedAt.Format(time.RFC3339)
+	updatedAt := dbRole.UpdatedAt.Format(time.RFC3339)
+	role.CreatedAt = &createdAt
+	role.UpdatedAt = &updatedAt
+	return role
+}
+
+// convertPromotionToGraphQL converts a database Promotion to a GraphQL Promotion
+func convertPromotionToGraphQL(dbPromotion *database.Promotion) *model.Promotion {
+	if dbPromotion == nil {
+		return nil
+	}
+
+	daysOfWeek := make([]int, len(dbPromotion.DaysOfWeek))
+	copy(daysOfWeek, dbPromotion.DaysOfWeek)
+
+	return &model.Promotion{
+		ID:              dbPromotion.ID.Hex(),
+		Name:            dbPromotion.Name,
+		Description:     optionalString(dbPromotion.Description),
+		Type:            dbPromotion.Type,
+		Value:           dbPromotion.Value,
+		Currency:        optionalString(dbPromotion.Currency),
+		ProductIds:      objectIDsHex(dbPromotion.ProductIDs),
+		BuyQuantity:     optionalPositive(dbPromotion.BuyQuantity),
+		GetQuantity:     optionalPositive(dbPromotion.GetQuantity),
+		MinBasketAmount: optionalPositive(dbPromotion.MinBasketAmount),
+		StoreIds:        objectIDsHex(dbPromotion.StoreIDs),
+		StartDate:       optionalTime(dbPromotion.StartDate),
+		EndDate:         optionalTime(dbPromotion.EndDate),
+		DaysOfWeek:      daysOfWeek,
+		StartTime:       optionalString(dbPromotion.StartTime),
+		EndTime:         optionalString(dbPromotion.EndTime),
+		Active:          dbPromotion.Active,
+		CreatedBy:       dbPromotion.CreatedBy.Hex(),
+		CreatedAt:       dbPromotion.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       dbPromotion.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// convertAppliedPromotionsToGraphQL converts the promotions applied to a sale
+func convertAppliedPromotionsToGraphQL(applied []database.AppliedPromotion) []*model.AppliedPromotion {
+	result := make([]*model.AppliedPromotion, len(applied))
+	for i, promotion := range applied {
+		result[i] = &model.AppliedPromotion{
+			PromotionID: promotion.PromotionID.Hex(),
+			Name:        promotion.Name,
+			Type:        promotion.Type,
+			Amount:      promotion.Amount,
+		}
+	}
+	return result
+}
+
+// convertSalePaymentsToGraphQL converts the tenders of a sale
+func convertSalePaymentsToGraphQL(payments []database.SalePayment) []*model.SalePayment {
+	result := make([]*model.SalePayment, len(payments))
+	for i, payment := range payments {
+		// Tenders recorded before multi-currency tenders are in the currency of the sale
+		rate, convertedAmount := payment.Rate, payment.ConvertedAmount
+		if rate <= 0 {
+			rate, convertedAmount = 1, payment.Amount
+		}
+		result[i] = &model.SalePayment{
+			Method:          payment.Method,
+			Amount:          payment.Amount,
+			Currency:        payment.Currency,
+			Rate:            rate,
+			ConvertedAmount: convertedAmount,
+			Reference:       optionalString(payment.Reference),
+			TransID:         optionalObjectIDHex(payment.TransID),
+		}
+	}
+	return result
+}
+
+// convertSaleExchangeRatesToGraphQL converts the exchange rates saved on a sale
+func convertSaleExchangeRatesToGraphQL(rates []database.SaleExchangeRate) []*model.SaleExchangeRate {
+	result := make([]*model.SaleExchangeRate, len(rates))
+	for i, rate := range rates {
+		result[i] = &model.SaleExchangeRate{
+			Currency: rate.Currency,
+			Rate:     rate.Rate,
+		}
+	}
+	return result
+}
+
+// convertPaymentMethodTotalsToGraphQL converts the amounts received per payment method
+func convertPaymentMethodTotalsToGraphQL(totals []*database.PaymentMethodTotal) []*model.PaymentMethodTotal {
+	result := make([]*model.PaymentMethodTotal, len(totals))
+	for i, total := range totals {
+		result[i] = &model.PaymentMethodTotal{
+			Method:   total.Method,
+			Currency: total.Currency,
+			Amount:   total.Amount,
+			Count:    int(total.Count),
+		}
+	}
+	return result
+}
+
+// convertCaissePaymentMethodTotalsToGraphQL converts the caisse totals per payment method
+func convertCaissePaymentMethodTotalsToGraphQL(totals []*database.CaissePaymentMethodTotal) []*model.CaissePaymentMethodTotal {
+	result := make([]*model.CaissePaymentMethodTotal, len(totals))
+	for i, total := range totals {
+		result[i] = &model.CaissePaymentMethodTotal{
+			Method:             total.Method,
+			Entrees:            total.Entrees,
+			Sorties:            total.Sorties,
+			Solde:              total.Solde,
+			NombreTransactions: total.NombreTransactions,
+		}
+	}
+	return result
+}
+
+// convertPaymentIntentToGraphQL converts a database PaymentIntent to a GraphQL PaymentIntent
+func convertPaymentIntentToGraphQL(dbIntent *database.PaymentIntent) *model.PaymentIntent {
+	return &model.PaymentIntent{
+		ID:              dbIntent.ID.Hex(),
+		StoreID:         dbIntent.StoreID.Hex(),
+		OperatorID:      dbIntent.OperatorID.Hex(),
+		Provider:        dbIntent.Provider,
+		Method:          dbIntent.Method,
+		Phone:           dbIntent.Phone,
+		Amount:          dbIntent.Amount,
+		Currency:        dbIntent.Currency,
+		SaleID:          optionalObjectIDHex(dbIntent.SaleID),
+		DebtID:          dbIntent.DebtID.Hex(),
+		Status:          dbIntent.Status,
+		ProviderRef:     optionalString(dbIntent.ProviderRef),
+		TransactionID:   optionalString(dbIntent.TransactionID),
+		FailureReason:   optionalString(dbIntent.FailureReason),
+		DebtPaymentID:   optionalObjectIDHex(dbIntent.DebtPaymentID),
+		SettlementError: optionalString(dbIntent.SettlementError),
+		CreatedAt:       dbIntent.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       dbIntent.UpdatedAt.Format(time.RFC3339),
+		ConfirmedAt:     optionalTime(dbIntent.ConfirmedAt),
+	}
+}
@@ -8,6 +8,20 @@ import (
 	"strconv"
 )
 
+type APIKey struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Prefix      string   `json:"prefix"`
+	StoreIds    []string `json:"storeIds"`
+	Permissions []string `json:"permissions"`
+	CreatedBy   string   `json:"createdBy"`
+	ExpiresAt   *string  `json:"expiresAt,omitempty"`
+	LastUsedAt  *string  `json:"lastUsedAt,omitempty"`
+	RevokedAt   *string  `json:"revokedAt,omitempty"`
+	Active      bool     `json:"active"`
+	CreatedAt   string   `json:"createdAt"`
+}
+
 type AddInventoryItemInput struct {
 	InventoryID      string  `json:"inventoryId"`
 	ProductID        string  `json:"productId"`
@@ -15,59 +29,117 @@ type AddInventoryItemInput struct {
 	Reason           *string `json:"reason,omitempty"`
 }
 
-type AuthResponse struct {
-	AccessToken  string `json:"accessToken"`
-	RefreshToken string `json:"refreshToken"`
-	User         *User  `json:"user"`
+type AppliedPromotion struct {
+	PromotionID string  `json:"promotionId"`
+	Name        string  `json:"name"`
+	Type        string  `json:"type"`
+	Amount      float64 `json:"amount"`
 }
 
-type Caisse struct {
-	CurrentBalance float64 `json:"currentBalance"`
-	In             float64 `json:"in"`
-	Out            float64 `json:"out"`
-	TotalBenefice  float64 `json:"totalBenefice"`
-	Currency       string  `json:"currency"`
-	StoreID        *string `json:"storeId,omitempty"`
-	Store          *Store  `json:"store,omitempty"`
+type AuditLogEntry struct {
+	ID        string  `json:"id"`
+	Sequence  int     `json:"sequence"`
+	Action    string  `json:"action"`
+	ActorID   *string `json:"actorId,omitempty"`
+	CompanyID *string `json:"companyId,omitempty"`
+	StoreID   *string `json:"storeId,omitempty"`
+	TargetID  *string `json:"targetId,omitempty"`
+	Details   *string `json:"details,omitempty"`
+	Before    *string `json:"before,omitempty"`
+	After     *string `json:"after,omitempty"`
+	Error     *string `json:"error,omitempty"`
+	IPAddress *string `json:"ipAddress,omitempty"`
+	CreatedAt string  `json:"createdAt"`
+	PrevHash  string  `json:"prevHash"`
+	Hash      string  `json:"hash"`
+}
+
+type AuditLogFilter struct {
+	StoreID   *string `json:"storeId,omitempty"`
+	ActorID   *string `json:"actorId,omitempty"`
+	Action    *string `json:"action,omitempty"`
+	TargetID  *string `json:"targetId,omitempty"`
+	StartDate *string `json:"startDate,omitempty"`
+	EndDate   *string `json:"endDate,omitempty"`
+	Limit     *int    `json:"limit,omitempty"`
+}
+
+type AuditLogVerification struct {
+	Valid            bool    `json:"valid"`
+	Checked          int     `json:"checked"`
+	BrokenAtSequence *int    `json:"brokenAtSequence,omitempty"`
+	Reason           *string `json:"reason,omitempty"`
 }
 
-type CaisseRapport struct {
-	StoreID            *string              `json:"storeId,omitempty"`
-	Store              *Store               `json:"store,omitempty"`
-	Currency           string               `json:"currency"`
-	Period             string               `json:"period"`
-	StartDate          string               `json:"startDate"`
-	EndDate            string               `json:"endDate"`
-	TotalEntrees       float64              `json:"totalEntrees"`
-	TotalSorties       float64              `json:"totalSorties"`
-	TotalBenefice      float64              `json:"totalBenefice"`
-	SoldeInitial       float64              `json:"soldeInitial"`
-	SoldeFinal         float64              `json:"soldeFinal"`
-	NombreTransactions int                  `json:"nombreTransactions"`
-	Transactions       []*CaisseTransaction `json:"transactions"`
-	ResumeParJour      []*CaisseResumeJour  `json:"resumeParJour,omitempty"`
+type AuthResponse struct {
+	AccessToken            *string `json:"accessToken,omitempty"`
+	RefreshToken           *string `json:"refreshToken,omitempty"`
+	User                   *User   `json:"user,omitempty"`
+	TwoFactorRequired      bool    `json:"twoFactorRequired"`
+	TwoFactorSetupRequired bool    `json:"twoFactorSetupRequired"`
+	ChallengeToken         *string `json:"challengeToken,omitempty"`
 }
 
-type CaisseResumeJour struct {
-	Date               string  `json:"date"`
+type Caisse struct {
+	CurrentBalance float64  `json:"currentBalance"`
+	In             float64  `json:"in"`
+	Out            float64  `json:"out"`
+	TotalBenefice  *float64 `json:"totalBenefice,omitempty"`
+	Currency       string   `json:"currency"`
+	StoreID        *string  `json:"storeId,omitempty"`
+	Store          *Store   `json:"store,omitempty"`
+}
+
+type CaissePaymentMethodTotal struct {
+	Method             string  `json:"method"`
 	Entrees            float64 `json:"entrees"`
 	Sorties            float64 `json:"sorties"`
-	Benefice           float64 `json:"benefice"`
 	Solde              float64 `json:"solde"`
 	NombreTransactions int     `json:"nombreTransactions"`
 }
 
+type CaisseRapport struct {
+	StoreID            *string                     `json:"storeId,omitempty"`
+	Store              *Store                      `json:"store,omitempty"`
+	Currency           string                      `json:"currency"`
+	Period             string                      `json:"period"`
+	StartDate          string                      `json:"startDate"`
+	EndDate            string                      `json:"endDate"`
+	TotalEntrees       float64                     `json:"totalEntrees"`
+	TotalSorties       float64                     `json:"totalSorties"`
+	TotalBenefice      *float64                    `json:"totalBenefice,omitempty"`
+	SoldeInitial       float64                     `json:"soldeInitial"`
+	SoldeFinal         float64                     `json:"soldeFinal"`
+	NombreTransactions int                         `json:"nombreTransactions"`
+	Transactions       []*CaisseTransaction        `json:"transactions"`
+	ResumeParJour      []*CaisseResumeJour         `json:"resumeParJour,omitempty"`
+	ParMethode         []*CaissePaymentMethodTotal `json:"parMethode"`
+}
+
+type CaisseResumeJour struct {
+	Date               string   `json:"date"`
+	Entrees            float64  `json:"entrees"`
+	Sorties            float64  `json:"sorties"`
+	Benefice           *float64 `json:"benefice,omitempty"`
+	Solde              float64  `json:"solde"`
+	NombreTransactions int      `json:"nombreTransactions"`
+}
+
 type CaisseTransaction struct {
-	ID          string  `json:"id"`
-	Amount      float64 `json:"amount"`
-	Operation   string  `json:"operation"`
-	Description string  `json:"description"`
-	Currency    string  `json:"currency"`
-	StoreID     string  `json:"storeId"`
-	Store       *Store  `json:"store"`
-	Date        string  `json:"date"`
-	CreatedAt   string  `json:"createdAt"`
-	UpdatedAt   string  `json:"updatedAt"`
+	ID            string   `json:"id"`
+	Amount        *float64 `json:"amount,omitempty"`
+	Operation     string   `json:"operation"`
+	Purchase      bool     `json:"purchase"`
+	Description   string   `json:"description"`
+	Currency      string   `json:"currency"`
+	PaymentMethod string   `json:"paymentMethod"`
+	Reference     *string  `json:"reference,omitempty"`
+	SaleID        *string  `json:"saleId,omitempty"`
+	StoreID       string   `json:"storeId"`
+	Store         *Store   `json:"store"`
+	Date          string   `json:"date"`
+	CreatedAt     string   `json:"createdAt"`
+	UpdatedAt     string   `json:"updatedAt"`
 }
 
 type ChangePasswordInput struct {
@@ -89,23 +161,24 @@ type Client struct {
 }
 
 type Company struct {
-	ID            string               `json:"id"`
-	Name          string               `json:"name"`
-	Address       string               `json:"address"`
-	Phone         string               `json:"phone"`
-	Email         *string              `json:"email,omitempty"`
-	Description   string               `json:"description"`
-	Type          string               `json:"type"`
-	Logo          *string              `json:"logo,omitempty"`
-	Rccm          *string              `json:"rccm,omitempty"`
-	IDNat         *string              `json:"idNat,omitempty"`
-	IDCommerce    *string  `json:"idCommerce,omitempty"`
-	LicenseID     *string  `json:"licenseId,omitempty"`
-	Stores        []*Store             `json:"stores"`
-	Subscription  *CompanySubscription `json:"subscription"`
-	ExchangeRates []*ExchangeRate      `json:"exchangeRates"`
-	CreatedAt     string               `json:"createdAt"`
-	UpdatedAt     string               `json:"updatedAt"`
+	ID                    string               `json:"id"`
+	Name                  string               `json:"name"`
+	Address               string               `json:"address"`
+	Phone                 string               `json:"phone"`
+	Email                 *string              `json:"email,omitempty"`
+	Description           string               `json:"description"`
+	Type                  string               `json:"type"`
+	Logo                  *string              `json:"logo,omitempty"`
+	Rccm                  *string              `json:"rccm,omitempty"`
+	IDNat                 *string              `json:"idNat,omitempty"`
+	IDCommerce            *string              `json:"idCommerce,omitempty"`
+	LicenseID             *string              `json:"licenseId,omitempty"`
+	Stores                []*Store             `json:"stores"`
+	Subscription          *CompanySubscription `json:"subscription"`
+	ExchangeRates         []*ExchangeRate      `json:"exchangeRates"`
+	RequireAdminTwoFactor bool                 `json:"requireAdminTwoFactor"`
+	CreatedAt             string               `json:"createdAt"`
+	UpdatedAt             string               `json:"updatedAt"`
 }
 
 type CompanySubscription struct {
@@ -127,6 +200,13 @@ type CompanySubscription struct {
 	UpdatedAt             string  `json:"updatedAt"`
 }
 
+type CreateAPIKeyInput struct {
+	Name        string   `json:"name"`
+	StoreIds    []string `json:"storeIds"`
+	Permissions []string `json:"permissions"`
+	ExpiresAt   *string  `json:"expiresAt,omitempty"`
+}
+
 type CreateCaisseTransactionInput struct {
 	Amount      float64 `json:"amount"`
 	Operation   string  `json:"operation"`
@@ -171,10 +251,19 @@ type CreateInventoryInput struct {
 	Description string `json:"description"`
 }
 
+type CreateInvitationInput struct {
+	Phone   *string `json:"phone,omitempty"`
+	Role    string  `json:"role"`
+	RoleID  *string `json:"roleId,omitempty"`
+	StoreID *string `json:"storeId,omitempty"`
+}
+
 type CreateProductInput struct {
-	Name    string `json:"name"`
-	Mark    string `json:"mark"`
-	StoreID string `json:"storeId"`
+	Name    string  `json:"name"`
+	Mark    string  `json:"mark"`
+	StoreID string  `json:"storeId"`
+	Barcode *string `json:"barcode,omitempty"`
+	Sku     *string `json:"sku,omitempty"`
 }
 
 type CreateProviderInput struct {
@@ -184,6 +273,15 @@ type CreateProviderInput struct {
 	StoreID string `json:"storeId"`
 }
 
+type CreatePurchaseOrderInput struct {
+	StoreID      string                    `json:"storeId"`
+	ProviderID   string                    `json:"providerId"`
+	Lines        []*PurchaseOrderLineInput `json:"lines"`
+	Currency     *string                   `json:"currency,omitempty"`
+	Note         *string                   `json:"note,omitempty"`
+	ExpectedDate *string                   `json:"expectedDate,omitempty"`
+}
+
 type CreateRapportStoreInput struct {
 	ProductID string  `json:"productId"`
 	StoreID   string  `json:"storeId"`
@@ -193,14 +291,31 @@ type CreateRapportStoreInput struct {
 }
 
 type CreateSaleInput struct {
-	Basket      []*SaleProductInput `json:"basket"`
-	PriceToPay  float64             `json:"priceToPay"`
-	PricePayed  float64             `json:"pricePayed"`
-	ClientID    *string             `json:"clientId,omitempty"`
-	StoreID     string              `json:"storeId"`
-	Currency    *string             `json:"currency,omitempty"`
-	PaymentType *string             `json:"paymentType,omitempty"`
-	Date        *string             `json:"date,omitempty"`
+	Basket         []*SaleProductInput   `json:"basket"`
+	Products       []*SaleByProductInput `json:"products,omitempty"`
+	PriceToPay     float64               `json:"priceToPay"`
+	PricePayed     float64               `json:"pricePayed"`
+	ClientID       *string               `json:"clientId,omitempty"`
+	StoreID        string                `json:"storeId"`
+	Currency       *string               `json:"currency,omitempty"`
+	PaymentType    *string               `json:"paymentType,omitempty"`
+	Payments       []*SalePaymentInput   `json:"payments,omitempty"`
+	ChangeCurrency *string               `json:"changeCurrency,omitempty"`
+	Date           *string               `json:"date,omitempty"`
+}
+
+type CreateSaleReturnInput struct {
+	SaleID       string                 `json:"saleId"`
+	Items        []*SaleReturnItemInput `json:"items"`
+	RefundMethod string                 `json:"refundMethod"`
+	Reason       *string                `json:"reason,omitempty"`
+}
+
+type CreateStockTransferInput struct {
+	FromStoreID string                    `json:"fromStoreId"`
+	ToStoreID   string                    `json:"toStoreId"`
+	Items       []*StockTransferItemInput `json:"items"`
+	Note        *string                   `json:"note,omitempty"`
 }
 
 type CreateStoreInput struct {
@@ -219,6 +334,17 @@ type CreateUserInput struct {
 	StoreID  *string `json:"storeId,omitempty"`
 }
 
+type CreatedAPIKey struct {
+	Key    string  `json:"key"`
+	APIKey *APIKey `json:"apiKey"`
+}
+
+type CreatedInvitation struct {
+	Code       string      `json:"code"`
+	Link       *string     `json:"link,omitempty"`
+	Invitation *Invitation `json:"invitation"`
+}
+
 type Debt struct {
 	ID          string         `json:"id"`
 	SaleID      string         `json:"saleId"`
@@ -240,17 +366,20 @@ type Debt struct {
 }
 
 type DebtPayment struct {
-	ID          string  `json:"id"`
-	DebtID      string  `json:"debtId"`
-	Debt        *Debt   `json:"debt"`
-	Amount      float64 `json:"amount"`
-	Currency    string  `json:"currency"`
-	OperatorID  string  `json:"operatorId"`
-	Operator    *User   `json:"operator"`
-	StoreID     string  `json:"storeId"`
-	Store       *Store  `json:"store"`
-	Description string  `json:"description"`
-	CreatedAt   string  `json:"createdAt"`
+	ID            string  `json:"id"`
+	DebtID        string  `json:"debtId"`
+	Debt          *Debt   `json:"debt"`
+	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
+	OperatorID    string  `json:"operatorId"`
+	Operator      *User   `json:"operator"`
+	StoreID       string  `json:"storeId"`
+	Store         *Store  `json:"store"`
+	Description   string  `json:"description"`
+	PaymentMethod string  `json:"paymentMethod"`
+	Reference     *string `json:"reference,omitempty"`
+	CreatedAt     string  `json:"createdAt"`
+	VoidedAt      *string `json:"voidedAt,omitempty"`
 }
 
 type ExchangeRate struct {
@@ -296,6 +425,15 @@ type FactureProductInput struct {
 	Price     float64 `json:"price"`
 }
 
+type InitiatePaymentInput struct {
+	StoreID string  `json:"storeId"`
+	Method  string  `json:"method"`
+	Phone   string  `json:"phone"`
+	Amount  float64 `json:"amount"`
+	SaleID  *string `json:"saleId,omitempty"`
+	DebtID  *string `json:"debtId,omitempty"`
+}
+
 type Inventory struct {
 	ID          string           `json:"id"`
 	StoreID     string           `json:"storeId"`
@@ -328,33 +466,111 @@ type InventoryItem struct {
 	CountedAt        string   `json:"countedAt"`
 }
 
+type Invitation struct {
+	ID         string  `json:"id"`
+	Phone      *string `json:"phone,omitempty"`
+	Role       string  `json:"role"`
+	RoleID     *string `json:"roleId,omitempty"`
+	StoreID    *string `json:"storeId,omitempty"`
+	InvitedBy  string  `json:"invitedBy"`
+	Status     string  `json:"status"`
+	SmsSent    bool    `json:"smsSent"`
+	ExpiresAt  string  `json:"expiresAt"`
+	AcceptedAt *string `json:"acceptedAt,omitempty"`
+	AcceptedBy *string `json:"acceptedBy,omitempty"`
+	RevokedAt  *string `json:"revokedAt,omitempty"`
+	CreatedAt  string  `json:"createdAt"`
+}
+
+type LowStockProduct struct {
+	ProductID       string   `json:"productId"`
+	Product         *Product `json:"product"`
+	StoreID         string   `json:"storeId"`
+	Stock           float64  `json:"stock"`
+	ReorderPoint    float64  `json:"reorderPoint"`
+	ReorderQuantity float64  `json:"reorderQuantity"`
+}
+
+type Membership struct {
+	CompanyID       string   `json:"companyId"`
+	CompanyName     string   `json:"companyName"`
+	Role            string   `json:"role"`
+	RoleID          *string  `json:"roleId,omitempty"`
+	StoreIds        []string `json:"storeIds"`
+	AssignedStoreID *string  `json:"assignedStoreId,omitempty"`
+	Current         bool     `json:"current"`
+	CreatedAt       string   `json:"createdAt"`
+}
+
 type Mutation struct {
 }
 
+type PaymentIntent struct {
+	ID              string  `json:"id"`
+	StoreID         string  `json:"storeId"`
+	OperatorID      string  `json:"operatorId"`
+	Provider        string  `json:"provider"`
+	Method          string  `json:"method"`
+	Phone           string  `json:"phone"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	SaleID          *string `json:"saleId,omitempty"`
+	DebtID          string  `json:"debtId"`
+	Status          string  `json:"status"`
+	ProviderRef     *string `json:"providerRef,omitempty"`
+	TransactionID   *string `json:"transactionId,omitempty"`
+	FailureReason   *string `json:"failureReason,omitempty"`
+	DebtPaymentID   *string `json:"debtPaymentId,omitempty"`
+	SettlementError *string `json:"settlementError,omitempty"`
+	CreatedAt       string  `json:"createdAt"`
+	UpdatedAt       string  `json:"updatedAt"`
+	ConfirmedAt     *string `json:"confirmedAt,omitempty"`
+}
+
+type PaymentMethodTotal struct {
+	Method   string  `json:"method"`
+	Currency string  `json:"currency"`
+	Amount   float64 `json:"amount"`
+	Count    int     `json:"count"`
+}
+
+type Permission struct {
+	Key         string `json:"key"`
+	Description string `json:"description"`
+}
+
 type Product struct {
-	ID        string `json:"id"`
-	Name      string `json:"name"`
-	Mark      string `json:"mark"`
-	StoreID   string `json:"storeId"`
-	Store     *Store `json:"store"`
-	CreatedAt string `json:"createdAt"`
-	UpdatedAt string `json:"updatedAt"`
+	ID              string  `json:"id"`
+	Name            string  `json:"name"`
+	Mark            string  `json:"mark"`
+	Barcode         *string `json:"barcode,omitempty"`
+	Sku             *string `json:"sku,omitempty"`
+	ReorderPoint    float64 `json:"reorderPoint"`
+	ReorderQuantity float64 `json:"reorderQuantity"`
+	StoreID         string  `json:"storeId"`
+	Store           *Store  `json:"store"`
+	CreatedAt       string  `json:"createdAt"`
+	UpdatedAt       string  `json:"updatedAt"`
 }
 
 type ProductInStock struct {
-	ID         string    `json:"id"`
-	ProductID  string    `json:"productId"`
-	Product    *Product  `json:"product"`
-	PriceVente float64   `json:"priceVente"`
-	PriceAchat float64   `json:"priceAchat"`
-	Currency   string    `json:"currency"`
-	Stock      float64   `json:"stock"`
-	StoreID    string    `json:"storeId"`
-	Store      *Store    `json:"store"`
-	ProviderID string    `json:"providerId"`
-	Provider   *Provider `json:"provider"`
-	CreatedAt  string    `json:"createdAt"`
-	UpdatedAt  string    `json:"updatedAt"`
+	ID              string    `json:"id"`
+	ProductID       string    `json:"productId"`
+	Product         *Product  `json:"product"`
+	PriceVente      float64   `json:"priceVente"`
+	PriceAchat      *float64  `json:"priceAchat,omitempty"`
+	Currency        string    `json:"currency"`
+	Stock           float64   `json:"stock"`
+	StoreID         string    `json:"storeId"`
+	Store           *Store    `json:"store"`
+	ProviderID      string    `json:"providerId"`
+	Provider        *Provider `json:"provider"`
+	BatchNumber     *string   `json:"batchNumber,omitempty"`
+	ExpiryDate      *string   `json:"expiryDate,omitempty"`
+	WriteOffExpired bool      `json:"writeOffExpired"`
+	WrittenOffAt    *string   `json:"writtenOffAt,omitempty"`
+	CreatedAt       string    `json:"createdAt"`
+	UpdatedAt       string    `json:"updatedAt"`
 }
 
 type ProductMovementStats struct {
@@ -364,6 +580,48 @@ type ProductMovementStats struct {
 	NombreMouvements int      `json:"nombreMouvements"`
 }
 
+type Promotion struct {
+	ID              string   `json:"id"`
+	Name            string   `json:"name"`
+	Description     *string  `json:"description,omitempty"`
+	Type            string   `json:"type"`
+	Value           float64  `json:"value"`
+	Currency        *string  `json:"currency,omitempty"`
+	ProductIds      []string `json:"productIds"`
+	BuyQuantity     *float64 `json:"buyQuantity,omitempty"`
+	GetQuantity     *float64 `json:"getQuantity,omitempty"`
+	MinBasketAmount *float64 `json:"minBasketAmount,omitempty"`
+	StoreIds        []string `json:"storeIds"`
+	StartDate       *string  `json:"startDate,omitempty"`
+	EndDate         *string  `json:"endDate,omitempty"`
+	DaysOfWeek      []int    `json:"daysOfWeek"`
+	StartTime       *string  `json:"startTime,omitempty"`
+	EndTime         *string  `json:"endTime,omitempty"`
+	Active          bool     `json:"active"`
+	CreatedBy       string   `json:"createdBy"`
+	CreatedAt       string   `json:"createdAt"`
+	UpdatedAt       string   `json:"updatedAt"`
+}
+
+type PromotionInput struct {
+	Name            string   `json:"name"`
+	Description     *string  `json:"description,omitempty"`
+	Type            string   `json:"type"`
+	Value           *float64 `json:"value,omitempty"`
+	Currency        *string  `json:"currency,omitempty"`
+	ProductIds      []string `json:"productIds,omitempty"`
+	BuyQuantity     *float64 `json:"buyQuantity,omitempty"`
+	GetQuantity     *float64 `json:"getQuantity,omitempty"`
+	MinBasketAmount *float64 `json:"minBasketAmount,omitempty"`
+	StoreIds        []string `json:"storeIds,omitempty"`
+	StartDate       *string  `json:"startDate,omitempty"`
+	EndDate         *string  `json:"endDate,omitempty"`
+	DaysOfWeek      []int    `json:"daysOfWeek,omitempty"`
+	StartTime       *string  `json:"startTime,omitempty"`
+	EndTime         *string  `json:"endTime,omitempty"`
+	Active          *bool    `json:"active,omitempty"`
+}
+
 type Provider struct {
 	ID        string `json:"id"`
 	Name      string `json:"name"`
@@ -383,9 +641,9 @@ type ProviderDebt struct {
 	Provider    *Provider              `json:"provider"`
 	StoreID     string                 `json:"storeId"`
 	Store       *Store                 `json:"store"`
-	TotalAmount float64                `json:"totalAmount"`
-	AmountPaid  float64                `json:"amountPaid"`
-	AmountDue   float64                `json:"amountDue"`
+	TotalAmount *float64               `json:"totalAmount,omitempty"`
+	AmountPaid  *float64               `json:"amountPaid,omitempty"`
+	AmountDue   *float64               `json:"amountDue,omitempty"`
 	Currency    string                 `json:"currency"`
 	Status      string                 `json:"status"`
 	Payments    []*ProviderDebtPayment `json:"payments"`
@@ -398,7 +656,7 @@ type ProviderDebtPayment struct {
 	ID             string        `json:"id"`
 	ProviderDebtID string        `json:"providerDebtId"`
 	ProviderDebt   *ProviderDebt `json:"providerDebt"`
-	Amount         float64       `json:"amount"`
+	Amount         *float64      `json:"amount,omitempty"`
 	Currency       string        `json:"currency"`
 	OperatorID     string        `json:"operatorId"`
 	Operator       *User         `json:"operator"`
@@ -408,6 +666,51 @@ type ProviderDebtPayment struct {
 	CreatedAt      string        `json:"createdAt"`
 }
 
+type PurchaseOrder struct {
+	ID           string               `json:"id"`
+	StoreID      string               `json:"storeId"`
+	Store        *Store               `json:"store"`
+	ProviderID   string               `json:"providerId"`
+	Provider     *Provider            `json:"provider"`
+	Status       string               `json:"status"`
+	Lines        []*PurchaseOrderLine `json:"lines"`
+	Currency     string               `json:"currency"`
+	TotalAmount  *float64             `json:"totalAmount,omitempty"`
+	Note         *string              `json:"note,omitempty"`
+	ExpectedDate *string              `json:"expectedDate,omitempty"`
+	CreatedBy    *User                `json:"createdBy"`
+	SentAt       *string              `json:"sentAt,omitempty"`
+	ReceivedAt   *string              `json:"receivedAt,omitempty"`
+	CancelledAt  *string              `json:"cancelledAt,omitempty"`
+	CreatedAt    string               `json:"createdAt"`
+	UpdatedAt    string               `json:"updatedAt"`
+}
+
+type PurchaseOrderLine struct {
+	ProductID         string         `json:"productId"`
+	Product           *Product       `json:"product"`
+	QuantityOrdered   float64        `json:"quantityOrdered"`
+	QuantityReceived  float64        `json:"quantityReceived"`
+	BackorderQuantity float64        `json:"backorderQuantity"`
+	PriceAchat        *float64       `json:"priceAchat,omitempty"`
+	PriceVente        float64        `json:"priceVente"`
+	Supplies          []*StockSupply `json:"supplies"`
+}
+
+type PurchaseOrderLineInput struct {
+	ProductID  string  `json:"productId"`
+	Quantity   float64 `json:"quantity"`
+	PriceAchat float64 `json:"priceAchat"`
+	PriceVente float64 `json:"priceVente"`
+}
+
+type PurchaseOrderReceiptLineInput struct {
+	ProductID   string  `json:"productId"`
+	Quantity    float64 `json:"quantity"`
+	BatchNumber *string `json:"batchNumber,omitempty"`
+	ExpiryDate  *string `json:"expiryDate,omitempty"`
+}
+
 type Query struct {
 }
 
@@ -423,49 +726,133 @@ type RapportStore struct {
 	UpdatedAt string   `json:"updatedAt"`
 }
 
+type ReceivePurchaseOrderInput struct {
+	Lines       []*PurchaseOrderReceiptLineInput `json:"lines"`
+	PaymentType string                           `json:"paymentType"`
+	Date        *string                          `json:"date,omitempty"`
+}
+
 type RegisterInput struct {
-	Name     string `json:"name"`
-	Phone    string `json:"phone"`
-	Password string `json:"password"`
+	Name             string  `json:"name"`
+	Phone            string  `json:"phone"`
+	Password         string  `json:"password"`
+	VerificationCode *string `json:"verificationCode,omitempty"`
+	InvitationCode   *string `json:"invitationCode,omitempty"`
+}
+
+type ReorderSuggestion struct {
+	ProductID               string   `json:"productId"`
+	Product                 *Product `json:"product"`
+	Stock                   float64  `json:"stock"`
+	AverageDailyConsumption float64  `json:"averageDailyConsumption"`
+	DaysOfCover             *float64 `json:"daysOfCover,omitempty"`
+	SuggestedQuantity       float64  `json:"suggestedQuantity"`
+	PriceAchat              *float64 `json:"priceAchat,omitempty"`
+	Currency                *string  `json:"currency,omitempty"`
+}
+
+type ReorderSuggestionGroup struct {
+	ProviderID  *string              `json:"providerId,omitempty"`
+	Provider    *Provider            `json:"provider,omitempty"`
+	StoreID     string               `json:"storeId"`
+	Suggestions []*ReorderSuggestion `json:"suggestions"`
+	TotalAmount *float64             `json:"totalAmount,omitempty"`
+	Currency    string               `json:"currency"`
+}
+
+type Role struct {
+	ID          string   `json:"id"`
+	Name        string   `json:"name"`
+	Description *string  `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
+	BuiltIn     bool     `json:"builtIn"`
+	CreatedAt   *string  `json:"createdAt,omitempty"`
+	UpdatedAt   *string  `json:"updatedAt,omitempty"`
+}
+
+type RoleInput struct {
+	Name        string   `json:"name"`
+	Description *string  `json:"description,omitempty"`
+	Permissions []string `json:"permissions"`
 }
 
 type Sale struct {
-	ID          string         `json:"id"`
-	Basket      []*SaleProduct `json:"basket"`
-	PriceToPay  float64        `json:"priceToPay"`
-	PricePayed  float64        `json:"pricePayed"`
-	Change      float64        `json:"change"`
-	Benefice    float64        `json:"benefice"`
-	Currency    string         `json:"currency"`
-	Client      *Client        `json:"client,omitempty"`
-	Operator    *User          `json:"operator"`
-	StoreID     string         `json:"storeId"`
-	Store       *Store         `json:"store"`
-	PaymentType string         `json:"paymentType"`
-	AmountDue   float64        `json:"amountDue"`
-	DebtStatus  string         `json:"debtStatus"`
-	DebtID      *string        `json:"debtId,omitempty"`
-	Debt        *Debt          `json:"debt,omitempty"`
-	Date        string         `json:"date"`
-	CreatedAt   string         `json:"createdAt"`
-	UpdatedAt   string         `json:"updatedAt"`
+	ID             string              `json:"id"`
+	Basket         []*SaleProduct      `json:"basket"`
+	PriceToPay     float64             `json:"priceToPay"`
+	PricePayed     float64             `json:"pricePayed"`
+	Change         float64             `json:"change"`
+	Benefice       *float64            `json:"benefice,omitempty"`
+	Currency       string              `json:"currency"`
+	Client         *Client             `json:"client,omitempty"`
+	Operator       *User               `json:"operator"`
+	StoreID        string              `json:"storeId"`
+	Store          *Store              `json:"store"`
+	PaymentType    string              `json:"paymentType"`
+	AmountDue      float64             `json:"amountDue"`
+	DebtStatus     string              `json:"debtStatus"`
+	DebtID         *string             `json:"debtId,omitempty"`
+	Debt           *Debt               `json:"debt,omitempty"`
+	CancelledAt    *string             `json:"cancelledAt,omitempty"`
+	CancelReason   *string             `json:"cancelReason,omitempty"`
+	ReturnedAmount float64             `json:"returnedAmount"`
+	Discount       float64             `json:"discount"`
+	Promotions     []*AppliedPromotion `json:"promotions"`
+	Payments       []*SalePayment      `json:"payments"`
+	ExchangeRates  []*SaleExchangeRate `json:"exchangeRates"`
+	ChangeCurrency *string             `json:"changeCurrency,omitempty"`
+	ChangeAmount   float64             `json:"changeAmount"`
+	Date           string              `json:"date"`
+	CreatedAt      string              `json:"createdAt"`
+	UpdatedAt      string              `json:"updatedAt"`
+}
+
+type SaleByProductInput struct {
+	ProductID string  `json:"productId"`
+	Quantity  float64 `json:"quantity"`
+	Price     float64 `json:"price"`
+}
+
+type SaleExchangeRate struct {
+	Currency string  `json:"currency"`
+	Rate     float64 `json:"rate"`
 }
 
 type SaleList struct {
-	ID          string  `json:"id"`
-	Date        string  `json:"date"`
-	CreatedAt   string  `json:"createdAt"`
-	PriceToPay  float64 `json:"priceToPay"`
-	PricePayed  float64 `json:"pricePayed"`
-	Change      float64 `json:"change"`
-	Currency    string  `json:"currency"`
-	Client      *Client `json:"client,omitempty"`
-	BasketCount int     `json:"basketCount"`
-	TotalItems  float64 `json:"totalItems"`
-	StoreID     string  `json:"storeId"`
-	PaymentType string  `json:"paymentType"`
-	AmountDue   float64 `json:"amountDue"`
-	DebtStatus  string  `json:"debtStatus"`
+	ID             string  `json:"id"`
+	Date           string  `json:"date"`
+	CreatedAt      string  `json:"createdAt"`
+	PriceToPay     float64 `json:"priceToPay"`
+	PricePayed     float64 `json:"pricePayed"`
+	Change         float64 `json:"change"`
+	Currency       string  `json:"currency"`
+	Client         *Client `json:"client,omitempty"`
+	BasketCount    int     `json:"basketCount"`
+	TotalItems     float64 `json:"totalItems"`
+	StoreID        string  `json:"storeId"`
+	PaymentType    string  `json:"paymentType"`
+	AmountDue      float64 `json:"amountDue"`
+	DebtStatus     string  `json:"debtStatus"`
+	CancelledAt    *string `json:"cancelledAt,omitempty"`
+	ReturnedAmount float64 `json:"returnedAmount"`
+	Discount       float64 `json:"discount"`
+}
+
+type SalePayment struct {
+	Method          string  `json:"method"`
+	Amount          float64 `json:"amount"`
+	Currency        string  `json:"currency"`
+	Rate            float64 `json:"rate"`
+	ConvertedAmount float64 `json:"convertedAmount"`
+	Reference       *string `json:"reference,omitempty"`
+	TransID         *string `json:"transId,omitempty"`
+}
+
+type SalePaymentInput struct {
+	Method    string  `json:"method"`
+	Amount    float64 `json:"amount"`
+	Currency  *string `json:"currency,omitempty"`
+	Reference *string `json:"reference,omitempty"`
 }
 
 type SaleProduct struct {
@@ -473,6 +860,9 @@ type SaleProduct struct {
 	ProductInStock   *ProductInStock `json:"productInStock"`
 	Quantity         float64         `json:"quantity"`
 	Price            float64         `json:"price"`
+	ProductID        *string         `json:"productId,omitempty"`
+	Discount         float64         `json:"discount"`
+	PromotionIds     []string        `json:"promotionIds"`
 }
 
 type SaleProductInput struct {
@@ -481,12 +871,68 @@ type SaleProductInput struct {
 	Price            float64 `json:"price"`
 }
 
+type SaleReturn struct {
+	ID           string            `json:"id"`
+	SaleID       string            `json:"saleId"`
+	Sale         *Sale             `json:"sale"`
+	Items        []*SaleReturnItem `json:"items"`
+	TotalAmount  float64           `json:"totalAmount"`
+	Benefice     *float64          `json:"benefice,omitempty"`
+	Currency     string            `json:"currency"`
+	RefundMethod string            `json:"refundMethod"`
+	Reason       *string           `json:"reason,omitempty"`
+	OperatorID   string            `json:"operatorId"`
+	Operator     *User             `json:"operator"`
+	StoreID      string            `json:"storeId"`
+	Store        *Store            `json:"store"`
+	CreatedAt    string            `json:"createdAt"`
+}
+
+type SaleReturnItem struct {
+	ProductInStockID string          `json:"productInStockId"`
+	ProductInStock   *ProductInStock `json:"productInStock"`
+	Quantity         float64         `json:"quantity"`
+	Price            float64         `json:"price"`
+}
+
+type SaleReturnItemInput struct {
+	ProductInStockID string  `json:"productInStockId"`
+	Quantity         float64 `json:"quantity"`
+}
+
 type SalesStats struct {
-	TotalSales    int     `json:"totalSales"`
-	TotalRevenue  float64 `json:"totalRevenue"`
-	TotalItems    float64 `json:"totalItems"`
-	AverageSale   float64 `json:"averageSale"`
-	TotalBenefice float64 `json:"totalBenefice"`
+	TotalSales      int                   `json:"totalSales"`
+	TotalRevenue    float64               `json:"totalRevenue"`
+	TotalItems      float64               `json:"totalItems"`
+	AverageSale     float64               `json:"averageSale"`
+	TotalBenefice   *float64              `json:"totalBenefice,omitempty"`
+	TotalDiscount   float64               `json:"totalDiscount"`
+	ByPaymentMethod []*PaymentMethodTotal `json:"byPaymentMethod"`
+}
+
+type Session struct {
+	ID         string  `json:"id"`
+	UserID     string  `json:"userId"`
+	DeviceName *string `json:"deviceName,omitempty"`
+	IPAddress  *string `json:"ipAddress,omitempty"`
+	UserAgent  *string `json:"userAgent,omitempty"`
+	Current    bool    `json:"current"`
+	LastSeenAt string  `json:"lastSeenAt"`
+	ExpiresAt  string  `json:"expiresAt"`
+	CreatedAt  string  `json:"createdAt"`
+}
+
+type StockAlert struct {
+	ID              string   `json:"id"`
+	ProductID       string   `json:"productId"`
+	Product         *Product `json:"product"`
+	StoreID         string   `json:"storeId"`
+	Stock           float64  `json:"stock"`
+	ReorderPoint    float64  `json:"reorderPoint"`
+	ReorderQuantity float64  `json:"reorderQuantity"`
+	Status          string   `json:"status"`
+	ResolvedAt      *string  `json:"resolvedAt,omitempty"`
+	CreatedAt       string   `json:"createdAt"`
 }
 
 type StockMovement struct {
@@ -497,8 +943,8 @@ type StockMovement struct {
 	Store         *Store            `json:"store"`
 	Type          StockMovementType `json:"type"`
 	Quantity      float64           `json:"quantity"`
-	UnitPrice     float64           `json:"unitPrice"`
-	TotalValue    float64           `json:"totalValue"`
+	UnitPrice     *float64          `json:"unitPrice,omitempty"`
+	TotalValue    *float64          `json:"totalValue,omitempty"`
 	Currency      string            `json:"currency"`
 	Reason        *string           `json:"reason,omitempty"`
 	Reference     *string           `json:"reference,omitempty"`
@@ -519,8 +965,8 @@ type StockMovementByProduct struct {
 	SoldeInitial        float64  `json:"soldeInitial"`
 	SoldeFinal          float64  `json:"soldeFinal"`
 	NombreMouvements    int      `json:"nombreMouvements"`
-	ValeurTotaleEntrees float64  `json:"valeurTotaleEntrees"`
-	ValeurTotaleSorties float64  `json:"valeurTotaleSorties"`
+	ValeurTotaleEntrees *float64 `json:"valeurTotaleEntrees,omitempty"`
+	ValeurTotaleSorties *float64 `json:"valeurTotaleSorties,omitempty"`
 }
 
 type StockReport struct {
@@ -542,14 +988,14 @@ type StockReport struct {
 }
 
 type StockReportResumeJour struct {
-	Date                string  `json:"date"`
-	Entrees             float64 `json:"entrees"`
-	Sorties             float64 `json:"sorties"`
-	Ajustements         float64 `json:"ajustements"`
-	Solde               float64 `json:"solde"`
-	NombreMouvements    int     `json:"nombreMouvements"`
-	ValeurTotaleEntrees float64 `json:"valeurTotaleEntrees"`
-	ValeurTotaleSorties float64 `json:"valeurTotaleSorties"`
+	Date                string   `json:"date"`
+	Entrees             float64  `json:"entrees"`
+	Sorties             float64  `json:"sorties"`
+	Ajustements         float64  `json:"ajustements"`
+	Solde               float64  `json:"solde"`
+	NombreMouvements    int      `json:"nombreMouvements"`
+	ValeurTotaleEntrees *float64 `json:"valeurTotaleEntrees,omitempty"`
+	ValeurTotaleSorties *float64 `json:"valeurTotaleSorties,omitempty"`
 }
 
 type StockStats struct {
@@ -569,7 +1015,7 @@ type StockSupply struct {
 	ProductInStockID string          `json:"productInStockId"`
 	ProductInStock   *ProductInStock `json:"productInStock"`
 	Quantity         float64         `json:"quantity"`
-	PriceAchat       float64         `json:"priceAchat"`
+	PriceAchat       *float64        `json:"priceAchat,omitempty"`
 	PriceVente       float64         `json:"priceVente"`
 	Currency         string          `json:"currency"`
 	ProviderID       string          `json:"providerId"`
@@ -581,6 +1027,8 @@ type StockSupply struct {
 	PaymentType      string          `json:"paymentType"`
 	ProviderDebtID   *string         `json:"providerDebtId,omitempty"`
 	ProviderDebt     *ProviderDebt   `json:"providerDebt,omitempty"`
+	BatchNumber      *string         `json:"batchNumber,omitempty"`
+	ExpiryDate       *string         `json:"expiryDate,omitempty"`
 	Date             string          `json:"date"`
 	CreatedAt        string          `json:"createdAt"`
 	UpdatedAt        string          `json:"updatedAt"`
@@ -597,6 +1045,51 @@ type StockSupplyInput struct {
 	PaymentType string   `json:"paymentType"`
 	AmountPaid  *float64 `json:"amountPaid,omitempty"`
 	Date        *string  `json:"date,omitempty"`
+	BatchNumber *string  `json:"batchNumber,omitempty"`
+	ExpiryDate  *string  `json:"expiryDate,omitempty"`
+}
+
+type StockTransfer struct {
+	ID          string               `json:"id"`
+	FromStoreID string               `json:"fromStoreId"`
+	FromStore   *Store               `json:"fromStore"`
+	ToStoreID   string               `json:"toStoreId"`
+	ToStore     *Store               `json:"toStore"`
+	Status      string               `json:"status"`
+	Items       []*StockTransferItem `json:"items"`
+	Note        *string              `json:"note,omitempty"`
+	CreatedBy   *User                `json:"createdBy"`
+	ShippedAt   *string              `json:"shippedAt,omitempty"`
+	ReceivedAt  *string              `json:"receivedAt,omitempty"`
+	CancelledAt *string              `json:"cancelledAt,omitempty"`
+	CreatedAt   string               `json:"createdAt"`
+	UpdatedAt   string               `json:"updatedAt"`
+}
+
+type StockTransferItem struct {
+	SourceProductInStockID      string          `json:"sourceProductInStockId"`
+	SourceProductInStock        *ProductInStock `json:"sourceProductInStock"`
+	DestinationProductInStockID *string         `json:"destinationProductInStockId,omitempty"`
+	DestinationProductID        *string         `json:"destinationProductId,omitempty"`
+	ProductID                   string          `json:"productId"`
+	Product                     *Product        `json:"product"`
+	Quantity                    float64         `json:"quantity"`
+	ReceivedQuantity            float64         `json:"receivedQuantity"`
+	Discrepancy                 float64         `json:"discrepancy"`
+	DiscrepancyReason           *string         `json:"discrepancyReason,omitempty"`
+	PriceAchat                  *float64        `json:"priceAchat,omitempty"`
+	Currency                    string          `json:"currency"`
+}
+
+type StockTransferItemInput struct {
+	ProductInStockID string  `json:"productInStockId"`
+	Quantity         float64 `json:"quantity"`
+}
+
+type StockTransferReceiptInput struct {
+	ProductInStockID string  `json:"productInStockId"`
+	ReceivedQuantity float64 `json:"receivedQuantity"`
+	Reason           *string `json:"reason,omitempty"`
 }
 
 type Store struct {
@@ -612,6 +1105,9 @@ type Store struct {
 	UpdatedAt           string   `json:"updatedAt"`
 }
 
+type Subscription struct {
+}
+
 type SubscriptionPlan struct {
 	ID            string   `json:"id"`
 	Name          string   `json:"name"`
@@ -634,6 +1130,16 @@ type SubscriptionStatus struct {
 	HasLicense   bool                 `json:"hasLicense"`
 }
 
+type TwoFactorActivation struct {
+	RecoveryCodes []string      `json:"recoveryCodes"`
+	Auth          *AuthResponse `json:"auth,omitempty"`
+}
+
+type TwoFactorSetup struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauthUri"`
+}
+
 type UpdateClientInput struct {
 	Name        *string  `json:"name,omitempty"`
 	Phone       *string  `json:"phone,omitempty"`
@@ -664,8 +1170,10 @@ type UpdateFactureInput struct {
 }
 
 type UpdateProductInput struct {
-	Name *string `json:"name,omitempty"`
-	Mark *string `json:"mark,omitempty"`
+	Name    *string `json:"name,omitempty"`
+	Mark    *string `json:"mark,omitempty"`
+	Barcode *string `json:"barcode,omitempty"`
+	Sku     *string `json:"sku,omitempty"`
 }
 
 type UpdateProviderInput struct {
@@ -690,17 +1198,21 @@ type UpdateUserInput struct {
 }
 
 type User struct {
-	ID              string   `json:"id"`
-	UID             string   `json:"uid"`
-	Name            string   `json:"name"`
-	Phone           string   `json:"phone"`
-	Role            string   `json:"role"`
-	IsBlocked       bool     `json:"isBlocked"`
-	CompanyID       string   `json:"companyId"`
-	StoreIds        []string `json:"storeIds"`
-	AssignedStoreID *string  `json:"assignedStoreId,omitempty"`
-	CreatedAt       string   `json:"createdAt"`
-	UpdatedAt       string   `json:"updatedAt"`
+	ID               string   `json:"id"`
+	UID              string   `json:"uid"`
+	Name             string   `json:"name"`
+	Phone            string   `json:"phone"`
+	PhoneVerified    bool     `json:"phoneVerified"`
+	Role             string   `json:"role"`
+	IsBlocked        bool     `json:"isBlocked"`
+	TwoFactorEnabled bool     `json:"twoFactorEnabled"`
+	LockedUntil      *string  `json:"lockedUntil,omitempty"`
+	CompanyID        string   `json:"companyId"`
+	StoreIds         []string `json:"storeIds"`
+	AssignedStoreID  *string  `json:"assignedStoreId,omitempty"`
+	RoleID           *string  `json:"roleId,omitempty"`
+	CreatedAt        string   `json:"createdAt"`
+	UpdatedAt        string   `json:"updatedAt"`
 }
 
 type StockMovementType string
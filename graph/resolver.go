@@ -2,9 +2,22 @@ package graph
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"rangoapp/database"
+	"rangoapp/events"
+	"rangoapp/graph/model"
 	"rangoapp/middlewares"
 	"rangoapp/utils"
+	"rangoapp/validators"
+	"strings"
+	"time"
+
+	"github.com/99designs/gqlgen/graphql"
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 // This file will not be regenerated automatically.
@@ -12,7 +25,9 @@ import (
 // It serves as dependency injection for your app, add any dependencies you require here.
 
 type Resolver struct {
-	DB *database.DB
+	DB       *database.DB
+	SMS      utils.SMSSender       // Codes OTP (resetPassword, vérification du téléphone)
+	Payments utils.PaymentProvider // Paiements mobile money (initiatePayment, webhook)
 }
 
 func (r *Resolver) GetUserFromContext(ctx context.Context) (*database.User, error) {
@@ -21,10 +36,11 @@ func (r *Resolver) GetUserFromContext(ctx context.Context) (*database.User, erro
 		return nil, nil
 	}
 
-	user, err := r.DB.FindUserByID(raw.ID)
+	// Role and stores of the user in the company of the token
+	user, err := r.DB.FindUserForToken(raw.ID, raw.CompanyID)
 	if err != nil {
 		utils.LogError(err, "Failed to find user from context")
-		return nil, utils.NewDatabaseError("FindUserByID", err)
+		return nil, utils.NewDatabaseError("FindUserForToken", err)
 	}
 
 	return user, nil
@@ -36,8 +52,8 @@ func (r *Resolver) GetAccessibleStoreIDs(ctx context.Context) ([]string, error)
 		return nil, nil
 	}
 
-	// If Admin, return all storeIDs
-	if raw.Role == "Admin" {
+	// If Admin, return all storeIDs (an API key carries its stores the same way)
+	if raw.Role == "Admin" || raw.APIKeyID != "" {
 		return raw.StoreIDs, nil
 	}
 
@@ -55,8 +71,8 @@ func (r *Resolver) HasStoreAccess(ctx context.Context, storeID string) (bool, er
 		return false, nil
 	}
 
-	// Admin has access to all stores in their company
-	if raw.Role == "Admin" {
+	// Admin has access to all stores in their company, an API key to its stores
+	if raw.Role == "Admin" || raw.APIKeyID != "" {
 		for _, id := range raw.StoreIDs {
 			if id == storeID {
 				return true, nil
@@ -102,18 +118,61 @@ func (r *Resolver) RequireStoreAccessFromSale(ctx context.Context, sale *databas
 	return r.RequireStoreAccess(ctx, sale.StoreID.Hex())
 }
 
-// RequireAdmin vérifie que l'utilisateur est Admin
-func (r *Resolver) RequireAdmin(ctx context.Context) error {
-	user, err := r.GetUserFromContext(ctx)
-	if err != nil || user == nil {
-		return utils.NewUnauthorizedError("Unauthorized")
+// HasPermission vérifie que le rôle de l'utilisateur accorde la permission (ex: database.PermissionSaleCancel)
+// Les permissions sont chargées une seule fois par requête (voir middlewares.UserPermissions)
+func (r *Resolver) HasPermission(ctx context.Context, permission string) (bool, error) {
+	if middlewares.CtxValue(ctx) == nil {
+		return false, nil
 	}
-	if user.Role != "Admin" {
-		return utils.NewForbiddenError("Only Admin can perform this action")
+	permissions, err := middlewares.UserPermissions(ctx, r.DB.GetMemberPermissions)
+	if err != nil {
+		return false, err
+	}
+	return permissions[permission], nil
+}
+
+// RequirePermission vérifie la permission et retourne une erreur si elle est refusée
+func (r *Resolver) RequirePermission(ctx context.Context, permission string) error {
+	allowed, err := r.HasPermission(ctx, permission)
+	if err != nil {
+		return utils.NewDatabaseError("check_permission", err)
+	}
+	if !allowed {
+		return utils.NewForbiddenError("Missing permission: " + permission)
 	}
 	return nil
 }
 
+// RequireManageableUser retourne le membre de l'entreprise que l'utilisateur peut gérer (modifier, retirer,
+// bloquer): seul un Admin gère un Admin, et personne ne gère un membre ayant des permissions qu'il n'a pas
+func (r *Resolver) RequireManageableUser(ctx context.Context, currentUser *database.User, userID string) (*database.User, error) {
+	target, err := r.DB.FindUserInCompany(userID, currentUser.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	if target.Role == database.RoleAdmin && currentUser.Role != database.RoleAdmin {
+		return nil, utils.NewForbiddenError("Only Admin can manage an Admin")
+	}
+
+	// Permissions of the role, even while blocked (unblocking gives them back)
+	role := *target
+	role.IsBlocked = false
+	targetPermissions, err := r.DB.GetUserPermissions(&role)
+	if err != nil {
+		return nil, utils.NewDatabaseError("check_permission", err)
+	}
+	permissions, err := middlewares.UserPermissions(ctx, r.DB.GetMemberPermissions)
+	if err != nil {
+		return nil, utils.NewDatabaseError("check_permission", err)
+	}
+	for _, permission := range targetPermissions {
+		if !permissions[permission] {
+			return nil, utils.NewForbiddenError("You cannot manage a user with permissions you don't have")
+		}
+	}
+	return target, nil
+}
+
 // RequireAuthenticated vérifie que l'utilisateur est authentifié
 func (r *Resolver) RequireAuthenticated(ctx context.Context) (*database.User, error) {
 	user, err := r.GetUserFromContext(ctx)
@@ -125,3 +184,259 @@ func (r *Resolver) RequireAuthenticated(ctx context.Context) (*database.User, er
 	}
 	return user, nil
 }
+
+// parseInputDate accepte le format RFC3339 (2024-01-01T00:00:00Z) ou le format date HTML (2024-01-01, début de journée)
+func parseInputDate(value string) (time.Time, error) {
+	date, err := time.Parse(time.RFC3339, value)
+	if err == nil {
+		return date, nil
+	}
+	date, err = time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, gqlerror.Errorf("Invalid date format. Expected RFC3339 (e.g., 2024-01-01T00:00:00Z) or date format (e.g., 2024-01-01)")
+	}
+	return time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local), nil
+}
+
+// parseLotInput lit le numéro de lot et la date de péremption optionnels d'un approvisionnement
+func parseLotInput(batchNumber, expiryDate *string) (string, *time.Time, error) {
+	batch := ""
+	if batchNumber != nil {
+		batch = strings.TrimSpace(*batchNumber)
+	}
+	if expiryDate == nil || *expiryDate == "" {
+		return batch, nil, nil
+	}
+	expiry, err := parseInputDate(*expiryDate)
+	if err != nil {
+		return "", nil, err
+	}
+	return batch, &expiry, nil
+}
+
+// requirePromotionScope vérifie l'accès à chaque boutique d'une promotion.
+// Une promotion sans boutique s'applique à toute l'entreprise: réservée aux Admins
+func (r *Resolver) requirePromotionScope(ctx context.Context, user *database.User, storeIDs []string) error {
+	if len(storeIDs) == 0 {
+		if user.Role != database.RoleAdmin {
+			return gqlerror.Errorf("Only Admin can create a promotion for all stores")
+		}
+		return nil
+	}
+	for _, storeID := range storeIDs {
+		if err := r.RequireStoreAccess(ctx, storeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promotionFromInput convertit un PromotionInput. Une date de fin sans heure (YYYY-MM-DD) inclut ce jour
+func promotionFromInput(input model.PromotionInput) (*database.Promotion, error) {
+	promotion := &database.Promotion{
+		Name:       strings.TrimSpace(input.Name),
+		Type:       input.Type,
+		DaysOfWeek: input.DaysOfWeek,
+		Active:     input.Active == nil || *input.Active,
+		StoreIDs:   []primitive.ObjectID{},
+	}
+	if input.Description != nil {
+		promotion.Description = strings.TrimSpace(*input.Description)
+	}
+	if input.Value != nil {
+		promotion.Value = *input.Value
+	}
+	if input.Currency != nil {
+		promotion.Currency = strings.ToUpper(strings.TrimSpace(*input.Currency))
+	}
+	if input.BuyQuantity != nil {
+		promotion.BuyQuantity = *input.BuyQuantity
+	}
+	if input.GetQuantity != nil {
+		promotion.GetQuantity = *input.GetQuantity
+	}
+	if input.MinBasketAmount != nil {
+		promotion.MinBasketAmount = *input.MinBasketAmount
+	}
+	if input.StartTime != nil {
+		promotion.StartTime = *input.StartTime
+	}
+	if input.EndTime != nil {
+		promotion.EndTime = *input.EndTime
+	}
+	for _, productID := range input.ProductIds {
+		id, err := primitive.ObjectIDFromHex(productID)
+		if err != nil {
+			return nil, gqlerror.Errorf("Invalid product ID: %s", productID)
+		}
+		promotion.ProductIDs = append(promotion.ProductIDs, id)
+	}
+	for _, storeID := range input.StoreIds {
+		id, err := primitive.ObjectIDFromHex(storeID)
+		if err != nil {
+			return nil, gqlerror.Errorf("Invalid store ID: %s", storeID)
+		}
+		promotion.StoreIDs = append(promotion.StoreIDs, id)
+	}
+	if input.StartDate != nil && *input.StartDate != "" {
+		startDate, err := parseInputDate(*input.StartDate)
+		if err != nil {
+			return nil, err
+		}
+		promotion.StartDate = &startDate
+	}
+	if input.EndDate != nil && *input.EndDate != "" {
+		endDate, err := parseInputDate(*input.EndDate)
+		if err != nil {
+			return nil, err
+		}
+		if len(*input.EndDate) == len("2006-01-02") {
+			endDate = endDate.AddDate(0, 0, 1)
+		}
+		promotion.EndDate = &endDate
+	}
+	return promotion, nil
+}
+
+// forwardEvents relaie les événements du bus vers une subscription GraphQL jusqu'à la fermeture
+// de la connexion. convert retourne nil pour ignorer un événement
+func forwardEvents[T any](ctx context.Context, ch <-chan events.Event, unsubscribe func(), convert func(events.Event) *T) <-chan *T {
+	out := make(chan *T, 1)
+	go func() {
+		defer close(out)
+		defer unsubscribe()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-ch:
+				if !ok {
+					return
+				}
+				value := convert(event)
+				if value == nil {
+					continue
+				}
+				select {
+				case out <- value:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// rollbackOnError lists the idempotent mutations that write nothing when they fail (MongoDB transaction or
+// single insert): their key is released for a retry. The others may fail after a first write
+var rollbackOnError = map[string]bool{
+	"createSale":              true,
+	"createCaisseTransaction": true,
+}
+
+// idempotent exécute une mutation avec sa clé d'idempotence (argument idempotencyKey, sinon en-tête Idempotency-Key):
+// le premier résultat est conservé et rejoué aux nouvelles tentatives avec la même clé et les mêmes arguments,
+// une tentative concurrente attend la requête en cours. Sans clé, la mutation est exécutée directement.
+// La clé de l'en-tête vaut pour toute la requête HTTP: elle est propre à chaque champ (alias) de la requête
+func idempotent[T any](ctx context.Context, r *Resolver, operation string, idempotencyKey *string, args interface{}, run func() (T, error)) (T, error) {
+	var zero T
+	key := middlewares.IdempotencyKey(ctx)
+	fromHeader := key != ""
+	if idempotencyKey != nil && strings.TrimSpace(*idempotencyKey) != "" {
+		key = strings.TrimSpace(*idempotencyKey)
+		fromHeader = false
+	}
+	if key == "" {
+		return run()
+	}
+	if err := validators.ValidateIdempotencyKey(key); err != nil {
+		return zero, err
+	}
+	if fromHeader {
+		if fc := graphql.GetFieldContext(ctx); fc != nil && fc.Field.Field != nil {
+			key += ":" + fc.Field.Alias
+		}
+	}
+
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return zero, err
+	}
+
+	// Empreinte de la requête: la clé ne peut pas resservir pour d'autres arguments ou un autre utilisateur
+	payload, err := json.Marshal(map[string]interface{}{"userId": currentUser.ID.Hex(), "args": args})
+	if err != nil {
+		return zero, utils.WrapError(err, "Error hashing the request")
+	}
+	hash := sha256.Sum256(payload)
+
+	record, err := r.DB.BeginIdempotentRequest(currentUser.CompanyID, currentUser.ID, operation, key, hex.EncodeToString(hash[:]))
+	if err != nil {
+		return zero, err
+	}
+
+	// Nouvelle tentative d'une requête déjà exécutée: résultat conservé
+	if record.Status == database.IdempotencyCompleted {
+		var result T
+		if err := json.Unmarshal(record.Response, &result); err != nil {
+			return zero, utils.WrapError(err, "Error reading the stored result")
+		}
+		return result, nil
+	}
+
+	// The request keeps its lock while it runs: a retry waits for it instead of taking it over
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(database.IdempotencyHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := r.DB.ExtendIdempotentRequest(record); err != nil {
+					utils.LogError(err, "Failed to extend idempotency key")
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	result, err := run()
+	close(done)
+
+	if err != nil {
+		if rollbackOnError[operation] || isRefusedBeforeWrite(err) {
+			// Nothing was written: a new attempt runs the mutation again
+			if releaseErr := r.DB.ReleaseIdempotentRequest(record); releaseErr != nil {
+				utils.LogError(releaseErr, "Failed to release idempotency key")
+			}
+		} else if failErr := r.DB.FailIdempotentRequest(record, err); failErr != nil {
+			utils.LogError(failErr, "Failed to store idempotent failure")
+		}
+		return zero, err
+	}
+
+	response, err := json.Marshal(result)
+	if err == nil {
+		err = r.DB.CompleteIdempotentRequest(record, response)
+	}
+	if err != nil {
+		// The mutation is done: return its result, the key stays locked until it expires
+		utils.LogError(err, "Failed to store idempotent result")
+	}
+	return result, nil
+}
+
+// isRefusedBeforeWrite tells whether a mutation was refused by its checks (validation, not found, access),
+// which the mutations run before their first write
+func isRefusedBeforeWrite(err error) bool {
+	var appErr *utils.AppError
+	if !errors.As(err, &appErr) {
+		return false
+	}
+	switch appErr.Type {
+	case utils.ErrorTypeValidation, utils.ErrorTypeNotFound, utils.ErrorTypeUnauthorized, utils.ErrorTypeForbidden:
+		return true
+	}
+	return false
+}
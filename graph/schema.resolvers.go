@@ -8,10 +8,13 @@ import (
 	"context"
 	"fmt"
 	"rangoapp/database"
+	"rangoapp/events"
 	"rangoapp/graph/model"
+	"rangoapp/middlewares"
 	"rangoapp/services"
 	"rangoapp/utils"
 	"rangoapp/validators"
+	"strings"
 	"time"
 
 	"github.com/vektah/gqlparser/v2/gqlerror"
@@ -19,21 +22,24 @@ import (
 )
 
 // Login is the resolver for the login field.
-func (r *mutationResolver) Login(ctx context.Context, phone string, password string) (*model.AuthResponse, error) {
+func (r *mutationResolver) Login(ctx context.Context, phone string, password string, deviceName *string) (*model.AuthResponse, error) {
 	if err := validators.ValidateLoginInput(phone, password); err != nil {
 		return nil, err
 	}
+	device := middlewares.ClientInfo(ctx)
+	if deviceName != nil {
+		if err := validators.ValidateString(*deviceName, "Device name", false, 0, 100); err != nil {
+			return nil, err
+		}
+		device.DeviceName = strings.TrimSpace(*deviceName)
+	}
 	authService := services.NewAuthService(r.DB)
-	response, err := authService.Login(ctx, phone, password)
+	response, err := authService.Login(ctx, phone, password, device)
 	if err != nil {
 		return nil, err
 	}
 
-	return &model.AuthResponse{
-		AccessToken:  response.AccessToken,
-		RefreshToken: response.RefreshToken,
-		User:         convertUserToGraphQL(response.User),
-	}, nil
+	return convertAuthResponseToGraphQL(response), nil
 }
 
 // Register is the resolver for the register field.
@@ -47,81 +53,287 @@ func (r *mutationResolver) Register(ctx context.Context, input model.RegisterInp
 		Name:     input.Name,
 		Phone:    input.Phone,
 	}
+	if input.VerificationCode != nil {
+		registerInput.VerificationCode = strings.TrimSpace(*input.VerificationCode)
+	}
+	if input.InvitationCode != nil {
+		registerInput.InvitationCode = strings.TrimSpace(*input.InvitationCode)
+	}
 
-	response, err := authService.Register(ctx, registerInput)
+	response, err := authService.Register(ctx, registerInput, middlewares.ClientInfo(ctx))
 	if err != nil {
 		return nil, err
 	}
 
-	return &model.AuthResponse{
-		AccessToken:  response.AccessToken,
-		RefreshToken: response.RefreshToken,
-		User:         convertUserToGraphQL(response.User),
-	}, nil
+	return convertAuthResponseToGraphQL(response), nil
 }
 
 // RefreshToken is the resolver for the refreshToken field.
 func (r *mutationResolver) RefreshToken(ctx context.Context, refreshToken string) (*model.AuthResponse, error) {
-	// Validate the refresh token
-	token, err := utils.JwtValidate(ctx, refreshToken)
+	authService := services.NewAuthService(r.DB)
+	response, err := authService.RefreshToken(ctx, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertAuthResponseToGraphQL(response), nil
+}
+
+// Logout is the resolver for the logout field.
+func (r *mutationResolver) Logout(ctx context.Context) (bool, error) {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return false, err
+	}
+
+	// The access token and the refresh token of this login stop working right away
+	authService := services.NewAuthService(r.DB)
+	if err := authService.Logout(ctx, middlewares.CtxValue(ctx)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// LogoutAllDevices is the resolver for the logoutAllDevices field.
+func (r *mutationResolver) LogoutAllDevices(ctx context.Context) (bool, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	authService := services.NewAuthService(r.DB)
+	if _, err := authService.LogoutAllDevices(ctx, currentUser.ID); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RevokeSession is the resolver for the revokeSession field.
+func (r *mutationResolver) RevokeSession(ctx context.Context, id string) (bool, error) {
+	if err := validators.ValidateObjectID(id, "Session ID"); err != nil {
+		return false, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	session, err := r.DB.FindSessionByID(id)
 	if err != nil {
-		return nil, gqlerror.Errorf("Invalid or expired refresh token")
+		return false, err
+	}
+
+	// Own session, or a session of a user of the company with user.manage
+	if session.UserID != currentUser.ID {
+		canManage, err := r.HasPermission(ctx, database.PermissionUserManage)
+		if err != nil {
+			return false, err
+		}
+		if !canManage || session.CompanyID != currentUser.CompanyID {
+			return false, gqlerror.Errorf("Session not found")
+		}
+		// Same rules as blockUser: a Manager cannot end the sessions of an Admin
+		if _, err := r.RequireManageableUser(ctx, currentUser, session.UserID.Hex()); err != nil {
+			return false, err
+		}
+	}
+
+	authService := services.NewAuthService(r.DB)
+	if err := authService.RevokeSession(ctx, session); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RequestPhoneVerification is the resolver for the requestPhoneVerification field.
+func (r *mutationResolver) RequestPhoneVerification(ctx context.Context, phone string) (bool, error) {
+	if err := validators.ValidatePhone(phone); err != nil {
+		return false, err
+	}
+
+	otpService := services.NewOTPService(r.DB, r.SMS)
+	if err := otpService.RequestPhoneVerification(ctx, strings.TrimSpace(phone)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RequestPasswordReset is the resolver for the requestPasswordReset field.
+func (r *mutationResolver) RequestPasswordReset(ctx context.Context, phone string) (bool, error) {
+	if err := validators.ValidatePhone(phone); err != nil {
+		return false, err
+	}
+
+	otpService := services.NewOTPService(r.DB, r.SMS)
+	if err := otpService.RequestPasswordReset(ctx, strings.TrimSpace(phone)); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// ResetPassword is the resolver for the resetPassword field.
+func (r *mutationResolver) ResetPassword(ctx context.Context, phone string, code string, newPassword string) (bool, error) {
+	if err := validators.ValidateResetPasswordInput(phone, code, newPassword); err != nil {
+		return false, err
 	}
 
-	// Extract claims
-	claims, ok := token.Claims.(*utils.JwtCustomClaim)
-	if !ok || !token.Valid {
-		return nil, gqlerror.Errorf("Invalid refresh token")
+	otpService := services.NewOTPService(r.DB, r.SMS)
+	if err := otpService.ResetPassword(ctx, strings.TrimSpace(phone), strings.TrimSpace(code), newPassword); err != nil {
+		return false, err
 	}
 
-	// Get user from database
-	user, err := r.DB.FindUserByID(claims.ID)
+	return true, nil
+}
+
+// VerifyTwoFactorLogin is the resolver for the verifyTwoFactorLogin field.
+func (r *mutationResolver) VerifyTwoFactorLogin(ctx context.Context, challengeToken string, code string, deviceName *string) (*model.AuthResponse, error) {
+	if err := validators.ValidateTwoFactorCode(code); err != nil {
+		return nil, err
+	}
+	device := middlewares.ClientInfo(ctx)
+	if deviceName != nil {
+		if err := validators.ValidateString(*deviceName, "Device name", false, 0, 100); err != nil {
+			return nil, err
+		}
+		device.DeviceName = strings.TrimSpace(*deviceName)
+	}
+	authService := services.NewAuthService(r.DB)
+	response, err := authService.VerifyTwoFactorLogin(ctx, challengeToken, code, device)
 	if err != nil {
-		return nil, gqlerror.Errorf("User not found")
+		return nil, err
 	}
 
-	// Check if user is blocked
-	if user.IsBlocked {
-		return nil, gqlerror.Errorf("User is blocked")
+	return convertAuthResponseToGraphQL(response), nil
+}
+
+// SwitchCompany is the resolver for the switchCompany field.
+func (r *mutationResolver) SwitchCompany(ctx context.Context, companyID string, deviceName *string) (*model.AuthResponse, error) {
+	if err := validators.ValidateObjectID(companyID, "Company ID"); err != nil {
+		return nil, err
+	}
+	device := middlewares.ClientInfo(ctx)
+	if deviceName != nil {
+		if err := validators.ValidateString(*deviceName, "Device name", false, 0, 100); err != nil {
+			return nil, err
+		}
+		device.DeviceName = strings.TrimSpace(*deviceName)
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
 	}
 
-	// Convert storeIDs to strings
-	storeIDs := make([]string, len(user.StoreIDs))
-	for i, id := range user.StoreIDs {
-		storeIDs[i] = id.Hex()
+	claims := middlewares.CtxValue(ctx)
+	if claims.APIKeyID != "" {
+		return nil, gqlerror.Errorf("API keys cannot switch company")
 	}
 
-	assignedStoreID := ""
-	if user.AssignedStoreID != nil {
-		assignedStoreID = user.AssignedStoreID.Hex()
+	companyObjectID, _ := primitive.ObjectIDFromHex(companyID)
+	response, err := services.NewAuthService(r.DB).SwitchCompany(ctx, claims, companyObjectID, device)
+	if err != nil {
+		return nil, err
 	}
 
-	// Generate new access token
-	accessToken, err := utils.JwtGenerate(ctx, user.ID.Hex(), user.CompanyID.Hex(), user.Role, storeIDs, assignedStoreID)
+	return convertAuthResponseToGraphQL(response), nil
+}
+
+// SetupTwoFactor is the resolver for the setupTwoFactor field.
+func (r *mutationResolver) SetupTwoFactor(ctx context.Context, challengeToken *string) (*model.TwoFactorSetup, error) {
+	// Logged in, or in the middle of a login that requires the setup
+	var user *database.User
+	var err error
+	if challengeToken != nil && *challengeToken != "" {
+		user, err = services.NewAuthService(r.DB).ChallengeUser(ctx, *challengeToken, utils.TokenTypeTwoFactorSetup)
+	} else {
+		user, err = r.RequireAuthenticated(ctx)
+	}
 	if err != nil {
-		return nil, gqlerror.Errorf("Error generating access token: %v", err)
+		return nil, err
 	}
 
-	// Generate new refresh token
-	newRefreshToken, err := utils.JwtGenerateRefresh(ctx, user.ID.Hex(), user.CompanyID.Hex(), user.Role, storeIDs, assignedStoreID)
+	setup, err := services.NewTwoFactorService(r.DB).Setup(ctx, user)
 	if err != nil {
-		return nil, gqlerror.Errorf("Error generating refresh token: %v", err)
+		return nil, err
 	}
 
-	return &model.AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: newRefreshToken,
-		User:         convertUserToGraphQL(user),
+	return &model.TwoFactorSetup{
+		Secret:     setup.Secret,
+		OtpauthURI: setup.OtpauthURI,
 	}, nil
 }
 
-// Logout is the resolver for the logout field.
-func (r *mutationResolver) Logout(ctx context.Context) (bool, error) {
-	// JWT is stateless, so logout is just a success response
-	// Client should remove the token
+// ConfirmTwoFactor is the resolver for the confirmTwoFactor field.
+func (r *mutationResolver) ConfirmTwoFactor(ctx context.Context, code string, challengeToken *string, deviceName *string) (*model.TwoFactorActivation, error) {
+	if err := validators.ValidateTwoFactorCode(code); err != nil {
+		return nil, err
+	}
+
+	// Setup required at login: the activation opens the session
+	if challengeToken != nil && *challengeToken != "" {
+		device := middlewares.ClientInfo(ctx)
+		if deviceName != nil {
+			if err := validators.ValidateString(*deviceName, "Device name", false, 0, 100); err != nil {
+				return nil, err
+			}
+			device.DeviceName = strings.TrimSpace(*deviceName)
+		}
+		authService := services.NewAuthService(r.DB)
+		response, recoveryCodes, err := authService.CompleteTwoFactorSetup(ctx, *challengeToken, code, device)
+		if err != nil {
+			return nil, err
+		}
+		return &model.TwoFactorActivation{
+			RecoveryCodes: recoveryCodes,
+			Auth:          convertAuthResponseToGraphQL(response),
+		}, nil
+	}
+
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := services.NewTwoFactorService(r.DB).Confirm(ctx, currentUser, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.TwoFactorActivation{RecoveryCodes: recoveryCodes}, nil
+}
+
+// DisableTwoFactor is the resolver for the disableTwoFactor field.
+func (r *mutationResolver) DisableTwoFactor(ctx context.Context, code string) (bool, error) {
+	if err := validators.ValidateTwoFactorCode(code); err != nil {
+		return false, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if err := services.NewTwoFactorService(r.DB).Disable(ctx, currentUser, code); err != nil {
+		return false, err
+	}
+
 	return true, nil
 }
 
+// RegenerateRecoveryCodes is the resolver for the regenerateRecoveryCodes field.
+func (r *mutationResolver) RegenerateRecoveryCodes(ctx context.Context, code string) ([]string, error) {
+	if err := validators.ValidateTwoFactorCode(code); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return services.NewTwoFactorService(r.DB).RegenerateRecoveryCodes(ctx, currentUser, code)
+}
+
 // CreateUser is the resolver for the createUser field.
 func (r *mutationResolver) CreateUser(ctx context.Context, input model.CreateUserInput) (*model.User, error) {
 	if err := validators.ValidateCreateUserInput(&input); err != nil {
@@ -133,9 +345,14 @@ func (r *mutationResolver) CreateUser(ctx context.Context, input model.CreateUse
 		return nil, err
 	}
 
-	// Only Admin can create users
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can create users")
+	// Requires the user.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionUserManage); err != nil {
+		return nil, err
+	}
+
+	// Only an Admin can grant the built-in Admin role
+	if input.Role == database.RoleAdmin && currentUser.Role != database.RoleAdmin {
+		return nil, gqlerror.Errorf("Only Admin can grant the Admin role")
 	}
 
 	// Check subscription (trial or license)
@@ -202,9 +419,19 @@ func (r *mutationResolver) UpdateUser(ctx context.Context, id string, input mode
 		return nil, err
 	}
 
-	// Only Admin can update users
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can update users")
+	// Requires the user.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionUserManage); err != nil {
+		return nil, err
+	}
+
+	// Only an Admin can manage an Admin or a user with more permissions
+	if _, err := r.RequireManageableUser(ctx, currentUser, id); err != nil {
+		return nil, err
+	}
+
+	// Only an Admin can grant the built-in Admin role
+	if input.Role != nil && *input.Role == database.RoleAdmin && currentUser.Role != database.RoleAdmin {
+		return nil, gqlerror.Errorf("Only Admin can grant the Admin role")
 	}
 
 	var assignedStoreID *primitive.ObjectID
@@ -218,6 +445,7 @@ func (r *mutationResolver) UpdateUser(ctx context.Context, id string, input mode
 
 	user, err := r.DB.UpdateUser(
 		id,
+		currentUser.CompanyID,
 		input.Name,
 		input.Phone,
 		nil, // Email removed from schema
@@ -238,12 +466,18 @@ func (r *mutationResolver) DeleteUser(ctx context.Context, id string) (bool, err
 		return false, err
 	}
 
-	// Only Admin can delete users
-	if currentUser.Role != "Admin" {
-		return false, gqlerror.Errorf("Only Admin can delete users")
+	// Requires the user.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionUserManage); err != nil {
+		return false, err
+	}
+
+	// Only an Admin can manage an Admin or a user with more permissions
+	if _, err := r.RequireManageableUser(ctx, currentUser, id); err != nil {
+		return false, err
 	}
 
-	err = r.DB.DeleteUser(id)
+	// The account of a member of other companies is kept
+	_, err = r.DB.RemoveUserFromCompany(id, currentUser.CompanyID)
 	if err != nil {
 		return false, err
 	}
@@ -261,9 +495,14 @@ func (r *mutationResolver) BlockUser(ctx context.Context, id string) (*model.Use
 		return nil, err
 	}
 
-	// Only Admin can block users
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can block users")
+	// Requires the user.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionUserManage); err != nil {
+		return nil, err
+	}
+
+	// Only a member of the company, an Admin or a user with more permissions only by an Admin
+	if _, err := r.RequireManageableUser(ctx, currentUser, id); err != nil {
+		return nil, err
 	}
 
 	user, err := r.DB.BlockUser(id)
@@ -271,6 +510,11 @@ func (r *mutationResolver) BlockUser(ctx context.Context, id string) (*model.Use
 		return nil, err
 	}
 
+	// A blocked user is logged out everywhere right away
+	if _, err := r.DB.RevokeUserTokens(user.ID, database.TokenRevokedBlocked); err != nil {
+		return nil, err
+	}
+
 	return convertUserToGraphQL(user), nil
 }
 
@@ -284,9 +528,14 @@ func (r *mutationResolver) UnblockUser(ctx context.Context, id string) (*model.U
 		return nil, err
 	}
 
-	// Only Admin can unblock users
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can unblock users")
+	// Requires the user.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionUserManage); err != nil {
+		return nil, err
+	}
+
+	// Only a member of the company, an Admin or a user with more permissions only by an Admin
+	if _, err := r.RequireManageableUser(ctx, currentUser, id); err != nil {
+		return nil, err
 	}
 
 	user, err := r.DB.UnblockUser(id)
@@ -310,9 +559,9 @@ func (r *mutationResolver) AssignUserToStore(ctx context.Context, userID string,
 		return nil, err
 	}
 
-	// Only Admin can assign users to stores
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can assign users to stores")
+	// Requires the user.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionUserManage); err != nil {
+		return nil, err
 	}
 
 	storeObjectID, err := primitive.ObjectIDFromHex(storeID)
@@ -320,7 +569,7 @@ func (r *mutationResolver) AssignUserToStore(ctx context.Context, userID string,
 		return nil, gqlerror.Errorf("Invalid store ID")
 	}
 
-	user, err := r.DB.AssignUserToStore(userID, storeObjectID)
+	user, err := r.DB.AssignUserToStore(userID, currentUser.CompanyID, storeObjectID)
 	if err != nil {
 		return nil, err
 	}
@@ -348,9 +597,9 @@ func (r *mutationResolver) ChangePassword(ctx context.Context, input model.Chang
 	return true, nil
 }
 
-// CreateCompany is the resolver for the createCompany field.
-func (r *mutationResolver) CreateCompany(ctx context.Context, input model.CreateCompanyInput) (*model.Company, error) {
-	if err := validators.ValidateCreateCompanyInput(&input); err != nil {
+// CreateInvitation is the resolver for the createInvitation field.
+func (r *mutationResolver) CreateInvitation(ctx context.Context, input model.CreateInvitationInput) (*model.CreatedInvitation, error) {
+	if err := validators.ValidateCreateInvitationInput(&input); err != nil {
 		return nil, err
 	}
 	currentUser, err := r.RequireAuthenticated(ctx)
@@ -358,232 +607,376 @@ func (r *mutationResolver) CreateCompany(ctx context.Context, input model.Create
 		return nil, err
 	}
 
-	// Check if user already has a company
-	if currentUser.CompanyID != primitive.NilObjectID {
-		return nil, gqlerror.Errorf("User already has a company. Use updateCompany to modify it.")
+	// Check subscription (trial or license)
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
 	}
 
-	// Create company
-	company, err := r.DB.CreateCompany(
-		input.Name,
-		input.Address,
-		input.Phone,
-		input.Description,
-		input.Type,
-		input.Email,
-		input.Logo,
-		input.Rccm,
-		input.IDNat,
-		input.IDCommerce,
-	)
+	invite := services.InviteInput{Role: input.Role}
+	if input.Phone != nil {
+		invite.Phone = strings.TrimSpace(*input.Phone)
+	}
+	if input.RoleID != nil {
+		roleID, _ := primitive.ObjectIDFromHex(*input.RoleID)
+		invite.RoleID = &roleID
+	}
+	if input.StoreID != nil {
+		storeID, _ := primitive.ObjectIDFromHex(*input.StoreID)
+		invite.StoreID = &storeID
+	}
+
+	created, err := services.NewInvitationService(r.DB, r.SMS).Invite(ctx, currentUser, invite)
 	if err != nil {
 		return nil, err
 	}
 
-	// Create trial subscription automatically
-	_, err = r.DB.CreateTrialSubscription(company.ID)
+	return &model.CreatedInvitation{
+		Code:       created.Code,
+		Link:       optionalString(created.Link),
+		Invitation: convertInvitationToGraphQL(created.Invitation),
+	}, nil
+}
+
+// RevokeInvitation is the resolver for the revokeInvitation field.
+func (r *mutationResolver) RevokeInvitation(ctx context.Context, id string) (*model.Invitation, error) {
+	if err := validators.ValidateObjectID(id, "Invitation ID"); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
-		utils.LogError(err, "Error creating trial subscription")
-		// Continue anyway, subscription can be created later
+		return nil, err
 	}
 
-	// Update user's company ID
-	err = r.DB.UpdateUserCompanyID(currentUser.ID.Hex(), company.ID)
+	invitation, err := r.DB.RevokeInvitation(id, currentUser.CompanyID)
 	if err != nil {
-		utils.LogError(err, "Error updating user company ID")
-		return nil, gqlerror.Errorf("Error associating company with user")
+		return nil, err
 	}
 
-	return convertCompanyToGraphQL(company, r.DB, true), nil
+	return convertInvitationToGraphQL(invitation), nil
 }
 
-// UpdateCompany is the resolver for the updateCompany field.
-func (r *mutationResolver) UpdateCompany(ctx context.Context, input model.UpdateCompanyInput) (*model.Company, error) {
-	if err := validators.ValidateUpdateCompanyInput(&input); err != nil {
+// AcceptInvitation is the resolver for the acceptInvitation field.
+func (r *mutationResolver) AcceptInvitation(ctx context.Context, code string) (*model.Membership, error) {
+	if err := validators.ValidateInvitationCode(code); err != nil {
 		return nil, err
 	}
 	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
-
-	// Only Admin can update company
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can update company")
+	if middlewares.CtxValue(ctx).APIKeyID != "" {
+		return nil, gqlerror.Errorf("API keys cannot accept invitations")
 	}
 
-	var licenseID *string
-	if input.LicenseID != nil {
-		licenseID = input.LicenseID
-	}
-	company, err := r.DB.UpdateCompany(
-		currentUser.CompanyID.Hex(),
-		input.Name,
-		input.Address,
-		input.Phone,
-		input.Email,
-		input.Description,
-		input.Type,
-		input.Logo,
-		input.Rccm,
-		input.IDNat,
-		input.IDCommerce,
-		licenseID,
-	)
+	membership, err := r.DB.AcceptInvitation(code, currentUser)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertCompanyToGraphQL(company, r.DB, true), nil
+	return convertMembershipToGraphQL(membership, membership.CompanyID == currentUser.CompanyID, r.DB), nil
 }
 
-// DeleteCompany is the resolver for the deleteCompany field.
-func (r *mutationResolver) DeleteCompany(ctx context.Context) (bool, error) {
+// CreateRole is the resolver for the createRole field.
+func (r *mutationResolver) CreateRole(ctx context.Context, input model.RoleInput) (*model.Role, error) {
+	if err := validators.ValidateRoleInput(&input); err != nil {
+		return nil, err
+	}
 	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	// Only Admin can delete company
-	if currentUser.Role != "Admin" {
-		return false, gqlerror.Errorf("Only Admin can delete company")
+	description := ""
+	if input.Description != nil {
+		description = *input.Description
 	}
 
-	// Check if user has a company
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return false, gqlerror.Errorf("User does not have a company to delete")
+	role, err := r.DB.CreateRole(currentUser.CompanyID, strings.TrimSpace(input.Name), description, input.Permissions)
+	if err != nil {
+		return nil, err
 	}
 
-	// Delete company
-	err = r.DB.DeleteCompany(currentUser.CompanyID.Hex())
+	return convertRoleToGraphQL(role), nil
+}
+
+// UpdateRole is the resolver for the updateRole field.
+func (r *mutationResolver) UpdateRole(ctx context.Context, id string, input model.RoleInput) (*model.Role, error) {
+	if err := validators.ValidateObjectID(id, "Role ID"); err != nil {
+		return nil, err
+	}
+	if err := validators.ValidateRoleInput(&input); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
-		return false, err
+		return nil, err
+	}
+
+	name := strings.TrimSpace(input.Name)
+	role, err := r.DB.UpdateRole(id, currentUser.CompanyID, &name, input.Description, input.Permissions)
+	if err != nil {
+		return nil, err
 	}
 
-	// Update user's company ID to NilObjectID
-	err = r.DB.UpdateUserCompanyID(currentUser.ID.Hex(), primitive.NilObjectID)
+	return convertRoleToGraphQL(role), nil
+}
+
+// DeleteRole is the resolver for the deleteRole field.
+func (r *mutationResolver) DeleteRole(ctx context.Context, id string) (bool, error) {
+	if err := validators.ValidateObjectID(id, "Role ID"); err != nil {
+		return false, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
-		utils.LogError(err, "Error updating user company ID after deletion")
-		// Don't fail the deletion, just log the error
+		return false, err
+	}
+
+	if err := r.DB.DeleteRole(id, currentUser.CompanyID); err != nil {
+		return false, err
 	}
 
 	return true, nil
 }
 
-// CreateSubscription is the resolver for the createSubscription field.
-func (r *mutationResolver) CreateSubscription(ctx context.Context, plan string, paymentMethod string, paymentID string) (*model.CompanySubscription, error) {
+// CreateDefaultRoles is the resolver for the createDefaultRoles field.
+func (r *mutationResolver) CreateDefaultRoles(ctx context.Context) ([]*model.Role, error) {
 	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return nil, gqlerror.Errorf("User does not have a company yet")
+	roles, err := r.DB.CreateDefaultRoles(currentUser.CompanyID)
+	if err != nil {
+		return nil, err
 	}
 
-	if plan == "" {
-		return nil, gqlerror.Errorf("Plan is required")
+	result := make([]*model.Role, 0, len(roles))
+	for _, role := range roles {
+		result = append(result, convertRoleToGraphQL(role))
 	}
-	if paymentMethod == "" {
-		return nil, gqlerror.Errorf("Payment method is required")
+
+	return result, nil
+}
+
+// AssignUserRole is the resolver for the assignUserRole field.
+func (r *mutationResolver) AssignUserRole(ctx context.Context, userID string, roleID *string) (*model.User, error) {
+	if err := validators.ValidateObjectID(userID, "User ID"); err != nil {
+		return nil, err
 	}
-	if paymentID == "" {
-		return nil, gqlerror.Errorf("Payment ID is required")
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	if _, err := r.DB.GetSubscriptionPlanByID(plan); err != nil {
+	// Verify user belongs to same company
+	user, err := r.DB.FindUserInCompany(userID, currentUser.CompanyID)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := r.DB.SetLicenseID(currentUser.CompanyID.Hex(), paymentID); err != nil {
+	// null or the built-in "User" role: back to the default permissions
+	var roleObjectID *primitive.ObjectID
+	if roleID != nil && *roleID != database.RoleUser {
+		if err := validators.ValidateObjectID(*roleID, "Role ID"); err != nil {
+			return nil, err
+		}
+		id, _ := primitive.ObjectIDFromHex(*roleID)
+		roleObjectID = &id
+	}
+
+	user, err = r.DB.AssignUserRole(userID, currentUser.CompanyID, roleObjectID)
+	if err != nil {
 		return nil, err
 	}
 
-	subscription, err := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
+	return convertUserToGraphQL(user), nil
+}
+
+// CreateAPIKey is the resolver for the createAPIKey field.
+func (r *mutationResolver) CreateAPIKey(ctx context.Context, input model.CreateAPIKeyInput) (*model.CreatedAPIKey, error) {
+	if err := validators.ValidateCreateAPIKeyInput(&input); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
-		subscription, err = r.DB.CreateTrialSubscription(currentUser.CompanyID)
-		if err != nil {
+		return nil, err
+	}
+
+	// Check subscription (trial or license)
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	// A key cannot reach a store or grant a permission its creator does not have
+	storeIDs := make([]primitive.ObjectID, 0, len(input.StoreIds))
+	for _, storeID := range input.StoreIds {
+		if err := r.RequireStoreAccess(ctx, storeID); err != nil {
+			return nil, err
+		}
+		storeObjectID, _ := primitive.ObjectIDFromHex(storeID)
+		storeIDs = append(storeIDs, storeObjectID)
+	}
+	for _, permission := range input.Permissions {
+		if err := r.RequirePermission(ctx, strings.TrimSpace(permission)); err != nil {
 			return nil, err
 		}
 	}
 
-	if subscription.Status != "active" {
-		if err := r.DB.UpdateSubscriptionStatus(subscription.ID.Hex(), "active"); err != nil {
+	var expiresAt *time.Time
+	if input.ExpiresAt != nil && *input.ExpiresAt != "" {
+		expiry, err := parseInputDate(*input.ExpiresAt)
+		if err != nil {
 			return nil, err
 		}
-		subscription.Status = "active"
+		expiresAt = &expiry
 	}
 
-	return convertSubscriptionToGraphQL(subscription), nil
+	apiKey, rawKey, err := r.DB.CreateAPIKey(currentUser.CompanyID, currentUser.ID, input.Name, storeIDs, input.Permissions, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.CreatedAPIKey{
+		Key:    rawKey,
+		APIKey: convertAPIKeyToGraphQL(apiKey),
+	}, nil
 }
 
-// UpgradeSubscription is the resolver for the upgradeSubscription field.
-func (r *mutationResolver) UpgradeSubscription(ctx context.Context, plan string, paymentMethod string, paymentID string) (*model.CompanySubscription, error) {
+// RevokeAPIKey is the resolver for the revokeAPIKey field.
+func (r *mutationResolver) RevokeAPIKey(ctx context.Context, id string) (*model.APIKey, error) {
+	if err := validators.ValidateObjectID(id, "API key ID"); err != nil {
+		return nil, err
+	}
 	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return nil, gqlerror.Errorf("User does not have a company yet")
+	apiKey, err := r.DB.RevokeAPIKey(id, currentUser.CompanyID)
+	if err != nil {
+		return nil, err
 	}
 
-	if plan == "" {
-		return nil, gqlerror.Errorf("Plan is required")
+	return convertAPIKeyToGraphQL(apiKey), nil
+}
+
+// CreateCompany is the resolver for the createCompany field.
+func (r *mutationResolver) CreateCompany(ctx context.Context, input model.CreateCompanyInput) (*model.Company, error) {
+	if err := validators.ValidateCreateCompanyInput(&input); err != nil {
+		return nil, err
 	}
-	if paymentMethod == "" {
-		return nil, gqlerror.Errorf("Payment method is required")
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
 	}
-	if paymentID == "" {
-		return nil, gqlerror.Errorf("Payment ID is required")
+
+	// Check if user already has a company
+	if currentUser.CompanyID != primitive.NilObjectID {
+		return nil, gqlerror.Errorf("User already has a company. Use updateCompany to modify it.")
 	}
 
-	if _, err := r.DB.GetSubscriptionPlanByID(plan); err != nil {
+	// Create company
+	company, err := r.DB.CreateCompany(
+		input.Name,
+		input.Address,
+		input.Phone,
+		input.Description,
+		input.Type,
+		input.Email,
+		input.Logo,
+		input.Rccm,
+		input.IDNat,
+		input.IDCommerce,
+	)
+	if err != nil {
 		return nil, err
 	}
 
-	if err := r.DB.SetLicenseID(currentUser.CompanyID.Hex(), paymentID); err != nil {
-		return nil, err
+	// Create trial subscription automatically
+	_, err = r.DB.CreateTrialSubscription(company.ID)
+	if err != nil {
+		utils.LogError(err, "Error creating trial subscription")
+		// Continue anyway, subscription can be created later
 	}
 
-	subscription, err := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
+	// Update user's company ID
+	err = r.DB.UpdateUserCompanyID(currentUser.ID.Hex(), company.ID)
 	if err != nil {
-		subscription, err = r.DB.CreateTrialSubscription(currentUser.CompanyID)
-		if err != nil {
-			return nil, err
-		}
+		utils.LogError(err, "Error updating user company ID")
+		return nil, gqlerror.Errorf("Error associating company with user")
 	}
 
-	if subscription.Status != "active" {
-		if err := r.DB.UpdateSubscriptionStatus(subscription.ID.Hex(), "active"); err != nil {
-			return nil, err
-		}
-		subscription.Status = "active"
+	return convertCompanyToGraphQL(company, r.DB, true), nil
+}
+
+// UpdateCompany is the resolver for the updateCompany field.
+func (r *mutationResolver) UpdateCompany(ctx context.Context, input model.UpdateCompanyInput) (*model.Company, error) {
+	if err := validators.ValidateUpdateCompanyInput(&input); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	return convertSubscriptionToGraphQL(subscription), nil
+	// Requires the company.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionCompanyManage); err != nil {
+		return nil, err
+	}
+
+	var licenseID *string
+	if input.LicenseID != nil {
+		licenseID = input.LicenseID
+	}
+	company, err := r.DB.UpdateCompany(
+		currentUser.CompanyID.Hex(),
+		input.Name,
+		input.Address,
+		input.Phone,
+		input.Email,
+		input.Description,
+		input.Type,
+		input.Logo,
+		input.Rccm,
+		input.IDNat,
+		input.IDCommerce,
+		licenseID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertCompanyToGraphQL(company, r.DB, true), nil
 }
 
-// CancelSubscription is the resolver for the cancelSubscription field.
-func (r *mutationResolver) CancelSubscription(ctx context.Context) (bool, error) {
+// DeleteCompany is the resolver for the deleteCompany field.
+func (r *mutationResolver) DeleteCompany(ctx context.Context) (bool, error) {
 	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return false, err
 	}
 
+	// Requires the company.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionCompanyManage); err != nil {
+		return false, err
+	}
+
+	// Check if user has a company
 	if currentUser.CompanyID == primitive.NilObjectID {
-		return false, gqlerror.Errorf("User does not have a company yet")
+		return false, gqlerror.Errorf("User does not have a company to delete")
 	}
 
-	if err := r.DB.SetLicenseID(currentUser.CompanyID.Hex(), ""); err != nil {
+	// Delete company
+	err = r.DB.DeleteCompany(currentUser.CompanyID.Hex())
+	if err != nil {
 		return false, err
 	}
 
-	subscription, err := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
-	if err == nil && subscription != nil && subscription.Status != "expired" {
-		if err := r.DB.UpdateSubscriptionStatus(subscription.ID.Hex(), "expired"); err != nil {
-			return false, err
-		}
+	// Every member leaves the company (the active company of the others changes, or becomes none)
+	err = r.DB.RemoveCompanyMembers(currentUser.CompanyID)
+	if err != nil {
+		utils.LogError(err, "Error removing company members after deletion")
+		return false, err
 	}
 
 	return true, nil
@@ -596,9 +989,9 @@ func (r *mutationResolver) UpdateExchangeRates(ctx context.Context, rates []*mod
 		return nil, err
 	}
 
-	// Only Admin can update exchange rates
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can update exchange rates")
+	// Requires the company.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionCompanyManage); err != nil {
+		return nil, err
 	}
 
 	// Verify that the user has a company
@@ -630,6 +1023,30 @@ func (r *mutationResolver) UpdateExchangeRates(ctx context.Context, rates []*mod
 	return convertCompanyToGraphQL(company, r.DB, true), nil
 }
 
+// SetAdminTwoFactorPolicy is the resolver for the setAdminTwoFactorPolicy field.
+func (r *mutationResolver) SetAdminTwoFactorPolicy(ctx context.Context, required bool) (*model.Company, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Requires the company.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionCompanyManage); err != nil {
+		return nil, err
+	}
+
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return nil, gqlerror.Errorf("User does not belong to a company")
+	}
+
+	company, err := r.DB.SetCompanyAdminTwoFactor(currentUser.CompanyID, required)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertCompanyToGraphQL(company, r.DB, true), nil
+}
+
 // CreateStore is the resolver for the createStore field.
 func (r *mutationResolver) CreateStore(ctx context.Context, input model.CreateStoreInput) (*model.Store, error) {
 	if err := validators.ValidateCreateStoreInput(&input); err != nil {
@@ -640,9 +1057,9 @@ func (r *mutationResolver) CreateStore(ctx context.Context, input model.CreateSt
 		return nil, err
 	}
 
-	// Only Admin can create stores
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can create stores")
+	// Requires the store.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionStoreManage); err != nil {
+		return nil, err
 	}
 
 	// Verify that the user has a company
@@ -690,7 +1107,7 @@ func (r *mutationResolver) CreateStore(ctx context.Context, input model.CreateSt
 				for _, s := range stores {
 					storeIDs = append(storeIDs, s.ID)
 				}
-				r.DB.UpdateUserStoreIDs(admin.ID.Hex(), storeIDs)
+				r.DB.UpdateUserStoreIDs(admin.ID.Hex(), currentUser.CompanyID, storeIDs)
 			}
 		}
 	}
@@ -711,9 +1128,9 @@ func (r *mutationResolver) UpdateStore(ctx context.Context, id string, input mod
 		return nil, err
 	}
 
-	// Only Admin can update stores
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can update stores")
+	// Requires the store.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionStoreManage); err != nil {
+		return nil, err
 	}
 
 	// Verify store access
@@ -750,12 +1167,9 @@ func (r *mutationResolver) DeleteStore(ctx context.Context, id string) (bool, er
 		return false, err
 	}
 
-	// Only Admin can delete stores
-	if err := r.RequireAdmin(ctx); err != nil {
+	if err := r.RequirePermission(ctx, database.PermissionStoreManage); err != nil {
 		return false, err
 	}
-	// Re-get user after RequireAdmin check (we know it's valid from RequireAdmin)
-	currentUser, _ = r.RequireAuthenticated(ctx)
 
 	// Verify store access
 	hasAccess, err := r.DB.VerifyStoreAccess(id, currentUser.CompanyID.Hex())
@@ -795,9 +1209,19 @@ func (r *mutationResolver) CreateProduct(ctx context.Context, input model.Create
 		return nil, gqlerror.Errorf("Invalid store ID")
 	}
 
+	var barcode, sku string
+	if input.Barcode != nil {
+		barcode = *input.Barcode
+	}
+	if input.Sku != nil {
+		sku = *input.Sku
+	}
+
 	product, err := r.DB.CreateProduct(
 		input.Name,
 		input.Mark,
+		barcode,
+		sku,
 		storeID,
 	)
 	if err != nil {
@@ -833,6 +1257,8 @@ func (r *mutationResolver) UpdateProduct(ctx context.Context, id string, input m
 		id,
 		input.Name,
 		input.Mark,
+		input.Barcode,
+		input.Sku,
 	)
 	if err != nil {
 		return nil, err
@@ -869,182 +1295,207 @@ func (r *mutationResolver) DeleteProduct(ctx context.Context, id string) (bool,
 }
 
 // SupplyStock is the resolver for the supplyStock field.
-func (r *mutationResolver) SupplyStock(ctx context.Context, input model.StockSupplyInput) (*model.StockSupply, error) {
-	currentUser, err := r.RequireAuthenticated(ctx)
-	if err != nil {
-		return nil, err
-	}
-
-	// Validate input
-	if err := validators.ValidateObjectID(input.ProductID, "Product ID"); err != nil {
-		return nil, err
-	}
-	if err := validators.ValidateObjectID(input.StoreID, "Store ID"); err != nil {
-		return nil, err
-	}
-	if err := validators.ValidateObjectID(input.ProviderID, "Provider ID"); err != nil {
-		return nil, err
-	}
-	if input.Quantity <= 0 {
-		return nil, gqlerror.Errorf("Quantity must be greater than 0")
-	}
-	if input.PriceAchat <= 0 {
-		return nil, gqlerror.Errorf("Price d'achat must be greater than 0")
-	}
-	if input.PriceVente < input.PriceAchat {
-		return nil, gqlerror.Errorf("Price de vente must be >= price d'achat")
-	}
-	if input.PaymentType != "cash" && input.PaymentType != "debt" {
-		return nil, gqlerror.Errorf("Payment type must be 'cash' or 'debt'")
-	}
-	if input.PaymentType == "debt" && (input.AmountPaid == nil || *input.AmountPaid < 0) {
-		return nil, gqlerror.Errorf("Amount paid is required when payment type is 'debt'")
-	}
+func (r *mutationResolver) SupplyStock(ctx context.Context, input model.StockSupplyInput, idempotencyKey *string) (*model.StockSupply, error) {
+	return idempotent(ctx, r.Resolver, "supplyStock", idempotencyKey, input, func() (*model.StockSupply, error) {
+		currentUser, err := r.RequireAuthenticated(ctx)
+		if err != nil {
+			return nil, err
+		}
 
-	// Verify store access
-	if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
-		return nil, err
-	}
-
-	// Convert IDs
-	productID, _ := primitive.ObjectIDFromHex(input.ProductID)
-	storeID, _ := primitive.ObjectIDFromHex(input.StoreID)
-	providerID, _ := primitive.ObjectIDFromHex(input.ProviderID)
-	operatorID := currentUser.ID
-
-	// Determine currency
-	currency := ""
-	if input.Currency != nil && *input.Currency != "" {
-		currency = *input.Currency
-		isValid, err := r.DB.ValidateStoreCurrency(input.StoreID, currency)
-		if err != nil {
+		// Validate input
+		if err := validators.ValidateObjectID(input.ProductID, "Product ID"); err != nil {
 			return nil, err
 		}
-		if !isValid {
-			return nil, gqlerror.Errorf("Currency %s is not supported by this store", currency)
+		if err := validators.ValidateObjectID(input.StoreID, "Store ID"); err != nil {
+			return nil, err
 		}
-	} else {
-		defaultCurrency, err := r.DB.GetStoreDefaultCurrency(input.StoreID)
-		if err != nil {
+		if err := validators.ValidateObjectID(input.ProviderID, "Provider ID"); err != nil {
+			return nil, err
+		}
+		if input.Quantity <= 0 {
+			return nil, gqlerror.Errorf("Quantity must be greater than 0")
+		}
+		if input.PriceAchat <= 0 {
+			return nil, gqlerror.Errorf("Price d'achat must be greater than 0")
+		}
+		if input.PriceVente < input.PriceAchat {
+			return nil, gqlerror.Errorf("Price de vente must be >= price d'achat")
+		}
+		if input.PaymentType != "cash" && input.PaymentType != "debt" {
+			return nil, gqlerror.Errorf("Payment type must be 'cash' or 'debt'")
+		}
+		if input.PaymentType == "debt" && (input.AmountPaid == nil || *input.AmountPaid < 0) {
+			return nil, gqlerror.Errorf("Amount paid is required when payment type is 'debt'")
+		}
+		if err := validators.ValidateLotInput(input.BatchNumber, input.ExpiryDate); err != nil {
 			return nil, err
 		}
-		currency = defaultCurrency
-	}
 
-	// Parse date
-	date := time.Now()
-	if input.Date != nil && *input.Date != "" {
-		parsedDate, err := time.Parse(time.RFC3339, *input.Date)
-		if err != nil {
-			return nil, gqlerror.Errorf("Invalid date format")
+		// Verify store access
+		if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
+			return nil, err
 		}
-		date = parsedDate
-	}
 
-	// Create or update ProductInStock
-	productInStock, err := r.DB.CreateProductInStock(
-		productID,
-		input.PriceVente,
-		input.PriceAchat,
-		input.Quantity,
-		currency,
-		storeID,
-		providerID,
-	)
-	if err != nil {
-		return nil, err
-	}
+		// Convert IDs
+		productID, _ := primitive.ObjectIDFromHex(input.ProductID)
+		storeID, _ := primitive.ObjectIDFromHex(input.StoreID)
+		providerID, _ := primitive.ObjectIDFromHex(input.ProviderID)
+		operatorID := currentUser.ID
+
+		// Determine currency
+		currency := ""
+		if input.Currency != nil && *input.Currency != "" {
+			currency = *input.Currency
+			isValid, err := r.DB.ValidateStoreCurrency(input.StoreID, currency)
+			if err != nil {
+				return nil, err
+			}
+			if !isValid {
+				return nil, gqlerror.Errorf("Currency %s is not supported by this store", currency)
+			}
+		} else {
+			defaultCurrency, err := r.DB.GetStoreDefaultCurrency(input.StoreID)
+			if err != nil {
+				return nil, err
+			}
+			currency = defaultCurrency
+		}
 
-	// Calculate total amount and debt
-	totalAmount := input.PriceAchat * input.Quantity
-	amountPaid := 0.0
-	if input.PaymentType == "cash" {
-		amountPaid = totalAmount
-	} else if input.AmountPaid != nil {
-		amountPaid = *input.AmountPaid
-	}
-	amountDue := totalAmount - amountPaid
+		// Parse date
+		date := time.Now()
+		if input.Date != nil && *input.Date != "" {
+			parsedDate, err := time.Parse(time.RFC3339, *input.Date)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid date format")
+			}
+			date = parsedDate
+		}
 
-	// Create stock supply first (without debt ID for now)
-	supply, err := r.DB.CreateStockSupply(
-		productID,
-		productInStock.ID,
-		input.Quantity,
-		input.PriceAchat,
-		input.PriceVente,
-		currency,
-		storeID,
-		providerID,
-		operatorID,
-		input.PaymentType,
-		nil, // Will be set after creating debt
-		date,
-	)
-	if err != nil {
-		return nil, err
-	}
+		// Batch number and expiry date (optional): a new batch gets its own ProductInStock
+		batchNumber, expiryDate, err := parseLotInput(input.BatchNumber, input.ExpiryDate)
+		if err != nil {
+			return nil, err
+		}
 
-	// Create provider debt if payment type is "debt" (now we have the supply ID)
-	if input.PaymentType == "debt" && amountDue > 0 {
-		_, err := r.DB.CreateProviderDebt(
-			supply.ID,
-			providerID,
+		// Create or update ProductInStock
+		productInStock, err := r.DB.CreateProductInStock(
+			productID,
+			input.PriceVente,
+			input.PriceAchat,
+			input.Quantity,
+			currency,
 			storeID,
-			totalAmount,
-			amountPaid,
-			amountDue,
+			providerID,
+			batchNumber,
+			expiryDate,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Calculate total amount and debt
+		totalAmount := input.PriceAchat * input.Quantity
+		amountPaid := 0.0
+		if input.PaymentType == "cash" {
+			amountPaid = totalAmount
+		} else if input.AmountPaid != nil {
+			amountPaid = *input.AmountPaid
+		}
+		amountDue := totalAmount - amountPaid
+
+		// Create stock supply first (without debt ID for now)
+		supply, err := r.DB.CreateStockSupply(
+			productID,
+			productInStock.ID,
+			input.Quantity,
+			input.PriceAchat,
+			input.PriceVente,
 			currency,
+			storeID,
+			providerID,
+			operatorID,
+			input.PaymentType,
+			nil, // Will be set after creating debt
+			date,
+			batchNumber,
+			expiryDate,
 		)
 		if err != nil {
 			return nil, err
 		}
-		// Note: providerDebt is created but not currently linked to supply
 
-		// Update supply with debt ID
-		// Note: We would need an UpdateStockSupply function, but for now the debt reference is enough
-		// The supply can be queried with the debt ID from the debt side
-	}
+		// Create provider debt if payment type is "debt" (now we have the supply ID)
+		if input.PaymentType == "debt" && amountDue > 0 {
+			_, err := r.DB.CreateProviderDebt(
+				supply.ID,
+				providerID,
+				storeID,
+				totalAmount,
+				amountPaid,
+				amountDue,
+				currency,
+			)
+			if err != nil {
+				return nil, err
+			}
+			// Note: providerDebt is created but not currently linked to supply
 
-	// Create stock movement (ENTREE)
-	_, err = r.DB.CreateStockMovement(
-		productInStock.ID.Hex(),
-		input.StoreID,
-		"ENTREE",
-		input.Quantity,
-		input.PriceAchat,
-		currency,
-		operatorID,
-		fmt.Sprintf("Approvisionnement - Fournisseur: %s", input.ProviderID),
-		fmt.Sprintf("supply-%s", supply.ID.Hex()),
-		"SUPPLY",
-		&supply.ID,
-	)
-	if err != nil {
-		utils.LogError(err, "Error creating stock movement for supply")
-	}
+			// Update supply with debt ID
+			// Note: We would need an UpdateStockSupply function, but for now the debt reference is enough
+			// The supply can be queried with the debt ID from the debt side
+		}
 
-	// Create caisse transaction (SORTIE) if paid cash
-	if input.PaymentType == "cash" {
-		_, err = r.DB.CreateTrans(
-			"Sortie",
-			totalAmount,
-			fmt.Sprintf("Achat stock - Produit: %s, Fournisseur: %s", input.ProductID, input.ProviderID),
+		// Create stock movement (ENTREE)
+		_, err = r.DB.CreateStockMovement(
+			productInStock.ID.Hex(),
+			input.StoreID,
+			"ENTREE",
+			input.Quantity,
+			input.PriceAchat,
 			currency,
 			operatorID,
-			storeID,
-			&date,
+			fmt.Sprintf("Approvisionnement - Fournisseur: %s", input.ProviderID),
+			fmt.Sprintf("supply-%s", supply.ID.Hex()),
+			"SUPPLY",
+			&supply.ID,
 		)
 		if err != nil {
-			utils.LogError(err, "Error creating caisse transaction for supply")
+			utils.LogError(err, "Error creating stock movement for supply")
 		}
-	}
 
-	return convertStockSupplyToGraphQL(supply, r.DB), nil
+		// Create caisse transaction (SORTIE) if paid cash
+		if input.PaymentType == "cash" {
+			_, err = r.DB.CreatePurchaseTrans(
+				totalAmount,
+				fmt.Sprintf("Achat stock - Produit: %s, Fournisseur: %s", input.ProductID, input.ProviderID),
+				currency,
+				operatorID,
+				storeID,
+				&date,
+			)
+			if err != nil {
+				utils.LogError(err, "Error creating caisse transaction for supply")
+			}
+		}
+
+		// Flag the product for the low stock job (an open alert may be resolved)
+		if err := r.DB.MarkProductsForStockCheck([]primitive.ObjectID{productID}); err != nil {
+			utils.LogError(err, "Error flagging supplied product for stock check")
+		}
+
+		return convertStockSupplyToGraphQL(supply, r.DB), nil
+	})
 }
 
-// CreateClient is the resolver for the createClient field.
-func (r *mutationResolver) CreateClient(ctx context.Context, input model.CreateClientInput) (*model.Client, error) {
-	if err := validators.ValidateCreateClientInput(&input); err != nil {
+// SetReorderPoint is the resolver for the setReorderPoint field.
+func (r *mutationResolver) SetReorderPoint(ctx context.Context, productID string, reorderPoint float64, reorderQuantity float64) (*model.Product, error) {
+	if err := validators.ValidateObjectID(productID, "Product ID"); err != nil {
+		return nil, err
+	}
+	if err := validators.ValidateFloat(reorderPoint, "Reorder point", false, 0, 0); err != nil {
+		return nil, err
+	}
+	if err := validators.ValidateFloat(reorderQuantity, "Reorder quantity", false, 0, 0); err != nil {
 		return nil, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
@@ -1056,212 +1507,225 @@ func (r *mutationResolver) CreateClient(ctx context.Context, input model.CreateC
 		return nil, err
 	}
 
-	// Verify store access
-	if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
+	product, err := r.DB.FindProductByID(productID)
+	if err != nil {
 		return nil, err
 	}
-
-	storeID, err := primitive.ObjectIDFromHex(input.StoreID)
-	if err != nil {
-		return nil, gqlerror.Errorf("Invalid store ID")
+	if err := r.RequireStoreAccessFromProduct(ctx, product); err != nil {
+		return nil, err
 	}
 
-	client, err := r.DB.CreateClient(input.Name, input.Phone, storeID, input.CreditLimit)
+	updatedProduct, err := r.DB.SetProductReorderPoint(productID, reorderPoint, reorderQuantity)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertClientToGraphQL(client, r.DB), nil
+	return convertProductToGraphQL(updatedProduct, r.DB), nil
 }
 
-// UpdateClient is the resolver for the updateClient field.
-func (r *mutationResolver) UpdateClient(ctx context.Context, id string, input model.UpdateClientInput) (*model.Client, error) {
-	if err := validators.ValidateObjectID(id, "Client ID"); err != nil {
-		return nil, err
-	}
-	if err := validators.ValidateUpdateClientInput(&input); err != nil {
-		return nil, err
-	}
-	if _, err := r.RequireAuthenticated(ctx); err != nil {
+// SetLotExpiryWriteOff is the resolver for the setLotExpiryWriteOff field.
+func (r *mutationResolver) SetLotExpiryWriteOff(ctx context.Context, productInStockID string, enabled bool) (*model.ProductInStock, error) {
+	if err := validators.ValidateObjectID(productInStockID, "Product In Stock ID"); err != nil {
 		return nil, err
 	}
-
-	// Get client to verify store access
-	client, err := r.DB.FindClientByID(id)
+	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
-
-	if err := r.RequireStoreAccessFromClient(ctx, client); err != nil {
+	if err := r.RequirePermission(ctx, database.PermissionStockExpiry); err != nil {
 		return nil, err
 	}
 
-	updatedClient, err := r.DB.UpdateClient(id, input.Name, input.Phone, input.CreditLimit)
-	if err != nil {
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
 		return nil, err
 	}
 
-	return convertClientToGraphQL(updatedClient, r.DB), nil
-}
-
-// DeleteClient is the resolver for the deleteClient field.
-func (r *mutationResolver) DeleteClient(ctx context.Context, id string) (bool, error) {
-	if err := validators.ValidateObjectID(id, "Client ID"); err != nil {
-		return false, err
-	}
-	if _, err := r.RequireAuthenticated(ctx); err != nil {
-		return false, err
-	}
-
-	// Get client to verify store access
-	client, err := r.DB.FindClientByID(id)
+	productInStock, err := r.DB.FindProductInStockByID(productInStockID)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-
-	if err := r.RequireStoreAccessFromClient(ctx, client); err != nil {
-		return false, err
+	if err := r.RequireStoreAccess(ctx, productInStock.StoreID.Hex()); err != nil {
+		return nil, err
 	}
 
-	err = r.DB.DeleteClient(id)
+	updated, err := r.DB.SetLotExpiryWriteOff(productInStockID, enabled, currentUser.ID)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return true, nil
+	return convertProductInStockToGraphQL(updated, r.DB), nil
 }
 
-// UpdateClientCreditLimit is the resolver for the updateClientCreditLimit field.
-func (r *mutationResolver) UpdateClientCreditLimit(ctx context.Context, clientID string, creditLimit float64) (*model.Client, error) {
-	if err := validators.ValidateObjectID(clientID, "Client ID"); err != nil {
+// CreatePurchaseOrder is the resolver for the createPurchaseOrder field.
+func (r *mutationResolver) CreatePurchaseOrder(ctx context.Context, input model.CreatePurchaseOrderInput) (*model.PurchaseOrder, error) {
+	if err := validators.ValidateCreatePurchaseOrderInput(&input); err != nil {
 		return nil, err
 	}
-	if _, err := r.RequireAuthenticated(ctx); err != nil {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	// Seuls les admins peuvent modifier les limites de crédit
-	if err := r.RequireAdmin(ctx); err != nil {
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
 		return nil, err
 	}
 
-	// Get client to verify store access
-	client, err := r.DB.FindClientByID(clientID)
-	if err != nil {
+	// Verify store access
+	if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
 		return nil, err
 	}
 
-	hasAccess, err := r.HasStoreAccess(ctx, client.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this client's store")
+	storeID, _ := primitive.ObjectIDFromHex(input.StoreID)
+	providerID, _ := primitive.ObjectIDFromHex(input.ProviderID)
+
+	var lines []database.PurchaseOrderLine
+	for _, line := range input.Lines {
+		productID, _ := primitive.ObjectIDFromHex(line.ProductID)
+		lines = append(lines, database.PurchaseOrderLine{
+			ProductID:       productID,
+			QuantityOrdered: line.Quantity,
+			PriceAchat:      line.PriceAchat,
+			PriceVente:      line.PriceVente,
+		})
 	}
 
-	// Valider que la limite est positive
-	if creditLimit < 0 {
-		return nil, gqlerror.Errorf("Credit limit cannot be negative")
+	currency := ""
+	if input.Currency != nil {
+		currency = *input.Currency
+	}
+	note := ""
+	if input.Note != nil {
+		note = validators.SanitizeString(*input.Note, 500)
+	}
+	var expectedDate *time.Time
+	if input.ExpectedDate != nil && *input.ExpectedDate != "" {
+		date, err := parseInputDate(*input.ExpectedDate)
+		if err != nil {
+			return nil, err
+		}
+		expectedDate = &date
 	}
 
-	// Mettre à jour la limite de crédit
-	updatedClient, err := r.DB.UpdateClientCreditLimit(clientID, creditLimit)
+	order, err := r.DB.CreatePurchaseOrder(storeID, providerID, lines, currency, note, expectedDate, currentUser.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertClientToGraphQL(updatedClient, r.DB), nil
+	return convertPurchaseOrderToGraphQL(order, r.DB), nil
 }
 
-// CreateProvider is the resolver for the createProvider field.
-func (r *mutationResolver) CreateProvider(ctx context.Context, input model.CreateProviderInput) (*model.Provider, error) {
-	if err := validators.ValidateCreateProviderInput(&input); err != nil {
+// SendPurchaseOrder is the resolver for the sendPurchaseOrder field.
+func (r *mutationResolver) SendPurchaseOrder(ctx context.Context, id string) (*model.PurchaseOrder, error) {
+	if err := validators.ValidateObjectID(id, "Purchase Order ID"); err != nil {
 		return nil, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	// Vérifier l'abonnement
-	if err := r.CheckSubscription(ctx); err != nil {
+	order, err := r.DB.GetPurchaseOrderByID(id)
+	if err != nil {
 		return nil, err
 	}
-
-	// Verify store access
-	if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
+	if err := r.RequireStoreAccess(ctx, order.StoreID.Hex()); err != nil {
 		return nil, err
 	}
 
-	storeID, err := primitive.ObjectIDFromHex(input.StoreID)
-	if err != nil {
-		return nil, gqlerror.Errorf("Invalid store ID")
-	}
-
-	provider, err := r.DB.CreateProvider(input.Name, input.Phone, input.Address, storeID)
+	sentOrder, err := r.DB.SendPurchaseOrder(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertProviderToGraphQL(provider, r.DB), nil
+	return convertPurchaseOrderToGraphQL(sentOrder, r.DB), nil
 }
 
-// UpdateProvider is the resolver for the updateProvider field.
-func (r *mutationResolver) UpdateProvider(ctx context.Context, id string, input model.UpdateProviderInput) (*model.Provider, error) {
-	if err := validators.ValidateObjectID(id, "Provider ID"); err != nil {
+// ReceivePurchaseOrder is the resolver for the receivePurchaseOrder field.
+func (r *mutationResolver) ReceivePurchaseOrder(ctx context.Context, id string, input model.ReceivePurchaseOrderInput) (*model.PurchaseOrder, error) {
+	if err := validators.ValidateObjectID(id, "Purchase Order ID"); err != nil {
 		return nil, err
 	}
-	if err := validators.ValidateUpdateProviderInput(&input); err != nil {
+	if err := validators.ValidateReceivePurchaseOrderInput(&input); err != nil {
 		return nil, err
 	}
-	if _, err := r.RequireAuthenticated(ctx); err != nil {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
 		return nil, err
 	}
 
-	// Get provider to verify store access
-	provider, err := r.DB.FindProviderByID(id)
-	if err != nil {
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
 		return nil, err
 	}
 
-	if err := r.RequireStoreAccess(ctx, provider.StoreID.Hex()); err != nil {
+	order, err := r.DB.GetPurchaseOrderByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.RequireStoreAccess(ctx, order.StoreID.Hex()); err != nil {
 		return nil, err
 	}
 
-	updatedProvider, err := r.DB.UpdateProvider(id, input.Name, input.Phone, input.Address)
+	var receipts []database.PurchaseOrderReceipt
+	for _, line := range input.Lines {
+		productID, _ := primitive.ObjectIDFromHex(line.ProductID)
+		batchNumber, expiryDate, err := parseLotInput(line.BatchNumber, line.ExpiryDate)
+		if err != nil {
+			return nil, err
+		}
+		receipts = append(receipts, database.PurchaseOrderReceipt{
+			ProductID:   productID,
+			Quantity:    line.Quantity,
+			BatchNumber: batchNumber,
+			ExpiryDate:  expiryDate,
+		})
+	}
+
+	date := time.Now()
+	if input.Date != nil && *input.Date != "" {
+		date, err = parseInputDate(*input.Date)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	receivedOrder, err := r.DB.ReceivePurchaseOrder(id, receipts, input.PaymentType, date, currentUser.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertProviderToGraphQL(updatedProvider, r.DB), nil
+	return convertPurchaseOrderToGraphQL(receivedOrder, r.DB), nil
 }
 
-// DeleteProvider is the resolver for the deleteProvider field.
-func (r *mutationResolver) DeleteProvider(ctx context.Context, id string) (bool, error) {
-	if err := validators.ValidateObjectID(id, "Provider ID"); err != nil {
-		return false, err
+// CancelPurchaseOrder is the resolver for the cancelPurchaseOrder field.
+func (r *mutationResolver) CancelPurchaseOrder(ctx context.Context, id string) (*model.PurchaseOrder, error) {
+	if err := validators.ValidateObjectID(id, "Purchase Order ID"); err != nil {
+		return nil, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
-		return false, err
+		return nil, err
 	}
 
-	// Get provider to verify store access
-	provider, err := r.DB.FindProviderByID(id)
+	order, err := r.DB.GetPurchaseOrderByID(id)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-
-	if err := r.RequireStoreAccess(ctx, provider.StoreID.Hex()); err != nil {
-		return false, err
+	if err := r.RequireStoreAccess(ctx, order.StoreID.Hex()); err != nil {
+		return nil, err
 	}
 
-	err = r.DB.DeleteProvider(id)
+	cancelledOrder, err := r.DB.CancelPurchaseOrder(id)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return true, nil
+	return convertPurchaseOrderToGraphQL(cancelledOrder, r.DB), nil
 }
 
-// CreateFacture is the resolver for the createFacture field.
-func (r *mutationResolver) CreateFacture(ctx context.Context, input model.CreateFactureInput) (*model.Facture, error) {
-	if err := validators.ValidateCreateFactureInput(&input); err != nil {
+// CreateStockTransfer is the resolver for the createStockTransfer field.
+func (r *mutationResolver) CreateStockTransfer(ctx context.Context, input model.CreateStockTransferInput) (*model.StockTransfer, error) {
+	if err := validators.ValidateCreateStockTransferInput(&input); err != nil {
 		return nil, err
 	}
 	currentUser, err := r.RequireAuthenticated(ctx)
@@ -1274,229 +1738,152 @@ func (r *mutationResolver) CreateFacture(ctx context.Context, input model.Create
 		return nil, err
 	}
 
-	// Verify store access
-	if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
+	// The goods leave the source store: the user must have access to it
+	if err := r.RequireStoreAccess(ctx, input.FromStoreID); err != nil {
 		return nil, err
 	}
 
-	storeID, err := primitive.ObjectIDFromHex(input.StoreID)
-	if err != nil {
-		return nil, gqlerror.Errorf("Invalid store ID")
+	fromStoreID, _ := primitive.ObjectIDFromHex(input.FromStoreID)
+	toStoreID, _ := primitive.ObjectIDFromHex(input.ToStoreID)
+
+	var items []database.StockTransferItem
+	for _, item := range input.Items {
+		productInStockID, err := primitive.ObjectIDFromHex(item.ProductInStockID)
+		if err != nil {
+			return nil, gqlerror.Errorf("Invalid product in stock ID: %s", item.ProductInStockID)
+		}
+		items = append(items, database.StockTransferItem{
+			SourceProductInStockID: productInStockID,
+			Quantity:               item.Quantity,
+		})
 	}
 
-	clientID, err := primitive.ObjectIDFromHex(input.ClientID)
-	if err != nil {
-		return nil, gqlerror.Errorf("Invalid client ID")
+	note := ""
+	if input.Note != nil {
+		note = validators.SanitizeString(*input.Note, 500)
 	}
 
-	// Parse date
-	date, err := time.Parse(time.RFC3339, input.Date)
+	transfer, err := r.DB.CreateStockTransfer(fromStoreID, toStoreID, items, note, currentUser.ID)
 	if err != nil {
-		return nil, gqlerror.Errorf("Invalid date format")
+		return nil, err
 	}
 
-	// Convert products
-	var factureProducts []database.FactureProduct
-	for _, p := range input.Products {
-		productID, err := primitive.ObjectIDFromHex(p.ProductID)
-		if err != nil {
-			return nil, gqlerror.Errorf("Invalid product ID: %s", p.ProductID)
-		}
-
-		// Verify product belongs to store
-		product, err := r.DB.FindProductByID(p.ProductID)
-		if err != nil {
-			return nil, gqlerror.Errorf("Product not found: %s", p.ProductID)
-		}
-		if product.StoreID != storeID {
-			return nil, gqlerror.Errorf("Product %s does not belong to store", p.ProductID)
-		}
-
-		// Note: Factures use Product templates, not ProductInStock
-		// Stock checking is not applicable for factures as they are just invoices
-		// The actual stock management is done through ProductInStock
-
-		factureProducts = append(factureProducts, database.FactureProduct{
-			ProductID: productID,
-			Quantity:  p.Quantity,
-			Price:     p.Price,
-		})
+	return convertStockTransferToGraphQL(transfer, r.DB), nil
+}
 
-		// Note: Stock updates are no longer done here since Product is now just a template
+// ShipStockTransfer is the resolver for the shipStockTransfer field.
+func (r *mutationResolver) ShipStockTransfer(ctx context.Context, id string) (*model.StockTransfer, error) {
+	if err := validators.ValidateObjectID(id, "Stock Transfer ID"); err != nil {
+		return nil, err
 	}
-
-	// Determine currency: use provided currency or default from store
-	currency := ""
-	if input.Currency != nil && *input.Currency != "" {
-		currency = *input.Currency
-		// Validate currency is supported by store
-		isValid, err := r.DB.ValidateStoreCurrency(input.StoreID, currency)
-		if err != nil {
-			return nil, err
-		}
-		if !isValid {
-			return nil, gqlerror.Errorf("Currency %s is not supported by this store. Supported currencies: %v", currency, func() []string {
-				store, _ := r.DB.FindStoreByID(input.StoreID)
-				if store != nil {
-					return store.SupportedCurrencies
-				}
-				return []string{}
-			}())
-		}
-	} else {
-		// Use default currency from store
-		defaultCurrency, err := r.DB.GetStoreDefaultCurrency(input.StoreID)
-		if err != nil {
-			return nil, err
-		}
-		currency = defaultCurrency
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create facture
-	facture := &database.Facture{
-		ID:        primitive.NewObjectID(),
-		Products:  factureProducts,
-		Quantity:  input.Quantity,
-		Date:      date,
-		Price:     input.Price,
-		Currency:  currency,
-		ClientID:  clientID,
-		StoreID:   storeID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
 	}
 
-	createdFacture, err := r.DB.CreateFacture(facture)
+	transfer, err := r.DB.GetStockTransferByID(id)
 	if err != nil {
 		return nil, err
 	}
+	if err := r.RequireStoreAccess(ctx, transfer.FromStoreID.Hex()); err != nil {
+		return nil, err
+	}
 
-	// Automatically create an "Entree" (entry) transaction in caisse when a facture is created
-	// This represents money coming in from the sale
-	_, err = r.DB.CreateTrans(
-		"Entree",
-		input.Price,
-		fmt.Sprintf("Vente facture %s", createdFacture.FactureNumber),
-		currency,
-		currentUser.ID,
-		storeID,
-		&date,
-	)
+	shippedTransfer, err := r.DB.ShipStockTransfer(id, currentUser.ID)
 	if err != nil {
-		// Log error but don't fail the facture creation
-		utils.LogError(err, "Error creating caisse transaction for facture")
+		return nil, err
 	}
 
-	return convertFactureToGraphQL(createdFacture, r.DB), nil
+	return convertStockTransferToGraphQL(shippedTransfer, r.DB), nil
 }
 
-// UpdateFacture is the resolver for the updateFacture field.
-func (r *mutationResolver) UpdateFacture(ctx context.Context, id string, input model.UpdateFactureInput) (*model.Facture, error) {
-	if err := validators.ValidateObjectID(id, "Facture ID"); err != nil {
-		return nil, err
-	}
-	if err := validators.ValidateUpdateFactureInput(&input); err != nil {
+// ReceiveStockTransfer is the resolver for the receiveStockTransfer field.
+func (r *mutationResolver) ReceiveStockTransfer(ctx context.Context, id string, items []*model.StockTransferReceiptInput) (*model.StockTransfer, error) {
+	if err := validators.ValidateObjectID(id, "Stock Transfer ID"); err != nil {
 		return nil, err
 	}
-	if _, err := r.RequireAuthenticated(ctx); err != nil {
-		return nil, err
+	for i, item := range items {
+		if err := validators.ValidateObjectID(item.ProductInStockID, "Product In Stock ID"); err != nil {
+			return nil, gqlerror.Errorf("Item %d: %v", i+1, err)
+		}
+		if item.ReceivedQuantity < 0 {
+			return nil, gqlerror.Errorf("Item %d: Received quantity cannot be negative", i+1)
+		}
 	}
-
-	// Get facture to verify store access
-	facture, err := r.DB.FindFactureByID(id)
+	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := r.RequireStoreAccess(ctx, facture.StoreID.Hex()); err != nil {
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
 		return nil, err
 	}
 
-	var factureProducts []database.FactureProduct
-	if input.Products != nil {
-		for _, p := range input.Products {
-			productID, err := primitive.ObjectIDFromHex(p.ProductID)
-			if err != nil {
-				return nil, gqlerror.Errorf("Invalid product ID")
-			}
-			factureProducts = append(factureProducts, database.FactureProduct{
-				ProductID: productID,
-				Quantity:  p.Quantity,
-				Price:     p.Price,
-			})
-		}
+	// The goods enter the destination store: the user must have access to it
+	transfer, err := r.DB.GetStockTransferByID(id)
+	if err != nil {
+		return nil, err
 	}
-
-	var clientID *primitive.ObjectID
-	if input.ClientID != nil {
-		id, err := primitive.ObjectIDFromHex(*input.ClientID)
-		if err != nil {
-			return nil, gqlerror.Errorf("Invalid client ID")
-		}
-		clientID = &id
+	if err := r.RequireStoreAccess(ctx, transfer.ToStoreID.Hex()); err != nil {
+		return nil, err
 	}
 
-	var factureDate *time.Time
-	if input.Date != nil {
-		date, err := time.Parse(time.RFC3339, *input.Date)
-		if err != nil {
-			return nil, gqlerror.Errorf("Invalid date format")
+	var receipts []database.StockTransferReceipt
+	for _, item := range items {
+		productInStockID, _ := primitive.ObjectIDFromHex(item.ProductInStockID)
+		reason := ""
+		if item.Reason != nil {
+			reason = validators.SanitizeString(*item.Reason, 500)
 		}
-		factureDate = &date
-	}
-
-	var quantity *int
-	if input.Quantity != nil {
-		quantity = input.Quantity
+		receipts = append(receipts, database.StockTransferReceipt{
+			SourceProductInStockID: productInStockID,
+			ReceivedQuantity:       item.ReceivedQuantity,
+			Reason:                 reason,
+		})
 	}
 
-	updatedFacture, err := r.DB.UpdateFacture(
-		id,
-		factureProducts,
-		clientID,
-		quantity,
-		input.Price,
-		input.Currency,
-		factureDate,
-	)
+	receivedTransfer, err := r.DB.ReceiveStockTransfer(id, receipts, currentUser.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertFactureToGraphQL(updatedFacture, r.DB), nil
+	return convertStockTransferToGraphQL(receivedTransfer, r.DB), nil
 }
 
-// DeleteFacture is the resolver for the deleteFacture field.
-func (r *mutationResolver) DeleteFacture(ctx context.Context, id string) (bool, error) {
-	if err := validators.ValidateObjectID(id, "Facture ID"); err != nil {
-		return false, err
+// CancelStockTransfer is the resolver for the cancelStockTransfer field.
+func (r *mutationResolver) CancelStockTransfer(ctx context.Context, id string) (*model.StockTransfer, error) {
+	if err := validators.ValidateObjectID(id, "Stock Transfer ID"); err != nil {
+		return nil, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
-		return false, err
+		return nil, err
 	}
 
-	// Get facture to verify store access
-	facture, err := r.DB.FindFactureByID(id)
+	transfer, err := r.DB.GetStockTransferByID(id)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-
-	hasAccess, err := r.HasStoreAccess(ctx, facture.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return false, gqlerror.Errorf("You don't have access to this facture's store")
+	if err := r.RequireStoreAccess(ctx, transfer.FromStoreID.Hex()); err != nil {
+		return nil, err
 	}
 
-	err = r.DB.DeleteFacture(id)
+	cancelledTransfer, err := r.DB.CancelStockTransfer(id)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 
-	return true, nil
+	return convertStockTransferToGraphQL(cancelledTransfer, r.DB), nil
 }
 
-// CreateRapportStore is the resolver for the createRapportStore field.
-func (r *mutationResolver) CreateRapportStore(ctx context.Context, input model.CreateRapportStoreInput) (*model.RapportStore, error) {
-	if err := validators.ValidateCreateRapportStoreInput(&input); err != nil {
+// CreateClient is the resolver for the createClient field.
+func (r *mutationResolver) CreateClient(ctx context.Context, input model.CreateClientInput) (*model.Client, error) {
+	if err := validators.ValidateCreateClientInput(&input); err != nil {
 		return nil, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
@@ -1518,81 +1905,64 @@ func (r *mutationResolver) CreateRapportStore(ctx context.Context, input model.C
 		return nil, gqlerror.Errorf("Invalid store ID")
 	}
 
-	productID, err := primitive.ObjectIDFromHex(input.ProductID)
-	if err != nil {
-		return nil, gqlerror.Errorf("Invalid product ID")
-	}
-
-	// Verify product belongs to store
-	product, err := r.DB.FindProductByID(input.ProductID)
+	client, err := r.DB.CreateClient(input.Name, input.Phone, storeID, input.CreditLimit)
 	if err != nil {
 		return nil, err
 	}
-	if product.StoreID != storeID {
-		return nil, gqlerror.Errorf("Product does not belong to store")
-	}
 
-	// Parse date
-	date, err := time.Parse(time.RFC3339, input.Date)
-	if err != nil {
-		return nil, gqlerror.Errorf("Invalid date format")
-	}
+	return convertClientToGraphQL(client, r.DB), nil
+}
 
-	// Validate type
-	if input.Type != "entree" && input.Type != "sortie" {
-		return nil, gqlerror.Errorf("Type must be 'entree' or 'sortie'")
+// UpdateClient is the resolver for the updateClient field.
+func (r *mutationResolver) UpdateClient(ctx context.Context, id string, input model.UpdateClientInput) (*model.Client, error) {
+	if err := validators.ValidateObjectID(id, "Client ID"); err != nil {
+		return nil, err
 	}
-
-	rapport := &database.RapportStore{
-		ID:        primitive.NewObjectID(),
-		Type:      input.Type,
-		ProductID: productID,
-		Quantity:  input.Quantity,
-		Date:      date,
-		StoreID:   storeID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	if err := validators.ValidateUpdateClientInput(&input); err != nil {
+		return nil, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
 	}
 
-	createdRapport, err := r.DB.CreateRapportStore(rapport)
+	// Get client to verify store access
+	client, err := r.DB.FindClientByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Update product stock
-	stockChange := input.Quantity
-	if input.Type == "sortie" {
-		stockChange = -input.Quantity
+	if err := r.RequireStoreAccessFromClient(ctx, client); err != nil {
+		return nil, err
 	}
-	err = r.DB.UpdateProductStock(input.ProductID, stockChange)
+
+	updatedClient, err := r.DB.UpdateClient(id, input.Name, input.Phone, input.CreditLimit)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertRapportStoreToGraphQL(createdRapport, r.DB), nil
+	return convertClientToGraphQL(updatedClient, r.DB), nil
 }
 
-// DeleteRapportStore is the resolver for the deleteRapportStore field.
-func (r *mutationResolver) DeleteRapportStore(ctx context.Context, id string) (bool, error) {
-	if err := validators.ValidateObjectID(id, "RapportStore ID"); err != nil {
+// DeleteClient is the resolver for the deleteClient field.
+func (r *mutationResolver) DeleteClient(ctx context.Context, id string) (bool, error) {
+	if err := validators.ValidateObjectID(id, "Client ID"); err != nil {
 		return false, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return false, err
 	}
 
-	// Get rapport to verify store access
-	rapport, err := r.DB.FindRapportStoreByID(id)
+	// Get client to verify store access
+	client, err := r.DB.FindClientByID(id)
 	if err != nil {
 		return false, err
 	}
 
-	hasAccess, err := r.HasStoreAccess(ctx, rapport.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return false, gqlerror.Errorf("You don't have access to this rapport's store")
+	if err := r.RequireStoreAccessFromClient(ctx, client); err != nil {
+		return false, err
 	}
 
-	err = r.DB.DeleteRapportStore(id)
+	err = r.DB.DeleteClient(id)
 	if err != nil {
 		return false, err
 	}
@@ -1600,16 +1970,54 @@ func (r *mutationResolver) DeleteRapportStore(ctx context.Context, id string) (b
 	return true, nil
 }
 
-// CreateCaisseTransaction is the resolver for the createCaisseTransaction field.
-func (r *mutationResolver) CreateCaisseTransaction(ctx context.Context, input model.CreateCaisseTransactionInput) (*model.CaisseTransaction, error) {
-	if err := validators.ValidateCreateCaisseTransactionInput(&input); err != nil {
+// UpdateClientCreditLimit is the resolver for the updateClientCreditLimit field.
+func (r *mutationResolver) UpdateClientCreditLimit(ctx context.Context, clientID string, creditLimit float64) (*model.Client, error) {
+	if err := validators.ValidateObjectID(clientID, "Client ID"); err != nil {
 		return nil, err
 	}
-	currentUser, err := r.RequireAuthenticated(ctx)
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	// Seuls les admins peuvent modifier les limites de crédit
+	if err := r.RequirePermission(ctx, database.PermissionClientCredit); err != nil {
+		return nil, err
+	}
+
+	// Get client to verify store access
+	client, err := r.DB.FindClientByID(clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	hasAccess, err := r.HasStoreAccess(ctx, client.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return nil, gqlerror.Errorf("You don't have access to this client's store")
+	}
+
+	// Valider que la limite est positive
+	if creditLimit < 0 {
+		return nil, gqlerror.Errorf("Credit limit cannot be negative")
+	}
+
+	// Mettre à jour la limite de crédit
+	updatedClient, err := r.DB.UpdateClientCreditLimit(clientID, creditLimit)
 	if err != nil {
 		return nil, err
 	}
 
+	return convertClientToGraphQL(updatedClient, r.DB), nil
+}
+
+// CreateProvider is the resolver for the createProvider field.
+func (r *mutationResolver) CreateProvider(ctx context.Context, input model.CreateProviderInput) (*model.Provider, error) {
+	if err := validators.ValidateCreateProviderInput(&input); err != nil {
+		return nil, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
 	// Vérifier l'abonnement
 	if err := r.CheckSubscription(ctx); err != nil {
 		return nil, err
@@ -1625,83 +2033,64 @@ func (r *mutationResolver) CreateCaisseTransaction(ctx context.Context, input mo
 		return nil, gqlerror.Errorf("Invalid store ID")
 	}
 
-	operatorID := currentUser.ID
+	provider, err := r.DB.CreateProvider(input.Name, input.Phone, input.Address, storeID)
+	if err != nil {
+		return nil, err
+	}
 
-	// Parse date if provided
-	var transactionDate *time.Time
-	if input.Date != nil {
-		date, err := time.Parse(time.RFC3339, *input.Date)
-		if err != nil {
-			return nil, gqlerror.Errorf("Invalid date format")
-		}
-		transactionDate = &date
+	return convertProviderToGraphQL(provider, r.DB), nil
+}
+
+// UpdateProvider is the resolver for the updateProvider field.
+func (r *mutationResolver) UpdateProvider(ctx context.Context, id string, input model.UpdateProviderInput) (*model.Provider, error) {
+	if err := validators.ValidateObjectID(id, "Provider ID"); err != nil {
+		return nil, err
+	}
+	if err := validators.ValidateUpdateProviderInput(&input); err != nil {
+		return nil, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
 	}
 
-	// Determine currency: use provided currency or default from store
-	currency := ""
-	if input.Currency != nil && *input.Currency != "" {
-		currency = *input.Currency
-		// Validate currency is supported by store
-		isValid, err := r.DB.ValidateStoreCurrency(input.StoreID, currency)
-		if err != nil {
-			return nil, err
-		}
-		if !isValid {
-			return nil, gqlerror.Errorf("Currency %s is not supported by this store. Supported currencies: %v", currency, func() []string {
-				store, _ := r.DB.FindStoreByID(input.StoreID)
-				if store != nil {
-					return store.SupportedCurrencies
-				}
-				return []string{}
-			}())
-		}
-	} else {
-		// Use default currency from store
-		defaultCurrency, err := r.DB.GetStoreDefaultCurrency(input.StoreID)
-		if err != nil {
-			return nil, err
-		}
-		currency = defaultCurrency
+	// Get provider to verify store access
+	provider, err := r.DB.FindProviderByID(id)
+	if err != nil {
+		return nil, err
 	}
 
-	trans, err := r.DB.CreateTrans(
-		input.Operation,
-		input.Amount,
-		input.Description,
-		currency,
-		operatorID,
-		storeID,
-		transactionDate,
-	)
+	if err := r.RequireStoreAccess(ctx, provider.StoreID.Hex()); err != nil {
+		return nil, err
+	}
+
+	updatedProvider, err := r.DB.UpdateProvider(id, input.Name, input.Phone, input.Address)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertCaisseTransactionToGraphQL(trans, r.DB), nil
+	return convertProviderToGraphQL(updatedProvider, r.DB), nil
 }
 
-// DeleteCaisseTransaction is the resolver for the deleteCaisseTransaction field.
-func (r *mutationResolver) DeleteCaisseTransaction(ctx context.Context, id string) (bool, error) {
-	if err := validators.ValidateObjectID(id, "Transaction ID"); err != nil {
+// DeleteProvider is the resolver for the deleteProvider field.
+func (r *mutationResolver) DeleteProvider(ctx context.Context, id string) (bool, error) {
+	if err := validators.ValidateObjectID(id, "Provider ID"); err != nil {
 		return false, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return false, err
 	}
 
-	// Verify transaction exists and user has access
-	trans, err := r.DB.FindTransByID(id)
+	// Get provider to verify store access
+	provider, err := r.DB.FindProviderByID(id)
 	if err != nil {
 		return false, err
 	}
 
-	// Verify store access
-	hasAccess, err := r.HasStoreAccess(ctx, trans.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return false, gqlerror.Errorf("You don't have access to this transaction's store")
+	if err := r.RequireStoreAccess(ctx, provider.StoreID.Hex()); err != nil {
+		return false, err
 	}
 
-	err = r.DB.DeleteTrans(id)
+	err = r.DB.DeleteProvider(id)
 	if err != nil {
 		return false, err
 	}
@@ -1709,9 +2098,9 @@ func (r *mutationResolver) DeleteCaisseTransaction(ctx context.Context, id strin
 	return true, nil
 }
 
-// CreateSale is the resolver for the createSale field.
-func (r *mutationResolver) CreateSale(ctx context.Context, input model.CreateSaleInput) (*model.Sale, error) {
-	if err := validators.ValidateCreateSaleInput(&input); err != nil {
+// CreateFacture is the resolver for the createFacture field.
+func (r *mutationResolver) CreateFacture(ctx context.Context, input model.CreateFactureInput) (*model.Facture, error) {
+	if err := validators.ValidateCreateFactureInput(&input); err != nil {
 		return nil, err
 	}
 	currentUser, err := r.RequireAuthenticated(ctx)
@@ -1734,60 +2123,45 @@ func (r *mutationResolver) CreateSale(ctx context.Context, input model.CreateSal
 		return nil, gqlerror.Errorf("Invalid store ID")
 	}
 
-	// Client ID is optional (for walk-in sales)
-	var clientID *primitive.ObjectID
-	if input.ClientID != nil {
-		id, err := primitive.ObjectIDFromHex(*input.ClientID)
-		if err != nil {
-			return nil, gqlerror.Errorf("Invalid client ID")
-		}
-		clientID = &id
+	clientID, err := primitive.ObjectIDFromHex(input.ClientID)
+	if err != nil {
+		return nil, gqlerror.Errorf("Invalid client ID")
 	}
 
-	operatorID := currentUser.ID
-
-	// Parse date if provided
-	// Accept both RFC3339 format (2024-01-01T00:00:00Z) and HTML date input format (2024-01-01)
-	var saleDate *time.Time
-	if input.Date != nil {
-		var date time.Time
-		var err error
-		// Try RFC3339 format first
-		date, err = time.Parse(time.RFC3339, *input.Date)
-		if err != nil {
-			// Try HTML date input format (YYYY-MM-DD)
-			date, err = time.Parse("2006-01-02", *input.Date)
-			if err != nil {
-				return nil, gqlerror.Errorf("Invalid date format. Expected RFC3339 (e.g., 2024-01-01T00:00:00Z) or date format (e.g., 2024-01-01)")
-			}
-			// Set time to start of day (00:00:00) in local timezone
-			date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
-		}
-		saleDate = &date
+	// Parse date
+	date, err := time.Parse(time.RFC3339, input.Date)
+	if err != nil {
+		return nil, gqlerror.Errorf("Invalid date format")
 	}
 
-	// Convert basket products
-	var basket []database.ProductInBasket
-	for _, p := range input.Basket {
-		productInStockID, err := primitive.ObjectIDFromHex(p.ProductInStockID)
+	// Convert products
+	var factureProducts []database.FactureProduct
+	for _, p := range input.Products {
+		productID, err := primitive.ObjectIDFromHex(p.ProductID)
 		if err != nil {
-			return nil, gqlerror.Errorf("Invalid product in stock ID: %s", p.ProductInStockID)
+			return nil, gqlerror.Errorf("Invalid product ID: %s", p.ProductID)
 		}
 
-		// Verify product in stock belongs to store
-		productInStock, err := r.DB.FindProductInStockByID(p.ProductInStockID)
+		// Verify product belongs to store
+		product, err := r.DB.FindProductByID(p.ProductID)
 		if err != nil {
-			return nil, gqlerror.Errorf("Product in stock not found: %s", p.ProductInStockID)
+			return nil, gqlerror.Errorf("Product not found: %s", p.ProductID)
 		}
-		if productInStock.StoreID != storeID {
-			return nil, gqlerror.Errorf("Product in stock %s does not belong to store", p.ProductInStockID)
+		if product.StoreID != storeID {
+			return nil, gqlerror.Errorf("Product %s does not belong to store", p.ProductID)
 		}
 
-		basket = append(basket, database.ProductInBasket{
-			ProductInStockID: productInStockID,
-			Quantity:         p.Quantity,
-			Price:            p.Price,
+		// Note: Factures use Product templates, not ProductInStock
+		// Stock checking is not applicable for factures as they are just invoices
+		// The actual stock management is done through ProductInStock
+
+		factureProducts = append(factureProducts, database.FactureProduct{
+			ProductID: productID,
+			Quantity:  p.Quantity,
+			Price:     p.Price,
 		})
+
+		// Note: Stock updates are no longer done here since Product is now just a template
 	}
 
 	// Determine currency: use provided currency or default from store
@@ -1817,170 +2191,1346 @@ func (r *mutationResolver) CreateSale(ctx context.Context, input model.CreateSal
 		currency = defaultCurrency
 	}
 
-	// Determine payment type
-	paymentType := "cash"
-	if input.PaymentType != nil && *input.PaymentType != "" {
-		paymentType = *input.PaymentType
+	// Create facture
+	facture := &database.Facture{
+		ID:        primitive.NewObjectID(),
+		Products:  factureProducts,
+		Quantity:  input.Quantity,
+		Date:      date,
+		Price:     input.Price,
+		Currency:  currency,
+		ClientID:  clientID,
+		StoreID:   storeID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
 	}
 
-	// Create sale (this will automatically update stock and create caisse transaction)
-	sale, err := r.DB.CreateSale(
-		basket,
-		input.PriceToPay,
-		input.PricePayed,
-		currency,
-		paymentType,
-		clientID,
-		operatorID,
-		storeID,
-		saleDate,
-	)
+	createdFacture, err := r.DB.CreateFacture(facture)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertSaleToGraphQL(sale, r.DB), nil
-}
-
-// DeleteSale is the resolver for the deleteSale field.
-func (r *mutationResolver) DeleteSale(ctx context.Context, id string) (bool, error) {
-	if err := validators.ValidateObjectID(id, "Sale ID"); err != nil {
-		return false, err
-	}
-	if _, err := r.RequireAuthenticated(ctx); err != nil {
-		return false, err
-	}
-
-	// Verify sale exists and user has access
-	sale, err := r.DB.FindSaleByID(id)
-	if err != nil {
-		return false, err
-	}
-
-	// Verify store access
-	hasAccess, err := r.HasStoreAccess(ctx, sale.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return false, gqlerror.Errorf("You don't have access to this sale's store")
-	}
-
-	err = r.DB.DeleteSale(id)
+	// Automatically create an "Entree" (entry) transaction in caisse when a facture is created
+	// This represents money coming in from the sale
+	_, err = r.DB.CreateTrans(
+		"Entree",
+		input.Price,
+		fmt.Sprintf("Vente facture %s", createdFacture.FactureNumber),
+		currency,
+		currentUser.ID,
+		storeID,
+		&date,
+	)
 	if err != nil {
-		return false, err
+		// Log error but don't fail the facture creation
+		utils.LogError(err, "Error creating caisse transaction for facture")
 	}
 
-	return true, nil
+	return convertFactureToGraphQL(createdFacture, r.DB), nil
 }
 
-// CreateFactureFromSale is the resolver for the createFactureFromSale field.
-func (r *mutationResolver) CreateFactureFromSale(ctx context.Context, saleID string) (*model.Facture, error) {
-	if err := validators.ValidateObjectID(saleID, "Sale ID"); err != nil {
+// UpdateFacture is the resolver for the updateFacture field.
+func (r *mutationResolver) UpdateFacture(ctx context.Context, id string, input model.UpdateFactureInput) (*model.Facture, error) {
+	if err := validators.ValidateObjectID(id, "Facture ID"); err != nil {
+		return nil, err
+	}
+	if err := validators.ValidateUpdateFactureInput(&input); err != nil {
 		return nil, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	// Get sale
-	sale, err := r.DB.FindSaleByID(saleID)
+	// Get facture to verify store access
+	facture, err := r.DB.FindFactureByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Verify store access
-	hasAccess, err := r.HasStoreAccess(ctx, sale.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this sale's store")
+	if err := r.RequireStoreAccess(ctx, facture.StoreID.Hex()); err != nil {
+		return nil, err
 	}
 
-	// Convert sale basket to facture products
 	var factureProducts []database.FactureProduct
-	var totalQuantity int
-	for _, item := range sale.Basket {
-		// Note: FactureProduct still uses ProductID, we need to get it from ProductInStock
-		productInStock, err := r.DB.FindProductInStockByID(item.ProductInStockID.Hex())
+	if input.Products != nil {
+		for _, p := range input.Products {
+			productID, err := primitive.ObjectIDFromHex(p.ProductID)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid product ID")
+			}
+			factureProducts = append(factureProducts, database.FactureProduct{
+				ProductID: productID,
+				Quantity:  p.Quantity,
+				Price:     p.Price,
+			})
+		}
+	}
+
+	var clientID *primitive.ObjectID
+	if input.ClientID != nil {
+		id, err := primitive.ObjectIDFromHex(*input.ClientID)
+		if err != nil {
+			return nil, gqlerror.Errorf("Invalid client ID")
+		}
+		clientID = &id
+	}
+
+	var factureDate *time.Time
+	if input.Date != nil {
+		date, err := time.Parse(time.RFC3339, *input.Date)
+		if err != nil {
+			return nil, gqlerror.Errorf("Invalid date format")
+		}
+		factureDate = &date
+	}
+
+	var quantity *int
+	if input.Quantity != nil {
+		quantity = input.Quantity
+	}
+
+	updatedFacture, err := r.DB.UpdateFacture(
+		id,
+		factureProducts,
+		clientID,
+		quantity,
+		input.Price,
+		input.Currency,
+		factureDate,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertFactureToGraphQL(updatedFacture, r.DB), nil
+}
+
+// DeleteFacture is the resolver for the deleteFacture field.
+func (r *mutationResolver) DeleteFacture(ctx context.Context, id string) (bool, error) {
+	if err := validators.ValidateObjectID(id, "Facture ID"); err != nil {
+		return false, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return false, err
+	}
+
+	// Get facture to verify store access
+	facture, err := r.DB.FindFactureByID(id)
+	if err != nil {
+		return false, err
+	}
+
+	hasAccess, err := r.HasStoreAccess(ctx, facture.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return false, gqlerror.Errorf("You don't have access to this facture's store")
+	}
+
+	err = r.DB.DeleteFacture(id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CreateRapportStore is the resolver for the createRapportStore field.
+func (r *mutationResolver) CreateRapportStore(ctx context.Context, input model.CreateRapportStoreInput) (*model.RapportStore, error) {
+	if err := validators.ValidateCreateRapportStoreInput(&input); err != nil {
+		return nil, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	// Verify store access
+	if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
+		return nil, err
+	}
+
+	storeID, err := primitive.ObjectIDFromHex(input.StoreID)
+	if err != nil {
+		return nil, gqlerror.Errorf("Invalid store ID")
+	}
+
+	productID, err := primitive.ObjectIDFromHex(input.ProductID)
+	if err != nil {
+		return nil, gqlerror.Errorf("Invalid product ID")
+	}
+
+	// Verify product belongs to store
+	product, err := r.DB.FindProductByID(input.ProductID)
+	if err != nil {
+		return nil, err
+	}
+	if product.StoreID != storeID {
+		return nil, gqlerror.Errorf("Product does not belong to store")
+	}
+
+	// Parse date
+	date, err := time.Parse(time.RFC3339, input.Date)
+	if err != nil {
+		return nil, gqlerror.Errorf("Invalid date format")
+	}
+
+	// Validate type
+	if input.Type != "entree" && input.Type != "sortie" {
+		return nil, gqlerror.Errorf("Type must be 'entree' or 'sortie'")
+	}
+
+	rapport := &database.RapportStore{
+		ID:        primitive.NewObjectID(),
+		Type:      input.Type,
+		ProductID: productID,
+		Quantity:  input.Quantity,
+		Date:      date,
+		StoreID:   storeID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	createdRapport, err := r.DB.CreateRapportStore(rapport)
+	if err != nil {
+		return nil, err
+	}
+
+	// Update product stock
+	stockChange := input.Quantity
+	if input.Type == "sortie" {
+		stockChange = -input.Quantity
+	}
+	err = r.DB.UpdateProductStock(input.ProductID, stockChange)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertRapportStoreToGraphQL(createdRapport, r.DB), nil
+}
+
+// DeleteRapportStore is the resolver for the deleteRapportStore field.
+func (r *mutationResolver) DeleteRapportStore(ctx context.Context, id string) (bool, error) {
+	if err := validators.ValidateObjectID(id, "RapportStore ID"); err != nil {
+		return false, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return false, err
+	}
+
+	// Get rapport to verify store access
+	rapport, err := r.DB.FindRapportStoreByID(id)
+	if err != nil {
+		return false, err
+	}
+
+	hasAccess, err := r.HasStoreAccess(ctx, rapport.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return false, gqlerror.Errorf("You don't have access to this rapport's store")
+	}
+
+	err = r.DB.DeleteRapportStore(id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CreateCaisseTransaction is the resolver for the createCaisseTransaction field.
+func (r *mutationResolver) CreateCaisseTransaction(ctx context.Context, input model.CreateCaisseTransactionInput, idempotencyKey *string) (*model.CaisseTransaction, error) {
+	return idempotent(ctx, r.Resolver, "createCaisseTransaction", idempotencyKey, input, func() (*model.CaisseTransaction, error) {
+		if err := validators.ValidateCreateCaisseTransactionInput(&input); err != nil {
+			return nil, err
+		}
+		currentUser, err := r.RequireAuthenticated(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Vérifier l'abonnement
+		if err := r.CheckSubscription(ctx); err != nil {
+			return nil, err
+		}
+
+		// Verify store access
+		if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
+			return nil, err
+		}
+
+		storeID, err := primitive.ObjectIDFromHex(input.StoreID)
+		if err != nil {
+			return nil, gqlerror.Errorf("Invalid store ID")
+		}
+
+		operatorID := currentUser.ID
+
+		// Parse date if provided
+		var transactionDate *time.Time
+		if input.Date != nil {
+			date, err := time.Parse(time.RFC3339, *input.Date)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid date format")
+			}
+			transactionDate = &date
+		}
+
+		// Determine currency: use provided currency or default from store
+		currency := ""
+		if input.Currency != nil && *input.Currency != "" {
+			currency = *input.Currency
+			// Validate currency is supported by store
+			isValid, err := r.DB.ValidateStoreCurrency(input.StoreID, currency)
+			if err != nil {
+				return nil, err
+			}
+			if !isValid {
+				return nil, gqlerror.Errorf("Currency %s is not supported by this store. Supported currencies: %v", currency, func() []string {
+					store, _ := r.DB.FindStoreByID(input.StoreID)
+					if store != nil {
+						return store.SupportedCurrencies
+					}
+					return []string{}
+				}())
+			}
+		} else {
+			// Use default currency from store
+			defaultCurrency, err := r.DB.GetStoreDefaultCurrency(input.StoreID)
+			if err != nil {
+				return nil, err
+			}
+			currency = defaultCurrency
+		}
+
+		trans, err := r.DB.CreateTrans(
+			input.Operation,
+			input.Amount,
+			input.Description,
+			currency,
+			operatorID,
+			storeID,
+			transactionDate,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return convertCaisseTransactionToGraphQL(trans, r.DB), nil
+	})
+}
+
+// DeleteCaisseTransaction is the resolver for the deleteCaisseTransaction field.
+func (r *mutationResolver) DeleteCaisseTransaction(ctx context.Context, id string) (bool, error) {
+	if err := validators.ValidateObjectID(id, "Transaction ID"); err != nil {
+		return false, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return false, err
+	}
+
+	// Verify transaction exists and user has access
+	trans, err := r.DB.FindTransByID(id)
+	if err != nil {
+		return false, err
+	}
+
+	// Verify store access
+	hasAccess, err := r.HasStoreAccess(ctx, trans.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return false, gqlerror.Errorf("You don't have access to this transaction's store")
+	}
+
+	err = r.DB.DeleteTrans(id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CreateSale is the resolver for the createSale field.
+func (r *mutationResolver) CreateSale(ctx context.Context, input model.CreateSaleInput, idempotencyKey *string) (*model.Sale, error) {
+	return idempotent(ctx, r.Resolver, "createSale", idempotencyKey, input, func() (*model.Sale, error) {
+		if err := validators.ValidateCreateSaleInput(&input); err != nil {
+			return nil, err
+		}
+		currentUser, err := r.RequireAuthenticated(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Vérifier l'abonnement
+		if err := r.CheckSubscription(ctx); err != nil {
+			return nil, err
+		}
+
+		// Verify store access
+		if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
+			return nil, err
+		}
+
+		storeID, err := primitive.ObjectIDFromHex(input.StoreID)
+		if err != nil {
+			return nil, gqlerror.Errorf("Invalid store ID")
+		}
+
+		// Client ID is optional (for walk-in sales)
+		var clientID *primitive.ObjectID
+		if input.ClientID != nil {
+			id, err := primitive.ObjectIDFromHex(*input.ClientID)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid client ID")
+			}
+			clientID = &id
+		}
+
+		operatorID := currentUser.ID
+
+		// Parse date if provided
+		// Accept both RFC3339 format (2024-01-01T00:00:00Z) and HTML date input format (2024-01-01)
+		var saleDate *time.Time
+		if input.Date != nil {
+			var date time.Time
+			var err error
+			// Try RFC3339 format first
+			date, err = time.Parse(time.RFC3339, *input.Date)
+			if err != nil {
+				// Try HTML date input format (YYYY-MM-DD)
+				date, err = time.Parse("2006-01-02", *input.Date)
+				if err != nil {
+					return nil, gqlerror.Errorf("Invalid date format. Expected RFC3339 (e.g., 2024-01-01T00:00:00Z) or date format (e.g., 2024-01-01)")
+				}
+				// Set time to start of day (00:00:00) in local timezone
+				date = time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.Local)
+			}
+			saleDate = &date
+		}
+
+		// Convert basket products
+		var basket []database.ProductInBasket
+		for _, p := range input.Basket {
+			productInStockID, err := primitive.ObjectIDFromHex(p.ProductInStockID)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid product in stock ID: %s", p.ProductInStockID)
+			}
+
+			// Verify product in stock belongs to store
+			productInStock, err := r.DB.FindProductInStockByID(p.ProductInStockID)
+			if err != nil {
+				return nil, gqlerror.Errorf("Product in stock not found: %s", p.ProductInStockID)
+			}
+			if productInStock.StoreID != storeID {
+				return nil, gqlerror.Errorf("Product in stock %s does not belong to store", p.ProductInStockID)
+			}
+
+			basket = append(basket, database.ProductInBasket{
+				ProductInStockID: productInStockID,
+				Quantity:         p.Quantity,
+				Price:            p.Price,
+			})
+		}
+
+		// Determine currency: use provided currency or default from store
+		currency := ""
+		if input.Currency != nil && *input.Currency != "" {
+			currency = *input.Currency
+			// Validate currency is supported by store
+			isValid, err := r.DB.ValidateStoreCurrency(input.StoreID, currency)
+			if err != nil {
+				return nil, err
+			}
+			if !isValid {
+				return nil, gqlerror.Errorf("Currency %s is not supported by this store. Supported currencies: %v", currency, func() []string {
+					store, _ := r.DB.FindStoreByID(input.StoreID)
+					if store != nil {
+						return store.SupportedCurrencies
+					}
+					return []string{}
+				}())
+			}
+		} else {
+			// Use default currency from store
+			defaultCurrency, err := r.DB.GetStoreDefaultCurrency(input.StoreID)
+			if err != nil {
+				return nil, err
+			}
+			currency = defaultCurrency
+		}
+
+		// Sale by product: the server picks the lots in the sale currency (FEFO, then FIFO)
+		for _, p := range input.Products {
+			productID, err := primitive.ObjectIDFromHex(p.ProductID)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid product ID: %s", p.ProductID)
+			}
+
+			lines, err := r.DB.AllocateProductLots(storeID, productID, p.Quantity, p.Price, currency, basket)
+			if err != nil {
+				return nil, err
+			}
+			basket = append(basket, lines...)
+		}
+
+		// Determine payment type
+		paymentType := "cash"
+		if input.PaymentType != nil && *input.PaymentType != "" {
+			paymentType = *input.PaymentType
+		}
+
+		// Split tenders: pricePayed and paymentType follow the payments, converted to the sale currency
+		var payments []database.SalePayment
+		for _, p := range input.Payments {
+			payment := database.SalePayment{Method: p.Method, Amount: p.Amount}
+			if p.Currency != nil {
+				payment.Currency = *p.Currency
+			}
+			if p.Reference != nil {
+				payment.Reference = *p.Reference
+			}
+			payments = append(payments, payment)
+		}
+
+		changeCurrency := ""
+		if input.ChangeCurrency != nil {
+			changeCurrency = *input.ChangeCurrency
+		}
+
+		// Create sale (this will automatically update stock and create caisse transaction)
+		sale, err := r.DB.CreateSale(
+			basket,
+			input.PriceToPay,
+			input.PricePayed,
+			payments,
+			changeCurrency,
+			currency,
+			paymentType,
+			clientID,
+			operatorID,
+			storeID,
+			saleDate,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		return convertSaleToGraphQL(sale, r.DB), nil
+	})
+}
+
+// DeleteSale is the resolver for the deleteSale field.
+func (r *mutationResolver) DeleteSale(ctx context.Context, id string) (bool, error) {
+	if err := validators.ValidateObjectID(id, "Sale ID"); err != nil {
+		return false, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return false, err
+	}
+
+	// Verify sale exists and user has access
+	sale, err := r.DB.FindSaleByID(id)
+	if err != nil {
+		return false, err
+	}
+
+	// Verify store access
+	hasAccess, err := r.HasStoreAccess(ctx, sale.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return false, gqlerror.Errorf("You don't have access to this sale's store")
+	}
+
+	err = r.DB.DeleteSale(id)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CancelSale is the resolver for the cancelSale field.
+func (r *mutationResolver) CancelSale(ctx context.Context, id string, reason string) (*model.Sale, error) {
+	if err := validators.ValidateObjectID(id, "Sale ID"); err != nil {
+		return nil, err
+	}
+	if err := validators.ValidateString(reason, "Reason", true, 1, 500); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	// Verify sale exists and user has access
+	sale, err := r.DB.FindSaleByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.RequireStoreAccessFromSale(ctx, sale); err != nil {
+		return nil, err
+	}
+
+	// Cancel sale (this will restore stock, refund the caisse and void the debt)
+	cancelledSale, err := r.DB.CancelSale(id, validators.SanitizeString(reason, 500), currentUser.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertSaleToGraphQL(cancelledSale, r.DB), nil
+}
+
+// CreateSaleReturn is the resolver for the createSaleReturn field.
+func (r *mutationResolver) CreateSaleReturn(ctx context.Context, input model.CreateSaleReturnInput) (*model.SaleReturn, error) {
+	if err := validators.ValidateCreateSaleReturnInput(&input); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	// Verify sale exists and user has access
+	sale, err := r.DB.FindSaleByID(input.SaleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.RequireStoreAccessFromSale(ctx, sale); err != nil {
+		return nil, err
+	}
+
+	// Convert returned items
+	var items []database.SaleReturnItem
+	for _, item := range input.Items {
+		productInStockID, err := primitive.ObjectIDFromHex(item.ProductInStockID)
+		if err != nil {
+			return nil, gqlerror.Errorf("Invalid product in stock ID: %s", item.ProductInStockID)
+		}
+		items = append(items, database.SaleReturnItem{
+			ProductInStockID: productInStockID,
+			Quantity:         item.Quantity,
+		})
+	}
+
+	reason := ""
+	if input.Reason != nil {
+		reason = validators.SanitizeString(*input.Reason, 500)
+	}
+
+	// Create return (this will restore stock and refund through the caisse or the debt)
+	saleReturn, err := r.DB.CreateSaleReturn(input.SaleID, items, input.RefundMethod, reason, currentUser.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertSaleReturnToGraphQL(saleReturn, r.DB), nil
+}
+
+// CreatePromotion is the resolver for the createPromotion field.
+func (r *mutationResolver) CreatePromotion(ctx context.Context, input model.PromotionInput) (*model.Promotion, error) {
+	if err := validators.ValidatePromotionInput(&input); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check subscription (trial or license)
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := r.requirePromotionScope(ctx, currentUser, input.StoreIds); err != nil {
+		return nil, err
+	}
+
+	promotion, err := promotionFromInput(input)
+	if err != nil {
+		return nil, err
+	}
+	promotion.CompanyID = currentUser.CompanyID
+	promotion.CreatedBy = currentUser.ID
+
+	promotion, err = r.DB.CreatePromotion(promotion)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertPromotionToGraphQL(promotion), nil
+}
+
+// UpdatePromotion is the resolver for the updatePromotion field.
+func (r *mutationResolver) UpdatePromotion(ctx context.Context, id string, input model.PromotionInput) (*model.Promotion, error) {
+	if err := validators.ValidateObjectID(id, "Promotion ID"); err != nil {
+		return nil, err
+	}
+	if err := validators.ValidatePromotionInput(&input); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check subscription (trial or license)
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	// Both the current and the new stores of the promotion must be accessible
+	existing, err := r.DB.FindPromotionByID(id, currentUser.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.requirePromotionScope(ctx, currentUser, objectIDsHex(existing.StoreIDs)); err != nil {
+		return nil, err
+	}
+	if err := r.requirePromotionScope(ctx, currentUser, input.StoreIds); err != nil {
+		return nil, err
+	}
+
+	promotion, err := promotionFromInput(input)
+	if err != nil {
+		return nil, err
+	}
+	promotion.CompanyID = currentUser.CompanyID
+
+	promotion, err = r.DB.UpdatePromotion(id, promotion)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertPromotionToGraphQL(promotion), nil
+}
+
+// DeletePromotion is the resolver for the deletePromotion field.
+func (r *mutationResolver) DeletePromotion(ctx context.Context, id string) (bool, error) {
+	if err := validators.ValidateObjectID(id, "Promotion ID"); err != nil {
+		return false, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	promotion, err := r.DB.FindPromotionByID(id, currentUser.CompanyID)
+	if err != nil {
+		return false, err
+	}
+	if err := r.requirePromotionScope(ctx, currentUser, objectIDsHex(promotion.StoreIDs)); err != nil {
+		return false, err
+	}
+
+	if err := r.DB.DeletePromotion(id, currentUser.CompanyID); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// CreateFactureFromSale is the resolver for the createFactureFromSale field.
+func (r *mutationResolver) CreateFactureFromSale(ctx context.Context, saleID string) (*model.Facture, error) {
+	if err := validators.ValidateObjectID(saleID, "Sale ID"); err != nil {
+		return nil, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	// Get sale
+	sale, err := r.DB.FindSaleByID(saleID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify store access
+	hasAccess, err := r.HasStoreAccess(ctx, sale.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return nil, gqlerror.Errorf("You don't have access to this sale's store")
+	}
+
+	// Convert sale basket to facture products
+	var factureProducts []database.FactureProduct
+	var totalQuantity int
+	for _, item := range sale.Basket {
+		// Note: FactureProduct still uses ProductID, we need to get it from ProductInStock
+		productInStock, err := r.DB.FindProductInStockByID(item.ProductInStockID.Hex())
+		if err != nil {
+			return nil, gqlerror.Errorf("Product in stock not found: %s", item.ProductInStockID.Hex())
+		}
+		factureProducts = append(factureProducts, database.FactureProduct{
+			ProductID: productInStock.ProductID,
+			Quantity:  int(item.Quantity),
+			Price:     item.Price,
+		})
+		totalQuantity += int(item.Quantity)
+	}
+
+	// Create facture from sale
+	// Note: If sale has no client, we still need a clientID for Facture
+	// For now, we'll require a client to create a facture
+	if sale.ClientID == nil {
+		return nil, gqlerror.Errorf("Cannot create facture from sale without a client. Please specify a client when creating the sale.")
+	}
+
+	facture := &database.Facture{
+		ID:        primitive.NewObjectID(),
+		Products:  factureProducts,
+		Quantity:  totalQuantity,
+		Date:      sale.Date,
+		Price:     sale.PriceToPay,
+		Currency:  sale.Currency,
+		ClientID:  *sale.ClientID,
+		StoreID:   sale.StoreID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+
+	createdFacture, err := r.DB.CreateFacture(facture)
+	if err != nil {
+		return nil, err
+	}
+
+	// Note: We don't create another caisse transaction because the sale already created one
+	// The facture is just a formal document for printing
+
+	return convertFactureToGraphQL(createdFacture, r.DB), nil
+}
+
+// PayDebt is the resolver for the payDebt field.
+func (r *mutationResolver) PayDebt(ctx context.Context, debtID string, amount float64, description string, idempotencyKey *string) (*model.Debt, error) {
+	return idempotent(ctx, r.Resolver, "payDebt", idempotencyKey, []interface{}{debtID, amount, description}, func() (*model.Debt, error) {
+		if err := validators.ValidateObjectID(debtID, "Debt ID"); err != nil {
+			return nil, err
+		}
+		if amount <= 0 {
+			return nil, gqlerror.Errorf("Payment amount must be greater than 0")
+		}
+		if description == "" {
+			return nil, gqlerror.Errorf("Description is required")
+		}
+
+		currentUser, err := r.RequireAuthenticated(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Get debt to verify store access
+		debt, err := r.DB.GetDebtByID(debtID)
+		if err != nil {
+			return nil, err
+		}
+
+		// Verify store access
+		hasAccess, err := r.HasStoreAccess(ctx, debt.StoreID.Hex())
+		if err != nil || !hasAccess {
+			return nil, gqlerror.Errorf("You don't have access to this debt's store")
+		}
+
+		// Pay debt
+		updatedDebt, _, err := r.DB.PayDebt(debtID, amount, currentUser.ID, debt.StoreID, description)
+		if err != nil {
+			return nil, err
+		}
+
+		return convertDebtToGraphQL(updatedDebt, r.DB), nil
+	})
+}
+
+// InitiatePayment is the resolver for the initiatePayment field.
+func (r *mutationResolver) InitiatePayment(ctx context.Context, input model.InitiatePaymentInput) (*model.PaymentIntent, error) {
+	if err := validators.ValidateInitiatePaymentInput(&input); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
+		return nil, err
+	}
+
+	storeID, _ := primitive.ObjectIDFromHex(input.StoreID)
+	payment := services.PaymentInput{
+		StoreID: storeID,
+		Method:  input.Method,
+		Phone:   input.Phone,
+		Amount:  input.Amount,
+	}
+	if input.SaleID != nil {
+		saleID, _ := primitive.ObjectIDFromHex(*input.SaleID)
+		payment.SaleID = &saleID
+	}
+	if input.DebtID != nil {
+		debtID, _ := primitive.ObjectIDFromHex(*input.DebtID)
+		payment.DebtID = &debtID
+	}
+
+	intent, err := services.NewPaymentService(r.DB, r.Payments).Initiate(ctx, currentUser, payment)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertPaymentIntentToGraphQL(intent), nil
+}
+
+// RefreshPaymentIntent is the resolver for the refreshPaymentIntent field.
+func (r *mutationResolver) RefreshPaymentIntent(ctx context.Context, id string) (*model.PaymentIntent, error) {
+	if err := validators.ValidateObjectID(id, "Payment intent ID"); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	intent, err := r.DB.FindPaymentIntentByID(id, currentUser.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.RequireStoreAccess(ctx, intent.StoreID.Hex()); err != nil {
+		return nil, err
+	}
+
+	intent, err = services.NewPaymentService(r.DB, r.Payments).Refresh(ctx, intent)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertPaymentIntentToGraphQL(intent), nil
+}
+
+// PayProviderDebt is the resolver for the payProviderDebt field.
+func (r *mutationResolver) PayProviderDebt(ctx context.Context, providerDebtID string, amount float64, description string, idempotencyKey *string) (*model.ProviderDebt, error) {
+	return idempotent(ctx, r.Resolver, "payProviderDebt", idempotencyKey, []interface{}{providerDebtID, amount, description}, func() (*model.ProviderDebt, error) {
+		if err := validators.ValidateObjectID(providerDebtID, "Provider Debt ID"); err != nil {
+			return nil, err
+		}
+		currentUser, err := r.RequireAuthenticated(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		// Get provider debt to verify store access
+		debt, err := r.DB.GetProviderDebtByID(providerDebtID)
+		if err != nil {
+			return nil, err
+		}
+
+		hasAccess, err := r.HasStoreAccess(ctx, debt.StoreID.Hex())
+		if err != nil || !hasAccess {
+			return nil, gqlerror.Errorf("You don't have access to this provider debt's store")
+		}
+
+		// Pay the debt
+		updatedDebt, payment, err := r.DB.PayProviderDebt(
+			providerDebtID,
+			amount,
+			currentUser.ID,
+			debt.StoreID,
+			description,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		// Log payment creation (payment is already created in PayProviderDebt)
+		_ = payment
+
+		return convertProviderDebtToGraphQL(updatedDebt, r.DB), nil
+	})
+}
+
+// CreateInventory is the resolver for the createInventory field.
+func (r *mutationResolver) CreateInventory(ctx context.Context, input model.CreateInventoryInput) (*model.Inventory, error) {
+	if err := validators.ValidateCreateInventoryInput(&input); err != nil {
+		return nil, err
+	}
+
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	// Verify store access
+	if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
+		return nil, err
+	}
+
+	storeID, err := primitive.ObjectIDFromHex(input.StoreID)
+	if err != nil {
+		return nil, gqlerror.Errorf("Invalid store ID")
+	}
+
+	// Check if there's already an active inventory for this store
+	activeInventory, err := r.DB.GetActiveInventory(input.StoreID)
+	if err != nil {
+		return nil, err
+	}
+	if activeInventory != nil {
+		return nil, gqlerror.Errorf("There is already an active inventory for this store. Please complete or cancel it first.")
+	}
+
+	inventory, err := r.DB.CreateInventory(storeID, currentUser.ID, input.Description)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertInventoryToGraphQL(inventory, r.DB), nil
+}
+
+// AddInventoryItem is the resolver for the addInventoryItem field.
+func (r *mutationResolver) AddInventoryItem(ctx context.Context, input model.AddInventoryItemInput) (*model.Inventory, error) {
+	if err := validators.ValidateAddInventoryItemInput(&input); err != nil {
+		return nil, err
+	}
+
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Get inventory to verify access
+	inventory, err := r.DB.GetInventoryByID(input.InventoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify store access
+	hasAccess, err := r.HasStoreAccess(ctx, inventory.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return nil, gqlerror.Errorf("You don't have access to this inventory's store")
+	}
+
+	productID, err := primitive.ObjectIDFromHex(input.ProductID)
+	if err != nil {
+		return nil, gqlerror.Errorf("Invalid product ID")
+	}
+
+	reason := ""
+	if input.Reason != nil {
+		reason = *input.Reason
+	}
+
+	updatedInventory, err := r.DB.AddInventoryItem(input.InventoryID, productID, input.PhysicalQuantity, reason, currentUser.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertInventoryToGraphQL(updatedInventory, r.DB), nil
+}
+
+// CompleteInventory is the resolver for the completeInventory field.
+func (r *mutationResolver) CompleteInventory(ctx context.Context, inventoryID string, adjustStock bool) (*model.Inventory, error) {
+	if err := validators.ValidateObjectID(inventoryID, "Inventory ID"); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	// Get inventory to verify access
+	inventory, err := r.DB.GetInventoryByID(inventoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify store access
+	hasAccess, err := r.HasStoreAccess(ctx, inventory.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return nil, gqlerror.Errorf("You don't have access to this inventory's store")
+	}
+
+	completedInventory, err := r.DB.CompleteInventory(inventoryID, adjustStock)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertInventoryToGraphQL(completedInventory, r.DB), nil
+}
+
+// CancelInventory is the resolver for the cancelInventory field.
+func (r *mutationResolver) CancelInventory(ctx context.Context, inventoryID string) (*model.Inventory, error) {
+	if err := validators.ValidateObjectID(inventoryID, "Inventory ID"); err != nil {
+		return nil, err
+	}
+
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	// Get inventory to verify access
+	inventory, err := r.DB.GetInventoryByID(inventoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify store access
+	hasAccess, err := r.HasStoreAccess(ctx, inventory.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return nil, gqlerror.Errorf("You don't have access to this inventory's store")
+	}
+
+	cancelledInventory, err := r.DB.CancelInventory(inventoryID)
+	if err != nil {
+		return nil, err
+	}
+
+	return convertInventoryToGraphQL(cancelledInventory, r.DB), nil
+}
+
+// CreateSubscription is the resolver for the createSubscription field.
+func (r *mutationResolver) CreateSubscription(ctx context.Context, plan string, paymentMethod string, paymentID string) (*model.CompanySubscription, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return nil, gqlerror.Errorf("User does not have a company yet")
+	}
+
+	if plan == "" {
+		return nil, gqlerror.Errorf("Plan is required")
+	}
+	if paymentMethod == "" {
+		return nil, gqlerror.Errorf("Payment method is required")
+	}
+	if paymentID == "" {
+		return nil, gqlerror.Errorf("Payment ID is required")
+	}
+
+	if _, err := r.DB.GetSubscriptionPlanByID(plan); err != nil {
+		return nil, err
+	}
+
+	if err := r.DB.SetLicenseID(currentUser.CompanyID.Hex(), paymentID); err != nil {
+		return nil, err
+	}
+
+	subscription, err := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
+	if err != nil {
+		subscription, err = r.DB.CreateTrialSubscription(currentUser.CompanyID)
 		if err != nil {
-			return nil, gqlerror.Errorf("Product in stock not found: %s", item.ProductInStockID.Hex())
+			return nil, err
+		}
+	}
+
+	if subscription.Status != "active" {
+		if err := r.DB.UpdateSubscriptionStatus(subscription.ID.Hex(), "active"); err != nil {
+			return nil, err
+		}
+		subscription.Status = "active"
+	}
+
+	return convertSubscriptionToGraphQL(subscription), nil
+}
+
+// UpgradeSubscription is the resolver for the upgradeSubscription field.
+func (r *mutationResolver) UpgradeSubscription(ctx context.Context, plan string, paymentMethod string, paymentID string) (*model.CompanySubscription, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return nil, gqlerror.Errorf("User does not have a company yet")
+	}
+
+	if plan == "" {
+		return nil, gqlerror.Errorf("Plan is required")
+	}
+	if paymentMethod == "" {
+		return nil, gqlerror.Errorf("Payment method is required")
+	}
+	if paymentID == "" {
+		return nil, gqlerror.Errorf("Payment ID is required")
+	}
+
+	if _, err := r.DB.GetSubscriptionPlanByID(plan); err != nil {
+		return nil, err
+	}
+
+	if err := r.DB.SetLicenseID(currentUser.CompanyID.Hex(), paymentID); err != nil {
+		return nil, err
+	}
+
+	subscription, err := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
+	if err != nil {
+		subscription, err = r.DB.CreateTrialSubscription(currentUser.CompanyID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if subscription.Status != "active" {
+		if err := r.DB.UpdateSubscriptionStatus(subscription.ID.Hex(), "active"); err != nil {
+			return nil, err
+		}
+		subscription.Status = "active"
+	}
+
+	return convertSubscriptionToGraphQL(subscription), nil
+}
+
+// CancelSubscription is the resolver for the cancelSubscription field.
+func (r *mutationResolver) CancelSubscription(ctx context.Context) (bool, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return false, gqlerror.Errorf("User does not have a company yet")
+	}
+
+	if err := r.DB.SetLicenseID(currentUser.CompanyID.Hex(), ""); err != nil {
+		return false, err
+	}
+
+	subscription, err := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
+	if err == nil && subscription != nil && subscription.Status != "expired" {
+		if err := r.DB.UpdateSubscriptionStatus(subscription.ID.Hex(), "expired"); err != nil {
+			return false, err
 		}
-		factureProducts = append(factureProducts, database.FactureProduct{
-			ProductID: productInStock.ProductID,
-			Quantity:  int(item.Quantity),
-			Price:     item.Price,
-		})
-		totalQuantity += int(item.Quantity)
 	}
 
-	// Create facture from sale
-	// Note: If sale has no client, we still need a clientID for Facture
-	// For now, we'll require a client to create a facture
-	if sale.ClientID == nil {
-		return nil, gqlerror.Errorf("Cannot create facture from sale without a client. Please specify a client when creating the sale.")
+	return true, nil
+}
+
+// Me is the resolver for the me field.
+func (r *queryResolver) Me(ctx context.Context) (*model.User, error) {
+	user, err := r.GetUserFromContext(ctx)
+	if err != nil || user == nil {
+		return nil, gqlerror.Errorf("Unauthorized")
 	}
 
-	facture := &database.Facture{
-		ID:        primitive.NewObjectID(),
-		Products:  factureProducts,
-		Quantity:  totalQuantity,
-		Date:      sale.Date,
-		Price:     sale.PriceToPay,
-		Currency:  sale.Currency,
-		ClientID:  *sale.ClientID,
-		StoreID:   sale.StoreID,
-		CreatedAt: time.Now(),
-		UpdatedAt: time.Now(),
+	return convertUserToGraphQL(user), nil
+}
+
+// MySessions is the resolver for the mySessions field.
+func (r *queryResolver) MySessions(ctx context.Context) ([]*model.Session, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	createdFacture, err := r.DB.CreateFacture(facture)
+	sessions, err := r.DB.FindActiveSessionsByUserID(currentUser.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Note: We don't create another caisse transaction because the sale already created one
-	// The facture is just a formal document for printing
+	currentSessionID := middlewares.CtxValue(ctx).FamilyID
+	result := make([]*model.Session, 0, len(sessions))
+	for _, session := range sessions {
+		result = append(result, convertSessionToGraphQL(session, currentSessionID))
+	}
 
-	return convertFactureToGraphQL(createdFacture, r.DB), nil
+	return result, nil
 }
 
-// PayDebt is the resolver for the payDebt field.
-func (r *mutationResolver) PayDebt(ctx context.Context, debtID string, amount float64, description string) (*model.Debt, error) {
-	if err := validators.ValidateObjectID(debtID, "Debt ID"); err != nil {
+// MyMemberships is the resolver for the myMemberships field.
+func (r *queryResolver) MyMemberships(ctx context.Context) ([]*model.Membership, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The active company of the user document, not the overlay of the request
+	user, err := r.DB.FindUserByID(currentUser.ID.Hex())
+	if err != nil {
 		return nil, err
 	}
-	if amount <= 0 {
-		return nil, gqlerror.Errorf("Payment amount must be greater than 0")
+	memberships, err := r.DB.FindUserMemberships(user)
+	if err != nil {
+		return nil, err
 	}
-	if description == "" {
-		return nil, gqlerror.Errorf("Description is required")
+
+	result := make([]*model.Membership, len(memberships))
+	for i, membership := range memberships {
+		result[i] = convertMembershipToGraphQL(membership, membership.CompanyID == currentUser.CompanyID, r.DB)
 	}
 
+	return result, nil
+}
+
+// Users is the resolver for the users field.
+func (r *queryResolver) Users(ctx context.Context) ([]*model.User, error) {
 	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get debt to verify store access
-	debt, err := r.DB.GetDebtByID(debtID)
+	// Requires the user.manage permission (Admin, or a role granting it)
+	if err := r.RequirePermission(ctx, database.PermissionUserManage); err != nil {
+		return nil, err
+	}
+
+	// Check if user has a company
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return []*model.User{}, nil // Return empty list if no company
+	}
+
+	users, err := r.DB.FindUsersByCompanyID(currentUser.CompanyID.Hex())
 	if err != nil {
 		return nil, err
 	}
 
-	// Verify store access
-	hasAccess, err := r.HasStoreAccess(ctx, debt.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this debt's store")
+	var result []*model.User
+	for _, user := range users {
+		result = append(result, convertUserToGraphQL(user))
+	}
+
+	return result, nil
+}
+
+// User is the resolver for the user field.
+func (r *queryResolver) User(ctx context.Context, id string) (*model.User, error) {
+	if err := validators.ValidateObjectID(id, "User ID"); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Pay debt
-	updatedDebt, _, err := r.DB.PayDebt(debtID, amount, currentUser.ID, debt.StoreID, description)
+	// Verify user belongs to same company
+	user, err := r.DB.FindUserInCompany(id, currentUser.CompanyID)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertDebtToGraphQL(updatedDebt, r.DB), nil
+	return convertUserToGraphQL(user), nil
 }
 
-// PayProviderDebt is the resolver for the payProviderDebt field.
-func (r *mutationResolver) PayProviderDebt(ctx context.Context, providerDebtID string, amount float64, description string) (*model.ProviderDebt, error) {
-	if err := validators.ValidateObjectID(providerDebtID, "Provider Debt ID"); err != nil {
+// UserSessions is the resolver for the userSessions field.
+func (r *queryResolver) UserSessions(ctx context.Context, userID string) ([]*model.Session, error) {
+	if err := validators.ValidateObjectID(userID, "User ID"); err != nil {
+		return nil, err
+	}
+	if err := r.RequirePermission(ctx, database.PermissionUserManage); err != nil {
 		return nil, err
 	}
 	currentUser, err := r.RequireAuthenticated(ctx)
@@ -1988,488 +3538,788 @@ func (r *mutationResolver) PayProviderDebt(ctx context.Context, providerDebtID s
 		return nil, err
 	}
 
-	// Get provider debt to verify store access
-	debt, err := r.DB.GetProviderDebtByID(providerDebtID)
+	// Verify user belongs to same company
+	user, err := r.DB.FindUserInCompany(userID, currentUser.CompanyID)
 	if err != nil {
 		return nil, err
 	}
 
-	hasAccess, err := r.HasStoreAccess(ctx, debt.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this provider debt's store")
+	sessions, err := r.DB.FindActiveSessionsByUserID(user.ID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Pay the debt
-	updatedDebt, payment, err := r.DB.PayProviderDebt(
-		providerDebtID,
-		amount,
-		currentUser.ID,
-		debt.StoreID,
-		description,
-	)
+	currentSessionID := middlewares.CtxValue(ctx).FamilyID
+	result := make([]*model.Session, 0, len(sessions))
+	for _, session := range sessions {
+		// The sessions opened in the other companies of the user are not shown
+		if session.CompanyID != currentUser.CompanyID {
+			continue
+		}
+		result = append(result, convertSessionToGraphQL(session, currentSessionID))
+	}
+
+	return result, nil
+}
+
+// Invitations is the resolver for the invitations field.
+func (r *queryResolver) Invitations(ctx context.Context) ([]*model.Invitation, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invitations, err := r.DB.FindInvitationsByCompanyID(currentUser.CompanyID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Log payment creation (payment is already created in PayProviderDebt)
-	_ = payment
+	result := make([]*model.Invitation, len(invitations))
+	for i, invitation := range invitations {
+		result[i] = convertInvitationToGraphQL(invitation)
+	}
 
-	return convertProviderDebtToGraphQL(updatedDebt, r.DB), nil
+	return result, nil
 }
 
-// CreateInventory is the resolver for the createInventory field.
-func (r *mutationResolver) CreateInventory(ctx context.Context, input model.CreateInventoryInput) (*model.Inventory, error) {
-	if err := validators.ValidateCreateInventoryInput(&input); err != nil {
+// Permissions is the resolver for the permissions field.
+func (r *queryResolver) Permissions(ctx context.Context) ([]*model.Permission, error) {
+	result := make([]*model.Permission, 0, len(database.Permissions))
+	for _, permission := range database.Permissions {
+		result = append(result, &model.Permission{
+			Key:         permission.Key,
+			Description: permission.Description,
+		})
+	}
+
+	return result, nil
+}
+
+// Roles is the resolver for the roles field.
+func (r *queryResolver) Roles(ctx context.Context) ([]*model.Role, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
 		return nil, err
 	}
 
+	roles, err := r.DB.FindRolesByCompanyID(currentUser.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.Role, 0, len(roles)+2)
+	for _, role := range database.BuiltInRoles() {
+		result = append(result, convertRoleToGraphQL(role))
+	}
+	for _, role := range roles {
+		result = append(result, convertRoleToGraphQL(role))
+	}
+
+	return result, nil
+}
+
+// MyPermissions is the resolver for the myPermissions field.
+func (r *queryResolver) MyPermissions(ctx context.Context) ([]string, error) {
 	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Vérifier l'abonnement
-	if err := r.CheckSubscription(ctx); err != nil {
+	return r.DB.GetUserPermissions(currentUser)
+}
+
+// APIKeys is the resolver for the apiKeys field.
+func (r *queryResolver) APIKeys(ctx context.Context) ([]*model.APIKey, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	apiKeys, err := r.DB.FindAPIKeysByCompanyID(currentUser.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.APIKey, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		result[i] = convertAPIKeyToGraphQL(apiKey)
+	}
+
+	return result, nil
+}
+
+// AuditLog is the resolver for the auditLog field.
+func (r *queryResolver) AuditLog(ctx context.Context, filter *model.AuditLogFilter) ([]*model.AuditLogEntry, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dbFilter := database.AuditLogFilter{}
+	if filter != nil {
+		if filter.StoreID != nil {
+			storeID, err := primitive.ObjectIDFromHex(*filter.StoreID)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid store ID")
+			}
+			dbFilter.StoreID = &storeID
+		}
+		if filter.ActorID != nil {
+			actorID, err := primitive.ObjectIDFromHex(*filter.ActorID)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid actor ID")
+			}
+			dbFilter.ActorID = &actorID
+		}
+		if filter.StartDate != nil {
+			startDate, err := time.Parse(time.RFC3339, *filter.StartDate)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid start date format (expected RFC3339)")
+			}
+			dbFilter.StartDate = &startDate
+		}
+		if filter.EndDate != nil {
+			endDate, err := time.Parse(time.RFC3339, *filter.EndDate)
+			if err != nil {
+				return nil, gqlerror.Errorf("Invalid end date format (expected RFC3339)")
+			}
+			dbFilter.EndDate = &endDate
+		}
+		if filter.Action != nil {
+			dbFilter.Action = *filter.Action
+		}
+		if filter.TargetID != nil {
+			dbFilter.TargetID = *filter.TargetID
+		}
+		if filter.Limit != nil {
+			dbFilter.Limit = *filter.Limit
+		}
+	}
+
+	entries, err := r.DB.FindAuditEntries(currentUser.CompanyID, dbFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.AuditLogEntry, len(entries))
+	for i, entry := range entries {
+		result[i] = convertAuditEntryToGraphQL(entry)
+	}
+
+	return result, nil
+}
+
+// VerifyAuditLog is the resolver for the verifyAuditLog field.
+func (r *queryResolver) VerifyAuditLog(ctx context.Context) (*model.AuditLogVerification, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	verification, err := r.DB.VerifyAuditChain(currentUser.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var brokenAtSequence *int
+	if verification.BrokenAtSequence != nil {
+		sequence := int(*verification.BrokenAtSequence)
+		brokenAtSequence = &sequence
+	}
+
+	return &model.AuditLogVerification{
+		Valid:            verification.Valid,
+		Checked:          verification.Checked,
+		BrokenAtSequence: brokenAtSequence,
+		Reason:           optionalString(verification.Reason),
+	}, nil
+}
+
+// Company is the resolver for the company field.
+func (r *queryResolver) Company(ctx context.Context) (*model.Company, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Check if user has a company
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return nil, gqlerror.Errorf("User does not have a company yet. Please create one using createCompany mutation.")
+	}
+
+	company, err := r.DB.FindCompanyByID(currentUser.CompanyID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	return convertCompanyToGraphQL(company, r.DB, true), nil
+}
+
+// ExchangeRates is the resolver for the exchangeRates field.
+func (r *queryResolver) ExchangeRates(ctx context.Context) ([]*model.ExchangeRate, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Verify that the user has a company
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return nil, gqlerror.Errorf("User does not have a company yet")
+	}
+
+	// Get exchange rates from database
+	rates, err := r.DB.GetCompanyExchangeRates(currentUser.CompanyID.Hex())
+	if err != nil {
 		return nil, err
 	}
 
-	// Verify store access
-	if err := r.RequireStoreAccess(ctx, input.StoreID); err != nil {
-		return nil, err
+	// Convert to GraphQL model
+	var rateModels []*model.ExchangeRate
+	for _, rate := range rates {
+		rateModels = append(rateModels, convertExchangeRateToGraphQL(&rate))
+	}
+
+	return rateModels, nil
+}
+
+// ConvertCurrency is the resolver for the convertCurrency field.
+func (r *queryResolver) ConvertCurrency(ctx context.Context, amount float64, fromCurrency string, toCurrency string) (float64, error) {
+	currentUser, err := r.GetUserFromContext(ctx)
+	if err != nil || currentUser == nil {
+		return 0, gqlerror.Errorf("Unauthorized")
+	}
+
+	// Verify that the user has a company
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return 0, gqlerror.Errorf("User does not have a company yet")
+	}
+
+	// Validate amount
+	if amount < 0 {
+		return 0, gqlerror.Errorf("Amount must be positive")
 	}
 
-	storeID, err := primitive.ObjectIDFromHex(input.StoreID)
+	// Convert currency using the company's exchange rates
+	convertedAmount, err := r.DB.ConvertCurrency(currentUser.CompanyID.Hex(), amount, fromCurrency, toCurrency)
 	if err != nil {
-		return nil, gqlerror.Errorf("Invalid store ID")
+		return 0, err
 	}
 
-	// Check if there's already an active inventory for this store
-	activeInventory, err := r.DB.GetActiveInventory(input.StoreID)
+	return convertedAmount, nil
+}
+
+// Subscription is the resolver for the subscription field.
+func (r *queryResolver) Subscription(ctx context.Context) (*model.CompanySubscription, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
-	if activeInventory != nil {
-		return nil, gqlerror.Errorf("There is already an active inventory for this store. Please complete or cancel it first.")
+
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return nil, gqlerror.Errorf("User does not have a company yet")
 	}
 
-	inventory, err := r.DB.CreateInventory(storeID, currentUser.ID, input.Description)
+	subscription, err := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
 	if err != nil {
 		return nil, err
 	}
 
-	return convertInventoryToGraphQL(inventory, r.DB), nil
+	sub := convertSubscriptionToGraphQL(subscription)
+	return sub, nil
 }
 
-// AddInventoryItem is the resolver for the addInventoryItem field.
-func (r *mutationResolver) AddInventoryItem(ctx context.Context, input model.AddInventoryItemInput) (*model.Inventory, error) {
-	if err := validators.ValidateAddInventoryItemInput(&input); err != nil {
-		return nil, err
-	}
-
+// CheckSubscriptionStatus is the resolver for the checkSubscriptionStatus field.
+func (r *queryResolver) CheckSubscriptionStatus(ctx context.Context) (*model.SubscriptionStatus, error) {
 	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Get inventory to verify access
-	inventory, err := r.DB.GetInventoryByID(input.InventoryID)
-	if err != nil {
-		return nil, err
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return &model.SubscriptionStatus{
+			IsValid:    false,
+			HasLicense: false,
+			Message:    stringPtr("User does not have a company yet"),
+		}, nil
 	}
 
-	// Verify store access
-	hasAccess, err := r.HasStoreAccess(ctx, inventory.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this inventory's store")
+	// Check if company has a valid license ID
+	company, err := r.DB.FindCompanyByID(currentUser.CompanyID.Hex())
+	hasLicense := false
+	if err == nil && company != nil && company.LicenseID != nil && *company.LicenseID != "" {
+		hasLicense = true
+	}
+
+	subscriptionService := services.NewSubscriptionService(r.DB)
+	err = subscriptionService.ValidateSubscription(ctx, currentUser.CompanyID.Hex())
+
+	subscription, _ := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
+	var subModel *model.CompanySubscription
+	if subscription != nil {
+		subModel = convertSubscriptionToGraphQL(subscription)
 	}
 
-	productID, err := primitive.ObjectIDFromHex(input.ProductID)
 	if err != nil {
-		return nil, gqlerror.Errorf("Invalid product ID")
+		return &model.SubscriptionStatus{
+			IsValid:      false,
+			HasLicense:   hasLicense,
+			Message:      stringPtr(err.Error()),
+			Subscription: subModel,
+		}, nil
 	}
 
-	reason := ""
-	if input.Reason != nil {
-		reason = *input.Reason
+	return &model.SubscriptionStatus{
+		IsValid:      true,
+		HasLicense:   hasLicense,
+		Message:      nil,
+		Subscription: subModel,
+	}, nil
+}
+
+// SubscriptionPlans is the resolver for the subscriptionPlans field.
+func (r *queryResolver) SubscriptionPlans(ctx context.Context) ([]*model.SubscriptionPlan, error) {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
 	}
 
-	updatedInventory, err := r.DB.AddInventoryItem(input.InventoryID, productID, input.PhysicalQuantity, reason, currentUser.ID)
+	plans, err := r.DB.GetAllSubscriptionPlans()
 	if err != nil {
 		return nil, err
 	}
 
-	return convertInventoryToGraphQL(updatedInventory, r.DB), nil
+	result := make([]*model.SubscriptionPlan, 0, len(plans))
+	for _, plan := range plans {
+		result = append(result, convertSubscriptionPlanToGraphQL(plan))
+	}
+
+	return result, nil
 }
 
-// CompleteInventory is the resolver for the completeInventory field.
-func (r *mutationResolver) CompleteInventory(ctx context.Context, inventoryID string, adjustStock bool) (*model.Inventory, error) {
-	if err := validators.ValidateObjectID(inventoryID, "Inventory ID"); err != nil {
+// SubscriptionPlan is the resolver for the subscriptionPlan field.
+func (r *queryResolver) SubscriptionPlan(ctx context.Context, id string) (*model.SubscriptionPlan, error) {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	if _, err := r.RequireAuthenticated(ctx); err != nil {
+	plan, err := r.DB.GetSubscriptionPlanByID(id)
+	if err != nil {
 		return nil, err
 	}
 
-	// Get inventory to verify access
-	inventory, err := r.DB.GetInventoryByID(inventoryID)
+	return convertSubscriptionPlanToGraphQL(plan), nil
+}
+
+// Stores is the resolver for the stores field.
+func (r *queryResolver) Stores(ctx context.Context) ([]*model.Store, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	// Verify store access
-	hasAccess, err := r.HasStoreAccess(ctx, inventory.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this inventory's store")
+	// Check if user has a company
+	if currentUser.CompanyID == primitive.NilObjectID {
+		return []*model.Store{}, nil // Return empty list if no company
+	}
+
+	var stores []*database.Store
+	if currentUser.Role == "Admin" {
+		stores, err = r.DB.FindStoresByCompanyID(currentUser.CompanyID.Hex())
+	} else {
+		// User can only see assigned store
+		if currentUser.AssignedStoreID != nil {
+			store, err := r.DB.FindStoreByID(currentUser.AssignedStoreID.Hex())
+			if err == nil {
+				stores = []*database.Store{store}
+			}
+		}
 	}
 
-	completedInventory, err := r.DB.CompleteInventory(inventoryID, adjustStock)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertInventoryToGraphQL(completedInventory, r.DB), nil
+	var result []*model.Store
+	for _, store := range stores {
+		result = append(result, convertStoreToGraphQL(store, r.DB, true))
+	}
+
+	return result, nil
 }
 
-// CancelInventory is the resolver for the cancelInventory field.
-func (r *mutationResolver) CancelInventory(ctx context.Context, inventoryID string) (*model.Inventory, error) {
-	if err := validators.ValidateObjectID(inventoryID, "Inventory ID"); err != nil {
+// Store is the resolver for the store field.
+func (r *queryResolver) Store(ctx context.Context, id string) (*model.Store, error) {
+	if err := validators.ValidateObjectID(id, "Store ID"); err != nil {
 		return nil, err
 	}
-
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	// Get inventory to verify access
-	inventory, err := r.DB.GetInventoryByID(inventoryID)
-	if err != nil {
-		return nil, err
-	}
-
 	// Verify store access
-	hasAccess, err := r.HasStoreAccess(ctx, inventory.StoreID.Hex())
+	hasAccess, err := r.HasStoreAccess(ctx, id)
 	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this inventory's store")
+		return nil, gqlerror.Errorf("You don't have access to this store")
 	}
 
-	cancelledInventory, err := r.DB.CancelInventory(inventoryID)
+	store, err := r.DB.FindStoreByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertInventoryToGraphQL(cancelledInventory, r.DB), nil
-}
-
-// Me is the resolver for the me field.
-func (r *queryResolver) Me(ctx context.Context) (*model.User, error) {
-	user, err := r.GetUserFromContext(ctx)
-	if err != nil || user == nil {
-		return nil, gqlerror.Errorf("Unauthorized")
-	}
-
-	return convertUserToGraphQL(user), nil
+	return convertStoreToGraphQL(store, r.DB, true), nil
 }
 
-// Users is the resolver for the users field.
-func (r *queryResolver) Users(ctx context.Context) ([]*model.User, error) {
-	currentUser, err := r.RequireAuthenticated(ctx)
-	if err != nil {
+// Products is the resolver for the products field.
+func (r *queryResolver) Products(ctx context.Context, storeID *string) ([]*model.Product, error) {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	// Only Admin can see all users
-	if currentUser.Role != "Admin" {
-		return nil, gqlerror.Errorf("Only Admin can view all users")
-	}
-
-	// Check if user has a company
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return []*model.User{}, nil // Return empty list if no company
+	var storeIDs []primitive.ObjectID
+	if storeID != nil {
+		if err := validators.ValidateObjectID(*storeID, "Store ID"); err != nil {
+			return nil, err
+		}
+		// Verify access to specific store
+		hasAccess, err := r.HasStoreAccess(ctx, *storeID)
+		if err != nil || !hasAccess {
+			return nil, gqlerror.Errorf("You don't have access to this store")
+		}
+		id, _ := primitive.ObjectIDFromHex(*storeID)
+		storeIDs = []primitive.ObjectID{id}
+	} else {
+		// Get accessible stores
+		accessibleStoreIDs, _ := r.GetAccessibleStoreIDs(ctx)
+		for _, id := range accessibleStoreIDs {
+			objectID, _ := primitive.ObjectIDFromHex(id)
+			storeIDs = append(storeIDs, objectID)
+		}
 	}
 
-	users, err := r.DB.FindUsersByCompanyID(currentUser.CompanyID.Hex())
+	// Find all products in accessible stores (products are now just templates)
+	products, err := r.DB.FindProductsByStoreIDs(storeIDs)
 	if err != nil {
 		return nil, err
 	}
 
-	var result []*model.User
-	for _, user := range users {
-		result = append(result, convertUserToGraphQL(user))
+	var result []*model.Product
+	for _, product := range products {
+		result = append(result, convertProductToGraphQL(product, r.DB))
 	}
 
 	return result, nil
 }
 
-// User is the resolver for the user field.
-func (r *queryResolver) User(ctx context.Context, id string) (*model.User, error) {
-	if err := validators.ValidateObjectID(id, "User ID"); err != nil {
+// Product is the resolver for the product field.
+func (r *queryResolver) Product(ctx context.Context, id string) (*model.Product, error) {
+	if err := validators.ValidateObjectID(id, "Product ID"); err != nil {
 		return nil, err
 	}
-	currentUser, err := r.RequireAuthenticated(ctx)
-	if err != nil {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	user, err := r.DB.FindUserByID(id)
+	product, err := r.DB.FindProductByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Verify user belongs to same company
-	if user.CompanyID != currentUser.CompanyID {
-		return nil, gqlerror.Errorf("User not found")
+	// Verify store access
+	hasAccess, err := r.HasStoreAccess(ctx, product.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return nil, gqlerror.Errorf("You don't have access to this product's store")
 	}
 
-	return convertUserToGraphQL(user), nil
+	return convertProductToGraphQL(product, r.DB), nil
 }
 
-// Company is the resolver for the company field.
-func (r *queryResolver) Company(ctx context.Context) (*model.Company, error) {
-	currentUser, err := r.RequireAuthenticated(ctx)
-	if err != nil {
+// ProductByBarcode is the resolver for the productByBarcode field.
+func (r *queryResolver) ProductByBarcode(ctx context.Context, storeID string, code string) ([]*model.ProductInStock, error) {
+	if err := validators.ValidateObjectID(storeID, "Store ID"); err != nil {
 		return nil, err
 	}
-
-	// Check if user has a company
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return nil, gqlerror.Errorf("User does not have a company yet. Please create one using createCompany mutation.")
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return nil, gqlerror.Errorf("Code is required")
 	}
-
-	company, err := r.DB.FindCompanyByID(currentUser.CompanyID.Hex())
-	if err != nil {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	return convertCompanyToGraphQL(company, r.DB, true), nil
-}
-
-// ExchangeRates is the resolver for the exchangeRates field.
-func (r *queryResolver) ExchangeRates(ctx context.Context) ([]*model.ExchangeRate, error) {
-	currentUser, err := r.RequireAuthenticated(ctx)
-	if err != nil {
+	if err := r.RequireStoreAccess(ctx, storeID); err != nil {
 		return nil, err
 	}
-
-	// Verify that the user has a company
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return nil, gqlerror.Errorf("User does not have a company yet")
+
+	storeObjectID, _ := primitive.ObjectIDFromHex(storeID)
+	product, err := r.DB.FindProductByCode(storeObjectID, code)
+	if err != nil {
+		return nil, err
 	}
 
-	// Get exchange rates from database
-	rates, err := r.DB.GetCompanyExchangeRates(currentUser.CompanyID.Hex())
+	productsInStock, err := r.DB.FindSellableProductsInStock(product.ID, storeObjectID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Convert to GraphQL model
-	var rateModels []*model.ExchangeRate
-	for _, rate := range rates {
-		rateModels = append(rateModels, convertExchangeRateToGraphQL(&rate))
+	result := make([]*model.ProductInStock, 0, len(productsInStock))
+	for _, pis := range productsInStock {
+		result = append(result, convertProductInStockToGraphQL(pis, r.DB))
 	}
 
-	return rateModels, nil
+	return result, nil
 }
 
-// ConvertCurrency is the resolver for the convertCurrency field.
-func (r *queryResolver) ConvertCurrency(ctx context.Context, amount float64, fromCurrency string, toCurrency string) (float64, error) {
-	currentUser, err := r.GetUserFromContext(ctx)
-	if err != nil || currentUser == nil {
-		return 0, gqlerror.Errorf("Unauthorized")
+// ProductsInStock is the resolver for the productsInStock field.
+func (r *queryResolver) ProductsInStock(ctx context.Context, storeID *string, productID *string, providerID *string) ([]*model.ProductInStock, error) {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
 	}
 
-	// Verify that the user has a company
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return 0, gqlerror.Errorf("User does not have a company yet")
+	var storeIDs []primitive.ObjectID
+	var err error
+	if storeID != nil {
+		if err := validators.ValidateObjectID(*storeID, "Store ID"); err != nil {
+			return nil, err
+		}
+		hasAccess, err := r.HasStoreAccess(ctx, *storeID)
+		if err != nil || !hasAccess {
+			return nil, gqlerror.Errorf("You don't have access to this store")
+		}
+		id, _ := primitive.ObjectIDFromHex(*storeID)
+		storeIDs = []primitive.ObjectID{id}
+	} else {
+		accessibleStoreIDs, _ := r.GetAccessibleStoreIDs(ctx)
+		for _, id := range accessibleStoreIDs {
+			objectID, _ := primitive.ObjectIDFromHex(id)
+			storeIDs = append(storeIDs, objectID)
+		}
 	}
 
-	// Validate amount
-	if amount < 0 {
-		return 0, gqlerror.Errorf("Amount must be positive")
+	var productsInStock []*database.ProductInStock
+	if productID != nil && *productID != "" {
+		productsInStock, err = r.DB.FindProductsInStockByProductID(*productID, storeIDs)
+	} else if providerID != nil && *providerID != "" {
+		productsInStock, err = r.DB.FindProductsInStockByProviderID(*providerID, storeIDs)
+	} else {
+		productsInStock, err = r.DB.FindProductsInStockByStoreIDs(storeIDs)
 	}
-
-	// Convert currency using the company's exchange rates
-	convertedAmount, err := r.DB.ConvertCurrency(currentUser.CompanyID.Hex(), amount, fromCurrency, toCurrency)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	return convertedAmount, nil
+	var result []*model.ProductInStock
+	for _, pis := range productsInStock {
+		result = append(result, convertProductInStockToGraphQL(pis, r.DB))
+	}
+
+	return result, nil
 }
 
-// Subscription is the resolver for the subscription field.
-func (r *queryResolver) Subscription(ctx context.Context) (*model.CompanySubscription, error) {
-	currentUser, err := r.RequireAuthenticated(ctx)
-	if err != nil {
+// ProductInStock is the resolver for the productInStock field.
+func (r *queryResolver) ProductInStock(ctx context.Context, id string) (*model.ProductInStock, error) {
+	if err := validators.ValidateObjectID(id, "Product In Stock ID"); err != nil {
 		return nil, err
 	}
-
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return nil, gqlerror.Errorf("User does not have a company yet")
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
 	}
 
-	subscription, err := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
+	productInStock, err := r.DB.FindProductInStockByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	sub := convertSubscriptionToGraphQL(subscription)
-	return sub, nil
+	hasAccess, err := r.HasStoreAccess(ctx, productInStock.StoreID.Hex())
+	if err != nil || !hasAccess {
+		return nil, gqlerror.Errorf("You don't have access to this product in stock's store")
+	}
+
+	return convertProductInStockToGraphQL(productInStock, r.DB), nil
 }
 
-// CheckSubscriptionStatus is the resolver for the checkSubscriptionStatus field.
-func (r *queryResolver) CheckSubscriptionStatus(ctx context.Context) (*model.SubscriptionStatus, error) {
-	currentUser, err := r.RequireAuthenticated(ctx)
-	if err != nil {
+// LowStockProducts is the resolver for the lowStockProducts field.
+func (r *queryResolver) LowStockProducts(ctx context.Context, storeID *string) ([]*model.LowStockProduct, error) {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return &model.SubscriptionStatus{
-			IsValid:    false,
-			HasLicense: false,
-			Message:    stringPtr("User does not have a company yet"),
-		}, nil
+	var storeIDs []primitive.ObjectID
+	if storeID != nil {
+		if err := validators.ValidateObjectID(*storeID, "Store ID"); err != nil {
+			return nil, err
+		}
+		hasAccess, err := r.HasStoreAccess(ctx, *storeID)
+		if err != nil || !hasAccess {
+			return nil, gqlerror.Errorf("You don't have access to this store")
+		}
+		id, _ := primitive.ObjectIDFromHex(*storeID)
+		storeIDs = []primitive.ObjectID{id}
+	} else {
+		accessibleStoreIDs, _ := r.GetAccessibleStoreIDs(ctx)
+		for _, id := range accessibleStoreIDs {
+			objectID, _ := primitive.ObjectIDFromHex(id)
+			storeIDs = append(storeIDs, objectID)
+		}
 	}
 
-	// Check if company has a valid license ID
-	company, err := r.DB.FindCompanyByID(currentUser.CompanyID.Hex())
-	hasLicense := false
-	if err == nil && company != nil && company.LicenseID != nil && *company.LicenseID != "" {
-		hasLicense = true
+	if len(storeIDs) == 0 {
+		return []*model.LowStockProduct{}, nil
 	}
 
-	subscriptionService := services.NewSubscriptionService(r.DB)
-	err = subscriptionService.ValidateSubscription(ctx, currentUser.CompanyID.Hex())
-
-	subscription, _ := r.DB.GetCompanySubscription(currentUser.CompanyID.Hex())
-	var subModel *model.CompanySubscription
-	if subscription != nil {
-		subModel = convertSubscriptionToGraphQL(subscription)
+	lowStockProducts, err := r.DB.FindLowStockProducts(storeIDs)
+	if err != nil {
+		return nil, err
 	}
 
-	if err != nil {
-		return &model.SubscriptionStatus{
-			IsValid:      false,
-			HasLicense:   hasLicense,
-			Message:      stringPtr(err.Error()),
-			Subscription: subModel,
-		}, nil
+	result := make([]*model.LowStockProduct, 0, len(lowStockProducts))
+	for _, lowStock := range lowStockProducts {
+		result = append(result, convertLowStockProductToGraphQL(lowStock, r.DB))
 	}
 
-	return &model.SubscriptionStatus{
-		IsValid:      true,
-		HasLicense:   hasLicense,
-		Message:      nil,
-		Subscription: subModel,
-	}, nil
+	return result, nil
 }
 
-// SubscriptionPlans is the resolver for the subscriptionPlans field.
-func (r *queryResolver) SubscriptionPlans(ctx context.Context) ([]*model.SubscriptionPlan, error) {
+// StockAlerts is the resolver for the stockAlerts field.
+func (r *queryResolver) StockAlerts(ctx context.Context, storeID *string, status *string) ([]*model.StockAlert, error) {
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	plans, err := r.DB.GetAllSubscriptionPlans()
+	var storeIDs []primitive.ObjectID
+	if storeID != nil {
+		if err := validators.ValidateObjectID(*storeID, "Store ID"); err != nil {
+			return nil, err
+		}
+		hasAccess, err := r.HasStoreAccess(ctx, *storeID)
+		if err != nil || !hasAccess {
+			return nil, gqlerror.Errorf("You don't have access to this store")
+		}
+		id, _ := primitive.ObjectIDFromHex(*storeID)
+		storeIDs = []primitive.ObjectID{id}
+	} else {
+		accessibleStoreIDs, _ := r.GetAccessibleStoreIDs(ctx)
+		for _, id := range accessibleStoreIDs {
+			objectID, _ := primitive.ObjectIDFromHex(id)
+			storeIDs = append(storeIDs, objectID)
+		}
+	}
+
+	if len(storeIDs) == 0 {
+		return []*model.StockAlert{}, nil
+	}
+
+	alerts, err := r.DB.GetStockAlertsByStoreIDs(storeIDs, status)
 	if err != nil {
 		return nil, err
 	}
 
-	result := make([]*model.SubscriptionPlan, 0, len(plans))
-	for _, plan := range plans {
-		result = append(result, convertSubscriptionPlanToGraphQL(plan))
+	result := make([]*model.StockAlert, 0, len(alerts))
+	for _, alert := range alerts {
+		result = append(result, convertStockAlertToGraphQL(alert, r.DB))
 	}
 
 	return result, nil
 }
 
-// SubscriptionPlan is the resolver for the subscriptionPlan field.
-func (r *queryResolver) SubscriptionPlan(ctx context.Context, id string) (*model.SubscriptionPlan, error) {
-	if _, err := r.RequireAuthenticated(ctx); err != nil {
+// ReorderSuggestions is the resolver for the reorderSuggestions field.
+func (r *queryResolver) ReorderSuggestions(ctx context.Context, storeID string, horizonDays int, lookbackDays *int) ([]*model.ReorderSuggestionGroup, error) {
+	if err := validators.ValidateObjectID(storeID, "Store ID"); err != nil {
 		return nil, err
 	}
-
-	plan, err := r.DB.GetSubscriptionPlanByID(id)
-	if err != nil {
-		return nil, err
+	if horizonDays < 1 || horizonDays > 365 {
+		return nil, gqlerror.Errorf("horizonDays must be between 1 and 365")
 	}
-
-	return convertSubscriptionPlanToGraphQL(plan), nil
-}
-
-// Stores is the resolver for the stores field.
-func (r *queryResolver) Stores(ctx context.Context) ([]*model.Store, error) {
-	currentUser, err := r.RequireAuthenticated(ctx)
-	if err != nil {
+	lookback := database.DefaultReorderLookbackDays
+	if lookbackDays != nil {
+		if *lookbackDays < 1 || *lookbackDays > 365 {
+			return nil, gqlerror.Errorf("lookbackDays must be between 1 and 365")
+		}
+		lookback = *lookbackDays
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	// Check if user has a company
-	if currentUser.CompanyID == primitive.NilObjectID {
-		return []*model.Store{}, nil // Return empty list if no company
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
 	}
 
-	var stores []*database.Store
-	if currentUser.Role == "Admin" {
-		stores, err = r.DB.FindStoresByCompanyID(currentUser.CompanyID.Hex())
-	} else {
-		// User can only see assigned store
-		if currentUser.AssignedStoreID != nil {
-			store, err := r.DB.FindStoreByID(currentUser.AssignedStoreID.Hex())
-			if err == nil {
-				stores = []*database.Store{store}
-			}
-		}
+	if err := r.RequireStoreAccess(ctx, storeID); err != nil {
+		return nil, err
 	}
 
+	storeObjectID, _ := primitive.ObjectIDFromHex(storeID)
+	groups, err := r.DB.GetReorderSuggestions(storeObjectID, horizonDays, lookback)
 	if err != nil {
 		return nil, err
 	}
 
-	var result []*model.Store
-	for _, store := range stores {
-		result = append(result, convertStoreToGraphQL(store, r.DB, true))
+	result := make([]*model.ReorderSuggestionGroup, 0, len(groups))
+	for _, group := range groups {
+		result = append(result, convertReorderSuggestionGroupToGraphQL(group, storeObjectID, r.DB))
 	}
 
 	return result, nil
 }
 
-// Store is the resolver for the store field.
-func (r *queryResolver) Store(ctx context.Context, id string) (*model.Store, error) {
-	if err := validators.ValidateObjectID(id, "Store ID"); err != nil {
-		return nil, err
+// ExpiringStock is the resolver for the expiringStock field.
+func (r *queryResolver) ExpiringStock(ctx context.Context, storeID *string, withinDays int) ([]*model.ProductInStock, error) {
+	if withinDays < 0 || withinDays > 3650 {
+		return nil, gqlerror.Errorf("withinDays must be between 0 and 3650")
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	// Verify store access
-	hasAccess, err := r.HasStoreAccess(ctx, id)
-	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this store")
+	var storeIDs []primitive.ObjectID
+	if storeID != nil {
+		if err := validators.ValidateObjectID(*storeID, "Store ID"); err != nil {
+			return nil, err
+		}
+		hasAccess, err := r.HasStoreAccess(ctx, *storeID)
+		if err != nil || !hasAccess {
+			return nil, gqlerror.Errorf("You don't have access to this store")
+		}
+		id, _ := primitive.ObjectIDFromHex(*storeID)
+		storeIDs = []primitive.ObjectID{id}
+	} else {
+		accessibleStoreIDs, _ := r.GetAccessibleStoreIDs(ctx)
+		for _, id := range accessibleStoreIDs {
+			objectID, _ := primitive.ObjectIDFromHex(id)
+			storeIDs = append(storeIDs, objectID)
+		}
+	}
+	if len(storeIDs) == 0 {
+		return []*model.ProductInStock{}, nil
 	}
 
-	store, err := r.DB.FindStoreByID(id)
+	before := time.Now().AddDate(0, 0, withinDays)
+	productsInStock, err := r.DB.FindExpiringProductsInStock(storeIDs, before)
 	if err != nil {
 		return nil, err
 	}
 
-	return convertStoreToGraphQL(store, r.DB, true), nil
+	result := make([]*model.ProductInStock, 0, len(productsInStock))
+	for _, pis := range productsInStock {
+		result = append(result, convertProductInStockToGraphQL(pis, r.DB))
+	}
+
+	return result, nil
 }
 
-// Products is the resolver for the products field.
-func (r *queryResolver) Products(ctx context.Context, storeID *string) ([]*model.Product, error) {
+// StockSupplies is the resolver for the stockSupplies field.
+func (r *queryResolver) StockSupplies(ctx context.Context, storeID *string, productID *string, providerID *string) ([]*model.StockSupply, error) {
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
 	var storeIDs []primitive.ObjectID
+	var err error
 	if storeID != nil {
 		if err := validators.ValidateObjectID(*storeID, "Store ID"); err != nil {
 			return nil, err
 		}
-		// Verify access to specific store
 		hasAccess, err := r.HasStoreAccess(ctx, *storeID)
 		if err != nil || !hasAccess {
 			return nil, gqlerror.Errorf("You don't have access to this store")
@@ -2477,63 +4327,63 @@ func (r *queryResolver) Products(ctx context.Context, storeID *string) ([]*model
 		id, _ := primitive.ObjectIDFromHex(*storeID)
 		storeIDs = []primitive.ObjectID{id}
 	} else {
-		// Get accessible stores
 		accessibleStoreIDs, _ := r.GetAccessibleStoreIDs(ctx)
 		for _, id := range accessibleStoreIDs {
 			objectID, _ := primitive.ObjectIDFromHex(id)
 			storeIDs = append(storeIDs, objectID)
 		}
 	}
-
-	// Find all products in accessible stores (products are now just templates)
-	products, err := r.DB.FindProductsByStoreIDs(storeIDs)
+
+	var supplies []*database.StockSupply
+	if productID != nil && *productID != "" {
+		supplies, err = r.DB.FindStockSuppliesByProductID(*productID, storeIDs)
+	} else if providerID != nil && *providerID != "" {
+		supplies, err = r.DB.FindStockSuppliesByProviderID(*providerID, storeIDs)
+	} else {
+		supplies, err = r.DB.FindStockSuppliesByStoreIDs(storeIDs)
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	var result []*model.Product
-	for _, product := range products {
-		result = append(result, convertProductToGraphQL(product, r.DB))
+	var result []*model.StockSupply
+	for _, supply := range supplies {
+		result = append(result, convertStockSupplyToGraphQL(supply, r.DB))
 	}
 
 	return result, nil
 }
 
-// Product is the resolver for the product field.
-func (r *queryResolver) Product(ctx context.Context, id string) (*model.Product, error) {
-	if err := validators.ValidateObjectID(id, "Product ID"); err != nil {
+// StockSupply is the resolver for the stockSupply field.
+func (r *queryResolver) StockSupply(ctx context.Context, id string) (*model.StockSupply, error) {
+	if err := validators.ValidateObjectID(id, "Stock Supply ID"); err != nil {
 		return nil, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	product, err := r.DB.FindProductByID(id)
+	supply, err := r.DB.FindStockSupplyByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	// Verify store access
-	hasAccess, err := r.HasStoreAccess(ctx, product.StoreID.Hex())
+	hasAccess, err := r.HasStoreAccess(ctx, supply.StoreID.Hex())
 	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this product's store")
+		return nil, gqlerror.Errorf("You don't have access to this stock supply's store")
 	}
 
-	return convertProductToGraphQL(product, r.DB), nil
+	return convertStockSupplyToGraphQL(supply, r.DB), nil
 }
 
-// ProductsInStock is the resolver for the productsInStock field.
-func (r *queryResolver) ProductsInStock(ctx context.Context, storeID *string, productID *string, providerID *string) ([]*model.ProductInStock, error) {
+// PurchaseOrders is the resolver for the purchaseOrders field.
+func (r *queryResolver) PurchaseOrders(ctx context.Context, storeID *string, providerID *string, status *string) ([]*model.PurchaseOrder, error) {
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
 	var storeIDs []primitive.ObjectID
-	var err error
 	if storeID != nil {
-		if err := validators.ValidateObjectID(*storeID, "Store ID"); err != nil {
-			return nil, err
-		}
 		hasAccess, err := r.HasStoreAccess(ctx, *storeID)
 		if err != nil || !hasAccess {
 			return nil, gqlerror.Errorf("You don't have access to this store")
@@ -2548,60 +4398,62 @@ func (r *queryResolver) ProductsInStock(ctx context.Context, storeID *string, pr
 		}
 	}
 
-	var productsInStock []*database.ProductInStock
-	if productID != nil && *productID != "" {
-		productsInStock, err = r.DB.FindProductsInStockByProductID(*productID, storeIDs)
-	} else if providerID != nil && *providerID != "" {
-		productsInStock, err = r.DB.FindProductsInStockByProviderID(*providerID, storeIDs)
-	} else {
-		productsInStock, err = r.DB.FindProductsInStockByStoreIDs(storeIDs)
+	if len(storeIDs) == 0 {
+		return []*model.PurchaseOrder{}, nil
+	}
+
+	var providerObjectID *primitive.ObjectID
+	if providerID != nil && *providerID != "" {
+		if err := validators.ValidateObjectID(*providerID, "Provider ID"); err != nil {
+			return nil, err
+		}
+		id, _ := primitive.ObjectIDFromHex(*providerID)
+		providerObjectID = &id
 	}
+
+	orders, err := r.DB.GetPurchaseOrdersByStoreIDs(storeIDs, providerObjectID, status)
 	if err != nil {
 		return nil, err
 	}
 
-	var result []*model.ProductInStock
-	for _, pis := range productsInStock {
-		result = append(result, convertProductInStockToGraphQL(pis, r.DB))
+	result := make([]*model.PurchaseOrder, 0, len(orders))
+	for _, order := range orders {
+		result = append(result, convertPurchaseOrderToGraphQL(order, r.DB))
 	}
 
 	return result, nil
 }
 
-// ProductInStock is the resolver for the productInStock field.
-func (r *queryResolver) ProductInStock(ctx context.Context, id string) (*model.ProductInStock, error) {
-	if err := validators.ValidateObjectID(id, "Product In Stock ID"); err != nil {
+// PurchaseOrder is the resolver for the purchaseOrder field.
+func (r *queryResolver) PurchaseOrder(ctx context.Context, id string) (*model.PurchaseOrder, error) {
+	if err := validators.ValidateObjectID(id, "Purchase Order ID"); err != nil {
 		return nil, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	productInStock, err := r.DB.FindProductInStockByID(id)
+	order, err := r.DB.GetPurchaseOrderByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	hasAccess, err := r.HasStoreAccess(ctx, productInStock.StoreID.Hex())
+	hasAccess, err := r.HasStoreAccess(ctx, order.StoreID.Hex())
 	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this product in stock's store")
+		return nil, gqlerror.Errorf("You don't have access to this purchase order's store")
 	}
 
-	return convertProductInStockToGraphQL(productInStock, r.DB), nil
+	return convertPurchaseOrderToGraphQL(order, r.DB), nil
 }
 
-// StockSupplies is the resolver for the stockSupplies field.
-func (r *queryResolver) StockSupplies(ctx context.Context, storeID *string, productID *string, providerID *string) ([]*model.StockSupply, error) {
+// StockTransfers is the resolver for the stockTransfers field.
+func (r *queryResolver) StockTransfers(ctx context.Context, storeID *string, status *string) ([]*model.StockTransfer, error) {
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
 	var storeIDs []primitive.ObjectID
-	var err error
 	if storeID != nil {
-		if err := validators.ValidateObjectID(*storeID, "Store ID"); err != nil {
-			return nil, err
-		}
 		hasAccess, err := r.HasStoreAccess(ctx, *storeID)
 		if err != nil || !hasAccess {
 			return nil, gqlerror.Errorf("You don't have access to this store")
@@ -2616,46 +4468,45 @@ func (r *queryResolver) StockSupplies(ctx context.Context, storeID *string, prod
 		}
 	}
 
-	var supplies []*database.StockSupply
-	if productID != nil && *productID != "" {
-		supplies, err = r.DB.FindStockSuppliesByProductID(*productID, storeIDs)
-	} else if providerID != nil && *providerID != "" {
-		supplies, err = r.DB.FindStockSuppliesByProviderID(*providerID, storeIDs)
-	} else {
-		supplies, err = r.DB.FindStockSuppliesByStoreIDs(storeIDs)
+	if len(storeIDs) == 0 {
+		return []*model.StockTransfer{}, nil
 	}
+
+	transfers, err := r.DB.GetStockTransfersByStoreIDs(storeIDs, status)
 	if err != nil {
 		return nil, err
 	}
 
-	var result []*model.StockSupply
-	for _, supply := range supplies {
-		result = append(result, convertStockSupplyToGraphQL(supply, r.DB))
+	result := make([]*model.StockTransfer, 0, len(transfers))
+	for _, transfer := range transfers {
+		result = append(result, convertStockTransferToGraphQL(transfer, r.DB))
 	}
 
 	return result, nil
 }
 
-// StockSupply is the resolver for the stockSupply field.
-func (r *queryResolver) StockSupply(ctx context.Context, id string) (*model.StockSupply, error) {
-	if err := validators.ValidateObjectID(id, "Stock Supply ID"); err != nil {
+// StockTransfer is the resolver for the stockTransfer field.
+func (r *queryResolver) StockTransfer(ctx context.Context, id string) (*model.StockTransfer, error) {
+	if err := validators.ValidateObjectID(id, "Stock Transfer ID"); err != nil {
 		return nil, err
 	}
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
 		return nil, err
 	}
 
-	supply, err := r.DB.FindStockSupplyByID(id)
+	transfer, err := r.DB.GetStockTransferByID(id)
 	if err != nil {
 		return nil, err
 	}
 
-	hasAccess, err := r.HasStoreAccess(ctx, supply.StoreID.Hex())
-	if err != nil || !hasAccess {
-		return nil, gqlerror.Errorf("You don't have access to this stock supply's store")
+	// Both ends of the transfer can see it
+	hasFromAccess, _ := r.HasStoreAccess(ctx, transfer.FromStoreID.Hex())
+	hasToAccess, _ := r.HasStoreAccess(ctx, transfer.ToStoreID.Hex())
+	if !hasFromAccess && !hasToAccess {
+		return nil, gqlerror.Errorf("You don't have access to this stock transfer's stores")
 	}
 
-	return convertStockSupplyToGraphQL(supply, r.DB), nil
+	return convertStockTransferToGraphQL(transfer, r.DB), nil
 }
 
 // ProviderDebts is the resolver for the providerDebts field.
@@ -3270,11 +5121,12 @@ func (r *queryResolver) SalesStats(ctx context.Context, storeID *string, period
 
 	if len(storeIDs) == 0 {
 		return &model.SalesStats{
-			TotalSales:    0,
-			TotalRevenue:  0,
-			TotalItems:    0,
-			AverageSale:   0,
-			TotalBenefice: 0,
+			TotalSales:      0,
+			TotalRevenue:    0,
+			TotalItems:      0,
+			AverageSale:     0,
+			TotalBenefice:   costValue(0),
+			ByPaymentMethod: []*model.PaymentMethodTotal{},
 		}, nil
 	}
 
@@ -3283,6 +5135,11 @@ func (r *queryResolver) SalesStats(ctx context.Context, storeID *string, period
 		return nil, err
 	}
 
+	byPaymentMethod, err := r.DB.GetPaymentMethodStatsByStoreIDs(storeIDs, period, startDate, endDate, currency)
+	if err != nil {
+		return nil, err
+	}
+
 	// Calculate benefice using optimized aggregation pipeline (avoids N+1 queries)
 	totalBenefice, err := r.DB.CalculateTotalBeneficeByStoreIDs(storeIDs, period, startDate, endDate, currency)
 	if err != nil {
@@ -3291,11 +5148,13 @@ func (r *queryResolver) SalesStats(ctx context.Context, storeID *string, period
 	}
 
 	return &model.SalesStats{
-		TotalSales:    int(stats.TotalSales),
-		TotalRevenue:  stats.TotalRevenue,
-		TotalItems:    stats.TotalItems,
-		AverageSale:   stats.AverageSale,
-		TotalBenefice: totalBenefice,
+		TotalSales:      int(stats.TotalSales),
+		TotalRevenue:    stats.TotalRevenue,
+		TotalItems:      stats.TotalItems,
+		AverageSale:     stats.AverageSale,
+		TotalBenefice:   costValue(totalBenefice),
+		TotalDiscount:   stats.TotalDiscount,
+		ByPaymentMethod: convertPaymentMethodTotalsToGraphQL(byPaymentMethod),
 	}, nil
 }
 
@@ -3322,6 +5181,162 @@ func (r *queryResolver) Sale(ctx context.Context, id string) (*model.Sale, error
 	return convertSaleToGraphQL(sale, r.DB), nil
 }
 
+// SaleReturns is the resolver for the saleReturns field.
+func (r *queryResolver) SaleReturns(ctx context.Context, storeID *string, saleID *string) ([]*model.SaleReturn, error) {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	var storeIDs []primitive.ObjectID
+	if storeID != nil {
+		hasAccess, err := r.HasStoreAccess(ctx, *storeID)
+		if err != nil || !hasAccess {
+			return nil, gqlerror.Errorf("You don't have access to this store")
+		}
+		id, _ := primitive.ObjectIDFromHex(*storeID)
+		storeIDs = []primitive.ObjectID{id}
+	} else {
+		accessibleStoreIDs, _ := r.GetAccessibleStoreIDs(ctx)
+		for _, id := range accessibleStoreIDs {
+			objectID, _ := primitive.ObjectIDFromHex(id)
+			storeIDs = append(storeIDs, objectID)
+		}
+	}
+
+	if len(storeIDs) == 0 {
+		return []*model.SaleReturn{}, nil
+	}
+
+	var saleObjectID *primitive.ObjectID
+	if saleID != nil {
+		if err := validators.ValidateObjectID(*saleID, "Sale ID"); err != nil {
+			return nil, err
+		}
+		id, _ := primitive.ObjectIDFromHex(*saleID)
+		saleObjectID = &id
+	}
+
+	returns, err := r.DB.FindSaleReturnsByStoreIDs(storeIDs, saleObjectID)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.SaleReturn, 0, len(returns))
+	for _, saleReturn := range returns {
+		result = append(result, convertSaleReturnToGraphQL(saleReturn, r.DB))
+	}
+
+	return result, nil
+}
+
+// Promotions is the resolver for the promotions field.
+func (r *queryResolver) Promotions(ctx context.Context, storeID *string, activeOnly *bool) ([]*model.Promotion, error) {
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var storeObjectID *primitive.ObjectID
+	if storeID != nil {
+		if err := r.RequireStoreAccess(ctx, *storeID); err != nil {
+			return nil, err
+		}
+		id, _ := primitive.ObjectIDFromHex(*storeID)
+		storeObjectID = &id
+	}
+
+	promotions, err := r.DB.FindPromotions(currentUser.CompanyID, storeObjectID, activeOnly != nil && *activeOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	// Promotions of the company or of at least one accessible store
+	accessibleStoreIDs, _ := r.GetAccessibleStoreIDs(ctx)
+	accessible := make(map[string]bool, len(accessibleStoreIDs))
+	for _, id := range accessibleStoreIDs {
+		accessible[id] = true
+	}
+
+	result := make([]*model.Promotion, 0, len(promotions))
+	for _, promotion := range promotions {
+		visible := len(promotion.StoreIDs) == 0
+		for _, id := range promotion.StoreIDs {
+			if accessible[id.Hex()] {
+				visible = true
+				break
+			}
+		}
+		if visible {
+			result = append(result, convertPromotionToGraphQL(promotion))
+		}
+	}
+
+	return result, nil
+}
+
+// PaymentIntents is the resolver for the paymentIntents field.
+func (r *queryResolver) PaymentIntents(ctx context.Context, storeID *string, status *string, limit *int) ([]*model.PaymentIntent, error) {
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	var storeIDs []primitive.ObjectID
+	if storeID != nil {
+		if err := r.RequireStoreAccess(ctx, *storeID); err != nil {
+			return nil, err
+		}
+		id, _ := primitive.ObjectIDFromHex(*storeID)
+		storeIDs = []primitive.ObjectID{id}
+	} else {
+		accessibleStoreIDs, _ := r.GetAccessibleStoreIDs(ctx)
+		for _, id := range accessibleStoreIDs {
+			objectID, _ := primitive.ObjectIDFromHex(id)
+			storeIDs = append(storeIDs, objectID)
+		}
+	}
+
+	if len(storeIDs) == 0 {
+		return []*model.PaymentIntent{}, nil
+	}
+
+	maxResults := 100
+	if limit != nil && *limit > 0 && *limit < maxResults {
+		maxResults = *limit
+	}
+
+	intents, err := r.DB.FindPaymentIntents(storeIDs, status, maxResults)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]*model.PaymentIntent, len(intents))
+	for i, intent := range intents {
+		result[i] = convertPaymentIntentToGraphQL(intent)
+	}
+	return result, nil
+}
+
+// PaymentIntent is the resolver for the paymentIntent field.
+func (r *queryResolver) PaymentIntent(ctx context.Context, id string) (*model.PaymentIntent, error) {
+	if err := validators.ValidateObjectID(id, "Payment intent ID"); err != nil {
+		return nil, err
+	}
+	currentUser, err := r.RequireAuthenticated(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	intent, err := r.DB.FindPaymentIntentByID(id, currentUser.CompanyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.RequireStoreAccess(ctx, intent.StoreID.Hex()); err != nil {
+		return nil, err
+	}
+
+	return convertPaymentIntentToGraphQL(intent), nil
+}
+
 // Debts is the resolver for the debts field.
 func (r *queryResolver) Debts(ctx context.Context, storeID *string, status *string) ([]*model.Debt, error) {
 	if _, err := r.RequireAuthenticated(ctx); err != nil {
@@ -3661,14 +5676,110 @@ func (r *queryResolver) StockStats(ctx context.Context, storeID *string, product
 	return convertStockStatsToGraphQL(stats, r.DB), nil
 }
 
+// SaleCreated is the resolver for the saleCreated field.
+func (r *subscriptionResolver) SaleCreated(ctx context.Context, storeID string) (<-chan *model.Sale, error) {
+	if err := validators.ValidateObjectID(storeID, "Store ID"); err != nil {
+		return nil, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := r.RequireStoreAccess(ctx, storeID); err != nil {
+		return nil, err
+	}
+
+	ch, unsubscribe := r.DB.Events().Subscribe(events.SaleCreated, storeID)
+	return forwardEvents(ctx, ch, unsubscribe, func(event events.Event) *model.Sale {
+		sale, ok := event.Payload.(*database.Sale)
+		if !ok {
+			return nil
+		}
+		return convertSaleToGraphQL(sale, r.DB)
+	}), nil
+}
+
+// CaisseUpdated is the resolver for the caisseUpdated field.
+func (r *subscriptionResolver) CaisseUpdated(ctx context.Context, storeID string, currency string) (<-chan *model.Caisse, error) {
+	if err := validators.ValidateObjectID(storeID, "Store ID"); err != nil {
+		return nil, err
+	}
+	if err := validators.ValidateCurrency(currency); err != nil {
+		return nil, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := r.RequireStoreAccess(ctx, storeID); err != nil {
+		return nil, err
+	}
+
+	ch, unsubscribe := r.DB.Events().Subscribe(events.CaisseUpdated, storeID)
+	return forwardEvents(ctx, ch, unsubscribe, func(event events.Event) *model.Caisse {
+		if event.Currency != currency {
+			return nil
+		}
+		// Send the new balance rather than the transaction, like the caisse query
+		caisse, err := r.DB.FindCaisse(&storeID, &currency, nil)
+		if err != nil {
+			utils.LogError(err, "Failed to load caisse for subscription")
+			return nil
+		}
+		return convertCaisseToGraphQL(caisse, r.DB)
+	}), nil
+}
+
+// StockLevelChanged is the resolver for the stockLevelChanged field.
+func (r *subscriptionResolver) StockLevelChanged(ctx context.Context, storeID string) (<-chan *model.StockMovement, error) {
+	if err := validators.ValidateObjectID(storeID, "Store ID"); err != nil {
+		return nil, err
+	}
+	if _, err := r.RequireAuthenticated(ctx); err != nil {
+		return nil, err
+	}
+
+	// Vérifier l'abonnement
+	if err := r.CheckSubscription(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := r.RequireStoreAccess(ctx, storeID); err != nil {
+		return nil, err
+	}
+
+	ch, unsubscribe := r.DB.Events().Subscribe(events.StockLevelChanged, storeID)
+	return forwardEvents(ctx, ch, unsubscribe, func(event events.Event) *model.StockMovement {
+		movement, ok := event.Payload.(*database.StockMovement)
+		if !ok {
+			return nil
+		}
+		return convertStockMovementToGraphQL(movement, r.DB)
+	}), nil
+}
+
 // Mutation returns MutationResolver implementation.
 func (r *Resolver) Mutation() MutationResolver { return &mutationResolver{r} }
 
 // Query returns QueryResolver implementation.
 func (r *Resolver) Query() QueryResolver { return &queryResolver{r} }
 
+// Subscription returns SubscriptionResolver implementation.
+func (r *Resolver) Subscription() SubscriptionResolver { return &subscriptionResolver{r} }
+
 type mutationResolver struct{ *Resolver }
 type queryResolver struct{ *Resolver }
+type subscriptionResolver struct{ *Resolver }
 
 // !!! WARNING !!!
 // The code below was going to be deleted when updating resolvers. It has been copied here so you have
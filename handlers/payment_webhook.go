@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"rangoapp/services"
+	"rangoapp/utils"
+)
+
+// maxPaymentCallbackSize limits the body of the operator callbacks
+const maxPaymentCallbackSize = 64 << 10
+
+// PaymentWebhookHandler receives the callbacks of the mobile money operator: the signature is checked
+// and a confirmed payment settles its sale or debt (see services.PaymentService)
+func PaymentWebhookHandler(payments *services.PaymentService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxPaymentCallbackSize))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"status": "error", "message": "Invalid body"})
+			return
+		}
+
+		intent, err := payments.HandleCallback(body, r.Header)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var appErr *utils.AppError
+			if errors.As(err, &appErr) {
+				switch appErr.Type {
+				case utils.ErrorTypeUnauthorized:
+					status = http.StatusUnauthorized
+				case utils.ErrorTypeNotFound:
+					status = http.StatusNotFound
+				case utils.ErrorTypeValidation:
+					status = http.StatusBadRequest
+				}
+			}
+			utils.Warning("Payment callback rejected: %v", err)
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"status": "error", "message": http.StatusText(status)})
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]string{
+			"status":          "ok",
+			"paymentIntentId": intent.ID.Hex(),
+			"paymentStatus":   intent.Status,
+		})
+	}
+}
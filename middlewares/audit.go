@@ -0,0 +1,253 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"rangoapp/database"
+	"rangoapp/utils"
+
+	"github.com/99designs/gqlgen/graphql"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// auditRedacted replaces the secret arguments in the audit log
+const auditRedacted = "[REDACTED]"
+
+// auditSecretArgs are the argument names never written to the audit log (any depth)
+var auditSecretArgs = map[string]bool{
+	"password":         true,
+	"currentPassword":  true,
+	"newPassword":      true,
+	"code":             true,
+	"verificationCode": true,
+	"refreshToken":     true,
+	"challengeToken":   true,
+	"token":            true,
+	"secret":           true,
+	"apiKey":           true,
+	"invitationCode":   true,
+}
+
+// auditCompanyTarget marks the mutations on the company of the current user (no id argument)
+const auditCompanyTarget = "@company"
+
+// auditTarget is the document changed by a mutation: its collection and the argument holding its id
+type auditTarget struct {
+	collection string
+	idArg      string
+	delete     bool
+}
+
+// auditTargets lists the updates and deletes whose document is snapshotted before and after the mutation
+var auditTargets = map[string]auditTarget{
+	"updateUser":        {"users", "id", false},
+	"deleteUser":        {"users", "id", true},
+	"blockUser":         {"users", "id", false},
+	"unblockUser":       {"users", "id", false},
+	"assignUserToStore": {"users", "userId", false},
+	"assignUserRole":    {"users", "userId", false},
+	"updateRole":        {"roles", "id", false},
+	"deleteRole":        {"roles", "id", true},
+	"revokeAPIKey":      {"api_keys", "id", false},
+	"revokeInvitation":  {"invitations", "id", false},
+
+	"updateCompany":       {"companies", auditCompanyTarget, false},
+	"deleteCompany":       {"companies", auditCompanyTarget, true},
+	"updateExchangeRates": {"companies", auditCompanyTarget, false},
+	"updateStore":         {"stores", "id", false},
+	"deleteStore":         {"stores", "id", true},
+
+	"updateProduct":        {"products", "id", false},
+	"deleteProduct":        {"products", "id", true},
+	"setReorderPoint":      {"products", "productId", false},
+	"setLotExpiryWriteOff": {"products_in_stock", "productInStockId", false},
+	"completeInventory":    {"inventories", "inventoryId", false},
+	"cancelInventory":      {"inventories", "inventoryId", false},
+	"sendPurchaseOrder":    {"purchase_orders", "id", false},
+	"receivePurchaseOrder": {"purchase_orders", "id", false},
+	"cancelPurchaseOrder":  {"purchase_orders", "id", false},
+	"shipStockTransfer":    {"stock_transfers", "id", false},
+	"receiveStockTransfer": {"stock_transfers", "id", false},
+	"cancelStockTransfer":  {"stock_transfers", "id", false},
+
+	"updateClient":            {"clients", "id", false},
+	"deleteClient":            {"clients", "id", true},
+	"updateClientCreditLimit": {"clients", "clientId", false},
+	"updateProvider":          {"providers", "id", false},
+	"deleteProvider":          {"providers", "id", true},
+	"payDebt":                 {"debts", "debtId", false},
+	"payProviderDebt":         {"provider_debts", "providerDebtId", false},
+
+	"updateFacture":           {"factures", "id", false},
+	"deleteFacture":           {"factures", "id", true},
+	"deleteRapportStore":      {"rapportStore", "id", true},
+	"deleteCaisseTransaction": {"trans", "id", true},
+	"deleteSale":              {"sales", "id", true},
+	"cancelSale":              {"sales", "id", false},
+	"updatePromotion":         {"promotions", "id", false},
+	"deletePromotion":         {"promotions", "id", true},
+}
+
+// AuditExtension records every mutation in the audit log: actor, company, store, arguments
+// (secrets redacted), before/after snapshots of the changed document, error and IP address.
+// An entry that cannot be appended is queued (see database.CreateAuditEntry); one that cannot be
+// queued either fails the mutation, so that no change goes unrecorded without the caller knowing
+type AuditExtension struct {
+	db *database.DB
+}
+
+var _ interface {
+	graphql.HandlerExtension
+	graphql.FieldInterceptor
+} = AuditExtension{}
+
+// NewAuditExtension creates the audit extension (srv.Use)
+func NewAuditExtension(db *database.DB) AuditExtension {
+	return AuditExtension{db: db}
+}
+
+func (AuditExtension) ExtensionName() string {
+	return "AuditLog"
+}
+
+func (AuditExtension) Validate(schema graphql.ExecutableSchema) error {
+	return nil
+}
+
+func (a AuditExtension) InterceptField(ctx context.Context, next graphql.Resolver) (interface{}, error) {
+	fc := graphql.GetFieldContext(ctx)
+	if fc == nil || fc.Object != "Mutation" {
+		return next(ctx)
+	}
+
+	entry := database.AuditEntry{
+		Action:    fc.Field.Name,
+		Details:   database.AuditJSON(redactAuditArgs(fc.Args)),
+		IPAddress: ClientInfo(ctx).IPAddress,
+	}
+	if claims := CtxValue(ctx); claims != nil {
+		if actorID, err := primitive.ObjectIDFromHex(claims.ID); err == nil {
+			entry.ActorID = &actorID
+		}
+		if companyID, err := primitive.ObjectIDFromHex(claims.CompanyID); err == nil {
+			entry.CompanyID = &companyID
+		}
+	}
+	if storeID, err := primitive.ObjectIDFromHex(auditStoreID(fc.Args)); err == nil {
+		entry.StoreID = &storeID
+	}
+
+	target, hasTarget := auditTargets[fc.Field.Name]
+	var targetID primitive.ObjectID
+	if hasTarget {
+		rawID := auditArgString(fc.Args, target.idArg)
+		if target.idArg == auditCompanyTarget && entry.CompanyID != nil {
+			rawID = entry.CompanyID.Hex()
+		}
+		entry.TargetID = rawID
+		// Built-in roles have no ObjectID: no snapshot
+		id, err := primitive.ObjectIDFromHex(rawID)
+		hasTarget = err == nil
+		targetID = id
+	}
+	if hasTarget {
+		entry.Before = a.snapshot(target.collection, targetID)
+	}
+
+	res, err := next(ctx)
+
+	if err != nil {
+		entry.Error = err.Error()
+	} else if hasTarget && !target.delete {
+		entry.After = a.snapshot(target.collection, targetID)
+	}
+
+	if auditErr := a.db.CreateAuditEntry(entry); auditErr != nil {
+		// The mutation is committed: its result is returned (a failure would make the client apply it again).
+		// The entry is logged in full so that it can be added to the audit log by hand
+		utils.LogError(auditErr, "Failed to write audit entry for "+fc.Field.Name)
+		if payload, jsonErr := json.Marshal(entry); jsonErr == nil {
+			utils.Error("AUDIT ENTRY LOST, add it to audit_queue: %s", payload)
+		} else {
+			utils.Error("AUDIT ENTRY LOST for %s by %v at %s", fc.Field.Name, entry.ActorID, time.Now().Format(time.RFC3339))
+		}
+	}
+
+	return res, err
+}
+
+func (a AuditExtension) snapshot(collection string, id primitive.ObjectID) string {
+	snapshot, err := a.db.FindAuditSnapshot(collection, id)
+	if err != nil {
+		utils.LogError(err, "Failed to read audit snapshot")
+	}
+	return snapshot
+}
+
+// redactAuditArgs converts the arguments to plain JSON values and masks the secrets
+func redactAuditArgs(args map[string]interface{}) interface{} {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return nil
+	}
+	var plain interface{}
+	if err := json.Unmarshal(data, &plain); err != nil {
+		return nil
+	}
+	return redactAuditValue(plain)
+}
+
+func redactAuditValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, nested := range v {
+			if auditSecretArgs[key] {
+				v[key] = auditRedacted
+				continue
+			}
+			v[key] = redactAuditValue(nested)
+		}
+		return v
+	case []interface{}:
+		for i, nested := range v {
+			v[i] = redactAuditValue(nested)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// auditStoreID returns the store of the mutation: storeId argument or input.storeId
+func auditStoreID(args map[string]interface{}) string {
+	if storeID := auditArgString(args, "storeId"); storeID != "" {
+		return storeID
+	}
+	if input, ok := args["input"]; ok {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return ""
+		}
+		var fields map[string]interface{}
+		if json.Unmarshal(data, &fields) == nil {
+			return auditArgString(fields, "storeId")
+		}
+	}
+	return ""
+}
+
+// auditArgString returns a string (or *string) argument, "" when absent
+func auditArgString(args map[string]interface{}, name string) string {
+	switch v := args[name].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case *string:
+		if v != nil {
+			return strings.TrimSpace(*v)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type auditTestInput struct {
+	Name     string  `json:"name"`
+	Password *string `json:"password,omitempty"`
+	StoreID  string  `json:"storeId"`
+}
+
+// TestRedactAuditArgs vérifie que les mots de passe et codes n'arrivent jamais dans le journal d'audit
+func TestRedactAuditArgs(t *testing.T) {
+	password := "secret123"
+	redacted := redactAuditArgs(map[string]interface{}{
+		"id":    "42",
+		"input": auditTestInput{Name: "Jean", Password: &password, StoreID: "s1"},
+		"code":  "123456",
+	})
+
+	assert.Equal(t, map[string]interface{}{
+		"id":    "42",
+		"input": map[string]interface{}{"name": "Jean", "password": auditRedacted, "storeId": "s1"},
+		"code":  auditRedacted,
+	}, redacted)
+}
+
+// TestAuditStoreID vérifie la boutique retenue: argument storeId, sinon input.storeId
+func TestAuditStoreID(t *testing.T) {
+	storeID := "s2"
+	assert.Equal(t, "s1", auditStoreID(map[string]interface{}{"storeId": "s1"}))
+	assert.Equal(t, "s2", auditStoreID(map[string]interface{}{"storeId": &storeID}))
+	assert.Equal(t, "s3", auditStoreID(map[string]interface{}{"input": auditTestInput{StoreID: "s3"}}))
+	assert.Empty(t, auditStoreID(map[string]interface{}{"id": "42"}))
+}
@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
@@ -10,6 +11,33 @@ import (
 
 var userCtxKey = &contextKey{"authUser"}
 
+// TokenRevocationChecker checks the revocation list (logout, refresh token reuse, blocked user)
+type TokenRevocationChecker interface {
+	IsTokenRevoked(claims *utils.JwtCustomClaim) (bool, error)
+}
+
+var revocationChecker TokenRevocationChecker
+
+// SetTokenRevocationChecker enables the revocation check of AuthMiddleware and WebsocketInit (called once at startup)
+func SetTokenRevocationChecker(checker TokenRevocationChecker) {
+	revocationChecker = checker
+}
+
+// APIKeyAuthenticator checks the keys of the X-API-Key header (machine-to-machine integrations)
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(rawKey string) (*utils.JwtCustomClaim, error)
+}
+
+var apiKeyAuthenticator APIKeyAuthenticator
+
+// SetAPIKeyAuthenticator enables the X-API-Key header of AuthMiddleware (called once at startup)
+func SetAPIKeyAuthenticator(authenticator APIKeyAuthenticator) {
+	apiKeyAuthenticator = authenticator
+}
+
+// APIKeyHeader carries an API key instead of a Bearer token
+const APIKeyHeader = "X-API-Key"
+
 type contextKey struct {
 	name string
 }
@@ -25,6 +53,19 @@ func AuthMiddleware(next http.Handler) http.Handler {
 		auth := r.Header.Get("Authorization")
 
 		if auth == "" {
+			// No Bearer token: an integration may authenticate with an API key
+			if apiKey := strings.TrimSpace(r.Header.Get(APIKeyHeader)); apiKey != "" && apiKeyAuthenticator != nil {
+				customClaim, err := apiKeyAuthenticator.AuthenticateAPIKey(apiKey)
+				if err != nil {
+					utils.Warning("Rejected API key: %v", err)
+					http.Error(w, "Invalid API key", http.StatusForbidden)
+					return
+				}
+				ctx := context.WithValue(r.Context(), userCtxKey, customClaim)
+				ctx = withPermissionSet(ctx)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
 			next.ServeHTTP(w, r)
 			return
 		}
@@ -52,27 +93,55 @@ func AuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 
-		validate, err := utils.JwtValidate(context.Background(), token)
-		if err != nil || !validate.Valid {
-			utils.LogError(err, "Invalid JWT token")
-			http.Error(w, "Invalid token", http.StatusForbidden)
-			return
-		}
-
-		customClaim, ok := validate.Claims.(*utils.JwtCustomClaim)
-		if !ok || customClaim == nil {
-			utils.Warning("Invalid token claims type assertion")
-			http.Error(w, "Invalid token claims", http.StatusForbidden)
+		customClaim, err := claimsFromToken(token)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
 			return
 		}
 
 		ctx := context.WithValue(r.Context(), userCtxKey, customClaim)
+		ctx = withPermissionSet(ctx)
 
 		r = r.WithContext(ctx)
 		next.ServeHTTP(w, r)
 	})
 }
 
+// claimsFromToken validates a JWT and returns its claims
+func claimsFromToken(token string) (*utils.JwtCustomClaim, error) {
+	validate, err := utils.JwtValidate(context.Background(), token)
+	if err != nil || !validate.Valid {
+		utils.LogError(err, "Invalid JWT token")
+		return nil, errors.New("Invalid token")
+	}
+
+	customClaim, ok := validate.Claims.(*utils.JwtCustomClaim)
+	if !ok || customClaim == nil {
+		utils.Warning("Invalid token claims type assertion")
+		return nil, errors.New("Invalid token claims")
+	}
+
+	// A refresh token only works with the refreshToken mutation, a challenge token with the
+	// two-factor mutations (tokens issued before the "typ" claim have none)
+	if customClaim.TokenType != "" && customClaim.TokenType != utils.TokenTypeAccess {
+		utils.Warning("%s token used as access token", customClaim.TokenType)
+		return nil, errors.New("Invalid token type")
+	}
+
+	if revocationChecker != nil {
+		revoked, err := revocationChecker.IsTokenRevoked(customClaim)
+		if err != nil {
+			utils.LogError(err, "Failed to check token revocation")
+			return nil, errors.New("Unable to verify token")
+		}
+		if revoked {
+			return nil, errors.New("Token has been revoked")
+		}
+	}
+
+	return customClaim, nil
+}
+
 func CtxValue(ctx context.Context) *utils.JwtCustomClaim {
 	raw, _ := ctx.Value(userCtxKey).(*utils.JwtCustomClaim)
 	return raw
@@ -0,0 +1,101 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"rangoapp/database"
+)
+
+var clientInfoCtxKey = &contextKey{"clientInfo"}
+
+// trustedProxies are the reverse proxies (nginx, load balancer) whose X-Forwarded-For is trusted
+var trustedProxies []*net.IPNet
+
+// SetTrustedProxies sets the addresses (IP or CIDR) of the reverse proxies in front of the server
+// (called once at startup). Without trusted proxy, X-Forwarded-For is ignored.
+func SetTrustedProxies(proxies []string) error {
+	networks := make([]*net.IPNet, 0, len(proxies))
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if proxy == "" {
+			continue
+		}
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy address %q", proxy)
+			}
+			if ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy network %q", proxy)
+		}
+		networks = append(networks, network)
+	}
+	trustedProxies = networks
+	return nil
+}
+
+// isTrustedProxy reports whether the address is one of the trusted proxies
+func isTrustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, network := range trustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientInfoMiddleware stores the IP address and user agent of the request in the context
+// (recorded on the session created at login)
+func ClientInfoMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := database.DeviceInfo{
+			IPAddress: clientIP(r),
+			UserAgent: r.UserAgent(),
+		}
+		ctx := context.WithValue(r.Context(), clientInfoCtxKey, info)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ClientInfo returns the IP address and user agent of the request (empty outside HTTP requests)
+func ClientInfo(ctx context.Context) database.DeviceInfo {
+	info, _ := ctx.Value(clientInfoCtxKey).(database.DeviceInfo)
+	return info
+}
+
+// clientIP returns the client address: the remote address, or behind trusted proxies the right-most
+// X-Forwarded-For hop that is not a trusted proxy (the hops on its left are set by the client)
+func clientIP(r *http.Request) string {
+	addr, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		addr = r.RemoteAddr
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0 && isTrustedProxy(addr); i-- {
+		hop := strings.TrimSpace(hops[i])
+		if net.ParseIP(hop) == nil {
+			break
+		}
+		addr = hop
+	}
+	return addr
+}
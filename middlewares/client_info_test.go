@@ -0,0 +1,50 @@
+package middlewares
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Cleanup(func() { trustedProxies = nil })
+
+	request := func(remoteAddr string, forwarded ...string) string {
+		req := httptest.NewRequest("POST", "/query", nil)
+		req.RemoteAddr = remoteAddr
+		for _, header := range forwarded {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+		return clientIP(req)
+	}
+
+	t.Run("Without trusted proxy X-Forwarded-For is ignored", func(t *testing.T) {
+		require.NoError(t, SetTrustedProxies(nil))
+		assert.Equal(t, "203.0.113.7", request("203.0.113.7:4321", "198.51.100.1"))
+	})
+
+	t.Run("Behind a trusted proxy the right-most untrusted hop is the client", func(t *testing.T) {
+		require.NoError(t, SetTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"}))
+		assert.Equal(t, "198.51.100.1", request("127.0.0.1:4321", "198.51.100.1"))
+		assert.Equal(t, "198.51.100.1", request("127.0.0.1:4321", "6.6.6.6, 198.51.100.1, 10.0.0.3"), "Hops set by the client are ignored")
+		assert.Equal(t, "198.51.100.1", request("127.0.0.1:4321", "6.6.6.6", "198.51.100.1"))
+		assert.Equal(t, "127.0.0.1", request("127.0.0.1:4321"))
+	})
+
+	t.Run("A request that does not come from a trusted proxy keeps its remote address", func(t *testing.T) {
+		require.NoError(t, SetTrustedProxies([]string{"127.0.0.1"}))
+		assert.Equal(t, "203.0.113.7", request("203.0.113.7:4321", "198.51.100.1"))
+	})
+
+	t.Run("Invalid hops stop the walk", func(t *testing.T) {
+		require.NoError(t, SetTrustedProxies([]string{"127.0.0.1"}))
+		assert.Equal(t, "127.0.0.1", request("127.0.0.1:4321", "unknown"))
+	})
+
+	t.Run("Invalid proxies are rejected", func(t *testing.T) {
+		assert.Error(t, SetTrustedProxies([]string{"nginx"}))
+		assert.Error(t, SetTrustedProxies([]string{"10.0.0.0/33"}))
+	})
+}
@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// IdempotencyKeyHeader is the header of the retried mutations (see database.BeginIdempotentRequest)
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+var idempotencyKeyCtxKey = &contextKey{"idempotencyKey"}
+
+// IdempotencyMiddleware stores the Idempotency-Key header of the request in the context
+func IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(IdempotencyKeyHeader))
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := context.WithValue(r.Context(), idempotencyKeyCtxKey, key)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// IdempotencyKey returns the Idempotency-Key header of the request (empty if absent)
+func IdempotencyKey(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyCtxKey).(string)
+	return key
+}
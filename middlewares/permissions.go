@@ -0,0 +1,59 @@
+package middlewares
+
+import (
+	"context"
+	"sync"
+)
+
+var permissionsCtxKey = &contextKey{"permissions"}
+
+// permissionSet holds the permissions of the authenticated user, loaded on the first
+// permission check of the request and reused by the next ones (field directives of a list)
+type permissionSet struct {
+	once        sync.Once
+	permissions map[string]bool
+	err         error
+}
+
+// withPermissionSet prepares the permission set of an authenticated request
+func withPermissionSet(ctx context.Context) context.Context {
+	return context.WithValue(ctx, permissionsCtxKey, &permissionSet{})
+}
+
+// UserPermissions returns the permissions of the authenticated user in the company of the token,
+// loaded once per request by load (for an API key, those of its owner limited to the key's permissions)
+func UserPermissions(ctx context.Context, load func(userID, companyID string) ([]string, error)) (map[string]bool, error) {
+	claim := CtxValue(ctx)
+	if claim == nil {
+		return map[string]bool{}, nil
+	}
+
+	loadSet := func() (map[string]bool, error) {
+		permissions, err := load(claim.ID, claim.CompanyID)
+		if err != nil {
+			return nil, err
+		}
+		set := make(map[string]bool, len(permissions))
+		for _, permission := range permissions {
+			set[permission] = true
+		}
+		// An API key only keeps the permissions it was given (and its owner still has)
+		if claim.APIKeyID != "" {
+			keyPermissions := make(map[string]bool, len(claim.Permissions))
+			for _, permission := range claim.Permissions {
+				keyPermissions[permission] = set[permission]
+			}
+			set = keyPermissions
+		}
+		return set, nil
+	}
+
+	set, ok := ctx.Value(permissionsCtxKey).(*permissionSet)
+	if !ok {
+		return loadSet()
+	}
+	set.once.Do(func() {
+		set.permissions, set.err = loadSet()
+	})
+	return set.permissions, set.err
+}
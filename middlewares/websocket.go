@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/99designs/gqlgen/graphql/handler/transport"
+)
+
+// WebsocketInit authenticates a websocket connection (GraphQL subscriptions).
+// Browsers cannot set an Authorization header on a websocket, so the token is read from
+// the "Authorization" (or "authorization") key of the connection_init payload,
+// with or without the "Bearer " prefix. Without a token the connection is kept anonymous
+// (like AuthMiddleware does) and the @auth directive rejects the subscriptions.
+func WebsocketInit(ctx context.Context, initPayload transport.InitPayload) (context.Context, *transport.InitPayload, error) {
+	auth := strings.TrimSpace(initPayload.Authorization())
+	if auth == "" {
+		return ctx, &initPayload, nil
+	}
+
+	token := strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	if token == "" {
+		return nil, nil, errors.New("Invalid token: token is empty")
+	}
+
+	customClaim, err := claimsFromToken(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx = context.WithValue(ctx, userCtxKey, customClaim)
+	return withPermissionSet(ctx), &initPayload, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"rangoapp/database"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type userRecord struct {
+	ID              primitive.ObjectID   `bson:"_id"`
+	Name            string               `bson:"name"`
+	CompanyID       primitive.ObjectID   `bson:"companyId"`
+	Role            string               `bson:"role"`
+	RoleID          *primitive.ObjectID  `bson:"roleId,omitempty"`
+	StoreIDs        []primitive.ObjectID `bson:"storeIds"`
+	AssignedStoreID *primitive.ObjectID  `bson:"assignedStoreId,omitempty"`
+	CreatedAt       time.Time            `bson:"createdAt"`
+}
+
+func getEnv(key, fallback string) string {
+	value := strings.TrimSpace(os.Getenv(key))
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+func envBool(key string, fallback bool) bool {
+	value := strings.TrimSpace(strings.ToLower(os.Getenv(key)))
+	if value == "" {
+		return fallback
+	}
+	return value == "true" || value == "1" || value == "yes"
+}
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println("No .env file found, using environment variables")
+	}
+
+	db := database.ConnectDB()
+	defer func() {
+		if err := db.Client().Disconnect(nil); err != nil {
+			log.Printf("Error disconnecting from database: %v", err)
+		}
+	}()
+
+	dbName := getEnv("MONGO_DB_NAME", "rangodb")
+	dryRun := envBool("DRY_RUN", true)
+
+	fmt.Printf("🔧 Migration des utilisateurs vers les memberships\n")
+	fmt.Printf("   - DB: %s\n", dbName)
+	fmt.Printf("   - DRY_RUN: %v\n\n", dryRun)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	userCol := db.Client().Database(dbName).Collection("users")
+	membershipCol := db.Client().Database(dbName).Collection("memberships")
+
+	// Users without company (registered, no createCompany yet) have no membership
+	cursor, err := userCol.Find(ctx, bson.M{"companyId": bson.M{"$ne": primitive.NilObjectID}})
+	if err != nil {
+		log.Fatalf("Failed to find users: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	created := 0
+	skipped := 0
+	errored := 0
+	total := 0
+
+	for cursor.Next(ctx) {
+		total++
+		var user userRecord
+		if err := cursor.Decode(&user); err != nil {
+			errored++
+			log.Printf("❌ Decode user failed: %v", err)
+			continue
+		}
+
+		filter := bson.M{"userId": user.ID, "companyId": user.CompanyID}
+		count, err := membershipCol.CountDocuments(ctx, filter)
+		if err != nil {
+			errored++
+			log.Printf("❌ Membership lookup failed for %s (%s): %v", user.Name, user.ID.Hex(), err)
+			continue
+		}
+		if count > 0 {
+			skipped++
+			continue
+		}
+
+		if dryRun {
+			fmt.Printf("🧪 DRY_RUN: create membership for %s (%s) in company %s as %s\n", user.Name, user.ID.Hex(), user.CompanyID.Hex(), user.Role)
+			created++
+			continue
+		}
+
+		storeIDs := user.StoreIDs
+		if storeIDs == nil {
+			storeIDs = []primitive.ObjectID{}
+		}
+		membership := database.Membership{
+			UserID:          user.ID,
+			CompanyID:       user.CompanyID,
+			Role:            user.Role,
+			RoleID:          user.RoleID,
+			StoreIDs:        storeIDs,
+			AssignedStoreID: user.AssignedStoreID,
+			CreatedAt:       user.CreatedAt,
+			UpdatedAt:       time.Now(),
+		}
+		_, err = membershipCol.UpdateOne(ctx, filter, bson.M{"$setOnInsert": membership}, options.Update().SetUpsert(true))
+		if err != nil {
+			errored++
+			log.Printf("❌ Create membership failed for %s (%s): %v", user.Name, user.ID.Hex(), err)
+			continue
+		}
+
+		fmt.Printf("✅ Membership created for %s (%s)\n", user.Name, user.ID.Hex())
+		created++
+	}
+
+	if err := cursor.Err(); err != nil {
+		log.Printf("Cursor error: %v", err)
+	}
+
+	fmt.Println("============================================================")
+	fmt.Println("📈 RÉSUMÉ")
+	fmt.Println("============================================================")
+	fmt.Printf("✅ Créés: %d\n", created)
+	fmt.Printf("⏭️  Ignorés (déjà existants): %d\n", skipped)
+	fmt.Printf("❌ Erreurs: %d\n", errored)
+	fmt.Printf("📊 Total: %d\n", total)
+	fmt.Println("============================================================")
+}
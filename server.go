@@ -17,12 +17,15 @@ import (
 	"rangoapp/handlers"
 	"rangoapp/middlewares"
 	"rangoapp/services"
+	"rangoapp/utils"
 
 	"github.com/99designs/gqlgen/graphql/handler"
 	"github.com/99designs/gqlgen/graphql/handler/extension"
+	"github.com/99designs/gqlgen/graphql/handler/lru"
 	"github.com/99designs/gqlgen/graphql/handler/transport"
 	"github.com/99designs/gqlgen/graphql/playground"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
 )
@@ -67,7 +70,7 @@ func main() {
 	corsHandler := cors.New(cors.Options{
 		AllowedOrigins:   allowedOrigins,
 		AllowedMethods:   []string{"GET", "POST", "OPTIONS", "PUT", "DELETE"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token", "X-API-Key", "Idempotency-Key"},
 		ExposedHeaders:   []string{"Link"},
 		AllowCredentials: true,
 		MaxAge:           300, // Cache preflight requests for 5 minutes
@@ -83,19 +86,58 @@ func main() {
 	router.HandleFunc("/health/ready", handlers.ReadinessHandler(db)).Methods("GET", "OPTIONS")
 	router.HandleFunc("/health/live", handlers.LivenessHandler()).Methods("GET", "OPTIONS")
 
+	// Mobile money callbacks (M-Pesa, Airtel Money, Orange Money), authenticated by their signature
+	paymentProvider, err := utils.NewPaymentProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid payment configuration: %v", err)
+	}
+	router.HandleFunc("/webhooks/payments", handlers.PaymentWebhookHandler(services.NewPaymentService(db, paymentProvider))).Methods("POST")
+
 	// Apply auth middleware to all other routes
+	// Revoked tokens (logout, refresh token reuse, blocked user) are rejected
+	middlewares.SetTokenRevocationChecker(db)
+	middlewares.SetAPIKeyAuthenticator(db)
+	// X-Forwarded-For is only trusted from the reverse proxies of TRUSTED_PROXIES (client IP of the sessions and rate limits)
+	if err := middlewares.SetTrustedProxies(splitAndTrim(os.Getenv("TRUSTED_PROXIES"), ",")); err != nil {
+		log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+	}
+	router.Use(middlewares.ClientInfoMiddleware)
+	router.Use(middlewares.IdempotencyMiddleware)
 	router.Use(middlewares.AuthMiddleware)
 
 	// Initialize GraphQL
-	c := graph.Config{Resolvers: &graph.Resolver{DB: db}}
-	c.Directives.Auth = directives.Auth
+	if _, err := utils.TOTPEncryptionKey(); err != nil {
+		log.Fatalf("Invalid two-factor configuration: %v", err)
+	}
+	smsSender, err := utils.NewSMSSenderFromEnv()
+	if err != nil {
+		log.Fatalf("Invalid SMS configuration: %v", err)
+	}
+	c := graph.Config{Resolvers: &graph.Resolver{DB: db, SMS: smsSender, Payments: paymentProvider}}
+	c.Directives.Auth = directives.NewAuth(db)
+	c.Directives.Cost = directives.NewCost(db)
 
-	srv := handler.NewDefaultServer(graph.NewExecutableSchema(c))
+	srv := handler.New(graph.NewExecutableSchema(c))
+	// Websocket first: subscriptions (saleCreated, caisseUpdated, stockLevelChanged)
+	// The token comes from the connection_init payload (see middlewares.WebsocketInit)
+	srv.AddTransport(transport.Websocket{
+		KeepAlivePingInterval: 10 * time.Second,
+		InitFunc:              middlewares.WebsocketInit,
+		Upgrader: websocket.Upgrader{
+			CheckOrigin: websocketOriginChecker(allowedOrigins),
+		},
+	})
 	// Add multiple transports for better compatibility
 	srv.AddTransport(transport.Options{})
 	srv.AddTransport(transport.GET{})
 	srv.AddTransport(transport.POST{})
+	srv.AddTransport(transport.MultipartForm{})
+	srv.SetQueryCache(lru.New(1000))
+	srv.Use(middlewares.NewAuditExtension(db)) // Journal d'audit des mutations
 	srv.Use(extension.Introspection{})
+	srv.Use(extension.AutomaticPersistedQuery{
+		Cache: lru.New(100),
+	})
 
 	// Setup routes
 	router.Handle("/", playground.Handler("GraphQL playground", "/query")).Methods("GET", "OPTIONS")
@@ -117,6 +159,7 @@ func main() {
 		log.Printf("GraphQL playground: http://localhost:%s/", port)
 		log.Printf("GraphQL endpoint: http://localhost:%s/query", port)
 		log.Printf("Health check: http://localhost:%s/health", port)
+		log.Printf("Payment webhook: http://localhost:%s/webhooks/payments", port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Failed to start server: %v", err)
 		}
@@ -192,6 +235,24 @@ func getAllowedOrigins() []string {
 	}
 }
 
+// websocketOriginChecker accepts the websocket upgrades coming from the CORS allowed origins
+// (the CORS middleware does not apply to websockets). Clients without Origin header are accepted.
+func websocketOriginChecker(allowedOrigins []string) func(r *http.Request) bool {
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			return true
+		}
+		for _, allowed := range allowedOrigins {
+			if allowed == "*" || strings.EqualFold(allowed, origin) {
+				return true
+			}
+		}
+		log.Printf("Websocket connection refused for origin %s", origin)
+		return false
+	}
+}
+
 // splitAndTrim splits a string by separator and trims whitespace from each part
 func splitAndTrim(s, sep string) []string {
 	parts := []string{}
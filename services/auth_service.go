@@ -2,6 +2,8 @@ package services
 
 import (
 	"context"
+	"errors"
+	"time"
 
 	"rangoapp/database"
 	"rangoapp/utils"
@@ -19,20 +21,50 @@ func NewAuthService(db *database.DB) *AuthService {
 }
 
 type RegisterInput struct {
-	Password string
-	Name     string
-	Phone    string
+	Password         string
+	Name             string
+	Phone            string
+	VerificationCode string // Code reçu par requestPhoneVerification (optionnel sauf si RequirePhoneVerification)
+	InvitationCode   string // Code d'invitation: rejoint directement l'entreprise au lieu de rester sans entreprise
 }
 
 type AuthResponse struct {
 	AccessToken  string
 	RefreshToken string
 	User         *database.User
+
+	// Login en deux étapes: pas de tokens, un challenge à présenter avec le code TOTP
+	TwoFactorRequired      bool
+	TwoFactorSetupRequired bool // L'entreprise impose la 2FA aux Admins et l'utilisateur ne l'a pas encore activée
+	ChallengeToken         string
 }
 
-func (s *AuthService) Register(ctx context.Context, input RegisterInput) (*AuthResponse, error) {
+func (s *AuthService) Register(ctx context.Context, input RegisterInput, device database.DeviceInfo) (*AuthResponse, error) {
+	// The invitation is checked before creating the account
+	var invitation *database.Invitation
+	if input.InvitationCode != "" {
+		var err error
+		invitation, err = s.db.FindPendingInvitation(input.InvitationCode, input.Phone)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Vérification du téléphone par code SMS (un code d'invitation reçu par SMS sur ce numéro la vaut)
+	phoneVerified := false
+	if invitation != nil && invitation.SMSSent && invitation.Phone == input.Phone {
+		phoneVerified = true
+	} else if input.VerificationCode != "" {
+		if err := s.db.VerifyOTP(input.Phone, database.OTPPurposePhoneVerification, input.VerificationCode); err != nil {
+			return nil, err
+		}
+		phoneVerified = true
+	} else if RequirePhoneVerification() {
+		return nil, gqlerror.Errorf("Phone verification code is required")
+	}
+
 	// Create user without company (CompanyID will be NilObjectID)
-	// User can create company later using createCompany mutation
+	// User can create company later using createCompany mutation, or joins the company of the invitation
 	user, err := s.db.CreateUser(
 		input.Name,
 		input.Phone,
@@ -47,42 +79,437 @@ func (s *AuthService) Register(ctx context.Context, input RegisterInput) (*AuthR
 		return nil, err
 	}
 
-	// Generate JWT access token with empty company ID
+	if phoneVerified {
+		if err := s.db.MarkPhoneVerified(user.ID); err != nil {
+			utils.LogError(err, "Failed to mark phone as verified")
+		} else {
+			user.PhoneVerified = true
+		}
+	}
+
+	if invitation != nil {
+		if _, err := s.db.AcceptInvitation(input.InvitationCode, user); err != nil {
+			return nil, err
+		}
+		if user, err = s.db.FindUserByID(user.ID.Hex()); err != nil {
+			return nil, err
+		}
+	}
+
+	// Generate JWT tokens with empty company ID
 	// User will need to create company and login again, or we can allow empty company ID
-	accessToken, err := utils.JwtGenerate(ctx, user.ID.Hex(), primitive.NilObjectID.Hex(), user.Role, []string{}, "")
+	return s.startSession(ctx, user, device)
+}
+
+func (s *AuthService) Login(ctx context.Context, phone, password string, device database.DeviceInfo) (*AuthResponse, error) {
+	// Protection brute-force: délai progressif puis verrouillage par numéro et par IP
+	if err := s.db.ReserveLoginAttempt(phone, device.IPAddress); err != nil {
+		return nil, err
+	}
+
+	user, err := s.db.AuthenticateUser(phone, password)
 	if err != nil {
-		return nil, gqlerror.Errorf("Error generating access token: %v", err)
+		s.recordLoginFailure(phone, device.IPAddress)
+		return nil, err
 	}
 
-	// Generate refresh token
-	refreshToken, err := utils.JwtGenerateRefresh(ctx, user.ID.Hex(), primitive.NilObjectID.Hex(), user.Role, []string{}, "")
+	// Vérifier l'abonnement si l'utilisateur a une company
+	if err := s.checkSubscription(ctx, user.CompanyID); err != nil {
+		return nil, err
+	}
+
+	// Second step: the password alone does not open a session
+	// (the failures are only reset once the second step succeeds)
+	challenge, err := s.twoFactorChallenge(ctx, user)
+	if err != nil || challenge != nil {
+		return challenge, err
+	}
+
+	if err := s.db.ResetLoginFailures(phone); err != nil {
+		utils.LogError(err, "Failed to reset login failures")
+	}
+
+	// Each login starts a new session (token family)
+	return s.startSession(ctx, user, device)
+}
+
+// checkSubscription refuses the login in a company whose trial or license has expired
+func (s *AuthService) checkSubscription(ctx context.Context, companyID primitive.ObjectID) error {
+	if companyID == primitive.NilObjectID {
+		return nil
+	}
+	subscriptionService := NewSubscriptionService(s.db)
+	if err := subscriptionService.ValidateSubscription(ctx, companyID.Hex()); err != nil {
+		// Si l'essai est expiré, bloquer l'accès
+		return gqlerror.Errorf("Votre période d'essai a expiré. Veuillez vous abonner pour continuer à utiliser l'application.")
+	}
+	return nil
+}
+
+// twoFactorChallenge returns the challenge of the second step of the login, nil when the user
+// does not need one (2FA disabled and not required by the company)
+func (s *AuthService) twoFactorChallenge(ctx context.Context, user *database.User) (*AuthResponse, error) {
+	tokenType := ""
+	if user.TwoFactorEnabled() {
+		tokenType = utils.TokenTypeTwoFactor
+	} else if user.Role == database.RoleAdmin && user.CompanyID != primitive.NilObjectID {
+		company, err := s.db.FindCompanyByID(user.CompanyID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		if company.RequireAdminTwoFactor {
+			tokenType = utils.TokenTypeTwoFactorSetup
+		}
+	}
+	if tokenType == "" {
+		return nil, nil
+	}
+
+	challengeToken, err := utils.JwtGenerateChallenge(ctx, user.ID.Hex(), tokenType)
 	if err != nil {
-		return nil, gqlerror.Errorf("Error generating refresh token: %v", err)
+		return nil, gqlerror.Errorf("Error generating challenge token: %v", err)
 	}
 
 	return &AuthResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		User:         user,
+		TwoFactorRequired:      tokenType == utils.TokenTypeTwoFactor,
+		TwoFactorSetupRequired: tokenType == utils.TokenTypeTwoFactorSetup,
+		ChallengeToken:         challengeToken,
 	}, nil
 }
 
-func (s *AuthService) Login(ctx context.Context, phone, password string) (*AuthResponse, error) {
-	user, err := s.db.AuthenticateUser(phone, password)
+// ChallengeUser returns the user of a valid challenge token of the given type
+func (s *AuthService) ChallengeUser(ctx context.Context, challengeToken, tokenType string) (*database.User, error) {
+	user, _, err := s.challenge(ctx, challengeToken, tokenType)
+	return user, err
+}
+
+// challenge validates a challenge token that has not opened a session yet and returns its user and claims
+func (s *AuthService) challenge(ctx context.Context, challengeToken, tokenType string) (*database.User, *utils.JwtCustomClaim, error) {
+	token, err := utils.JwtValidate(ctx, challengeToken)
+	if err != nil || !token.Valid {
+		return nil, nil, gqlerror.Errorf("Invalid or expired challenge token, please log in again")
+	}
+
+	claims, ok := token.Claims.(*utils.JwtCustomClaim)
+	if !ok || claims.TokenType != tokenType || claims.Id == "" {
+		return nil, nil, gqlerror.Errorf("Invalid challenge token")
+	}
+
+	used, err := s.db.IsChallengeTokenUsed(claims.Id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if used {
+		return nil, nil, gqlerror.Errorf("Invalid or expired challenge token, please log in again")
+	}
+
+	user, err := s.db.FindUserByID(claims.ID)
+	if err != nil {
+		return nil, nil, gqlerror.Errorf("Invalid challenge token")
+	}
+	if user.IsBlocked {
+		return nil, nil, gqlerror.Errorf("User account is blocked")
+	}
+
+	return user, claims, nil
+}
+
+// useChallenge consumes the challenge token once its second step succeeded: it opens a single session
+func (s *AuthService) useChallenge(claims *utils.JwtCustomClaim, user *database.User) error {
+	ok, err := s.db.UseChallengeToken(claims.Id, user.ID, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return gqlerror.Errorf("Invalid or expired challenge token, please log in again")
+	}
+	return nil
+}
+
+// VerifyTwoFactorLogin completes a two-factor login with a TOTP or recovery code.
+// Wrong codes count as failed logins (progressive delay, then lockout)
+func (s *AuthService) VerifyTwoFactorLogin(ctx context.Context, challengeToken, code string, device database.DeviceInfo) (*AuthResponse, error) {
+	user, claims, err := s.challenge(ctx, challengeToken, utils.TokenTypeTwoFactor)
 	if err != nil {
 		return nil, err
 	}
 
-	// Vérifier l'abonnement si l'utilisateur a une company
-	if user.CompanyID != primitive.NilObjectID {
-		subscriptionService := NewSubscriptionService(s.db)
-		err = subscriptionService.ValidateSubscription(ctx, user.CompanyID.Hex())
+	if err := s.db.ReserveLoginAttempt(user.Phone, device.IPAddress); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.VerifyTwoFactorCode(user, code); err != nil {
+		s.recordLoginFailure(user.Phone, device.IPAddress)
+		return nil, err
+	}
+
+	if err := s.useChallenge(claims, user); err != nil {
+		return nil, err
+	}
+
+	if err := s.db.ResetLoginFailures(user.Phone); err != nil {
+		utils.LogError(err, "Failed to reset login failures")
+	}
+
+	return s.startSession(ctx, user, device)
+}
+
+// CompleteTwoFactorSetup enables the 2FA required by the company with the first code of the app,
+// then opens the session. It returns the recovery codes with the tokens. Wrong codes count as failed logins
+func (s *AuthService) CompleteTwoFactorSetup(ctx context.Context, challengeToken, code string, device database.DeviceInfo) (*AuthResponse, []string, error) {
+	user, claims, err := s.challenge(ctx, challengeToken, utils.TokenTypeTwoFactorSetup)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.db.ReserveLoginAttempt(user.Phone, device.IPAddress); err != nil {
+		return nil, nil, err
+	}
+
+	recoveryCodes, err := s.db.ConfirmTwoFactorSetup(user, code)
+	if err != nil {
+		var appErr *utils.AppError
+		if errors.As(err, &appErr) && appErr.Type == utils.ErrorTypeValidation {
+			s.recordLoginFailure(user.Phone, device.IPAddress)
+		}
+		return nil, nil, err
+	}
+
+	if err := s.useChallenge(claims, user); err != nil {
+		return nil, nil, err
+	}
+
+	if err := s.db.ResetLoginFailures(user.Phone); err != nil {
+		utils.LogError(err, "Failed to reset login failures")
+	}
+
+	user, err = s.db.FindUserByID(user.ID.Hex())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	response, err := s.startSession(ctx, user, device)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return response, recoveryCodes, nil
+}
+
+// recordLoginFailure counts a failed login and writes an audit entry for each lockout it starts
+func (s *AuthService) recordLoginFailure(phone, ip string) {
+	lockouts, err := s.db.RecordLoginFailure(phone, ip)
+	if err != nil {
+		utils.LogError(err, "Failed to record login failure")
+	}
+
+	for _, lockout := range lockouts {
+		utils.Warning("Login locked for %s %s until %s after %d failures", lockout.Kind, lockout.Value, lockout.LockedUntil.Format(time.RFC3339), lockout.Failures)
+
+		entry := database.AuditEntry{
+			Action: database.AuditActionLoginLockout,
+			Details: database.AuditJSON(map[string]interface{}{
+				"kind":        lockout.Kind,
+				"value":       lockout.Value,
+				"failures":    lockout.Failures,
+				"lockedUntil": lockout.LockedUntil,
+			}),
+			IPAddress: ip,
+		}
+		if lockout.Kind == database.LoginAttemptPhoneIP {
+			if user, err := s.db.FindUserByPhone(phone); err == nil && user != nil {
+				entry.TargetID = user.ID.Hex()
+				if user.CompanyID != primitive.NilObjectID {
+					entry.CompanyID = &user.CompanyID
+				}
+			}
+		}
+		if err := s.db.CreateAuditEntry(entry); err != nil {
+			utils.LogError(err, "Failed to write lockout audit entry")
+		}
+	}
+}
+
+// RefreshToken rotates a refresh token: the token is used once and replaced by a new one of the same family.
+// Presenting an already used token means it was stolen (or replayed): the whole family is revoked
+func (s *AuthService) RefreshToken(ctx context.Context, refreshToken string) (*AuthResponse, error) {
+	token, err := utils.JwtValidate(ctx, refreshToken)
+	if err != nil || !token.Valid {
+		return nil, gqlerror.Errorf("Invalid or expired refresh token")
+	}
+
+	claims, ok := token.Claims.(*utils.JwtCustomClaim)
+	if !ok || claims.TokenType != utils.TokenTypeRefresh || claims.Id == "" || claims.FamilyID == "" {
+		// Access tokens and refresh tokens issued before rotation are refused
+		return nil, gqlerror.Errorf("Invalid refresh token")
+	}
+
+	stored, err := s.db.FindRefreshToken(claims.Id)
+	if err != nil {
+		return nil, gqlerror.Errorf("Invalid refresh token")
+	}
+
+	revoked, err := s.db.IsTokenFamilyRevoked(stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, gqlerror.Errorf("Refresh token has been revoked")
+	}
+
+	// The tokens keep the company of the session, with the current role and stores of the user in it
+	user, err := s.sessionUser(stored)
+	if err != nil {
+		var appErr *utils.AppError
+		if errors.As(err, &appErr) && appErr.Type == utils.ErrorTypeNotFound {
+			// Removed from the company of the session: the session ends
+			if err := s.db.RevokeTokenFamily(stored.FamilyID, stored.UserID, database.TokenRevokedMembership); err != nil {
+				utils.LogError(err, "Failed to revoke token family of removed member")
+			}
+		}
+		return nil, gqlerror.Errorf("User not found")
+	}
+
+	// Check if user is blocked
+	if user.IsBlocked {
+		if err := s.db.RevokeTokenFamily(stored.FamilyID, user.ID, database.TokenRevokedBlocked); err != nil {
+			utils.LogError(err, "Failed to revoke token family of blocked user")
+		}
+		return nil, gqlerror.Errorf("User is blocked")
+	}
+
+	response, nextTokenID, err := s.generateTokens(ctx, user, stored.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := s.db.UseRefreshToken(stored.TokenID, nextTokenID)
+	if err != nil {
+		return nil, err
+	}
+	if !used {
+		utils.Warning("Refresh token reuse detected for user %s, revoking token family %s", user.ID.Hex(), stored.FamilyID)
+		if err := s.db.RevokeTokenFamily(stored.FamilyID, user.ID, database.TokenRevokedReuse); err != nil {
+			return nil, err
+		}
+		return nil, gqlerror.Errorf("Refresh token already used. Please log in again")
+	}
+
+	expiresAt := time.Now().Add(utils.JWTRefreshTokenExpiration)
+	if _, err := s.db.CreateRefreshToken(nextTokenID, stored.FamilyID, user.ID, expiresAt); err != nil {
+		return nil, err
+	}
+	if err := s.db.TouchSession(stored.FamilyID, expiresAt); err != nil {
+		utils.LogError(err, "Failed to update session on token refresh")
+	}
+
+	return response, nil
+}
+
+// sessionUser returns the user of a refresh token with their membership in the company of its session
+func (s *AuthService) sessionUser(stored *database.RefreshToken) (*database.User, error) {
+	session, err := s.db.FindSessionByID(stored.FamilyID)
+	if err != nil {
+		// Family issued before sessions: active company of the user
+		return s.db.FindUserByID(stored.UserID.Hex())
+	}
+	return s.db.FindUserForToken(stored.UserID.Hex(), session.CompanyID.Hex())
+}
+
+// SwitchCompany opens a session in another company of the user, which becomes their active company.
+// The session of the current device is replaced by the new one
+func (s *AuthService) SwitchCompany(ctx context.Context, claims *utils.JwtCustomClaim, companyID primitive.ObjectID, device database.DeviceInfo) (*AuthResponse, error) {
+	user, err := s.db.FindUserInCompany(claims.ID, companyID)
+	if err != nil {
+		return nil, gqlerror.Errorf("You are not a member of this company")
+	}
+	if user.IsBlocked {
+		return nil, gqlerror.Errorf("User account is blocked")
+	}
+
+	if err := s.checkSubscription(ctx, companyID); err != nil {
+		return nil, err
+	}
+
+	// The password was already checked: only the 2FA policy of the company remains
+	if user.Role == database.RoleAdmin && !user.TwoFactorEnabled() {
+		company, err := s.db.FindCompanyByID(companyID.Hex())
 		if err != nil {
-			// Si l'essai est expiré, bloquer l'accès
-			return nil, gqlerror.Errorf("Votre période d'essai a expiré. Veuillez vous abonner pour continuer à utiliser l'application.")
+			return nil, err
+		}
+		if company.RequireAdminTwoFactor {
+			return nil, gqlerror.Errorf("This company requires two-factor authentication for admins: enable it before switching")
 		}
 	}
 
+	user, err = s.db.SwitchActiveMembership(user.ID, companyID)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.startSession(ctx, user, device)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims.FamilyID != "" {
+		if err := s.db.RevokeTokenFamily(claims.FamilyID, user.ID, database.TokenRevokedSwitch); err != nil {
+			utils.LogError(err, "Failed to revoke session after company switch")
+		}
+	}
+
+	return response, nil
+}
+
+// Logout revokes the token family of the current token (this device only)
+func (s *AuthService) Logout(ctx context.Context, claims *utils.JwtCustomClaim) error {
+	if claims == nil || claims.FamilyID == "" {
+		// Token issued before token families: nothing to revoke, it expires on its own
+		return nil
+	}
+
+	userID, err := primitive.ObjectIDFromHex(claims.ID)
+	if err != nil {
+		return gqlerror.Errorf("Invalid user ID")
+	}
+
+	return s.db.RevokeTokenFamily(claims.FamilyID, userID, database.TokenRevokedLogout)
+}
+
+// RevokeSession ends a session: its tokens stop working right away
+func (s *AuthService) RevokeSession(ctx context.Context, session *database.Session) error {
+	return s.db.RevokeTokenFamily(session.ID.Hex(), session.UserID, database.TokenRevokedSession)
+}
+
+// LogoutAllDevices revokes every token family of the user
+func (s *AuthService) LogoutAllDevices(ctx context.Context, userID primitive.ObjectID) (int, error) {
+	return s.db.RevokeUserTokens(userID, database.TokenRevokedLogoutAll)
+}
+
+// startSession records the login of the user on the device and issues the first tokens of the session
+func (s *AuthService) startSession(ctx context.Context, user *database.User, device database.DeviceInfo) (*AuthResponse, error) {
+	expiresAt := time.Now().Add(utils.JWTRefreshTokenExpiration)
+	session, err := s.db.CreateSession(user.ID, user.CompanyID, device, expiresAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// The session ID is the family of its tokens
+	familyID := session.ID.Hex()
+	response, refreshTokenID, err := s.generateTokens(ctx, user, familyID)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.db.CreateRefreshToken(refreshTokenID, familyID, user.ID, expiresAt); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// generateTokens signs the access and refresh tokens of the user and returns the jti of the refresh token
+func (s *AuthService) generateTokens(ctx context.Context, user *database.User, familyID string) (*AuthResponse, string, error) {
 	// Convert storeIDs to strings
 	storeIDs := make([]string, len(user.StoreIDs))
 	for i, id := range user.StoreIDs {
@@ -95,22 +522,22 @@ func (s *AuthService) Login(ctx context.Context, phone, password string) (*AuthR
 	}
 
 	// Generate JWT access token
-	accessToken, err := utils.JwtGenerate(ctx, user.ID.Hex(), user.CompanyID.Hex(), user.Role, storeIDs, assignedStoreID)
+	accessToken, err := utils.JwtGenerate(ctx, familyID, user.ID.Hex(), user.CompanyID.Hex(), user.Role, storeIDs, assignedStoreID)
 	if err != nil {
-		return nil, gqlerror.Errorf("Error generating access token: %v", err)
+		return nil, "", gqlerror.Errorf("Error generating access token: %v", err)
 	}
 
 	// Generate refresh token
-	refreshToken, err := utils.JwtGenerateRefresh(ctx, user.ID.Hex(), user.CompanyID.Hex(), user.Role, storeIDs, assignedStoreID)
+	refreshToken, refreshTokenID, err := utils.JwtGenerateRefresh(ctx, familyID, user.ID.Hex(), user.CompanyID.Hex(), user.Role, storeIDs, assignedStoreID)
 	if err != nil {
-		return nil, gqlerror.Errorf("Error generating refresh token: %v", err)
+		return nil, "", gqlerror.Errorf("Error generating refresh token: %v", err)
 	}
 
 	return &AuthResponse{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
 		User:         user,
-	}, nil
+	}, refreshTokenID, nil
 }
 
 
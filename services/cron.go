@@ -32,6 +32,67 @@ func (s *CronService) CheckAndBlockExpiredTrials() error {
 	return nil
 }
 
+// WriteOffExpiredLots met au rebut les lots périmés pour lesquels un admin a activé la mise au rebut automatique
+// Chaque lot est vidé avec un mouvement AJUSTEMENT (raison "expired")
+func (s *CronService) WriteOffExpiredLots() error {
+	utils.Info("Starting write-off of expired lots...")
+
+	count, err := s.db.WriteOffExpiredLots()
+	if err != nil {
+		utils.LogError(err, "Error writing off expired lots")
+		return err
+	}
+
+	utils.Info("Finished writing off expired lots: %d lot(s) written off", count)
+	return nil
+}
+
+// CheckLowStock vérifie les produits dont le stock a changé (ventes, inventaires) et enregistre
+// une alerte quand le stock passe sous le seuil de réapprovisionnement
+func (s *CronService) CheckLowStock() error {
+	utils.Info("Starting low stock check...")
+
+	opened, err := s.db.ProcessPendingStockChecks()
+	if err != nil {
+		utils.LogError(err, "Error checking low stock")
+		return err
+	}
+
+	utils.Info("Finished low stock check: %d alert(s) opened", opened)
+	return nil
+}
+
+// FlushAuditQueue ajoute au journal d'audit les entrées mises en attente (chaîne occupée ou erreur)
+func (s *CronService) FlushAuditQueue() error {
+	count, err := s.db.FlushAuditQueue()
+	if err != nil {
+		utils.LogError(err, "Error flushing audit queue")
+		return err
+	}
+
+	if count > 0 {
+		utils.Info("Flushed audit queue: %d entries appended", count)
+	}
+	return nil
+}
+
+// SettlePaymentIntents impute aux dettes les paiements mobile money reçus mais non imputés (settlementError)
+func (s *CronService) SettlePaymentIntents() error {
+	settled, unsettled, err := s.db.SettlePaymentIntents()
+	if err != nil {
+		utils.LogError(err, "Error settling payment intents")
+		return err
+	}
+
+	if settled > 0 {
+		utils.Info("Settled payment intents: %d payment(s) applied to their debt", settled)
+	}
+	if unsettled > 0 {
+		utils.Warning("%d mobile money payment(s) received but not applied to their debt, see settlementError", unsettled)
+	}
+	return nil
+}
+
 // StartCronJobs démarre les tâches cron en arrière-plan
 // Cette fonction peut être appelée au démarrage du serveur
 func StartCronJobs(db *database.DB) {
@@ -51,32 +112,57 @@ func StartCronJobs(db *database.DB) {
 		}
 	}()
 
-	utils.Info("Cron jobs started")
-}
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
-
+	// Vérifier les seuils de réapprovisionnement toutes les 15 minutes
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
 
+		// Exécuter immédiatement au démarrage
+		cronService.CheckLowStock()
 
+		// Puis toutes les 15 minutes
+		for range ticker.C {
+			cronService.CheckLowStock()
+		}
+	}()
 
+	// Mettre au rebut les lots périmés une fois par jour
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
 
+		// Exécuter immédiatement au démarrage
+		cronService.WriteOffExpiredLots()
 
+		// Puis tous les jours
+		for range ticker.C {
+			cronService.WriteOffExpiredLots()
+		}
+	}()
 
+	// Imputer les paiements mobile money non imputés toutes les 15 minutes
+	go func() {
+		ticker := time.NewTicker(15 * time.Minute)
+		defer ticker.Stop()
 
+		// Exécuter immédiatement au démarrage
+		cronService.SettlePaymentIntents()
 
+		// Puis toutes les 15 minutes
+		for range ticker.C {
+			cronService.SettlePaymentIntents()
+		}
+	}()
 
+	// Vider la file d'attente du journal d'audit toutes les minutes
+	go func() {
+		ticker := time.NewTicker(1 * time.Minute)
+		defer ticker.Stop()
 
+		for range ticker.C {
+			cronService.FlushAuditQueue()
+		}
+	}()
 
+	utils.Info("Cron jobs started")
+}
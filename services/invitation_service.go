@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"rangoapp/database"
+	"rangoapp/utils"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// InvitationService invite une personne à rejoindre l'entreprise avec un rôle et un store prédéfinis.
+// Le code est envoyé par SMS quand un numéro est donné, et s'utilise dans register ou acceptInvitation
+type InvitationService struct {
+	db  *database.DB
+	sms utils.SMSSender
+}
+
+func NewInvitationService(db *database.DB, sms utils.SMSSender) *InvitationService {
+	return &InvitationService{db: db, sms: sms}
+}
+
+// InviteInput décrit l'invitation: numéro (optionnel), rôle intégré, rôle de l'entreprise et store
+type InviteInput struct {
+	Phone   string
+	Role    string
+	RoleID  *primitive.ObjectID
+	StoreID *primitive.ObjectID
+}
+
+// CreatedInvitation est l'invitation créée avec son code (affiché une seule fois) et son lien
+type CreatedInvitation struct {
+	Invitation *database.Invitation
+	Code       string
+	Link       string
+}
+
+// InvitationLink retourne le lien d'invitation: INVITATION_URL (ex: https://app.rangoapp.com/join)
+// suivi du code, "" si INVITATION_URL n'est pas configurée
+func InvitationLink(code string) string {
+	base := strings.TrimSpace(os.Getenv("INVITATION_URL"))
+	if base == "" {
+		return ""
+	}
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + "code=" + url.QueryEscape(code)
+}
+
+// Invite crée l'invitation et envoie le code par SMS au numéro. Seul un Admin peut inviter un Admin.
+// Un échec d'envoi du SMS n'annule pas l'invitation: le code est retourné pour être transmis autrement
+func (s *InvitationService) Invite(ctx context.Context, inviter *database.User, input InviteInput) (*CreatedInvitation, error) {
+	if input.Role == database.RoleAdmin && inviter.Role != database.RoleAdmin {
+		return nil, gqlerror.Errorf("Only Admin can invite an Admin")
+	}
+
+	invitation, code, err := s.db.CreateInvitation(inviter.CompanyID, inviter.ID, input.Phone, input.Role, input.RoleID, input.StoreID)
+	if err != nil {
+		return nil, err
+	}
+
+	created := &CreatedInvitation{Invitation: invitation, Code: code, Link: InvitationLink(code)}
+	if invitation.Phone == "" {
+		return created, nil
+	}
+
+	company, err := s.db.FindCompanyByID(inviter.CompanyID.Hex())
+	if err != nil {
+		return nil, err
+	}
+
+	message := fmt.Sprintf("%s vous invite à rejoindre %s sur RangoApp. Code d'invitation: %s (valable %d jours)",
+		inviter.Name, company.Name, code, int(database.InvitationValidity.Hours()/24))
+	if created.Link != "" {
+		message += " " + created.Link
+	}
+	if err := s.sms.Send(ctx, invitation.Phone, message); err != nil {
+		utils.LogError(err, "Failed to send invitation SMS")
+		return created, nil
+	}
+
+	if err := s.db.MarkInvitationSent(invitation.ID); err != nil {
+		utils.LogError(err, "Failed to mark invitation as sent")
+	} else {
+		invitation.SMSSent = true
+	}
+
+	return created, nil
+}
@@ -0,0 +1,104 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"rangoapp/database"
+	"rangoapp/utils"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+)
+
+// OTPService envoie et vérifie les codes à usage unique reçus par SMS
+// (réinitialisation du mot de passe, vérification du téléphone à l'inscription)
+type OTPService struct {
+	db  *database.DB
+	sms utils.SMSSender
+}
+
+func NewOTPService(db *database.DB, sms utils.SMSSender) *OTPService {
+	return &OTPService{db: db, sms: sms}
+}
+
+// RequirePhoneVerification indique si register exige un code de vérification (REQUIRE_PHONE_VERIFICATION=true)
+func RequirePhoneVerification() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("REQUIRE_PHONE_VERIFICATION")), "true")
+}
+
+// RequestPasswordReset envoie un code de réinitialisation si le numéro appartient à un utilisateur actif.
+// La réponse est la même pour un numéro inconnu, pour ne pas révéler les comptes existants
+// (une nouvelle demande trop rapprochée est ignorée sans erreur)
+func (s *OTPService) RequestPasswordReset(ctx context.Context, phone string) error {
+	user, err := s.db.FindUserByPhone(phone)
+	if err != nil || user == nil || user.IsBlocked {
+		utils.Info("Password reset requested for unknown or blocked phone %s", phone)
+		return nil
+	}
+
+	err = s.sendCode(ctx, phone, database.OTPPurposePasswordReset, "Votre code de réinitialisation RangoApp: %s (valable %d minutes)")
+	if errors.Is(err, database.ErrOTPResendTooSoon) {
+		utils.Info("Password reset requested again too soon for phone %s", phone)
+		return nil
+	}
+	return err
+}
+
+// ResetPassword vérifie le code et remplace le mot de passe. Toutes les sessions de l'utilisateur sont fermées
+func (s *OTPService) ResetPassword(ctx context.Context, phone, code, newPassword string) error {
+	if err := s.db.VerifyOTP(phone, database.OTPPurposePasswordReset, code); err != nil {
+		return err
+	}
+
+	user, err := s.db.FindUserByPhone(phone)
+	if err != nil || user == nil {
+		return gqlerror.Errorf("Invalid or expired code, please request a new one")
+	}
+
+	if err := s.db.SetPassword(user.ID, newPassword); err != nil {
+		return err
+	}
+
+	if _, err := s.db.RevokeUserTokens(user.ID, database.TokenRevokedPassword); err != nil {
+		utils.LogError(err, "Failed to revoke tokens after password reset")
+	}
+
+	return nil
+}
+
+// RequestPhoneVerification envoie un code à saisir dans register
+func (s *OTPService) RequestPhoneVerification(ctx context.Context, phone string) error {
+	// FindUserByPhone returns nil without error for an unknown number
+	existing, err := s.db.FindUserByPhone(phone)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return gqlerror.Errorf("Phone number already registered")
+	}
+
+	return s.sendCode(ctx, phone, database.OTPPurposePhoneVerification, "Votre code de vérification RangoApp: %s (valable %d minutes)")
+}
+
+// sendCode génère un code, le stocke haché puis l'envoie par SMS
+func (s *OTPService) sendCode(ctx context.Context, phone, purpose, message string) error {
+	code, err := utils.GenerateOTP(database.OTPLength)
+	if err != nil {
+		utils.LogError(err, "Failed to generate OTP")
+		return gqlerror.Errorf("Unable to send the SMS, please try again later")
+	}
+
+	if _, err := s.db.CreateOTP(phone, purpose, code); err != nil {
+		return err
+	}
+
+	if err := s.sms.Send(ctx, phone, fmt.Sprintf(message, code, int(database.OTPValidity.Minutes()))); err != nil {
+		utils.LogError(err, "Failed to send OTP SMS")
+		return gqlerror.Errorf("Unable to send the SMS, please try again later")
+	}
+
+	return nil
+}
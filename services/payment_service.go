@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"rangoapp/database"
+	"rangoapp/utils"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PaymentService collecte les paiements mobile money (M-Pesa, Airtel Money, Orange Money): la demande est
+// poussée sur le téléphone du client, puis confirmée par le webhook de l'opérateur ou par une vérification du statut
+type PaymentService struct {
+	db       *database.DB
+	provider utils.PaymentProvider
+}
+
+func NewPaymentService(db *database.DB, provider utils.PaymentProvider) *PaymentService {
+	return &PaymentService{db: db, provider: provider}
+}
+
+// PaymentInput décrit le paiement: moyen, numéro du client, montant et vente ou dette réglée
+type PaymentInput struct {
+	StoreID primitive.ObjectID
+	Method  string
+	Phone   string
+	Amount  float64
+	SaleID  *primitive.ObjectID
+	DebtID  *primitive.ObjectID
+}
+
+// Initiate enregistre le PaymentIntent et pousse la demande de paiement sur le téléphone du client.
+// Une demande refusée par l'opérateur marque le PaymentIntent en échec
+func (s *PaymentService) Initiate(ctx context.Context, operator *database.User, input PaymentInput) (*database.PaymentIntent, error) {
+	if _, disabled := s.provider.(utils.DisabledPaymentProvider); disabled {
+		return nil, utils.ValidationErrorf("Mobile money payments are not enabled")
+	}
+
+	intent, err := s.db.CreatePaymentIntent(input.StoreID, operator.ID, s.provider.Name(), input.Method, input.Phone, input.Amount, input.SaleID, input.DebtID)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := s.provider.Initiate(ctx, utils.PaymentRequest{
+		Method:      intent.Method,
+		Phone:       intent.Phone,
+		Amount:      intent.Amount,
+		Currency:    intent.Currency,
+		Reference:   intent.ID.Hex(),
+		Description: fmt.Sprintf("RangoApp %s", intent.ID.Hex()),
+	})
+	if err != nil {
+		utils.LogError(err, fmt.Sprintf("Payment request %s rejected by %s", intent.ID.Hex(), s.provider.Name()))
+		return s.db.ApplyPaymentResult(intent, &utils.PaymentResult{Status: utils.PaymentStatusFailed, Message: err.Error()})
+	}
+
+	if err := s.db.SetPaymentIntentProviderRef(intent, result.ProviderRef); err != nil {
+		return nil, err
+	}
+	return s.db.ApplyPaymentResult(intent, result)
+}
+
+// Refresh demande le statut d'un paiement en attente à l'opérateur (si le callback n'est pas arrivé)
+func (s *PaymentService) Refresh(ctx context.Context, intent *database.PaymentIntent) (*database.PaymentIntent, error) {
+	if intent.Status != utils.PaymentStatusPending || intent.ProviderRef == "" || intent.Provider != s.provider.Name() {
+		return intent, nil
+	}
+
+	result, err := s.provider.CheckStatus(ctx, intent.ProviderRef)
+	if err != nil {
+		return nil, utils.WrapError(err, "Error checking the payment status")
+	}
+	return s.db.ApplyPaymentResult(intent, result)
+}
+
+// HandleCallback vérifie la signature du callback de l'opérateur et applique le résultat au PaymentIntent
+func (s *PaymentService) HandleCallback(body []byte, header http.Header) (*database.PaymentIntent, error) {
+	result, err := s.provider.VerifyCallback(body, header)
+	if err != nil {
+		return nil, utils.NewUnauthorizedError(err.Error())
+	}
+
+	intent, err := s.db.FindPaymentIntentByProviderRef(s.provider.Name(), result.ProviderRef)
+	if err != nil {
+		return nil, err
+	}
+	return s.db.ApplyPaymentResult(intent, result)
+}
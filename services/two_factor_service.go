@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"os"
+	"strings"
+
+	"rangoapp/database"
+	"rangoapp/utils"
+
+	"github.com/vektah/gqlparser/v2/gqlerror"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TwoFactorService gère l'activation de l'authentification à deux facteurs (TOTP RFC 6238)
+// et les codes de secours. La connexion en deux étapes est dans AuthService
+type TwoFactorService struct {
+	db *database.DB
+}
+
+func NewTwoFactorService(db *database.DB) *TwoFactorService {
+	return &TwoFactorService{db: db}
+}
+
+// TwoFactorSetup est le secret à saisir (ou scanner en QR code) dans l'application d'authentification
+type TwoFactorSetup struct {
+	Secret     string
+	OtpauthURI string
+}
+
+// totpIssuer est le nom affiché dans l'application d'authentification (TOTP_ISSUER, RangoApp par défaut)
+func totpIssuer() string {
+	if issuer := strings.TrimSpace(os.Getenv("TOTP_ISSUER")); issuer != "" {
+		return issuer
+	}
+	return "RangoApp"
+}
+
+// Setup génère un nouveau secret; la 2FA n'est active qu'après Confirm avec un premier code
+func (s *TwoFactorService) Setup(ctx context.Context, user *database.User) (*TwoFactorSetup, error) {
+	secret, err := s.db.StartTwoFactorSetup(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorSetup{
+		Secret:     secret,
+		OtpauthURI: utils.TOTPURI(totpIssuer(), user.Phone, secret),
+	}, nil
+}
+
+// Confirm active la 2FA avec un code de l'application et retourne les codes de secours (affichés une seule fois)
+func (s *TwoFactorService) Confirm(ctx context.Context, user *database.User, code string) ([]string, error) {
+	return s.db.ConfirmTwoFactorSetup(user, code)
+}
+
+// Disable désactive la 2FA après vérification d'un code. Refusé pour un Admin si l'entreprise l'impose
+func (s *TwoFactorService) Disable(ctx context.Context, user *database.User, code string) error {
+	if user.Role == database.RoleAdmin && user.CompanyID != primitive.NilObjectID {
+		company, err := s.db.FindCompanyByID(user.CompanyID.Hex())
+		if err != nil {
+			return err
+		}
+		if company.RequireAdminTwoFactor {
+			return gqlerror.Errorf("Your company requires two-factor authentication for Admins")
+		}
+	}
+
+	if err := s.db.VerifyTwoFactorCode(user, code); err != nil {
+		return err
+	}
+
+	return s.db.DisableTwoFactor(user.ID)
+}
+
+// RegenerateRecoveryCodes remplace les codes de secours après vérification d'un code
+func (s *TwoFactorService) RegenerateRecoveryCodes(ctx context.Context, user *database.User, code string) ([]string, error) {
+	if err := s.db.VerifyTwoFactorCode(user, code); err != nil {
+		return nil, err
+	}
+
+	return s.db.RegenerateRecoveryCodes(user.ID)
+}
@@ -0,0 +1,16 @@
+package utils
+
+// NormalizeBarcode returns the EAN-13 form of a barcode: a UPC-A code (12 digits) gets its leading zero,
+// so that both forms of the same product are validated, stored and looked up as one code.
+// Other codes are returned unchanged
+func NormalizeBarcode(code string) string {
+	if len(code) != 12 {
+		return code
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return code
+		}
+	}
+	return "0" + code
+}
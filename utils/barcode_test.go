@@ -0,0 +1,15 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeBarcode(t *testing.T) {
+	assert.Equal(t, "0036000291452", NormalizeBarcode("036000291452"))  // UPC-A
+	assert.Equal(t, "0036000291452", NormalizeBarcode("0036000291452")) // EAN-13
+	assert.Equal(t, "5901234123457", NormalizeBarcode("5901234123457"))
+	assert.Equal(t, "ABC-12345678", NormalizeBarcode("ABC-12345678"))
+	assert.Equal(t, "", NormalizeBarcode(""))
+}
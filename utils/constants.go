@@ -7,6 +7,7 @@ const (
 	// JWT token expiration times
 	JWTTokenExpiration      = 24 * time.Hour      // 1 day
 	JWTRefreshTokenExpiration = 7 * 24 * time.Hour // 7 days
+	JWTChallengeExpiration    = 5 * time.Minute    // Second step of a two-factor login
 
 	// Common time durations
 	OneDay   = 24 * time.Hour
@@ -0,0 +1,13 @@
+package utils
+
+import (
+	"os"
+	"strings"
+)
+
+// IsDevelopment indique un environnement de développement (ENV=development ou ENV=dev).
+// Les services simulés (SMS écrits dans les logs, paiements simulés) ne sont acceptés que là
+func IsDevelopment() bool {
+	env := strings.ToLower(strings.TrimSpace(os.Getenv("ENV")))
+	return env == "development" || env == "dev"
+}
@@ -2,7 +2,6 @@ package utils
 
 import (
 	"fmt"
-	"os"
 	"runtime"
 	"strings"
 
@@ -66,7 +65,7 @@ func (e *AppError) ToGraphQLError() *gqlerror.Error {
 	}
 	
 	// Include stack trace in development mode only
-	if os.Getenv("ENV") == "development" || os.Getenv("ENV") == "dev" {
+	if IsDevelopment() {
 		if e.Stack != "" {
 			extensions["stack"] = e.Stack
 		}
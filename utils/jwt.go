@@ -7,6 +7,16 @@ import (
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+)
+
+// Token types (claim "typ"): only refresh tokens are accepted by refreshToken,
+// the challenge tokens of a two-factor login only by the two-factor mutations
+const (
+	TokenTypeAccess         = "access"
+	TokenTypeRefresh        = "refresh"
+	TokenTypeTwoFactor      = "2fa"       // Mot de passe vérifié, code TOTP attendu
+	TokenTypeTwoFactorSetup = "2fa_setup" // Mot de passe vérifié, activation de la 2FA imposée par l'entreprise
 )
 
 type JwtCustomClaim struct {
@@ -15,7 +25,11 @@ type JwtCustomClaim struct {
 	Role          string   `json:"role"`
 	StoreIDs      []string `json:"storeIds"`
 	AssignedStoreID string `json:"assignedStoreId,omitempty"`
-	jwt.StandardClaims
+	TokenType     string   `json:"typ,omitempty"`
+	FamilyID      string   `json:"fam,omitempty"` // Famille de tokens (une par connexion), révoquée en bloc
+	APIKeyID      string   `json:"-"` // Requête authentifiée par une clé d'API (X-API-Key), jamais dans un JWT
+	Permissions   []string `json:"-"` // Permissions de la clé d'API: limitent celles de l'utilisateur qui l'a créée
+	jwt.StandardClaims // Id = jti
 }
 
 var jwtSecret = []byte(getJwtSecret())
@@ -34,14 +48,18 @@ func getJwtSecret() string {
 	return secret
 }
 
-func JwtGenerate(ctx context.Context, userID, companyID, role string, storeIDs []string, assignedStoreID string) (string, error) {
+// JwtGenerate generates an access token of the given token family
+func JwtGenerate(ctx context.Context, familyID, userID, companyID, role string, storeIDs []string, assignedStoreID string) (string, error) {
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, &JwtCustomClaim{
 		ID:              userID,
 		CompanyID:       companyID,
 		Role:            role,
 		StoreIDs:        storeIDs,
 		AssignedStoreID: assignedStoreID,
+		TokenType:       TokenTypeAccess,
+		FamilyID:        familyID,
 		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
 			ExpiresAt: time.Now().Add(JWTTokenExpiration).Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
@@ -56,14 +74,19 @@ func JwtGenerate(ctx context.Context, userID, companyID, role string, storeIDs [
 }
 
 // JwtGenerateRefresh generates a refresh token with longer expiration (7 days)
-func JwtGenerateRefresh(ctx context.Context, userID, companyID, role string, storeIDs []string, assignedStoreID string) (string, error) {
+// and returns it with its jti, which must be persisted for rotation
+func JwtGenerateRefresh(ctx context.Context, familyID, userID, companyID, role string, storeIDs []string, assignedStoreID string) (string, string, error) {
+	tokenID := uuid.NewString()
 	t := jwt.NewWithClaims(jwt.SigningMethodHS256, &JwtCustomClaim{
 		ID:              userID,
 		CompanyID:       companyID,
 		Role:            role,
 		StoreIDs:        storeIDs,
 		AssignedStoreID: assignedStoreID,
+		TokenType:       TokenTypeRefresh,
+		FamilyID:        familyID,
 		StandardClaims: jwt.StandardClaims{
+			Id:        tokenID,
 			ExpiresAt: time.Now().Add(JWTRefreshTokenExpiration).Unix(),
 			IssuedAt:  time.Now().Unix(),
 		},
@@ -71,10 +94,25 @@ func JwtGenerateRefresh(ctx context.Context, userID, companyID, role string, sto
 
 	token, err := t.SignedString(jwtSecret)
 	if err != nil {
-		return "", err
+		return "", "", err
 	}
 
-	return token, nil
+	return token, tokenID, nil
+}
+
+// JwtGenerateChallenge generates the short-lived token of the second step of a two-factor login
+func JwtGenerateChallenge(ctx context.Context, userID, tokenType string) (string, error) {
+	t := jwt.NewWithClaims(jwt.SigningMethodHS256, &JwtCustomClaim{
+		ID:        userID,
+		TokenType: tokenType,
+		StandardClaims: jwt.StandardClaims{
+			Id:        uuid.NewString(),
+			ExpiresAt: time.Now().Add(JWTChallengeExpiration).Unix(),
+			IssuedAt:  time.Now().Unix(),
+		},
+	})
+
+	return t.SignedString(jwtSecret)
 }
 
 func JwtValidate(ctx context.Context, token string) (*jwt.Token, error) {
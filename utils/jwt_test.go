@@ -23,7 +23,7 @@ func TestJwtGenerate(t *testing.T) {
 	storeIDs := []string{"store1", "store2"}
 	assignedStoreID := ""
 
-	token, err := JwtGenerate(ctx, userID, companyID, role, storeIDs, assignedStoreID)
+	token, err := JwtGenerate(ctx, "family1", userID, companyID, role, storeIDs, assignedStoreID)
 	require.NoError(t, err)
 	assert.NotEmpty(t, token)
 
@@ -39,6 +39,9 @@ func TestJwtGenerate(t *testing.T) {
 	assert.Equal(t, role, claims.Role)
 	assert.Equal(t, storeIDs, claims.StoreIDs)
 	assert.Equal(t, assignedStoreID, claims.AssignedStoreID)
+	assert.Equal(t, TokenTypeAccess, claims.TokenType)
+	assert.Equal(t, "family1", claims.FamilyID)
+	assert.NotEmpty(t, claims.Id)
 	assert.Greater(t, claims.ExpiresAt, time.Now().Unix())
 }
 
@@ -53,9 +56,10 @@ func TestJwtGenerateRefresh(t *testing.T) {
 	storeIDs := []string{"store1"}
 	assignedStoreID := "store1"
 
-	token, err := JwtGenerateRefresh(ctx, userID, companyID, role, storeIDs, assignedStoreID)
+	token, tokenID, err := JwtGenerateRefresh(ctx, "family1", userID, companyID, role, storeIDs, assignedStoreID)
 	require.NoError(t, err)
 	assert.NotEmpty(t, token)
+	assert.NotEmpty(t, tokenID)
 
 	// Validate the token
 	parsedToken, err := JwtValidate(ctx, token)
@@ -67,6 +71,9 @@ func TestJwtGenerateRefresh(t *testing.T) {
 	assert.Equal(t, userID, claims.ID)
 	assert.Equal(t, companyID, claims.CompanyID)
 	assert.Equal(t, role, claims.Role)
+	assert.Equal(t, TokenTypeRefresh, claims.TokenType)
+	assert.Equal(t, "family1", claims.FamilyID)
+	assert.Equal(t, tokenID, claims.Id, "The returned jti is the one signed in the token")
 	
 	// Refresh token should expire in 7 days
 	expectedExpiry := time.Now().Add(JWTRefreshTokenExpiration).Unix()
@@ -81,7 +88,7 @@ func TestJwtValidate(t *testing.T) {
 	ctx := context.Background()
 
 	t.Run("Valid token", func(t *testing.T) {
-		token, err := JwtGenerate(ctx, "user1", "company1", "Admin", []string{"store1"}, "")
+		token, err := JwtGenerate(ctx, "family1", "user1", "company1", "Admin", []string{"store1"}, "")
 		require.NoError(t, err)
 
 		parsedToken, err := JwtValidate(ctx, token)
@@ -99,7 +106,7 @@ func TestJwtValidate(t *testing.T) {
 	t.Run("Token with wrong secret", func(t *testing.T) {
 		// Generate token with one secret
 		os.Setenv("JWT_SECRET", "secret1-at-least-32-characters-long")
-		token, err := JwtGenerate(ctx, "user1", "company1", "Admin", []string{"store1"}, "")
+		token, err := JwtGenerate(ctx, "family1", "user1", "company1", "Admin", []string{"store1"}, "")
 		require.NoError(t, err)
 
 		// Try to validate with different secret
@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+)
+
+// GenerateOTP génère un code numérique aléatoire de la longueur donnée (crypto/rand)
+func GenerateOTP(length int) (string, error) {
+	if length <= 0 {
+		return "", fmt.Errorf("invalid OTP length: %d", length)
+	}
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(length)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", length, n), nil
+}
@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateOTP(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		code, err := GenerateOTP(6)
+		assert.NoError(t, err)
+		assert.Len(t, code, 6)
+		for _, c := range code {
+			assert.True(t, c >= '0' && c <= '9', code)
+		}
+	}
+
+	_, err := GenerateOTP(0)
+	assert.Error(t, err)
+}
+
+func TestMemorySMSSender(t *testing.T) {
+	sender := &MemorySMSSender{}
+	var _ SMSSender = sender
+
+	assert.NoError(t, sender.Send(context.Background(), "+243810000001", "first"))
+	assert.NoError(t, sender.Send(context.Background(), "+243810000002", "other"))
+	assert.NoError(t, sender.Send(context.Background(), "+243810000001", "second"))
+
+	msg, ok := sender.LastMessage("+243810000001")
+	assert.True(t, ok)
+	assert.Equal(t, "second", msg.Message)
+	assert.Len(t, sender.Messages(), 3)
+
+	_, ok = sender.LastMessage("+243810000003")
+	assert.False(t, ok)
+}
@@ -0,0 +1,255 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuts d'un paiement chez l'opérateur
+const (
+	PaymentStatusPending   = "pending"
+	PaymentStatusSucceeded = "succeeded"
+	PaymentStatusFailed    = "failed"
+)
+
+// PaymentSignatureHeader porte la signature HMAC-SHA256 (hex) du corps des callbacks
+const PaymentSignatureHeader = "X-Payment-Signature"
+
+// PaymentRequest est une demande de paiement poussée sur le téléphone du client (M-Pesa, Airtel Money, Orange Money)
+type PaymentRequest struct {
+	Method      string // "mpesa", "airtel_money" ou "orange_money"
+	Phone       string
+	Amount      float64
+	Currency    string
+	Reference   string // ID du PaymentIntent
+	Description string
+}
+
+// PaymentResult est l'état d'un paiement chez l'opérateur
+type PaymentResult struct {
+	ProviderRef   string `json:"providerRef"`             // Référence de la demande chez l'opérateur
+	Status        string `json:"status"`                  // "pending", "succeeded" ou "failed"
+	TransactionID string `json:"transactionId,omitempty"` // Référence de la transaction (reçu mobile money)
+	Message       string `json:"message,omitempty"`       // Motif d'échec
+}
+
+// PaymentProvider initie les paiements mobile money et vérifie leurs callbacks
+type PaymentProvider interface {
+	Name() string
+	Initiate(ctx context.Context, req PaymentRequest) (*PaymentResult, error)
+	CheckStatus(ctx context.Context, providerRef string) (*PaymentResult, error)
+	VerifyCallback(body []byte, header http.Header) (*PaymentResult, error)
+}
+
+// NewPaymentProviderFromEnv retourne le provider configuré par PAYMENT_PROVIDER et refuse une configuration
+// incomplète: PAYMENT_WEBHOOK_SECRET signe les callbacks et doit être défini. Seul "simulator" est disponible,
+// et uniquement en développement (ENV=development, voir IsDevelopment): il confirme seul les paiements après
+// PAYMENT_SIMULATOR_DELAY_SECONDS (5 par défaut). "none" désactive les paiements mobile money
+func NewPaymentProviderFromEnv() (PaymentProvider, error) {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("PAYMENT_PROVIDER")))
+	switch provider {
+	case "none":
+		return DisabledPaymentProvider{}, nil
+	case "simulator":
+		if !IsDevelopment() {
+			return nil, fmt.Errorf("the payment simulator confirms payments by itself: it requires ENV=development")
+		}
+	case "":
+		return nil, fmt.Errorf("PAYMENT_PROVIDER is required (simulator in development, none to disable mobile money)")
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", provider)
+	}
+
+	secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if secret == "" {
+		return nil, fmt.Errorf("PAYMENT_WEBHOOK_SECRET is required to verify the payment callbacks")
+	}
+
+	delay := 5 * time.Second
+	if value := os.Getenv("PAYMENT_SIMULATOR_DELAY_SECONDS"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid PAYMENT_SIMULATOR_DELAY_SECONDS %q", value)
+		}
+		delay = time.Duration(seconds) * time.Second
+	}
+
+	Warning("Mobile money payments are simulated and confirmed after %v", delay)
+	return NewSimulatorPaymentProvider(secret, delay), nil
+}
+
+// DisabledPaymentProvider refuse les paiements mobile money (PAYMENT_PROVIDER=none) et tous les callbacks
+type DisabledPaymentProvider struct{}
+
+func (DisabledPaymentProvider) Name() string {
+	return "none"
+}
+
+func (DisabledPaymentProvider) Initiate(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	return nil, fmt.Errorf("mobile money payments are not enabled")
+}
+
+func (DisabledPaymentProvider) CheckStatus(ctx context.Context, providerRef string) (*PaymentResult, error) {
+	return nil, fmt.Errorf("mobile money payments are not enabled")
+}
+
+func (DisabledPaymentProvider) VerifyCallback(body []byte, header http.Header) (*PaymentResult, error) {
+	return nil, fmt.Errorf("mobile money payments are not enabled")
+}
+
+// SignPaymentCallback retourne la signature HMAC-SHA256 (hex) du corps d'un callback
+func SignPaymentCallback(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyPaymentSignature vérifie la signature d'un callback en temps constant
+func VerifyPaymentSignature(secret string, body []byte, signature string) bool {
+	expected := SignPaymentCallback(secret, body)
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(strings.TrimSpace(signature))))
+}
+
+// simulatedPayment est une demande de paiement du simulateur
+type simulatedPayment struct {
+	request   PaymentRequest
+	createdAt time.Time
+	result    PaymentResult
+}
+
+// SimulatorPaymentProvider simule les opérateurs mobile money (développement et tests):
+// un paiement est confirmé après le délai, sauf pour les numéros finissant par 9 (solde insuffisant)
+type SimulatorPaymentProvider struct {
+	mu       sync.Mutex
+	secret   string
+	delay    time.Duration
+	now      func() time.Time
+	payments map[string]*simulatedPayment
+}
+
+// NewSimulatorPaymentProvider crée un simulateur qui signe ses callbacks avec secret
+func NewSimulatorPaymentProvider(secret string, delay time.Duration) *SimulatorPaymentProvider {
+	return &SimulatorPaymentProvider{
+		secret:   secret,
+		delay:    delay,
+		now:      time.Now,
+		payments: make(map[string]*simulatedPayment),
+	}
+}
+
+func (p *SimulatorPaymentProvider) Name() string {
+	return "simulator"
+}
+
+func (p *SimulatorPaymentProvider) Initiate(ctx context.Context, req PaymentRequest) (*PaymentResult, error) {
+	switch req.Method {
+	case "mpesa", "airtel_money", "orange_money":
+	default:
+		return nil, fmt.Errorf("simulator: unsupported payment method %q", req.Method)
+	}
+	if req.Amount <= 0 {
+		return nil, fmt.Errorf("simulator: amount must be greater than 0")
+	}
+
+	ref, err := randomHex(8)
+	if err != nil {
+		return nil, fmt.Errorf("simulator: %w", err)
+	}
+	payment := &simulatedPayment{
+		request:   req,
+		createdAt: p.now(),
+		result:    PaymentResult{ProviderRef: "SIM-" + ref, Status: PaymentStatusPending},
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.payments[payment.result.ProviderRef] = payment
+	Info("Simulated %s payment request of %.2f %s to %s (%s)", req.Method, req.Amount, req.Currency, req.Phone, payment.result.ProviderRef)
+
+	result := payment.result
+	return &result, nil
+}
+
+func (p *SimulatorPaymentProvider) CheckStatus(ctx context.Context, providerRef string) (*PaymentResult, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[providerRef]
+	if !ok {
+		return nil, fmt.Errorf("simulator: unknown payment %s", providerRef)
+	}
+	if payment.result.Status == PaymentStatusPending && p.now().Sub(payment.createdAt) >= p.delay {
+		p.complete(payment)
+	}
+
+	result := payment.result
+	return &result, nil
+}
+
+func (p *SimulatorPaymentProvider) VerifyCallback(body []byte, header http.Header) (*PaymentResult, error) {
+	if !VerifyPaymentSignature(p.secret, body, header.Get(PaymentSignatureHeader)) {
+		return nil, fmt.Errorf("simulator: invalid callback signature")
+	}
+
+	var result PaymentResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("simulator: invalid callback: %w", err)
+	}
+	if result.ProviderRef == "" {
+		return nil, fmt.Errorf("simulator: callback without providerRef")
+	}
+	return &result, nil
+}
+
+// Callback confirme (ou fait échouer) le paiement et retourne le corps signé du callback de l'opérateur,
+// à envoyer sur le webhook
+func (p *SimulatorPaymentProvider) Callback(providerRef string) ([]byte, string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[providerRef]
+	if !ok {
+		return nil, "", fmt.Errorf("simulator: unknown payment %s", providerRef)
+	}
+	if payment.result.Status == PaymentStatusPending {
+		p.complete(payment)
+	}
+
+	body, err := json.Marshal(payment.result)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, SignPaymentCallback(p.secret, body), nil
+}
+
+// complete termine le paiement: échec pour les numéros finissant par 9, sinon succès
+func (p *SimulatorPaymentProvider) complete(payment *simulatedPayment) {
+	if strings.HasSuffix(payment.request.Phone, "9") {
+		payment.result.Status = PaymentStatusFailed
+		payment.result.Message = "Solde insuffisant"
+		return
+	}
+	ref, _ := randomHex(5)
+	payment.result.Status = PaymentStatusSucceeded
+	payment.result.TransactionID = strings.ToUpper("SIMTX" + ref)
+}
+
+// randomHex retourne n octets aléatoires en hexadécimal
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPaymentSignature(t *testing.T) {
+	body := []byte(`{"providerRef":"SIM-1","status":"succeeded"}`)
+	signature := SignPaymentCallback("secret", body)
+
+	assert.True(t, VerifyPaymentSignature("secret", body, signature))
+	assert.False(t, VerifyPaymentSignature("other", body, signature))
+	assert.False(t, VerifyPaymentSignature("secret", []byte(`{"providerRef":"SIM-1","status":"failed"}`), signature))
+	assert.False(t, VerifyPaymentSignature("secret", body, ""))
+}
+
+func TestSimulatorPaymentProvider(t *testing.T) {
+	provider := NewSimulatorPaymentProvider("secret", time.Minute)
+	var _ PaymentProvider = provider
+	now := time.Now()
+	provider.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	_, err := provider.Initiate(ctx, PaymentRequest{Method: "card", Phone: "+243810000001", Amount: 10})
+	assert.Error(t, err)
+
+	result, err := provider.Initiate(ctx, PaymentRequest{Method: "mpesa", Phone: "+243810000001", Amount: 10, Currency: "USD"})
+	require.NoError(t, err)
+	assert.Equal(t, PaymentStatusPending, result.Status)
+
+	// Confirmé après le délai
+	status, err := provider.CheckStatus(ctx, result.ProviderRef)
+	require.NoError(t, err)
+	assert.Equal(t, PaymentStatusPending, status.Status)
+	now = now.Add(time.Minute)
+	status, err = provider.CheckStatus(ctx, result.ProviderRef)
+	require.NoError(t, err)
+	assert.Equal(t, PaymentStatusSucceeded, status.Status)
+	assert.NotEmpty(t, status.TransactionID)
+
+	_, err = provider.CheckStatus(ctx, "SIM-unknown")
+	assert.Error(t, err)
+}
+
+func TestSimulatorPaymentCallback(t *testing.T) {
+	provider := NewSimulatorPaymentProvider("secret", time.Hour)
+	ctx := context.Background()
+
+	result, err := provider.Initiate(ctx, PaymentRequest{Method: "airtel_money", Phone: "+243990000009", Amount: 10, Currency: "CDF"})
+	require.NoError(t, err)
+
+	body, signature, err := provider.Callback(result.ProviderRef)
+	require.NoError(t, err)
+
+	header := http.Header{}
+	header.Set(PaymentSignatureHeader, signature)
+	callback, err := provider.VerifyCallback(body, header)
+	require.NoError(t, err)
+	assert.Equal(t, result.ProviderRef, callback.ProviderRef)
+	assert.Equal(t, PaymentStatusFailed, callback.Status) // Numéro finissant par 9
+	assert.NotEmpty(t, callback.Message)
+
+	header.Set(PaymentSignatureHeader, SignPaymentCallback("other", body))
+	_, err = provider.VerifyCallback(body, header)
+	assert.Error(t, err)
+}
+
+func TestNewPaymentProviderFromEnv(t *testing.T) {
+	t.Setenv("PAYMENT_SIMULATOR_DELAY_SECONDS", "")
+
+	t.Run("A provider is required", func(t *testing.T) {
+		t.Setenv("ENV", "development")
+		t.Setenv("PAYMENT_WEBHOOK_SECRET", "secret")
+		t.Setenv("PAYMENT_PROVIDER", "")
+		_, err := NewPaymentProviderFromEnv()
+		assert.Error(t, err)
+
+		t.Setenv("PAYMENT_PROVIDER", "mpesa-direct")
+		_, err = NewPaymentProviderFromEnv()
+		assert.Error(t, err, "Unknown providers are refused")
+	})
+
+	t.Run("The simulator requires development and a webhook secret", func(t *testing.T) {
+		t.Setenv("PAYMENT_PROVIDER", "simulator")
+		t.Setenv("PAYMENT_WEBHOOK_SECRET", "secret")
+		t.Setenv("ENV", "production")
+		_, err := NewPaymentProviderFromEnv()
+		assert.Error(t, err)
+
+		t.Setenv("ENV", "development")
+		provider, err := NewPaymentProviderFromEnv()
+		require.NoError(t, err)
+		assert.IsType(t, &SimulatorPaymentProvider{}, provider)
+
+		t.Setenv("PAYMENT_WEBHOOK_SECRET", "")
+		_, err = NewPaymentProviderFromEnv()
+		assert.Error(t, err)
+	})
+
+	t.Run("Disabled payments refuse requests and callbacks", func(t *testing.T) {
+		t.Setenv("ENV", "production")
+		t.Setenv("PAYMENT_WEBHOOK_SECRET", "")
+		t.Setenv("PAYMENT_PROVIDER", "none")
+		provider, err := NewPaymentProviderFromEnv()
+		require.NoError(t, err)
+
+		_, err = provider.Initiate(context.Background(), PaymentRequest{Method: "mpesa", Phone: "+243810000001", Amount: 10})
+		assert.Error(t, err)
+		_, err = provider.VerifyCallback([]byte(`{"providerRef":"SIM-1","status":"succeeded"}`), http.Header{})
+		assert.Error(t, err)
+	})
+}
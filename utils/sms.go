@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// SMSSender envoie un SMS (codes OTP de réinitialisation du mot de passe et de vérification du téléphone)
+type SMSSender interface {
+	Send(ctx context.Context, to, message string) error
+}
+
+// NewSMSSenderFromEnv retourne le sender configuré par SMS_PROVIDER:
+// "twilio" (TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN, TWILIO_FROM) ou "log" (par défaut, développement).
+// Les logs contiennent les codes en clair: hors développement (voir IsDevelopment), seul Twilio est accepté
+func NewSMSSenderFromEnv() (SMSSender, error) {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("SMS_PROVIDER")))
+	switch provider {
+	case "twilio":
+		accountSid := os.Getenv("TWILIO_ACCOUNT_SID")
+		authToken := os.Getenv("TWILIO_AUTH_TOKEN")
+		from := os.Getenv("TWILIO_FROM")
+		if accountSid == "" || authToken == "" || from == "" {
+			return nil, fmt.Errorf("SMS_PROVIDER=twilio requires TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM")
+		}
+		return NewTwilioSMSSender(accountSid, authToken, from), nil
+	case "", "log":
+		if !IsDevelopment() {
+			return nil, fmt.Errorf("SMS_PROVIDER=twilio is required outside development (the log sender writes the codes in clear)")
+		}
+		Warning("SMS are only logged (development)")
+		return LogSMSSender{}, nil
+	default:
+		return nil, fmt.Errorf("unknown SMS_PROVIDER %q", provider)
+	}
+}
+
+// LogSMSSender écrit les SMS dans les logs au lieu de les envoyer (développement)
+type LogSMSSender struct{}
+
+func (LogSMSSender) Send(ctx context.Context, to, message string) error {
+	Info("SMS to %s: %s", to, message)
+	return nil
+}
+
+// SMSMessage est un SMS enregistré par MemorySMSSender
+type SMSMessage struct {
+	To      string
+	Message string
+}
+
+// MemorySMSSender garde les SMS en mémoire (tests)
+type MemorySMSSender struct {
+	mu       sync.Mutex
+	messages []SMSMessage
+}
+
+func (s *MemorySMSSender) Send(ctx context.Context, to, message string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, SMSMessage{To: to, Message: message})
+	return nil
+}
+
+// Messages retourne les SMS envoyés
+func (s *MemorySMSSender) Messages() []SMSMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SMSMessage{}, s.messages...)
+}
+
+// LastMessage retourne le dernier SMS envoyé au numéro
+func (s *MemorySMSSender) LastMessage(to string) (SMSMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return s.messages[i], true
+		}
+	}
+	return SMSMessage{}, false
+}
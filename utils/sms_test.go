@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSMSSenderFromEnv(t *testing.T) {
+	t.Setenv("TWILIO_ACCOUNT_SID", "")
+	t.Setenv("TWILIO_AUTH_TOKEN", "")
+	t.Setenv("TWILIO_FROM", "")
+
+	t.Run("The log sender is refused outside development", func(t *testing.T) {
+		t.Setenv("ENV", "production")
+		t.Setenv("SMS_PROVIDER", "")
+		_, err := NewSMSSenderFromEnv()
+		assert.Error(t, err)
+
+		t.Setenv("SMS_PROVIDER", "log")
+		_, err = NewSMSSenderFromEnv()
+		assert.Error(t, err)
+	})
+
+	t.Run("The log sender is accepted in development", func(t *testing.T) {
+		t.Setenv("ENV", "development")
+		t.Setenv("SMS_PROVIDER", "")
+		sender, err := NewSMSSenderFromEnv()
+		require.NoError(t, err)
+		assert.IsType(t, LogSMSSender{}, sender)
+	})
+
+	t.Run("Twilio requires its credentials", func(t *testing.T) {
+		t.Setenv("ENV", "development")
+		t.Setenv("SMS_PROVIDER", "twilio")
+		_, err := NewSMSSenderFromEnv()
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown providers are refused", func(t *testing.T) {
+		t.Setenv("ENV", "development")
+		t.Setenv("SMS_PROVIDER", "smtp")
+		_, err := NewSMSSenderFromEnv()
+		assert.Error(t, err)
+	})
+}
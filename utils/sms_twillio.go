@@ -1,33 +1,38 @@
 package utils
 
 import (
+	"context"
 	"fmt"
-	"math/rand"
-	"time"
+	"net/url"
 
 	"github.com/kevinburke/twilio-go"
 )
 
-var (
-	accountSid = "ACce339b854f4bda3f03d64bc828efb01a"
-	authToken  = "c82adfc7ca8fb11a8625c83175e13471"
-)
+// TwilioSMSSender envoie les SMS via l'API Twilio
+type TwilioSMSSender struct {
+	client *twilio.Client
+	from   string
+}
 
-func SendMessageSMS(to string, message string) bool {
-	client := twilio.NewClient(accountSid, authToken, nil)
+// NewTwilioSMSSender crée un sender Twilio (from: numéro ou alphanumeric sender ID, ex: "innov")
+func NewTwilioSMSSender(accountSid, authToken, from string) *TwilioSMSSender {
+	return &TwilioSMSSender{
+		client: twilio.NewClient(accountSid, authToken, nil),
+		from:   from,
+	}
+}
 
-	msg, err := client.Messages.SendMessage("innov", to, message, nil)
+func (s *TwilioSMSSender) Send(ctx context.Context, to, message string) error {
+	data := url.Values{}
+	data.Set("From", s.from)
+	data.Set("To", to)
+	data.Set("Body", message)
+
+	msg, err := s.client.Messages.Create(ctx, data)
 	if err != nil {
-		fmt.Errorf("%s", err)
-		return false
+		return fmt.Errorf("twilio: %w", err)
 	}
 
-	fmt.Println(msg.Status)
-	return true
-
-}
-func GenerateCode() int {
-	rand.Seed(time.Now().UnixNano())
-	code := rand.Intn(1000000)
-	return code
+	Debug("SMS sent to %s (status: %s)", to, msg.Status)
+	return nil
 }
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// TOTP parameters (RFC 6238): the defaults of the authenticator apps
+const (
+	TOTPDigits     = 6
+	TOTPPeriod     = 30 // secondes
+	TOTPSecretSize = 20 // octets (160 bits, recommandé pour HMAC-SHA1)
+	TOTPSkewSteps  = 1  // Tolérance d'un pas avant/après pour le décalage d'horloge
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret génère un secret TOTP aléatoire encodé en base32
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, TOTPSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPStep retourne le pas de temps (compteur RFC 6238) d'un instant
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// TOTPCode calcule le code d'un pas de temps (HOTP RFC 4226 sur HMAC-SHA1)
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(strings.TrimSpace(secret)))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %v", err)
+	}
+
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo), nil
+}
+
+// ValidateTOTP vérifie un code autour de l'instant t et retourne le pas de temps qu'il valide.
+// Le pas permet de refuser la réutilisation d'un code déjà accepté
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0, false
+	}
+	current := TOTPStep(t)
+	for step := current - TOTPSkewSteps; step <= current+TOTPSkewSteps; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+		if hmac.Equal([]byte(expected), []byte(code)) {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI retourne l'URI otpauth:// à afficher en QR code dans l'application d'authentification
+func TOTPURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(TOTPPeriod))
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// encryptedTOTPPrefix marque un secret chiffré (les secrets enregistrés avant le chiffrement sont en clair)
+const encryptedTOTPPrefix = "enc:"
+
+// TOTPEncryptionKey retourne la clé AES-256 de TOTP_ENCRYPTION_KEY (32 octets encodés en base64).
+// Sans clé, une clé de développement n'est utilisée qu'avec ENV=development (voir IsDevelopment)
+func TOTPEncryptionKey() ([]byte, error) {
+	value := strings.TrimSpace(os.Getenv("TOTP_ENCRYPTION_KEY"))
+	if value == "" {
+		if !IsDevelopment() {
+			return nil, fmt.Errorf("TOTP_ENCRYPTION_KEY is required outside development")
+		}
+		key := sha256.Sum256([]byte("rangoapp-development-totp-key"))
+		return key[:], nil
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("TOTP_ENCRYPTION_KEY must be 32 bytes encoded in base64")
+	}
+	return key, nil
+}
+
+func totpCipher() (cipher.AEAD, error) {
+	key, err := TOTPEncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptTOTPSecret chiffre un secret TOTP pour le stocker (AES-256-GCM)
+func EncryptTOTPSecret(secret string) (string, error) {
+	aead, err := totpCipher()
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(secret), nil)
+	return encryptedTOTPPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptTOTPSecret déchiffre un secret stocké; un secret enregistré en clair est retourné tel quel
+func DecryptTOTPSecret(stored string) (string, error) {
+	if !IsEncryptedTOTPSecret(stored) {
+		return stored, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedTOTPPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted TOTP secret")
+	}
+	aead, err := totpCipher()
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("invalid encrypted TOTP secret")
+	}
+	secret, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted TOTP secret")
+	}
+	return string(secret), nil
+}
+
+// IsEncryptedTOTPSecret indique si un secret stocké est chiffré
+func IsEncryptedTOTPSecret(stored string) bool {
+	return strings.HasPrefix(stored, encryptedTOTPPrefix)
+}
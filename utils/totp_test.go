@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Secret SHA1 des vecteurs de test de la RFC 6238 (annexe B)
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCodeRFC6238(t *testing.T) {
+	// Les 6 derniers chiffres des codes à 8 chiffres de la RFC
+	vectors := map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	}
+	for unix, expected := range vectors {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(unix, 0)))
+		assert.NoError(t, err)
+		assert.Equal(t, expected, code, unix)
+	}
+
+	_, err := TOTPCode("not base32!", 1)
+	assert.Error(t, err)
+}
+
+func TestValidateTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step, ok := ValidateTOTP(rfc6238Secret, "050471", now)
+	assert.True(t, ok)
+	assert.Equal(t, TOTPStep(now), step)
+
+	// Un pas de décalage d'horloge est toléré, pas deux
+	_, ok = ValidateTOTP(rfc6238Secret, "050471", now.Add(TOTPPeriod*time.Second))
+	assert.True(t, ok)
+	_, ok = ValidateTOTP(rfc6238Secret, "050471", now.Add(2*TOTPPeriod*time.Second))
+	assert.False(t, ok)
+
+	_, ok = ValidateTOTP(rfc6238Secret, "000000", now)
+	assert.False(t, ok)
+	_, ok = ValidateTOTP(rfc6238Secret, "05047", now)
+	assert.False(t, ok)
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	assert.NoError(t, err)
+	assert.Len(t, secret, 32)
+
+	code, err := TOTPCode(secret, TOTPStep(time.Now()))
+	assert.NoError(t, err)
+	assert.Len(t, code, TOTPDigits)
+
+	uri := TOTPURI("RangoApp", "+243810000001", secret)
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/RangoApp:+243810000001?"))
+	assert.Contains(t, uri, "secret="+secret)
+	assert.Contains(t, uri, "issuer=RangoApp")
+}
+
+func TestEncryptTOTPSecret(t *testing.T) {
+	t.Setenv("TOTP_ENCRYPTION_KEY", "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+
+	encrypted, err := EncryptTOTPSecret(rfc6238Secret)
+	assert.NoError(t, err)
+	assert.True(t, IsEncryptedTOTPSecret(encrypted))
+	assert.NotContains(t, encrypted, rfc6238Secret)
+
+	secret, err := DecryptTOTPSecret(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, rfc6238Secret, secret)
+
+	// Les secrets enregistrés en clair avant le chiffrement restent lisibles
+	secret, err = DecryptTOTPSecret(rfc6238Secret)
+	assert.NoError(t, err)
+	assert.Equal(t, rfc6238Secret, secret)
+
+	// Une autre clé ne déchiffre pas le secret
+	t.Setenv("TOTP_ENCRYPTION_KEY", "ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	_, err = DecryptTOTPSecret(encrypted)
+	assert.Error(t, err)
+}
+
+func TestTOTPEncryptionKey(t *testing.T) {
+	t.Setenv("TOTP_ENCRYPTION_KEY", "")
+	t.Setenv("ENV", "production")
+	_, err := TOTPEncryptionKey()
+	assert.Error(t, err, "A key is required outside development")
+
+	t.Setenv("ENV", "development")
+	key, err := TOTPEncryptionKey()
+	assert.NoError(t, err)
+	assert.Len(t, key, 32)
+
+	t.Setenv("TOTP_ENCRYPTION_KEY", "dG9vIHNob3J0")
+	_, err = TOTPEncryptionKey()
+	assert.Error(t, err)
+}
@@ -18,6 +18,16 @@ func ValidateRegisterInput(input *model.RegisterInput) error {
 	if err := ValidatePhone(input.Phone); err != nil {
 		return err
 	}
+	if input.VerificationCode != nil && *input.VerificationCode != "" {
+		if err := ValidateOTPCode(*input.VerificationCode); err != nil {
+			return err
+		}
+	}
+	if input.InvitationCode != nil && *input.InvitationCode != "" {
+		if err := ValidateInvitationCode(*input.InvitationCode); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -174,10 +184,21 @@ func ValidateCreateProductInput(input *model.CreateProductInput) error {
 	if err := ValidateObjectID(input.StoreID, "Store ID"); err != nil {
 		return err
 	}
+	if input.Barcode != nil && *input.Barcode != "" {
+		if err := ValidateBarcode(*input.Barcode); err != nil {
+			return err
+		}
+	}
+	if input.Sku != nil && *input.Sku != "" {
+		if err := ValidateSKU(*input.Sku); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 // ValidateUpdateProductInput validates UpdateProductInput
+// An empty barcode or SKU is allowed and clears the code
 func ValidateUpdateProductInput(input *model.UpdateProductInput) error {
 	if input.Name != nil {
 		if err := ValidateString(*input.Name, "Product name", false, 2, 200); err != nil {
@@ -189,6 +210,16 @@ func ValidateUpdateProductInput(input *model.UpdateProductInput) error {
 			return err
 		}
 	}
+	if input.Barcode != nil && *input.Barcode != "" {
+		if err := ValidateBarcode(*input.Barcode); err != nil {
+			return err
+		}
+	}
+	if input.Sku != nil && *input.Sku != "" {
+		if err := ValidateSKU(*input.Sku); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -430,12 +461,77 @@ func ValidateSaleProductInput(input *model.SaleProductInput) error {
 	return nil
 }
 
+// ValidateSaleByProductInput validates SaleByProductInput
+func ValidateSaleByProductInput(input *model.SaleByProductInput) error {
+	if err := ValidateObjectID(input.ProductID, "Product ID"); err != nil {
+		return err
+	}
+	if err := ValidateFloat(input.Quantity, "Quantity", true, 0.01, 0); err != nil {
+		return err
+	}
+	if err := ValidateFloat(input.Price, "Price", true, 0, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ValidateSalePaymentInput validates SalePaymentInput
+func ValidateSalePaymentInput(input *model.SalePaymentInput) error {
+	if err := ValidatePaymentMethod(input.Method); err != nil {
+		return err
+	}
+	if err := ValidateFloat(input.Amount, "Amount", true, 0.01, 0); err != nil {
+		return err
+	}
+	if input.Currency != nil && *input.Currency != "" {
+		if err := ValidateCurrency(*input.Currency); err != nil {
+			return err
+		}
+	}
+	if input.Reference != nil {
+		if err := ValidateString(*input.Reference, "Reference", false, 0, 100); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateInitiatePaymentInput validates InitiatePaymentInput
+func ValidateInitiatePaymentInput(input *model.InitiatePaymentInput) error {
+	if err := ValidateObjectID(input.StoreID, "Store ID"); err != nil {
+		return err
+	}
+	if input.Method != "mpesa" && input.Method != "airtel_money" && input.Method != "orange_money" {
+		return gqlerror.Errorf("Invalid mobile money method. Must be 'mpesa', 'airtel_money' or 'orange_money'")
+	}
+	if err := ValidatePhone(input.Phone); err != nil {
+		return err
+	}
+	if err := ValidateFloat(input.Amount, "Amount", true, 0.01, 0); err != nil {
+		return err
+	}
+	if (input.SaleID == nil) == (input.DebtID == nil) {
+		return gqlerror.Errorf("Either saleId or debtId is required")
+	}
+	if input.SaleID != nil {
+		if err := ValidateObjectID(*input.SaleID, "Sale ID"); err != nil {
+			return err
+		}
+	}
+	if input.DebtID != nil {
+		if err := ValidateObjectID(*input.DebtID, "Debt ID"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ValidateCreateSaleInput validates CreateSaleInput
 func ValidateCreateSaleInput(input *model.CreateSaleInput) error {
-	if input.Basket == nil || len(input.Basket) == 0 {
+	if len(input.Basket) == 0 && len(input.Products) == 0 {
 		return gqlerror.Errorf("Basket cannot be empty")
 	}
-	if len(input.Basket) > 100 {
+	if len(input.Basket)+len(input.Products) > 100 {
 		return gqlerror.Errorf("Maximum 100 products allowed per sale")
 	}
 	for i, product := range input.Basket {
@@ -443,12 +539,30 @@ func ValidateCreateSaleInput(input *model.CreateSaleInput) error {
 			return gqlerror.Errorf("Product %d: %v", i+1, err)
 		}
 	}
+	for i, product := range input.Products {
+		if err := ValidateSaleByProductInput(product); err != nil {
+			return gqlerror.Errorf("Product %d: %v", len(input.Basket)+i+1, err)
+		}
+	}
 	if err := ValidateFloat(input.PriceToPay, "Price to pay", true, 0.01, 0); err != nil {
 		return err
 	}
 	if err := ValidateFloat(input.PricePayed, "Price payed", true, 0.01, 0); err != nil {
 		return err
 	}
+	if len(input.Payments) > 10 {
+		return gqlerror.Errorf("Maximum 10 payments allowed per sale")
+	}
+	for i, payment := range input.Payments {
+		if err := ValidateSalePaymentInput(payment); err != nil {
+			return gqlerror.Errorf("Payment %d: %v", i+1, err)
+		}
+	}
+	if input.ChangeCurrency != nil && *input.ChangeCurrency != "" {
+		if err := ValidateCurrency(*input.ChangeCurrency); err != nil {
+			return err
+		}
+	}
 	// Note: PricePayed can be less than PriceToPay to allow discounts/reductions
 	// The seller has full control over pricing and discounts
 	// Currency is optional - if provided, validate it
@@ -474,6 +588,157 @@ func ValidateCreateSaleInput(input *model.CreateSaleInput) error {
 	return nil
 }
 
+// ValidateCreateSaleReturnInput validates CreateSaleReturnInput
+func ValidateCreateSaleReturnInput(input *model.CreateSaleReturnInput) error {
+	if err := ValidateObjectID(input.SaleID, "Sale ID"); err != nil {
+		return err
+	}
+	if input.Items == nil || len(input.Items) == 0 {
+		return gqlerror.Errorf("Items cannot be empty")
+	}
+	if len(input.Items) > 100 {
+		return gqlerror.Errorf("Maximum 100 products allowed per return")
+	}
+	for i, item := range input.Items {
+		if err := ValidateObjectID(item.ProductInStockID, "Product In Stock ID"); err != nil {
+			return gqlerror.Errorf("Item %d: %v", i+1, err)
+		}
+		if err := ValidateFloat(item.Quantity, "Quantity", true, 0.01, 0); err != nil {
+			return gqlerror.Errorf("Item %d: %v", i+1, err)
+		}
+	}
+	if input.RefundMethod != "cash" && input.RefundMethod != "debt" {
+		return gqlerror.Errorf("Refund method must be cash or debt")
+	}
+	if input.Reason != nil {
+		if err := ValidateString(*input.Reason, "Reason", false, 0, 500); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateLotInput validates the optional batch number and expiry date of a supply
+func ValidateLotInput(batchNumber, expiryDate *string) error {
+	if batchNumber != nil {
+		if err := ValidateString(*batchNumber, "Batch number", false, 0, 64); err != nil {
+			return err
+		}
+	}
+	if expiryDate != nil && *expiryDate != "" {
+		if err := ValidateDate(*expiryDate, "Expiry date"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateCreatePurchaseOrderInput validates CreatePurchaseOrderInput
+func ValidateCreatePurchaseOrderInput(input *model.CreatePurchaseOrderInput) error {
+	if err := ValidateObjectID(input.StoreID, "Store ID"); err != nil {
+		return err
+	}
+	if err := ValidateObjectID(input.ProviderID, "Provider ID"); err != nil {
+		return err
+	}
+	if input.Lines == nil || len(input.Lines) == 0 {
+		return gqlerror.Errorf("Lines cannot be empty")
+	}
+	if len(input.Lines) > 100 {
+		return gqlerror.Errorf("Maximum 100 lines allowed per purchase order")
+	}
+	for i, line := range input.Lines {
+		if err := ValidateObjectID(line.ProductID, "Product ID"); err != nil {
+			return gqlerror.Errorf("Line %d: %v", i+1, err)
+		}
+		if err := ValidateFloat(line.Quantity, "Quantity", true, 0.01, 0); err != nil {
+			return gqlerror.Errorf("Line %d: %v", i+1, err)
+		}
+		if err := ValidateFloat(line.PriceAchat, "Price d'achat", true, 0.01, 0); err != nil {
+			return gqlerror.Errorf("Line %d: %v", i+1, err)
+		}
+		if err := ValidateProductPrices(line.PriceVente, line.PriceAchat); err != nil {
+			return gqlerror.Errorf("Line %d: %v", i+1, err)
+		}
+	}
+	if input.Currency != nil && *input.Currency != "" {
+		if err := ValidateCurrency(*input.Currency); err != nil {
+			return err
+		}
+	}
+	if input.Note != nil {
+		if err := ValidateString(*input.Note, "Note", false, 0, 500); err != nil {
+			return err
+		}
+	}
+	if input.ExpectedDate != nil && *input.ExpectedDate != "" {
+		if err := ValidateDate(*input.ExpectedDate, "Expected date"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateReceivePurchaseOrderInput validates ReceivePurchaseOrderInput
+func ValidateReceivePurchaseOrderInput(input *model.ReceivePurchaseOrderInput) error {
+	if input.Lines == nil || len(input.Lines) == 0 {
+		return gqlerror.Errorf("Lines cannot be empty")
+	}
+	for i, line := range input.Lines {
+		if err := ValidateObjectID(line.ProductID, "Product ID"); err != nil {
+			return gqlerror.Errorf("Line %d: %v", i+1, err)
+		}
+		if err := ValidateFloat(line.Quantity, "Quantity", true, 0.01, 0); err != nil {
+			return gqlerror.Errorf("Line %d: %v", i+1, err)
+		}
+		if err := ValidateLotInput(line.BatchNumber, line.ExpiryDate); err != nil {
+			return gqlerror.Errorf("Line %d: %v", i+1, err)
+		}
+	}
+	if input.PaymentType != "cash" && input.PaymentType != "debt" {
+		return gqlerror.Errorf("Payment type must be 'cash' or 'debt'")
+	}
+	if input.Date != nil && *input.Date != "" {
+		if err := ValidateDate(*input.Date, "Date"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateCreateStockTransferInput validates CreateStockTransferInput
+func ValidateCreateStockTransferInput(input *model.CreateStockTransferInput) error {
+	if err := ValidateObjectID(input.FromStoreID, "From Store ID"); err != nil {
+		return err
+	}
+	if err := ValidateObjectID(input.ToStoreID, "To Store ID"); err != nil {
+		return err
+	}
+	if input.FromStoreID == input.ToStoreID {
+		return gqlerror.Errorf("Source and destination stores must be different")
+	}
+	if input.Items == nil || len(input.Items) == 0 {
+		return gqlerror.Errorf("Items cannot be empty")
+	}
+	if len(input.Items) > 100 {
+		return gqlerror.Errorf("Maximum 100 products allowed per transfer")
+	}
+	for i, item := range input.Items {
+		if err := ValidateObjectID(item.ProductInStockID, "Product In Stock ID"); err != nil {
+			return gqlerror.Errorf("Item %d: %v", i+1, err)
+		}
+		if err := ValidateFloat(item.Quantity, "Quantity", true, 0.01, 0); err != nil {
+			return gqlerror.Errorf("Item %d: %v", i+1, err)
+		}
+	}
+	if input.Note != nil {
+		if err := ValidateString(*input.Note, "Note", false, 0, 500); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ValidateCreateInventoryInput validates CreateInventoryInput
 func ValidateCreateInventoryInput(input *model.CreateInventoryInput) error {
 	if err := ValidateObjectID(input.StoreID, "Store ID"); err != nil {
@@ -505,6 +770,17 @@ func ValidateAddInventoryItemInput(input *model.AddInventoryItemInput) error {
 	return nil
 }
 
+// ValidateResetPasswordInput validates the resetPassword arguments
+func ValidateResetPasswordInput(phone, code, newPassword string) error {
+	if err := ValidatePhone(phone); err != nil {
+		return err
+	}
+	if err := ValidateOTPCode(code); err != nil {
+		return err
+	}
+	return ValidatePassword(newPassword)
+}
+
 // ValidateChangePasswordInput validates ChangePasswordInput
 func ValidateChangePasswordInput(input *model.ChangePasswordInput) error {
 	if input.CurrentPassword == "" {
@@ -519,3 +795,149 @@ func ValidateChangePasswordInput(input *model.ChangePasswordInput) error {
 	}
 	return nil
 }
+
+// ValidateRoleInput validates RoleInput (permission keys are checked against the catalogue by the database layer)
+func ValidateRoleInput(input *model.RoleInput) error {
+	if err := ValidateString(input.Name, "Name", true, 2, 50); err != nil {
+		return err
+	}
+	if input.Description != nil {
+		if err := ValidateString(*input.Description, "Description", false, 0, 200); err != nil {
+			return err
+		}
+	}
+	if len(input.Permissions) == 0 {
+		return gqlerror.Errorf("At least one permission is required")
+	}
+	return nil
+}
+
+// ValidateCreateAPIKeyInput validates CreateAPIKeyInput (permissions are checked by the database layer)
+func ValidateCreateAPIKeyInput(input *model.CreateAPIKeyInput) error {
+	if err := ValidateString(input.Name, "Name", true, 2, 50); err != nil {
+		return err
+	}
+	if len(input.StoreIds) == 0 {
+		return gqlerror.Errorf("At least one store is required")
+	}
+	for _, storeID := range input.StoreIds {
+		if err := ValidateObjectID(storeID, "Store ID"); err != nil {
+			return err
+		}
+	}
+	if len(input.Permissions) == 0 {
+		return gqlerror.Errorf("At least one permission is required")
+	}
+	if input.ExpiresAt != nil && *input.ExpiresAt != "" {
+		if err := ValidateDate(*input.ExpiresAt, "expiresAt"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateCreateInvitationInput validates CreateInvitationInput (role and store are checked by the database layer)
+func ValidateCreateInvitationInput(input *model.CreateInvitationInput) error {
+	if input.Phone != nil && *input.Phone != "" {
+		if err := ValidatePhone(*input.Phone); err != nil {
+			return err
+		}
+	}
+	if err := ValidateRole(input.Role); err != nil {
+		return err
+	}
+	if input.RoleID != nil {
+		if err := ValidateObjectID(*input.RoleID, "Role ID"); err != nil {
+			return err
+		}
+	}
+	if input.StoreID != nil {
+		if err := ValidateObjectID(*input.StoreID, "Store ID"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidatePromotionInput validates PromotionInput (the fields required by the type are checked by the database layer)
+func ValidatePromotionInput(input *model.PromotionInput) error {
+	if err := ValidateString(input.Name, "Name", true, 2, 100); err != nil {
+		return err
+	}
+	if input.Description != nil {
+		if err := ValidateString(*input.Description, "Description", false, 0, 500); err != nil {
+			return err
+		}
+	}
+	validTypes := map[string]bool{
+		"percentage":        true,
+		"fixed":             true,
+		"buy_x_get_y":       true,
+		"basket_percentage": true,
+		"basket_fixed":      true,
+	}
+	if !validTypes[input.Type] {
+		return gqlerror.Errorf("Invalid promotion type. Must be 'percentage', 'fixed', 'buy_x_get_y', 'basket_percentage' or 'basket_fixed'")
+	}
+	if input.Value != nil {
+		if err := ValidateFloat(*input.Value, "Value", false, 0, 0); err != nil {
+			return err
+		}
+	}
+	if input.Currency != nil && *input.Currency != "" {
+		if err := ValidateCurrency(*input.Currency); err != nil {
+			return err
+		}
+	}
+	for _, productID := range input.ProductIds {
+		if err := ValidateObjectID(productID, "Product ID"); err != nil {
+			return err
+		}
+	}
+	for _, storeID := range input.StoreIds {
+		if err := ValidateObjectID(storeID, "Store ID"); err != nil {
+			return err
+		}
+	}
+	if input.BuyQuantity != nil {
+		if err := ValidateFloat(*input.BuyQuantity, "Buy quantity", false, 0, 0); err != nil {
+			return err
+		}
+	}
+	if input.GetQuantity != nil {
+		if err := ValidateFloat(*input.GetQuantity, "Get quantity", false, 0, 0); err != nil {
+			return err
+		}
+	}
+	if input.MinBasketAmount != nil {
+		if err := ValidateFloat(*input.MinBasketAmount, "Minimum basket amount", false, 0, 0); err != nil {
+			return err
+		}
+	}
+	if input.StartDate != nil && *input.StartDate != "" {
+		if err := ValidateDate(*input.StartDate, "startDate"); err != nil {
+			return err
+		}
+	}
+	if input.EndDate != nil && *input.EndDate != "" {
+		if err := ValidateDate(*input.EndDate, "endDate"); err != nil {
+			return err
+		}
+	}
+	for _, day := range input.DaysOfWeek {
+		if err := ValidateInt(day, "Day of week", false, 0, 6); err != nil {
+			return err
+		}
+	}
+	if input.StartTime != nil && *input.StartTime != "" {
+		if err := ValidateTimeOfDay(*input.StartTime, "startTime"); err != nil {
+			return err
+		}
+	}
+	if input.EndTime != nil && *input.EndTime != "" {
+		if err := ValidateTimeOfDay(*input.EndTime, "endTime"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package validators
 
 import (
 	"rangoapp/graph/model"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -427,6 +428,254 @@ func TestValidateCreateSaleInput(t *testing.T) {
 		err := ValidateCreateSaleInput(input)
 		assert.Error(t, err)
 	})
+
+	t.Run("Valid sale by product", func(t *testing.T) {
+		input := &model.CreateSaleInput{
+			Basket: []*model.SaleProductInput{},
+			Products: []*model.SaleByProductInput{
+				{
+					ProductID: validProductID,
+					Quantity:  3.0,
+					Price:     50.0,
+				},
+			},
+			PriceToPay: 150.0,
+			PricePayed: 150.0,
+			StoreID:    validStoreID,
+		}
+		err := ValidateCreateSaleInput(input)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Invalid product ID in sale by product", func(t *testing.T) {
+		input := &model.CreateSaleInput{
+			Basket: []*model.SaleProductInput{},
+			Products: []*model.SaleByProductInput{
+				{
+					ProductID: "invalid",
+					Quantity:  3.0,
+					Price:     50.0,
+				},
+			},
+			PriceToPay: 150.0,
+			PricePayed: 150.0,
+			StoreID:    validStoreID,
+		}
+		err := ValidateCreateSaleInput(input)
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateCreateSaleReturnInput(t *testing.T) {
+	validSaleID := primitive.NewObjectID().Hex()
+	validProductID := primitive.NewObjectID().Hex()
+
+	t.Run("Valid input", func(t *testing.T) {
+		input := &model.CreateSaleReturnInput{
+			SaleID: validSaleID,
+			Items: []*model.SaleReturnItemInput{
+				{ProductInStockID: validProductID, Quantity: 1.0},
+			},
+			RefundMethod: "cash",
+		}
+		err := ValidateCreateSaleReturnInput(input)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Empty items", func(t *testing.T) {
+		input := &model.CreateSaleReturnInput{
+			SaleID:       validSaleID,
+			Items:        []*model.SaleReturnItemInput{},
+			RefundMethod: "cash",
+		}
+		err := ValidateCreateSaleReturnInput(input)
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid quantity", func(t *testing.T) {
+		input := &model.CreateSaleReturnInput{
+			SaleID: validSaleID,
+			Items: []*model.SaleReturnItemInput{
+				{ProductInStockID: validProductID, Quantity: -1.0},
+			},
+			RefundMethod: "debt",
+		}
+		err := ValidateCreateSaleReturnInput(input)
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid refund method", func(t *testing.T) {
+		input := &model.CreateSaleReturnInput{
+			SaleID: validSaleID,
+			Items: []*model.SaleReturnItemInput{
+				{ProductInStockID: validProductID, Quantity: 1.0},
+			},
+			RefundMethod: "voucher",
+		}
+		err := ValidateCreateSaleReturnInput(input)
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateCreateStockTransferInput(t *testing.T) {
+	fromStoreID := primitive.NewObjectID().Hex()
+	toStoreID := primitive.NewObjectID().Hex()
+	validProductID := primitive.NewObjectID().Hex()
+
+	t.Run("Valid input", func(t *testing.T) {
+		input := &model.CreateStockTransferInput{
+			FromStoreID: fromStoreID,
+			ToStoreID:   toStoreID,
+			Items: []*model.StockTransferItemInput{
+				{ProductInStockID: validProductID, Quantity: 5.0},
+			},
+		}
+		err := ValidateCreateStockTransferInput(input)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Same store", func(t *testing.T) {
+		input := &model.CreateStockTransferInput{
+			FromStoreID: fromStoreID,
+			ToStoreID:   fromStoreID,
+			Items: []*model.StockTransferItemInput{
+				{ProductInStockID: validProductID, Quantity: 5.0},
+			},
+		}
+		err := ValidateCreateStockTransferInput(input)
+		assert.Error(t, err)
+	})
+
+	t.Run("Empty items", func(t *testing.T) {
+		input := &model.CreateStockTransferInput{
+			FromStoreID: fromStoreID,
+			ToStoreID:   toStoreID,
+			Items:       []*model.StockTransferItemInput{},
+		}
+		err := ValidateCreateStockTransferInput(input)
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateCreatePurchaseOrderInput(t *testing.T) {
+	storeID := primitive.NewObjectID().Hex()
+	providerID := primitive.NewObjectID().Hex()
+	productID := primitive.NewObjectID().Hex()
+
+	t.Run("Valid input", func(t *testing.T) {
+		input := &model.CreatePurchaseOrderInput{
+			StoreID:    storeID,
+			ProviderID: providerID,
+			Lines: []*model.PurchaseOrderLineInput{
+				{ProductID: productID, Quantity: 10.0, PriceAchat: 5.0, PriceVente: 8.0},
+			},
+		}
+		err := ValidateCreatePurchaseOrderInput(input)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Empty lines", func(t *testing.T) {
+		input := &model.CreatePurchaseOrderInput{
+			StoreID:    storeID,
+			ProviderID: providerID,
+			Lines:      []*model.PurchaseOrderLineInput{},
+		}
+		err := ValidateCreatePurchaseOrderInput(input)
+		assert.Error(t, err)
+	})
+
+	t.Run("Selling price below purchase price", func(t *testing.T) {
+		input := &model.CreatePurchaseOrderInput{
+			StoreID:    storeID,
+			ProviderID: providerID,
+			Lines: []*model.PurchaseOrderLineInput{
+				{ProductID: productID, Quantity: 10.0, PriceAchat: 8.0, PriceVente: 5.0},
+			},
+		}
+		err := ValidateCreatePurchaseOrderInput(input)
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid expected date", func(t *testing.T) {
+		expectedDate := "next week"
+		input := &model.CreatePurchaseOrderInput{
+			StoreID:    storeID,
+			ProviderID: providerID,
+			Lines: []*model.PurchaseOrderLineInput{
+				{ProductID: productID, Quantity: 10.0, PriceAchat: 5.0, PriceVente: 8.0},
+			},
+			ExpectedDate: &expectedDate,
+		}
+		err := ValidateCreatePurchaseOrderInput(input)
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateReceivePurchaseOrderInput(t *testing.T) {
+	productID := primitive.NewObjectID().Hex()
+
+	t.Run("Valid input", func(t *testing.T) {
+		input := &model.ReceivePurchaseOrderInput{
+			Lines: []*model.PurchaseOrderReceiptLineInput{
+				{ProductID: productID, Quantity: 4.0},
+			},
+			PaymentType: "debt",
+		}
+		err := ValidateReceivePurchaseOrderInput(input)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Invalid payment type", func(t *testing.T) {
+		input := &model.ReceivePurchaseOrderInput{
+			Lines: []*model.PurchaseOrderReceiptLineInput{
+				{ProductID: productID, Quantity: 4.0},
+			},
+			PaymentType: "credit",
+		}
+		err := ValidateReceivePurchaseOrderInput(input)
+		assert.Error(t, err)
+	})
+
+	t.Run("Empty lines", func(t *testing.T) {
+		input := &model.ReceivePurchaseOrderInput{
+			Lines:       []*model.PurchaseOrderReceiptLineInput{},
+			PaymentType: "cash",
+		}
+		err := ValidateReceivePurchaseOrderInput(input)
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid expiry date", func(t *testing.T) {
+		expiryDate := "01/03/2026"
+		input := &model.ReceivePurchaseOrderInput{
+			Lines: []*model.PurchaseOrderReceiptLineInput{
+				{ProductID: productID, Quantity: 4.0, ExpiryDate: &expiryDate},
+			},
+			PaymentType: "cash",
+		}
+		err := ValidateReceivePurchaseOrderInput(input)
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateLotInput(t *testing.T) {
+	t.Run("No lot information", func(t *testing.T) {
+		err := ValidateLotInput(nil, nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Valid batch and expiry date", func(t *testing.T) {
+		batchNumber := "LOT-2026-03"
+		expiryDate := "2026-03-01"
+		err := ValidateLotInput(&batchNumber, &expiryDate)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Batch number too long", func(t *testing.T) {
+		batchNumber := strings.Repeat("A", 65)
+		err := ValidateLotInput(&batchNumber, nil)
+		assert.Error(t, err)
+	})
 }
 
 func TestValidateCreateCaisseTransactionInput(t *testing.T) {
@@ -688,3 +937,258 @@ func stringPtr(s string) *string {
 
 
 
+
+func TestValidateRoleInput(t *testing.T) {
+	t.Run("Valid input", func(t *testing.T) {
+		input := &model.RoleInput{
+			Name:        "Cashier",
+			Description: stringPtr("Caissier"),
+			Permissions: []string{"sale.create", "caisse.view"},
+		}
+		err := ValidateRoleInput(input)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Name too short", func(t *testing.T) {
+		input := &model.RoleInput{
+			Name:        "C",
+			Permissions: []string{"sale.create"},
+		}
+		err := ValidateRoleInput(input)
+		assert.Error(t, err)
+	})
+
+	t.Run("No permission", func(t *testing.T) {
+		input := &model.RoleInput{
+			Name:        "Cashier",
+			Permissions: []string{},
+		}
+		err := ValidateRoleInput(input)
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateResetPasswordInput(t *testing.T) {
+	t.Run("Valid input", func(t *testing.T) {
+		err := ValidateResetPasswordInput("+243810000001", "012345", "newPassword123")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Invalid code", func(t *testing.T) {
+		assert.Error(t, ValidateResetPasswordInput("+243810000001", "12345", "newPassword123"))
+		assert.Error(t, ValidateResetPasswordInput("+243810000001", "12a456", "newPassword123"))
+	})
+
+	t.Run("Invalid new password", func(t *testing.T) {
+		err := ValidateResetPasswordInput("+243810000001", "012345", "short")
+		assert.Error(t, err)
+	})
+}
+
+func TestValidateCreateAPIKeyInput(t *testing.T) {
+	t.Run("Valid input", func(t *testing.T) {
+		input := &model.CreateAPIKeyInput{
+			Name:        "Comptabilité",
+			StoreIds:    []string{"507f1f77bcf86cd799439011"},
+			Permissions: []string{"report.view"},
+			ExpiresAt:   stringPtr("2027-01-01"),
+		}
+		assert.NoError(t, ValidateCreateAPIKeyInput(input))
+	})
+
+	t.Run("No store", func(t *testing.T) {
+		input := &model.CreateAPIKeyInput{
+			Name:        "Comptabilité",
+			StoreIds:    []string{},
+			Permissions: []string{"report.view"},
+		}
+		assert.Error(t, ValidateCreateAPIKeyInput(input))
+	})
+
+	t.Run("Invalid store ID", func(t *testing.T) {
+		input := &model.CreateAPIKeyInput{
+			Name:        "Comptabilité",
+			StoreIds:    []string{"store-1"},
+			Permissions: []string{"report.view"},
+		}
+		assert.Error(t, ValidateCreateAPIKeyInput(input))
+	})
+
+	t.Run("Invalid expiry date", func(t *testing.T) {
+		input := &model.CreateAPIKeyInput{
+			Name:        "Comptabilité",
+			StoreIds:    []string{"507f1f77bcf86cd799439011"},
+			Permissions: []string{"report.view"},
+			ExpiresAt:   stringPtr("01/01/2027"),
+		}
+		assert.Error(t, ValidateCreateAPIKeyInput(input))
+	})
+}
+
+func TestValidateCreateInvitationInput(t *testing.T) {
+	t.Run("Valid input", func(t *testing.T) {
+		input := &model.CreateInvitationInput{
+			Phone:   stringPtr("+243900000000"),
+			Role:    "User",
+			RoleID:  stringPtr("507f1f77bcf86cd799439011"),
+			StoreID: stringPtr("507f1f77bcf86cd799439012"),
+		}
+		assert.NoError(t, ValidateCreateInvitationInput(input))
+	})
+
+	t.Run("Without phone", func(t *testing.T) {
+		assert.NoError(t, ValidateCreateInvitationInput(&model.CreateInvitationInput{Role: "Admin"}))
+	})
+
+	t.Run("Invalid role", func(t *testing.T) {
+		assert.Error(t, ValidateCreateInvitationInput(&model.CreateInvitationInput{Role: "Owner"}))
+	})
+
+	t.Run("Invalid phone", func(t *testing.T) {
+		input := &model.CreateInvitationInput{Phone: stringPtr("abc"), Role: "User"}
+		assert.Error(t, ValidateCreateInvitationInput(input))
+	})
+
+	t.Run("Invalid store ID", func(t *testing.T) {
+		input := &model.CreateInvitationInput{Role: "User", StoreID: stringPtr("store-1")}
+		assert.Error(t, ValidateCreateInvitationInput(input))
+	})
+}
+
+func TestValidatePromotionInput(t *testing.T) {
+	value := 10.0
+
+	t.Run("Valid input", func(t *testing.T) {
+		input := &model.PromotionInput{
+			Name:       "Happy hour",
+			Type:       "percentage",
+			Value:      &value,
+			ProductIds: []string{"507f1f77bcf86cd799439011"},
+			StoreIds:   []string{"507f1f77bcf86cd799439012"},
+			StartDate:  stringPtr("2026-01-01"),
+			EndDate:    stringPtr("2026-01-31"),
+			DaysOfWeek: []int{5, 6},
+			StartTime:  stringPtr("17:00"),
+			EndTime:    stringPtr("19:00"),
+		}
+		assert.NoError(t, ValidatePromotionInput(input))
+	})
+
+	t.Run("Invalid type", func(t *testing.T) {
+		assert.Error(t, ValidatePromotionInput(&model.PromotionInput{Name: "Promo", Type: "bogo"}))
+	})
+
+	t.Run("Invalid currency", func(t *testing.T) {
+		input := &model.PromotionInput{Name: "Promo", Type: "basket_fixed", Value: &value, Currency: stringPtr("XAF")}
+		assert.Error(t, ValidatePromotionInput(input))
+	})
+
+	t.Run("Invalid product ID", func(t *testing.T) {
+		input := &model.PromotionInput{Name: "Promo", Type: "percentage", Value: &value, ProductIds: []string{"product-1"}}
+		assert.Error(t, ValidatePromotionInput(input))
+	})
+
+	t.Run("Invalid day of week", func(t *testing.T) {
+		input := &model.PromotionInput{Name: "Promo", Type: "basket_percentage", Value: &value, DaysOfWeek: []int{7}}
+		assert.Error(t, ValidatePromotionInput(input))
+	})
+
+	t.Run("Invalid time", func(t *testing.T) {
+		input := &model.PromotionInput{Name: "Promo", Type: "basket_percentage", Value: &value, StartTime: stringPtr("5pm")}
+		assert.Error(t, ValidatePromotionInput(input))
+	})
+}
+
+func TestValidateSalePaymentInput(t *testing.T) {
+	t.Run("Valid mobile money payment", func(t *testing.T) {
+		reference := "MP240101.1234.A12345"
+		input := &model.SalePaymentInput{Method: "mpesa", Amount: 50, Reference: &reference}
+		assert.NoError(t, ValidateSalePaymentInput(input))
+	})
+
+	t.Run("Invalid method", func(t *testing.T) {
+		input := &model.SalePaymentInput{Method: "cheque", Amount: 50}
+		assert.Error(t, ValidateSalePaymentInput(input))
+	})
+
+	t.Run("Zero amount", func(t *testing.T) {
+		input := &model.SalePaymentInput{Method: "cash", Amount: 0}
+		assert.Error(t, ValidateSalePaymentInput(input))
+	})
+
+	t.Run("Invalid currency", func(t *testing.T) {
+		currency := "GBP"
+		input := &model.SalePaymentInput{Method: "cash", Amount: 50, Currency: &currency}
+		assert.Error(t, ValidateSalePaymentInput(input))
+	})
+
+	t.Run("Sale with an invalid payment", func(t *testing.T) {
+		input := &model.CreateSaleInput{
+			Basket:     []*model.SaleProductInput{{ProductInStockID: primitive.NewObjectID().Hex(), Quantity: 1, Price: 100}},
+			PriceToPay: 100,
+			PricePayed: 100,
+			StoreID:    primitive.NewObjectID().Hex(),
+			Payments:   []*model.SalePaymentInput{{Method: "cash", Amount: 60}, {Method: "cheque", Amount: 40}},
+		}
+		assert.Error(t, ValidateCreateSaleInput(input))
+	})
+
+	t.Run("Sale paid in several currencies", func(t *testing.T) {
+		cdf, changeCurrency := "CDF", "CDF"
+		input := &model.CreateSaleInput{
+			Basket:         []*model.SaleProductInput{{ProductInStockID: primitive.NewObjectID().Hex(), Quantity: 1, Price: 100}},
+			PriceToPay:     100,
+			PricePayed:     100,
+			StoreID:        primitive.NewObjectID().Hex(),
+			Payments:       []*model.SalePaymentInput{{Method: "cash", Amount: 60}, {Method: "cash", Amount: 100000, Currency: &cdf}},
+			ChangeCurrency: &changeCurrency,
+		}
+		assert.NoError(t, ValidateCreateSaleInput(input))
+
+		invalid := "GBP"
+		input.ChangeCurrency = &invalid
+		assert.Error(t, ValidateCreateSaleInput(input))
+	})
+}
+
+func TestValidateInitiatePaymentInput(t *testing.T) {
+	saleID := primitive.NewObjectID().Hex()
+	valid := func() *model.InitiatePaymentInput {
+		return &model.InitiatePaymentInput{
+			StoreID: primitive.NewObjectID().Hex(),
+			Method:  "mpesa",
+			Phone:   "+243810000001",
+			Amount:  25,
+			SaleID:  &saleID,
+		}
+	}
+
+	t.Run("Valid input", func(t *testing.T) {
+		assert.NoError(t, ValidateInitiatePaymentInput(valid()))
+	})
+
+	t.Run("Cash is not a mobile money method", func(t *testing.T) {
+		input := valid()
+		input.Method = "cash"
+		assert.Error(t, ValidateInitiatePaymentInput(input))
+	})
+
+	t.Run("Invalid phone", func(t *testing.T) {
+		input := valid()
+		input.Phone = "abc"
+		assert.Error(t, ValidateInitiatePaymentInput(input))
+	})
+
+	t.Run("Sale and debt", func(t *testing.T) {
+		input := valid()
+		debtID := primitive.NewObjectID().Hex()
+		input.DebtID = &debtID
+		assert.Error(t, ValidateInitiatePaymentInput(input))
+
+		input.SaleID = nil
+		assert.NoError(t, ValidateInitiatePaymentInput(input))
+
+		input.DebtID = nil
+		assert.Error(t, ValidateInitiatePaymentInput(input))
+	})
+}
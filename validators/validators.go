@@ -3,6 +3,7 @@ package validators
 import (
 	"fmt"
 	"regexp"
+	"rangoapp/utils"
 	"strings"
 
 	"github.com/vektah/gqlparser/v2/gqlerror"
@@ -14,6 +15,12 @@ var (
 	emailRegex    = regexp.MustCompile(`^[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}$`)
 	phoneRegex   = regexp.MustCompile(`^\+?[1-9]\d{1,14}$|^[0-9]{8,15}$`) // International or local format
 	passwordRegex = regexp.MustCompile(`^.{8,}$`)                        // At least 8 characters
+	skuRegex      = regexp.MustCompile(`^[A-Za-z0-9._\-]+$`)
+	otpRegex      = regexp.MustCompile(`^[0-9]{6}$`) // Code SMS à 6 chiffres
+	recoveryCodeRegex = regexp.MustCompile(`^[A-Za-z0-9]{5}-?[A-Za-z0-9]{5}$`) // Code de secours 2FA
+	invitationCodeRegex = regexp.MustCompile(`^[A-Za-z0-9]{4}-?[A-Za-z0-9]{4}$`) // Code d'invitation (xxxx-xxxx)
+	timeOfDayRegex = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`) // Heure HH:MM (promotions)
+	idempotencyKeyRegex = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,255}$`) // Clé d'idempotence (UUID conseillé)
 )
 
 // ValidateEmail validates an email address
@@ -45,6 +52,14 @@ func ValidatePhone(phone string) error {
 	return nil
 }
 
+// ValidateOTPCode validates a code received by SMS
+func ValidateOTPCode(code string) error {
+	if !otpRegex.MatchString(strings.TrimSpace(code)) {
+		return gqlerror.Errorf("Code must be 6 digits")
+	}
+	return nil
+}
+
 // ValidatePassword validates a password
 func ValidatePassword(password string) error {
 	if password == "" {
@@ -157,6 +172,22 @@ func ValidateCurrency(currency string) error {
 	return nil
 }
 
+// ValidatePaymentMethod validates a payment method of a sale tender
+func ValidatePaymentMethod(method string) error {
+	validMethods := map[string]bool{
+		"cash":          true,
+		"mpesa":         true,
+		"airtel_money":  true,
+		"orange_money":  true,
+		"card":          true,
+		"bank_transfer": true,
+	}
+	if !validMethods[method] {
+		return gqlerror.Errorf("Invalid payment method. Must be 'cash', 'mpesa', 'airtel_money', 'orange_money', 'card' or 'bank_transfer'")
+	}
+	return nil
+}
+
 // ValidateDate validates a date string (RFC3339 format or YYYY-MM-DD format)
 func ValidateDate(dateStr, fieldName string) error {
 	if dateStr == "" {
@@ -179,6 +210,56 @@ func ValidateProductPrices(priceVente, priceAchat float64) error {
 	return nil
 }
 
+// ValidateBarcode validates an EAN-13 or UPC-A barcode, including its check digit
+// A UPC-A code is validated in its EAN-13 form (see utils.NormalizeBarcode)
+func ValidateBarcode(code string) error {
+	if code == "" {
+		return gqlerror.Errorf("Barcode is required")
+	}
+	if len(code) != 12 && len(code) != 13 {
+		return gqlerror.Errorf("Invalid barcode. Expected 12 digits (UPC-A) or 13 digits (EAN-13)")
+	}
+	code = utils.NormalizeBarcode(code)
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return gqlerror.Errorf("Invalid barcode. Only digits are allowed")
+		}
+	}
+	if BarcodeCheckDigit(code[:len(code)-1]) != int(code[len(code)-1]-'0') {
+		return gqlerror.Errorf("Invalid barcode check digit")
+	}
+	return nil
+}
+
+// BarcodeCheckDigit computes the GS1 check digit of the given digits (without the check digit)
+// Weights alternate 3 and 1 starting from the rightmost digit, which works for both UPC-A and EAN-13
+func BarcodeCheckDigit(digits string) int {
+	sum := 0
+	for i := 0; i < len(digits); i++ {
+		digit := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 0 {
+			sum += digit * 3
+		} else {
+			sum += digit
+		}
+	}
+	return (10 - sum%10) % 10
+}
+
+// ValidateSKU validates a stock keeping unit code (letters, digits, '-', '_' and '.')
+func ValidateSKU(sku string) error {
+	if sku == "" {
+		return gqlerror.Errorf("SKU is required")
+	}
+	if len(sku) > 64 {
+		return gqlerror.Errorf("SKU must be at most 64 characters")
+	}
+	if !skuRegex.MatchString(sku) {
+		return gqlerror.Errorf("Invalid SKU. Only letters, digits, '-', '_' and '.' are allowed")
+	}
+	return nil
+}
+
 // ValidateFactureProducts validates facture products array
 func ValidateFactureProducts(products []interface{}) error {
 	if len(products) == 0 {
@@ -225,3 +306,36 @@ func ValidateNotEmpty(slice interface{}, fieldName string) error {
 	return nil
 }
 
+
+// ValidateTwoFactorCode validates a code of the authenticator app (6 digits) or a recovery code (xxxxx-xxxxx)
+func ValidateTwoFactorCode(code string) error {
+	code = strings.TrimSpace(code)
+	if otpRegex.MatchString(code) || recoveryCodeRegex.MatchString(code) {
+		return nil
+	}
+	return gqlerror.Errorf("Code must be 6 digits or a recovery code")
+}
+
+// ValidateInvitationCode validates an invitation code (xxxx-xxxx, dash optional)
+func ValidateInvitationCode(code string) error {
+	if !invitationCodeRegex.MatchString(strings.TrimSpace(code)) {
+		return gqlerror.Errorf("Invalid invitation code")
+	}
+	return nil
+}
+
+// ValidateTimeOfDay validates a time of day (HH:MM, 24h)
+func ValidateTimeOfDay(value, fieldName string) error {
+	if !timeOfDayRegex.MatchString(value) {
+		return gqlerror.Errorf("Invalid %s format. Expected HH:MM (e.g., 17:30)", fieldName)
+	}
+	return nil
+}
+
+// ValidateIdempotencyKey validates an idempotency key (1 to 255 letters, digits, '-', '_', ':' or '.', e.g. a UUID)
+func ValidateIdempotencyKey(key string) error {
+	if !idempotencyKeyRegex.MatchString(key) {
+		return gqlerror.Errorf("Invalid idempotency key. Use 1 to 255 letters, digits, '-', '_', ':' or '.' (e.g., a UUID)")
+	}
+	return nil
+}
@@ -1,6 +1,7 @@
 package validators
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -439,6 +440,65 @@ func TestValidateProductPrices(t *testing.T) {
 	})
 }
 
+func TestValidateBarcode(t *testing.T) {
+	t.Run("Valid EAN-13", func(t *testing.T) {
+		err := ValidateBarcode("4006381333931")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Valid UPC-A", func(t *testing.T) {
+		err := ValidateBarcode("036000291452")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Wrong check digit", func(t *testing.T) {
+		err := ValidateBarcode("4006381333932")
+		assert.Error(t, err)
+	})
+
+	t.Run("Invalid length", func(t *testing.T) {
+		err := ValidateBarcode("12345678")
+		assert.Error(t, err)
+	})
+
+	t.Run("Non-digit characters", func(t *testing.T) {
+		err := ValidateBarcode("40063813339A1")
+		assert.Error(t, err)
+	})
+
+	t.Run("Empty barcode", func(t *testing.T) {
+		err := ValidateBarcode("")
+		assert.Error(t, err)
+	})
+}
+
+func TestBarcodeCheckDigit(t *testing.T) {
+	assert.Equal(t, 1, BarcodeCheckDigit("400638133393"))
+	assert.Equal(t, 2, BarcodeCheckDigit("03600029145"))
+}
+
+func TestValidateSKU(t *testing.T) {
+	t.Run("Valid SKU", func(t *testing.T) {
+		err := ValidateSKU("RIZ-25KG_v2.1")
+		assert.NoError(t, err)
+	})
+
+	t.Run("Invalid characters", func(t *testing.T) {
+		err := ValidateSKU("RIZ 25KG")
+		assert.Error(t, err)
+	})
+
+	t.Run("Too long", func(t *testing.T) {
+		err := ValidateSKU(strings.Repeat("A", 65))
+		assert.Error(t, err)
+	})
+
+	t.Run("Empty SKU", func(t *testing.T) {
+		err := ValidateSKU("")
+		assert.Error(t, err)
+	})
+}
+
 func TestValidateFactureProducts(t *testing.T) {
 	t.Run("Valid products array", func(t *testing.T) {
 		products := make([]interface{}, 5)
@@ -532,3 +592,73 @@ func TestValidateNotEmpty(t *testing.T) {
 
 
 
+
+func TestValidateTwoFactorCode(t *testing.T) {
+	t.Run("TOTP code", func(t *testing.T) {
+		assert.NoError(t, ValidateTwoFactorCode("123456"))
+		assert.NoError(t, ValidateTwoFactorCode(" 123456 "))
+	})
+
+	t.Run("Recovery code", func(t *testing.T) {
+		assert.NoError(t, ValidateTwoFactorCode("abcde-23456"))
+		assert.NoError(t, ValidateTwoFactorCode("ABCDE23456"))
+	})
+
+	t.Run("Invalid code", func(t *testing.T) {
+		assert.Error(t, ValidateTwoFactorCode(""))
+		assert.Error(t, ValidateTwoFactorCode("12345"))
+		assert.Error(t, ValidateTwoFactorCode("abcde-2345!"))
+	})
+}
+
+func TestValidateInvitationCode(t *testing.T) {
+	t.Run("Valid code", func(t *testing.T) {
+		assert.NoError(t, ValidateInvitationCode("abcd-2345"))
+		assert.NoError(t, ValidateInvitationCode(" ABCD2345 "))
+	})
+
+	t.Run("Invalid code", func(t *testing.T) {
+		assert.Error(t, ValidateInvitationCode(""))
+		assert.Error(t, ValidateInvitationCode("abc-2345"))
+		assert.Error(t, ValidateInvitationCode("abcd-234!"))
+	})
+}
+
+func TestValidateTimeOfDay(t *testing.T) {
+	t.Run("Valid time", func(t *testing.T) {
+		assert.NoError(t, ValidateTimeOfDay("00:00", "startTime"))
+		assert.NoError(t, ValidateTimeOfDay("23:59", "endTime"))
+	})
+
+	t.Run("Invalid time", func(t *testing.T) {
+		assert.Error(t, ValidateTimeOfDay("24:00", "startTime"))
+		assert.Error(t, ValidateTimeOfDay("9:30", "startTime"))
+		assert.Error(t, ValidateTimeOfDay("", "startTime"))
+	})
+}
+
+func TestValidatePaymentMethod(t *testing.T) {
+	t.Run("Valid methods", func(t *testing.T) {
+		for _, method := range []string{"cash", "mpesa", "airtel_money", "orange_money", "card", "bank_transfer"} {
+			assert.NoError(t, ValidatePaymentMethod(method))
+		}
+	})
+
+	t.Run("Invalid method", func(t *testing.T) {
+		assert.Error(t, ValidatePaymentMethod("cheque"))
+		assert.Error(t, ValidatePaymentMethod(""))
+	})
+}
+
+func TestValidateIdempotencyKey(t *testing.T) {
+	t.Run("Valid key", func(t *testing.T) {
+		assert.NoError(t, ValidateIdempotencyKey("3f2b8c1e-9d4a-4e7b-8c2d-1a5f6e7d8c9b"))
+		assert.NoError(t, ValidateIdempotencyKey("pos-12:sale.42"))
+	})
+
+	t.Run("Invalid key", func(t *testing.T) {
+		assert.Error(t, ValidateIdempotencyKey(""))
+		assert.Error(t, ValidateIdempotencyKey("key with spaces"))
+		assert.Error(t, ValidateIdempotencyKey(strings.Repeat("a", 256)))
+	})
+}